@@ -0,0 +1,293 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/internal"
+	"github.com/spf13/cobra"
+)
+
+var Flags struct {
+	Path          string
+	Sample        uint64
+	Seed          int64
+	ChunkSizes    []string
+	HumanReadable bool
+}
+
+var Command = &cobra.Command{
+	Use:   "bench vpk_or_dir_path",
+	Short: "Benchmarks compression settings on a sample of files",
+	Long: `Benchmarks compression settings on a sample of files
+
+A random sample of files is taken from a VPK or a directory (the same sample is taken each time unless --seed is changed), and the files are split into chunks and compressed with each combination of chunk size and compression policy, showing the compression ratio and the single-threaded compression and decompression speed on this machine.
+
+The LZHAM parameters are fixed by the game, so the only settings which affect compression are the chunk size (--chunk-size when packing) and which files are compressed. The policies are:
+
+  default  store files which are already compressed (e.g., bik, png) as-is
+  all      compress all files (--compress-all when packing)
+  store    store all files as-is (--store-all when packing)
+
+With any policy, chunks which don't get smaller are stored as-is.
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		Flags.Path = args[0]
+		main()
+	},
+}
+
+func init() {
+	Command.Flags().Bool("help", false, "help for "+Command.Name()) // prevent the default short help flag from being set
+	Command.Flags().BoolVarP(&Flags.HumanReadable, "human-readable", "h", false, "show sizes in human-readable form")
+	root.ByteSizeVar(Command, &Flags.Sample, "sample", 64<<20, "approximate total uncompressed size of the files to sample")
+	Command.Flags().Int64Var(&Flags.Seed, "seed", 1, "random seed for choosing the sample")
+	Command.Flags().StringSliceVar(&Flags.ChunkSizes, "chunk-size", []string{"64KiB", "128KiB", "256KiB", "512KiB", "1MiB"}, "chunk sizes to test")
+	root.FlagJSON(Command)
+	root.Command.AddCommand(Command)
+}
+
+type sample struct {
+	Name string
+	Data []byte
+}
+
+var policies = []struct {
+	Name        string
+	Compression func(name string) tf2vpk.CompressionMode
+}{
+	{"default", tf2vpk.DefaultCompression},
+	{"all", func(string) tf2vpk.CompressionMode { return tf2vpk.CompressionAuto }},
+	{"store", func(string) tf2vpk.CompressionMode { return tf2vpk.CompressionStore }},
+}
+
+func main() {
+	var chunkSizes []uint64
+	for _, x := range Flags.ChunkSizes {
+		n, err := internal.ParseBytes(x)
+		if err != nil {
+			root.Errorf("invalid --chunk-size %q: %v", x, err)
+			os.Exit(2)
+		}
+		if n == 0 || n > tf2vpk.ValvePakMaxChunkUncompressedSize {
+			root.Errorf("invalid --chunk-size %q: must be between 1 and %d bytes", x, tf2vpk.ValvePakMaxChunkUncompressedSize)
+			os.Exit(2)
+		}
+		chunkSizes = append(chunkSizes, n)
+	}
+
+	var (
+		samples []sample
+		err     error
+	)
+	if fi, serr := os.Stat(Flags.Path); serr == nil && fi.IsDir() {
+		samples, err = sampleDir(Flags.Path)
+	} else {
+		samples, err = sampleVPK(Flags.Path)
+	}
+	if err != nil {
+		root.Fatalf("%v", err)
+	}
+	if len(samples) == 0 {
+		root.Fatalf("no files to sample")
+	}
+
+	var total uint64
+	for _, s := range samples {
+		total += uint64(len(s.Data))
+	}
+	if root.Flags.JSON {
+		root.EmitJSON("sample", struct {
+			Codec string `json:"codec"`
+			Files int    `json:"files"`
+			Size  uint64 `json:"size"`
+		}{tf2vpk.CurrentCodec().Name(), len(samples), total})
+	} else {
+		fmt.Printf("codec: %s\n", tf2vpk.CurrentCodec().Name())
+		fmt.Printf("sample: %d files, %s\n\n", len(samples), size(total))
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	if !root.Flags.JSON {
+		fmt.Fprintf(tw, "chunk_size\tpolicy\tchunks\tcompressed\tratio\tcompress\tdecompress\t\n")
+	}
+	for _, cs := range chunkSizes {
+		for _, p := range policies {
+			r, err := run(samples, cs, p.Compression)
+			if err != nil {
+				root.Fatalf("chunk size %d, policy %s: %v", cs, p.Name, err)
+			}
+			if root.Flags.JSON {
+				root.EmitJSON("result", struct {
+					ChunkSize      uint64  `json:"chunk_size"`
+					Policy         string  `json:"policy"`
+					Chunks         int     `json:"chunks"`
+					Compressed     uint64  `json:"compressed_size"`
+					CompressTime   float64 `json:"compress_seconds"`
+					DecompressTime float64 `json:"decompress_seconds"`
+				}{cs, p.Name, r.Chunks, r.Compressed, r.CompressTime.Seconds(), r.DecompressTime.Seconds()})
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%.1f%%\t%s\t%s\t\n", size(cs), p.Name, r.Chunks, size(r.Compressed), float64(r.Compressed)/float64(total)*100, speed(total, r.CompressTime), speed(total, r.DecompressTime))
+		}
+	}
+	tw.Flush()
+}
+
+type result struct {
+	Chunks         int
+	Compressed     uint64
+	CompressTime   time.Duration
+	DecompressTime time.Duration
+}
+
+// run compresses and decompresses the samples the same way as Writer.Add and
+// Reader.OpenChunk. Stored chunks count towards the time since they still need
+// to be copied.
+func run(samples []sample, chunkSize uint64, compression func(string) tf2vpk.CompressionMode) (result, error) {
+	var (
+		res   result
+		codec = tf2vpk.CurrentCodec()
+		zbuf  = make([]byte, chunkSize+1024)
+		ubuf  = make([]byte, chunkSize)
+	)
+	for _, s := range samples {
+		store := compression(s.Name) == tf2vpk.CompressionStore
+		for off := uint64(0); off < uint64(len(s.Data)); off += chunkSize {
+			chunk := s.Data[off:min(off+chunkSize, uint64(len(s.Data)))]
+			res.Chunks++
+
+			start := time.Now()
+			var z []byte
+			if !store {
+				if n, err := codec.Compress(zbuf, chunk); err == nil && n < len(chunk) {
+					z = zbuf[:n]
+				}
+			}
+			if z == nil {
+				z = append(zbuf[:0], chunk...)
+			}
+			res.CompressTime += time.Since(start)
+			res.Compressed += uint64(len(z))
+
+			start = time.Now()
+			if len(z) == len(chunk) {
+				copy(ubuf, z)
+			} else if n, err := codec.Decompress(ubuf, z); err != nil {
+				return res, fmt.Errorf("decompress %q at %d: %w", s.Name, off, err)
+			} else if n != len(chunk) {
+				return res, fmt.Errorf("decompress %q at %d: got %d bytes, expected %d", s.Name, off, n, len(chunk))
+			}
+			res.DecompressTime += time.Since(start)
+		}
+	}
+	return res, nil
+}
+
+// pick chooses files with the provided sizes until the sample size is reached.
+func pick(sizes []uint64) []int {
+	idx := rand.New(rand.NewSource(Flags.Seed)).Perm(len(sizes))
+	var n uint64
+	for i, x := range idx {
+		if n >= Flags.Sample {
+			idx = idx[:i]
+			break
+		}
+		n += sizes[x]
+	}
+	slices.Sort(idx)
+	return idx
+}
+
+func sampleVPK(name string) ([]sample, error) {
+	vpk, err := root.VPK(name)
+	if err != nil {
+		return nil, err
+	}
+	r, err := root.NewReader(vpk, false)
+	if err != nil {
+		return nil, fmt.Errorf("open vpk: %w", err)
+	}
+	defer r.Close()
+
+	sizes := make([]uint64, len(r.Root.File))
+	for i, f := range r.Root.File {
+		sizes[i] = f.Size()
+	}
+	var ss []sample
+	for _, i := range pick(sizes) {
+		f := r.Root.File[i]
+		fr, err := r.OpenFileParallel(f, root.Flags.Jobs)
+		if err != nil {
+			return nil, fmt.Errorf("read vpk file %q: %w", f.Path, err)
+		}
+		buf, err := io.ReadAll(fr)
+		if err != nil {
+			return nil, fmt.Errorf("read vpk file %q: %w", f.Path, err)
+		}
+		ss = append(ss, sample{f.Path, buf})
+	}
+	return ss, nil
+}
+
+func sampleDir(name string) ([]sample, error) {
+	var (
+		paths []string
+		sizes []uint64
+	)
+	if err := filepath.WalkDir(name, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		paths = append(paths, p)
+		sizes = append(sizes, uint64(fi.Size()))
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	var ss []sample
+	for _, i := range pick(sizes) {
+		buf, err := os.ReadFile(paths[i])
+		if err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(name, paths[i])
+		if err != nil {
+			return nil, err
+		}
+		ss = append(ss, sample{strings.ToLower(filepath.ToSlash(rel)), buf})
+	}
+	return ss, nil
+}
+
+func speed(n uint64, d time.Duration) string {
+	if d <= 0 {
+		return "-"
+	}
+	return internal.FormatBytesSI(int64(float64(n)/d.Seconds())) + "/s"
+}
+
+func size(n uint64) string {
+	if Flags.HumanReadable {
+		return internal.FormatBytesSI(int64(n))
+	}
+	return fmt.Sprint(n)
+}
@@ -0,0 +1,92 @@
+package build
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/vpkutil"
+	"github.com/spf13/cobra"
+)
+
+var Flags struct {
+	VPK      tf2vpk.ValvePakRef
+	Manifest string
+	DryRun   bool
+	Verbose  bool
+	Writer   func(*tf2vpk.Writer) error
+}
+
+var Command = &cobra.Command{
+	GroupID: root.GroupVPKRepack.ID,
+	Use:     "build vpk_path manifest_path",
+	Short:   "Builds a new VPK from a JSON manifest",
+	Long: `Builds a new VPK from a JSON manifest
+
+The manifest lists the files to pack, where to read them from (relative to the manifest), and optionally their flags, whether to store them uncompressed, and which block to write them to. Directories are added recursively. See vpkutil.Manifest for the schema.
+`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		Flags.Manifest = args[1]
+		main()
+	},
+}
+
+func init() {
+	root.ArgVPK(&Flags.VPK, Command, -1, false, false, false)
+	root.FlagWriter(&Flags.Writer, Command, true)
+	Command.Flags().BoolVarP(&Flags.DryRun, "dry-run", "n", false, "read and compress the files without writing the vpk")
+	Command.Flags().BoolVarP(&Flags.Verbose, "verbose", "v", false, "display files as they are packed")
+	root.FlagJSON(Command)
+	root.Command.AddCommand(Command)
+}
+
+func main() {
+	m, err := vpkutil.ParseManifestFile(Flags.Manifest)
+	if err != nil {
+		root.Fatalf("read manifest: %v", err)
+	}
+
+	var w *tf2vpk.Writer
+	if Flags.DryRun {
+		w = tf2vpk.NewWriterFunc(func(tf2vpk.ValvePakIndex) (io.Writer, error) {
+			return io.Discard, nil
+		})
+	} else {
+		w = tf2vpk.NewWriter(Flags.VPK)
+	}
+	if err := Flags.Writer(w); err != nil {
+		root.Errorf("%v", err)
+		os.Exit(2)
+	}
+
+	if err := m.Build(w, filepath.Dir(Flags.Manifest), func(name, source string) {
+		if Flags.Verbose {
+			if root.Flags.JSON {
+				root.EmitJSON("file", struct {
+					Path   string `json:"path"`
+					Source string `json:"source"`
+				}{name, source})
+			} else {
+				fmt.Printf("%s <- %s\n", name, source)
+			}
+		}
+	}); err != nil {
+		w.Abort()
+		root.Fatalf("build vpk: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		root.Fatalf("write vpk: %v", err)
+	}
+	if root.Flags.JSON {
+		root.EmitJSON("summary", struct {
+			Files  int  `json:"files"`
+			DryRun bool `json:"dry_run"`
+		}{len(w.Root.File), Flags.DryRun})
+	} else if Flags.Verbose {
+		fmt.Printf("built vpk with %d files\n", len(w.Root.File))
+	}
+}
@@ -0,0 +1,111 @@
+package cdn
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/vpkutil"
+	"github.com/spf13/cobra"
+)
+
+var Flags struct {
+	VPK            tf2vpk.ValvePakRef
+	Output         string
+	Index          string
+	IncludeExclude func(tf2vpk.ValvePakFile) (bool, error)
+	Verbose        bool
+	Writer         func(*tf2vpk.Writer) error
+}
+
+var Command = &cobra.Command{
+	GroupID: root.GroupVPKRepack.ID,
+	Use:     "cdn vpk_path",
+	Short:   "Repacks a VPK for serving from object storage",
+	Long: `Repacks a VPK for serving from object storage
+
+The chunks are copied as-is without recompressing them into a new VPK, with the files grouped by directory and sorted by path (so the layout is stable and related files are fetched together), and each chunk aligned to --align (default 4KiB) so HTTP range requests line up with cache and storage boundaries.
+
+If --index is specified, a JSON sidecar is written with the block, byte range, and chunk extents of each file, so clients can fetch files directly without parsing the dir index.
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		main(cmd)
+	},
+}
+
+func init() {
+	root.ArgVPK(&Flags.VPK, Command, -1, false, false, false)
+	root.FlagWriter(&Flags.Writer, Command, false)
+	root.FlagIncludeExclude(&Flags.IncludeExclude, Command, true)
+	Command.Flags().StringVarP(&Flags.Output, "output", "o", "", "the vpk to write (required)")
+	Command.Flags().StringVar(&Flags.Index, "index", "", "write a json index of the file locations to this path")
+	Command.Flags().BoolVarP(&Flags.Verbose, "verbose", "v", false, "print the number of files in the output")
+	root.FlagJSON(Command)
+	root.Command.AddCommand(Command)
+}
+
+func main(cmd *cobra.Command) {
+	if Flags.Output == "" {
+		root.Errorf("no output vpk specified")
+		os.Exit(2)
+	}
+	out, err := root.VPK(Flags.Output)
+	if err != nil {
+		root.Errorf("%v", err)
+		os.Exit(2)
+	}
+
+	r, err := root.NewReader(Flags.VPK, false)
+	if err != nil {
+		root.Fatalf("open vpk: %v", err)
+	}
+	defer r.Close()
+
+	w := tf2vpk.NewWriter(out)
+	if err := Flags.Writer(w); err != nil {
+		root.Errorf("%v", err)
+		os.Exit(2)
+	}
+	if !cmd.Flags().Changed("align") {
+		w.Alignment = 4096
+	}
+	if err := vpkutil.CDNRepack(w, Flags.IncludeExclude, r); err != nil {
+		w.Abort()
+		root.Fatalf("%v", err)
+	}
+	if err := w.Close(); err != nil {
+		root.Fatalf("write vpk: %v", err)
+	}
+
+	if Flags.Index != "" {
+		x, err := vpkutil.NewCDNIndex(out, w.Root)
+		if err != nil {
+			root.Fatalf("create index: %v", err)
+		}
+		f, err := os.Create(Flags.Index)
+		if err != nil {
+			root.Fatalf("create index: %v", err)
+		}
+		bw := bufio.NewWriter(f)
+		if err := x.Encode(bw); err == nil {
+			err = bw.Flush()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			root.Fatalf("write index: %v", err)
+		}
+	}
+
+	if root.Flags.JSON {
+		root.EmitJSON("summary", struct {
+			Files int `json:"files"`
+		}{len(w.Root.File)})
+	} else if Flags.Verbose {
+		fmt.Printf("repacked %d files\n", len(w.Root.File))
+	}
+}
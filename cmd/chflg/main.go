@@ -57,12 +57,13 @@ func init() {
 	root.ArgVPK(&Flags.VPK, Command, 2, true, true, true)
 	Command.Flags().BoolVarP(&Flags.DryRun, "dry-run", "n", false, "do not write changes")
 	Command.Flags().BoolVarP(&Flags.Verbose, "verbose", "v", false, "print information about each processed file")
+	root.FlagJSON(Command)
 	root.Command.AddCommand(Command)
 }
 
 func main() {
 	var failed int
-	if err := vpkutil.UpdateDir(Flags.VPK, Flags.DryRun, func(root *tf2vpk.ValvePakDir) error {
+	if err := vpkutil.UpdateDir(Flags.VPK, Flags.DryRun, func(dir *tf2vpk.ValvePakDir) error {
 		var (
 			err          error
 			loadFlags    uint32
@@ -70,44 +71,47 @@ func main() {
 		)
 		if p, ok := strings.CutPrefix(Flags.Flags, "@"); ok {
 			var found bool
-			for _, f := range root.File {
+			for _, f := range dir.File {
 				if f.Path == strings.TrimPrefix(p, "/") {
 					if loadFlags, err = f.LoadFlags(); err != nil {
-						fmt.Fprintf(os.Stderr, "error: failed to compute load flags for reference file %q: %v\n", p, err)
-						os.Exit(1)
+						root.Fatalf("failed to compute load flags for reference file %q: %v", p, err)
 					}
 					if textureFlags, err = f.TextureFlags(); err != nil {
-						fmt.Fprintf(os.Stderr, "error: failed to compute texture flags for reference file %q: %v\n", p, err)
-						os.Exit(1)
+						root.Fatalf("failed to compute texture flags for reference file %q: %v", p, err)
 					}
 					found = true
 					break
 				}
 			}
 			if !found {
-				fmt.Fprintf(os.Stderr, "error: reference file %q does not exist in vpk\n", p)
-				os.Exit(1)
+				root.Fatalf("reference file %q does not exist in vpk", p)
 			}
 		} else {
 			loadFlags, textureFlags, err = parseFlags(Flags.Flags)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "error: invalid flags %q: %v\n", Flags.Flags, err)
-				os.Exit(1)
+				root.Fatalf("invalid flags %q: %v", Flags.Flags, err)
 			}
 		}
 
 		for _, name := range Flags.Files {
 			if err := func() error {
 				var matched bool
-				for i, f := range root.File {
+				for i, f := range dir.File {
 					if name == "/" || strings.HasPrefix(f.Path+"/", name+"/") {
 						loadFlagsOrig, _ := f.LoadFlags()
 						textureFlagsOrig, _ := f.TextureFlags()
 						for j := range f.Chunk {
-							root.File[i].Chunk[j].LoadFlags = loadFlags
-							root.File[i].Chunk[j].TextureFlags = textureFlags
+							dir.File[i].Chunk[j].LoadFlags = loadFlags
+							dir.File[i].Chunk[j].TextureFlags = textureFlags
 						}
-						if Flags.Verbose {
+						if Flags.Verbose && root.Flags.JSON {
+							root.EmitJSON("file", struct {
+								Path         string `json:"path"`
+								Changed      bool   `json:"changed"`
+								LoadFlags    uint32 `json:"load_flags"`
+								TextureFlags uint16 `json:"texture_flags"`
+							}{f.Path, loadFlagsOrig != loadFlags || textureFlagsOrig != textureFlags, loadFlags, textureFlags})
+						} else if Flags.Verbose {
 							var what string
 							if loadFlagsOrig != loadFlags || textureFlagsOrig != textureFlags {
 								what = "set flags to"
@@ -124,15 +128,14 @@ func main() {
 				}
 				return nil
 			}(); err != nil {
-				fmt.Fprintf(os.Stderr, "error: set flags for file %q: %v\n", name, err)
+				root.Errorf("set flags for file %q: %v", name, err)
 				failed++
 			}
 		}
 
 		return nil
 	}); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+		root.Fatalf("%v", err)
 	}
 	if failed != 0 {
 		os.Exit(1)
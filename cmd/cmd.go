@@ -1,23 +1,55 @@
 package cmd
 
 import (
+	"os"
+
 	"github.com/pg9182/tf2vpk/cmd/root"
 
+	_ "github.com/pg9182/tf2vpk/cmd/bench"
+	_ "github.com/pg9182/tf2vpk/cmd/build"
+	_ "github.com/pg9182/tf2vpk/cmd/cdn"
 	_ "github.com/pg9182/tf2vpk/cmd/chflg"
+	_ "github.com/pg9182/tf2vpk/cmd/cmpdir"
+	_ "github.com/pg9182/tf2vpk/cmd/compact"
+	_ "github.com/pg9182/tf2vpk/cmd/devserve"
+	_ "github.com/pg9182/tf2vpk/cmd/diff"
+	_ "github.com/pg9182/tf2vpk/cmd/dump"
+	_ "github.com/pg9182/tf2vpk/cmd/dupes"
 	_ "github.com/pg9182/tf2vpk/cmd/filter"
+	_ "github.com/pg9182/tf2vpk/cmd/fromtar"
 	_ "github.com/pg9182/tf2vpk/cmd/get"
+	_ "github.com/pg9182/tf2vpk/cmd/index"
 	_ "github.com/pg9182/tf2vpk/cmd/init"
+	_ "github.com/pg9182/tf2vpk/cmd/lint"
 	_ "github.com/pg9182/tf2vpk/cmd/list"
 	_ "github.com/pg9182/tf2vpk/cmd/lzham"
+	_ "github.com/pg9182/tf2vpk/cmd/manifest"
+	_ "github.com/pg9182/tf2vpk/cmd/merge"
+	_ "github.com/pg9182/tf2vpk/cmd/mv"
+	_ "github.com/pg9182/tf2vpk/cmd/nsmod"
+	_ "github.com/pg9182/tf2vpk/cmd/optim"
+	_ "github.com/pg9182/tf2vpk/cmd/pack"
+	_ "github.com/pg9182/tf2vpk/cmd/patch"
 	_ "github.com/pg9182/tf2vpk/cmd/rm"
+	_ "github.com/pg9182/tf2vpk/cmd/selftest"
+	_ "github.com/pg9182/tf2vpk/cmd/serve"
+	_ "github.com/pg9182/tf2vpk/cmd/sha256"
+	_ "github.com/pg9182/tf2vpk/cmd/shell"
+	_ "github.com/pg9182/tf2vpk/cmd/stat"
 	_ "github.com/pg9182/tf2vpk/cmd/tarzip"
+	_ "github.com/pg9182/tf2vpk/cmd/transcode"
 	_ "github.com/pg9182/tf2vpk/cmd/unpack"
 	_ "github.com/pg9182/tf2vpk/cmd/verify"
 	_ "github.com/pg9182/tf2vpk/cmd/version"
 	_ "github.com/pg9182/tf2vpk/cmd/vpkfiles"
 	_ "github.com/pg9182/tf2vpk/cmd/vpkflags"
+	_ "github.com/pg9182/tf2vpk/cmd/watch"
 )
 
 func Execute() {
-	root.Command.Execute()
+	root.Command.SilenceErrors = true // so they can be written as json
+	if err := root.Command.Execute(); err != nil {
+		root.Errorf("%v", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,207 @@
+package cmpdir
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/vpkutil"
+	"github.com/spf13/cobra"
+)
+
+var Flags struct {
+	VPK            tf2vpk.ValvePakRef
+	Path           string
+	Quiet          bool
+	Verbose        bool
+	IncludeExclude func(tf2vpk.ValvePakFile) (bool, error)
+}
+
+var Command = &cobra.Command{
+	GroupID: root.GroupVPKRead.ID,
+	Use:     "cmpdir vpk_path dir_path",
+	Short:   "Compares the contents of a VPK to a directory",
+	Long: `Compares the contents of a VPK to a directory
+
+Files are compared by their size and checksum against the checksums stored in the VPK directory index, so the VPK data is not read (use the verify command to check it). Files matching the vpkignore file at the root of the directory are skipped. If there isn't one, the default ignore rules are used. Each difference is printed on a line starting with:
+
+  +  the file only exists in the directory
+  -  the file only exists in the vpk
+  M  the file contents differ
+
+With --verbose, matching files are printed on lines starting with =.
+
+Exits with status 1 if there are differences.
+`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		Flags.Path = args[1]
+		main()
+	},
+}
+
+func init() {
+	root.ArgVPK(&Flags.VPK, Command, -1, false, false, false)
+	Command.Flags().BoolVarP(&Flags.Quiet, "quiet", "q", false, "only set the exit status")
+	Command.Flags().BoolVarP(&Flags.Verbose, "verbose", "v", false, "also print matching files")
+	root.FlagIncludeExclude(&Flags.IncludeExclude, Command, true)
+	root.FlagJSON(Command)
+	root.Command.AddCommand(Command)
+}
+
+func main() {
+	r, err := root.NewReader(Flags.VPK, false)
+	if err != nil {
+		root.Errorf("open vpk: %v", err)
+		os.Exit(2)
+	}
+	defer r.Close()
+
+	var vpkignore vpkutil.VPKIgnore
+	if err := vpkignore.ParseFile(filepath.Join(Flags.Path, vpkutil.VPKIgnoreFilename)); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			root.Errorf("read vpkignore: %v", err)
+			os.Exit(2)
+		}
+		vpkignore.AddDefault()
+	}
+
+	a := make(map[string]tf2vpk.ValvePakFile, len(r.Root.File))
+	for _, f := range r.Root.File {
+		a[f.Path] = f
+	}
+
+	b := map[string]int64{}
+	if err := filepath.WalkDir(Flags.Path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() && d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		rel, err := filepath.Rel(Flags.Path, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if name == vpkutil.VPKFlagsFilename || name == vpkutil.VPKIgnoreFilename || name == vpkutil.VPKMetaFilename || name == vpkutil.VPKTimesFilename {
+			return nil
+		}
+		if vpkignore.Match(name) {
+			return nil
+		}
+		fi, err := os.Stat(p) // follow symlinks (e.g., from unpack --link=sym)
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		b[name] = fi.Size()
+		return nil
+	}); err != nil {
+		root.Errorf("list directory: %v", err)
+		os.Exit(2)
+	}
+
+	var names []string
+	for name := range a {
+		names = append(names, name)
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	var differ bool
+	for _, name := range names {
+		fa, inA := a[name]
+		sb, inB := b[name]
+
+		f := fa
+		if !inA {
+			f = tf2vpk.ValvePakFile{Path: name}
+		}
+		if skip, err := Flags.IncludeExclude(f); err != nil {
+			root.Errorf("%v", err)
+			os.Exit(2)
+		} else if skip {
+			continue
+		}
+
+		var d diffJSON
+		switch {
+		case !inA:
+			d = diffJSON{"extra", name, ""}
+		case !inB:
+			d = diffJSON{"missing", name, ""}
+		case fa.Size() != uint64(sb):
+			d = diffJSON{"modified", name, fmt.Sprintf("size %d -> %d", fa.Size(), sb)}
+		default:
+			crc, err := checksum(filepath.Join(Flags.Path, filepath.FromSlash(name)))
+			if err != nil {
+				root.Errorf("%v", err)
+				os.Exit(2)
+			}
+			if crc != fa.CRC32 {
+				d = diffJSON{"modified", name, fmt.Sprintf("crc32 %08X -> %08X", fa.CRC32, crc)}
+			} else {
+				d = diffJSON{"same", name, ""}
+			}
+		}
+		if d.Kind != "same" {
+			differ = true
+		} else if !Flags.Verbose {
+			continue
+		}
+		if Flags.Quiet {
+			continue
+		}
+		if root.Flags.JSON {
+			root.EmitJSON("diff", d)
+			continue
+		}
+		switch d.Kind {
+		case "extra":
+			fmt.Printf("+ %s\n", name)
+		case "missing":
+			fmt.Printf("- %s\n", name)
+		case "modified":
+			fmt.Printf("M %s (%s)\n", name, d.Reason)
+		case "same":
+			fmt.Printf("= %s\n", name)
+		}
+	}
+	if differ {
+		os.Exit(1)
+	}
+}
+
+// diffJSON is the record written for each difference with --json.
+type diffJSON struct {
+	Kind   string `json:"kind"` // extra, missing, modified, or same
+	Path   string `json:"path"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func checksum(name string) (uint32, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	h := tf2vpk.NewCRC()
+	if _, err := io.Copy(h, f); err != nil {
+		return 0, fmt.Errorf("read %q: %w", name, err)
+	}
+	return h.Sum32(), nil
+}
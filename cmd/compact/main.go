@@ -0,0 +1,72 @@
+package compact
+
+import (
+	"fmt"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/internal"
+	"github.com/pg9182/tf2vpk/vpkutil"
+	"github.com/spf13/cobra"
+)
+
+var Flags struct {
+	VPK     tf2vpk.ValvePakRef
+	Verbose bool
+	DryRun  bool
+}
+
+var Command = &cobra.Command{
+	GroupID: root.GroupVPKRepack.ID,
+	Use:     "compact vpk_path",
+	Short:   "Removes unused data from the blocks of a VPK in-place",
+	Long: `Removes unused data from the blocks of a VPK in-place
+
+Blocks containing data which isn't referenced by any file (e.g., after deleting or replacing files) are rewritten with only the referenced chunks, and blocks which aren't referenced at all are removed. Unlike the gc command, the chunks stay in the same blocks and order, blocks without unused data are left as-is, and the VPK is updated in-place. The chunks in rewritten blocks are packed back-to-back, so padding from pack --align is removed.
+
+VPKs with dir indexes for more than one language can't be compacted, since the other dir indexes reference the same blocks.
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		main()
+	},
+}
+
+func init() {
+	root.ArgVPK(&Flags.VPK, Command, -1, false, false, false)
+	Command.Flags().BoolVarP(&Flags.DryRun, "dry-run", "n", false, "show what would be compacted without modifying anything")
+	Command.Flags().BoolVarP(&Flags.Verbose, "verbose", "v", false, "print information about each compacted block")
+	root.FlagJSON(Command)
+	root.Command.AddCommand(Command)
+}
+
+func main() {
+	blocks, err := vpkutil.Compact(Flags.VPK, Flags.DryRun)
+	if err != nil {
+		root.Fatalf("compact vpk: %v", err)
+	}
+	var reclaimed int64
+	for _, b := range blocks {
+		if root.Flags.JSON {
+			root.EmitJSON("block", struct {
+				Block   tf2vpk.ValvePakIndex `json:"block"`
+				OldSize int64                `json:"old_size"`
+				NewSize int64                `json:"new_size"`
+			}{b.Index, b.OldSize, b.NewSize})
+		} else if Flags.Verbose || Flags.DryRun {
+			fmt.Printf("compact %s: %s -> %s\n", b.Index, internal.FormatBytesSI(b.OldSize), internal.FormatBytesSI(b.NewSize))
+		}
+		reclaimed += b.Reclaimed()
+	}
+	if root.Flags.JSON {
+		root.EmitJSON("summary", struct {
+			Blocks    int   `json:"blocks"`
+			Reclaimed int64 `json:"reclaimed"`
+			DryRun    bool  `json:"dry_run"`
+		}{len(blocks), reclaimed, Flags.DryRun})
+	} else if Flags.DryRun {
+		fmt.Printf("%d blocks would be compacted, reclaiming %s\n", len(blocks), internal.FormatBytesSI(reclaimed))
+	} else {
+		fmt.Printf("%d blocks compacted, %s reclaimed\n", len(blocks), internal.FormatBytesSI(reclaimed))
+	}
+}
@@ -0,0 +1,419 @@
+package devserve
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/vpkutil"
+	"github.com/spf13/cobra"
+)
+
+var Flags struct {
+	VPK       tf2vpk.ValvePakRef
+	Path      string
+	Addr      string
+	Verbose   bool
+	Writer    func(*tf2vpk.Writer) error
+	Transform vpkutil.Transforms
+}
+
+var Command = &cobra.Command{
+	GroupID: root.GroupVPKRepack.ID,
+	Use:     "devserve vpk_path in_path",
+	Aliases: []string{"dev"},
+	Short:   "Serves a VPK built on demand from a directory over HTTP",
+	Long: `Serves a VPK built on demand from a directory over HTTP
+
+The directory is packed like the watch command, but instead of being written to disk, the VPK is kept in memory and served over HTTP at a stable path, so other tools can read it while the source files are being edited. Only the name of vpk_path is used; it does not need to exist.
+
+Whenever the dir index is requested, the directory is checked for changes (by comparing the size and modification time of each file), and if something changed, the VPK is rebuilt, only compressing new and modified files. The chunks of the other files are copied from the previous build as-is. If the vpkflags, vpkignore, or vpkmeta file changes, everything is packed again.
+
+Blocks are always served from the build of the last dir index which was requested, so a client which reads the dir index first will always get consistent blocks. Each build has a different ETag, which can be used with If-Range or If-Match to detect rebuilds in between requests. If a build fails, the error is reported, and the previous build continues to be served.
+
+Endpoints:
+  GET /                      list the files in the vpk (text)
+  GET /{prefix}{name}_dir.vpk  get the dir index, rebuilding it if needed
+  GET /{name}_{index}.vpk      get a block (supports range and conditional requests)
+`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		Flags.Path = args[1]
+		main()
+	},
+}
+
+func init() {
+	root.ArgVPK(&Flags.VPK, Command, -1, false, false, false)
+	root.FlagWriter(&Flags.Writer, Command, true)
+	root.FlagTransform(&Flags.Transform, Command)
+	Command.Flags().StringVarP(&Flags.Addr, "addr", "a", "localhost:8080", "address to listen on")
+	Command.Flags().BoolVarP(&Flags.Verbose, "verbose", "v", false, "display files as they are packed")
+	root.FlagJSON(Command)
+	root.Command.AddCommand(Command)
+}
+
+// fileState is used to detect changes to a file.
+type fileState struct {
+	Size    int64
+	ModTime int64 // unix nanoseconds
+}
+
+// dirState is the state of the input directory.
+type dirState struct {
+	Config map[string]fileState // vpkflags, vpkignore, and vpkmeta
+	Input  map[string]fileState
+}
+
+// build is an in-memory version of the VPK.
+type build struct {
+	ID    int
+	Time  time.Time
+	State dirState
+	Block map[tf2vpk.ValvePakIndex][]byte
+	R     *tf2vpk.Reader
+}
+
+type server struct {
+	mu  sync.Mutex
+	cur *build // nil if nothing has been built successfully yet
+	err error  // the last build error, if it failed and nothing has changed since
+	bad *dirState
+	n   int
+}
+
+func main() {
+	s := &server{}
+	if _, err := s.update(); err != nil {
+		root.Errorf("%v", err)
+	}
+
+	root.Infof("serving %s on http://%s/%s", Flags.Path, Flags.Addr, path.Base(filepath.ToSlash(Flags.VPK.Resolve(tf2vpk.ValvePakIndexDir))))
+	srv := &http.Server{
+		Addr:              Flags.Addr,
+		Handler:           s,
+		ReadHeaderTimeout: time.Second * 10,
+	}
+	if err := srv.ListenAndServe(); err != nil {
+		root.Fatalf("%v", err)
+	}
+}
+
+// update rebuilds the VPK if the input directory has changed, returning the
+// current build. If the build fails, the previous one is returned along with
+// the error.
+func (s *server) update() (*build, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cur, err := scan()
+	if err != nil {
+		return s.cur, err
+	}
+	if s.cur != nil && dirEqual(s.cur.State, cur) {
+		return s.cur, nil
+	}
+	if s.bad != nil && dirEqual(*s.bad, cur) {
+		return s.cur, s.err // don't retry until something changes
+	}
+
+	start := time.Now()
+	b, n, err := pack(s.cur, cur)
+	if err != nil {
+		s.bad, s.err = &cur, err
+		root.Errorf("%v", err)
+		return s.cur, err
+	}
+	s.n++
+	b.ID, b.Time = s.n, start
+	s.cur, s.bad, s.err = b, nil, nil
+	root.Infof("packed %d files (%d compressed) in %s", len(cur.Input), n, time.Since(start).Round(time.Millisecond))
+	return b, nil
+}
+
+// current returns the current build, building it if there isn't one yet.
+func (s *server) current() (*build, error) {
+	s.mu.Lock()
+	b, err := s.cur, s.err
+	s.mu.Unlock()
+	if b == nil {
+		return s.update()
+	}
+	return b, err
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	if p == "" {
+		b, err := s.update()
+		if b == nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("ETag", b.etag())
+		if err != nil {
+			fmt.Fprintf(w, "# error: %v\n", err)
+		}
+		fmt.Fprintf(w, "# build %d at %s\n", b.ID, b.Time.Format(time.RFC3339))
+		for _, i := range b.indexes() {
+			fmt.Fprintf(w, "# %s (%d bytes)\n", path.Base(filepath.ToSlash(Flags.VPK.Resolve(i))), len(b.Block[i]))
+		}
+		for _, f := range b.R.Root.File {
+			fmt.Fprintln(w, f.Path)
+		}
+		return
+	}
+
+	i, ok := blockIndex(p)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	var (
+		b   *build
+		err error
+	)
+	if i == tf2vpk.ValvePakIndexDir {
+		b, err = s.update()
+	} else {
+		b, err = s.current()
+	}
+	if b == nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	buf, ok := b.Block[i]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", b.etag())
+	http.ServeContent(w, r, p, b.Time, bytes.NewReader(buf))
+}
+
+// blockIndex gets the block index for a file name of the served VPK.
+func blockIndex(name string) (tf2vpk.ValvePakIndex, bool) {
+	n, i, err := tf2vpk.SplitName(name, Flags.VPK.Prefix)
+	if err != nil || n != Flags.VPK.Name {
+		return 0, false
+	}
+	if name != path.Base(filepath.ToSlash(Flags.VPK.Resolve(i))) {
+		return 0, false // only serve each block at one path (e.g., not _0001)
+	}
+	return i, true
+}
+
+func (b *build) etag() string {
+	return `"` + strconv.Itoa(b.ID) + "-" + strconv.FormatInt(b.Time.UnixNano(), 36) + `"`
+}
+
+// indexes returns the blocks in the build, in order.
+func (b *build) indexes() []tf2vpk.ValvePakIndex {
+	idx := make([]tf2vpk.ValvePakIndex, 0, len(b.Block))
+	for i := range b.Block {
+		idx = append(idx, i)
+	}
+	sort.Slice(idx, func(a, b int) bool {
+		return idx[a] < idx[b]
+	})
+	return idx
+}
+
+func dirEqual(a, b dirState) bool {
+	return maps.Equal(a.Config, b.Config) && maps.Equal(a.Input, b.Input)
+}
+
+// scan gets the current state of the input directory.
+func scan() (dirState, error) {
+	st := dirState{
+		Config: map[string]fileState{},
+		Input:  map[string]fileState{},
+	}
+	for _, name := range []string{vpkutil.VPKFlagsFilename, vpkutil.VPKIgnoreFilename, vpkutil.VPKMetaFilename} {
+		if fi, err := os.Stat(filepath.Join(Flags.Path, name)); err == nil {
+			st.Config[name] = fileState{fi.Size(), fi.ModTime().UnixNano()}
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return st, err
+		}
+	}
+
+	var vpkignore vpkutil.VPKIgnore
+	if err := vpkignore.ParseFile(filepath.Join(Flags.Path, vpkutil.VPKIgnoreFilename)); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return st, fmt.Errorf("read vpkignore: %w", err)
+		}
+		vpkignore.AddDefault()
+	}
+
+	if err := filepath.WalkDir(Flags.Path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() && d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		rel, err := filepath.Rel(Flags.Path, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if _, ok := st.Config[name]; ok || name == vpkutil.VPKTimesFilename || vpkignore.Match(name) {
+			return nil
+		}
+		fi, err := os.Stat(p) // follow symlinks (e.g., from unpack --link=sym)
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		st.Input[name] = fileState{fi.Size(), fi.ModTime().UnixNano()}
+		return nil
+	}); err != nil {
+		return st, fmt.Errorf("list input directory: %w", err)
+	}
+	return st, nil
+}
+
+// pack builds the VPK for cur in memory, copying the files which haven't
+// changed since prev from it. It returns the number of files which were
+// compressed.
+func pack(prev *build, cur dirState) (*build, int, error) {
+	meta, err := vpkutil.ReadPackMeta(os.DirFS(Flags.Path))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var oldFiles map[string]tf2vpk.ValvePakFile
+	if prev != nil && maps.Equal(prev.State.Config, cur.Config) {
+		oldFiles = make(map[string]tf2vpk.ValvePakFile, len(prev.R.Root.File))
+		for _, f := range prev.R.Root.File {
+			oldFiles[f.Path] = f
+		}
+	}
+
+	buf := map[tf2vpk.ValvePakIndex]*bytes.Buffer{}
+	w := tf2vpk.NewWriterFunc(func(i tf2vpk.ValvePakIndex) (io.Writer, error) {
+		b := new(bytes.Buffer)
+		buf[i] = b
+		return b, nil
+	})
+	if err := Flags.Writer(w); err != nil {
+		return nil, 0, err
+	}
+
+	names := make([]string, 0, len(cur.Input))
+	for name := range cur.Input {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// copy the unchanged files, and transform the changed ones which need it
+	// since AddFS can't
+	pm := &packMeta{PackMeta: meta, input: cur.Input, done: map[string]bool{}}
+	for _, name := range names {
+		if err := func() error {
+			if f, ok := oldFiles[name]; ok && prev.State.Input[name] == cur.Input[name] {
+				if b, err := prev.R.OpenBlockRaw(f.Index); err == nil {
+					if Flags.Verbose {
+						root.Infof("copy %s", name)
+					}
+					pm.done[name] = true
+					return w.AddRaw(f, b)
+				}
+			}
+			if !Flags.Transform.Match(name) {
+				return nil
+			}
+			if Flags.Verbose {
+				root.Infof("pack %s", name)
+			}
+			pm.done[name] = true
+			pm.packed++
+
+			f, err := os.Open(filepath.Join(Flags.Path, filepath.FromSlash(name)))
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			r, err := Flags.Transform.Apply(name, f)
+			if err != nil {
+				return err
+			}
+			load, texture := meta.Flags(name)
+			return w.Add(name, load, texture, r)
+		}(); err != nil {
+			w.Abort()
+			return nil, 0, fmt.Errorf("pack %q: %w", name, err)
+		}
+	}
+	if err := w.AddFS(os.DirFS(Flags.Path), pm); err != nil {
+		w.Abort()
+		return nil, 0, fmt.Errorf("pack: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, 0, fmt.Errorf("write vpk: %w", err)
+	}
+
+	b := &build{
+		State: cur,
+		Block: make(map[tf2vpk.ValvePakIndex][]byte, len(buf)),
+	}
+	for i, x := range buf {
+		b.Block[i] = x.Bytes()
+	}
+	r, err := tf2vpk.NewReaderFunc(func(i tf2vpk.ValvePakIndex) (io.ReaderAt, error) {
+		if x, ok := b.Block[i]; ok {
+			return bytes.NewReader(x), nil
+		}
+		return nil, fmt.Errorf("block %d: %w", i, fs.ErrNotExist)
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("read built vpk: %w", err)
+	}
+	b.R = r
+	return b, pm.packed, nil
+}
+
+// packMeta adds the remaining files in the input directory which were found by
+// scan and haven't been added yet.
+type packMeta struct {
+	*vpkutil.PackMeta
+	input  map[string]fileState
+	done   map[string]bool
+	packed int
+}
+
+func (m *packMeta) Skip(name string) bool {
+	if _, ok := m.input[name]; !ok || m.done[name] {
+		return true
+	}
+	if Flags.Verbose {
+		root.Infof("pack %s", name)
+	}
+	m.packed++
+	return false
+}
@@ -0,0 +1,164 @@
+package diff
+
+import (
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/spf13/cobra"
+)
+
+var Flags struct {
+	VPK            tf2vpk.ValvePakRef
+	Other          tf2vpk.ValvePakRef
+	Quiet          bool
+	IgnoreFlags    bool
+	IncludeExclude func(tf2vpk.ValvePakFile) (bool, error)
+}
+
+var Command = &cobra.Command{
+	GroupID: root.GroupVPKRead.ID,
+	Use:     "diff vpk_path other_vpk_path",
+	Short:   "Compares the contents of two VPKs",
+	Long: `Compares the contents of two VPKs
+
+Files are compared by their checksum, size, and flags. Each difference is printed on a line starting with:
+
+  +  the file only exists in the other vpk
+  -  the file only exists in the first vpk
+  M  the file contents differ
+  F  the file flags differ
+
+Exits with status 1 if there are differences.
+`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if vpk, err := root.VPK(args[1]); err != nil {
+			root.Errorf("%v", err)
+			os.Exit(2)
+		} else {
+			Flags.Other = vpk
+		}
+		main()
+	},
+}
+
+func init() {
+	root.ArgVPK(&Flags.VPK, Command, -1, false, false, false)
+	Command.Flags().BoolVarP(&Flags.Quiet, "quiet", "q", false, "only set the exit status")
+	Command.Flags().BoolVar(&Flags.IgnoreFlags, "ignore-flags", false, "do not compare load and texture flags")
+	root.FlagIncludeExclude(&Flags.IncludeExclude, Command, true)
+	root.FlagJSON(Command)
+	root.Command.AddCommand(Command)
+}
+
+func main() {
+	a, err := readDir(Flags.VPK)
+	if err != nil {
+		root.Errorf("%v", err)
+		os.Exit(2)
+	}
+	b, err := readDir(Flags.Other)
+	if err != nil {
+		root.Errorf("%v", err)
+		os.Exit(2)
+	}
+
+	var names []string
+	for name := range a {
+		names = append(names, name)
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	var differ bool
+	for _, name := range names {
+		fa, inA := a[name]
+		fb, inB := b[name]
+
+		f := fa
+		if !inA {
+			f = fb
+		}
+		if skip, err := Flags.IncludeExclude(f); err != nil {
+			root.Errorf("%v", err)
+			os.Exit(2)
+		} else if skip {
+			continue
+		}
+
+		var d diffJSON
+		switch {
+		case !inA:
+			d = diffJSON{"added", name, ""}
+		case !inB:
+			d = diffJSON{"removed", name, ""}
+		case fa.CRC32 != fb.CRC32 || fa.Size() != fb.Size():
+			d = diffJSON{"modified", name, fmt.Sprintf("crc32 %08X -> %08X, size %d -> %d", fa.CRC32, fb.CRC32, fa.Size(), fb.Size())}
+		case !Flags.IgnoreFlags:
+			la, _ := fa.LoadFlags()
+			lb, _ := fb.LoadFlags()
+			ta, _ := fa.TextureFlags()
+			tb, _ := fb.TextureFlags()
+			if la != lb || ta != tb {
+				d = diffJSON{"flags", name, fmt.Sprintf("0x%08X:0x%04X -> 0x%08X:0x%04X", la, ta, lb, tb)}
+			}
+		}
+		if d.Kind == "" {
+			continue
+		}
+		differ = true
+		if Flags.Quiet {
+			continue
+		}
+		if root.Flags.JSON {
+			root.EmitJSON("diff", d)
+			continue
+		}
+		switch d.Kind {
+		case "added":
+			fmt.Printf("+ %s\n", name)
+		case "removed":
+			fmt.Printf("- %s\n", name)
+		case "modified":
+			fmt.Printf("M %s (%s)\n", name, d.Reason)
+		case "flags":
+			fmt.Printf("F %s (%s)\n", name, d.Reason)
+		}
+	}
+	if differ {
+		os.Exit(1)
+	}
+}
+
+// diffJSON is the record written for each difference with --json.
+type diffJSON struct {
+	Kind   string `json:"kind"` // added, removed, modified, or flags
+	Path   string `json:"path"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func readDir(vpk tf2vpk.ValvePakRef) (map[string]tf2vpk.ValvePakFile, error) {
+	f, err := os.Open(vpk.Resolve(tf2vpk.ValvePakIndexDir))
+	if err != nil {
+		return nil, fmt.Errorf("open vpk dir: %w", err)
+	}
+	defer f.Close()
+
+	var root tf2vpk.ValvePakDir
+	if err := root.Deserialize(f); err != nil {
+		return nil, fmt.Errorf("read vpk dir %q: %w", f.Name(), err)
+	}
+
+	m := make(map[string]tf2vpk.ValvePakFile, len(root.File))
+	for _, f := range root.File {
+		m[f.Path] = f
+	}
+	return m, nil
+}
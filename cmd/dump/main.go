@@ -0,0 +1,57 @@
+package dump
+
+import (
+	"os"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/vpkutil"
+	"github.com/spf13/cobra"
+)
+
+var Flags struct {
+	VPK     tf2vpk.ValvePakRef
+	Compact bool
+}
+
+var Command = &cobra.Command{
+	GroupID: root.GroupVPKRead.ID,
+	Use:     "dump vpk_path",
+	Short:   "Dumps the dir index of a VPK as JSON",
+	Long: `Dumps the dir index of a VPK as JSON
+
+The output contains every file entry with its chunks, offsets, sizes, and flags. See vpkutil.JSONDir for the schema.
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		main()
+	},
+}
+
+func init() {
+	root.ArgVPK(&Flags.VPK, Command, -1, false, false, false)
+	Command.Flags().BoolVarP(&Flags.Compact, "compact", "c", false, "do not indent the output")
+	root.FlagJSON(Command)
+	root.Command.AddCommand(Command)
+}
+
+func main() {
+	f, err := os.Open(Flags.VPK.Resolve(tf2vpk.ValvePakIndexDir))
+	if err != nil {
+		root.Fatalf("open vpk dir: %v", err)
+	}
+	defer f.Close()
+
+	var d tf2vpk.ValvePakDir
+	if err := d.Deserialize(f); err != nil {
+		root.Fatalf("read vpk dir: %v", err)
+	}
+
+	if root.Flags.JSON {
+		root.EmitJSON("dir", vpkutil.NewJSONDir(d))
+		return
+	}
+	if err := vpkutil.DumpJSON(os.Stdout, d, !Flags.Compact); err != nil {
+		root.Fatalf("write json: %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+package dupes
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/internal"
+	"github.com/pg9182/tf2vpk/vpkutil"
+	"github.com/spf13/cobra"
+)
+
+var Flags struct {
+	Paths          []string
+	HumanReadable  bool
+	Partial        bool
+	IncludeExclude func(tf2vpk.ValvePakFile) (bool, error)
+}
+
+var Command = &cobra.Command{
+	GroupID: root.GroupVPKRead.ID,
+	Use:     "dupes vpk_path|dir...",
+	Short:   "Reports files and chunks with duplicate contents",
+	Long: `Reports files and chunks with duplicate contents
+
+Each group of files with identical contents is printed as a line containing the wasted bytes, the size of each file, the number of copies stored in the VPK, and the SHA-256, followed by an indented line for each path. Groups where every file already shares the same chunks have no wasted bytes.
+
+With --partial, files which are not identical to another file, but share some chunk contents with another one are also listed.
+
+The totals include the bytes which would be saved by deduplicating identical chunks (which is what pack and the other commands writing VPKs do).
+
+If more than one VPK or a directory is specified (or none if --vpk-dir or --game is set), the content duplicated between different VPKs is reported instead. All languages of the VPKs in directories are read, and content shared between languages of the same VPK is counted as already deduplicated. Each group lists the number of VPKs storing a copy, and each path is prefixed by the VPK name. The size of each VPK and the amount of it also stored in other VPKs are printed after the groups. Since a dir index can only reference its own blocks, this content can't be deduplicated without moving files between VPKs.
+`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 && root.Flags.VPKDir == "" && root.Flags.Game == "" {
+			return fmt.Errorf("at least one vpk path or directory is required")
+		}
+		return nil
+	},
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return root.ArgVPKNameCompletions() // directories are completed along with vpk paths
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		Flags.Paths = args
+		main()
+	},
+}
+
+func init() {
+	Command.Flags().Bool("help", false, "help for "+Command.Name()) // prevent the default short help flag from being set
+	Command.Flags().BoolVarP(&Flags.HumanReadable, "human-readable", "h", false, "show sizes in human-readable form")
+	Command.Flags().BoolVarP(&Flags.Partial, "partial", "p", false, "also list files sharing some chunks with other files (single vpk only)")
+	root.FlagIncludeExclude(&Flags.IncludeExclude, Command, true)
+	root.FlagJSON(Command)
+	root.Command.AddCommand(Command)
+}
+
+func main() {
+	if len(Flags.Paths) == 1 {
+		if fi, err := os.Stat(Flags.Paths[0]); err != nil || !fi.IsDir() {
+			vpk, err := root.VPK(Flags.Paths[0])
+			if err != nil {
+				root.Errorf("%v", err)
+				os.Exit(2)
+			}
+			single(vpk)
+			return
+		}
+	}
+	if Flags.Partial {
+		root.Errorf("--partial is only supported for a single vpk")
+		os.Exit(2)
+	}
+
+	var refs []tf2vpk.ValvePakRef
+	if len(Flags.Paths) == 0 {
+		dir := root.Flags.VPKDir
+		if dir == "" {
+			var err error
+			if dir, err = root.GameVPKDir(); err != nil {
+				root.Fatalf("%v", err)
+			}
+		}
+		Flags.Paths = []string{dir}
+	}
+	for _, p := range Flags.Paths {
+		if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+			sets, err := tf2vpk.ScanValvePakSets(p)
+			if err != nil {
+				root.Fatalf("scan %q: %v", p, err)
+			}
+			for _, s := range sets {
+				for _, lang := range s.Languages {
+					ref, _ := s.Ref(lang)
+					refs = append(refs, ref)
+				}
+			}
+			continue
+		}
+		ref, err := root.VPK(p)
+		if err != nil {
+			root.Errorf("%v", err)
+			os.Exit(2)
+		}
+		refs = append(refs, ref)
+	}
+	if len(refs) == 0 {
+		root.Fatalf("no vpks found")
+	}
+	set(refs)
+}
+
+func single(vpk tf2vpk.ValvePakRef) {
+	r, err := root.NewReader(vpk, false)
+	if err != nil {
+		root.Fatalf("open vpk: %v", err)
+	}
+	defer r.Close()
+
+	rep, err := vpkutil.FindDuplicates(r, Flags.IncludeExclude)
+	if err != nil {
+		root.Fatalf("%v", err)
+	}
+
+	if root.Flags.JSON {
+		for _, g := range rep.Groups {
+			root.EmitJSON("group", groupJSON{fmt.Sprintf("%x", g.SHA256), g.Size, g.Stored, g.Wasted(), g.Paths, nil})
+		}
+		if Flags.Partial {
+			for _, p := range rep.Partial {
+				root.EmitJSON("partial", struct {
+					Path   string `json:"path"`
+					Size   uint64 `json:"size"`
+					Shared uint64 `json:"shared"`
+				}{p.Path, p.Size, p.Shared})
+			}
+		}
+		root.EmitJSON("summary", struct {
+			Groups       int    `json:"groups"`
+			FileWasted   uint64 `json:"file_wasted"`
+			Chunks       int    `json:"chunks"`
+			ChunkWasted  uint64 `json:"chunk_wasted"`
+			ChunkDeduped uint64 `json:"chunk_deduped"`
+		}{len(rep.Groups), rep.FileWasted, rep.Chunks, rep.ChunkWasted, rep.ChunkDeduped})
+		return
+	}
+
+	for _, g := range rep.Groups {
+		fmt.Printf("%s %s x%d %x\n", size(g.Wasted()), size(g.Size), g.Stored, g.SHA256)
+		for _, p := range g.Paths {
+			fmt.Printf("\t%s\n", p)
+		}
+	}
+	if Flags.Partial {
+		for _, p := range rep.Partial {
+			fmt.Printf("partial %s/%s %s\n", size(p.Shared), size(p.Size), p.Path)
+		}
+	}
+	fmt.Printf("%d groups of identical files (%s wasted), %d chunks stored more than once (%s wasted, %s already deduplicated)\n", len(rep.Groups), size(rep.FileWasted), rep.Chunks, size(rep.ChunkWasted), size(rep.ChunkDeduped))
+}
+
+func set(refs []tf2vpk.ValvePakRef) {
+	progress := root.Progress("dupes", 0, 0)
+
+	var last int64
+	rep, err := vpkutil.FindSetDuplicates(refs, Flags.IncludeExclude, func(done, _ int64, _ string) {
+		progress.AddBytes(done - last)
+		last = done
+	})
+	if err != nil {
+		root.Fatalf("%v", err)
+	}
+	progress.Done()
+
+	if root.Flags.JSON {
+		for _, g := range rep.Files {
+			var files []groupFileJSON
+			for _, f := range g.Files {
+				files = append(files, groupFileJSON{f.VPK, f.Path})
+			}
+			root.EmitJSON("group", groupJSON{fmt.Sprintf("%x", g.SHA256), g.Size, g.Stored, g.Wasted(), nil, files})
+		}
+		for _, v := range rep.VPKs {
+			root.EmitJSON("vpk", struct {
+				Name      string   `json:"name"`
+				Languages []string `json:"languages"`
+				Size      uint64   `json:"size"`
+				Shared    uint64   `json:"shared"`
+			}{v.Name, v.Languages, v.Size, v.Shared})
+		}
+		root.EmitJSON("summary", struct {
+			VPKs        int    `json:"vpks"`
+			Groups      int    `json:"groups"`
+			FileWasted  uint64 `json:"file_wasted"`
+			Chunks      int    `json:"chunks"`
+			ChunkWasted uint64 `json:"chunk_wasted"`
+			Shared      uint64 `json:"shared"`
+		}{len(rep.VPKs), len(rep.Files), rep.FileWasted, rep.Chunks, rep.ChunkWasted, rep.Shared})
+		return
+	}
+
+	for _, g := range rep.Files {
+		fmt.Printf("%s %s x%d %x\n", size(g.Wasted()), size(g.Size), g.Stored, g.SHA256)
+		for _, f := range g.Files {
+			fmt.Printf("\t%s: %s\n", f.VPK, f.Path)
+		}
+	}
+	for _, v := range rep.VPKs {
+		fmt.Printf("vpk %s/%s %s (%s)\n", size(v.Shared), size(v.Size), v.Name, strings.Join(v.Languages, ", "))
+	}
+	fmt.Printf("%d vpks, %d groups of identical files (%s wasted), %d chunks stored by more than one vpk (%s wasted), %s shared between languages\n", len(rep.VPKs), len(rep.Files), size(rep.FileWasted), rep.Chunks, size(rep.ChunkWasted), size(rep.Shared))
+}
+
+// groupJSON is the record written for each group of identical files with
+// --json. Paths is set for a single vpk, and Files for multiple ones.
+type groupJSON struct {
+	SHA256 string          `json:"sha256"`
+	Size   uint64          `json:"size"`
+	Stored int             `json:"stored"`
+	Wasted uint64          `json:"wasted"`
+	Paths  []string        `json:"paths,omitempty"`
+	Files  []groupFileJSON `json:"files,omitempty"`
+}
+
+type groupFileJSON struct {
+	VPK  string `json:"vpk"`
+	Path string `json:"path"`
+}
+
+func size(n uint64) string {
+	if Flags.HumanReadable {
+		return internal.FormatBytesSI(int64(n))
+	}
+	return fmt.Sprint(n)
+}
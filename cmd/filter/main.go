@@ -2,6 +2,7 @@ package filter
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"slices"
 
@@ -14,8 +15,10 @@ import (
 var Flags struct {
 	VPK            tf2vpk.ValvePakRef
 	IncludeExclude func(tf2vpk.ValvePakFile) (bool, error)
+	Output         string
 	Verbose        bool
 	DryRun         bool
+	Writer         func(*tf2vpk.Writer) error
 }
 
 var Command = &cobra.Command{
@@ -24,7 +27,9 @@ var Command = &cobra.Command{
 	Short:   "Filters files out of a VPK",
 	Long: `Filters files out of a VPK
 
-Does not remove the unused chunks; use the gc command to do that after filtering.
+By default, the VPK is updated in-place. This does not remove the unused chunks; use the compact or gc commands to do that after filtering.
+
+If --output is specified, a new VPK containing only the remaining files is written instead, copying the chunks as-is without recompressing them.
 `,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
@@ -35,22 +40,29 @@ Does not remove the unused chunks; use the gc command to do that after filtering
 func init() {
 	root.ArgVPK(&Flags.VPK, Command, 2, true, true, true)
 	root.FlagIncludeExclude(&Flags.IncludeExclude, Command, true)
+	root.FlagWriter(&Flags.Writer, Command, false)
+	Command.Flags().StringVarP(&Flags.Output, "output", "o", "", "write the remaining files to a new vpk instead of updating it in-place")
 	Command.Flags().BoolVarP(&Flags.DryRun, "dry-run", "n", false, "do not write changes")
 	Command.Flags().BoolVarP(&Flags.Verbose, "verbose", "v", false, "print information about each filtered file")
+	root.FlagJSON(Command)
 	root.Command.AddCommand(Command)
 }
 
 func main() {
-	var failed int
-	if err := vpkutil.UpdateDir(Flags.VPK, Flags.DryRun, func(root *tf2vpk.ValvePakDir) error {
+	if Flags.Output != "" {
+		mainOutput()
+		return
+	}
+
+	if err := vpkutil.UpdateDir(Flags.VPK, Flags.DryRun, func(dir *tf2vpk.ValvePakDir) error {
 		var errs []error
-		root.File = slices.DeleteFunc(root.File, func(f tf2vpk.ValvePakFile) bool {
+		dir.File = slices.DeleteFunc(dir.File, func(f tf2vpk.ValvePakFile) bool {
 			skip, err := Flags.IncludeExclude(f)
 			if err != nil {
 				errs = append(errs, err)
 			}
 			if skip && Flags.Verbose {
-				fmt.Printf("filtered %s\n", f.Path)
+				filtered(f.Path)
 			}
 			return skip
 		})
@@ -59,10 +71,57 @@ func main() {
 		}
 		return nil
 	}); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+		root.Fatalf("%v", err)
 	}
-	if failed != 0 {
-		os.Exit(1)
+}
+
+func mainOutput() {
+	out, err := root.VPK(Flags.Output)
+	if err != nil {
+		root.Errorf("%v", err)
+		os.Exit(2)
+	}
+
+	r, err := root.NewReader(Flags.VPK, false)
+	if err != nil {
+		root.Fatalf("open vpk: %v", err)
+	}
+	defer r.Close()
+
+	var w *tf2vpk.Writer
+	if Flags.DryRun {
+		w = tf2vpk.NewWriterFunc(func(tf2vpk.ValvePakIndex) (io.Writer, error) {
+			return io.Discard, nil
+		})
+	} else {
+		w = tf2vpk.NewWriter(out)
+	}
+	if err := Flags.Writer(w); err != nil {
+		root.Errorf("%v", err)
+		os.Exit(2)
+	}
+	if err := vpkutil.Copy(w, func(f tf2vpk.ValvePakFile) (bool, error) {
+		skip, err := Flags.IncludeExclude(f)
+		if skip && Flags.Verbose {
+			filtered(f.Path)
+		}
+		return skip, err
+	}, r); err != nil {
+		w.Abort()
+		root.Fatalf("%v", err)
+	}
+	if err := w.Close(); err != nil {
+		root.Fatalf("write vpk: %v", err)
+	}
+}
+
+// filtered prints a filtered file.
+func filtered(name string) {
+	if root.Flags.JSON {
+		root.EmitJSON("filtered", struct {
+			Path string `json:"path"`
+		}{name})
+		return
 	}
+	fmt.Printf("filtered %s\n", name)
 }
@@ -0,0 +1,167 @@
+package filter
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/spf13/cobra"
+)
+
+var testFiles = map[string]string{
+	"a.txt":     strings.Repeat("a", 1000),
+	"b/c.txt":   strings.Repeat("c", 1000),
+	"b/d/e.txt": strings.Repeat("e", 1000),
+	"f.txt":     strings.Repeat("f", 1000),
+}
+
+// writeTestVPK writes a VPK containing testFiles.
+func writeTestVPK(t *testing.T, vpk tf2vpk.ValvePakRef) {
+	t.Helper()
+	w := tf2vpk.NewWriter(vpk)
+	for name, data := range testFiles {
+		if err := w.Add(name, uint32(tf2vpk.ValvePakLoadVisible|tf2vpk.ValvePakLoadCache), 0, strings.NewReader(data)); err != nil {
+			t.Fatalf("add %q: %v", name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+}
+
+// runFilter runs the command on vpk with the provided include/exclude flags.
+func runFilter(t *testing.T, vpk tf2vpk.ValvePakRef, output string, dryRun bool, args ...string) {
+	t.Helper()
+	cmd := &cobra.Command{}
+	root.FlagIncludeExclude(&Flags.IncludeExclude, cmd, true)
+	if err := cmd.ParseFlags(args); err != nil {
+		t.Fatalf("parse flags: %v", err)
+	}
+	Flags.VPK = vpk
+	Flags.Output = output
+	Flags.DryRun = dryRun
+	Flags.Writer = func(*tf2vpk.Writer) error { return nil }
+	main()
+}
+
+// checkFiles checks that vpk contains exactly the files from testFiles in exp.
+func checkFiles(t *testing.T, vpk tf2vpk.ValvePakRef, exp ...string) *tf2vpk.Reader {
+	t.Helper()
+	r, err := tf2vpk.NewReader(vpk)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+
+	var act []string
+	for _, f := range r.Root.File {
+		act = append(act, f.Path)
+		if buf, err := fs.ReadFile(r, f.Path); err != nil {
+			t.Errorf("read %q: %v", f.Path, err)
+		} else if string(buf) != testFiles[f.Path] {
+			t.Errorf("read %q: incorrect contents", f.Path)
+		}
+	}
+	slices.Sort(act)
+	slices.Sort(exp)
+	if !slices.Equal(act, exp) {
+		t.Errorf("expected files %q, got %q", exp, act)
+	}
+	return r
+}
+
+// readFiles reads the files in dir.
+func readFiles(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	m := map[string][]byte{}
+	es, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range es {
+		buf, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m[e.Name()] = buf
+	}
+	return m
+}
+
+func TestFilterOutput(t *testing.T) {
+	var (
+		dir = t.TempDir()
+		in  = tf2vpk.ValvePakRef{Path: dir, Prefix: "english", Name: "in"}
+		out = tf2vpk.ValvePakRef{Path: dir, Prefix: "english", Name: "out"}
+	)
+	writeTestVPK(t, in)
+	before := readFiles(t, dir)
+
+	runFilter(t, in, out.Resolve(tf2vpk.ValvePakIndexDir), true, "--exclude", "b")
+	if after := readFiles(t, dir); len(after) != len(before) {
+		t.Fatalf("dry run wrote files")
+	}
+
+	runFilter(t, in, out.Resolve(tf2vpk.ValvePakIndexDir), false, "--exclude", "b")
+	for name, buf := range readFiles(t, dir) {
+		if x, ok := before[name]; ok && !bytes.Equal(x, buf) {
+			t.Errorf("input file %q modified", name)
+		}
+	}
+	ir := checkFiles(t, in, "a.txt", "b/c.txt", "b/d/e.txt", "f.txt")
+	or := checkFiles(t, out, "a.txt", "f.txt")
+
+	// the chunks are copied as-is
+	for _, f := range or.Root.File {
+		i := slices.IndexFunc(ir.Root.File, func(x tf2vpk.ValvePakFile) bool {
+			return x.Path == f.Path
+		})
+		if exp, act := readRaw(t, ir, ir.Root.File[i]), readRaw(t, or, f); !bytes.Equal(exp, act) {
+			t.Errorf("%q: raw chunks differ", f.Path)
+		}
+	}
+}
+
+func TestFilterInPlace(t *testing.T) {
+	vpk := tf2vpk.ValvePakRef{Path: t.TempDir(), Prefix: "english", Name: "test"}
+	writeTestVPK(t, vpk)
+	before := readFiles(t, vpk.Path)
+
+	runFilter(t, vpk, "", true, "--include", "b", "--exclude", "b/d")
+	for name, buf := range readFiles(t, vpk.Path) {
+		if !bytes.Equal(before[name], buf) {
+			t.Errorf("dry run modified %q", name)
+		}
+	}
+
+	runFilter(t, vpk, "", false, "--include", "b", "--exclude", "b/d")
+	checkFiles(t, vpk, "b/c.txt")
+
+	// the chunks aren't removed
+	if block := filepath.Base(vpk.Resolve(0)); !bytes.Equal(before[block], readFiles(t, vpk.Path)[block]) {
+		t.Errorf("block modified")
+	}
+}
+
+// readRaw reads the raw chunks of f.
+func readRaw(t *testing.T, r *tf2vpk.Reader, f tf2vpk.ValvePakFile) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, c := range f.Chunk {
+		cr, err := r.OpenChunkRaw(f, c)
+		if err != nil {
+			t.Fatalf("%q: read chunk: %v", f.Path, err)
+		}
+		if _, err := io.Copy(&buf, cr); err != nil {
+			t.Fatalf("%q: read chunk: %v", f.Path, err)
+		}
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,167 @@
+package fromtar
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/internal"
+	"github.com/pg9182/tf2vpk/vpkutil"
+	"github.com/spf13/cobra"
+)
+
+var Flags struct {
+	VPK           tf2vpk.ValvePakRef
+	Input         string
+	VPKFlags      string
+	VPKIgnore     string
+	Deterministic bool
+	IgnorePAX     bool
+	Verbose       bool
+	Writer        func(*tf2vpk.Writer) error
+}
+
+var Command = &cobra.Command{
+	GroupID: root.GroupVPKRepack.ID,
+	Use:     "fromtar vpk_path",
+	Aliases: []string{"tar2vpk"},
+	Short:   "Packs a tar archive into a new VPK",
+	Long: `Packs a tar archive into a new VPK
+
+The archive is read as a stream, so it can be piped directly from another command (e.g., git archive). Only regular files are packed. Empty files are skipped with a warning, since VPKs can't contain them.
+
+If the archive was created by the tar command, the flags and chunk sizes stored in its PAX records are used instead of the vpkflags file, so the files are packed the same way as in the original VPK. Use --ignore-pax to disable this.
+
+If no vpkflags file is specified, all files get the default flags. If no vpkignore file is specified, the default rules are used.
+
+With --deterministic, the output only depends on the files and their contents, not their order in the archive. This requires spooling the compressed data to a temporary file.
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		main()
+	},
+}
+
+func init() {
+	root.ArgVPK(&Flags.VPK, Command, -1, false, false, false)
+	root.FlagWriter(&Flags.Writer, Command, true)
+	Command.Flags().StringVarP(&Flags.Input, "input", "i", "-", "read the tar archive from a file")
+	Command.Flags().StringVar(&Flags.VPKFlags, "vpkflags", "", "read flags from the provided vpkflags file")
+	Command.Flags().StringVar(&Flags.VPKIgnore, "vpkignore", "", "read ignore rules from the provided vpkignore file")
+	Command.Flags().BoolVar(&Flags.Deterministic, "deterministic", false, "lay out the output independently of the archive order")
+	Command.Flags().BoolVar(&Flags.IgnorePAX, "ignore-pax", false, "ignore the vpk metadata stored in pax records by the tar command")
+	Command.Flags().BoolVarP(&Flags.Verbose, "verbose", "v", false, "display files as they are packed")
+	root.FlagJSON(Command)
+	root.Command.AddCommand(Command)
+}
+
+func main() {
+	var vpkflags vpkutil.VPKFlags
+	if Flags.VPKFlags != "" {
+		if err := vpkflags.ParseFile(Flags.VPKFlags); err != nil {
+			root.Fatalf("read vpkflags: %v", err)
+		}
+	} else {
+		vpkflags.AddDefault()
+	}
+
+	var vpkignore vpkutil.VPKIgnore
+	if Flags.VPKIgnore != "" {
+		if err := vpkignore.ParseFile(Flags.VPKIgnore); err != nil {
+			root.Fatalf("read vpkignore: %v", err)
+		}
+	} else {
+		vpkignore.AddDefault()
+	}
+
+	var r io.Reader
+	switch Flags.Input {
+	case "":
+		root.Fatalf("no input file specified")
+	case "-":
+		r = os.Stdin
+	default:
+		f, err := os.Open(Flags.Input)
+		if err != nil {
+			root.Fatalf("open input file: %v", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	w := tf2vpk.NewWriter(Flags.VPK)
+	if err := Flags.Writer(w); err != nil {
+		root.Errorf("%v", err)
+		os.Exit(2)
+	}
+	w.Deterministic = Flags.Deterministic
+
+	progress := root.Progress("pack", 0, 0)
+
+	var files, total, last int64
+	if err := vpkutil.AddTar(w, tar.NewReader(r), &vpkutil.PackMeta{
+		VPKFlags:  vpkflags,
+		VPKIgnore: vpkignore,
+	}, !Flags.IgnorePAX, func(name string, size int64, skip bool) {
+		if skip {
+			if size == 0 && !vpkignore.Match(name) && !vpkutil.IsPackMetaFile(name) {
+				root.Warnf("skipping %q: empty files are not supported", name)
+				return
+			}
+			if Flags.Verbose {
+				verbose(name, size, true)
+			}
+			return
+		}
+		if Flags.Verbose {
+			verbose(name, size, false)
+		}
+		if files != 0 {
+			progress.AddFiles(1)
+		}
+		files++
+		total += size
+	}, func(done, _ int64, _ string) {
+		progress.AddBytes(done - last)
+		last = done
+	}); err != nil {
+		w.Abort()
+		root.Fatalf("%v", err)
+	}
+
+	if err := w.Close(); err != nil {
+		root.Fatalf("write vpk: %v", err)
+	}
+	if files != 0 {
+		progress.AddFiles(1)
+	}
+	progress.Done()
+	if root.Flags.JSON {
+		root.EmitJSON("summary", struct {
+			Files int   `json:"files"`
+			Size  int64 `json:"size"`
+		}{len(w.Root.File), total})
+	} else if Flags.Verbose {
+		fmt.Fprintf(os.Stderr, "packed %d files (%s)\n", len(w.Root.File), internal.FormatBytesSI(total))
+	}
+}
+
+// verbose prints a file read from the tar.
+func verbose(name string, size int64, ignored bool) {
+	if root.Flags.JSON {
+		root.EmitJSON("file", struct {
+			Path    string `json:"path"`
+			Size    int64  `json:"size"`
+			Ignored bool   `json:"ignored"`
+		}{name, size, ignored})
+		return
+	}
+	if ignored {
+		fmt.Fprintf(os.Stderr, "%s (ignored)\n", name)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s (%s)\n", name, internal.FormatBytesSI(size))
+	}
+}
@@ -1,19 +1,20 @@
 package get
 
 import (
-	"fmt"
 	"io"
 	"io/fs"
 	"os"
 
 	"github.com/pg9182/tf2vpk"
 	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/vpkutil"
 	"github.com/spf13/cobra"
 )
 
 var Flags struct {
-	VPK   tf2vpk.ValvePakRef
-	Files []string
+	VPK       tf2vpk.ValvePakRef
+	Files     []string
+	FileCache func() (*vpkutil.FileCache, error)
 }
 
 var Command = &cobra.Command{
@@ -30,14 +31,19 @@ var Command = &cobra.Command{
 
 func init() {
 	root.ArgVPK(&Flags.VPK, Command, 1, true, false, true)
+	root.FlagFileCache(&Flags.FileCache, Command)
 	root.Command.AddCommand(Command)
 }
 
 func main() {
-	r, err := tf2vpk.NewReader(Flags.VPK)
+	cache, err := Flags.FileCache()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: open vpk: %v\n", err)
-		os.Exit(1)
+		root.Fatalf("%v", err)
+	}
+
+	r, err := root.NewReader(Flags.VPK, false)
+	if err != nil {
+		root.Fatalf("open vpk: %v", err)
 	}
 
 	var failed int
@@ -45,7 +51,17 @@ func main() {
 		if err := func() error {
 			for _, f := range r.Root.File {
 				if f.Path == name {
-					r, err := r.OpenFileParallel(f, root.Flags.Threads)
+					if cache != nil {
+						x, err := cache.Open(r, Flags.VPK, f)
+						if err != nil {
+							return err
+						}
+						defer x.Close()
+
+						_, err = io.Copy(os.Stdout, x)
+						return err
+					}
+					r, err := r.OpenFileParallel(f, root.Flags.Jobs)
 					if err != nil {
 						return err
 					}
@@ -57,7 +73,7 @@ func main() {
 			}
 			return fs.ErrNotExist
 		}(); err != nil {
-			fmt.Fprintf(os.Stderr, "error: read file %q: %v\n", name, err)
+			root.Errorf("read file %q: %v", name, err)
 			failed++
 		}
 	}
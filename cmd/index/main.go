@@ -0,0 +1,340 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/internal"
+	"github.com/pg9182/tf2vpk/vpkutil"
+	"github.com/spf13/cobra"
+)
+
+var Flags struct {
+	Dir              string
+	HumanReadable    bool
+	IgnoreCase       bool
+	FilesWithMatches bool
+}
+
+var Command = &cobra.Command{
+	GroupID: root.GroupVPKRead.ID,
+	Use:     "index",
+	Short:   "Searches the files in all VPKs in a directory using a persistent index",
+	Long: `Searches the files in all VPKs in a directory using a persistent index
+
+The paths, sizes, CRC32s, and flags of the files in each dir index in the directory set by --vpk-dir or --game are stored in an index in the user cache directory (or --index-dir). Each command updates the index first, only re-parsing the dir indexes whose size or modification time changed, so queries don't need to parse every dir index each time.
+`,
+}
+
+var CommandUpdate = &cobra.Command{
+	Use:   "update",
+	Short: "Updates the index",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		x, updated := load()
+		var files int
+		for _, v := range x.VPKs {
+			files += len(v.Files)
+		}
+		if root.Flags.JSON {
+			root.EmitJSON("summary", struct {
+				VPKs    int `json:"vpks"`
+				Updated int `json:"updated"`
+				Files   int `json:"files"`
+			}{len(x.VPKs), updated, files})
+			return
+		}
+		fmt.Printf("indexed %d vpks (%d updated), %d files\n", len(x.VPKs), updated, files)
+	},
+}
+
+var CommandFind = &cobra.Command{
+	Use:   "find glob...",
+	Short: "Shows the files matching globs",
+	Long: `Shows the files matching globs
+
+Each line contains the dir index name, block index, load flags, texture flags, CRC32, compressed size, uncompressed size, and path. Globs match like --include, so a file name or directory matches anywhere in the path. If nothing matches, the exit status is 1.
+`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		match := globs(args)
+		x, _ := load()
+		if find(x, match) == 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+var CommandStat = &cobra.Command{
+	Use:   "stat path...",
+	Short: "Shows the files with exact paths",
+	Long: `Shows the files with exact paths
+
+The output is the same as the find command. If a path isn't found, the exit status is 1.
+`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		paths := map[string]bool{}
+		for _, x := range args {
+			paths[strings.Trim(strings.ToLower(strings.ReplaceAll(x, `\`, "/")), "/")] = false
+		}
+		x, _ := load()
+		find(x, func(f vpkutil.SearchIndexFile) bool {
+			if _, ok := paths[f.Path]; ok {
+				paths[f.Path] = true
+				return true
+			}
+			return false
+		})
+		var missing bool
+		for _, x := range args {
+			p := strings.Trim(strings.ToLower(strings.ReplaceAll(x, `\`, "/")), "/")
+			if !paths[p] {
+				root.Errorf("%s: not found", p)
+				missing = true
+			}
+		}
+		if missing {
+			os.Exit(1)
+		}
+	},
+}
+
+var CommandGrep = &cobra.Command{
+	Use:   "grep pattern [glob...]",
+	Short: "Searches the contents of files for a regular expression",
+	Long: `Searches the contents of files for a regular expression
+
+Each matching line is printed as the dir index name, path, and line number, followed by the line, separated by colons. Binary files (containing a null byte) are only reported as matching. If globs are provided, only files matching them (like find) are searched, and dir indexes without any are not opened. If nothing matches, the exit status is 1.
+`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pattern := args[0]
+		if Flags.IgnoreCase {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			root.Errorf("invalid pattern: %v", err)
+			os.Exit(2)
+		}
+		match := globs(args[1:])
+		x, _ := load()
+
+		var found int
+		bw := bufio.NewWriter(os.Stdout)
+		for _, v := range x.VPKs {
+			var paths []string
+			for _, f := range v.Files {
+				if len(args) == 1 || match(f) {
+					paths = append(paths, f.Path)
+				}
+			}
+			if len(paths) == 0 {
+				continue
+			}
+			n, err := grep(bw, re, filepath.Join(x.Dir, v.Name), v.Name, paths)
+			if err != nil {
+				bw.Flush()
+				root.Fatalf("%s: %v", v.Name, err)
+			}
+			found += n
+		}
+		if err := bw.Flush(); err != nil {
+			root.Fatalf("%v", err)
+		}
+		if found == 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	Command.PersistentFlags().StringVar(&Flags.Dir, "index-dir", "", "the directory to store indexes in (defaults to the user cache directory)")
+	CommandFind.Flags().BoolVarP(&Flags.HumanReadable, "human-readable", "h", false, "show sizes in human-readable form")
+	CommandFind.Flags().Bool("help", false, "help for "+CommandFind.Name()) // prevent the default short help flag from being set
+	CommandStat.Flags().BoolVarP(&Flags.HumanReadable, "human-readable", "h", false, "show sizes in human-readable form")
+	CommandStat.Flags().Bool("help", false, "help for "+CommandStat.Name())
+	CommandGrep.Flags().BoolVarP(&Flags.IgnoreCase, "ignore-case", "i", false, "match case-insensitively")
+	CommandGrep.Flags().BoolVarP(&Flags.FilesWithMatches, "files-with-matches", "l", false, "only print the dir index name and path of matching files")
+	for _, c := range []*cobra.Command{CommandUpdate, CommandFind, CommandStat, CommandGrep} {
+		root.FlagJSON(c)
+	}
+	Command.AddCommand(CommandUpdate)
+	Command.AddCommand(CommandFind)
+	Command.AddCommand(CommandStat)
+	Command.AddCommand(CommandGrep)
+	root.Command.AddCommand(Command)
+}
+
+// globs returns a function matching files against the provided globs, exiting
+// if any are invalid.
+func globs(args []string) func(f vpkutil.SearchIndexFile) bool {
+	globs := make([]string, len(args))
+	for i, x := range args {
+		globs[i] = strings.ToLower(strings.ReplaceAll(x, `\`, "/"))
+		if _, err := path.Match(strings.TrimPrefix(globs[i], "/"), ""); err != nil {
+			root.Errorf("invalid glob %q: %v", x, err)
+			os.Exit(2)
+		}
+	}
+	return func(f vpkutil.SearchIndexFile) bool {
+		for _, g := range globs {
+			if m, _ := internal.MatchGlobParents(g, f.Path); m {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// load updates and returns the index.
+func load() (*vpkutil.SearchIndex, int) {
+	dir := root.Flags.VPKDir
+	if dir == "" && root.Flags.Game != "" {
+		var err error
+		if dir, err = root.GameVPKDir(); err != nil {
+			root.Fatalf("%v", err)
+		}
+	}
+	if dir == "" {
+		root.Errorf("no vpk directory specified (use --vpk-dir or --game)")
+		os.Exit(2)
+	}
+	idx := Flags.Dir
+	if idx == "" {
+		var err error
+		if idx, err = vpkutil.DefaultSearchIndexDir(); err != nil {
+			root.Fatalf("get index directory: %v", err)
+		}
+	}
+	x, updated, err := vpkutil.UpdateSearchIndex(idx, dir)
+	if err != nil {
+		root.Fatalf("update index: %v", err)
+	}
+	return x, updated
+}
+
+// find prints the files in x matching fn, returning the number of matches.
+func find(x *vpkutil.SearchIndex, fn func(f vpkutil.SearchIndexFile) bool) int {
+	var found int
+	bw := bufio.NewWriter(os.Stdout)
+	for _, v := range x.VPKs {
+		for _, f := range v.Files {
+			if !fn(f) {
+				continue
+			}
+			found++
+			if root.Flags.JSON {
+				root.EmitJSON("file", struct {
+					VPK            string               `json:"vpk"`
+					Path           string               `json:"path"`
+					Block          tf2vpk.ValvePakIndex `json:"block"`
+					LoadFlags      uint32               `json:"load_flags"`
+					TextureFlags   uint16               `json:"texture_flags"`
+					CRC32          uint32               `json:"crc32"`
+					CompressedSize uint64               `json:"compressed_size"`
+					Size           uint64               `json:"size"`
+				}{v.Name, f.Path, f.Index, f.LoadFlags, f.TextureFlags, f.CRC32, f.CompressedSize, f.Size})
+			} else if Flags.HumanReadable {
+				fmt.Fprintf(bw, "%s %s %08X %04X %08X %9s %9s %s\n", v.Name, f.Index, f.LoadFlags, f.TextureFlags, f.CRC32, internal.FormatBytesSI(int64(f.CompressedSize)), internal.FormatBytesSI(int64(f.Size)), f.Path)
+			} else {
+				fmt.Fprintf(bw, "%s %s %08X %04X %08X %9d %9d %s\n", v.Name, f.Index, f.LoadFlags, f.TextureFlags, f.CRC32, f.CompressedSize, f.Size, f.Path)
+			}
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		root.Fatalf("%v", err)
+	}
+	return found
+}
+
+// grepJSON is the record written for each match with --json. Line is zero if
+// only the file is reported (for binary files and --files-with-matches).
+type grepJSON struct {
+	VPK    string `json:"vpk"`
+	Path   string `json:"path"`
+	Line   int    `json:"line,omitempty"`
+	Text   string `json:"text,omitempty"`
+	Binary bool   `json:"binary,omitempty"`
+}
+
+// grep searches the files with the provided paths in the dir index at name,
+// printing matches to w, and returning the number of matching files.
+func grep(w io.Writer, re *regexp.Regexp, name, label string, paths []string) (int, error) {
+	vpk, err := tf2vpk.DetectValvePakRef(name)
+	if err != nil {
+		return 0, err
+	}
+	r, err := root.NewReader(vpk, false)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	want := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		want[p] = true
+	}
+	var found int
+	for _, f := range r.Root.File {
+		if !want[f.Path] {
+			continue
+		}
+		fr, err := r.OpenFileParallel(f, root.Flags.Jobs)
+		if err != nil {
+			return found, fmt.Errorf("read %q: %w", f.Path, err)
+		}
+		buf, err := io.ReadAll(fr)
+		if err != nil {
+			return found, fmt.Errorf("read %q: %w", f.Path, err)
+		}
+		if bytes.IndexByte(buf, 0) != -1 {
+			if !re.Match(buf) {
+				continue
+			}
+			found++
+			if root.Flags.JSON {
+				root.EmitJSON("match", grepJSON{label, f.Path, 0, "", true})
+			} else if Flags.FilesWithMatches {
+				fmt.Fprintf(w, "%s:%s\n", label, f.Path)
+			} else {
+				fmt.Fprintf(w, "%s:%s: binary file matches\n", label, f.Path)
+			}
+			continue
+		}
+		var matched bool
+		for i, line := range bytes.Split(buf, []byte{'\n'}) {
+			if line = bytes.TrimSuffix(line, []byte{'\r'}); !re.Match(line) {
+				continue
+			}
+			if !matched {
+				matched = true
+				found++
+				if Flags.FilesWithMatches {
+					if root.Flags.JSON {
+						root.EmitJSON("match", grepJSON{label, f.Path, 0, "", false})
+					} else {
+						fmt.Fprintf(w, "%s:%s\n", label, f.Path)
+					}
+					break
+				}
+			}
+			if root.Flags.JSON {
+				root.EmitJSON("match", grepJSON{label, f.Path, i + 1, string(line), false})
+			} else {
+				fmt.Fprintf(w, "%s:%s:%d:%s\n", label, f.Path, i+1, line)
+			}
+		}
+	}
+	return found, nil
+}
@@ -2,7 +2,6 @@ package init
 
 import (
 	"errors"
-	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -34,13 +33,13 @@ var Command = &cobra.Command{
 
 func init() {
 	Command.Flags().BoolVarP(&Flags.Force, "force", "f", false, "overwrite files if they exist")
+	root.FlagJSON(Command)
 	root.Command.AddCommand(Command)
 }
 
 func main() {
 	if err := os.Mkdir(Flags.Path, 0777); err != nil && !errors.Is(err, fs.ErrExist) {
-		fmt.Fprintf(os.Stderr, "error: create output directory: %v\n", err)
-		os.Exit(1)
+		root.Fatalf("create output directory: %v", err)
 	}
 
 	writeFile := writeFileExcl
@@ -49,20 +48,18 @@ func main() {
 	}
 
 	var vpkflags vpkutil.VPKFlags
-	if err := vpkflags.Add("/", 0b00000000000000000000000100000001, 0); err != nil {
-		panic(err)
-	}
+	vpkflags.AddDefault()
 
 	var vpkignore vpkutil.VPKIgnore
 	vpkignore.AddDefault()
 
 	var fail bool
 	if err := writeFile(filepath.Join(Flags.Path, vpkutil.VPKFlagsFilename), []byte(vpkflags.String()), 0666); err != nil {
-		fmt.Fprintf(os.Stderr, "error: save vpkflags: %v\n", err)
+		root.Errorf("save vpkflags: %v", err)
 		fail = true
 	}
 	if err := writeFile(filepath.Join(Flags.Path, vpkutil.VPKIgnoreFilename), []byte(vpkignore.String()), 0666); err != nil {
-		fmt.Fprintf(os.Stderr, "error: save vpkignore: %v\n", err)
+		root.Errorf("save vpkignore: %v", err)
 		fail = true
 	}
 	if fail {
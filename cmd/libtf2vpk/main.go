@@ -0,0 +1,211 @@
+// Command libtf2vpk is a C shared library exposing the VPK reader, so it can be
+// used from other languages (e.g., C++, or Python using ctypes).
+//
+//	go build -buildmode=c-shared -o libtf2vpk.so ./cmd/libtf2vpk
+//
+// This also generates libtf2vpk.h. Handles are opaque integers which must be
+// closed with tf2vpk_close. Strings returned by the library, including error
+// messages, must be freed with tf2vpk_free. Functions returning an error
+// message set *err (if err is not NULL) on failure.
+//
+//	uintptr_t tf2vpk_open(char *path, char **err);
+//	void tf2vpk_close(uintptr_t vpk);
+//	int64_t tf2vpk_count(uintptr_t vpk);
+//	int tf2vpk_entry(uintptr_t vpk, int64_t i, char **path, uint64_t *size, uint32_t *crc32);
+//	int64_t tf2vpk_read(uintptr_t vpk, char *path, void *buf, int64_t len, char **err);
+//	void tf2vpk_free(void *p);
+//
+// For example, using Python:
+//
+//	lib = ctypes.CDLL("./libtf2vpk.so")
+//	lib.tf2vpk_open.restype = ctypes.c_size_t
+//	vpk = lib.tf2vpk_open(b"englishclient_frontend.bsp.pak000_dir.vpk", None)
+//	n = lib.tf2vpk_read(ctypes.c_size_t(vpk), b"scripts/vscripts/ui/menu_main.nut", None, 0, None)
+//	buf = ctypes.create_string_buffer(n)
+//	lib.tf2vpk_read(ctypes.c_size_t(vpk), b"scripts/vscripts/ui/menu_main.nut", buf, n, None)
+//	lib.tf2vpk_close(ctypes.c_size_t(vpk))
+package main
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"sync"
+	"unsafe"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+func main() {}
+
+// setError sets *p to a new C string containing err, if p is not NULL.
+func setError(p **C.char, err error) {
+	if p != nil {
+		*p = C.CString(err.Error())
+	}
+}
+
+// handles contains the open readers. Unlike cgo.Handle, looking up an invalid
+// (or already closed) handle is an error rather than a panic.
+var handles struct {
+	sync.Mutex
+	next uintptr
+	m    map[uintptr]*tf2vpk.Reader
+}
+
+// newHandle returns a new handle for r.
+func newHandle(r *tf2vpk.Reader) C.uintptr_t {
+	handles.Lock()
+	defer handles.Unlock()
+
+	if handles.m == nil {
+		handles.m = map[uintptr]*tf2vpk.Reader{}
+	}
+	handles.next++
+	handles.m[handles.next] = r
+	return C.uintptr_t(handles.next)
+}
+
+// reader gets the Reader for a handle.
+func reader(h C.uintptr_t) (*tf2vpk.Reader, bool) {
+	handles.Lock()
+	defer handles.Unlock()
+
+	r, ok := handles.m[uintptr(h)]
+	return r, ok
+}
+
+// deleteHandle removes a handle, returning its Reader.
+func deleteHandle(h C.uintptr_t) (*tf2vpk.Reader, bool) {
+	handles.Lock()
+	defer handles.Unlock()
+
+	r, ok := handles.m[uintptr(h)]
+	delete(handles.m, uintptr(h))
+	return r, ok
+}
+
+// tf2vpk_open opens the VPK with the provided dir index or block path,
+// detecting the language prefix. It returns 0 on error.
+//
+//export tf2vpk_open
+func tf2vpk_open(path *C.char, err **C.char) C.uintptr_t {
+	r, _, e := tf2vpk.OpenReaderPath(C.GoString(path), "")
+	if e != nil {
+		setError(err, e)
+		return 0
+	}
+	return newHandle(r)
+}
+
+// tf2vpk_close closes a VPK opened with tf2vpk_open. Afterwards, the handle
+// is invalid. Invalid handles are ignored.
+//
+//export tf2vpk_close
+func tf2vpk_close(vpk C.uintptr_t) {
+	if r, ok := deleteHandle(vpk); ok {
+		r.Close()
+	}
+}
+
+// tf2vpk_count returns the number of files in the VPK, or -1 if the handle is
+// invalid.
+//
+//export tf2vpk_count
+func tf2vpk_count(vpk C.uintptr_t) C.int64_t {
+	r, ok := reader(vpk)
+	if !ok {
+		return -1
+	}
+	return C.int64_t(len(r.Root.File))
+}
+
+// tf2vpk_entry gets information about the i-th file in the VPK. Any of the
+// output pointers may be NULL. It returns 0 on success, or -1 if the handle or
+// index is invalid.
+//
+//export tf2vpk_entry
+func tf2vpk_entry(vpk C.uintptr_t, i C.int64_t, path **C.char, size *C.uint64_t, crc32 *C.uint32_t) C.int {
+	r, ok := reader(vpk)
+	if !ok || i < 0 || int64(i) >= int64(len(r.Root.File)) {
+		return -1
+	}
+	f := r.Root.File[i]
+	if path != nil {
+		*path = C.CString(f.Path)
+	}
+	if size != nil {
+		*size = C.uint64_t(f.Size())
+	}
+	if crc32 != nil {
+		*crc32 = C.uint32_t(f.CRC32)
+	}
+	return 0
+}
+
+// tf2vpk_read reads up to len bytes of the file at path into buf, returning
+// the size of the file (which may be larger than len), or -1 on error. The
+// checksum is only verified if the entire file is read.
+//
+//export tf2vpk_read
+func tf2vpk_read(vpk C.uintptr_t, path *C.char, buf unsafe.Pointer, n C.int64_t, err **C.char) C.int64_t {
+	r, ok := reader(vpk)
+	if !ok {
+		setError(err, errors.New("invalid handle"))
+		return -1
+	}
+	if n < 0 || (n > 0 && buf == nil) {
+		setError(err, errors.New("invalid buffer"))
+		return -1
+	}
+	name := C.GoString(path)
+	var file *tf2vpk.ValvePakFile
+	for i := range r.Root.File {
+		if r.Root.File[i].Path == name {
+			file = &r.Root.File[i]
+			break
+		}
+	}
+	if file == nil {
+		setError(err, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist})
+		return -1
+	}
+	size := int64(file.Size())
+	if n == 0 {
+		return C.int64_t(size)
+	}
+	fr, e := r.OpenFile(*file)
+	if e != nil {
+		setError(err, e)
+		return -1
+	}
+	dst := unsafe.Slice((*byte)(buf), min(int64(n), size))
+	if _, e := io.ReadFull(fr, dst); e != nil {
+		setError(err, e)
+		return -1
+	}
+	if int64(n) >= size {
+		// read to EOF to verify the checksum
+		if _, e := fr.Read(make([]byte, 1)); e != io.EOF {
+			if e == nil {
+				e = errors.New("file is larger than expected")
+			}
+			setError(err, e)
+			return -1
+		}
+	}
+	return C.int64_t(size)
+}
+
+// tf2vpk_free frees memory allocated by the library.
+//
+//export tf2vpk_free
+func tf2vpk_free(p unsafe.Pointer) {
+	C.free(p)
+}
@@ -0,0 +1,115 @@
+package lint
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/vpkutil"
+	"github.com/spf13/cobra"
+)
+
+var Flags struct {
+	VPK            tf2vpk.ValvePakRef
+	Manifest       string
+	MaxSize        uint64
+	Quiet          bool
+	IncludeExclude func(tf2vpk.ValvePakFile) (bool, error)
+}
+
+var Command = &cobra.Command{
+	GroupID: root.GroupVPKRead.ID,
+	Use:     "lint vpk_path",
+	Short:   "Checks a VPK for common packaging mistakes",
+	Long: `Checks a VPK for common packaging mistakes
+
+Only the dir index is read. If --manifest is set, the files the manifest would build are checked instead, and the vpk path must not be specified.
+
+Each warning is printed on a line formatted as "path: check: message". The checks are:
+
+  case       paths are lowercase, and don't only differ by case
+  chars      paths only contain printable ASCII without spaces, backslashes, or characters invalid on Windows
+  flags      files are visible, no unknown load flags are set, and texture flags are only set on (and set on) vtfs
+  size       files are not empty or larger than --max-size
+  companion  materials have a texture named like them in the same directory
+
+Exits with status 1 if there are warnings.
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		main()
+	},
+}
+
+func init() {
+	root.ArgVPK(&Flags.VPK, Command, -1, false, false, false)
+	if args := Command.Args; args != nil {
+		Command.Args = func(cmd *cobra.Command, a []string) error {
+			if Flags.Manifest != "" {
+				return cobra.NoArgs(cmd, a)
+			}
+			return args(cmd, a)
+		}
+	}
+	Command.Flags().StringVarP(&Flags.Manifest, "manifest", "m", "", "check the files built by a json manifest instead of a vpk")
+	Command.Flags().Uint64Var(&Flags.MaxSize, "max-size", 256<<20, "warn about files with a larger uncompressed size (0 to disable)")
+	Command.Flags().BoolVarP(&Flags.Quiet, "quiet", "q", false, "only set the exit status")
+	root.FlagIncludeExclude(&Flags.IncludeExclude, Command, true)
+	root.FlagJSON(Command)
+	root.Command.AddCommand(Command)
+}
+
+func main() {
+	var (
+		files []vpkutil.LintFile
+		err   error
+	)
+	if Flags.Manifest != "" {
+		files, err = vpkutil.LintManifestFile(Flags.Manifest)
+		if err != nil {
+			root.Fatalf("read manifest: %v", err)
+		}
+	} else {
+		f, err := os.Open(Flags.VPK.Resolve(tf2vpk.ValvePakIndexDir))
+		if err != nil {
+			root.Fatalf("open vpk dir: %v", err)
+		}
+		var dir tf2vpk.ValvePakDir
+		err = dir.Deserialize(f)
+		f.Close()
+		if err != nil {
+			root.Fatalf("read vpk dir: %v", err)
+		}
+		files, err = vpkutil.LintDir(dir)
+		if err != nil {
+			root.Fatalf("%v", err)
+		}
+	}
+
+	var warned bool
+	for _, w := range vpkutil.Lint(files, Flags.MaxSize) {
+		if skip, err := Flags.IncludeExclude(tf2vpk.ValvePakFile{Path: w.Path}); err != nil {
+			root.Errorf("%v", err)
+			os.Exit(2)
+		} else if skip {
+			continue
+		}
+		warned = true
+		if Flags.Quiet {
+			continue
+		}
+		if root.Flags.JSON {
+			root.EmitJSON("lint", struct {
+				Path    string `json:"path"`
+				Check   string `json:"check"`
+				Message string `json:"message"`
+			}{w.Path, w.Check, w.Message})
+		} else {
+			fmt.Println(w)
+		}
+	}
+	if warned {
+		os.Exit(1)
+	}
+}
@@ -1,6 +1,7 @@
 package list
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"os"
@@ -18,13 +19,19 @@ var Flags struct {
 	HumanReadableFlags bool
 	Long               bool
 	Test               bool
+	DirOnly            bool
 	IncludeExclude     func(tf2vpk.ValvePakFile) (bool, error)
+	AllowMissing       bool
 }
 
 var Command = &cobra.Command{
 	GroupID: root.GroupVPKRead.ID,
 	Use:     "list vpk_path",
 	Short:   "Lists the contents of a VPK",
+	Long: `Lists the contents of a VPK
+
+If --dir-only is set, only the dir index is read, and files are listed as they are parsed (or once it has been read, if the paths are padded for --human-readable-flags), so the block files don't need to exist. If the vpk path is -, the dir index is read from stdin (this implies --dir-only).
+`,
 	Args:    cobra.ExactArgs(1),
 	Aliases: []string{"ls"},
 	Run: func(cmd *cobra.Command, args []string) {
@@ -39,100 +46,191 @@ func init() {
 	Command.Flags().BoolVarP(&Flags.Long, "long", "l", false, "show detailed file metadata (adds the following columns to the beginning: block_index load_flags[binary] texture_flags[binary] crc32[hex] compressed_size[bytes] uncompressed_size[bytes] compressed_percent)")
 	Command.Flags().BoolVarP(&Flags.Test, "test", "t", false, "also attempt to read contents and compute checksums (adds a column with OK/ERR to the end)")
 	root.FlagIncludeExclude(&Flags.IncludeExclude, Command, true)
+	Command.Flags().BoolVarP(&Flags.DirOnly, "dir-only", "D", false, "only read the dir index, listing files as they are parsed (cannot be used with --test)")
 	root.ArgVPK(&Flags.VPK, Command, -1, false, false, false)
+	root.FlagAllowMissing(&Flags.AllowMissing, Command)
+	root.FlagJSON(Command)
+	if args := Command.Args; args != nil {
+		Command.Args = func(cmd *cobra.Command, a []string) error {
+			if len(a) == 1 && a[0] == "-" {
+				Flags.DirOnly = true
+				return nil
+			}
+			return args(cmd, a)
+		}
+	}
 	root.Command.AddCommand(Command)
 }
 
 func main() {
-	r, err := tf2vpk.NewReader(Flags.VPK)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: open vpk: %v\n", err)
-		os.Exit(1)
+	if Flags.DirOnly {
+		if Flags.Test {
+			root.Errorf("--test cannot be used when only reading the dir index")
+			os.Exit(2)
+		}
+		var in io.Reader = os.Stdin
+		if Flags.VPK != (tf2vpk.ValvePakRef{}) {
+			f, err := os.Open(Flags.VPK.Resolve(tf2vpk.ValvePakIndexDir))
+			if err != nil {
+				root.Fatalf("open vpk dir index: %v", err)
+			}
+			defer f.Close()
+			in = f
+		}
+		// the entries are listed as they are read unless the paths are padded
+		var (
+			dir   tf2vpk.ValvePakDir
+			files []tf2vpk.ValvePakFile
+			pad   = Flags.Long && Flags.HumanReadableFlags
+		)
+		if err := dir.DeserializeStream(bufio.NewReader(in), func(f tf2vpk.ValvePakFile) error {
+			if pad {
+				files = append(files, f)
+				return nil
+			}
+			return list(nil, f, 0)
+		}); err != nil {
+			root.Fatalf("read vpk dir index: %v", err)
+		}
+		pathLen := maxPathLen(files)
+		for _, f := range files {
+			list(nil, f, pathLen)
+		}
+		return
 	}
 
-	var pathLen int
-	for _, f := range r.Root.File {
-		pathLen = max(pathLen, min(len(f.Path), 64))
+	r, err := root.NewReader(Flags.VPK, Flags.AllowMissing)
+	if err != nil {
+		root.Fatalf("open vpk: %v", err)
 	}
 
+	pathLen := maxPathLen(r.Root.File)
+
 	var testErrCount int
 	for _, f := range r.Root.File {
-		if skip, err := Flags.IncludeExclude(f); err != nil {
-			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		if err := list(r, f, pathLen); err != nil {
+			testErrCount++
+		}
+	}
+	if Flags.Test {
+		root.Infof("%d/%d files valid", len(r.Root.File)-testErrCount, len(r.Root.File))
+		if testErrCount != 0 {
 			os.Exit(1)
-		} else if skip {
-			continue
 		}
+	}
+}
 
-		load, err := f.LoadFlags()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "warning: entry %q: compute load flags: %v\n", f.Path, err)
-			load = 0
-			load--
-		}
-		texture, err := f.TextureFlags()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "warning: entry %q: compute texture flags: %v\n", f.Path, err)
-			load = 0
-			load--
-		}
+// maxPathLen returns the length to pad the paths of fs to, which is the length
+// of the longest one, up to 64.
+func maxPathLen(fs []tf2vpk.ValvePakFile) int {
+	var n int
+	for _, f := range fs {
+		n = max(n, min(len(f.Path), 64))
+	}
+	return n
+}
 
-		var compressed, uncompressed uint64
-		for _, c := range f.Chunk {
-			compressed += c.CompressedSize
-			uncompressed += c.UncompressedSize
-		}
+// list prints f, returning an error if r is not nil and testing the file fails.
+func list(r *tf2vpk.Reader, f tf2vpk.ValvePakFile, pathLen int) error {
+	if skip, err := Flags.IncludeExclude(f); err != nil {
+		root.Fatalf("%v", err)
+	} else if skip {
+		return nil
+	}
 
-		if Flags.Long {
-			if Flags.HumanReadable {
-				fmt.Printf("%s %032b %016b %08X %6.2f %% %9s %9s  ", f.Index, load, texture, f.CRC32, float64(compressed)/float64(uncompressed)*100, formatBytesSIAligned(int64(compressed)), formatBytesSIAligned(int64(uncompressed)))
-			} else {
-				fmt.Printf("%s %032b %016b %08X %6.2f %% %9d %9d  ", f.Index, load, texture, f.CRC32, float64(compressed)/float64(uncompressed)*100, compressed, uncompressed)
-			}
-		}
-		if Flags.Test || (Flags.Long && Flags.HumanReadableFlags) {
-			fmt.Printf("%*s", -pathLen, f.Path)
-		} else {
-			fmt.Printf("%s", f.Path)
-		}
-		if Flags.Test {
-			os.Stdout.Sync()
-		}
+	load, err := f.LoadFlags()
+	if err != nil {
+		root.Warnf("entry %q: compute load flags: %v", f.Path, err)
+		load = 0
+		load--
+	}
+	texture, err := f.TextureFlags()
+	if err != nil {
+		root.Warnf("entry %q: compute texture flags: %v", f.Path, err)
+		load = 0
+		load--
+	}
+
+	var compressed, uncompressed uint64
+	for _, c := range f.Chunk {
+		compressed += c.CompressedSize
+		uncompressed += c.UncompressedSize
+	}
 
+	if root.Flags.JSON {
 		var testErr error
-		if Flags.Test {
-			if fr, err := r.OpenFileParallel(f, root.Flags.Threads); err != nil {
-				testErr = err
-			} else if _, err = io.Copy(io.Discard, fr); err != nil {
-				testErr = err
-			}
-			if testErr != nil {
-				testErrCount++
-			}
+		if Flags.Test && r != nil {
+			testErr = r.VerifyFileParallel(f, root.Flags.Jobs)
+		}
+		j := fileJSON{
+			Path:           f.Path,
+			Block:          f.Index,
+			LoadFlags:      load,
+			TextureFlags:   texture,
+			CRC32:          f.CRC32,
+			CompressedSize: compressed,
+			Size:           uncompressed,
 		}
-
 		if Flags.Test {
-			if testErr != nil {
-				fmt.Printf(" ERR")
-			} else {
-				fmt.Printf("  OK")
+			j.OK = new(bool)
+			if *j.OK = testErr == nil; !*j.OK {
+				j.Error = testErr.Error()
 			}
 		}
-		if Flags.Long && Flags.HumanReadableFlags {
-			fmt.Printf(" # load=%s texture=%s", tf2vpk.DescribeLoadFlags(load), tf2vpk.DescribeTextureFlags(texture))
-		}
-		fmt.Printf("\n")
+		root.EmitJSON("file", j)
+		return testErr
+	}
 
-		if Flags.Test && testErr != nil {
-			fmt.Fprintf(os.Stderr, "warning: entry %q: test: %v\n", f.Path, testErr)
+	if Flags.Long {
+		if Flags.HumanReadable {
+			fmt.Printf("%s %032b %016b %08X %6.2f %% %9s %9s  ", f.Index, load, texture, f.CRC32, float64(compressed)/float64(uncompressed)*100, formatBytesSIAligned(int64(compressed)), formatBytesSIAligned(int64(uncompressed)))
+		} else {
+			fmt.Printf("%s %032b %016b %08X %6.2f %% %9d %9d  ", f.Index, load, texture, f.CRC32, float64(compressed)/float64(uncompressed)*100, compressed, uncompressed)
 		}
 	}
+	if Flags.Test || (Flags.Long && Flags.HumanReadableFlags) {
+		fmt.Printf("%*s", -pathLen, f.Path)
+	} else {
+		fmt.Printf("%s", f.Path)
+	}
 	if Flags.Test {
-		fmt.Fprintf(os.Stderr, "%d/%d files valid", testErrCount, len(r.Root.File))
-		if testErrCount != 0 {
-			os.Exit(1)
+		os.Stdout.Sync()
+	}
+
+	var testErr error
+	if Flags.Test && r != nil {
+		testErr = r.VerifyFileParallel(f, root.Flags.Jobs)
+	}
+
+	if Flags.Test {
+		if testErr != nil {
+			fmt.Printf(" ERR")
+		} else {
+			fmt.Printf("  OK")
 		}
 	}
+	if Flags.Long && Flags.HumanReadableFlags {
+		fmt.Printf(" # load=%s texture=%s", tf2vpk.DescribeLoadFlags(load), tf2vpk.DescribeTextureFlags(texture))
+	}
+	fmt.Printf("\n")
+
+	if Flags.Test && testErr != nil {
+		root.Warnf("entry %q: test: %v", f.Path, testErr)
+	}
+	return testErr
+}
+
+// fileJSON is the record written for each file with --json.
+type fileJSON struct {
+	Path           string               `json:"path"`
+	Block          tf2vpk.ValvePakIndex `json:"block"`
+	LoadFlags      uint32               `json:"load_flags"`
+	TextureFlags   uint16               `json:"texture_flags"`
+	CRC32          uint32               `json:"crc32"`
+	CompressedSize uint64               `json:"compressed_size"`
+	Size           uint64               `json:"size"`
+	OK             *bool                `json:"ok,omitempty"`    // with --test
+	Error          string               `json:"error,omitempty"` // with --test
 }
 
 func formatBytesSIAligned(b int64) string {
@@ -1,3 +1,5 @@
+//go:build !tf2vpk_nolzham
+
 package lzham
 
 import (
@@ -6,6 +8,7 @@ import (
 	"io"
 	"io/fs"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/pg9182/tf2lzham"
@@ -51,10 +54,15 @@ func init() {
 	Command.Flags().BoolVarP(&Flags.Force, "force", "f", false, "force overwrite of output file")
 	Command.Flags().BoolVarP(&Flags.Verbose, "verbose", "v", false, "verbose mode")
 	Command.Flags().UintVarP(&Flags.Buffer, "buffer", "b", 5*1024*1024, "compression/decompression buffer size")
+	root.FlagJSON(Command)
 	root.Command.AddCommand(Command)
 }
 
 func main() {
+	if root.Flags.JSON && (Flags.Stdout || slices.Contains(Flags.Files, "-")) {
+		root.Errorf("--json cannot be used when writing to stdout")
+		os.Exit(2)
+	}
 	zbuf := make([]byte, int(Flags.Buffer))
 
 	var failed int
@@ -116,6 +124,9 @@ func main() {
 			} else {
 				f, err := os.OpenFile(output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_EXCL, mode)
 				if errors.Is(err, fs.ErrExist) {
+					if !Flags.Force && root.Flags.JSON {
+						return fmt.Errorf("%s already exists (use --force to overwrite)", output)
+					}
 					if !Flags.Force {
 						fmt.Fprintf(os.Stderr, "warning: %s already exists; overwrite (y or n)? ", output)
 						os.Stderr.Sync()
@@ -154,13 +165,23 @@ func main() {
 				action = "created"
 			}
 
-			if Flags.Verbose {
+			if Flags.Verbose && root.Flags.JSON {
+				root.EmitJSON("file", struct {
+					Input   string `json:"input"`
+					Output  string `json:"output"`
+					Size    int    `json:"size"`
+					OutSize int    `json:"output_size"`
+					Adler32 uint32 `json:"adler32"`
+					CRC32   uint32 `json:"crc32"`
+					Removed bool   `json:"removed"`
+				}{input, output, len(buf), n, adler32, crc32, action == "replaced with"})
+			} else if Flags.Verbose {
 				fmt.Fprintf(os.Stderr, "%s: %5.1f%% adler32=%08X crc32=%08X -- %s %s\n", input, float64(n)/float64(len(buf))*100, adler32, crc32, action, output)
 			}
 			return nil
 		}(); err != nil {
 			failed++
-			fmt.Fprintf(os.Stderr, "error: %s: %v\n", input, err)
+			root.Errorf("%s: %v", input, err)
 		}
 	}
 	if failed != 0 {
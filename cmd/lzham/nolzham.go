@@ -0,0 +1,4 @@
+//go:build tf2vpk_nolzham
+
+// Package lzham doesn't add the lzham command if tf2lzham isn't linked.
+package lzham
@@ -0,0 +1,186 @@
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/internal"
+	"github.com/pg9182/tf2vpk/vpkutil"
+	"github.com/spf13/cobra"
+)
+
+var Flags struct {
+	Output  string
+	SignKey string
+	Build   string
+}
+
+var Command = &cobra.Command{
+	GroupID: root.GroupVPKRead.ID,
+	Use:     "manifest",
+	Short:   "Creates install manifests for checking VPKs with verify --manifest",
+	Long: `Creates install manifests for checking VPKs with verify --manifest
+
+An install manifest is a JSON file listing the path, size, CRC32, and SHA-256 of each file in every VPK in a game install, which can be published so others can check their install with verify --manifest. Manifests can be signed with an Ed25519 key so they can be checked with verify --manifest-key.
+`,
+}
+
+var CommandCreate = &cobra.Command{
+	Use:   "create [vpk_dir]",
+	Short: "Creates a manifest from the VPKs in a directory",
+	Long: `Creates a manifest from the VPKs in a directory
+
+The directory is the argument, or --vpk-dir or --game if not specified. Every file in every dir index is hashed, but files shared between languages are only read once. If the game is installed with Steam, the build ID is included.
+
+With --sign-key, the manifest is signed with the private key, and the signature is written to the output path with .sig appended.
+`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := root.Flags.VPKDir
+		if len(args) != 0 {
+			dir = args[0]
+		} else if dir == "" && root.Flags.Game != "" {
+			var err error
+			if dir, err = root.GameVPKDir(); err != nil {
+				root.Fatalf("%v", err)
+			}
+		}
+		if dir == "" {
+			root.Errorf("no vpk directory specified")
+			os.Exit(2)
+		}
+		create(dir)
+	},
+}
+
+var CommandKeygen = &cobra.Command{
+	Use:   "keygen private_key_file",
+	Short: "Generates a key for signing manifests",
+	Long: `Generates a key for signing manifests
+
+The private key is written to the file, which must not already exist, and the public key to use with verify --manifest-key is printed.
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pub, priv, err := vpkutil.GenerateInstallManifestKey()
+		if err != nil {
+			root.Fatalf("generate key: %v", err)
+		}
+		f, err := os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			root.Fatalf("write private key: %v", err)
+		}
+		if _, err := f.WriteString(priv + "\n"); err != nil {
+			f.Close()
+			root.Fatalf("write private key: %v", err)
+		}
+		if err := f.Close(); err != nil {
+			root.Fatalf("write private key: %v", err)
+		}
+		if root.Flags.JSON {
+			root.EmitJSON("key", struct {
+				PublicKey      string `json:"public_key"`
+				PrivateKeyFile string `json:"private_key_file"`
+			}{pub, args[0]})
+			return
+		}
+		fmt.Println(pub)
+	},
+}
+
+func init() {
+	CommandCreate.Flags().StringVarP(&Flags.Output, "output", "o", "-", "write the manifest to a file")
+	CommandCreate.Flags().StringVar(&Flags.SignKey, "sign-key", "", "sign the manifest with the private key in this file (requires --output)")
+	CommandCreate.Flags().StringVar(&Flags.Build, "build", "", "the build ID to include (detected for Steam installs if not specified)")
+	root.FlagJSON(CommandCreate)
+	root.FlagJSON(CommandKeygen)
+	Command.AddCommand(CommandCreate)
+	Command.AddCommand(CommandKeygen)
+	root.Command.AddCommand(Command)
+}
+
+func create(dir string) {
+	if Flags.SignKey != "" && Flags.Output == "-" {
+		root.Errorf("--sign-key requires an output file")
+		os.Exit(2)
+	}
+	var sign func([]byte) []byte
+	if Flags.SignKey != "" {
+		buf, err := os.ReadFile(Flags.SignKey)
+		if err != nil {
+			root.Fatalf("read private key: %v", err)
+		}
+		key, err := vpkutil.ParseInstallManifestPrivateKey(string(buf))
+		if err != nil {
+			root.Fatalf("%v", err)
+		}
+		sign = func(b []byte) []byte {
+			return vpkutil.SignInstallManifest(b, key)
+		}
+	}
+
+	var (
+		progress *internal.Progress
+		last     int64
+	)
+	m, err := vpkutil.NewInstallManifest(dir, max(root.Flags.Jobs, 1), func(done, total int64, path string) {
+		if path == "" {
+			progress = root.Progress("manifest", 0, total) // before hashing, once the total is known
+			return
+		}
+		progress.AddBytes(done - last)
+		progress.AddFiles(1)
+		last = done
+	})
+	if err != nil {
+		root.Fatalf("%v", err)
+	}
+	progress.Done()
+
+	m.Game = root.Flags.Game
+	if m.Build = Flags.Build; m.Build == "" {
+		m.Build = vpkutil.SteamBuildID(dir)
+	}
+
+	var b bytes.Buffer
+	if err := m.Encode(&b); err != nil {
+		root.Fatalf("encode manifest: %v", err)
+	}
+	if Flags.Output == "-" && root.Flags.JSON {
+		root.EmitJSON("manifest", m) // sorted by Encode
+		return
+	}
+	if Flags.Output == "-" {
+		bw := bufio.NewWriter(os.Stdout)
+		if _, err := b.WriteTo(bw); err == nil {
+			err = bw.Flush()
+		}
+		if err != nil {
+			root.Fatalf("write manifest: %v", err)
+		}
+		return
+	}
+	if err := os.WriteFile(Flags.Output, b.Bytes(), 0666); err != nil {
+		root.Fatalf("write manifest: %v", err)
+	}
+	if sign != nil {
+		if err := os.WriteFile(Flags.Output+".sig", sign(b.Bytes()), 0666); err != nil {
+			root.Fatalf("write signature: %v", err)
+		}
+	}
+	if root.Flags.JSON {
+		var files int
+		for _, v := range m.VPKs {
+			files += len(v.Files)
+		}
+		root.EmitJSON("summary", struct {
+			Output string `json:"output"`
+			Signed bool   `json:"signed"`
+			VPKs   int    `json:"vpks"`
+			Files  int    `json:"files"`
+		}{Flags.Output, sign != nil, len(m.VPKs), files})
+	}
+}
@@ -0,0 +1,95 @@
+package merge
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/vpkutil"
+	"github.com/spf13/cobra"
+)
+
+var Flags struct {
+	VPK            tf2vpk.ValvePakRef
+	Inputs         []tf2vpk.ValvePakRef
+	Conflict       string
+	IncludeExclude func(tf2vpk.ValvePakFile) (bool, error)
+	Verbose        bool
+	Writer         func(*tf2vpk.Writer) error
+}
+
+var Command = &cobra.Command{
+	GroupID: root.GroupVPKRepack.ID,
+	Use:     "merge vpk_path input_vpk_path...",
+	Short:   "Merges multiple VPKs into a new VPK",
+	Long: `Merges multiple VPKs into a new VPK
+
+Chunks are copied as-is without recompressing them. Files which exist in more than one input are resolved using the conflict policy:
+
+  first  use the file from the first input containing it
+  last   use the file from the last input containing it
+  error  fail
+`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, arg := range args[1:] {
+			if vpk, err := root.VPK(arg); err != nil {
+				root.Errorf("%v", err)
+				os.Exit(2)
+			} else {
+				Flags.Inputs = append(Flags.Inputs, vpk)
+			}
+		}
+		main()
+	},
+}
+
+func init() {
+	root.ArgVPK(&Flags.VPK, Command, -1, false, false, false)
+	root.FlagWriter(&Flags.Writer, Command, false)
+	root.FlagIncludeExclude(&Flags.IncludeExclude, Command, true)
+	Command.Flags().StringVarP(&Flags.Conflict, "conflict", "c", vpkutil.MergeError.String(), "conflict policy (first, last, error)")
+	Command.Flags().BoolVarP(&Flags.Verbose, "verbose", "v", false, "print the number of files in the output")
+	root.FlagJSON(Command)
+	root.Command.AddCommand(Command)
+}
+
+func main() {
+	policy, err := vpkutil.ParseMergeConflict(Flags.Conflict)
+	if err != nil {
+		root.Errorf("%v", err)
+		os.Exit(2)
+	}
+
+	var rs []*tf2vpk.Reader
+	for _, vpk := range Flags.Inputs {
+		r, err := root.NewReader(vpk, false)
+		if err != nil {
+			root.Fatalf("open vpk %q: %v", vpk.Resolve(tf2vpk.ValvePakIndexDir), err)
+		}
+		defer r.Close()
+		rs = append(rs, r)
+	}
+
+	w := tf2vpk.NewWriter(Flags.VPK)
+	if err := Flags.Writer(w); err != nil {
+		root.Errorf("%v", err)
+		os.Exit(2)
+	}
+	if err := vpkutil.Merge(w, policy, Flags.IncludeExclude, rs...); err != nil {
+		w.Abort()
+		root.Fatalf("%v", err)
+	}
+	if err := w.Close(); err != nil {
+		root.Fatalf("write vpk: %v", err)
+	}
+	if root.Flags.JSON {
+		root.EmitJSON("summary", struct {
+			VPKs  int `json:"vpks"`
+			Files int `json:"files"`
+		}{len(rs), len(w.Root.File)})
+	} else if Flags.Verbose {
+		fmt.Printf("merged %d vpks (%d files)\n", len(rs), len(w.Root.File))
+	}
+}
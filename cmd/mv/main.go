@@ -0,0 +1,61 @@
+package mv
+
+import (
+	"fmt"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/vpkutil"
+	"github.com/spf13/cobra"
+)
+
+var Flags struct {
+	VPK     tf2vpk.ValvePakRef
+	From    string
+	To      string
+	Verbose bool
+	DryRun  bool
+}
+
+var Command = &cobra.Command{
+	GroupID: root.GroupVPKWrite.ID,
+	Use:     "mv vpk_path source dest",
+	Aliases: []string{"move", "rename", "ren"},
+	Short:   "Rename a file or directory in a VPK",
+	Long: `Rename a file or directory in a VPK
+
+If source is a directory, all files in it are moved to dest. Only the VPK
+directory index is rewritten; the file contents are not touched.
+`,
+	Args: cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		Flags.From = args[1]
+		Flags.To = args[2]
+		main()
+	},
+}
+
+func init() {
+	root.ArgVPK(&Flags.VPK, Command, 1, true, true, true)
+	Command.Flags().BoolVarP(&Flags.DryRun, "dry-run", "n", false, "do not write changes")
+	Command.Flags().BoolVarP(&Flags.Verbose, "verbose", "v", false, "print information about each processed file")
+	root.FlagJSON(Command)
+	root.Command.AddCommand(Command)
+}
+
+func main() {
+	if err := vpkutil.UpdateDir(Flags.VPK, Flags.DryRun, func(dir *tf2vpk.ValvePakDir) error {
+		return vpkutil.Rename(dir, Flags.From, Flags.To, func(oldPath, newPath string) {
+			if Flags.Verbose && root.Flags.JSON {
+				root.EmitJSON("rename", struct {
+					Old string `json:"old"`
+					New string `json:"new"`
+				}{oldPath, newPath})
+			} else if Flags.Verbose {
+				fmt.Printf("rename %s -> %s\n", oldPath, newPath)
+			}
+		})
+	}); err != nil {
+		root.Fatalf("%v", err)
+	}
+}
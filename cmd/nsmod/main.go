@@ -0,0 +1,207 @@
+package nsmod
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/vpkutil"
+	"github.com/spf13/cobra"
+)
+
+// OriginFilename is the name of the file recording where the extracted files
+// came from.
+const OriginFilename = "vpkorigin.txt"
+
+var Flags struct {
+	VPK            tf2vpk.ValvePakRef
+	Path           string
+	Files          []string
+	Name           string
+	Description    string
+	Version        string
+	LoadPriority   int
+	IncludeExclude func(tf2vpk.ValvePakFile) (bool, error)
+	Verbose        bool
+	LaxPaths       bool
+}
+
+var Command = &cobra.Command{
+	GroupID: root.GroupVPKRepack.ID,
+	Use:     "nsmod vpk_path mod_path [file...]",
+	Short:   "Creates a Northstar mod from files in a VPK",
+	Long: `Creates a Northstar mod from files in a VPK
+
+The selected files (or directories) are extracted to the mod/ folder of the new mod at the same paths they have in the VPK, so they override the vanilla ones. A mod.json stub is generated, and the VPK and CRC32 of each extracted file are recorded in ` + OriginFilename + ` so the files can be compared against the vanilla ones later.
+
+The mod directory must not exist or be empty. At least one file or --include filter must be specified.
+`,
+	Args: cobra.MinimumNArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		Flags.Path = args[1]
+		Flags.Files = args[2:]
+		if len(Flags.Files) == 0 && !cmd.Flags().Changed("include") {
+			root.Errorf("no files selected (specify files or use --include)")
+			os.Exit(2)
+		}
+		if Flags.Name == "" {
+			Flags.Name = filepath.Base(Flags.Path)
+		}
+		main()
+	},
+}
+
+func init() {
+	root.ArgVPK(&Flags.VPK, Command, 2, true, true, true)
+	Command.Flags().StringVarP(&Flags.Name, "name", "n", "", "mod name (defaults to the name of the mod directory)")
+	Command.Flags().StringVarP(&Flags.Description, "description", "d", "", "mod description")
+	Command.Flags().StringVar(&Flags.Version, "version", "0.0.1", "mod version")
+	Command.Flags().IntVar(&Flags.LoadPriority, "load-priority", 1, "mod load priority")
+	Command.Flags().BoolVarP(&Flags.Verbose, "verbose", "v", false, "print the extracted files")
+	Command.Flags().BoolVar(&Flags.LaxPaths, "lax-paths", false, "allow extracting paths which are invalid on windows (absolute paths and path traversal are always rejected)")
+	root.FlagIncludeExclude(&Flags.IncludeExclude, Command, true)
+	root.FlagJSON(Command)
+	root.Command.AddCommand(Command)
+}
+
+// modJSON is the mod.json stub. The field order matches the one used by the
+// Northstar example mods.
+type modJSON struct {
+	Name         string   `json:"Name"`
+	Description  string   `json:"Description"`
+	Version      string   `json:"Version"`
+	LoadPriority int      `json:"LoadPriority"`
+	Scripts      []string `json:"Scripts"`
+	Localisation []string `json:"Localisation"`
+}
+
+func main() {
+	r, err := root.NewReader(Flags.VPK, false)
+	if err != nil {
+		root.Fatalf("open vpk: %v", err)
+	}
+	defer r.Close()
+
+	var files []tf2vpk.ValvePakFile
+	for _, f := range r.Root.File {
+		if len(Flags.Files) != 0 && !selected(f.Path) {
+			continue
+		}
+		if skip, err := Flags.IncludeExclude(f); err != nil {
+			root.Fatalf("%v", err)
+		} else if skip {
+			continue
+		}
+		files = append(files, f)
+	}
+	for _, name := range Flags.Files {
+		var found bool
+		for _, f := range files {
+			if f.Path == name || strings.HasPrefix(f.Path, strings.TrimSuffix(name, "/")+"/") {
+				found = true
+				break
+			}
+		}
+		if !found {
+			root.Fatalf("no files selected for %q", name)
+		}
+	}
+	if len(files) == 0 {
+		root.Fatalf("no files selected")
+	}
+
+	if err := os.MkdirAll(Flags.Path, 0777); err != nil {
+		root.Fatalf("create mod directory: %v", err)
+	}
+	if dis, err := os.ReadDir(Flags.Path); err != nil {
+		root.Fatalf("list mod directory: %v", err)
+	} else if len(dis) != 0 {
+		root.Fatalf("mod directory must not exist or be empty, found %q", dis[0].Name())
+	}
+
+	mod, err := json.MarshalIndent(modJSON{
+		Name:         Flags.Name,
+		Description:  Flags.Description,
+		Version:      Flags.Version,
+		LoadPriority: Flags.LoadPriority,
+		Scripts:      []string{},
+		Localisation: []string{},
+	}, "", "\t")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(filepath.Join(Flags.Path, "mod.json"), append(mod, '\n'), 0666); err != nil {
+		root.Fatalf("write mod.json: %v", err)
+	}
+
+	var origin strings.Builder
+	fmt.Fprintf(&origin, "# %s\n", Flags.VPK.Resolve(tf2vpk.ValvePakIndexDir))
+	for _, f := range files {
+		outPath, err := vpkutil.SanitizeJoin(filepath.Join(Flags.Path, "mod"), f.Path, Flags.LaxPaths)
+		if err != nil {
+			root.Fatalf("%v", err)
+		}
+		if err := extract(r, f, outPath); err != nil {
+			root.Fatalf("extract vpk file %q: %v", f.Path, err)
+		}
+		if Flags.Verbose && root.Flags.JSON {
+			root.EmitJSON("file", struct {
+				Path string `json:"path"`
+			}{path.Join("mod", f.Path)})
+		} else if Flags.Verbose {
+			fmt.Printf("mod/%s\n", f.Path)
+		}
+		fmt.Fprintf(&origin, "%08X %s\n", f.CRC32, path.Join("mod", f.Path))
+	}
+	if err := os.WriteFile(filepath.Join(Flags.Path, OriginFilename), []byte(origin.String()), 0666); err != nil {
+		root.Fatalf("write %s: %v", OriginFilename, err)
+	}
+	if root.Flags.JSON {
+		root.EmitJSON("summary", struct {
+			Path  string `json:"path"`
+			Files int    `json:"files"`
+		}{Flags.Path, len(files)})
+	}
+}
+
+// selected checks if name is one of the files, or is inside one of the
+// directories, specified on the command line.
+func selected(name string) bool {
+	for _, x := range Flags.Files {
+		if x = strings.TrimSuffix(x, "/"); name == x || strings.HasPrefix(name, x+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func extract(r *tf2vpk.Reader, f tf2vpk.ValvePakFile, name string) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+		return err
+	}
+	fr, err := r.OpenFileParallel(f, root.Flags.Jobs)
+	if err != nil {
+		return err
+	}
+	of, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("file already exists")
+		}
+		return err
+	}
+	if _, err := io.Copy(of, fr); err != nil {
+		of.Close()
+		os.Remove(name)
+		return err
+	}
+	return of.Close()
+}
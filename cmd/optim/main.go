@@ -0,0 +1,166 @@
+package optim
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/internal"
+	"github.com/pg9182/tf2vpk/vpkutil"
+	"github.com/spf13/cobra"
+)
+
+var Flags struct {
+	VPK            tf2vpk.ValvePakRef
+	Output         string
+	IncludeExclude func(tf2vpk.ValvePakFile) (bool, error)
+	Verbose        bool
+	DryRun         bool
+	Rebalance      uint64
+	Writer         func(*tf2vpk.Writer) error
+}
+
+var Command = &cobra.Command{
+	GroupID: root.GroupVPKRepack.ID,
+	Use:     "optim vpk_path out_dir",
+	Aliases: []string{"optimize", "gc"},
+	Short:   "Rewrites a VPK without unused or duplicate chunks",
+	Long: `Rewrites a VPK without unused or duplicate chunks
+
+All blocks are merged into one, and chunks are copied as-is without recompressing them. Chunks with identical contents are only stored once. With --dry-run, the plan is printed without writing anything. The output is written to a VPK with the same name in a different directory.
+
+With --rebalance, the blocks are rearranged to be about the specified size instead (e.g., after many small blocks were appended by incremental updates). The files of blocks which are already between half and all of the size are kept together in their own block, and the files of the other blocks are packed into new blocks of up to the size after them. The dry run also lists the new blocks and the original blocks their files came from. Since it decides the blocks itself, it cannot be used with --block-size or --single-file.
+`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		Flags.Output = args[1]
+		main(cmd)
+	},
+}
+
+func init() {
+	root.ArgVPK(&Flags.VPK, Command, -1, false, false, false)
+	root.FlagWriter(&Flags.Writer, Command, false)
+	root.FlagIncludeExclude(&Flags.IncludeExclude, Command, true)
+	Command.Flags().BoolVarP(&Flags.DryRun, "dry-run", "n", false, "show what would be written without writing anything")
+	Command.Flags().BoolVarP(&Flags.Verbose, "verbose", "v", false, "print information about each file")
+	root.ByteSizeVar(Command, &Flags.Rebalance, "rebalance", 0, "rearrange the blocks to be about this size (e.g., 1GiB), keeping the ones which already are (cannot be used with --block-size or --single-file)")
+	root.FlagJSON(Command)
+	root.Command.AddCommand(Command)
+}
+
+func main(cmd *cobra.Command) {
+	if Flags.Rebalance != 0 {
+		for _, x := range []string{"block-size", "single-file"} {
+			if cmd.Flags().Changed(x) {
+				root.Errorf("--rebalance cannot be used with --%s", x)
+				os.Exit(2)
+			}
+		}
+	}
+
+	out := Flags.VPK
+	out.Path = Flags.Output
+
+	if a, err := filepath.Abs(Flags.VPK.Resolve(tf2vpk.ValvePakIndexDir)); err != nil {
+		root.Fatalf("resolve input path: %v", err)
+	} else if b, err := filepath.Abs(out.Resolve(tf2vpk.ValvePakIndexDir)); err != nil {
+		root.Fatalf("resolve output path: %v", err)
+	} else if a == b {
+		root.Fatalf("output directory must be different from the input directory")
+	}
+
+	r, err := root.NewReader(Flags.VPK, false)
+	if err != nil {
+		root.Fatalf("open vpk: %v", err)
+	}
+	defer r.Close()
+
+	a, err := vpkutil.AnalyzeOptimize(r, Flags.IncludeExclude)
+	if err != nil {
+		root.Fatalf("analyze vpk: %v", err)
+	}
+	plan := a.Plan()
+	if Flags.Rebalance != 0 {
+		plan.Rebalance(Flags.Rebalance)
+	}
+
+	if Flags.Verbose || Flags.DryRun {
+		for _, x := range plan.Excluded {
+			file("exclude", x)
+		}
+	}
+	if Flags.DryRun {
+		for _, x := range plan.Files {
+			file("copy", x)
+		}
+		for i, b := range plan.Blocks {
+			src := make([]string, len(b.Source))
+			for j, x := range b.Source {
+				src[j] = x.String()
+			}
+			action := "pack"
+			if b.Kept {
+				action = "keep"
+			}
+			if root.Flags.JSON {
+				root.EmitJSON("block", struct {
+					Block  tf2vpk.ValvePakIndex   `json:"block"`
+					Action string                 `json:"action"`
+					Files  int                    `json:"files"`
+					Size   uint64                 `json:"size"`
+					Source []tf2vpk.ValvePakIndex `json:"source"`
+				}{tf2vpk.ValvePakIndex(i), action, b.Files, b.Size, b.Source})
+				continue
+			}
+			fmt.Printf("block %s: %s %d files (%s) from %s\n", tf2vpk.ValvePakIndex(i), action, b.Files, internal.FormatBytesSI(int64(b.Size)), strings.Join(src, ", "))
+		}
+	} else {
+		if err := os.MkdirAll(Flags.Output, 0777); err != nil {
+			root.Fatalf("create output directory: %v", err)
+		}
+		w := tf2vpk.NewWriter(out)
+		if err := Flags.Writer(w); err != nil {
+			root.Errorf("%v", err)
+			os.Exit(2)
+		}
+		if err := plan.Execute(w, func(path string) {
+			if Flags.Verbose {
+				file("copy", path)
+			}
+		}); err != nil {
+			w.Abort()
+			root.Fatalf("%v", err)
+		}
+		if err := w.Close(); err != nil {
+			root.Fatalf("write vpk: %v", err)
+		}
+	}
+	if root.Flags.JSON {
+		root.EmitJSON("summary", struct {
+			Files          int    `json:"files"`
+			Excluded       int    `json:"excluded"`
+			Duplicates     int    `json:"duplicates"`
+			DuplicateBytes uint64 `json:"duplicate_bytes"`
+			OldSize        uint64 `json:"old_size"`
+			NewSize        uint64 `json:"new_size"`
+			DryRun         bool   `json:"dry_run"`
+		}{len(plan.Files), len(plan.Excluded), plan.Duplicates, plan.DuplicateBytes, plan.OldSize, plan.NewSize, Flags.DryRun})
+		return
+	}
+	fmt.Printf("%d files (%d excluded), %d duplicate chunks (%s), %s -> %s (delta %s)\n", len(plan.Files), len(plan.Excluded), plan.Duplicates, internal.FormatBytesSI(int64(plan.DuplicateBytes)), internal.FormatBytesSI(int64(plan.OldSize)), internal.FormatBytesSI(int64(plan.NewSize)), internal.FormatBytesSI(int64(plan.NewSize)-int64(plan.OldSize)))
+}
+
+// file prints an action for a file.
+func file(action, name string) {
+	if root.Flags.JSON {
+		root.EmitJSON(action, struct {
+			Path string `json:"path"`
+		}{name})
+		return
+	}
+	fmt.Printf("%s %s\n", action, name)
+}
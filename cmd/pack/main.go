@@ -0,0 +1,294 @@
+package pack
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/internal"
+	"github.com/pg9182/tf2vpk/vpkutil"
+	"github.com/spf13/cobra"
+)
+
+var Flags struct {
+	VPK       tf2vpk.ValvePakRef
+	Path      string
+	Verbose   bool
+	Writer    func(*tf2vpk.Writer) error
+	Transform vpkutil.Transforms
+	Unescape  bool
+	Times     string
+	DryRun    bool
+}
+
+var Command = &cobra.Command{
+	GroupID: root.GroupVPKRepack.ID,
+	Use:     "pack vpk_path in_path",
+	Short:   "Packs a directory into a new VPK",
+	Long: `Packs a directory into a new VPK
+
+The input can also be a zip file, which is packed like a directory with the same contents.
+
+Flags are set using the vpkflags file at the root of the directory (see the init and unpack commands). Files matching the vpkignore file at the root of the directory are not packed. If there isn't one, the default ignore rules are used.
+
+With --timestamps-out, a vpktimes file (see unpack --timestamps) with the current checksums and modification times of the packed files is written to the provided path after packing, so unpacking a future version of the vpk with unpack --timestamps-from can restore the times of unchanged files. The input is never modified, so to keep it next to the files, pass the vpktimes path in the input directory explicitly.
+
+If there is a vpkmeta file at the root of the directory (see unpack --vpkmeta), the flags and chunking it records take precedence over the vpkflags for files which have not changed in size.
+
+With --transform, the contents of matching files are rewritten as they are packed (e.g., --transform '*.nut=lf' to normalize line endings in scripts). The vpkmeta chunking is not used for transformed files.
+
+Files are packed in name order, so the output is reproducible for identical directory contents.
+
+With --dry-run, the files are read and compressed as usual, but nothing is written. Instead, the files which would be added, updated, or removed compared to the existing vpk (if any) are printed, followed by the resulting size.
+`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		Flags.Path = args[1]
+		main()
+	},
+}
+
+func init() {
+	root.ArgVPK(&Flags.VPK, Command, -1, false, false, false)
+	root.FlagWriter(&Flags.Writer, Command, true)
+	root.FlagTransform(&Flags.Transform, Command)
+	Command.Flags().BoolVarP(&Flags.Verbose, "verbose", "v", false, "display files as they are packed")
+	Command.Flags().BoolVarP(&Flags.DryRun, "dry-run", "n", false, "show what would be written without writing anything")
+	Command.Flags().StringVar(&Flags.Times, "timestamps-out", "", "write a vpktimes file with the modification times of the packed files to the provided path after packing")
+	Command.Flags().BoolVar(&Flags.Unescape, "unescape-names", false, "decode percent-encoded file names (see unpack --escape-names; not the default since file names may legitimately contain %)")
+	root.FlagJSON(Command)
+	root.Command.AddCommand(Command)
+}
+
+func main() {
+	var fsys fs.FS
+	if fi, err := os.Stat(Flags.Path); err == nil && !fi.IsDir() && strings.EqualFold(filepath.Ext(Flags.Path), ".zip") {
+		zr, err := zip.OpenReader(Flags.Path)
+		if err != nil {
+			root.Fatalf("open input zip: %v", err)
+		}
+		defer zr.Close()
+		fsys = zr
+	} else {
+		fsys = os.DirFS(Flags.Path)
+	}
+
+	meta, err := vpkutil.ReadPackMeta(fsys)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			root.Errorf("%s: %v (use the init command to create one)", Flags.Path, err)
+		} else {
+			root.Errorf("%s: %v", Flags.Path, err)
+		}
+		os.Exit(1)
+	}
+
+	type input struct {
+		Name string // in the vpk
+		Path string // relative to the input directory
+		Size int64
+		Time time.Time
+	}
+	var (
+		inputs     []input
+		totalBytes int64
+	)
+	if err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." || (!d.IsDir() && vpkutil.IsPackMetaFile(name)) {
+			return nil
+		}
+		if meta.Skip(name) {
+			if Flags.Verbose {
+				file("ignore", name)
+			}
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		fi, err := fs.Stat(fsys, name) // follow symlinks (e.g., from unpack --link=sym)
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		in := input{name, name, fi.Size(), fi.ModTime()}
+		if Flags.Unescape {
+			in.Name = vpkutil.UnescapePath(name)
+		}
+		inputs = append(inputs, in)
+		totalBytes += fi.Size()
+		return nil
+	}); err != nil {
+		root.Fatalf("list input directory: %v", err)
+	}
+
+	progress := root.Progress("pack", int64(len(inputs)), totalBytes)
+
+	var w *tf2vpk.Writer
+	if Flags.DryRun {
+		w = tf2vpk.NewWriterFunc(func(tf2vpk.ValvePakIndex) (io.Writer, error) {
+			return io.Discard, nil
+		})
+	} else {
+		w = tf2vpk.NewWriter(Flags.VPK)
+	}
+	if err := Flags.Writer(w); err != nil {
+		root.Errorf("%v", err)
+		os.Exit(2)
+	}
+	for i, in := range inputs {
+		if Flags.Verbose && root.Flags.JSON {
+			root.EmitJSON("pack", struct {
+				Path  string `json:"path"`
+				Size  int64  `json:"size"`
+				Index int    `json:"index"`
+				Total int    `json:"total"`
+			}{in.Name, in.Size, i + 1, len(inputs)})
+		} else if Flags.Verbose {
+			fmt.Printf("[%4d/%4d] %s (%s)\n", i+1, len(inputs), in.Name, internal.FormatBytesSI(in.Size))
+		}
+		if err := func() error {
+			f, err := fsys.Open(in.Path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			if Flags.Transform.Match(in.Name) {
+				r, err := Flags.Transform.Apply(in.Name, progress.Reader(f))
+				if err != nil {
+					return err
+				}
+				load, texture := meta.Flags(in.Name)
+				return w.Add(in.Name, load, texture, r)
+			}
+			return w.AddMeta(in.Name, uint64(in.Size), progress.Reader(f), meta)
+		}(); err != nil {
+			w.Abort()
+			root.Fatalf("pack %q: %v", in.Name, err)
+		}
+		progress.AddFiles(1)
+	}
+	if err := w.Close(); err != nil {
+		root.Fatalf("write vpk: %v", err)
+	}
+	progress.Done()
+
+	if Flags.DryRun {
+		dryRun(w.Root)
+		return
+	}
+	if Flags.Times != "" {
+		crc := make(map[string]uint32, len(w.Root.File))
+		for _, f := range w.Root.File {
+			crc[f.Path] = f.CRC32
+		}
+		var vpktimes vpkutil.VPKTimes
+		for _, in := range inputs {
+			if err := vpktimes.Set(in.Name, crc[in.Name], uint64(in.Size), in.Time); err != nil {
+				root.Fatalf("generate vpktimes: %v", err)
+			}
+		}
+		if err := os.WriteFile(Flags.Times, []byte(vpktimes.String()), 0666); err != nil {
+			root.Fatalf("write vpktimes: %v", err)
+		}
+	}
+	if root.Flags.JSON {
+		root.EmitJSON("summary", struct {
+			Files int   `json:"files"`
+			Size  int64 `json:"size"`
+		}{len(w.Root.File), totalBytes})
+	}
+}
+
+// dryRun prints the differences between the existing vpk and the one which
+// would have been written.
+func dryRun(dir tf2vpk.ValvePakDir) {
+	var old tf2vpk.ValvePakDir
+	if f, err := os.Open(Flags.VPK.Resolve(tf2vpk.ValvePakIndexDir)); err == nil {
+		err = old.Deserialize(f)
+		f.Close()
+		if err != nil {
+			root.Fatalf("read existing vpk dir: %v", err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		root.Fatalf("open existing vpk dir: %v", err)
+	}
+
+	files := make(map[string]tf2vpk.ValvePakFile, len(old.File))
+	for _, f := range old.File {
+		files[f.Path] = f
+	}
+	var added, updated, removed int
+	for _, f := range dir.File {
+		if o, ok := files[f.Path]; !ok {
+			file("add", f.Path)
+			added++
+		} else if o.CRC32 != f.CRC32 || o.Size() != f.Size() {
+			file("update", f.Path)
+			updated++
+		}
+		delete(files, f.Path)
+	}
+	for _, f := range old.File {
+		if _, ok := files[f.Path]; ok {
+			file("remove", f.Path)
+			removed++
+		}
+	}
+	if root.Flags.JSON {
+		root.EmitJSON("summary", struct {
+			Files   int    `json:"files"`
+			Added   int    `json:"added"`
+			Updated int    `json:"updated"`
+			Removed int    `json:"removed"`
+			OldSize uint64 `json:"old_size"`
+			NewSize uint64 `json:"new_size"`
+			DryRun  bool   `json:"dry_run"`
+		}{len(dir.File), added, updated, removed, blockBytes(old), blockBytes(dir), true})
+		return
+	}
+	fmt.Printf("%d files (%d added, %d updated, %d removed), %s -> %s\n", len(dir.File), added, updated, removed, internal.FormatBytesSI(int64(blockBytes(old))), internal.FormatBytesSI(int64(blockBytes(dir))))
+}
+
+// file prints an action for a file.
+func file(action, name string) {
+	if root.Flags.JSON {
+		root.EmitJSON(action, struct {
+			Path string `json:"path"`
+		}{name})
+		return
+	}
+	fmt.Printf("%s %s\n", action, name)
+}
+
+// blockBytes returns the total size of the data referenced in each block.
+func blockBytes(dir tf2vpk.ValvePakDir) uint64 {
+	end := map[tf2vpk.ValvePakIndex]uint64{}
+	for _, f := range dir.File {
+		for _, c := range f.Chunk {
+			end[f.Index] = max(end[f.Index], c.Offset+c.CompressedSize)
+		}
+	}
+	var n uint64
+	for _, x := range end {
+		n += x
+	}
+	return n
+}
@@ -0,0 +1,255 @@
+package patch
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/internal"
+	"github.com/pg9182/tf2vpk/vpkutil"
+	"github.com/spf13/cobra"
+)
+
+var Command = &cobra.Command{
+	GroupID: root.GroupVPKRepack.ID,
+	Use:     "patch",
+	Short:   "Creates and applies patches between VPK versions",
+}
+
+var CreateFlags struct {
+	VPK     tf2vpk.ValvePakRef
+	New     tf2vpk.ValvePakRef
+	Output  string
+	Verbose bool
+}
+
+var CommandCreate = &cobra.Command{
+	Use:   "create vpk_path new_vpk_path",
+	Short: "Creates a patch transforming a VPK into a newer version",
+	Long: `Creates a patch transforming a VPK into a newer version
+
+The patch contains the new dir index and any chunks which do not exist in the original VPK.
+`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if vpk, err := root.VPK(args[1]); err != nil {
+			root.Errorf("%v", err)
+			os.Exit(2)
+		} else {
+			CreateFlags.New = vpk
+		}
+		create()
+	},
+}
+
+var ApplyFlags struct {
+	VPK     tf2vpk.ValvePakRef
+	Patch   string
+	DryRun  bool
+	Verbose bool
+}
+
+var CommandApply = &cobra.Command{
+	Use:   "apply vpk_path patch_file",
+	Short: "Applies a patch to a VPK",
+	Long: `Applies a patch to a VPK
+
+The patched VPK is written next to the original and every file is checked against its CRC32 before the original VPK is replaced. If anything fails, the original VPK is left untouched.
+
+If patch_file is -, the patch is read from stdin.
+`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ApplyFlags.Patch = args[1]
+		apply()
+	},
+}
+
+func init() {
+	root.ArgVPK(&CreateFlags.VPK, CommandCreate, -1, false, false, false)
+	CommandCreate.Flags().StringVarP(&CreateFlags.Output, "output", "o", "-", "write the patch to a file")
+	CommandCreate.Flags().BoolVarP(&CreateFlags.Verbose, "verbose", "v", false, "show patch statistics")
+	root.FlagJSON(CommandCreate)
+	Command.AddCommand(CommandCreate)
+
+	root.ArgVPK(&ApplyFlags.VPK, CommandApply, -1, false, false, false)
+	CommandApply.Flags().BoolVarP(&ApplyFlags.DryRun, "dry-run", "n", false, "apply and verify the patch without replacing the original vpk")
+	CommandApply.Flags().BoolVarP(&ApplyFlags.Verbose, "verbose", "v", false, "show progress")
+	root.FlagJSON(CommandApply)
+	Command.AddCommand(CommandApply)
+
+	root.Command.AddCommand(Command)
+}
+
+func create() {
+	from, err := root.NewReader(CreateFlags.VPK, false)
+	if err != nil {
+		root.Fatalf("open original vpk: %v", err)
+	}
+	defer from.Close()
+
+	to, err := root.NewReader(CreateFlags.New, false)
+	if err != nil {
+		root.Fatalf("open new vpk: %v", err)
+	}
+	defer to.Close()
+
+	var (
+		w  io.Writer
+		tf *os.File
+	)
+	switch CreateFlags.Output {
+	case "":
+		root.Fatalf("no output file specified")
+	case "-":
+		if root.Flags.JSON {
+			root.Errorf("--json requires --output")
+			os.Exit(2)
+		}
+		w = os.Stdout
+	default:
+		// write to a temp file so an existing patch isn't truncated if it fails
+		tf, err = os.CreateTemp(filepath.Dir(CreateFlags.Output), ".vpkpatch*")
+		if err != nil {
+			root.Fatalf("create output file: %v", err)
+		}
+		defer os.Remove(tf.Name())
+		defer tf.Close()
+		w = tf
+	}
+
+	stats, err := vpkutil.CreatePatch(w, from, to)
+	if err != nil {
+		root.Errorf("create patch: %v", err)
+		if tf != nil {
+			tf.Close()
+			os.Remove(tf.Name())
+		}
+		os.Exit(1)
+	}
+	if tf != nil {
+		err := tf.Chmod(0644) // CreateTemp uses 0600
+		if err == nil {
+			err = tf.Close()
+		}
+		if err == nil {
+			err = os.Rename(tf.Name(), CreateFlags.Output)
+		}
+		if err != nil {
+			root.Errorf("write output file %q: %v", CreateFlags.Output, err)
+			os.Remove(tf.Name())
+			os.Exit(1)
+		}
+	}
+	if root.Flags.JSON {
+		root.EmitJSON("summary", struct {
+			Files      int    `json:"files"`
+			CopyChunks int    `json:"copy_chunks"`
+			CopyBytes  uint64 `json:"copy_bytes"`
+			DataChunks int    `json:"data_chunks"`
+			DataBytes  uint64 `json:"data_bytes"`
+		}{stats.Files, stats.CopyChunks, stats.CopyBytes, stats.DataChunks, stats.DataBytes})
+	} else if CreateFlags.Verbose {
+		fmt.Fprintf(os.Stderr, "%d files, %d chunks reused (%s), %d chunks included (%s)\n", stats.Files, stats.CopyChunks, internal.FormatBytesSI(int64(stats.CopyBytes)), stats.DataChunks, internal.FormatBytesSI(int64(stats.DataBytes)))
+	}
+}
+
+func apply() {
+	var pr io.Reader
+	switch ApplyFlags.Patch {
+	case "-":
+		pr = os.Stdin
+	default:
+		f, err := os.Open(ApplyFlags.Patch)
+		if err != nil {
+			root.Fatalf("open patch: %v", err)
+		}
+		defer f.Close()
+		pr = f
+	}
+
+	from, err := root.NewReader(ApplyFlags.VPK, false)
+	if err != nil {
+		root.Fatalf("open vpk: %v", err)
+	}
+	defer from.Close()
+
+	dir := ApplyFlags.VPK.Path
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.MkdirTemp(dir, ".vpkpatch*")
+	if err != nil {
+		root.Fatalf("create staging directory: %v", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	staged := ApplyFlags.VPK
+	staged.Path = tmp
+
+	if ApplyFlags.Verbose {
+		root.Infof("applying patch to %s", ApplyFlags.VPK.Resolve(tf2vpk.ValvePakIndexDir))
+	}
+	w := tf2vpk.NewWriter(staged)
+	if err := vpkutil.ApplyPatch(w, pr, from); err != nil {
+		w.Abort()
+		root.Errorf("%v", err)
+		os.RemoveAll(tmp)
+		os.Exit(1)
+	}
+	if err := w.Close(); err != nil {
+		root.Errorf("write patched vpk: %v", err)
+		os.RemoveAll(tmp)
+		os.Exit(1)
+	}
+
+	if ApplyFlags.Verbose {
+		root.Infof("verifying %d files", len(w.Root.File))
+	}
+	if err := verify(staged); err != nil {
+		root.Errorf("verify patched vpk: %v", err)
+		os.RemoveAll(tmp)
+		os.Exit(1)
+	}
+
+	if root.Flags.JSON {
+		defer root.EmitJSON("summary", struct {
+			Files  int  `json:"files"`
+			DryRun bool `json:"dry_run"`
+		}{len(w.Root.File), ApplyFlags.DryRun})
+	}
+	if ApplyFlags.DryRun {
+		if ApplyFlags.Verbose {
+			root.Infof("patch applied successfully (dry run, original vpk not replaced)")
+		}
+		return
+	}
+
+	from.Close()
+	if err := vpkutil.ReplaceVPK(ApplyFlags.VPK, staged); err != nil {
+		root.Errorf("%v", err)
+		os.RemoveAll(tmp)
+		os.Exit(1)
+	}
+	if ApplyFlags.Verbose {
+		root.Infof("replaced %s", ApplyFlags.VPK.Resolve(tf2vpk.ValvePakIndexDir))
+	}
+}
+
+func verify(vpk tf2vpk.ValvePakRef) error {
+	r, err := root.NewReader(vpk, false)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, f := range r.Root.File {
+		if err := r.VerifyFileParallel(f, root.Flags.Jobs); err != nil {
+			return fmt.Errorf("%s: %w", f.Path, err)
+		}
+	}
+	return nil
+}
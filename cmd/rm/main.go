@@ -28,7 +28,7 @@ var Command = &cobra.Command{
 	Short:   "Delete files or directories from a VPK",
 	Long: `Delete files or directories from a VPK
 
-Does not remove the unused chunks; use the gc command to do that afterwards.
+Does not remove the unused chunks; use the compact or gc commands to do that afterwards.
 `,
 	Args: cobra.MinimumNArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
@@ -42,38 +42,42 @@ func init() {
 	Command.Flags().BoolVarP(&Flags.Force, "force", "f", false, "ignore non-existent files")
 	Command.Flags().BoolVarP(&Flags.DryRun, "dry-run", "n", false, "do not write changes")
 	Command.Flags().BoolVarP(&Flags.Verbose, "verbose", "v", false, "print information about each processed file")
+	root.FlagJSON(Command)
 	root.Command.AddCommand(Command)
 }
 
 func main() {
 	var failed int
-	if err := vpkutil.UpdateDir(Flags.VPK, Flags.DryRun, func(root *tf2vpk.ValvePakDir) error {
+	if err := vpkutil.UpdateDir(Flags.VPK, Flags.DryRun, func(dir *tf2vpk.ValvePakDir) error {
 		for _, name := range Flags.Files {
 			if err := func() error {
-				orig := len(root.File)
-				root.File = slices.DeleteFunc(root.File, func(f tf2vpk.ValvePakFile) bool {
+				orig := len(dir.File)
+				dir.File = slices.DeleteFunc(dir.File, func(f tf2vpk.ValvePakFile) bool {
 					match := name == "/" || strings.HasPrefix(f.Path+"/", name+"/")
 					if match {
-						if Flags.Verbose {
+						if Flags.Verbose && root.Flags.JSON {
+							root.EmitJSON("delete", struct {
+								Path string `json:"path"`
+							}{f.Path})
+						} else if Flags.Verbose {
 							fmt.Printf("delete %s\n", f.Path)
 						}
 					}
 					return match
 				})
-				if orig == len(root.File) && !Flags.Force {
+				if orig == len(dir.File) && !Flags.Force {
 					return fs.ErrNotExist
 				}
 				return nil
 			}(); err != nil {
-				fmt.Fprintf(os.Stderr, "error: delete %q: %v\n", name, err)
+				root.Errorf("delete %q: %v", name, err)
 				failed++
 			}
 		}
 
 		return nil
 	}); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+		root.Fatalf("%v", err)
 	}
 	if failed != 0 {
 		os.Exit(1)
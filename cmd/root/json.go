@@ -0,0 +1,92 @@
+package root
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/spf13/cobra"
+)
+
+// jsonAnnotation marks commands which support --json.
+const jsonAnnotation = "tf2vpk-json"
+
+// JSONRecord is a line written to stdout by EmitJSON.
+type JSONRecord struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
+var jsonMu sync.Mutex
+
+// FlagJSON marks cmd as supporting --json, in which case it must only write
+// records to stdout using EmitJSON. Other commands (the ones which write file
+// contents to stdout or are interactive) fail with a usage error if --json is
+// used.
+func FlagJSON(cmd *cobra.Command) {
+	if cmd.Annotations == nil {
+		cmd.Annotations = map[string]string{}
+	}
+	cmd.Annotations[jsonAnnotation] = "true"
+}
+
+// checkJSON returns an error if --json was used for a command which doesn't
+// support it.
+func checkJSON(cmd *cobra.Command) error {
+	if Flags.JSON && cmd.Annotations[jsonAnnotation] == "" {
+		return fmt.Errorf("--json is not supported by %s", cmd.CommandPath())
+	}
+	return nil
+}
+
+// EmitJSON writes a record of the specified type to stdout as a JSON line. It
+// is safe for concurrent use.
+func EmitJSON(typ string, v any) {
+	buf, err := json.Marshal(JSONRecord{typ, v})
+	if err != nil {
+		panic(fmt.Errorf("marshal %s record: %w", typ, err)) // the records are our own types
+	}
+	jsonMu.Lock()
+	defer jsonMu.Unlock()
+	os.Stdout.Write(append(buf, '\n'))
+}
+
+// jsonMessage is the data of message, warning, and error records.
+type jsonMessage struct {
+	Message string `json:"message"`
+}
+
+// Infof writes an informational message to stderr, or as a message record
+// with --json.
+func Infof(format string, a ...any) {
+	if Flags.JSON {
+		EmitJSON("message", jsonMessage{fmt.Sprintf(format, a...)})
+		return
+	}
+	fmt.Fprintf(os.Stderr, format+"\n", a...)
+}
+
+// Warnf writes a warning to stderr, or as a warning record with --json.
+func Warnf(format string, a ...any) {
+	if Flags.JSON {
+		EmitJSON("warning", jsonMessage{fmt.Sprintf(format, a...)})
+		return
+	}
+	fmt.Fprintf(os.Stderr, "warning: "+format+"\n", a...)
+}
+
+// Errorf writes an error to stderr, or as an error record with --json.
+func Errorf(format string, a ...any) {
+	if Flags.JSON {
+		EmitJSON("error", jsonMessage{fmt.Sprintf(format, a...)})
+		return
+	}
+	fmt.Fprintf(os.Stderr, "error: "+format+"\n", a...)
+}
+
+// Fatalf is like Errorf, but exits with status 1 afterwards.
+func Fatalf(format string, a ...any) {
+	Errorf(format, a...)
+	os.Exit(1)
+}
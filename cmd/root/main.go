@@ -3,36 +3,52 @@ package root
 import (
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path"
-	"runtime"
 	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/pg9182/tf2vpk"
 	"github.com/pg9182/tf2vpk/internal"
+	"github.com/pg9182/tf2vpk/vpkutil"
 	"github.com/spf13/cobra"
 )
 
 var Flags struct {
 	VPKDir    string
 	VPKPrefix string
-	Threads   int
+	Game      string
+	Jobs      int
+	Progress  bool
+	JSON      bool
+	MemLimit  byteSizeValue
 }
 
 var Command = &cobra.Command{
 	Use:   "tf2vpk",
 	Short: "Manipulates Respawn VPK archives",
-	PreRun: func(cmd *cobra.Command, args []string) {
-		if Flags.Threads < 0 {
-			Flags.Threads = 0
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if err := checkJSON(cmd); err != nil {
+			Errorf("%v", err)
+			os.Exit(2)
 		}
-		if Flags.Threads > runtime.NumCPU() {
-			runtime.GOMAXPROCS(Flags.Threads)
+		if err := jobs.Apply(); err != nil {
+			Errorf("%v", err)
+			os.Exit(2)
+		}
+		Flags.Jobs = *jobs.Jobs
+		if Flags.MemLimit > 0 {
+			decompressMemory = tf2vpk.NewDecompressMemory(int64(Flags.MemLimit))
 		}
 	},
 }
 
+// jobs is the --jobs flag, which is copied into Flags.Jobs before running a
+// command.
+var jobs vpkutil.CLIJobs
+
 var GroupVPKRead = &cobra.Group{
 	ID:    "vpk-read",
 	Title: "Read Commands:",
@@ -51,16 +67,111 @@ var GroupVPKRepack = &cobra.Group{
 func init() {
 	Command.AddGroup(GroupVPKRead, GroupVPKWrite, GroupVPKRepack)
 	Command.PersistentFlags().StringVar(&Flags.VPKDir, "vpk-dir", "", "set the vpk directory, and use vpk names instead of paths")
-	Command.PersistentFlags().StringVar(&Flags.VPKPrefix, "vpk-prefix", "english", "the vpk locale prefix to use")
-	Command.PersistentFlags().IntVarP(&Flags.Threads, "threads", "j", runtime.NumCPU(), "number of threads to use for decompression (-1 to disable, default is cpu count)")
+	Command.PersistentFlags().StringVar(&Flags.Game, "game", "", "find the vpk directory of an installed game (tf2), and use vpk names instead of paths (like --vpk-dir)")
+	Command.PersistentFlags().StringVar(&Flags.VPKPrefix, "vpk-prefix", "english", "the vpk locale prefix to use (empty to detect it)")
+	jobs = vpkutil.NewCLIJobs(Command.PersistentFlags())
+	Command.PersistentFlags().Var(&Flags.MemLimit, "memory-limit", "limit the memory used for reading and decompressing chunks ahead of time (e.g., 256MiB; 0 for no limit)")
+	Command.PersistentFlags().BoolVarP(&Flags.Progress, "progress", "P", false, "report progress to stderr (redrawn on terminals, otherwise as periodic key=value lines)")
+	Command.PersistentFlags().BoolVar(&Flags.JSON, "json", false, "write the results (and progress and warnings) to stdout as json lines, for commands supporting it")
+}
+
+// Progress starts reporting progress for op if enabled. The returned value may
+// be nil, which is safe to use. With --json, progress records are written
+// instead.
+func Progress(op string, totalFiles, totalBytes int64) *internal.Progress {
+	if !Flags.Progress {
+		return nil
+	}
+	if Flags.JSON {
+		return internal.NewProgressFunc(func(st internal.ProgressStatus) {
+			EmitJSON("progress", st)
+		}, op, totalFiles, totalBytes)
+	}
+	return internal.NewProgress(os.Stderr, op, totalFiles, totalBytes)
+}
+
+// FlagAllowMissing adds a flag for commands which only need the dir index (or
+// only some of the blocks) to use a partial reader (see NewReader).
+func FlagAllowMissing(out *bool, cmd *cobra.Command) {
+	cmd.Flags().BoolVar(out, "allow-missing", false, "allow vpk block files to be missing, only failing when reading files stored in them")
+}
+
+// decompressMemory is shared by the readers opened with NewReader to limit
+// their combined memory usage (see --memory-limit).
+var decompressMemory *tf2vpk.DecompressMemory
+
+// NewReader opens a reader for vpk, limiting the memory used for reading ahead
+// using --memory-limit. If allowMissing is true, missing blocks are tolerated,
+// and a warning listing them is written (see Warnf).
+func NewReader(vpk tf2vpk.ValvePakRef, allowMissing bool) (*tf2vpk.Reader, error) {
+	if !allowMissing {
+		r, err := tf2vpk.NewReader(vpk)
+		if err != nil {
+			return nil, err
+		}
+		r.DecompressMemory = decompressMemory
+		return r, nil
+	}
+	r, err := tf2vpk.NewPartialReader(vpk)
+	if err != nil {
+		return nil, err
+	}
+	r.DecompressMemory = decompressMemory
+	if m := r.MissingBlocks(); len(m) != 0 {
+		s := make([]string, len(m))
+		for i, x := range m {
+			s[i] = x.String()
+		}
+		Warnf("missing vpk blocks: %s", strings.Join(s, ", "))
+	}
+	return r, nil
+}
+
+// GameVPKDir finds the vpk directory for --game, using the first install found.
+func GameVPKDir() (string, error) {
+	g, ok := vpkutil.LookupGame(Flags.Game)
+	if !ok {
+		var ns []string
+		for _, g := range vpkutil.Games {
+			ns = append(ns, g.Name)
+		}
+		return "", fmt.Errorf("unknown game %q (expected one of: %s)", Flags.Game, strings.Join(ns, ", "))
+	}
+	is := vpkutil.FindGame(g)
+	if len(is) == 0 {
+		return "", fmt.Errorf("no %s install found (use --vpk-dir to specify it manually)", g.Name)
+	}
+	return is[0].VPKDir(), nil
 }
 
 // VPK resolves the provided name to a VPK.
 func VPK(name string) (tf2vpk.ValvePakRef, error) {
+	if Flags.Game != "" && Command.PersistentFlags().Changed("vpk-dir") {
+		return tf2vpk.ValvePakRef{}, fmt.Errorf("--game and --vpk-dir cannot be used together")
+	}
+	if Flags.Game != "" && Flags.VPKDir == "" {
+		dir, err := GameVPKDir()
+		if err != nil {
+			return tf2vpk.ValvePakRef{}, err
+		}
+		Flags.VPKDir = dir
+	}
 	if Flags.VPKDir != "" {
 		if name == "" {
 			return tf2vpk.ValvePakRef{}, fmt.Errorf("invalid vpk name %q", name)
 		}
+		if Flags.VPKPrefix == "" {
+			sets, err := tf2vpk.ScanValvePakSets(Flags.VPKDir)
+			if err != nil {
+				return tf2vpk.ValvePakRef{}, fmt.Errorf("detect vpk prefix: %w", err)
+			}
+			for _, s := range sets {
+				if s.Name == name {
+					return s.Default(), nil
+				}
+			}
+			return tf2vpk.ValvePakRef{}, fmt.Errorf("detect vpk prefix: no dir index found for vpk %q", name)
+		}
 		return tf2vpk.ValvePakRef{
 			Path:   Flags.VPKDir,
 			Prefix: Flags.VPKPrefix,
@@ -115,20 +226,7 @@ func ArgVPK(out *tf2vpk.ValvePakRef, cmd *cobra.Command, i int, multi, dirs, fil
 	// add the argument completion
 	if validArgsFunction, next := func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 		if len(args) == 0 {
-			if Flags.VPKDir == "" {
-				return []string{tf2vpk.Ext}, cobra.ShellCompDirectiveFilterFileExt
-			}
-			ds, err := os.ReadDir(Flags.VPKDir)
-			if err != nil {
-				return nil, cobra.ShellCompDirectiveError
-			}
-			var ns []string
-			for _, d := range ds {
-				if n, idx, err := tf2vpk.SplitName(d.Name(), Flags.VPKPrefix); err == nil && idx == tf2vpk.ValvePakIndexDir {
-					ns = append(ns, n)
-				}
-			}
-			return ns, cobra.ShellCompDirectiveNoFileComp
+			return ArgVPKNameCompletions()
 		}
 		if i > 0 && len(args) >= i && (multi || len(args) == i) {
 			return ArgVPKFileCompletions(args, toComplete, dirs, files)
@@ -146,6 +244,36 @@ func ArgVPK(out *tf2vpk.ValvePakRef, cmd *cobra.Command, i int, multi, dirs, fil
 	}
 }
 
+// ArgVPKNameCompletions returns completions for a vpk name (with --vpk-dir) or
+// path.
+func ArgVPKNameCompletions() ([]string, cobra.ShellCompDirective) {
+	if Flags.VPKDir == "" {
+		return []string{tf2vpk.Ext}, cobra.ShellCompDirectiveFilterFileExt
+	}
+	if Flags.VPKPrefix == "" {
+		sets, err := tf2vpk.ScanValvePakSets(Flags.VPKDir)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		var ns []string
+		for _, s := range sets {
+			ns = append(ns, s.Name)
+		}
+		return ns, cobra.ShellCompDirectiveNoFileComp
+	}
+	ds, err := os.ReadDir(Flags.VPKDir)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+	var ns []string
+	for _, d := range ds {
+		if n, idx, err := tf2vpk.SplitName(d.Name(), Flags.VPKPrefix); err == nil && idx == tf2vpk.ValvePakIndexDir {
+			ns = append(ns, n)
+		}
+	}
+	return ns, cobra.ShellCompDirectiveNoFileComp
+}
+
 // ArgVPKFileCompletions returns file completions for a VPK.
 func ArgVPKFileCompletions(args []string, toComplete string, dirs, files bool) ([]string, cobra.ShellCompDirective) {
 	vpk, err := VPK(args[0])
@@ -199,8 +327,135 @@ func ArgVPKFileCompletions(args []string, toComplete string, dirs, files bool) (
 	return cs, cobra.ShellCompDirectiveNoFileComp
 }
 
-// FlagIncludeExclude adds --exclude and --include flags, returning a function
-// checking if a file is excluded.
+// FlagWriter adds flags for VPK writer options, returning a function which
+// applies them to a Writer. If compress is true, flags for commands which
+// compress new files are also added.
+func FlagWriter(out *func(*tf2vpk.Writer) error, cmd *cobra.Command, compress bool) {
+	var (
+		BlockSize   byteSizeValue
+		Align       byteSizeValue
+		SingleFile  bool
+		Store       *[]string
+		CompressAll *bool
+		StoreAll    *bool
+		Preload     *[]string
+		ChunkSize   byteSizeValue
+	)
+	cmd.Flags().Var(&BlockSize, "block-size", "start a new block once the current one reaches this size (e.g., 2GiB; 0 to write a single block)")
+	cmd.Flags().Var(&Align, "align", "pad the block data so each chunk starts at a multiple of this size (e.g., 4KiB for mmap or direct I/O)")
+	cmd.Flags().BoolVar(&SingleFile, "single-file", false, "store the chunk data in the dir index file instead of a separate block")
+	if compress {
+		Store = cmd.Flags().StringSlice("store", nil, "store files or directories matching the provided globs without compressing them")
+		CompressAll = cmd.Flags().Bool("compress-all", false, "compress files which are already compressed (e.g., bik, png) instead of storing them as-is")
+		StoreAll = cmd.Flags().Bool("store-all", false, "store all files without compressing them (much faster to pack, but larger; useful for development builds)")
+		cmd.Flags().Var(&ChunkSize, "chunk-size", "split files into chunks of this uncompressed size (e.g., 256KiB; smaller chunks are faster to read partially, but compress worse; default and maximum 1MiB)")
+		Preload = cmd.Flags().StringSlice("preload", nil, "store the start of files matching a glob in the dir index as preload data, as glob=size (e.g., materials=512; the first matching glob is used)")
+	}
+	*out = func(w *tf2vpk.Writer) error {
+		w.MaxBlockSize = uint64(BlockSize)
+		w.Alignment = uint64(Align)
+		if SingleFile {
+			if BlockSize != 0 {
+				return fmt.Errorf("--block-size cannot be used with --single-file")
+			}
+			if err := w.SetBlock(tf2vpk.ValvePakIndexDir); err != nil {
+				return err
+			}
+		}
+		if compress {
+			for _, x := range *Store {
+				if _, err := path.Match(x, ""); err != nil {
+					return fmt.Errorf("invalid --store glob %q: %w", x, err)
+				}
+			}
+			if uint64(ChunkSize) > tf2vpk.ValvePakMaxChunkUncompressedSize {
+				return fmt.Errorf("invalid --chunk-size: must be at most %d bytes", tf2vpk.ValvePakMaxChunkUncompressedSize)
+			}
+			w.ChunkSize = uint64(ChunkSize)
+			w.Workers = Flags.Jobs
+			if *StoreAll {
+				if *CompressAll {
+					return fmt.Errorf("--store-all cannot be used with --compress-all")
+				}
+				w.Compression = tf2vpk.StoreCompression
+			} else {
+				w.Compression = func(name string) tf2vpk.CompressionMode {
+					for _, x := range *Store {
+						if m, _ := internal.MatchGlobParents(x, name); m {
+							return tf2vpk.CompressionStore
+						}
+					}
+					if *CompressAll {
+						return tf2vpk.CompressionAuto
+					}
+					return tf2vpk.DefaultCompression(name)
+				}
+			}
+			type preload struct {
+				Glob string
+				Size int
+			}
+			var preloads []preload
+			for _, x := range *Preload {
+				glob, size, ok := strings.Cut(x, "=")
+				if !ok {
+					return fmt.Errorf("invalid --preload %q: expected glob=size", x)
+				}
+				if _, err := path.Match(glob, ""); err != nil {
+					return fmt.Errorf("invalid --preload glob %q: %w", glob, err)
+				}
+				n, err := internal.ParseBytes(size)
+				if err != nil {
+					return fmt.Errorf("invalid --preload size %q: %w", size, err)
+				}
+				if n > math.MaxUint16 {
+					return fmt.Errorf("invalid --preload size %q: must be at most %d bytes", size, math.MaxUint16)
+				}
+				preloads = append(preloads, preload{glob, int(n)})
+			}
+			if len(preloads) != 0 {
+				w.Preload = func(name string) int {
+					for _, x := range preloads {
+						if m, _ := internal.MatchGlobParents(x.Glob, name); m {
+							return x.Size
+						}
+					}
+					return 0
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// ByteSizeVar adds a flag accepting a size in bytes with an optional unit
+// (e.g., 256MiB).
+func ByteSizeVar(cmd *cobra.Command, p *uint64, name string, value uint64, usage string) {
+	*p = value
+	cmd.Flags().Var((*byteSizeValue)(p), name, usage)
+}
+
+type byteSizeValue uint64
+
+func (b *byteSizeValue) Set(s string) error {
+	n, err := internal.ParseBytes(s)
+	if err != nil {
+		return err
+	}
+	*b = byteSizeValue(n)
+	return nil
+}
+
+func (b *byteSizeValue) String() string {
+	return strconv.FormatUint(uint64(*b), 10)
+}
+
+func (b *byteSizeValue) Type() string {
+	return "size"
+}
+
+// FlagIncludeExclude adds --exclude, --include, and --type flags, returning a
+// function checking if a file is excluded.
 func FlagIncludeExclude(out *func(tf2vpk.ValvePakFile) (bool, error), cmd *cobra.Command, short bool) {
 	var Include, Exclude *[]string
 	var (
@@ -214,7 +469,15 @@ func FlagIncludeExclude(out *func(tf2vpk.ValvePakFile) (bool, error), cmd *cobra
 		Include = cmd.Flags().StringSlice("include", nil, IncludeDoc)
 		Exclude = cmd.Flags().StringSlice("exclude", nil, ExcludeDoc)
 	}
+	Type := cmd.Flags().StringSlice("type", nil, "Only includes files in one of the provided asset categories ("+strings.Join(vpkutil.AssetCategoryNames(), ", ")+")")
 	*out = func(f tf2vpk.ValvePakFile) (bool, error) {
+		if len(*Type) != 0 {
+			if m, err := vpkutil.MatchAssetCategories(*Type, f.Path); err != nil {
+				return false, fmt.Errorf("process types: %w", err)
+			} else if !m {
+				return true, nil
+			}
+		}
 		included := len(*Include) == 0
 		for _, x := range *Include {
 			if m, err := internal.MatchGlobParents(x, f.Path); err != nil {
@@ -235,3 +498,70 @@ func FlagIncludeExclude(out *func(tf2vpk.ValvePakFile) (bool, error), cmd *cobra
 		return !included, nil
 	}
 }
+
+// FlagLoadFlags adds --load-flags, --no-load-flags, and --server flags,
+// returning a function checking if a file is excluded by its load flags.
+func FlagLoadFlags(out *func(tf2vpk.ValvePakFile) (bool, error), cmd *cobra.Command) {
+	var filter vpkutil.LoadFlagsFilter
+	var server bool
+	cmd.Flags().Uint32Var(&filter.All, "load-flags", 0, "Only includes files with all of the provided load flags set (e.g., 0x1 for VISIBLE)")
+	cmd.Flags().Uint32Var(&filter.None, "no-load-flags", 0, "Excludes files with any of the provided load flags set")
+	cmd.Flags().BoolVar(&server, "server", false, "Excludes files a dedicated server doesn't load (currently, textures)")
+	*out = func(f tf2vpk.ValvePakFile) (bool, error) {
+		l := filter
+		if server {
+			l = l.Merge(vpkutil.ServerLoadFlagsFilter)
+		}
+		if skip, err := l.Skip(f); err != nil {
+			return false, fmt.Errorf("process load flags: file %q: %w", f.Path, err)
+		} else {
+			return skip, nil
+		}
+	}
+}
+
+// FlagFileCache adds --file-cache and --file-cache-size flags, returning a
+// function which returns the cache, or nil if it isn't enabled.
+func FlagFileCache(out *func() (*vpkutil.FileCache, error), cmd *cobra.Command) {
+	var (
+		Dir  string
+		Size = byteSizeValue(4 << 30)
+	)
+	cmd.Flags().StringVar(&Dir, "file-cache", "", "cache extracted files in a directory, so they don't need to be decompressed again (if no directory is specified, the user cache directory is used)")
+	cmd.Flags().Lookup("file-cache").NoOptDefVal = "default"
+	cmd.Flags().Var(&Size, "file-cache-size", "remove the least recently used files when the file cache gets larger than this (e.g., 4GiB; 0 for no limit)")
+	*out = func() (*vpkutil.FileCache, error) {
+		switch Dir {
+		case "":
+			return nil, nil
+		case "default":
+			dir, err := vpkutil.DefaultFileCacheDir()
+			if err != nil {
+				return nil, fmt.Errorf("get file cache directory: %w", err)
+			}
+			return vpkutil.NewFileCache(dir, int64(Size)), nil
+		default:
+			return vpkutil.NewFileCache(Dir, int64(Size)), nil
+		}
+	}
+}
+
+// FlagTransform adds a --transform flag for applying transforms to files as they
+// are processed.
+func FlagTransform(out *vpkutil.Transforms, cmd *cobra.Command) {
+	cmd.Flags().Var((*transformValue)(out), "transform", "apply a transform to files matching a glob, as glob=name (can be specified multiple times; available: "+strings.Join(vpkutil.TransformNames(), ", ")+")")
+}
+
+type transformValue vpkutil.Transforms
+
+func (t *transformValue) Set(s string) error {
+	return (*vpkutil.Transforms)(t).Parse(s)
+}
+
+func (t *transformValue) String() string {
+	return ""
+}
+
+func (t *transformValue) Type() string {
+	return "glob=name"
+}
@@ -0,0 +1,267 @@
+package selftest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/adler32"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/spf13/cobra"
+)
+
+var Flags struct {
+	Verbose bool
+}
+
+var Command = &cobra.Command{
+	Use:   "selftest",
+	Short: "Checks that the LZHAM codec works correctly",
+	Long: `Checks that the LZHAM codec works correctly
+
+Known inputs are compressed and decompressed, and the output is compared against the output of a known-good build. If this fails, the build of tf2vpk (or the native LZHAM library) is broken on this platform, and any errors about corrupted VPKs are probably not the fault of the VPKs.
+`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		main()
+	},
+}
+
+func init() {
+	Command.Flags().BoolVarP(&Flags.Verbose, "verbose", "v", false, "show the output of each test")
+	root.FlagJSON(Command)
+	root.Command.AddCommand(Command)
+}
+
+// vector is a known-answer test for the LZHAM codec.
+type vector struct {
+	Name  string
+	Input func() []byte
+
+	// Compressed is the sha256 of the compressed input.
+	Compressed string
+
+	// Adler32 is the checksum of the input, and CRC32 is the other checksum
+	// (which is not of the input). LZHAM stores both at the end of the
+	// compressed output.
+	Adler32 uint32
+	CRC32   uint32
+
+	// Raw is the compressed input, if it is small enough to include.
+	Raw string
+}
+
+var vectors = []vector{
+	{
+		Name:       "small",
+		Input:      func() []byte { return []byte("tf2vpk") },
+		Compressed: "98a656929aeb24c037c3a5dc858dc6fb9a125f5feef906d29b00012889b35aee",
+		Adler32:    0x0831025e,
+		CRC32:      0xc2c27dec,
+		Raw:        "200000505074663276706bc00831025ec2c27dec",
+	},
+	{
+		Name:       "text",
+		Input:      func() []byte { return []byte(strings.Repeat("The quick brown fox jumps over the lazy dog.\n", 64)) },
+		Compressed: "1bdc675988e9d290b0e1d396ddcce93ffbfffcdb565e5be11b95a31abf1dfe65",
+		Adler32:    0xd553047d,
+		CRC32:      0x338a1c37,
+		Raw:        "100000002151a19481c5d5a58dadd88189c9bdddb80337137bb806a756c6f7201c0deecc8e201d39ee3a3ac2eeec03237b215f8052a77426fed8fefed1fee4807efe807e800001ae30c0d553047d338a1c37",
+	},
+	{
+		Name:       "zeros",
+		Input:      func() []byte { return make([]byte, tf2vpk.ValvePakMaxChunkUncompressedSize) },
+		Compressed: "01e182989d2385b3b7e4b3860e3eb9b7bc6d78cec7378f0275237e888ebfa23e",
+		Adler32:    0x00f00001,
+		CRC32:      0xb4aaa61e,
+	},
+	{
+		Name: "random",
+		Input: func() []byte {
+			b := make([]byte, 1<<16)
+			x := uint32(1)
+			for i := range b {
+				x = x*1664525 + 1013904223
+				b[i] = byte(x >> 24)
+			}
+			return b
+		},
+		Compressed: "f30836e0ef1175684eb0542c9bc62df589a9c48300ebd3f316b2601d242acb1f",
+		Adler32:    0x5c0d5b9f,
+		CRC32:      0x8980c6c0,
+	},
+}
+
+func main() {
+	if root.Flags.JSON {
+		root.EmitJSON("codec", struct {
+			Name         string `json:"name"`
+			MaxChunkSize uint64 `json:"max_chunk_size"`
+		}{tf2vpk.CurrentCodec().Name(), tf2vpk.ValvePakMaxChunkUncompressedSize})
+	} else {
+		fmt.Printf("codec: %s\n", tf2vpk.CurrentCodec().Name())
+		fmt.Printf("parameters: dict_size_log2=20 level=uber flags=deterministic_parsing max_chunk_size=%d\n", tf2vpk.ValvePakMaxChunkUncompressedSize)
+	}
+
+	var failed int
+	test := func(name string, fn func() (string, error)) {
+		start := time.Now()
+		msg, err := fn()
+		if root.Flags.JSON {
+			j := testJSON{Name: name, OK: err == nil, Seconds: time.Since(start).Seconds(), Message: msg}
+			if err != nil {
+				j.Error = err.Error()
+				failed++
+			}
+			root.EmitJSON("test", j)
+			return
+		}
+		if err != nil {
+			fmt.Printf("FAIL %s: %v\n", name, err)
+			failed++
+		} else if Flags.Verbose && msg != "" {
+			fmt.Printf("ok   %s (%s): %s\n", name, time.Since(start).Round(time.Microsecond), msg)
+		} else {
+			fmt.Printf("ok   %s (%s)\n", name, time.Since(start).Round(time.Microsecond))
+		}
+	}
+	for _, v := range vectors {
+		test("compress "+v.Name, func() (string, error) {
+			return compress(v)
+		})
+		test("decompress "+v.Name, func() (string, error) {
+			return decompress(v)
+		})
+	}
+	test("vpk round-trip", roundTrip)
+
+	if root.Flags.JSON {
+		root.EmitJSON("summary", struct {
+			Failed int `json:"failed"`
+		}{failed})
+	} else if failed != 0 {
+		fmt.Printf("%d tests failed\n", failed)
+	} else {
+		fmt.Printf("all tests passed\n")
+	}
+	if failed != 0 {
+		os.Exit(1)
+	}
+}
+
+// testJSON is the record written for each test with --json.
+type testJSON struct {
+	Name    string  `json:"name"`
+	OK      bool    `json:"ok"`
+	Seconds float64 `json:"seconds"`
+	Message string  `json:"message,omitempty"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// compress checks that the input compresses to the expected output.
+func compress(v vector) (string, error) {
+	in := v.Input()
+	if adler := adler32.Checksum(in); adler != v.Adler32 {
+		return "", fmt.Errorf("incorrect input: got adler32=%08x, expected %08x", adler, v.Adler32)
+	}
+	out := make([]byte, len(in)+1024)
+	n, err := tf2vpk.CurrentCodec().Compress(out, in)
+	if err != nil {
+		return "", err
+	}
+	out = out[:n]
+	if n < 8 {
+		return "", fmt.Errorf("incorrect output: too short (%d bytes)", n)
+	}
+	if adler, crc := binary.BigEndian.Uint32(out[n-8:]), binary.BigEndian.Uint32(out[n-4:]); adler != v.Adler32 || crc != v.CRC32 {
+		return "", fmt.Errorf("incorrect checksums: got adler32=%08x crc32=%08x, expected adler32=%08x crc32=%08x", adler, crc, v.Adler32, v.CRC32)
+	}
+	if sum := sha256.Sum256(out); hex.EncodeToString(sum[:]) != v.Compressed {
+		return "", fmt.Errorf("incorrect output: got sha256 %x, expected %s", sum, v.Compressed)
+	}
+	return fmt.Sprintf("%d -> %d bytes", len(in), n), nil
+}
+
+// decompress checks that the compressed input decompresses to the input.
+func decompress(v vector) (string, error) {
+	in := v.Input()
+	var src []byte
+	if v.Raw != "" {
+		b, err := hex.DecodeString(v.Raw)
+		if err != nil {
+			panic(err)
+		}
+		src = b
+	} else {
+		// we don't include the larger ones, so compress it ourselves
+		buf := make([]byte, len(in)+1024)
+		n, err := tf2vpk.CurrentCodec().Compress(buf, in)
+		if err != nil {
+			return "", fmt.Errorf("compress: %w", err)
+		}
+		src = buf[:n]
+	}
+	out := make([]byte, len(in))
+	n, err := tf2vpk.CurrentCodec().Decompress(out, src)
+	if err != nil {
+		return "", err
+	}
+	if n != len(in) || !bytes.Equal(out[:n], in) {
+		return "", fmt.Errorf("incorrect output")
+	}
+	return fmt.Sprintf("%d -> %d bytes", len(src), n), nil
+}
+
+// roundTrip writes and reads a VPK in memory.
+func roundTrip() (string, error) {
+	files := map[string][]byte{}
+	for _, v := range vectors {
+		files[v.Name+".bin"] = v.Input()
+	}
+
+	blocks := map[tf2vpk.ValvePakIndex]*bytes.Buffer{}
+	w := tf2vpk.NewWriterFunc(func(i tf2vpk.ValvePakIndex) (io.Writer, error) {
+		b := new(bytes.Buffer)
+		blocks[i] = b
+		return b, nil
+	})
+	for _, v := range vectors {
+		if err := w.Add(v.Name+".bin", 1, 0, bytes.NewReader(files[v.Name+".bin"])); err != nil {
+			return "", fmt.Errorf("write: %w", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("write: %w", err)
+	}
+
+	r, err := tf2vpk.NewReaderFunc(func(i tf2vpk.ValvePakIndex) (io.ReaderAt, error) {
+		b, ok := blocks[i]
+		if !ok {
+			return nil, fs.ErrNotExist
+		}
+		return bytes.NewReader(b.Bytes()), nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("read: %w", err)
+	}
+	defer r.Close()
+
+	var errs []error
+	for name, data := range files {
+		if buf, err := fs.ReadFile(r, name); err != nil {
+			errs = append(errs, fmt.Errorf("read %q: %w", name, err))
+		} else if !bytes.Equal(buf, data) {
+			errs = append(errs, fmt.Errorf("read %q: incorrect contents", name))
+		}
+	}
+	return fmt.Sprintf("%d files, %d byte block", len(files), blocks[0].Len()), errors.Join(errs...)
+}
@@ -0,0 +1,86 @@
+package serve
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// chunkKey identifies a chunk in a served VPK.
+type chunkKey struct {
+	VPK    int
+	Index  tf2vpk.ValvePakIndex
+	Offset uint64
+	Size   uint64
+}
+
+type chunkEntry struct {
+	key  chunkKey
+	data []byte
+}
+
+// chunkCache is a LRU cache of decompressed chunks. It is safe for concurrent
+// use.
+type chunkCache struct {
+	mu    sync.Mutex
+	limit int64
+	size  int64
+	lru   *list.List // of *chunkEntry, most recently used first
+	chunk map[chunkKey]*list.Element
+
+	hits, misses uint64
+}
+
+func newChunkCache(limit int64) *chunkCache {
+	return &chunkCache{
+		limit: limit,
+		lru:   list.New(),
+		chunk: map[chunkKey]*list.Element{},
+	}
+}
+
+// Get gets a chunk from the cache, or loads it using fn. Concurrent loads of
+// the same chunk are not deduplicated.
+func (c *chunkCache) Get(k chunkKey, fn func() ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	if e, ok := c.chunk[k]; ok {
+		c.lru.MoveToFront(e)
+		c.hits++
+		c.mu.Unlock()
+		return e.Value.(*chunkEntry).data, nil
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	b, err := fn()
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > c.limit {
+		return b, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.chunk[k]; !ok {
+		c.chunk[k] = c.lru.PushFront(&chunkEntry{k, b})
+		c.size += int64(len(b))
+		for c.size > c.limit {
+			e := c.lru.Back()
+			x := e.Value.(*chunkEntry)
+			c.lru.Remove(e)
+			delete(c.chunk, x.key)
+			c.size -= int64(len(x.data))
+		}
+	}
+	return b, nil
+}
+
+// Stats returns the number of cached chunks, their total size, and the number
+// of hits and misses so far.
+func (c *chunkCache) Stats() (chunks int, size int64, hits, misses uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.chunk), c.size, c.hits, c.misses
+}
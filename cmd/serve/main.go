@@ -0,0 +1,326 @@
+package serve
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/internal"
+	"github.com/pg9182/tf2vpk/vpkutil"
+	"github.com/spf13/cobra"
+)
+
+var Flags struct {
+	Paths     []string
+	Addr      string
+	CacheSize string
+	FileCache func() (*vpkutil.FileCache, error)
+}
+
+var Command = &cobra.Command{
+	GroupID: root.GroupVPKRead.ID,
+	Use:     "serve vpk_path|dir...",
+	Aliases: []string{"server"},
+	Short:   "Serves the contents of VPKs over HTTP",
+	Long: `Serves the contents of VPKs over HTTP
+
+If a directory is specified, all VPKs in it are served (for the default language). If --vpk-dir is set, VPK names can be used instead of paths, and all VPKs in it are served if none are specified. The VPKs are identified by their name without the language prefix or suffix (e.g., client_mp_angel_city.bsp.pak000).
+
+Endpoints:
+  GET /vpks                     list the vpks (json)
+  GET /vpks/{vpk}               list the files in a vpk (json)
+  GET /vpks/{vpk}/stat/{path}   get information about a file (json)
+  GET /vpks/{vpk}/files/{path}  read a file (supports range and conditional requests)
+  GET /stats                    get chunk cache statistics (json)
+
+Decompressed chunks are cached in memory, so repeated and partial reads of the same files are fast. Since files can be read partially, checksums are not verified (use the verify command for that).
+
+If --file-cache is set, files are extracted to the file cache the first time they are read, and served from there instead. This persists between runs, and files are verified before being cached.
+`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 && root.Flags.VPKDir == "" {
+			return fmt.Errorf("at least one vpk path or directory is required")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		Flags.Paths = args
+		main()
+	},
+}
+
+func init() {
+	Command.Flags().StringVarP(&Flags.Addr, "addr", "a", "localhost:8080", "address to listen on")
+	Command.Flags().StringVar(&Flags.CacheSize, "cache-size", "256MiB", "maximum size of the decompressed chunk cache (0 to disable)")
+	root.FlagFileCache(&Flags.FileCache, Command)
+	root.FlagJSON(Command)
+	root.Command.AddCommand(Command)
+}
+
+type served struct {
+	Name string
+	Ref  tf2vpk.ValvePakRef
+	R    *tf2vpk.Reader
+	File map[string]int // path to index in R.Root.File
+}
+
+type server struct {
+	vpk   []*served
+	name  map[string]int
+	cache *chunkCache
+	files *vpkutil.FileCache // may be nil
+}
+
+func main() {
+	cacheSize, err := internal.ParseBytes(Flags.CacheSize)
+	if err != nil {
+		root.Errorf("invalid --cache-size: %v", err)
+		os.Exit(2)
+	}
+
+	files, err := Flags.FileCache()
+	if err != nil {
+		root.Fatalf("%v", err)
+	}
+
+	var refs []tf2vpk.ValvePakRef
+	if len(Flags.Paths) == 0 {
+		Flags.Paths = []string{root.Flags.VPKDir}
+	}
+	for _, p := range Flags.Paths {
+		if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+			sets, err := tf2vpk.ScanValvePakSets(p)
+			if err != nil {
+				root.Fatalf("scan %q: %v", p, err)
+			}
+			for _, s := range sets {
+				refs = append(refs, s.Default())
+			}
+			continue
+		}
+		ref, err := root.VPK(p)
+		if err != nil {
+			root.Errorf("%v", err)
+			os.Exit(2)
+		}
+		refs = append(refs, ref)
+	}
+
+	s := &server{
+		name:  map[string]int{},
+		cache: newChunkCache(int64(cacheSize)),
+		files: files,
+	}
+	for _, ref := range refs {
+		if _, ok := s.name[ref.Name]; ok {
+			root.Fatalf("more than one vpk named %q", ref.Name)
+		}
+		r, err := root.NewReader(ref, false)
+		if err != nil {
+			root.Fatalf("open vpk %q: %v", ref.Resolve(tf2vpk.ValvePakIndexDir), err)
+		}
+		defer r.Close()
+
+		v := &served{Name: ref.Name, Ref: ref, R: r, File: make(map[string]int, len(r.Root.File))}
+		for i, f := range r.Root.File {
+			v.File[f.Path] = i
+		}
+		s.name[ref.Name] = len(s.vpk)
+		s.vpk = append(s.vpk, v)
+	}
+	if len(s.vpk) == 0 {
+		root.Fatalf("no vpks found")
+	}
+
+	root.Infof("serving %d vpks on http://%s", len(s.vpk), Flags.Addr)
+	srv := &http.Server{
+		Addr:              Flags.Addr,
+		Handler:           s,
+		ReadHeaderTimeout: time.Second * 10,
+	}
+	if err := srv.ListenAndServe(); err != nil {
+		root.Fatalf("%v", err)
+	}
+}
+
+type vpkJSON struct {
+	Name  string `json:"name"`
+	Path  string `json:"path"`
+	Files int    `json:"files"`
+}
+
+type fileJSON struct {
+	Path           string               `json:"path"`
+	Size           uint64               `json:"size"`
+	CompressedSize uint64               `json:"compressed_size"`
+	CRC32          uint32               `json:"crc32"`
+	Block          tf2vpk.ValvePakIndex `json:"block"`
+	Chunks         int                  `json:"chunks"`
+	LoadFlags      uint32               `json:"load_flags"`
+	TextureFlags   uint16               `json:"texture_flags"`
+}
+
+func newFileJSON(f tf2vpk.ValvePakFile) fileJSON {
+	j := fileJSON{
+		Path:   f.Path,
+		CRC32:  f.CRC32,
+		Block:  f.Index,
+		Size:   uint64(len(f.Preload)),
+		Chunks: len(f.Chunk),
+	}
+	for _, c := range f.Chunk {
+		j.Size += c.UncompressedSize
+		j.CompressedSize += c.CompressedSize
+	}
+	j.LoadFlags, _ = f.LoadFlags()
+	j.TextureFlags, _ = f.TextureFlags()
+	return j
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	switch {
+	case p == "vpks" || p == "vpks/":
+		list := make([]vpkJSON, len(s.vpk))
+		for i, v := range s.vpk {
+			list[i] = vpkJSON{v.Name, v.Ref.Resolve(tf2vpk.ValvePakIndexDir), len(v.R.Root.File)}
+		}
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].Name < list[j].Name
+		})
+		writeJSON(w, list)
+
+	case p == "stats":
+		var st struct {
+			Chunks int    `json:"chunks"`
+			Size   int64  `json:"size"`
+			Hits   uint64 `json:"hits"`
+			Misses uint64 `json:"misses"`
+		}
+		st.Chunks, st.Size, st.Hits, st.Misses = s.cache.Stats()
+		writeJSON(w, st)
+
+	case strings.HasPrefix(p, "vpks/"):
+		name, rest, _ := strings.Cut(strings.TrimPrefix(p, "vpks/"), "/")
+		vi, ok := s.name[name]
+		if !ok {
+			http.Error(w, "vpk not found", http.StatusNotFound)
+			return
+		}
+		v := s.vpk[vi]
+		if rest == "" {
+			list := make([]fileJSON, len(v.R.Root.File))
+			for i, f := range v.R.Root.File {
+				list[i] = newFileJSON(f)
+			}
+			writeJSON(w, list)
+			return
+		}
+		op, path, _ := strings.Cut(rest, "/")
+		fi, ok := v.File[path]
+		if !ok || (op != "stat" && op != "files") {
+			http.Error(w, "file not found", http.StatusNotFound)
+			return
+		}
+		f := v.R.Root.File[fi]
+		if op == "stat" {
+			writeJSON(w, newFileJSON(f))
+			return
+		}
+		fr := &fileReaderAt{s: s, vi: vi, f: f}
+		w.Header().Set("ETag", fmt.Sprintf(`"%08x-%d"`, f.CRC32, fr.Size()))
+		if s.files != nil {
+			x, err := s.files.Open(v.R, v.Ref, f)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer x.Close()
+			http.ServeContent(w, r, path, time.Time{}, x)
+			return
+		}
+		http.ServeContent(w, r, path, time.Time{}, io.NewSectionReader(fr, 0, fr.Size()))
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	_ = e.Encode(v)
+}
+
+// fileReaderAt reads a file using the chunk cache.
+type fileReaderAt struct {
+	s     *server
+	vi    int
+	f     tf2vpk.ValvePakFile
+	start []int64 // uncompressed offset of each chunk (after the preload data)
+}
+
+func (fr *fileReaderAt) Size() int64 {
+	if fr.start == nil {
+		n := int64(len(fr.f.Preload))
+		fr.start = make([]int64, len(fr.f.Chunk)+1)
+		for i, c := range fr.f.Chunk {
+			fr.start[i] = n
+			n += int64(c.UncompressedSize)
+		}
+		fr.start[len(fr.f.Chunk)] = n
+	}
+	return fr.start[len(fr.start)-1]
+}
+
+func (fr *fileReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	size := fr.Size()
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+	var n int
+	if off < int64(len(fr.f.Preload)) {
+		x := copy(p, fr.f.Preload[off:])
+		n += x
+		off += int64(x)
+	}
+	for n < len(p) && off < size {
+		i := sort.Search(len(fr.f.Chunk), func(i int) bool {
+			return fr.start[i+1] > off
+		})
+		c := fr.f.Chunk[i]
+		b, err := fr.s.cache.Get(chunkKey{fr.vi, fr.f.Index, c.Offset, c.CompressedSize}, func() ([]byte, error) {
+			block, err := fr.s.vpk[fr.vi].R.OpenBlockRaw(fr.f.Index)
+			if err != nil {
+				return nil, err
+			}
+			return c.ReadAll(block)
+		})
+		if err != nil {
+			return n, fmt.Errorf("read %q: chunk %d: %w", fr.f.Path, i, err)
+		}
+		if int64(len(b)) != int64(c.UncompressedSize) {
+			return n, fmt.Errorf("read %q: chunk %d: got %d bytes, expected %d", fr.f.Path, i, len(b), c.UncompressedSize)
+		}
+		x := copy(p[n:], b[off-fr.start[i]:])
+		n += x
+		off += int64(x)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
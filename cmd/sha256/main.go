@@ -0,0 +1,193 @@
+package sha256
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/vpkutil"
+	"github.com/spf13/cobra"
+)
+
+var Flags struct {
+	VPK            tf2vpk.ValvePakRef
+	Output         string
+	SHA256Sum      bool
+	Check          string
+	IncludeExclude func(tf2vpk.ValvePakFile) (bool, error)
+}
+
+var Command = &cobra.Command{
+	GroupID: root.GroupVPKRead.ID,
+	Use:     "sha256 vpk_path",
+	Short:   "Generates a SHA-256 manifest of the files in a VPK",
+	Long: `Generates a SHA-256 manifest of the files in a VPK
+
+Each line contains the hex SHA-256, the uncompressed size in bytes, and the path of a file, separated by spaces. The CRC32 of each file is checked while it is hashed.
+
+With --sha256sum, the size is omitted and the lines are formatted like the output of sha256sum(1), so the manifest can be checked against an unpacked VPK with sha256sum -c.
+
+With --check, the files listed in a manifest previously written by this command (without --sha256sum) are checked against the VPK instead, printing a line for each file which is missing or has a different size or SHA-256. The exit status is 1 if any file does not match.
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		main()
+	},
+}
+
+func init() {
+	root.ArgVPK(&Flags.VPK, Command, -1, false, false, false)
+	Command.Flags().StringVarP(&Flags.Output, "output", "o", "-", "write the manifest to a file")
+	Command.Flags().BoolVar(&Flags.SHA256Sum, "sha256sum", false, "output in sha256sum format (without sizes)")
+	Command.Flags().StringVarP(&Flags.Check, "check", "c", "", "check the vpk against a manifest instead of writing one")
+	Command.MarkFlagsMutuallyExclusive("check", "sha256sum")
+	Command.MarkFlagsMutuallyExclusive("check", "output")
+	root.FlagIncludeExclude(&Flags.IncludeExclude, Command, true)
+	root.FlagJSON(Command)
+	root.Command.AddCommand(Command)
+}
+
+func main() {
+	r, err := root.NewReader(Flags.VPK, false)
+	if err != nil {
+		root.Fatalf("open vpk: %v", err)
+	}
+	defer r.Close()
+
+	if Flags.Check != "" {
+		check(r)
+		return
+	}
+
+	var files []tf2vpk.ValvePakFile
+	var total int64
+	for _, f := range r.Root.File {
+		if skip, err := Flags.IncludeExclude(f); err != nil {
+			root.Fatalf("%v", err)
+		} else if !skip {
+			files = append(files, f)
+			total += int64(f.Size())
+		}
+	}
+
+	out := os.Stdout
+	if Flags.Output != "-" {
+		f, err := os.Create(Flags.Output)
+		if err != nil {
+			root.Fatalf("create output file: %v", err)
+		}
+		out = f
+	}
+	bw := bufio.NewWriter(out)
+
+	progress := root.Progress("sha256", int64(len(files)), total)
+	for _, f := range files {
+		s, err := vpkutil.SumFile(r, f, root.Flags.Jobs)
+		if err != nil {
+			root.Fatalf("hash vpk file %q: %v", f.Path, err)
+		}
+		if root.Flags.JSON && out == os.Stdout {
+			root.EmitJSON("sum", struct {
+				Path   string `json:"path"`
+				Size   uint64 `json:"size"`
+				SHA256 string `json:"sha256"`
+			}{s.Path, s.Size, hex.EncodeToString(s.SHA256[:])})
+		} else if Flags.SHA256Sum {
+			fmt.Fprintf(bw, "%s  %s\n", hex.EncodeToString(s.SHA256[:]), s.Path)
+		} else {
+			fmt.Fprintln(bw, s.String())
+		}
+		progress.AddBytes(int64(s.Size))
+		progress.AddFiles(1)
+	}
+	progress.Done()
+
+	if err := bw.Flush(); err != nil {
+		root.Fatalf("write manifest: %v", err)
+	}
+	if out != os.Stdout {
+		if err := out.Close(); err != nil {
+			root.Fatalf("write manifest: %v", err)
+		}
+		if root.Flags.JSON {
+			root.EmitJSON("summary", struct {
+				Output string `json:"output"`
+				Files  int    `json:"files"`
+			}{Flags.Output, len(files)})
+		}
+	}
+}
+
+func check(r *tf2vpk.Reader) {
+	mf, err := os.Open(Flags.Check)
+	if err != nil {
+		root.Fatalf("open manifest: %v", err)
+	}
+	sums, err := vpkutil.ParseSHA256Sums(mf)
+	mf.Close()
+	if err != nil {
+		root.Fatalf("read manifest: %v", err)
+	}
+
+	files := map[string]tf2vpk.ValvePakFile{}
+	for _, f := range r.Root.File {
+		files[f.Path] = f
+	}
+
+	var (
+		checks []vpkutil.SHA256Sum
+		total  int64
+		failed int
+	)
+	for _, exp := range sums {
+		f, ok := files[exp.Path]
+		if !ok {
+			mismatch(exp.Path, "missing")
+			failed++
+			continue
+		}
+		if skip, err := Flags.IncludeExclude(f); err != nil {
+			root.Fatalf("%v", err)
+		} else if !skip {
+			checks = append(checks, exp)
+			total += int64(f.Size())
+		}
+	}
+
+	progress := root.Progress("sha256", int64(len(checks)), total)
+	for _, exp := range checks {
+		s, err := vpkutil.SumFile(r, files[exp.Path], root.Flags.Jobs)
+		if err != nil {
+			root.Fatalf("hash vpk file %q: %v", exp.Path, err)
+		}
+		if s.Size != exp.Size {
+			mismatch(exp.Path, fmt.Sprintf("size %d, expected %d", s.Size, exp.Size))
+			failed++
+		} else if s.SHA256 != exp.SHA256 {
+			mismatch(exp.Path, fmt.Sprintf("sha256 %x, expected %x", s.SHA256, exp.SHA256))
+			failed++
+		}
+		progress.AddBytes(int64(s.Size))
+		progress.AddFiles(1)
+	}
+	progress.Done()
+
+	if failed != 0 {
+		root.Fatalf("%d of %d files do not match", failed, len(sums))
+	}
+}
+
+// mismatch prints a file which doesn't match the manifest.
+func mismatch(name, reason string) {
+	if root.Flags.JSON {
+		root.EmitJSON("mismatch", struct {
+			Path   string `json:"path"`
+			Reason string `json:"reason"`
+		}{name, reason})
+		return
+	}
+	fmt.Printf("%s: %s\n", name, reason)
+}
@@ -0,0 +1,303 @@
+package shell
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/internal"
+	"github.com/pg9182/tf2vpk/vpkutil"
+	"github.com/spf13/cobra"
+)
+
+var Flags struct {
+	VPK tf2vpk.ValvePakRef
+}
+
+var Command = &cobra.Command{
+	GroupID: root.GroupVPKRead.ID,
+	Use:     "shell vpk_path",
+	Short:   "Opens a command shell for exploring the contents of a VPK",
+	Long: `Opens a command shell for exploring the contents of a VPK
+
+Reads commands (like ls, cd, cat, and get) from stdin, one per line, for navigating the VPK like a filesystem and extracting files from it. Type help at the prompt for a list of commands.
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		main()
+	},
+}
+
+func init() {
+	root.ArgVPK(&Flags.VPK, Command, -1, false, false, false)
+	root.Command.AddCommand(Command)
+}
+
+const previewLimit = 64 * 1024
+
+type shell struct {
+	r   *tf2vpk.Reader
+	cwd string
+	out io.Writer
+}
+
+var commands = []struct {
+	Name string
+	Args string
+	Help string
+	Fn   func(*shell, []string) error
+}{
+	{"ls", "[dir]", "list a directory (size, compressed size, load flags, texture flags, name)", (*shell).ls},
+	{"cd", "dir", "change the current directory", (*shell).cd},
+	{"pwd", "", "print the current directory", (*shell).pwd},
+	{"info", "file", "show metadata for a file", (*shell).info},
+	{"cat", "file", "preview the start of a text file", (*shell).cat},
+	{"get", "file|dir [out_path]", "extract a file or directory to the filesystem", (*shell).get},
+	{"help", "", "show this help", nil},
+	{"exit", "", "exit the shell", nil},
+}
+
+func main() {
+	r, err := root.NewReader(Flags.VPK, false)
+	if err != nil {
+		root.Fatalf("open vpk: %v", err)
+	}
+	defer r.Close()
+
+	sh := &shell{r: r, cwd: ".", out: os.Stdout}
+	fmt.Fprintf(sh.out, "%s (%d files), type help for a list of commands\n", Flags.VPK.Resolve(tf2vpk.ValvePakIndexDir), len(r.Root.File))
+
+	sc := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Fprintf(sh.out, "/%s> ", strings.TrimPrefix(sh.cwd, "."))
+		if !sc.Scan() {
+			fmt.Fprintln(sh.out)
+			break
+		}
+		args := strings.Fields(sc.Text())
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "exit" || args[0] == "quit" {
+			break
+		}
+		if args[0] == "help" {
+			sh.help()
+			continue
+		}
+		var found bool
+		for _, c := range commands {
+			if c.Name == args[0] && c.Fn != nil {
+				if err := c.Fn(sh, args[1:]); err != nil {
+					root.Errorf("%s: %v", c.Name, err)
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			root.Errorf("unknown command %q", args[0])
+		}
+	}
+	if err := sc.Err(); err != nil {
+		root.Fatalf("read input: %v", err)
+	}
+}
+
+// resolve resolves p against the current directory, returning a path suitable
+// for fs.FS.
+func (sh *shell) resolve(p string) string {
+	if !strings.HasPrefix(p, "/") {
+		p = sh.cwd + "/" + p
+	}
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+func (sh *shell) file(p string) (tf2vpk.ValvePakFile, error) {
+	name := sh.resolve(p)
+	for _, f := range sh.r.Root.File {
+		if f.Path == name {
+			return f, nil
+		}
+	}
+	return tf2vpk.ValvePakFile{}, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (sh *shell) ls(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("expected at most one argument")
+	}
+	dir := sh.cwd
+	if len(args) == 1 {
+		dir = sh.resolve(args[0])
+	}
+	es, err := fs.ReadDir(sh.r, dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range es {
+		if e.IsDir() {
+			fmt.Fprintf(sh.out, "%9s %9s %8s %4s  %s/\n", "-", "-", "-", "-", e.Name())
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			return err
+		}
+		f := fi.Sys().(tf2vpk.ValvePakFile)
+		load, _ := f.LoadFlags()
+		texture, _ := f.TextureFlags()
+		fmt.Fprintf(sh.out, "%9s %9s %08X %04X  %s\n", internal.FormatBytesSI(fi.Size()), internal.FormatBytesSI(int64(f.CompressedSize())), load, texture, e.Name())
+	}
+	return nil
+}
+
+func (sh *shell) cd(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected one argument")
+	}
+	dir := sh.resolve(args[0])
+	if dir != "." {
+		fi, err := fs.Stat(sh.r, dir)
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return &fs.PathError{Op: "cd", Path: dir, Err: errors.New("not a directory")}
+		}
+	}
+	sh.cwd = dir
+	return nil
+}
+
+func (sh *shell) pwd(args []string) error {
+	fmt.Fprintf(sh.out, "/%s\n", strings.TrimPrefix(sh.cwd, "."))
+	return nil
+}
+
+func (sh *shell) info(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected one argument")
+	}
+	f, err := sh.file(args[0])
+	if err != nil {
+		return err
+	}
+	load, _ := f.LoadFlags()
+	texture, _ := f.TextureFlags()
+	fmt.Fprintf(sh.out, "path:    %s\n", f.Path)
+	fmt.Fprintf(sh.out, "block:   %s\n", f.Index)
+	fmt.Fprintf(sh.out, "crc32:   %08X\n", f.CRC32)
+	fmt.Fprintf(sh.out, "load:    %032b %s\n", load, tf2vpk.DescribeLoadFlags(load))
+	fmt.Fprintf(sh.out, "texture: %016b %s\n", texture, tf2vpk.DescribeTextureFlags(texture))
+	for i, c := range f.Chunk {
+		fmt.Fprintf(sh.out, "chunk %d: offset=%d compressed=%d uncompressed=%d\n", i, c.Offset, c.CompressedSize, c.UncompressedSize)
+	}
+	return nil
+}
+
+func (sh *shell) cat(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected one argument")
+	}
+	f, err := sh.file(args[0])
+	if err != nil {
+		return err
+	}
+	fr, err := sh.r.OpenFileParallel(f, root.Flags.Jobs)
+	if err != nil {
+		return err
+	}
+	buf, err := io.ReadAll(io.LimitReader(fr, previewLimit+1))
+	if err != nil {
+		return err
+	}
+	truncated := len(buf) > previewLimit
+	if truncated {
+		buf = buf[:previewLimit]
+	}
+	if bytes.IndexByte(buf, 0) != -1 {
+		return fmt.Errorf("%s: not a text file", f.Path)
+	}
+	sh.out.Write(buf)
+	if len(buf) != 0 && buf[len(buf)-1] != '\n' {
+		fmt.Fprintln(sh.out)
+	}
+	if truncated {
+		fmt.Fprintf(sh.out, "... (truncated to %s)\n", internal.FormatBytesSI(previewLimit))
+	}
+	return nil
+}
+
+func (sh *shell) get(args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return fmt.Errorf("expected one or two arguments")
+	}
+	name := sh.resolve(args[0])
+	out := "."
+	if len(args) == 2 {
+		out = args[1]
+	}
+	var n int
+	for _, f := range sh.r.Root.File {
+		if name != "." && f.Path != name && !strings.HasPrefix(f.Path, name+"/") {
+			continue
+		}
+		rel := f.Path
+		if name != "." {
+			rel = strings.TrimPrefix(f.Path, path.Dir(name)+"/")
+		}
+		outPath, err := vpkutil.SanitizeJoin(out, rel, false)
+		if err != nil {
+			return fmt.Errorf("extract %q: %w", f.Path, err)
+		}
+		if err := sh.extract(f, outPath); err != nil {
+			return fmt.Errorf("extract %q: %w", f.Path, err)
+		}
+		n++
+	}
+	if n == 0 {
+		return &fs.PathError{Op: "get", Path: name, Err: fs.ErrNotExist}
+	}
+	fmt.Fprintf(sh.out, "extracted %d files to %s\n", n, out)
+	return nil
+}
+
+func (sh *shell) extract(f tf2vpk.ValvePakFile, outPath string) error {
+	if err := os.MkdirAll(filepath.Dir(outPath), 0777); err != nil {
+		return err
+	}
+	fr, err := sh.r.OpenFileParallel(f, root.Flags.Jobs)
+	if err != nil {
+		return err
+	}
+	of, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(of, fr); err != nil {
+		of.Close()
+		os.Remove(outPath)
+		return err
+	}
+	return of.Close()
+}
+
+func (sh *shell) help() {
+	for _, c := range commands {
+		fmt.Fprintf(sh.out, "  %-5s %-20s %s\n", c.Name, c.Args, c.Help)
+	}
+}
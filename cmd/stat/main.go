@@ -0,0 +1,215 @@
+package stat
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/internal"
+	"github.com/pg9182/tf2vpk/vpkutil"
+	"github.com/spf13/cobra"
+)
+
+var Flags struct {
+	VPK            tf2vpk.ValvePakRef
+	HumanReadable  bool
+	Top            int
+	IncludeExclude func(tf2vpk.ValvePakFile) (bool, error)
+	AllowMissing   bool
+}
+
+var Command = &cobra.Command{
+	GroupID: root.GroupVPKRead.ID,
+	Use:     "stat vpk_path",
+	Aliases: []string{"stats"},
+	Short:   "Shows a breakdown of the space used by a VPK",
+	Long: `Shows a breakdown of the space used by a VPK
+
+The dir index format, the largest files, and the sizes by extension, top-level directory, and block are shown. Only the dir index is read, so this is fast even for large VPKs.
+
+The stored size excludes chunks shared with other files. The block utilization is the stored size as a fraction of the size of the block file, which is less than 100% if there is unreferenced data left over from deleting files (see the compact and gc commands).
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		main()
+	},
+}
+
+func init() {
+	root.ArgVPK(&Flags.VPK, Command, -1, false, false, false)
+	root.FlagAllowMissing(&Flags.AllowMissing, Command)
+	Command.Flags().Bool("help", false, "help for "+Command.Name()) // prevent the default short help flag from being set
+	Command.Flags().BoolVarP(&Flags.HumanReadable, "human-readable", "h", false, "show sizes in human-readable form")
+	Command.Flags().IntVarP(&Flags.Top, "top", "n", 10, "number of files, extensions, and directories to show (0 for all)")
+	root.FlagIncludeExclude(&Flags.IncludeExclude, Command, true)
+	root.FlagJSON(Command)
+	root.Command.AddCommand(Command)
+}
+
+type fileStats struct {
+	Path             string               `json:"path"`
+	Block            tf2vpk.ValvePakIndex `json:"block"`
+	UncompressedSize uint64               `json:"uncompressed_size"`
+	CompressedSize   uint64               `json:"compressed_size"`
+}
+
+type headerStats struct {
+	Format       string `json:"format"`
+	MajorVersion uint16 `json:"major_version"`
+	MinorVersion uint16 `json:"minor_version"`
+	TreeSize     uint32 `json:"tree_size"`
+	DataSize     uint32 `json:"data_size"`
+}
+
+type blockStats struct {
+	Block     tf2vpk.ValvePakIndex `json:"block"`
+	Size      int64                `json:"size"`
+	Stored    uint64               `json:"stored_size"`
+	Available bool                 `json:"available"`
+}
+
+func main() {
+	r, err := root.NewReader(Flags.VPK, Flags.AllowMissing)
+	if err != nil {
+		root.Fatalf("open vpk: %v", err)
+	}
+	defer r.Close()
+
+	dir := r.Root
+	dir.File = nil
+	var largest []fileStats
+	for _, f := range r.Root.File {
+		if skip, err := Flags.IncludeExclude(f); err != nil {
+			root.Fatalf("%v", err)
+		} else if skip {
+			continue
+		}
+		dir.File = append(dir.File, f)
+
+		fs := fileStats{Path: f.Path, Block: f.Index}
+		for _, c := range f.Chunk {
+			fs.UncompressedSize += c.UncompressedSize
+			fs.CompressedSize += c.CompressedSize
+		}
+		largest = append(largest, fs)
+	}
+	slices.SortStableFunc(largest, func(a, b fileStats) int {
+		return -cmp.Compare(a.UncompressedSize, b.UncompressedSize)
+	})
+	if Flags.Top > 0 && len(largest) > Flags.Top {
+		largest = largest[:Flags.Top]
+	}
+
+	stats := vpkutil.ComputeStats(dir)
+
+	var blocks []blockStats
+	for i, s := range vpkutil.ComputeStats(r.Root).Block {
+		b := blockStats{Block: i, Stored: s.StoredSize}
+		if fi, err := os.Stat(Flags.VPK.Resolve(i)); err == nil {
+			b.Size, b.Available = fi.Size(), true
+			if i == tf2vpk.ValvePakIndexDir {
+				if n, err := r.Root.ChunkOffset(); err == nil {
+					b.Size -= int64(n)
+				}
+			}
+		}
+		blocks = append(blocks, b)
+	}
+	slices.SortFunc(blocks, func(a, b blockStats) int {
+		return cmp.Compare(a.Block, b.Block)
+	})
+
+	h := r.Header()
+	header := headerStats{
+		Format:       h.Format().String(),
+		MajorVersion: h.MajorVersion,
+		MinorVersion: h.MinorVersion,
+		TreeSize:     h.TreeSize,
+		DataSize:     h.DataSize,
+	}
+
+	if root.Flags.JSON {
+		root.EmitJSON("stats", struct {
+			Header headerStats `json:"header"`
+			vpkutil.Stats
+			Largest []fileStats  `json:"largest"`
+			Blocks  []blockStats `json:"blocks"`
+		}{header, stats, largest, blocks})
+		return
+	}
+
+	fmt.Printf("%s, tree size %s\n\n", header.Format, size(uint64(header.TreeSize)))
+
+	t := stats.Total
+	fmt.Printf("%d files, %d chunks (%d compressed), %s uncompressed, %s compressed (%.1f%%), %s stored\n", t.Files, t.Chunks, t.CompressedChunks, size(t.UncompressedSize), size(t.CompressedSize), t.Ratio()*100, size(t.StoredSize))
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+
+	fmt.Printf("\nlargest files:\n")
+	fmt.Fprintf(tw, "uncompressed\tcompressed\tratio\t block\t path\n")
+	for _, f := range largest {
+		fmt.Fprintf(tw, "%s\t%s\t%.1f%%\t %s\t %s\n", size(f.UncompressedSize), size(f.CompressedSize), ratio(f.CompressedSize, f.UncompressedSize), f.Block, f.Path)
+	}
+	tw.Flush()
+
+	for _, g := range []struct {
+		Name  string
+		Stats map[string]vpkutil.SizeStats
+	}{
+		{"extension", stats.Extension},
+		{"directory", stats.Directory},
+	} {
+		keys := make([]string, 0, len(g.Stats))
+		for k := range g.Stats {
+			keys = append(keys, k)
+		}
+		slices.SortStableFunc(keys, func(a, b string) int {
+			if c := -cmp.Compare(g.Stats[a].UncompressedSize, g.Stats[b].UncompressedSize); c != 0 {
+				return c
+			}
+			return cmp.Compare(a, b)
+		})
+		if Flags.Top > 0 && len(keys) > Flags.Top {
+			keys = keys[:Flags.Top]
+		}
+		fmt.Printf("\nby %s:\n", g.Name)
+		fmt.Fprintf(tw, "files\tchunks\tuncompressed\tcompressed\tratio\tstored\t %s\n", g.Name)
+		for _, k := range keys {
+			s := g.Stats[k]
+			if k == "" {
+				k = "(none)"
+			}
+			fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%.1f%%\t%s\t %s\n", s.Files, s.Chunks, size(s.UncompressedSize), size(s.CompressedSize), s.Ratio()*100, size(s.StoredSize), k)
+		}
+		tw.Flush()
+	}
+
+	fmt.Printf("\nby block:\n")
+	fmt.Fprintf(tw, "size\tstored\tutilization\t block\n")
+	for _, b := range blocks {
+		if !b.Available {
+			fmt.Fprintf(tw, "-\t%s\t-\t %s\n", size(b.Stored), b.Block)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.1f%%\t %s\n", size(uint64(b.Size)), size(b.Stored), ratio(b.Stored, uint64(b.Size)), b.Block)
+	}
+	tw.Flush()
+}
+
+func ratio(a, b uint64) float64 {
+	if b == 0 {
+		return 100
+	}
+	return float64(a) / float64(b) * 100
+}
+
+func size(n uint64) string {
+	if Flags.HumanReadable {
+		return internal.FormatBytesSI(int64(n))
+	}
+	return fmt.Sprint(n)
+}
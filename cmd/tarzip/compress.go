@@ -0,0 +1,172 @@
+package tarzip
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressFormat detects the compression format from the output file name if
+// format is empty, and validates it. An empty string is returned for no
+// compression.
+func compressFormat(format, output string) (string, error) {
+	switch format {
+	case "":
+		switch o := strings.ToLower(output); {
+		case strings.HasSuffix(o, ".gz"), strings.HasSuffix(o, ".tgz"):
+			return "gzip", nil
+		case strings.HasSuffix(o, ".zst"), strings.HasSuffix(o, ".tzst"):
+			return "zstd", nil
+		}
+		return "", nil
+	case "none":
+		return "", nil
+	case "gzip", "zstd":
+		return format, nil
+	case "gz":
+		return "gzip", nil
+	case "zst":
+		return "zstd", nil
+	}
+	return "", fmt.Errorf("unknown compression format %q (expected gzip, zstd, or none)", format)
+}
+
+// newCompressWriter wraps w to compress it using the format, with level 0 being
+// the default level for the format, and n workers.
+func newCompressWriter(w io.Writer, format string, level, n int) (io.WriteCloser, error) {
+	switch format {
+	case "gzip":
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+			return nil, fmt.Errorf("invalid gzip level %d", level)
+		}
+		if n <= 1 {
+			return gzip.NewWriterLevel(w, level)
+		}
+		return newParallelGzipWriter(w, level, n), nil
+	case "zstd":
+		opts := []zstd.EOption{zstd.WithEncoderConcurrency(max(n, 1))}
+		if level != 0 {
+			if level < 1 || level > 22 {
+				return nil, fmt.Errorf("invalid zstd level %d", level)
+			}
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		return zstd.NewWriter(w, opts...)
+	}
+	panic("wtf")
+}
+
+// parallelGzipBlockSize is the amount of uncompressed data in each gzip member
+// written by parallelGzipWriter.
+const parallelGzipBlockSize = 1 << 20
+
+// parallelGzipWriter compresses blocks of data in parallel, writing each one as
+// a separate gzip member. Multi-member gzip files are decompressed as a single
+// stream by all common tools.
+type parallelGzipWriter struct {
+	level int
+	buf   []byte
+	queue chan chan []byte // in order, buffered to limit the number of blocks in progress
+	done  chan struct{}
+
+	started bool // whether any blocks were written
+	closed  bool
+
+	mu  sync.Mutex
+	err error
+}
+
+func newParallelGzipWriter(w io.Writer, level, n int) *parallelGzipWriter {
+	z := &parallelGzipWriter{
+		level: level,
+		queue: make(chan chan []byte, n),
+		done:  make(chan struct{}),
+	}
+	go func() {
+		defer close(z.done)
+		for ch := range z.queue {
+			b := <-ch
+			if z.error() == nil {
+				if _, err := w.Write(b); err != nil {
+					z.setError(err)
+				}
+			}
+		}
+	}()
+	return z
+}
+
+func (z *parallelGzipWriter) error() error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.err
+}
+
+func (z *parallelGzipWriter) setError(err error) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if z.err == nil {
+		z.err = err
+	}
+}
+
+func (z *parallelGzipWriter) Write(p []byte) (int, error) {
+	var n int
+	for len(p) != 0 {
+		if err := z.error(); err != nil {
+			return n, err
+		}
+		if z.buf == nil {
+			z.buf = make([]byte, 0, parallelGzipBlockSize)
+		}
+		c := copy(z.buf[len(z.buf):cap(z.buf)], p)
+		z.buf = z.buf[:len(z.buf)+c]
+		p = p[c:]
+		n += c
+		if len(z.buf) == cap(z.buf) {
+			z.flush()
+		}
+	}
+	return n, nil
+}
+
+// flush starts compressing the current block.
+func (z *parallelGzipWriter) flush() {
+	b, ch := z.buf, make(chan []byte, 1)
+	z.buf, z.started = nil, true
+	z.queue <- ch
+	go func() {
+		var out bytes.Buffer
+		zw, _ := gzip.NewWriterLevel(&out, z.level) // level was checked
+		_, err := zw.Write(b)
+		if err == nil {
+			err = zw.Close()
+		}
+		if err != nil {
+			z.setError(err)
+		}
+		ch <- out.Bytes()
+	}()
+}
+
+// Close writes the remaining data and waits for it to be written.
+func (z *parallelGzipWriter) Close() error {
+	if z.closed {
+		return z.error()
+	}
+	z.closed = true
+	if z.buf != nil || !z.started {
+		z.flush() // an empty file is still a gzip member
+	}
+	close(z.queue)
+	<-z.done
+	return z.error()
+}
@@ -0,0 +1,100 @@
+package tarzip
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestParallelGzipWriter(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, n := range []int{0, 1, parallelGzipBlockSize - 1, parallelGzipBlockSize, parallelGzipBlockSize*3 + 12345} {
+		data := make([]byte, n)
+		for i := range data {
+			data[i] = "abcdefgh"[rng.Intn(8)] // compressible, but not trivially
+		}
+
+		var buf bytes.Buffer
+		z := newParallelGzipWriter(&buf, gzip.DefaultCompression, 3)
+		for p := data; len(p) != 0; {
+			c := min(len(p), 1+rng.Intn(200000)) // writes spanning blocks
+			if _, err := z.Write(p[:c]); err != nil {
+				t.Fatalf("%d bytes: write: %v", n, err)
+			}
+			p = p[c:]
+		}
+		if err := z.Close(); err != nil {
+			t.Fatalf("%d bytes: close: %v", n, err)
+		}
+
+		zr, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%d bytes: read: %v", n, err)
+		}
+		act, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("%d bytes: read: %v", n, err)
+		}
+		if !bytes.Equal(act, data) {
+			t.Errorf("%d bytes: incorrect data", n)
+		}
+
+		// each block is a separate member
+		var (
+			br      = bufio.NewReader(bytes.NewReader(buf.Bytes())) // so it doesn't read past the member
+			members int
+		)
+		for err = zr.Reset(br); err == nil; err = zr.Reset(br) {
+			zr.Multistream(false)
+			if _, err := io.Copy(io.Discard, zr); err != nil {
+				t.Fatalf("%d bytes: read member: %v", n, err)
+			}
+			members++
+		}
+		if err != io.EOF {
+			t.Fatalf("%d bytes: read member: %v", n, err)
+		}
+		if exp := max((n+parallelGzipBlockSize-1)/parallelGzipBlockSize, 1); members != exp {
+			t.Errorf("%d bytes: expected %d members, got %d", n, exp, members)
+		}
+	}
+}
+
+type failWriter struct {
+	n   int // number of successful writes left
+	err error
+}
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	if w.n == 0 {
+		return 0, w.err
+	}
+	w.n--
+	return len(p), nil
+}
+
+func TestParallelGzipWriterError(t *testing.T) {
+	errTest := errors.New("test")
+	data := make([]byte, parallelGzipBlockSize*8)
+
+	z := newParallelGzipWriter(&failWriter{1, errTest}, gzip.DefaultCompression, 2)
+	var err error
+	for i := 0; i < 16 && err == nil; i++ {
+		_, err = z.Write(data)
+	}
+	if err == nil {
+		err = z.Close()
+	} else if cerr := z.Close(); !errors.Is(cerr, errTest) {
+		t.Errorf("expected close to return the write error, got %v", cerr)
+	}
+	if !errors.Is(err, errTest) {
+		t.Errorf("expected write error, got %v", err)
+	}
+	if _, err := z.Write([]byte{0}); !errors.Is(err, errTest) {
+		t.Errorf("expected write after error to fail, got %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package tarzip
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// splitVolumes writes a tar archive as a sequence of size-capped volumes.
+type splitVolumes struct {
+	base  string // output path without the extension
+	limit uint64
+
+	cur      *os.File
+	n        uint64 // estimated size of the current volume
+	manifest splitManifest
+}
+
+// splitManifest lists the volumes written by splitVolumes.
+type splitManifest struct {
+	Volumes []splitManifestVolume `json:"volumes"`
+}
+
+type splitManifestVolume struct {
+	Name  string   `json:"name"` // relative to the manifest
+	Size  int64    `json:"size"`
+	Files []string `json:"files"`
+}
+
+func newSplitVolumes(output string, limit uint64) *splitVolumes {
+	return &splitVolumes{
+		base:  strings.TrimSuffix(output, filepath.Ext(output)),
+		limit: limit,
+	}
+}
+
+// tarOverhead is a conservative estimate of the bytes used by a tar entry in
+// addition to the contents, including PAX headers for long names and the
+// headers for any parent directories.
+func tarOverhead(name string) uint64 {
+	return 512*3 + uint64(len(name)+511)/512*512*(2+uint64(strings.Count(name, "/")))
+}
+
+// Reserve ensures there's space in the current volume for the named file,
+// returning true if a new volume was started, in which case a new tar writer
+// must be created. Before starting a new volume, flush is called to finish
+// the previous one.
+func (s *splitVolumes) Reserve(name string, size int64, flush func() error) (bool, error) {
+	need := tarOverhead(name) + (uint64(size)+511)/512*512
+	if need+1024 > s.limit { // end-of-archive marker
+		return false, fmt.Errorf("file %q does not fit in a volume of %d bytes (try --chunks)", name, s.limit)
+	}
+	var next bool
+	if s.cur == nil || s.n+need+1024 > s.limit {
+		if s.cur != nil {
+			if err := flush(); err != nil {
+				return false, err
+			}
+		}
+		if err := s.next(); err != nil {
+			return false, err
+		}
+		next = true
+	}
+	s.n += need
+	v := &s.manifest.Volumes[len(s.manifest.Volumes)-1]
+	v.Files = append(v.Files, name)
+	return next, nil
+}
+
+// next closes the current volume and starts a new one.
+func (s *splitVolumes) next() error {
+	if err := s.finish(); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s.%03d.tar", s.base, len(s.manifest.Volumes))
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	s.cur, s.n = f, 0
+	s.manifest.Volumes = append(s.manifest.Volumes, splitManifestVolume{
+		Name: filepath.Base(name),
+	})
+	return nil
+}
+
+// finish closes the current volume, if any.
+func (s *splitVolumes) finish() error {
+	if s.cur == nil {
+		return nil
+	}
+	fi, err := s.cur.Stat()
+	if err != nil {
+		return err
+	}
+	s.manifest.Volumes[len(s.manifest.Volumes)-1].Size = fi.Size()
+	err = s.cur.Close()
+	s.cur = nil
+	return err
+}
+
+func (s *splitVolumes) Write(b []byte) (int, error) {
+	if s.cur == nil {
+		return 0, fmt.Errorf("no volume")
+	}
+	return s.cur.Write(b)
+}
+
+// Close closes the last volume and writes the manifest.
+func (s *splitVolumes) Close() error {
+	if err := s.finish(); err != nil {
+		return err
+	}
+	buf, err := json.MarshalIndent(s.manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.base+".json", append(buf, '\n'), 0666)
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/pg9182/tf2vpk"
 	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/vpkutil"
 	"github.com/spf13/cobra"
 )
 
@@ -22,57 +23,118 @@ func command(format string) *cobra.Command {
 	var Flags struct {
 		VPK            tf2vpk.ValvePakRef
 		IncludeExclude func(tf2vpk.ValvePakFile) (bool, error)
+		LoadFlags      func(tf2vpk.ValvePakFile) (bool, error)
 		Output         string
 		Chunks         bool
 		RawChunks      bool
 		Verbose        bool
+		SplitSize      uint64
+		NoPAX          bool
+		Compress       string
+		CompressLevel  int
 	}
 	var Command = &cobra.Command{
 		GroupID: root.GroupVPKRead.ID,
 		Use:     format + " vpk_path",
 		Short:   "Streams the contents of VPK as a " + format + " archive",
-		Args:    cobra.ExactArgs(1),
+		Long: `Streams the contents of VPK as a ` + format + ` archive
+` + map[string]string{"tar": `
+Unless --no-pax is specified, the VPK metadata for each file (load and texture flags, CRC32, block index, and chunk sizes) is stored in PAX records prefixed with TF2VPK., which fromtar uses to repack the files the same way.
+
+The archive can be compressed with gzip or zstd using --compress, which is detected from the output file extension (.tar.gz, .tgz, .tar.zst, .tzst) if not specified. Compression is done in parallel using --jobs workers. For gzip, this writes the archive as a sequence of gzip members, which is supported by all common tools.
+
+With --split-size, the archive is written as numbered volumes (e.g., out.000.tar, out.001.tar, ...) which are each at most the specified size, and a manifest listing the files in each volume is written to the output path with a .json extension (e.g., out.json). Files are never split across volumes, so each file (or, with --chunks, each chunk) must fit in a single volume.
+`}[format],
+		Args: cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			main()
 		},
 	}
 	main = func() {
 		if Flags.RawChunks && !Flags.Chunks {
-			fmt.Fprintf(os.Stderr, "error: --raw-chunks requires --chunks\n")
+			root.Errorf("--raw-chunks requires --chunks")
+			os.Exit(2)
+		}
+
+		r, err := root.NewReader(Flags.VPK, false)
+		if err != nil {
+			root.Fatalf("open vpk: %v", err)
+		}
+
+		if Flags.SplitSize != 0 && Flags.Output == "-" {
+			root.Errorf("--split-size requires an output file")
 			os.Exit(2)
 		}
 
-		r, err := tf2vpk.NewReader(Flags.VPK)
+		compress, err := compressFormat(Flags.Compress, Flags.Output)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error: open vpk: %v\n", err)
-			os.Exit(1)
+			root.Errorf("%v", err)
+			os.Exit(2)
+		}
+		if compress != "" && Flags.SplitSize != 0 {
+			root.Errorf("--compress cannot be used with --split-size")
+			os.Exit(2)
 		}
 
 		var w *os.File
-		switch Flags.Output {
-		case "":
-			fmt.Fprintf(os.Stderr, "error: no output file specified\n")
-			os.Exit(1)
-		case "-":
+		switch {
+		case Flags.Output == "":
+			root.Fatalf("no output file specified")
+		case Flags.SplitSize != 0:
+			// volumes are created as needed
+		case Flags.Output == "-":
+			if root.Flags.JSON {
+				root.Errorf("--json requires --output")
+				os.Exit(2)
+			}
 			w = os.Stdout
 		default:
 			w, err = os.OpenFile(Flags.Output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "error: create output file: %v\n", err)
-				os.Exit(1)
+				root.Fatalf("create output file: %v", err)
 			}
 		}
 		defer w.Close()
 
 		var (
-			archive func(name string, size int64, r io.Reader) error
+			aw io.Writer = w
+			cw io.WriteCloser
+		)
+		if compress != "" {
+			if cw, err = newCompressWriter(w, compress, Flags.CompressLevel, root.Flags.Jobs); err != nil {
+				root.Errorf("%v", err)
+				os.Exit(2)
+			}
+			aw = cw
+		}
+
+		var (
+			archive func(name string, size int64, pax map[string]string, r io.Reader) error
 			finish  func() error
 		)
 		switch format {
 		case "tar":
-			a := tar.NewWriter(w)
-			ds := map[string]struct{}{}
-			archive = func(name string, size int64, r io.Reader) error {
+			var (
+				a  *tar.Writer
+				ds = map[string]struct{}{}
+				sv *splitVolumes
+			)
+			if Flags.SplitSize != 0 {
+				sv = newSplitVolumes(Flags.Output, Flags.SplitSize)
+			} else {
+				a = tar.NewWriter(aw)
+			}
+			archive = func(name string, size int64, pax map[string]string, r io.Reader) error {
+				if sv != nil {
+					if next, err := sv.Reserve(name, size, func() error {
+						return a.Close()
+					}); err != nil {
+						return err
+					} else if next {
+						a = tar.NewWriter(sv)
+						clear(ds)
+					}
+				}
 				var mkdirs []string
 			d:
 				for d := path.Dir(name); d != "" && d != "."; d = path.Dir(d) {
@@ -91,19 +153,30 @@ func command(format string) *cobra.Command {
 					}
 				}
 				err := a.WriteHeader(&tar.Header{
-					Name: name,
-					Size: size,
-					Mode: 0666,
+					Name:       name,
+					Size:       size,
+					Mode:       0666,
+					PAXRecords: pax,
 				})
 				if err == nil {
 					_, err = io.Copy(a, r)
 				}
 				return err
 			}
-			finish = a.Close
+			finish = func() error {
+				if a != nil {
+					if err := a.Close(); err != nil {
+						return err
+					}
+				}
+				if sv != nil {
+					return sv.Close()
+				}
+				return nil
+			}
 		case "zip":
 			a := zip.NewWriter(w)
-			archive = func(name string, size int64, r io.Reader) error {
+			archive = func(name string, size int64, _ map[string]string, r io.Reader) error {
 				w, err := a.CreateHeader(&zip.FileHeader{
 					Name:               name,
 					UncompressedSize64: uint64(size),
@@ -117,18 +190,31 @@ func command(format string) *cobra.Command {
 		default:
 			panic("wtf")
 		}
+		var (
+			files      []tf2vpk.ValvePakFile
+			totalBytes int64
+		)
 		for _, f := range r.Root.File {
-			if skip, err := Flags.IncludeExclude(f); err != nil {
-				fmt.Fprintf(os.Stderr, "error: %v\n", err)
-				os.Exit(1)
+			skip, err := Flags.IncludeExclude(f)
+			if err == nil && !skip {
+				skip, err = Flags.LoadFlags(f)
+			}
+			if err != nil {
+				root.Fatalf("%v", err)
 			} else if skip {
 				if Flags.Verbose {
-					fmt.Fprintf(os.Stderr, "%s (skipped)\n", f.Path)
+					verbose(f.Path, true)
 				}
 				continue
 			}
+			files = append(files, f)
+			totalBytes += int64(f.Size())
+		}
+
+		progress := root.Progress(format, int64(len(files)), totalBytes)
+		for _, f := range files {
 			if Flags.Verbose {
-				fmt.Fprintf(os.Stderr, "%s\n", f.Path)
+				verbose(f.Path, false)
 			}
 			if Flags.Chunks {
 				for i, c := range f.Chunk {
@@ -149,46 +235,83 @@ func command(format string) *cobra.Command {
 						cr, err = r.OpenChunk(f, c)
 					}
 					if err != nil {
-						fmt.Fprintf(os.Stderr, "error: read vpk file %q: chunk %d: %v\n", f.Path, i, err)
-						os.Exit(1)
+						root.Fatalf("read vpk file %q: chunk %d: %v", f.Path, i, err)
 					}
-					if err = archive(f.Path+"/"+strconv.Itoa(i)+ext, int64(sz), cr); err != nil {
-						fmt.Fprintf(os.Stderr, "error: process vpk file %q: chunk %d: %v\n", f.Path, i, err)
-						os.Exit(1)
+					if err = archive(f.Path+"/"+strconv.Itoa(i)+ext, int64(sz), nil, progress.Reader(cr)); err != nil {
+						root.Fatalf("process vpk file %q: chunk %d: %v", f.Path, i, err)
 					}
 				}
 			} else {
-				var sz uint64
-				for _, c := range f.Chunk {
-					sz += c.UncompressedSize
+				sz := f.Size()
+				var pax map[string]string
+				if format == "tar" && !Flags.NoPAX {
+					if pax, err = vpkutil.TarPAXRecords(f); err != nil {
+						root.Fatalf("%v", err)
+					}
 				}
-				if fr, err := r.OpenFileParallel(f, root.Flags.Threads); err != nil {
-					fmt.Fprintf(os.Stderr, "error: read vpk file %q: %v\n", f.Path, err)
-					os.Exit(1)
-				} else if err = archive(f.Path, int64(sz), fr); err != nil {
-					fmt.Fprintf(os.Stderr, "error: process vpk file %q: %v\n", f.Path, err)
-					os.Exit(1)
+				if fr, err := r.OpenFileParallel(f, root.Flags.Jobs); err != nil {
+					root.Fatalf("read vpk file %q: %v", f.Path, err)
+				} else if err = archive(f.Path, int64(sz), pax, progress.Reader(fr)); err != nil {
+					root.Fatalf("process vpk file %q: %v", f.Path, err)
 				}
 			}
+			progress.AddFiles(1)
 		}
 		if err := finish(); err != nil {
-			fmt.Fprintf(os.Stderr, "error: write output file %q: %v\n", Flags.Output, err)
-			os.Exit(1)
+			root.Fatalf("write output file %q: %v", Flags.Output, err)
+		}
+		if cw != nil {
+			if err := cw.Close(); err != nil {
+				root.Fatalf("write output file %q: %v", Flags.Output, err)
+			}
 		}
+		progress.Done()
 
-		if err := w.Close(); err != nil {
-			fmt.Fprintf(os.Stderr, "error: write output file %q: %v\n", Flags.Output, err)
-			os.Exit(1)
+		if w != nil {
+			if err := w.Close(); err != nil {
+				root.Fatalf("write output file %q: %v", Flags.Output, err)
+			}
+		}
+		if root.Flags.JSON {
+			root.EmitJSON("summary", struct {
+				Output string `json:"output"`
+				Files  int    `json:"files"`
+				Size   int64  `json:"size"`
+			}{Flags.Output, len(files), totalBytes})
 		}
 	}
 	{
 		root.ArgVPK(&Flags.VPK, Command, -1, false, false, false)
 		root.FlagIncludeExclude(&Flags.IncludeExclude, Command, true)
+		root.FlagLoadFlags(&Flags.LoadFlags, Command)
 		Command.Flags().StringVarP(&Flags.Output, "output", "o", "-", "write the archive to a file")
 		Command.Flags().BoolVarP(&Flags.Chunks, "chunks", "c", false, "instead of assembling files, make each file a dir, and output the raw chunks as numbered files within")
 		Command.Flags().BoolVarP(&Flags.RawChunks, "raw-chunks", "C", false, "do not decompress compressed chunks (requires --chunks)")
 		Command.Flags().BoolVarP(&Flags.Verbose, "verbose", "v", false, "display files as they are archived")
+		if format == "tar" {
+			Command.Flags().BoolVar(&Flags.NoPAX, "no-pax", false, "do not store the vpk metadata for each file in pax records")
+			root.ByteSizeVar(Command, &Flags.SplitSize, "split-size", 0, "write numbered volumes of at most this size (e.g., 4GiB) instead of a single archive")
+			Command.Flags().StringVarP(&Flags.Compress, "compress", "z", "", "compress the archive (gzip, zstd, or none; detected from the output file extension if not specified)")
+			Command.Flags().IntVar(&Flags.CompressLevel, "compress-level", 0, "the compression level (1-9 for gzip, 1-22 for zstd; 0 for the default)")
+		}
+		root.FlagJSON(Command)
 		root.Command.AddCommand(Command)
 	}
 	return Command
 }
+
+// verbose prints a file as it is archived or skipped.
+func verbose(name string, skipped bool) {
+	if root.Flags.JSON {
+		root.EmitJSON("file", struct {
+			Path    string `json:"path"`
+			Skipped bool   `json:"skipped"`
+		}{name, skipped})
+		return
+	}
+	if skipped {
+		fmt.Fprintf(os.Stderr, "%s (skipped)\n", name)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s\n", name)
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"runtime"
 	"time"
 
 	"github.com/pg9182/tf2vpk/vpkutil"
@@ -19,9 +18,13 @@ var (
 	Output  = pflag.StringP("output", "o", "-", "The file to write the tar archive to")
 	Verbose = pflag.BoolP("verbose", "v", false, "Write information about processed files to stderr")
 	Test    = pflag.BoolP("test", "t", false, "Don't create a tar archive; only attempt to read the entire VPK and verify checksums")
-	Threads = pflag.IntP("threads", "j", runtime.NumCPU(), "The number of decompression threads to use (0 to only decompress chunks as they are read) (defaults to the number of cores)")
+	Jobs    = vpkutil.NewCLIJobs(pflag.CommandLine)
 
 	IncludeExclude = vpkutil.NewCLIIncludeExclude(pflag.CommandLine)
+	LoadFlags      = pflag.Uint32("load-flags", 0, "Only include files with all of the provided load flags set (e.g., 0x1 for VISIBLE)")
+	NoLoadFlags    = pflag.Uint32("no-load-flags", 0, "Exclude files with any of the provided load flags set")
+	Server         = pflag.Bool("server", false, "Exclude files a dedicated server doesn't load (currently, textures)")
+	NoPAX          = pflag.Bool("no-pax", false, "Don't write the VPK metadata (flags, CRC32, block index, chunk sizes) as PAX records")
 
 	Help = pflag.BoolP("help", "h", false, "Show this help message")
 )
@@ -37,11 +40,9 @@ func main() {
 		return
 	}
 
-	if *Threads < 0 {
-		*Threads = 0
-	}
-	if *Threads > runtime.NumCPU() {
-		runtime.GOMAXPROCS(*Threads)
+	if err := Jobs.Apply(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(2)
 	}
 
 	_, r, err := ResolveOpen.ResolveOpen()
@@ -75,6 +76,10 @@ func main() {
 		tw = tar.NewWriter(w)
 	}
 
+	loadFlags := vpkutil.LoadFlagsFilter{All: *LoadFlags, None: *NoLoadFlags}
+	if *Server {
+		loadFlags = loadFlags.Merge(vpkutil.ServerLoadFlagsFilter)
+	}
 	for _, f := range r.Root.File {
 		if skip, err := IncludeExclude.Skip(f); err != nil {
 			fmt.Fprintf(os.Stderr, "error: %v\n", err)
@@ -82,14 +87,17 @@ func main() {
 		} else if skip {
 			continue
 		}
-		var sz uint64
-		for _, c := range f.Chunk {
-			sz += c.UncompressedSize
+		if skip, err := loadFlags.Skip(f); err != nil {
+			fmt.Fprintf(os.Stderr, "error: vpk file %q: %v\n", f.Path, err)
+			os.Exit(1)
+		} else if skip {
+			continue
 		}
+		sz := f.Size()
 		if *Verbose {
 			fmt.Fprintf(os.Stderr, "%s\n", f.Path)
 		}
-		fr, err := r.OpenFileParallel(f, *Threads)
+		fr, err := r.OpenFileParallel(f, *Jobs.Jobs)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "error: read vpk file %q: %v\n", f.Path, err)
 			os.Exit(1)
@@ -97,12 +105,20 @@ func main() {
 		if *Test {
 			_, err = io.Copy(io.Discard, fr)
 		} else {
-			if err = tw.WriteHeader(&tar.Header{
-				Name:    f.Path,
-				Size:    int64(sz),
-				Mode:    0666,
-				ModTime: time.Now(),
-			}); err == nil {
+			var pax map[string]string
+			if !*NoPAX {
+				pax, err = vpkutil.TarPAXRecords(f)
+			}
+			if err == nil {
+				err = tw.WriteHeader(&tar.Header{
+					Name:       f.Path,
+					Size:       int64(sz),
+					Mode:       0666,
+					ModTime:    time.Now(),
+					PAXRecords: pax,
+				})
+			}
+			if err == nil {
 				_, err = io.Copy(tw, fr)
 			}
 		}
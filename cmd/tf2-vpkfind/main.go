@@ -0,0 +1,130 @@
+// Command tf2-vpkfind shows which VPKs contain a file.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/internal"
+	"github.com/pg9182/tf2vpk/vpkutil"
+	"github.com/spf13/pflag"
+)
+
+var (
+	Game               = pflag.StringP("game", "g", "", "Find the vpk directory of an installed game (tf2) instead of taking it as the first argument")
+	VPKPrefix          = pflag.StringP("vpk-prefix", "p", "english", "VPK prefix (empty to use english if available, or the first one otherwise)")
+	HumanReadable      = pflag.BoolP("human-readable", "h", false, "Show sizes in human-readable form")
+	HumanReadableFlags = pflag.BoolP("human-readable-flags", "f", false, "Also show the flags in human-readable form at the very end of the line (delimited by a #)")
+
+	Help = pflag.Bool("help", false, "Show this help message")
+)
+
+func main() {
+	pflag.Parse()
+
+	args := pflag.Args()
+	if *Game == "" && len(args) != 0 {
+		args = args[1:]
+	}
+	if len(args) == 0 || *Help {
+		fmt.Fprintf(os.Stderr, "usage: %s [options] (vpk_dir|--game game) path_or_glob...\n\nShows the vpks containing files or directories matching the globs, only reading the dir indexes. Each line contains the vpk name, block index, load flags, texture flags, compressed size, uncompressed size, and path.\n\noptions:\n%s", os.Args[0], pflag.CommandLine.FlagUsages())
+		if !*Help {
+			os.Exit(2)
+		}
+		return
+	}
+
+	var dir string
+	if *Game != "" {
+		g, ok := vpkutil.LookupGame(*Game)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "error: unknown game %q\n", *Game)
+			os.Exit(2)
+		}
+		is := vpkutil.FindGame(g)
+		if len(is) == 0 {
+			fmt.Fprintf(os.Stderr, "error: no %s install found\n", g.Name)
+			os.Exit(1)
+		}
+		dir = is[0].VPKDir()
+	} else {
+		dir = pflag.Arg(0)
+	}
+
+	var globs []string
+	for _, x := range args {
+		x = strings.ToLower(strings.Trim(strings.ReplaceAll(x, `\`, "/"), "/"))
+		if _, err := internal.MatchGlobParents(x, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid glob %q: %v\n", x, err)
+			os.Exit(2)
+		}
+		globs = append(globs, x)
+	}
+
+	sets, err := tf2vpk.ScanValvePakSets(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: scan vpks: %v\n", err)
+		os.Exit(1)
+	}
+
+	var found int
+	bw := bufio.NewWriter(os.Stdout)
+	for _, s := range sets {
+		vpk := s.Default()
+		if *VPKPrefix != "" {
+			if vpk, err = s.Ref(*VPKPrefix); err != nil {
+				continue
+			}
+		}
+		f, err := os.Open(vpk.Resolve(tf2vpk.ValvePakIndexDir))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: open vpk %q: %v\n", s.Name, err)
+			continue
+		}
+		var root tf2vpk.ValvePakDir
+		err = root.DeserializeStream(bufio.NewReader(f), func(vf tf2vpk.ValvePakFile) error {
+			for _, g := range globs {
+				if m, _ := internal.MatchGlobParents(g, vf.Path); m {
+					found++
+					return printFile(bw, s.Name, vf)
+				}
+			}
+			return nil
+		})
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: read vpk %q: %v\n", s.Name, err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	if found == 0 {
+		os.Exit(1)
+	}
+}
+
+func printFile(w *bufio.Writer, name string, f tf2vpk.ValvePakFile) error {
+	load, err := f.LoadFlags()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: entry %q: compute load flags: %v\n", f.Path, err)
+	}
+	texture, err := f.TextureFlags()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: entry %q: compute texture flags: %v\n", f.Path, err)
+	}
+	if *HumanReadable {
+		fmt.Fprintf(w, "%s %s %08X %04X %9s %9s %s", name, f.Index, load, texture, internal.FormatBytesSI(int64(f.CompressedSize())), internal.FormatBytesSI(int64(f.Size())), f.Path)
+	} else {
+		fmt.Fprintf(w, "%s %s %08X %04X %9d %9d %s", name, f.Index, load, texture, f.CompressedSize(), f.Size(), f.Path)
+	}
+	if *HumanReadableFlags {
+		fmt.Fprintf(w, " # load=%s texture=%s", tf2vpk.DescribeLoadFlags(load), tf2vpk.DescribeTextureFlags(texture))
+	}
+	_, err = w.WriteString("\n")
+	return err
+}
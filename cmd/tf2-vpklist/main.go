@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"runtime"
 	"strings"
 
 	"github.com/pg9182/tf2vpk"
@@ -23,7 +22,7 @@ var (
 	Test               = pflag.BoolP("test", "t", false, "Also attempt to read contents and compute checksums (adds a column with OK/ERR to the end)")
 	//Stats = pflag.BoolP("stats", "s", false, "Show detailed statistics about vpk space utilization")
 
-	Threads = pflag.IntP("threads", "j", runtime.NumCPU(), "The number of decompression threads to use while verifying checksums (0 to only decompress chunks as they are read) (defaults to the number of cores)")
+	Jobs = vpkutil.NewCLIJobs(pflag.CommandLine)
 
 	IncludeExclude = vpkutil.NewCLIIncludeExclude(pflag.CommandLine)
 
@@ -41,11 +40,9 @@ func main() {
 		return
 	}
 
-	if *Threads < 0 {
-		*Threads = 0
-	}
-	if *Threads > runtime.NumCPU() {
-		runtime.GOMAXPROCS(*Threads)
+	if err := Jobs.Apply(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(2)
 	}
 
 	_, r, err := ResolveOpen.ResolveOpen()
@@ -106,7 +103,7 @@ func main() {
 
 		var testErr error
 		if *Test {
-			if fr, err := r.OpenFileParallel(f, *Threads); err != nil {
+			if fr, err := r.OpenFileParallel(f, *Jobs.Jobs); err != nil {
 				testErr = err
 			} else if _, err = io.Copy(io.Discard, fr); err != nil {
 				testErr = err
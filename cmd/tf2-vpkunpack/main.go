@@ -9,7 +9,6 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
-	"runtime"
 
 	"github.com/pg9182/tf2vpk/internal"
 	"github.com/pg9182/tf2vpk/vpkutil"
@@ -21,7 +20,8 @@ var (
 
 	VPKFlagsExplicit = pflag.Bool("vpkflags-explicit", false, "Do not optimize vpkflags for inheritance; generate one line for each file")
 	VPKIgnoreEmpty   = pflag.Bool("vpkignore-no-default", false, "Do not add default vpkignore entries")
-	Threads          = pflag.IntP("threads", "j", runtime.NumCPU(), "The number of decompression threads to use while verifying checksums (0 to only decompress chunks as they are read) (defaults to the number of cores)")
+	LaxPaths         = pflag.Bool("lax-paths", false, "Allow extracting paths which are invalid on Windows (absolute paths and path traversal are always rejected)")
+	Jobs             = vpkutil.NewCLIJobs(pflag.CommandLine)
 
 	IncludeExclude = vpkutil.NewCLIIncludeExclude(pflag.CommandLine)
 
@@ -40,11 +40,9 @@ func main() {
 		return
 	}
 
-	if *Threads < 0 {
-		*Threads = 0
-	}
-	if *Threads > runtime.NumCPU() {
-		runtime.GOMAXPROCS(*Threads)
+	if err := Jobs.Apply(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(2)
 	}
 
 	vpkOut := args[0]
@@ -150,7 +148,11 @@ func main() {
 			}
 			fmt.Printf("[%4d/%4d] %s (%s)\n", i+1, len(r.Root.File), f.Path, internal.FormatBytesSI(int64(uncompressed)))
 
-			outPath := filepath.Join(vpkOut, filepath.FromSlash(f.Path))
+			outPath, err := vpkutil.SanitizeJoin(vpkOut, f.Path, *LaxPaths)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
 
 			if err := os.MkdirAll(filepath.Dir(outPath), 0777); err != nil {
 				fmt.Fprintf(os.Stderr, "error: create %q: %v\n", outPath, err)
@@ -164,7 +166,7 @@ func main() {
 			}
 			defer tf.Close()
 
-			fr, err := r.OpenFileParallel(f, *Threads)
+			fr, err := r.OpenFileParallel(f, *Jobs.Jobs)
 			if err != nil {
 				os.Remove(tf.Name())
 				fmt.Fprintf(os.Stderr, "error: read vpk file %q: %v\n", f.Path, err)
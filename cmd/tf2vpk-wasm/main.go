@@ -0,0 +1,206 @@
+//go:build js && wasm
+
+// Command tf2vpk-wasm exposes a VPK reader to JavaScript, for reading VPKs
+// selected by the user in a browser without uploading them anywhere.
+//
+//	GOOS=js GOARCH=wasm go build -o tf2vpk.wasm ./cmd/tf2vpk-wasm
+//
+// Once loaded using wasm_exec.js from the Go distribution, it sets
+// globalThis.tf2vpk to an object with the following functions:
+//
+//	// open opens a VPK from an array of File objects (e.g., from an input
+//	// element with the multiple attribute), which must include the dir index
+//	// and the blocks it references.
+//	open(files: File[]): Promise<VPK>
+//
+//	interface VPK {
+//		files: {path: string, size: number, crc32: number, block: number}[]
+//		read(path: string): Promise<Uint8Array>
+//		close(): void
+//	}
+//
+// Chunks are decompressed using tf2lzham's WebAssembly build (or another Codec
+// set with tf2vpk.SetCodec).
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"syscall/js"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+func main() {
+	js.Global().Set("tf2vpk", js.ValueOf(map[string]any{
+		"open": js.FuncOf(func(this js.Value, args []js.Value) any {
+			return promise(func() (any, error) {
+				if len(args) != 1 {
+					return nil, fmt.Errorf("expected an array of files")
+				}
+				return open(args[0])
+			})
+		}),
+	}))
+	select {}
+}
+
+// open opens a VPK from an array of files.
+func open(files js.Value) (any, error) {
+	var (
+		dir    js.Value
+		dirN   string
+		blocks = map[tf2vpk.ValvePakIndex]map[string]js.Value{} // by name (without the language prefix)
+	)
+	for i, n := 0, files.Length(); i < n; i++ {
+		f := files.Index(i)
+		name, idx, err := tf2vpk.SplitName(f.Get("name").String(), "")
+		if err != nil {
+			continue
+		}
+		if idx == tf2vpk.ValvePakIndexDir {
+			if !dir.IsUndefined() {
+				return nil, fmt.Errorf("more than one dir index selected")
+			}
+			dir, dirN = f, name
+		} else {
+			if blocks[idx] == nil {
+				blocks[idx] = map[string]js.Value{}
+			}
+			blocks[idx][name] = f
+		}
+	}
+	if dir.IsUndefined() {
+		return nil, fmt.Errorf("no dir index selected")
+	}
+
+	r, err := tf2vpk.NewReaderFunc(func(i tf2vpk.ValvePakIndex) (io.ReaderAt, error) {
+		if i == tf2vpk.ValvePakIndexDir {
+			return newBlobReaderAt(dir), nil
+		}
+		for name, b := range blocks[i] {
+			if strings.HasSuffix(dirN, name) {
+				return newBlobReaderAt(b), nil
+			}
+		}
+		return nil, fmt.Errorf("block %s not selected", i)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := r.CheckBounds(); err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	list := make([]any, len(r.Root.File))
+	for i, f := range r.Root.File {
+		size := f.Size()
+		list[i] = map[string]any{
+			"path":  f.Path,
+			"size":  size,
+			"crc32": f.CRC32,
+			"block": int(f.Index),
+		}
+	}
+	return js.ValueOf(map[string]any{
+		"files": list,
+		"read": js.FuncOf(func(this js.Value, args []js.Value) any {
+			return promise(func() (any, error) {
+				if len(args) != 1 || args[0].Type() != js.TypeString {
+					return nil, fmt.Errorf("expected a path")
+				}
+				buf, err := fs.ReadFile(r, args[0].String())
+				if err != nil {
+					return nil, err
+				}
+				a := js.Global().Get("Uint8Array").New(len(buf))
+				js.CopyBytesToJS(a, buf)
+				return a, nil
+			})
+		}),
+		"close": js.FuncOf(func(this js.Value, args []js.Value) any {
+			r.Close()
+			return nil
+		}),
+	}), nil
+}
+
+// promise runs fn in a new goroutine (since it may block on other promises),
+// returning a promise for the result.
+func promise(fn func() (any, error)) js.Value {
+	var handler js.Func
+	handler = js.FuncOf(func(this js.Value, args []js.Value) any {
+		handler.Release()
+		resolve, reject := args[0], args[1]
+		go func() {
+			v, err := fn()
+			if err != nil {
+				reject.Invoke(js.Global().Get("Error").New(err.Error()))
+			} else {
+				resolve.Invoke(v)
+			}
+		}()
+		return nil
+	})
+	return js.Global().Get("Promise").New(handler)
+}
+
+// await waits for a promise to settle. It must not be called from the event
+// loop.
+func await(p js.Value) (js.Value, error) {
+	type result struct {
+		v   js.Value
+		err error
+	}
+	ch := make(chan result, 1)
+	then := js.FuncOf(func(this js.Value, args []js.Value) any {
+		ch <- result{v: args[0]}
+		return nil
+	})
+	defer then.Release()
+	catch := js.FuncOf(func(this js.Value, args []js.Value) any {
+		ch <- result{err: errors.New(args[0].Call("toString").String())}
+		return nil
+	})
+	defer catch.Release()
+	p.Call("then", then, catch)
+	r := <-ch
+	return r.v, r.err
+}
+
+// blobReaderAt reads from a JavaScript Blob.
+type blobReaderAt struct {
+	blob js.Value
+	size int64
+}
+
+func newBlobReaderAt(blob js.Value) *blobReaderAt {
+	return &blobReaderAt{blob, int64(blob.Get("size").Float())}
+}
+
+func (b *blobReaderAt) Size() int64 {
+	return b.size
+}
+
+func (b *blobReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+	if off >= b.size {
+		return 0, io.EOF
+	}
+	end := min(off+int64(len(p)), b.size)
+	buf, err := await(b.blob.Call("slice", off, end).Call("arrayBuffer"))
+	if err != nil {
+		return 0, fmt.Errorf("read blob: %w", err)
+	}
+	n := js.CopyBytesToGo(p, js.Global().Get("Uint8Array").New(buf))
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
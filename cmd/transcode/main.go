@@ -0,0 +1,144 @@
+package transcode
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/internal"
+	"github.com/pg9182/tf2vpk/vpkutil"
+	"github.com/spf13/cobra"
+)
+
+var Flags struct {
+	VPK            tf2vpk.ValvePakRef
+	Output         string
+	IncludeExclude func(tf2vpk.ValvePakFile) (bool, error)
+	Only           []string
+	Verbose        bool
+	Writer         func(*tf2vpk.Writer) error
+}
+
+var Command = &cobra.Command{
+	GroupID: root.GroupVPKRepack.ID,
+	Use:     "transcode vpk_path",
+	Short:   "Rewrites a VPK with different chunking, compression, or block settings",
+	Long: `Rewrites a VPK with different chunking, compression, or block settings
+
+Each file is decompressed and recompressed into a new VPK using the writer flags (e.g., --chunk-size, --store, --store-all, --block-size, --single-file, --align), streaming the files one at a time without extracting them. The load and texture flags are kept, and unless --preload is specified, so is the preload data. The compression level can't be changed since the LZHAM encoder only supports the level the game uses.
+
+With --only, only the files matching one of the provided globs are recompressed, and the chunks of the other files are copied as-is, so they stay byte-identical (e.g., --only '*.wav' --chunk-size 256KiB to only rechunk the sounds). Files excluded with --include, --exclude, or --type are not copied at all.
+
+To copy the chunks as-is without recompressing them, use merge or optim instead.
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		main()
+	},
+}
+
+func init() {
+	root.ArgVPK(&Flags.VPK, Command, -1, false, false, false)
+	root.FlagWriter(&Flags.Writer, Command, true)
+	root.FlagIncludeExclude(&Flags.IncludeExclude, Command, true)
+	Command.Flags().StringSliceVar(&Flags.Only, "only", nil, "only recompress files or directories matching one of the provided globs, copying the others as-is")
+	Command.Flags().StringVarP(&Flags.Output, "output", "o", "", "the vpk to write (required)")
+	Command.Flags().BoolVarP(&Flags.Verbose, "verbose", "v", false, "print the number of files in the output")
+	root.FlagJSON(Command)
+	root.Command.AddCommand(Command)
+}
+
+func main() {
+	if Flags.Output == "" {
+		root.Errorf("no output vpk specified")
+		os.Exit(2)
+	}
+	out, err := root.VPK(Flags.Output)
+	if err != nil {
+		root.Errorf("%v", err)
+		os.Exit(2)
+	}
+	if out.Resolve(tf2vpk.ValvePakIndexDir) == Flags.VPK.Resolve(tf2vpk.ValvePakIndexDir) {
+		root.Errorf("output vpk must be different from the input")
+		os.Exit(2)
+	}
+	only := make([]string, len(Flags.Only))
+	for i, x := range Flags.Only {
+		only[i] = strings.ToLower(strings.ReplaceAll(x, `\`, "/"))
+		if _, err := path.Match(strings.TrimPrefix(only[i], "/"), ""); err != nil {
+			root.Errorf("invalid --only glob %q: %v", x, err)
+			os.Exit(2)
+		}
+	}
+
+	r, err := root.NewReader(Flags.VPK, false)
+	if err != nil {
+		root.Fatalf("open vpk: %v", err)
+	}
+	defer r.Close()
+
+	w := tf2vpk.NewWriter(out)
+	if err := Flags.Writer(w); err != nil {
+		root.Errorf("%v", err)
+		os.Exit(2)
+	}
+
+	var totalFiles, totalBytes int64
+	for _, f := range r.Root.File {
+		if skip, err := Flags.IncludeExclude(f); err != nil {
+			root.Fatalf("%v", err)
+		} else if !skip {
+			totalFiles++
+			totalBytes += int64(f.Size())
+		}
+	}
+	progress := root.Progress("transcode", totalFiles, totalBytes)
+
+	var keep func(tf2vpk.ValvePakFile) (bool, error)
+	if len(only) != 0 {
+		keep = func(f tf2vpk.ValvePakFile) (bool, error) {
+			for _, x := range only {
+				if m, _ := internal.MatchGlobParents(x, f.Path); m {
+					return false, nil
+				}
+			}
+			return true, nil
+		}
+	}
+
+	var (
+		last int64
+		cur  string
+	)
+	if err := vpkutil.Transcode(w, r, Flags.IncludeExclude, keep, max(root.Flags.Jobs, 1), func(done, total int64, path string) {
+		progress.AddBytes(done - last)
+		last = done
+		if path != cur {
+			if cur != "" {
+				progress.AddFiles(1)
+			}
+			cur = path
+		}
+	}); err != nil {
+		w.Abort()
+		root.Fatalf("%v", err)
+	}
+	if err := w.Close(); err != nil {
+		root.Fatalf("write vpk: %v", err)
+	}
+	if cur != "" {
+		progress.AddFiles(1)
+	}
+	progress.Done()
+
+	if root.Flags.JSON {
+		root.EmitJSON("summary", struct {
+			Files int `json:"files"`
+		}{len(w.Root.File)})
+	} else if Flags.Verbose {
+		fmt.Printf("transcoded %d files\n", len(w.Root.File))
+	}
+}
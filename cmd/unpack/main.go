@@ -1,12 +1,17 @@
 package unpack
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/pg9182/tf2vpk"
 	"github.com/pg9182/tf2vpk/cmd/root"
@@ -20,7 +25,16 @@ var Flags struct {
 	Path             string
 	VPKFlagsExplicit bool
 	VPKIgnoreEmpty   bool
+	VPKMeta          bool
+	Resume           bool
+	Salvage          bool
+	Link             string
+	Transform        vpkutil.Transforms
 	Verbose          bool
+	LaxPaths         bool
+	EscapeNames      bool
+	Times            bool
+	TimesFrom        string
 	IncludeExclude   func(tf2vpk.ValvePakFile) (bool, error)
 }
 
@@ -28,9 +42,29 @@ var Command = &cobra.Command{
 	GroupID: root.GroupVPKRepack.ID,
 	Use:     "unpack vpk_path out_path",
 	Short:   "Unpacks a VPK for modification and repacking",
-	Args:    cobra.ExactArgs(2),
+	Long: `Unpacks a VPK for modification and repacking
+
+Extracted files are recorded in a journal (` + journalFilename + `) in the output directory, which is removed once everything has been extracted. If the unpack is interrupted, it can be continued with --resume, which skips files in the journal if the extracted file still has the correct size and checksum.
+
+File paths which could escape the output directory are rejected. Paths which are invalid on Windows (e.g., reserved names like CON) are also rejected unless --lax-paths or --escape-names (the default on Windows) is set. Escaped names are decoded by pack --unescape-names. On Windows, files are written using long paths, so deep trees don't hit MAX_PATH.
+
+With --timestamps, the checksum, size, and modification time of each extracted file are saved to a vpktimes file. With --timestamps-from, files which have the same checksum and size as in a vpktimes file (e.g., the one from a previous unpack of an older version of the vpk) get the recorded modification time instead of the current time, so incremental build tools don't see them as modified. See pack --timestamps-out.
+
+With --link, files with the same contents as one which was already extracted are created as a hard or symbolic link to it instead of being written again. This can save a lot of space for VPKs with many duplicate files (e.g., localized ones), but note that editing a hardlinked file in-place changes all of the files linked to it.
+
+With --transform, the contents of matching files are rewritten as they are extracted (e.g., --transform '*.nut=lf' to normalize line endings in scripts). Transformed files are not linked with --link, are always extracted again when resuming, and are not transformed with --salvage.
+
+With --salvage, files which cannot be read (e.g., due to a truncated block or a corrupted chunk) do not stop the unpack. Damaged chunks are replaced with whatever could be read from them followed by zeros, and every damaged file is listed in ` + damageFilename + ` in the output directory. Damaged files are not recorded in the journal, so they are extracted again when resuming.
+`,
+	Args: cobra.ExactArgs(2),
 	Run: func(cmd *cobra.Command, args []string) {
 		Flags.Path = args[1]
+		switch Flags.Link {
+		case "", "hard", "sym":
+		default:
+			root.Errorf("invalid --link %q (must be hard or sym)", Flags.Link)
+			os.Exit(2)
+		}
 		main()
 	},
 }
@@ -39,39 +73,46 @@ func init() {
 	root.ArgVPK(&Flags.VPK, Command, -1, false, false, false)
 	Command.Flags().BoolVarP(&Flags.VPKFlagsExplicit, "explicit-vpkflags", "x", false, "do not compute inherited vpkflags; generate one line for each file")
 	Command.Flags().BoolVar(&Flags.VPKIgnoreEmpty, "empty-vpkignore", false, "do not add default vpkignore entires")
+	Command.Flags().BoolVarP(&Flags.VPKMeta, "vpkmeta", "m", false, "also save the exact flags and chunking of each file so the vpk can be repacked losslessly")
+	Command.Flags().BoolVarP(&Flags.Times, "timestamps", "T", false, "also save the modification time of each file so it can be restored by future unpacks")
+	Command.Flags().StringVar(&Flags.TimesFrom, "timestamps-from", "", "restore the modification times of unchanged files from a vpktimes file (implies --timestamps)")
+	Command.Flags().BoolVarP(&Flags.Resume, "resume", "r", false, "continue an interrupted unpack into the same directory, skipping files which were already extracted and still match")
+	Command.Flags().BoolVar(&Flags.Salvage, "salvage", false, "extract as much as possible from damaged vpks instead of stopping at the first error, writing a damage report")
+	root.FlagTransform(&Flags.Transform, Command)
+	Command.Flags().StringVar(&Flags.Link, "link", "", "create duplicate files as links to the first extracted copy (hard or sym)")
 	Command.Flags().BoolVarP(&Flags.Verbose, "verbose", "v", false, "display progress information")
+	Command.Flags().BoolVar(&Flags.EscapeNames, "escape-names", runtime.GOOS == "windows", "percent-encode parts of file names which are invalid on windows (e.g., CON.txt becomes %43ON.txt, see pack --unescape-names)")
+	Command.Flags().BoolVar(&Flags.LaxPaths, "lax-paths", false, "allow extracting paths which are invalid on windows (absolute paths and path traversal are always rejected)")
 	root.FlagIncludeExclude(&Flags.IncludeExclude, Command, true)
+	root.FlagJSON(Command)
 	root.Command.AddCommand(Command)
 }
 
 func main() {
 	if Flags.Verbose {
-		fmt.Printf("unpacking vpk to %q\n", Flags.Path)
+		step("unpacking vpk to %q", Flags.Path)
 	}
 
-	r, err := tf2vpk.NewReader(Flags.VPK)
+	r, err := root.NewReader(Flags.VPK, false)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: open vpk: %v\n", err)
-		os.Exit(1)
+		root.Fatalf("open vpk: %v", err)
 	}
 
 	if Flags.Verbose {
 		if Flags.VPKFlagsExplicit {
-			fmt.Printf("... generating .vpkflags (without inheritance)\n")
+			step("... generating .vpkflags (without inheritance)")
 		} else {
-			fmt.Printf("... generating .vpkflags\n")
+			step("... generating .vpkflags")
 		}
 	}
 	var vpkflags vpkutil.VPKFlags
 	if Flags.VPKFlagsExplicit {
 		if err := vpkflags.GenerateExplicit(r.Root); err != nil {
-			fmt.Fprintf(os.Stderr, "error: generate vpkflags without inheritance: %v\n", err)
-			os.Exit(1)
+			root.Fatalf("generate vpkflags without inheritance: %v", err)
 		}
 	} else {
 		if err := vpkflags.Generate(r.Root); err != nil {
-			fmt.Fprintf(os.Stderr, "error: generate vpkflags: %v\n", err)
-			os.Exit(1)
+			root.Fatalf("generate vpkflags: %v", err)
 		}
 	}
 	if err := vpkflags.Test(r.Root); err != nil {
@@ -81,9 +122,9 @@ func main() {
 
 	if Flags.Verbose {
 		if Flags.VPKIgnoreEmpty {
-			fmt.Printf("... generating .vpkignore (without default entries)\n")
+			step("... generating .vpkignore (without default entries)")
 		} else {
-			fmt.Printf("... generating .vpkignore\n")
+			step("... generating .vpkignore")
 		}
 	}
 	var vpkignore vpkutil.VPKIgnore
@@ -91,110 +132,296 @@ func main() {
 		vpkignore.AddDefault()
 	}
 	if err := vpkignore.AddAutoExclusions(r.Root); err != nil {
-		fmt.Fprintf(os.Stderr, "error: generate vpkignore: %v\n", err)
-		os.Exit(1)
+		root.Fatalf("generate vpkignore: %v", err)
+	}
+
+	var prevtimes, vpktimes vpkutil.VPKTimes
+	if Flags.TimesFrom != "" {
+		if err := prevtimes.ParseFile(Flags.TimesFrom); err != nil {
+			root.Fatalf("read vpktimes: %v", err)
+		}
+		Flags.Times = true
+	}
+	stamp := func(f tf2vpk.ValvePakFile, outPath string, size uint64, restore bool) error {
+		if !Flags.Times {
+			return nil
+		}
+		t, ok := prevtimes.Get(f.Path, f.CRC32, size)
+		if ok && restore {
+			if err := os.Chtimes(outPath, t, t); err != nil {
+				return err
+			}
+		} else if fi, err := os.Stat(outPath); err != nil {
+			return err
+		} else {
+			t = fi.ModTime()
+		}
+		return vpktimes.Set(f.Path, f.CRC32, size, t)
+	}
+
+	var vpkmeta vpkutil.VPKMeta
+	if Flags.VPKMeta {
+		if Flags.Verbose {
+			step("... generating .vpkmeta")
+		}
+		if err := vpkmeta.Generate(r.Root); err != nil {
+			root.Fatalf("generate vpkmeta: %v", err)
+		}
 	}
 
 	if Flags.Verbose {
-		fmt.Printf("... creating output directory\n")
+		step("... creating output directory")
 	}
 	if err := os.Mkdir(Flags.Path, 0777); err != nil && !errors.Is(err, fs.ErrExist) {
-		fmt.Fprintf(os.Stderr, "error: create output directory: %v\n", err)
-		os.Exit(1)
+		root.Fatalf("create output directory: %v", err)
+	}
+	base, err := vpkutil.LongPath(Flags.Path)
+	if err != nil {
+		root.Fatalf("resolve output directory: %v", err)
 	}
-	if dis, err := os.ReadDir(Flags.Path); err != nil {
-		fmt.Fprintf(os.Stderr, "error: list output directory: %v\n", err)
-		os.Exit(1)
+	var journal map[string]journalEntry
+	if Flags.Resume {
+		if journal, err = readJournal(filepath.Join(Flags.Path, journalFilename)); err != nil {
+			root.Fatalf("read journal: %v", err)
+		}
+	} else if dis, err := os.ReadDir(Flags.Path); err != nil {
+		root.Fatalf("list output directory: %v", err)
 	} else {
 		for _, di := range dis {
 			if !vpkignore.Match(di.Name()) {
-				fmt.Fprintf(os.Stderr, "error: output directory must not exist or be empty (other than ignored files), found %q\n", di.Name())
-				os.Exit(1)
+				root.Fatalf("output directory must not exist or be empty (other than ignored files), found %q", di.Name())
 			}
 		}
 	}
 
 	if Flags.Verbose {
-		fmt.Printf("... saving .vpkflags\n")
+		step("... saving .vpkflags")
 	}
 	if err := os.WriteFile(filepath.Join(Flags.Path, ".vpkflags"), []byte(vpkflags.String()), 0666); err != nil {
-		fmt.Fprintf(os.Stderr, "error: write .vpkflags: %v\n", err)
-		os.Exit(1)
+		root.Fatalf("write .vpkflags: %v", err)
 	}
 
 	if Flags.Verbose {
-		fmt.Printf("... saving .vpkignore\n")
+		step("... saving .vpkignore")
 	}
 	if err := os.WriteFile(filepath.Join(Flags.Path, ".vpkignore"), []byte(vpkignore.String()), 0666); err != nil {
-		fmt.Fprintf(os.Stderr, "error: write .vpkignore: %v\n", err)
-		os.Exit(1)
+		root.Fatalf("write .vpkignore: %v", err)
 	}
 
-	if Flags.Verbose {
+	if Flags.VPKMeta {
+		if Flags.Verbose {
+			step("... saving .vpkmeta")
+		}
+		if err := os.WriteFile(filepath.Join(Flags.Path, vpkutil.VPKMetaFilename), []byte(vpkmeta.String()), 0666); err != nil {
+			root.Fatalf("write .vpkmeta: %v", err)
+		}
+	}
+
+	if Flags.Verbose && !root.Flags.JSON {
 		fmt.Println()
 	}
+	var totalFiles, totalBytes int64
+	for _, f := range r.Root.File {
+		if skip, _ := Flags.IncludeExclude(f); !skip {
+			totalFiles++
+			totalBytes += int64(f.Size())
+		}
+	}
+	progress := root.Progress("unpack", totalFiles, totalBytes)
+
+	journalFlag := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if !Flags.Resume {
+		journalFlag |= os.O_TRUNC
+	}
+	jf, err := os.OpenFile(filepath.Join(Flags.Path, journalFilename), journalFlag, 0666)
+	if err != nil {
+		root.Fatalf("open journal: %v", err)
+	}
+	defer jf.Close()
+
+	var resumedCount int
+
+	var linkedCount int
+	linkSources := map[linkKey]linkSource{}
+
+	var damage strings.Builder
+	var damagedCount int
+
 	var excludedCount int
 	for i, f := range r.Root.File {
 		if skip, err := Flags.IncludeExclude(f); err != nil {
-			fmt.Fprintf(os.Stderr, "error: %v\n", err)
-			os.Exit(1)
+			root.Fatalf("%v", err)
 		} else if skip {
 			excludedCount++
 			if Flags.Verbose {
-				fmt.Printf("[%4d/%4d] %s (excluded)\n", i+1, len(r.Root.File), f.Path)
+				file(i, len(r.Root.File), f, "excluded", "")
 			}
 			continue
 		}
 
-		var uncompressed uint64
-		for _, c := range f.Chunk {
-			uncompressed += c.UncompressedSize
+		uncompressed := f.Size()
+		name := f.Path
+		if Flags.EscapeNames {
+			name = vpkutil.EscapePath(name)
 		}
-		if Flags.Verbose {
-			fmt.Printf("[%4d/%4d] %s (%s)\n", i+1, len(r.Root.File), f.Path, internal.FormatBytesSI(int64(uncompressed)))
+		outPath, err := vpkutil.SanitizeJoin(base, name, Flags.LaxPaths)
+		if err != nil {
+			root.Fatalf("%v", err)
 		}
 
-		outPath := filepath.Join(Flags.Path, filepath.FromSlash(f.Path))
-
+		if e, ok := journal[f.Path]; ok && e.CRC32 == f.CRC32 && e.Size == uncompressed && checkExtracted(outPath, e) {
+			if _, ok := linkSources[linkKey{f.CRC32, uncompressed}]; !ok {
+				linkSources[linkKey{f.CRC32, uncompressed}] = linkSource{outPath, f}
+			}
+			if err := stamp(f, outPath, uncompressed, false); err != nil {
+				root.Fatalf("save timestamp for %q: %v", f.Path, err)
+			}
+			resumedCount++
+			if Flags.Verbose {
+				file(i, len(r.Root.File), f, "resumed", "")
+			}
+			progress.AddBytes(int64(uncompressed))
+			progress.AddFiles(1)
+			continue
+		}
 		if err := os.MkdirAll(filepath.Dir(outPath), 0777); err != nil {
-			fmt.Fprintf(os.Stderr, "error: create %q: %v\n", outPath, err)
-			os.Exit(1)
+			root.Fatalf("create %q: %v", outPath, err)
 		}
 
-		tf, err := os.CreateTemp(Flags.Path, ".vpk*")
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error: create temp file: %v\n", err)
-			os.Exit(1)
+		transform := Flags.Transform.Match(f.Path)
+
+		if src, ok := linkSources[linkKey{f.CRC32, uncompressed}]; ok && Flags.Link != "" && !transform {
+			if same, err := sameContents(r, f, src); err != nil {
+				root.Fatalf("compare vpk file %q to %q: %v", f.Path, src.Path, err)
+			} else if same {
+				if Flags.Verbose {
+					file(i, len(r.Root.File), f, "linked", src.File.Path)
+				}
+				if err := link(src.Path, outPath, Flags.Link == "sym"); err != nil {
+					root.Fatalf("extract vpk file %q: link: %v", f.Path, err)
+				}
+				if _, err := fmt.Fprintf(jf, "%08X %d %s\n", f.CRC32, uncompressed, f.Path); err != nil {
+					root.Fatalf("write journal: %v", err)
+				}
+				if err := stamp(f, outPath, uncompressed, false); err != nil {
+					root.Fatalf("save timestamp for %q: %v", f.Path, err)
+				}
+				linkedCount++
+				progress.AddBytes(int64(uncompressed))
+				progress.AddFiles(1)
+				continue
+			}
+		}
+		if Flags.Verbose {
+			file(i, len(r.Root.File), f, "extracted", "")
 		}
-		defer tf.Close()
 
-		fr, err := r.OpenFileParallel(f, root.Flags.Threads)
+		tf, err := os.CreateTemp(base, ".vpk*")
 		if err != nil {
-			os.Remove(tf.Name())
-			fmt.Fprintf(os.Stderr, "error: read vpk file %q: %v\n", f.Path, err)
-			os.Exit(1)
+			root.Fatalf("create temp file: %v", err)
 		}
+		defer tf.Close()
 
-		if _, err := io.Copy(tf, fr); err != nil {
-			os.Remove(tf.Name())
-			fmt.Fprintf(os.Stderr, "error: extract vpk file %q: %v\n", f.Path, err)
-			os.Exit(1)
+		var damaged bool
+		if Flags.Salvage {
+			res, err := vpkutil.Salvage(tf, r, f)
+			if err != nil {
+				os.Remove(tf.Name())
+				root.Fatalf("extract vpk file %q: %v", f.Path, err)
+			}
+			if !res.OK(f) {
+				damaged = true
+				damagedCount++
+				root.Warnf("vpk file %q is damaged", f.Path)
+				fmt.Fprintf(&damage, "%s\n", f.Path)
+				if res.CRC32 != f.CRC32 {
+					fmt.Fprintf(&damage, "\tcrc32 %08X, expected %08X\n", res.CRC32, f.CRC32)
+				}
+				for _, d := range res.Damage {
+					fmt.Fprintf(&damage, "\t%s\n", d)
+				}
+			}
+			progress.AddBytes(int64(uncompressed))
+		} else {
+			fr, err := r.OpenFileParallel(f, root.Flags.Jobs)
+			if err != nil {
+				os.Remove(tf.Name())
+				root.Fatalf("read vpk file %q: %v", f.Path, err)
+			}
+
+			if fr, err = Flags.Transform.Apply(f.Path, progress.Reader(fr)); err != nil {
+				os.Remove(tf.Name())
+				root.Fatalf("extract vpk file %q: %v", f.Path, err)
+			}
+
+			if _, err := io.Copy(tf, fr); err != nil {
+				os.Remove(tf.Name())
+				root.Fatalf("extract vpk file %q: %v", f.Path, err)
+			}
 		}
 
 		if err := tf.Close(); err != nil {
 			os.Remove(tf.Name())
-			fmt.Fprintf(os.Stderr, "error: extract vpk file %q: %v\n", f.Path, err)
-			os.Exit(1)
+			root.Fatalf("extract vpk file %q: %v", f.Path, err)
 		}
 
 		if err := os.Rename(tf.Name(), outPath); err != nil {
-			fmt.Fprintf(os.Stderr, "error: extract vpk file %q: rename temp file: %v\n", f.Path, err)
-			os.Exit(1)
+			root.Fatalf("extract vpk file %q: rename temp file: %v", f.Path, err)
+		}
+
+		if !damaged {
+			if err := stamp(f, outPath, uncompressed, !transform); err != nil {
+				root.Fatalf("save timestamp for %q: %v", f.Path, err)
+			}
+			if _, err := fmt.Fprintf(jf, "%08X %d %s\n", f.CRC32, uncompressed, f.Path); err != nil {
+				root.Fatalf("write journal: %v", err)
+			}
+			if _, ok := linkSources[linkKey{f.CRC32, uncompressed}]; !ok && !transform {
+				linkSources[linkKey{f.CRC32, uncompressed}] = linkSource{outPath, f}
+			}
 		}
 
+		progress.AddFiles(1)
+
 		// TODO: maybe extract files in parallel instead of using a parallel reader, might be faster for small files
 	}
-	if Flags.Verbose {
+	progress.Done()
+
+	if Flags.Times {
+		if Flags.Verbose {
+			step("... saving .vpktimes")
+		}
+		if err := os.WriteFile(filepath.Join(Flags.Path, vpkutil.VPKTimesFilename), []byte(vpktimes.String()), 0666); err != nil {
+			root.Fatalf("write .vpktimes: %v", err)
+		}
+	}
+
+	jf.Close()
+	if damagedCount != 0 {
+		if err := os.WriteFile(filepath.Join(Flags.Path, damageFilename), []byte(damage.String()), 0666); err != nil {
+			root.Errorf("write damage report: %v", err)
+		}
+		root.Fatalf("%d files are damaged (see %s)", damagedCount, filepath.Join(Flags.Path, damageFilename))
+	}
+	os.Remove(filepath.Join(Flags.Path, damageFilename))
+	if err := os.Remove(jf.Name()); err != nil {
+		root.Warnf("remove journal: %v", err)
+	}
+
+	if root.Flags.JSON {
+		root.EmitJSON("summary", struct {
+			Files    int `json:"files"`
+			Resumed  int `json:"resumed"`
+			Linked   int `json:"linked"`
+			Excluded int `json:"excluded"`
+		}{len(r.Root.File) - excludedCount, resumedCount, linkedCount, excludedCount})
+	} else if Flags.Verbose {
+		if resumedCount != 0 {
+			fmt.Printf("\n%d files were already extracted", resumedCount)
+		}
+		if linkedCount != 0 {
+			fmt.Printf("\n%d duplicate files were linked", linkedCount)
+		}
 		if excludedCount != 0 {
 			fmt.Printf("\nsuccess (%d files excluded by command-line filter)\n", excludedCount)
 		} else {
@@ -202,3 +429,159 @@ func main() {
 		}
 	}
 }
+
+// step prints a verbose progress message.
+func step(format string, a ...any) {
+	if root.Flags.JSON {
+		root.Infof(strings.TrimPrefix(format, "... "), a...)
+		return
+	}
+	fmt.Printf(format+"\n", a...)
+}
+
+// file prints the status of the i-th of n files, which is excluded, resumed,
+// linked (to the file at src), or extracted.
+func file(i, n int, f tf2vpk.ValvePakFile, status, src string) {
+	if root.Flags.JSON {
+		root.EmitJSON("file", struct {
+			Path   string `json:"path"`
+			Size   uint64 `json:"size"`
+			Status string `json:"status"`
+			Source string `json:"source,omitempty"`
+		}{f.Path, f.Size(), status, src})
+		return
+	}
+	switch status {
+	case "excluded":
+		fmt.Printf("[%4d/%4d] %s (excluded)\n", i+1, n, f.Path)
+	case "resumed":
+		fmt.Printf("[%4d/%4d] %s (already extracted)\n", i+1, n, f.Path)
+	case "linked":
+		fmt.Printf("[%4d/%4d] %s (linked to %s)\n", i+1, n, f.Path, src)
+	default:
+		fmt.Printf("[%4d/%4d] %s (%s)\n", i+1, n, f.Path, internal.FormatBytesSI(int64(f.Size())))
+	}
+}
+
+// journalFilename is the name of the file recording extracted files.
+const journalFilename = ".vpkunpack"
+
+// damageFilename is the name of the file listing damaged files when salvaging.
+const damageFilename = ".vpkdamage"
+
+type journalEntry struct {
+	CRC32 uint32
+	Size  uint64
+}
+
+// readJournal reads the extracted files from the journal. If it doesn't exist,
+// no files are returned.
+func readJournal(name string) (map[string]journalEntry, error) {
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	m := map[string]journalEntry{}
+	for _, line := range strings.Split(string(buf), "\n") {
+		var e journalEntry
+		var p string
+		if crc, rest, ok := strings.Cut(line, " "); ok {
+			if size, path, ok := strings.Cut(rest, " "); ok {
+				c, err1 := strconv.ParseUint(crc, 16, 32)
+				s, err2 := strconv.ParseUint(size, 10, 64)
+				if err1 == nil && err2 == nil {
+					e.CRC32, e.Size, p = uint32(c), s, path
+				}
+			}
+		}
+		if p != "" {
+			m[p] = e // ignore invalid (e.g., partially written) lines
+		}
+	}
+	return m, nil
+}
+
+// checkExtracted checks if the file at name matches e.
+func checkExtracted(name string, e journalEntry) bool {
+	f, err := os.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	h := tf2vpk.NewCRC()
+	n, err := io.Copy(h, f)
+	return err == nil && uint64(n) == e.Size && h.Sum32() == e.CRC32
+}
+
+// linkKey identifies files which may have the same contents.
+type linkKey struct {
+	CRC32 uint32
+	Size  uint64
+}
+
+// linkSource is an extracted file which other files can be linked to.
+type linkSource struct {
+	Path string
+	File tf2vpk.ValvePakFile
+}
+
+// sameContents checks whether f has the same contents as the extracted file
+// src, which must have the same checksum and size. If they don't share the same
+// preload data and chunks, the contents are compared.
+func sameContents(r *tf2vpk.Reader, f tf2vpk.ValvePakFile, src linkSource) (bool, error) {
+	if f.Index == src.File.Index && bytes.Equal(f.Preload, src.File.Preload) && slices.EqualFunc(f.Chunk, src.File.Chunk, func(a, b tf2vpk.ValvePakChunk) bool {
+		return a.Offset == b.Offset && a.CompressedSize == b.CompressedSize && a.UncompressedSize == b.UncompressedSize
+	}) {
+		return true, nil
+	}
+
+	x, err := os.Open(src.Path)
+	if err != nil {
+		return false, err
+	}
+	defer x.Close()
+
+	fr, err := r.OpenFileParallel(f, root.Flags.Jobs)
+	if err != nil {
+		return false, err
+	}
+
+	a, b := make([]byte, 32*1024), make([]byte, 32*1024)
+	for {
+		n, err := io.ReadFull(fr, a)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return false, err
+		}
+		if m, err := io.ReadFull(x, b[:n]); err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return false, err
+		} else if m != n || !bytes.Equal(a[:n], b[:n]) {
+			return false, nil
+		}
+		if n < len(a) {
+			m, err := x.Read(b[:1])
+			if err != nil && err != io.EOF {
+				return false, err
+			}
+			return m == 0, nil
+		}
+	}
+}
+
+// link replaces dst with a hard or symbolic link to src.
+func link(src, dst string, sym bool) error {
+	if err := os.Remove(dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if sym {
+		rel, err := filepath.Rel(filepath.Dir(dst), src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(rel, dst)
+	}
+	return os.Link(src, dst)
+}
@@ -1,71 +1,230 @@
 package verify
 
 import (
+	"bytes"
 	"fmt"
-	"io"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/pg9182/tf2vpk"
 	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/vpkutil"
 	"github.com/spf13/cobra"
 )
 
 var Flags struct {
-	VPK     tf2vpk.ValvePakRef
-	Verbose bool
+	VPK          tf2vpk.ValvePakRef
+	Verbose      bool
+	AllowMissing bool
+	Manifest     string
+	ManifestKey  string
+	ManifestSig  string
 }
 
+var dir string // with --manifest
+
 var Command = &cobra.Command{
 	GroupID: root.GroupVPKRead.ID,
 	Use:     "verify vpk_path",
 	Short:   "Verifies the contents of a VPK",
-	Args:    cobra.ExactArgs(1),
+	Long: `Verifies the contents of a VPK
+
+Chunks are read and decompressed in parallel using the number of jobs set by --jobs, and the checksum of each file is checked once all of its chunks have been read. Files are reported as they finish, which may not be in the order they are stored in the VPK.
+
+With --allow-missing, files stored in missing blocks are skipped instead of failing, so the blocks which are present can be checked.
+
+With --manifest, all VPKs in a directory (the argument, or --vpk-dir or --game if not specified) are checked against an install manifest instead, and each modified, missing, or extra file or VPK is reported. Only files with the expected size and CRC32 are read to check the SHA-256. With --manifest-key, the manifest must have a valid Ed25519 signature from that key (in the manifest path with .sig appended, or --manifest-sig) before it is used.
+`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
+		if Flags.Manifest != "" && len(args) != 0 {
+			dir = args[0]
+		}
 		main()
 	},
 }
 
 func init() {
 	root.ArgVPK(&Flags.VPK, Command, -1, false, false, false)
+	root.FlagAllowMissing(&Flags.AllowMissing, Command)
 	Command.Flags().BoolVarP(&Flags.Verbose, "verbose", "v", false, "display files as they are verified")
+	Command.Flags().StringVar(&Flags.Manifest, "manifest", "", "check all vpks in a directory against an install manifest")
+	Command.Flags().StringVar(&Flags.ManifestKey, "manifest-key", "", "require the manifest to be signed by this base64 ed25519 public key")
+	Command.Flags().StringVar(&Flags.ManifestSig, "manifest-sig", "", "the manifest signature file (defaults to the manifest path with .sig appended)")
+	root.FlagJSON(Command)
+	args := Command.Args
+	Command.Args = func(cmd *cobra.Command, a []string) error {
+		if Flags.Manifest != "" {
+			return cobra.MaximumNArgs(1)(cmd, a)
+		}
+		return args(cmd, a)
+	}
 	root.Command.AddCommand(Command)
 }
 
 func main() {
-	r, err := tf2vpk.NewReader(Flags.VPK)
+	if Flags.Manifest != "" {
+		manifest()
+		return
+	}
+
+	r, err := root.NewReader(Flags.VPK, Flags.AllowMissing)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: open vpk: %v\n", err)
-		os.Exit(1)
+		root.Fatalf("open vpk: %v", err)
 	}
 
-	var failure int
-	for _, f := range r.Root.File {
-		if Flags.Verbose {
-			fmt.Printf("%s: ", f.Path)
-			os.Stderr.Sync()
+	// files in missing blocks are skipped rather than failed
+	var skip func(tf2vpk.ValvePakFile) (bool, error)
+	if m := r.MissingBlocks(); len(m) != 0 {
+		skip = func(f tf2vpk.ValvePakFile) (bool, error) {
+			return slices.Contains(m, f.Index), nil
 		}
-		if err := func() error {
-			r, err := r.OpenFileParallel(f, root.Flags.Threads)
+	}
+
+	progress := root.Progress("verify", int64(len(r.Root.File)), int64(r.Root.TotalUncompressedSize()))
+
+	var (
+		failure int
+		last    int64
+	)
+	_ = vpkutil.VerifyParallel(r, skip, max(root.Flags.Jobs, 1), func(f tf2vpk.ValvePakFile, err error) {
+		if root.Flags.JSON {
+			j := fileJSON{Path: f.Path, OK: err == nil}
 			if err != nil {
-				return err
+				j.Error = err.Error()
+				failure++
 			}
-			if _, err := io.Copy(io.Discard, r); err != nil {
-				return err
-			}
-			return nil
-		}(); err != nil {
+			root.EmitJSON("file", j)
+			progress.AddFiles(1)
+			return
+		}
+		if err != nil {
 			if Flags.Verbose {
-				fmt.Printf("ERROR\n")
+				fmt.Printf("%s: ERROR\n", f.Path)
 			}
 			fmt.Fprintf(os.Stderr, "%s: ERROR - %v\n", f.Path, err)
 			failure++
 		} else {
 			if Flags.Verbose {
-				fmt.Printf("OK\n")
+				fmt.Printf("%s: OK\n", f.Path)
 			}
 		}
-	}
+		progress.AddFiles(1)
+	}, func(done, total int64, path string) {
+		progress.AddBytes(done - last)
+		last = done
+	})
+	progress.Done()
 	if failure != 0 {
 		os.Exit(1)
 	}
 }
+
+// fileJSON is the record written for each file with --json.
+type fileJSON struct {
+	Path  string `json:"path"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// diffJSON is the record written for each difference with --manifest and
+// --json.
+type diffJSON struct {
+	Kind   string `json:"kind"`
+	VPK    string `json:"vpk"`
+	Path   string `json:"path,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func manifest() {
+	if dir == "" {
+		if root.Flags.VPKDir == "" && root.Flags.Game != "" {
+			var err error
+			if root.Flags.VPKDir, err = root.GameVPKDir(); err != nil {
+				root.Fatalf("%v", err)
+			}
+		}
+		if dir = root.Flags.VPKDir; dir == "" {
+			root.Errorf("no vpk directory specified")
+			os.Exit(2)
+		}
+	}
+
+	buf, err := os.ReadFile(Flags.Manifest)
+	if err != nil {
+		root.Fatalf("read manifest: %v", err)
+	}
+	if Flags.ManifestKey != "" {
+		key, err := vpkutil.ParseInstallManifestKey(Flags.ManifestKey)
+		if err != nil {
+			root.Errorf("%v", err)
+			os.Exit(2)
+		}
+		name := Flags.ManifestSig
+		if name == "" {
+			name = Flags.Manifest + ".sig"
+		}
+		sig, err := os.ReadFile(name)
+		if err != nil {
+			root.Fatalf("read manifest signature: %v", err)
+		}
+		if err := vpkutil.VerifyInstallManifestSignature(buf, sig, key); err != nil {
+			root.Fatalf("verify manifest signature: %v", err)
+		}
+	}
+	m, err := vpkutil.ReadInstallManifest(bytes.NewReader(buf))
+	if err != nil {
+		root.Fatalf("read manifest: %v", err)
+	}
+
+	var total, totalBytes int64
+	for _, v := range m.VPKs {
+		for _, f := range v.Files {
+			total++
+			totalBytes += int64(f.Size)
+		}
+	}
+	progress := root.Progress("verify", total, totalBytes)
+
+	var (
+		diffs int
+		last  int64
+	)
+	if err := vpkutil.VerifyInstall(m, dir, max(root.Flags.Jobs, 1), func(d vpkutil.InstallDiff) {
+		diffs++
+		if root.Flags.JSON {
+			root.EmitJSON("diff", diffJSON{d.Kind.String(), d.VPK, d.Path, d.Reason})
+			return
+		}
+		name := d.VPK
+		if d.Path != "" {
+			name += ": " + d.Path
+		}
+		if d.Reason != "" {
+			fmt.Printf("%s: %s (%s)\n", strings.ToUpper(d.Kind.String()), name, d.Reason)
+		} else {
+			fmt.Printf("%s: %s\n", strings.ToUpper(d.Kind.String()), name)
+		}
+	}, func(done, total int64, path string) {
+		progress.AddBytes(done - last)
+		progress.AddFiles(1)
+		last = done
+	}); err != nil {
+		root.Fatalf("%v", err)
+	}
+	progress.Done()
+
+	if root.Flags.JSON {
+		root.EmitJSON("summary", struct {
+			Files int64 `json:"files"`
+			VPKs  int   `json:"vpks"`
+			Diffs int   `json:"diffs"`
+		}{total, len(m.VPKs), diffs})
+	} else if Flags.Verbose || diffs == 0 {
+		fmt.Printf("checked %d files in %d vpks, %d differences\n", total, len(m.VPKs), diffs)
+	}
+	if diffs != 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !tf2vpk_nolzham
+
+package version
+
+import "github.com/pg9182/tf2lzham"
+
+const lzhamWasm = tf2lzham.WebAssembly
+
+// lzhamVersion formats the tf2lzham version.
+func lzhamVersion(dep string) string {
+	version := "tf2lzham "
+	if dep != "" {
+		version += dep
+	} else {
+		version += "unknown"
+	}
+	if tf2lzham.WebAssembly {
+		version += " (wasm)"
+	} else {
+		version += " (native)"
+	}
+	return version
+}
@@ -6,7 +6,6 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/pg9182/tf2lzham"
 	"github.com/pg9182/tf2vpk/cmd/root"
 	"github.com/spf13/cobra"
 )
@@ -23,6 +22,7 @@ var Command = &cobra.Command{
 }
 
 func init() {
+	root.FlagJSON(Command)
 	root.Command.AddCommand(Command)
 }
 
@@ -82,6 +82,21 @@ func main() {
 		panic("no version information")
 	}
 
+	if root.Flags.JSON {
+		var t *time.Time
+		if !vcs.time.IsZero() {
+			t = &vcs.time
+		}
+		root.EmitJSON("version", struct {
+			Revision     string     `json:"revision"`
+			Time         *time.Time `json:"time,omitempty"`
+			Modified     bool       `json:"modified"`
+			TF2LZHAM     string     `json:"tf2lzham,omitempty"`
+			TF2LZHAMWasm bool       `json:"tf2lzham_wasm"`
+		}{vcs.revision, t, vcs.modified, dep.tf2lzham, lzhamWasm})
+		return
+	}
+
 	version := "tf2vpk "
 	if vcs.revision != "" {
 		version += vcs.revision[:7]
@@ -93,16 +108,5 @@ func main() {
 	}
 	fmt.Println(version)
 
-	version = "tf2lzham "
-	if dep.tf2lzham != "" {
-		version += dep.tf2lzham
-	} else {
-		version += "unknown"
-	}
-	if tf2lzham.WebAssembly {
-		version += " (wasm)"
-	} else {
-		version += " (native)"
-	}
-	fmt.Println(version)
+	fmt.Println(lzhamVersion(dep.tf2lzham))
 }
@@ -0,0 +1,10 @@
+//go:build tf2vpk_nolzham
+
+package version
+
+const lzhamWasm = false
+
+// lzhamVersion formats the tf2lzham version.
+func lzhamVersion(dep string) string {
+	return "tf2lzham not included"
+}
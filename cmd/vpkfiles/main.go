@@ -29,6 +29,12 @@ var CommandList = subcommand(
 	},
 	func(args []string, vpk tf2vpk.ValvePakRef, files []string, verbose, dryRun bool) error {
 		for _, f := range files {
+			if root.Flags.JSON {
+				root.EmitJSON("file", struct {
+					Path string `json:"path"`
+				}{filepath.Join(vpk.Path, f)})
+				continue
+			}
 			fmt.Printf("%s\n", filepath.Join(vpk.Path, f))
 		}
 		return nil
@@ -44,7 +50,11 @@ var CommandDelete = subcommand(
 	},
 	func(args []string, vpk tf2vpk.ValvePakRef, files []string, verbose, dryRun bool) error {
 		for _, f := range files {
-			if verbose {
+			if verbose && root.Flags.JSON {
+				root.EmitJSON("delete", struct {
+					Path string `json:"path"`
+				}{f})
+			} else if verbose {
 				fmt.Printf("delete %q\n", f)
 			}
 			if !dryRun {
@@ -79,7 +89,7 @@ var CommandRename = subcommand(
 				continue
 			}
 			if verbose {
-				fmt.Printf("rename %q -> %q\n", f, n)
+				rename(f, n)
 			}
 			if !dryRun {
 				if err := os.Rename(filepath.Join(vpk.Path, f), filepath.Join(vpk.Path, n)); err != nil {
@@ -109,7 +119,7 @@ var CommandPrefix = subcommand(
 				continue
 			}
 			if verbose {
-				fmt.Printf("rename %q -> %q\n", f, n)
+				rename(f, n)
 			}
 			if !dryRun {
 				if err := os.Rename(filepath.Join(vpk.Path, f), filepath.Join(vpk.Path, n)); err != nil {
@@ -121,6 +131,44 @@ var CommandPrefix = subcommand(
 	},
 )
 
+var CommandSets = &cobra.Command{
+	Use:   "sets [dir]",
+	Short: "List the VPKs in a directory with the languages they have a dir index for",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := root.Flags.VPKDir
+		if len(args) != 0 {
+			dir = args[0]
+		}
+		sets, err := tf2vpk.ScanValvePakSets(dir)
+		if err != nil {
+			root.Fatalf("scan vpks: %v", err)
+		}
+		for _, s := range sets {
+			if root.Flags.JSON {
+				root.EmitJSON("set", struct {
+					Name      string   `json:"name"`
+					Languages []string `json:"languages"`
+				}{s.Name, s.Languages})
+				continue
+			}
+			fmt.Printf("%s %s\n", s.Name, strings.Join(s.Languages, ","))
+		}
+	},
+}
+
+// rename prints a renamed file.
+func rename(oldName, newName string) {
+	if root.Flags.JSON {
+		root.EmitJSON("rename", struct {
+			Old string `json:"old"`
+			New string `json:"new"`
+		}{oldName, newName})
+		return
+	}
+	fmt.Printf("rename %q -> %q\n", oldName, newName)
+}
+
 func subcommand(cmd *cobra.Command, fn func(args []string, vpk tf2vpk.ValvePakRef, files []string, verbose, dryRun bool) error) *cobra.Command {
 	var flags struct {
 		VPK     tf2vpk.ValvePakRef
@@ -130,8 +178,7 @@ func subcommand(cmd *cobra.Command, fn func(args []string, vpk tf2vpk.ValvePakRe
 	cmd.Run = func(cmd *cobra.Command, args []string) {
 		files, err := flags.VPK.List()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error: list vpk files: %v\n", err)
-			os.Exit(1)
+			root.Fatalf("list vpk files: %v", err)
 		}
 		// deterministically sort dir first, then paks alphabetically
 		slices.SortStableFunc(files, func(a, b string) int {
@@ -149,8 +196,7 @@ func subcommand(cmd *cobra.Command, fn func(args []string, vpk tf2vpk.ValvePakRe
 			return strings.Compare(a, b)
 		})
 		if err := fn(args, flags.VPK, files, flags.Verbose, flags.DryRun); err != nil {
-			fmt.Fprintf(os.Stderr, "error: %v\n", err)
-			os.Exit(1)
+			root.Fatalf("%v", err)
 		}
 	}
 	if cmd.Use != "list vpk_path" {
@@ -162,9 +208,13 @@ func subcommand(cmd *cobra.Command, fn func(args []string, vpk tf2vpk.ValvePakRe
 }
 
 func init() {
+	for _, c := range []*cobra.Command{CommandList, CommandDelete, CommandRename, CommandPrefix, CommandSets} {
+		root.FlagJSON(c)
+	}
 	Command.AddCommand(CommandList)
 	Command.AddCommand(CommandDelete)
 	Command.AddCommand(CommandRename)
 	Command.AddCommand(CommandPrefix)
+	Command.AddCommand(CommandSets)
 	root.Command.AddCommand(Command)
 }
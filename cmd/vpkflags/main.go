@@ -1,7 +1,6 @@
 package vpkflags
 
 import (
-	"fmt"
 	"os"
 
 	"github.com/pg9182/tf2vpk"
@@ -11,8 +10,9 @@ import (
 )
 
 var Flags struct {
-	VPK      tf2vpk.ValvePakRef
-	Explicit bool
+	VPK          tf2vpk.ValvePakRef
+	Explicit     bool
+	AllowMissing bool
 }
 
 var Command = &cobra.Command{
@@ -27,15 +27,16 @@ var Command = &cobra.Command{
 
 func init() {
 	root.ArgVPK(&Flags.VPK, Command, -1, false, false, false)
+	root.FlagAllowMissing(&Flags.AllowMissing, Command)
 	Command.Flags().BoolVarP(&Flags.Explicit, "explicit", "x", false, "do not compute inherited vpkflags; generate one line for each file")
+	root.FlagJSON(Command)
 	root.Command.AddCommand(Command)
 }
 
 func main() {
-	r, err := tf2vpk.NewReader(Flags.VPK)
+	r, err := root.NewReader(Flags.VPK, Flags.AllowMissing)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: open vpk: %v\n", err)
-		os.Exit(1)
+		root.Fatalf("open vpk: %v", err)
 	}
 
 	var vpkflags vpkutil.VPKFlags
@@ -45,8 +46,13 @@ func main() {
 		err = vpkflags.Generate(r.Root)
 	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: generate vpkflags: %v\n", err)
-		os.Exit(1)
+		root.Fatalf("generate vpkflags: %v", err)
+	}
+	if root.Flags.JSON {
+		root.EmitJSON("vpkflags", struct {
+			Text string `json:"text"`
+		}{vpkflags.String()})
+		return
 	}
 	if _, err := os.Stdout.WriteString(vpkflags.String()); err != nil {
 		os.Exit(1)
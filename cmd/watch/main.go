@@ -0,0 +1,287 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pg9182/tf2vpk"
+	"github.com/pg9182/tf2vpk/cmd/root"
+	"github.com/pg9182/tf2vpk/vpkutil"
+	"github.com/spf13/cobra"
+)
+
+var Flags struct {
+	VPK       tf2vpk.ValvePakRef
+	Path      string
+	Interval  time.Duration
+	Once      bool
+	Verbose   bool
+	Writer    func(*tf2vpk.Writer) error
+	Transform vpkutil.Transforms
+}
+
+var Command = &cobra.Command{
+	GroupID: root.GroupVPKRepack.ID,
+	Use:     "watch vpk_path in_path",
+	Short:   "Packs a directory into a VPK, updating it when files change",
+	Long: `Packs a directory into a VPK, updating it when files change
+
+The directory is packed like the pack command, then checked for changes periodically (by comparing the size and modification time of each file). When something changes, the VPK is rebuilt, only compressing new and modified files. The chunks of the other files are copied from the previous version of the VPK as-is. If the vpkflags, vpkignore, or vpkmeta file changes, everything is packed again.
+
+The new VPK is written to a temporary directory next to the VPK, then moved into place, so the VPK is never left partially written. Errors while packing are reported, and the VPK is left as-is until the next change.
+`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		Flags.Path = args[1]
+		main()
+	},
+}
+
+func init() {
+	root.ArgVPK(&Flags.VPK, Command, -1, false, false, false)
+	root.FlagWriter(&Flags.Writer, Command, true)
+	root.FlagTransform(&Flags.Transform, Command)
+	Command.Flags().DurationVar(&Flags.Interval, "interval", time.Second, "how often to check for changes")
+	Command.Flags().BoolVar(&Flags.Once, "once", false, "update the vpk once, then exit (useful for incremental builds in scripts)")
+	Command.Flags().BoolVarP(&Flags.Verbose, "verbose", "v", false, "display files as they are packed")
+	root.FlagJSON(Command)
+	root.Command.AddCommand(Command)
+}
+
+// fileState is used to detect changes to a file.
+type fileState struct {
+	Size    int64
+	ModTime int64 // unix nanoseconds
+}
+
+// dirState is the state of the input directory.
+type dirState struct {
+	Config map[string]fileState // vpkflags, vpkignore, and vpkmeta
+	Input  map[string]fileState
+}
+
+func main() {
+	var prev *dirState
+	if Flags.Once {
+		// reuse the chunks of files older than the existing vpk
+		if fi, err := os.Stat(Flags.VPK.Resolve(tf2vpk.ValvePakIndexDir)); err == nil {
+			if cur, err := scan(); err == nil {
+				prev = &dirState{Config: cur.Config, Input: map[string]fileState{}}
+				for name, st := range cur.Input {
+					if st.ModTime < fi.ModTime().UnixNano() {
+						prev.Input[name] = st
+					}
+				}
+				for _, st := range cur.Config {
+					if st.ModTime >= fi.ModTime().UnixNano() {
+						prev = nil
+						break
+					}
+				}
+			}
+		}
+	}
+	for {
+		cur, err := scan()
+		if err != nil {
+			root.Errorf("%v", err)
+			if Flags.Once {
+				os.Exit(1)
+			}
+		} else if prev == nil || !maps.Equal(prev.Config, cur.Config) || !maps.Equal(prev.Input, cur.Input) {
+			start := time.Now()
+			if n, err := build(prev, cur); err != nil {
+				root.Errorf("%v", err)
+				if Flags.Once {
+					os.Exit(1)
+				}
+			} else {
+				if root.Flags.JSON {
+					root.EmitJSON("build", struct {
+						Files      int     `json:"files"`
+						Compressed int     `json:"compressed"`
+						Seconds    float64 `json:"seconds"`
+					}{len(cur.Input), n, time.Since(start).Seconds()})
+				} else {
+					fmt.Printf("packed %d files (%d compressed) in %s\n", len(cur.Input), n, time.Since(start).Round(time.Millisecond))
+				}
+			}
+			prev = &cur // even on failure, so we don't retry until something changes
+		}
+		if Flags.Once {
+			return
+		}
+		time.Sleep(Flags.Interval)
+	}
+}
+
+// scan gets the current state of the input directory.
+func scan() (dirState, error) {
+	st := dirState{
+		Config: map[string]fileState{},
+		Input:  map[string]fileState{},
+	}
+	for _, name := range []string{vpkutil.VPKFlagsFilename, vpkutil.VPKIgnoreFilename, vpkutil.VPKMetaFilename} {
+		if fi, err := os.Stat(filepath.Join(Flags.Path, name)); err == nil {
+			st.Config[name] = fileState{fi.Size(), fi.ModTime().UnixNano()}
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return st, err
+		}
+	}
+
+	var vpkignore vpkutil.VPKIgnore
+	if err := vpkignore.ParseFile(filepath.Join(Flags.Path, vpkutil.VPKIgnoreFilename)); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return st, fmt.Errorf("read vpkignore: %w", err)
+		}
+		vpkignore.AddDefault()
+	}
+
+	if err := filepath.WalkDir(Flags.Path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() && d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		rel, err := filepath.Rel(Flags.Path, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if vpkutil.IsPackMetaFile(name) || vpkignore.Match(name) {
+			return nil
+		}
+		fi, err := os.Stat(p) // follow symlinks (e.g., from unpack --link=sym)
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		st.Input[name] = fileState{fi.Size(), fi.ModTime().UnixNano()}
+		return nil
+	}); err != nil {
+		return st, fmt.Errorf("list input directory: %w", err)
+	}
+	return st, nil
+}
+
+// build writes a new version of the VPK for cur, copying the files which
+// haven't changed since prev from the existing VPK. It returns the number of
+// files which were compressed.
+func build(prev *dirState, cur dirState) (int, error) {
+	meta, err := vpkutil.ReadPackMeta(os.DirFS(Flags.Path))
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		old      *tf2vpk.Reader
+		oldFiles map[string]tf2vpk.ValvePakFile
+	)
+	if prev != nil && maps.Equal(prev.Config, cur.Config) {
+		if r, err := root.NewReader(Flags.VPK, false); err == nil {
+			old = r
+			defer func() {
+				if old != nil {
+					old.Close()
+				}
+			}()
+			oldFiles = make(map[string]tf2vpk.ValvePakFile, len(r.Root.File))
+			for _, f := range r.Root.File {
+				oldFiles[f.Path] = f
+			}
+		}
+	}
+
+	staged := Flags.VPK
+	staged.Path = filepath.Join(Flags.VPK.Path, ".vpkwatch")
+	if err := os.RemoveAll(staged.Path); err != nil {
+		return 0, fmt.Errorf("remove old staging directory: %w", err)
+	}
+	if err := os.Mkdir(staged.Path, 0777); err != nil {
+		return 0, fmt.Errorf("create staging directory: %w", err)
+	}
+	defer os.RemoveAll(staged.Path)
+
+	w := tf2vpk.NewWriter(staged)
+	if err := Flags.Writer(w); err != nil {
+		return 0, err
+	}
+
+	names := make([]string, 0, len(cur.Input))
+	for name := range cur.Input {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var compressed int
+	for _, name := range names {
+		if err := func() error {
+			if f, ok := oldFiles[name]; ok && prev.Input[name] == cur.Input[name] {
+				if b, err := old.OpenBlockRaw(f.Index); err == nil {
+					if Flags.Verbose {
+						file("copy", name)
+					}
+					return w.AddRaw(f, b)
+				}
+			}
+			if Flags.Verbose {
+				file("pack", name)
+			}
+			compressed++
+
+			f, err := os.Open(filepath.Join(Flags.Path, filepath.FromSlash(name)))
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			if Flags.Transform.Match(name) {
+				r, err := Flags.Transform.Apply(name, f)
+				if err != nil {
+					return err
+				}
+				load, texture := meta.Flags(name)
+				return w.Add(name, load, texture, r)
+			}
+			if chunks, ok := meta.Chunks(name, uint64(cur.Input[name].Size)); ok {
+				return w.AddChunks(name, chunks, f)
+			}
+			load, texture := meta.Flags(name)
+			return w.Add(name, load, texture, f)
+		}(); err != nil {
+			w.Abort()
+			return 0, fmt.Errorf("pack %q: %w", name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return 0, fmt.Errorf("write vpk: %w", err)
+	}
+	if old != nil {
+		old.Close()
+		old = nil
+	}
+	if err := vpkutil.ReplaceVPK(Flags.VPK, staged); err != nil {
+		return 0, err
+	}
+	return compressed, nil
+}
+
+// file prints an action for a file.
+func file(action, name string) {
+	if root.Flags.JSON {
+		root.EmitJSON(action, struct {
+			Path string `json:"path"`
+		}{name})
+		return
+	}
+	fmt.Printf("%s %s\n", action, name)
+}
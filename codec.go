@@ -0,0 +1,50 @@
+package tf2vpk
+
+import (
+	"sync/atomic"
+)
+
+// Codec compresses and decompresses chunk data. It must be safe for concurrent
+// use.
+type Codec interface {
+	// Name returns a short description of the implementation.
+	Name() string
+
+	// Compress compresses src into dst, returning the number of bytes
+	// written. It returns an error if dst is too small.
+	Compress(dst, src []byte) (int, error)
+
+	// Decompress decompresses src into dst, returning the number of bytes
+	// written. It returns an error if dst is too small.
+	Decompress(dst, src []byte) (int, error)
+}
+
+var codec atomic.Pointer[Codec]
+
+func init() {
+	SetCodec(nil)
+}
+
+// SetCodec replaces the Codec used by all Readers and Writers (e.g., to use an
+// alternative LZHAM implementation, or a stub in tests), returning the previous
+// one. If c is nil, the default one is restored. It should be set before any
+// VPKs are read or written.
+//
+// The default Codec uses tf2lzham (with cgo, or WebAssembly if cgo is disabled
+// or the tf2lzhamgo build tag is set). If the tf2vpk_nolzham build tag is set,
+// tf2lzham isn't linked (the cli also leaves out the lzham command), and the
+// default Codec can only store chunks uncompressed.
+func SetCodec(c Codec) Codec {
+	if c == nil {
+		c = defaultCodec
+	}
+	if old := codec.Swap(&c); old != nil {
+		return *old
+	}
+	return nil
+}
+
+// CurrentCodec returns the Codec used by all Readers and Writers.
+func CurrentCodec() Codec {
+	return *codec.Load()
+}
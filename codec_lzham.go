@@ -0,0 +1,27 @@
+//go:build !tf2vpk_nolzham
+
+package tf2vpk
+
+import "github.com/pg9182/tf2lzham"
+
+var defaultCodec Codec = lzhamCodec{}
+
+// lzhamCodec uses tf2lzham.
+type lzhamCodec struct{}
+
+func (lzhamCodec) Name() string {
+	if tf2lzham.WebAssembly {
+		return "lzham (webassembly)"
+	}
+	return "lzham (native)"
+}
+
+func (lzhamCodec) Compress(dst, src []byte) (int, error) {
+	n, _, _, err := tf2lzham.Compress(dst, src)
+	return n, err
+}
+
+func (lzhamCodec) Decompress(dst, src []byte) (int, error) {
+	n, _, _, err := tf2lzham.Decompress(dst, src)
+	return n, err
+}
@@ -0,0 +1,25 @@
+//go:build tf2vpk_nolzham
+
+package tf2vpk
+
+import "errors"
+
+var defaultCodec Codec = noCodec{}
+
+var errNoCodec = errors.New("lzham support is not included in this build")
+
+// noCodec is used when tf2lzham isn't linked. Writers store chunks
+// uncompressed when compression fails.
+type noCodec struct{}
+
+func (noCodec) Name() string {
+	return "none"
+}
+
+func (noCodec) Compress(dst, src []byte) (int, error) {
+	return 0, errNoCodec
+}
+
+func (noCodec) Decompress(dst, src []byte) (int, error) {
+	return 0, errNoCodec
+}
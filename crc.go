@@ -85,3 +85,45 @@ func (r *hashReader) Read(b []byte) (n int, err error) {
 	r.err = err
 	return
 }
+
+// WriteTo implements io.WriterTo, using the underlying reader's WriteTo if
+// available.
+func (r *hashReader) WriteTo(w io.Writer) (int64, error) {
+	if r.err != nil {
+		if r.err == io.EOF {
+			return 0, nil
+		}
+		return 0, r.err
+	}
+	wt, ok := r.r.(io.WriterTo)
+	if !ok {
+		return io.Copy(w, struct{ io.Reader }{r})
+	}
+	n, err := wt.WriteTo(hashWriter{w, r})
+	if err == nil {
+		err = io.EOF
+		if r.n != r.sz {
+			err = io.ErrUnexpectedEOF
+		} else if r.crc != 0 && r.h.Sum32() != r.crc {
+			err = fmt.Errorf("crc mismatch: expected %08X, got %08X", r.crc, r.h.Sum32())
+		}
+	}
+	r.err = err
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// hashWriter writes to w, updating the hash and size of r.
+type hashWriter struct {
+	w io.Writer
+	r *hashReader
+}
+
+func (h hashWriter) Write(b []byte) (int, error) {
+	n, err := h.w.Write(b)
+	_, _ = h.r.h.Write(b[:n])
+	h.r.n += uint64(n)
+	return n, err
+}
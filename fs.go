@@ -1,10 +1,11 @@
 package tf2vpk
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 )
 
@@ -35,12 +36,8 @@ func SplitName(fn, prefix string) (name string, idx ValvePakIndex, err error) {
 	if i := strings.LastIndex(fn, "_"); i == -1 || i == len(fn)-1 {
 		return "", ValvePakIndexEOF, fmt.Errorf("split %q (prefix %q): vpk block does not have an index suffix", fn, prefix)
 	} else {
-		if idxStr := fn[i+1:]; idxStr == ValvePakIndexDir.String() {
-			idx = ValvePakIndexDir
-		} else if n, err := strconv.ParseUint(idxStr, 10, 16); err != nil {
-			return "", ValvePakIndexEOF, fmt.Errorf("split %q (prefix %q): vpk block has an invalid index suffix: not a dir, and not an index: %w", fn, prefix, err)
-		} else {
-			idx = ValvePakIndex(n)
+		if idx, err = ParseValvePakIndex(fn[i+1:]); err != nil {
+			return "", ValvePakIndexEOF, fmt.Errorf("split %q (prefix %q): vpk block has an invalid index suffix: %w", fn, prefix, err)
 		}
 		fn = fn[:i]
 	}
@@ -58,8 +55,13 @@ func SplitName(fn, prefix string) (name string, idx ValvePakIndex, err error) {
 }
 
 // PathToValvePakRef attempts to return a ValvePak from the provided path. It
-// may or may not exist.
+// may or may not exist. If prefix is empty, it is detected using
+// DetectValvePakRef, and the detected prefix (which is empty for unprefixed
+// VPKs) is set in the returned ref.
 func PathToValvePakRef(filename, prefix string) (ValvePakRef, error) {
+	if prefix == "" {
+		return DetectValvePakRef(filename)
+	}
 	path, fn := filepath.Split(filepath.FromSlash(filename))
 	name, _, err := SplitName(fn, prefix)
 	if err != nil {
@@ -90,6 +92,24 @@ func (v ValvePakRef) Resolve(i ValvePakIndex) string {
 	return filepath.Join(v.Path, fn)
 }
 
+// Open opens the file for block i. If the block file doesn't exist with the
+// name returned by Resolve, but does with a different amount of zero-padding
+// (e.g., from other tools), that file is opened instead.
+func (v ValvePakRef) Open(i ValvePakIndex) (*os.File, error) {
+	f, err := os.Open(v.Resolve(i))
+	if err == nil || i == ValvePakIndexDir || !errors.Is(err, fs.ErrNotExist) {
+		return f, err
+	}
+	if ns, lerr := v.List(); lerr == nil {
+		for _, n := range ns {
+			if _, x, _ := SplitName(n, v.Prefix); x == i {
+				return os.Open(filepath.Join(v.Path, n))
+			}
+		}
+	}
+	return nil, err
+}
+
 func (v ValvePakRef) List() ([]string, error) {
 	if v.Path == "" {
 		v.Path = "."
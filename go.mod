@@ -3,6 +3,7 @@ module github.com/pg9182/tf2vpk
 go 1.21
 
 require (
+	github.com/klauspost/compress v1.17.11
 	github.com/pg9182/tf2lzham v0.0.8
 	github.com/spf13/cobra v1.8.0
 	github.com/spf13/pflag v1.0.5
@@ -0,0 +1,140 @@
+package tf2vpk
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// ValvePakHeader is the header at the start of a VPK dir index. Only the
+// Respawn format (version 2.3, used by Titanfall 2) can be read by
+// ValvePakDir, but the headers of Valve's formats can also be read with
+// ReadValvePakHeader to identify them.
+type ValvePakHeader struct {
+	Magic        uint32
+	MajorVersion uint16 // Valve's formats use a single uint32 version, so this is the version, and MinorVersion is 0
+	MinorVersion uint16
+	TreeSize     uint32 // size of the directory tree following the header
+	DataSize     uint32 // size of the data after the directory tree (preload data for Respawn VPKs, or chunk data stored in the dir index for Valve VPK 2)
+
+	// Valve VPK 2 only.
+	ArchiveMD5Size uint32 // size of the checksums of the chunk data
+	OtherMD5Size   uint32 // size of the checksums of the tree and archive checksums
+	SignatureSize  uint32 // size of the public key and signature
+}
+
+// ValvePakFormat is a known VPK dir index format.
+type ValvePakFormat int
+
+const (
+	ValvePakFormatUnknown ValvePakFormat = iota
+	ValvePakFormatRespawn                // version 2.3 (Titanfall, Titanfall 2, Apex Legends)
+	ValvePakFormatValve1                 // version 1 (older Source games)
+	ValvePakFormatValve2                 // version 2 (newer Source games)
+)
+
+// String returns a human-readable name for the format.
+func (f ValvePakFormat) String() string {
+	switch f {
+	case ValvePakFormatRespawn:
+		return "respawn vpk 2.3"
+	case ValvePakFormatValve1:
+		return "valve vpk 1"
+	case ValvePakFormatValve2:
+		return "valve vpk 2"
+	default:
+		return "unknown"
+	}
+}
+
+// Format identifies the format from the version.
+func (h ValvePakHeader) Format() ValvePakFormat {
+	if h.Magic != ValvePakMagic {
+		return ValvePakFormatUnknown
+	}
+	switch {
+	case h.MajorVersion == ValvePakVersionMajor && h.MinorVersion == ValvePakVersionMinor:
+		return ValvePakFormatRespawn
+	case h.MajorVersion == 1 && h.MinorVersion == 0:
+		return ValvePakFormatValve1
+	case h.MajorVersion == 2 && h.MinorVersion == 0:
+		return ValvePakFormatValve2
+	default:
+		return ValvePakFormatUnknown
+	}
+}
+
+// Size returns the size of the header, which depends on the format. For unknown
+// formats, only the magic and version are known.
+func (h ValvePakHeader) Size() int {
+	switch h.Format() {
+	case ValvePakFormatRespawn:
+		return valvePakHeaderSize
+	case ValvePakFormatValve1:
+		return 4 + 4 + 4
+	case ValvePakFormatValve2:
+		return 4 + 4 + 4*5
+	default:
+		return 4 + 2 + 2
+	}
+}
+
+// ReadValvePakHeader reads the header of a dir index from r. An error is only
+// returned if the magic is incorrect or the header is truncated, so variant
+// formats can be identified (see [ValvePakHeader.Format]). Nothing is read
+// past the end of the header.
+func ReadValvePakHeader(r io.Reader) (ValvePakHeader, error) {
+	var h ValvePakHeader
+	var b [4 + 4 + 4*5]byte
+	if _, err := io.ReadFull(r, b[:4]); err != nil {
+		return h, fmt.Errorf("read dir magic: %w", err)
+	}
+	if h.Magic = binary.LittleEndian.Uint32(b[0:]); h.Magic != ValvePakMagic {
+		return h, fmt.Errorf("read magic: expected %08X, got %08X", ValvePakMagic, h.Magic)
+	}
+	if _, err := io.ReadFull(r, b[4:8]); err != nil {
+		return h, fmt.Errorf("read version: %w", err)
+	}
+	h.MajorVersion = binary.LittleEndian.Uint16(b[4:])
+	h.MinorVersion = binary.LittleEndian.Uint16(b[6:])
+	if n := h.Size(); n > 8 {
+		if _, err := io.ReadFull(r, b[8:n]); err != nil {
+			return h, fmt.Errorf("read %s header: %w", h.Format(), err)
+		}
+	}
+	switch h.Format() {
+	case ValvePakFormatRespawn:
+		h.TreeSize = binary.LittleEndian.Uint32(b[8:])
+		h.DataSize = binary.LittleEndian.Uint32(b[12:])
+	case ValvePakFormatValve1:
+		h.TreeSize = binary.LittleEndian.Uint32(b[8:])
+	case ValvePakFormatValve2:
+		h.TreeSize = binary.LittleEndian.Uint32(b[8:])
+		h.DataSize = binary.LittleEndian.Uint32(b[12:])
+		h.ArchiveMD5Size = binary.LittleEndian.Uint32(b[16:])
+		h.OtherMD5Size = binary.LittleEndian.Uint32(b[20:])
+		h.SignatureSize = binary.LittleEndian.Uint32(b[24:])
+	}
+	return h, nil
+}
+
+// Header returns the header of d. If d was read from a dir index, the tree size
+// is the one which was read, otherwise, it is the size it would be serialized
+// as.
+func (d ValvePakDir) Header() (ValvePakHeader, error) {
+	h := ValvePakHeader{
+		Magic:        d.Magic,
+		MajorVersion: d.MajorVersion,
+		MinorVersion: d.MinorVersion,
+		TreeSize:     d.treeSize,
+		DataSize:     d.DataSize,
+	}
+	if h.TreeSize == 0 {
+		n, err := d.TreeSize()
+		if err != nil {
+			return h, err
+		}
+		h.TreeSize = n
+	}
+	return h, nil
+}
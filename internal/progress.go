@@ -0,0 +1,203 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Progress reports the progress of a long-running operation to a file. If the
+// file is a terminal, a status line is redrawn in-place, otherwise a
+// machine-readable line is written periodically.
+type Progress struct {
+	op         string
+	out        *os.File
+	fn         func(ProgressStatus)
+	tty        bool
+	interval   time.Duration
+	start      time.Time
+	totalFiles int64
+	totalBytes int64
+	files      atomic.Int64
+	bytes      atomic.Int64
+	stop       chan struct{}
+	wg         sync.WaitGroup
+}
+
+// ProgressStatus is the progress of an operation as reported to the function
+// passed to NewProgressFunc.
+type ProgressStatus struct {
+	Op         string `json:"op"`
+	State      string `json:"state"` // running, done
+	Files      int64  `json:"files"`
+	TotalFiles int64  `json:"total_files"` // zero if unknown
+	Bytes      int64  `json:"bytes"`
+	TotalBytes int64  `json:"total_bytes"` // zero if unknown
+	Elapsed    int64  `json:"elapsed"`     // seconds
+	Rate       int64  `json:"rate"`        // bytes per second
+	ETA        int64  `json:"eta"`         // seconds, -1 if unknown
+}
+
+// NewProgress starts reporting progress for op to out. If the totals are not
+// known in advance, they should be zero.
+func NewProgress(out *os.File, op string, totalFiles, totalBytes int64) *Progress {
+	p := newProgress(op, totalFiles, totalBytes)
+	p.out = out
+	if st, err := out.Stat(); err == nil && st.Mode()&os.ModeCharDevice != 0 {
+		p.tty, p.interval = true, time.Second/4
+	} else {
+		p.tty, p.interval = false, time.Second*5
+	}
+	p.run()
+	return p
+}
+
+// NewProgressFunc is like NewProgress, but calls fn periodically instead of
+// writing to a file.
+func NewProgressFunc(fn func(ProgressStatus), op string, totalFiles, totalBytes int64) *Progress {
+	p := newProgress(op, totalFiles, totalBytes)
+	p.fn, p.interval = fn, time.Second*5
+	p.run()
+	return p
+}
+
+func newProgress(op string, totalFiles, totalBytes int64) *Progress {
+	return &Progress{
+		op:         op,
+		start:      time.Now(),
+		totalFiles: totalFiles,
+		totalBytes: totalBytes,
+		stop:       make(chan struct{}),
+	}
+}
+
+func (p *Progress) run() {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		t := time.NewTicker(p.interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-t.C:
+				p.render(false)
+			}
+		}
+	}()
+}
+
+// AddFiles marks n files as done.
+func (p *Progress) AddFiles(n int64) {
+	if p != nil {
+		p.files.Add(n)
+	}
+}
+
+// AddBytes marks n bytes as processed.
+func (p *Progress) AddBytes(n int64) {
+	if p != nil {
+		p.bytes.Add(n)
+	}
+}
+
+// Reader wraps r, marking bytes as processed as they are read.
+func (p *Progress) Reader(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	return progressReader{r, p}
+}
+
+// Done stops reporting progress and writes the final status.
+func (p *Progress) Done() {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	p.wg.Wait()
+	p.render(true)
+}
+
+func (p *Progress) render(final bool) {
+	var (
+		elapsed = time.Since(p.start)
+		files   = p.files.Load()
+		bytes   = p.bytes.Load()
+		rate    float64
+		eta     time.Duration = -1
+	)
+	if s := elapsed.Seconds(); s > 0 {
+		rate = float64(bytes) / s
+	}
+	if p.totalBytes > 0 && rate > 0 && bytes <= p.totalBytes {
+		eta = time.Duration(float64(p.totalBytes-bytes) / rate * float64(time.Second)).Round(time.Second)
+	}
+	if p.fn != nil {
+		st := ProgressStatus{
+			Op:         p.op,
+			State:      "running",
+			Files:      files,
+			TotalFiles: p.totalFiles,
+			Bytes:      bytes,
+			TotalBytes: p.totalBytes,
+			Elapsed:    int64(elapsed.Seconds()),
+			Rate:       int64(rate),
+			ETA:        -1,
+		}
+		if final {
+			st.State = "done"
+		}
+		if eta >= 0 {
+			st.ETA = int64(eta.Seconds())
+		}
+		p.fn(st)
+	} else if p.tty {
+		var b strings.Builder
+		fmt.Fprintf(&b, "\r\x1b[K%s: ", p.op)
+		if p.totalFiles > 0 {
+			fmt.Fprintf(&b, "%d/%d files", files, p.totalFiles)
+		} else {
+			fmt.Fprintf(&b, "%d files", files)
+		}
+		if p.totalBytes > 0 {
+			fmt.Fprintf(&b, ", %s/%s (%.1f%%)", FormatBytesSI(bytes), FormatBytesSI(p.totalBytes), float64(bytes)/float64(p.totalBytes)*100)
+		} else {
+			fmt.Fprintf(&b, ", %s", FormatBytesSI(bytes))
+		}
+		fmt.Fprintf(&b, ", %s/s", FormatBytesSI(int64(rate)))
+		if final {
+			fmt.Fprintf(&b, ", took %s\n", elapsed.Round(time.Second))
+		} else if eta >= 0 {
+			fmt.Fprintf(&b, ", eta %s", eta)
+		}
+		_, _ = p.out.WriteString(b.String())
+	} else {
+		state := "running"
+		if final {
+			state = "done"
+		}
+		etaSeconds := int64(-1) // unknown
+		if eta >= 0 {
+			etaSeconds = int64(eta.Seconds())
+		}
+		_, _ = fmt.Fprintf(p.out, "progress op=%s state=%s files=%d total_files=%d bytes=%d total_bytes=%d elapsed=%d rate=%d eta=%d\n",
+			p.op, state, files, p.totalFiles, bytes, p.totalBytes, int64(elapsed.Seconds()), int64(rate), etaSeconds)
+	}
+}
+
+type progressReader struct {
+	r io.Reader
+	p *Progress
+}
+
+func (r progressReader) Read(b []byte) (n int, err error) {
+	n, err = r.r.Read(b)
+	r.p.bytes.Add(int64(n))
+	return
+}
@@ -3,6 +3,7 @@ package internal
 import (
 	"fmt"
 	"path"
+	"strconv"
 	"strings"
 )
 
@@ -55,6 +56,9 @@ func FormatBytesSI(b int64) string {
 	}
 	const unit = 1000
 	if b < unit {
+		if neg {
+			return fmt.Sprintf("-%d B", b)
+		}
 		return fmt.Sprintf("%d B", b)
 	}
 	div, exp := int64(unit), 0
@@ -68,3 +72,30 @@ func FormatBytesSI(b int64) string {
 		return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "kMGTPE"[exp])
 	}
 }
+
+// ParseBytes parses a byte quantity with an optional SI (k, M, G, T) or binary
+// (Ki, Mi, Gi, Ti) prefix, optionally followed by B.
+func ParseBytes(s string) (uint64, error) {
+	t := strings.TrimSuffix(strings.TrimSpace(s), "B")
+	mul := uint64(1)
+	for _, p := range []struct {
+		Suffix string
+		Mul    uint64
+	}{
+		{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40},
+		{"k", 1e3}, {"K", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12},
+	} {
+		if x, ok := strings.CutSuffix(t, p.Suffix); ok {
+			t, mul = x, p.Mul
+			break
+		}
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(t), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid byte quantity %q", s)
+	}
+	if n != 0 && n*mul/mul != n {
+		return 0, fmt.Errorf("invalid byte quantity %q: too large", s)
+	}
+	return n * mul, nil
+}
@@ -57,3 +57,37 @@ func TestMatchGlobParents(t *testing.T) {
 		}
 	}
 }
+
+func TestParseBytes(t *testing.T) {
+	for _, x := range []struct {
+		In    string
+		Out   uint64
+		Error bool
+	}{
+		{"0", 0, false},
+		{"100", 100, false},
+		{"100B", 100, false},
+		{"2k", 2000, false},
+		{"2K", 2000, false},
+		{"2kB", 2000, false},
+		{"2Ki", 2048, false},
+		{"2KiB", 2048, false},
+		{"1M", 1000000, false},
+		{"1Mi", 1 << 20, false},
+		{"2 GiB", 2 << 30, false},
+		{"1T", 1000000000000, false},
+		{"", 0, true},
+		{"-1", 0, true},
+		{"1.5G", 0, true},
+		{"1X", 0, true},
+		{"99999999999999999999", 0, true},
+		{"99999999999Ti", 0, true},
+	} {
+		n, err := ParseBytes(x.In)
+		if err != nil != x.Error {
+			t.Errorf("ParseBytes(%q): expected error=%t, got %v", x.In, x.Error, err)
+		} else if !x.Error && n != x.Out {
+			t.Errorf("ParseBytes(%q): expected %d, got %d", x.In, x.Out, n)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+//go:build go1.23
+
+package tf2vpk
+
+import (
+	"io"
+	"iter"
+)
+
+// Files returns an iterator over the paths and entries of the files in the VPK,
+// in dir index order.
+func (r *Reader) Files() iter.Seq2[string, ValvePakFile] {
+	return r.Root.Files()
+}
+
+// Files returns an iterator over the paths and entries of the files in the dir
+// index.
+func (d ValvePakDir) Files() iter.Seq2[string, ValvePakFile] {
+	return func(yield func(string, ValvePakFile) bool) {
+		for _, f := range d.File {
+			if !yield(f.Path, f) {
+				return
+			}
+		}
+	}
+}
+
+// Chunks returns an iterator over the indexes and entries of the chunks of the
+// file.
+func (f *ValvePakFile) Chunks() iter.Seq2[int, ValvePakChunk] {
+	return func(yield func(int, ValvePakChunk) bool) {
+		for i, c := range f.Chunk {
+			if !yield(i, c) {
+				return
+			}
+		}
+	}
+}
+
+// All returns an iterator over the remaining files in the directory tree. If
+// an error occurs, it is yielded with an empty file, and iteration stops.
+func (dec *ValvePakDirDecoder) All() iter.Seq2[ValvePakFile, error] {
+	return func(yield func(ValvePakFile, error) bool) {
+		for {
+			f, err := dec.Next()
+			if err == io.EOF {
+				return
+			}
+			if !yield(f, err) || err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package tf2vpk
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Languages contains the dir index prefixes of the languages shipped with
+// Titanfall 2.
+var Languages = []string{
+	"english",
+	"french",
+	"german",
+	"italian",
+	"japanese",
+	"korean",
+	"mspanish",
+	"polish",
+	"portuguese",
+	"russian",
+	"spanish",
+	"tchinese",
+}
+
+// ValvePakSet is a VPK with a dir index for each of one or more languages,
+// sharing the same blocks.
+type ValvePakSet struct {
+	Path      string
+	Name      string
+	Languages []string // sorted
+}
+
+// Ref returns a reference to the VPK for the provided language.
+func (s ValvePakSet) Ref(lang string) (ValvePakRef, error) {
+	if !slices.Contains(s.Languages, lang) {
+		return ValvePakRef{}, fmt.Errorf("vpk %q does not have a dir index for language %q (available: %q)", s.Name, lang, s.Languages)
+	}
+	return ValvePakRef{Path: s.Path, Prefix: lang, Name: s.Name}, nil
+}
+
+// Default returns a reference to the VPK for english if it exists, or the first
+// language otherwise.
+func (s ValvePakSet) Default() ValvePakRef {
+	lang := s.Languages[0]
+	if slices.Contains(s.Languages, "english") {
+		lang = "english"
+	}
+	return ValvePakRef{Path: s.Path, Prefix: lang, Name: s.Name}
+}
+
+// ScanValvePakSets finds the VPKs in dir, grouping the dir indexes for each
+// language by name. Prefixes other than the ones in Languages are recognized if
+// the VPK has a block file without the prefix. Dir indexes without a
+// recognized prefix are grouped under the empty language.
+func ScanValvePakSets(dir string) ([]ValvePakSet, error) {
+	if dir == "" {
+		dir = "."
+	}
+	dirs, blocks, err := scanValvePakNames(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	sets := map[string]*ValvePakSet{}
+	var names []string
+	for _, pn := range dirs {
+		lang, name, ok := splitLanguage(pn, blocks)
+		if !ok {
+			lang, name = "", pn
+		}
+		s, ok := sets[name]
+		if !ok {
+			s = &ValvePakSet{Path: dir, Name: name}
+			sets[name] = s
+			names = append(names, name)
+		}
+		s.Languages = append(s.Languages, lang)
+	}
+	slices.Sort(names)
+
+	res := make([]ValvePakSet, len(names))
+	for i, name := range names {
+		slices.Sort(sets[name].Languages)
+		res[i] = *sets[name]
+	}
+	return res, nil
+}
+
+// DetectValvePakRef is like PathToValvePakRef, but detects the prefix instead
+// of requiring it to be known up front. If filename is a dir index, the prefix
+// is split off using Languages or the names of the blocks next to it, and if
+// neither matches, the dir index is assumed to be unprefixed. Otherwise, the
+// dir index for the block is found in the same directory, preferring english
+// if there is more than one.
+func DetectValvePakRef(filename string) (ValvePakRef, error) {
+	path, fn := filepath.Split(filepath.FromSlash(filename))
+	pn, idx, err := SplitName(fn, "")
+	if err != nil {
+		return ValvePakRef{}, err
+	}
+	if idx == ValvePakIndexDir {
+		_, blocks, _ := scanValvePakNames(path)
+		if lang, name, ok := splitLanguage(pn, blocks); ok {
+			return ValvePakRef{path, lang, name}, nil
+		}
+		return ValvePakRef{path, "", pn}, nil
+	}
+	sets, err := ScanValvePakSets(path)
+	if err != nil {
+		return ValvePakRef{}, fmt.Errorf("detect prefix of %q: %w", fn, err)
+	}
+	for _, s := range sets {
+		if s.Name == pn {
+			ref := s.Default()
+			ref.Path = path
+			return ref, nil
+		}
+	}
+	return ValvePakRef{}, fmt.Errorf("detect prefix of %q: no dir index found", fn)
+}
+
+// scanValvePakNames returns the prefixed names of the dir indexes and the names
+// of the blocks in dir.
+func scanValvePakNames(dir string) (dirs []string, blocks map[string]bool, err error) {
+	if dir == "" {
+		dir = "."
+	}
+	ds, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	blocks = map[string]bool{}
+	for _, d := range ds {
+		if d.IsDir() {
+			continue
+		}
+		if name, idx, err := SplitName(d.Name(), ""); err == nil {
+			if idx == ValvePakIndexDir {
+				dirs = append(dirs, name)
+			} else {
+				blocks[name] = true
+			}
+		}
+	}
+	return dirs, blocks, nil
+}
+
+// splitLanguage splits the language prefix from the name of a dir index. If
+// the dir index doesn't have a recognized prefix, or it has blocks with the
+// same name (i.e., it isn't prefixed), ok is false.
+func splitLanguage(pn string, blocks map[string]bool) (lang, name string, ok bool) {
+	if blocks[pn] {
+		return "", "", false
+	}
+	for _, l := range Languages {
+		if name, ok := strings.CutPrefix(pn, l); ok && name != "" {
+			return l, name, true
+		}
+	}
+	for i := 1; i < len(pn); i++ {
+		if blocks[pn[i:]] {
+			return pn[:i], pn[i:], true
+		}
+	}
+	return "", "", false
+}
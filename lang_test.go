@@ -0,0 +1,153 @@
+package tf2vpk
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeTestSet writes a single-file VPK named name with a dir index for each
+// of prefixes.
+func writeTestSet(t *testing.T, dir, name string, prefixes ...string) {
+	t.Helper()
+	w := NewWriter(ValvePakRef{Path: dir, Prefix: prefixes[0], Name: name})
+	if err := w.Add("file.txt", 1, 0, strings.NewReader(name)); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+	buf, err := os.ReadFile(filepath.Join(dir, JoinName(prefixes[0], name, ValvePakIndexDir)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, prefix := range prefixes[1:] {
+		if err := os.WriteFile(filepath.Join(dir, JoinName(prefix, name, ValvePakIndexDir)), buf, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestScanValvePakSets(t *testing.T) {
+	dir := t.TempDir()
+	writeTestSet(t, dir, "client_mp_common.bsp.pak000", "french", "english")
+	writeTestSet(t, dir, "client_mp_angel_city.bsp.pak000", "german")
+	writeTestSet(t, dir, "pak01", "")
+	writeTestSet(t, dir, "englishtest", "")
+	writeTestSet(t, dir, "server.bsp.pak000", "klingon")
+	if err := os.WriteFile(filepath.Join(dir, "readme.txt"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	sets, err := ScanValvePakSets(dir)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	var act []string
+	for _, s := range sets {
+		if s.Path != dir {
+			t.Errorf("set %q: incorrect path %q", s.Name, s.Path)
+		}
+		act = append(act, s.Name+":"+strings.Join(s.Languages, ","))
+	}
+	if exp := []string{
+		"client_mp_angel_city.bsp.pak000:german",
+		"client_mp_common.bsp.pak000:english,french",
+		"englishtest:",
+		"pak01:",
+		"server.bsp.pak000:klingon",
+	}; !slices.Equal(act, exp) {
+		t.Errorf("expected sets %q, got %q", exp, act)
+	}
+
+	for _, s := range sets {
+		if r, err := NewReader(s.Default()); err != nil {
+			t.Errorf("set %q: open default: %v", s.Name, err)
+		} else {
+			r.Close()
+		}
+	}
+}
+
+func TestValvePakSet(t *testing.T) {
+	s := ValvePakSet{Path: "vpk", Name: "test", Languages: []string{"french", "german"}}
+	if exp, act := (ValvePakRef{"vpk", "french", "test"}), s.Default(); act != exp {
+		t.Errorf("expected default %+v, got %+v", exp, act)
+	}
+	s.Languages = []string{"english", "french"}
+	if exp, act := (ValvePakRef{"vpk", "english", "test"}), s.Default(); act != exp {
+		t.Errorf("expected default %+v, got %+v", exp, act)
+	}
+	s.Languages = []string{""}
+	if exp, act := (ValvePakRef{"vpk", "", "test"}), s.Default(); act != exp {
+		t.Errorf("expected default %+v, got %+v", exp, act)
+	}
+
+	s.Languages = []string{"english", "french"}
+	if ref, err := s.Ref("french"); err != nil {
+		t.Errorf("ref: unexpected error: %v", err)
+	} else if exp := (ValvePakRef{"vpk", "french", "test"}); ref != exp {
+		t.Errorf("expected ref %+v, got %+v", exp, ref)
+	}
+	if _, err := s.Ref("german"); err == nil {
+		t.Errorf("ref: expected error for missing language")
+	} else if !strings.Contains(err.Error(), `"english" "french"`) {
+		t.Errorf("ref: expected error to list available languages, got %v", err)
+	}
+	if _, err := s.Ref(""); err == nil {
+		t.Errorf("ref: expected error for missing unprefixed dir index")
+	}
+}
+
+func TestDetectValvePakRef(t *testing.T) {
+	for _, tc := range []struct {
+		Filename string
+		Ref      ValvePakRef
+	}{
+		{"vpk/pak01_dir.vpk", ValvePakRef{"vpk/", "", "pak01"}},
+		{"vpk/englishclient_mp_common.bsp.pak000_dir.vpk", ValvePakRef{"vpk/", "english", "client_mp_common.bsp.pak000"}},
+		{"frenchpak01_dir.vpk", ValvePakRef{"", "french", "pak01"}},
+	} {
+		if ref, err := PathToValvePakRef(tc.Filename, ""); err != nil {
+			t.Errorf("detect %q: unexpected error: %v", tc.Filename, err)
+		} else if ref != tc.Ref {
+			t.Errorf("detect %q: expected %+v, got %+v", tc.Filename, tc.Ref, ref)
+		}
+	}
+
+	dir := t.TempDir()
+	writeTestSet(t, dir, "pak01", "")
+	writeTestSet(t, dir, "client_mp_common.bsp.pak000", "french", "english")
+	writeTestSet(t, dir, "server.bsp.pak000", "klingon")
+	for _, tc := range []struct {
+		Filename string
+		Prefix   string
+		Name     string
+	}{
+		{"pak01_dir.vpk", "", "pak01"},
+		{"pak01_000.vpk", "", "pak01"},
+		{"frenchclient_mp_common.bsp.pak000_dir.vpk", "french", "client_mp_common.bsp.pak000"},
+		{"client_mp_common.bsp.pak000_000.vpk", "english", "client_mp_common.bsp.pak000"},
+		{"klingonserver.bsp.pak000_dir.vpk", "klingon", "server.bsp.pak000"},
+		{"server.bsp.pak000_000.vpk", "klingon", "server.bsp.pak000"},
+	} {
+		ref, err := PathToValvePakRef(filepath.Join(dir, tc.Filename), "")
+		if err != nil {
+			t.Errorf("detect %q: unexpected error: %v", tc.Filename, err)
+			continue
+		}
+		if ref.Prefix != tc.Prefix || ref.Name != tc.Name {
+			t.Errorf("detect %q: expected prefix %q and name %q, got %+v", tc.Filename, tc.Prefix, tc.Name, ref)
+		}
+		if r, err := NewReader(ref); err != nil {
+			t.Errorf("detect %q: open: %v", tc.Filename, err)
+		} else {
+			r.Close()
+		}
+	}
+	if _, err := PathToValvePakRef(filepath.Join(dir, "missing_000.vpk"), ""); err == nil {
+		t.Errorf("detect block without dir index: expected error")
+	}
+}
@@ -0,0 +1,130 @@
+package tf2vpk
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"sort"
+)
+
+// Overlay is a filesystem layering other filesystems (e.g., Readers for VPKs
+// and os.DirFS for loose files), where files in later layers shadow files with
+// the same path in earlier ones. This mirrors how the game searches for files
+// when mods are installed. Directories contain the entries from all layers.
+type Overlay struct {
+	Layers []fs.FS
+}
+
+var _ fs.StatFS = (*Overlay)(nil)
+
+// NewOverlay creates a new Overlay with the provided layers, from lowest to
+// highest priority.
+func NewOverlay(layers ...fs.FS) *Overlay {
+	return &Overlay{Layers: layers}
+}
+
+// Which returns the index of the layer which name is read from.
+func (o *Overlay) Which(name string) (int, bool) {
+	for i := len(o.Layers) - 1; i >= 0; i-- {
+		if _, err := fs.Stat(o.Layers[i], name); err == nil {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// Stat implements fs.StatFS.
+func (o *Overlay) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	for i := len(o.Layers) - 1; i >= 0; i-- {
+		if fi, err := fs.Stat(o.Layers[i], name); err == nil {
+			return fi, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+// Open implements fs.FS.
+func (o *Overlay) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	var (
+		info    fs.FileInfo
+		seen    = map[string]bool{}
+		entries []fs.DirEntry
+	)
+	for i := len(o.Layers) - 1; i >= 0; i-- {
+		fi, err := fs.Stat(o.Layers[i], name)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		if !fi.IsDir() {
+			if info != nil {
+				continue // shadowed by a directory
+			}
+			return o.Layers[i].Open(name)
+		}
+		if info == nil {
+			info = fi
+		}
+		des, err := fs.ReadDir(o.Layers[i], name)
+		if err != nil {
+			return nil, err
+		}
+		for _, de := range des {
+			if !seen[de.Name()] {
+				seen[de.Name()] = true
+				entries = append(entries, de)
+			}
+		}
+	}
+	if info == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return &overlayDir{name: name, info: info, entry: entries}, nil
+}
+
+type overlayDir struct {
+	name   string
+	info   fs.FileInfo
+	entry  []fs.DirEntry
+	offset int
+}
+
+func (d *overlayDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *overlayDir) Read(b []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: fs.ErrInvalid}
+}
+
+func (d *overlayDir) Close() error {
+	return nil
+}
+
+func (d *overlayDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	n := len(d.entry) - d.offset
+	if n == 0 && count > 0 {
+		return nil, io.EOF
+	}
+	if count > 0 && n > count {
+		n = count
+	}
+	list := make([]fs.DirEntry, n)
+	copy(list, d.entry[d.offset:])
+	d.offset += n
+	return list, nil
+}
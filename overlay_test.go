@@ -0,0 +1,131 @@
+package tf2vpk
+
+import (
+	"errors"
+	"io/fs"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+// errFS is a filesystem where every operation fails with err.
+type errFS struct {
+	err error
+}
+
+func (e errFS) Open(name string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: e.err}
+}
+
+func TestOverlay(t *testing.T) {
+	o := NewOverlay(fstest.MapFS{
+		"a.txt":       {Data: []byte("0:a")},
+		"b.txt":       {Data: []byte("0:b")},
+		"dir/c.txt":   {Data: []byte("0:c")},
+		"dir/d.txt":   {Data: []byte("0:d")},
+		"file/e.txt":  {Data: []byte("0:e")},
+		"subdir.txt":  {Data: []byte("0:subdir")},
+		"only0/f.txt": {Data: []byte("0:f")},
+	}, fstest.MapFS{
+		"b.txt":              {Data: []byte("1:b")},
+		"dir/a.txt":          {Data: []byte("1:a")},
+		"dir/d.txt":          {Data: []byte("1:d")},
+		"file":               {Data: []byte("1:file")},
+		"subdir.txt/g.txt":   {Data: []byte("1:g")},
+		"only1/deep/h.txt":   {Data: []byte("1:h")},
+		"dir/sub/nested.txt": {Data: []byte("1:nested")},
+	})
+
+	for name, exp := range map[string]string{
+		"a.txt":              "0:a", // only in the first layer
+		"b.txt":              "1:b", // later layer shadows earlier one
+		"dir/c.txt":          "0:c",
+		"dir/d.txt":          "1:d",
+		"dir/a.txt":          "1:a",
+		"file":               "1:file", // file shadows directory
+		"subdir.txt/g.txt":   "1:g",    // directory shadows file
+		"only0/f.txt":        "0:f",
+		"only1/deep/h.txt":   "1:h",
+		"dir/sub/nested.txt": "1:nested",
+	} {
+		if buf, err := fs.ReadFile(o, name); err != nil {
+			t.Errorf("read %q: %v", name, err)
+		} else if string(buf) != exp {
+			t.Errorf("read %q: expected %q, got %q", name, exp, buf)
+		}
+	}
+
+	if fi, err := o.Stat("file"); err != nil || fi.IsDir() {
+		t.Errorf("stat file: expected a file, got %v, %v", fi, err)
+	}
+	if fi, err := o.Stat("subdir.txt"); err != nil || !fi.IsDir() {
+		t.Errorf("stat subdir.txt: expected a directory, got %v, %v", fi, err)
+	}
+	if _, err := o.Stat("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("stat missing: expected not found error, got %v", err)
+	}
+	if _, err := o.Open("../a.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("open invalid path: expected not found error, got %v", err)
+	}
+
+	for name, exp := range map[string][]string{
+		".":   {"a.txt", "b.txt", "dir/", "file", "only0/", "only1/", "subdir.txt/"},
+		"dir": {"a.txt", "c.txt", "d.txt", "sub/"},
+	} {
+		des, err := fs.ReadDir(o, name)
+		if err != nil {
+			t.Errorf("readdir %q: %v", name, err)
+			continue
+		}
+		var act []string
+		for _, de := range des {
+			if de.IsDir() {
+				act = append(act, de.Name()+"/")
+			} else {
+				act = append(act, de.Name())
+			}
+		}
+		if !slices.Equal(act, exp) {
+			t.Errorf("readdir %q: expected %q, got %q", name, exp, act)
+		}
+	}
+
+	for name, exp := range map[string]int{
+		"a.txt":       0,
+		"b.txt":       1,
+		"dir":         1,
+		"dir/c.txt":   0,
+		"file":        1,
+		"only0/f.txt": 0,
+		"subdir.txt":  1,
+		"missing":     -1,
+	} {
+		if act, ok := o.Which(name); act != exp || ok != (exp != -1) {
+			t.Errorf("which %q: expected %d, got %d, %t", name, exp, act, ok)
+		}
+	}
+
+	if err := fstest.TestFS(o, "a.txt", "b.txt", "dir/a.txt", "dir/c.txt", "dir/d.txt", "dir/sub/nested.txt", "file", "subdir.txt/g.txt", "only0/f.txt", "only1/deep/h.txt"); err != nil {
+		t.Errorf("testfs: %v", err)
+	}
+}
+
+func TestOverlayError(t *testing.T) {
+	o := NewOverlay(fstest.MapFS{
+		"a.txt": {Data: []byte("a")},
+	}, errFS{fs.ErrPermission})
+	if _, err := o.Stat("a.txt"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("stat: expected permission error, got %v", err)
+	}
+	if _, err := o.Open("a.txt"); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("open: expected permission error, got %v", err)
+	}
+
+	// not found errors fall through to the earlier layers
+	o = NewOverlay(fstest.MapFS{
+		"a.txt": {Data: []byte("a")},
+	}, errFS{fs.ErrNotExist})
+	if buf, err := fs.ReadFile(o, "a.txt"); err != nil || string(buf) != "a" {
+		t.Errorf("read: expected contents from first layer, got %q, %v", buf, err)
+	}
+}
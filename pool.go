@@ -0,0 +1,142 @@
+package tf2vpk
+
+import (
+	"runtime"
+	"sync"
+)
+
+// decompressPool runs background chunk decompression for all parallel readers,
+// so reading many files at once doesn't oversubscribe the CPU.
+type decompressPool struct {
+	jobs chan func()
+	stop chan struct{}
+}
+
+var (
+	decompressPoolMu sync.Mutex
+	decompressPoolN  int
+	decompressPoolP  *decompressPool
+)
+
+// SetDecompressWorkers sets the number of background goroutines shared by all
+// readers created with CreateReaderParallel (and OpenFileParallel) for
+// decompressing chunks ahead of time. If n is zero, it defaults to GOMAXPROCS.
+// If n is negative, chunks are only decompressed as they are read. Jobs already
+// queued on the previous workers are still run.
+func SetDecompressWorkers(n int) {
+	decompressPoolMu.Lock()
+	defer decompressPoolMu.Unlock()
+
+	if decompressPoolP != nil {
+		close(decompressPoolP.stop) // no more jobs can be submitted to it since we hold the lock
+		decompressPoolP = nil
+	}
+	decompressPoolN = n
+}
+
+// submitDecompress runs fn on the shared pool if a worker is available,
+// returning false if it was not submitted.
+func submitDecompress(fn func()) bool {
+	decompressPoolMu.Lock()
+	defer decompressPoolMu.Unlock() // so the pool can't be stopped before fn is queued
+
+	p := decompressPoolP
+	if p == nil {
+		n := decompressPoolN
+		if n == 0 {
+			n = runtime.GOMAXPROCS(0)
+		}
+		if n < 0 {
+			return false
+		}
+		p = &decompressPool{
+			jobs: make(chan func(), n),
+			stop: make(chan struct{}),
+		}
+		for i := 0; i < n; i++ {
+			go p.worker()
+		}
+		decompressPoolP = p
+	}
+
+	select {
+	case p.jobs <- fn:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *decompressPool) worker() {
+	for {
+		select {
+		case fn := <-p.jobs:
+			fn()
+		case <-p.stop:
+			for {
+				select {
+				case fn := <-p.jobs:
+					fn() // run the remaining jobs, since they're being waited on
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// DecompressMemory limits the memory used by readers for reading and
+// decompressing chunks ahead of time. It may be shared between readers (see
+// [Reader.DecompressMemory]) to limit their combined usage.
+type DecompressMemory struct {
+	mu    sync.Mutex
+	limit int64
+	used  int64
+}
+
+// NewDecompressMemory creates a new limit of n bytes. Once it is reached,
+// readers stop reading ahead until memory is freed by chunks being read.
+// Chunks are always read as needed regardless of the limit, so each file being
+// read may use up to one more chunk. If n is zero or negative, the usage is
+// tracked, but not limited.
+func NewDecompressMemory(n int64) *DecompressMemory {
+	return &DecompressMemory{limit: n}
+}
+
+// Used returns the number of bytes currently reserved.
+func (m *DecompressMemory) Used() int64 {
+	if m == nil {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.used
+}
+
+// reserve attempts to reserve n bytes for reading ahead, returning false if it
+// would exceed the limit. If m is nil, it always succeeds.
+func (m *DecompressMemory) reserve(n int64) bool {
+	if m == nil {
+		return true
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.limit > 0 && m.used+n > m.limit {
+		return false
+	}
+	m.used += n
+	return true
+}
+
+// release releases n bytes reserved by reserve.
+func (m *DecompressMemory) release(n int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.used -= n
+}
@@ -0,0 +1,58 @@
+package tf2vpk
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSetDecompressWorkers(t *testing.T) {
+	t.Cleanup(func() { SetDecompressWorkers(0) })
+
+	SetDecompressWorkers(2)
+	var (
+		wg      sync.WaitGroup
+		started = make(chan struct{})
+		block   = make(chan struct{})
+	)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		if !submitDecompress(func() {
+			defer wg.Done()
+			started <- struct{}{}
+			<-block
+		}) {
+			t.Fatalf("job %d not submitted", i)
+		}
+	}
+	<-started
+	<-started
+
+	// fill the queue while the workers are busy
+	var queued int
+	for {
+		wg.Add(1)
+		if !submitDecompress(wg.Done) {
+			wg.Done()
+			break
+		}
+		queued++
+	}
+	if queued == 0 {
+		t.Fatalf("no jobs queued")
+	}
+
+	// queued jobs must still run after the pool is replaced
+	SetDecompressWorkers(1)
+	close(block)
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("queued jobs not run after replacing the pool")
+	}
+}
@@ -0,0 +1,36 @@
+package tf2vpk
+
+import "io"
+
+// ProgressFunc is called to report the progress of a long-running operation.
+// Done and total are numbers of uncompressed bytes, and total is zero if it
+// isn't known in advance. Path is the file currently being processed.
+type ProgressFunc func(done, total int64, path string)
+
+// progressReader calls a ProgressFunc as data is read.
+type progressReader struct {
+	r     io.Reader
+	fn    ProgressFunc
+	done  *int64
+	total int64
+	path  string
+}
+
+// NewProgressReader wraps r, calling fn with the number of bytes read added to
+// *done (which is updated) each time it is read from. If fn is nil, r is
+// returned as-is.
+func NewProgressReader(r io.Reader, fn ProgressFunc, done *int64, total int64, path string) io.Reader {
+	if fn == nil {
+		return r
+	}
+	return &progressReader{r, fn, done, total, path}
+}
+
+func (p *progressReader) Read(b []byte) (n int, err error) {
+	n, err = p.r.Read(b)
+	if n > 0 {
+		*p.done += int64(n)
+		p.fn(*p.done, p.total, p.path)
+	}
+	return
+}
@@ -1,38 +1,100 @@
 package tf2vpk
 
 import (
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
-	"os"
+	"log/slog"
 	"path"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
 // Reader reads Titanfall 2 VPKs.
+//
+// A Reader is safe for concurrent use by multiple goroutines, including the
+// readers returned by it (each of which must only be used by one goroutine at a
+// time), as long as Root is not modified. Close must not be called until all
+// reads are done.
 type Reader struct {
-	Root  ValvePakDir
-	block map[ValvePakIndex]io.ReaderAt
-	close map[ValvePakIndex]io.Closer
+	Root ValvePakDir
+
+	// Logger, if not nil, is used to log debug information about each file
+	// read with OpenFile or OpenFileParallel once it has been read fully
+	// (including the time taken), and warnings about files which fail to be
+	// read. It must not be changed while reading.
+	Logger *slog.Logger
+
+	// DecompressMemory, if not nil, limits the memory used for reading and
+	// decompressing chunks ahead of time when reading files. It may be shared
+	// between readers. It must not be changed while reading.
+	DecompressMemory *DecompressMemory
+
+	block   map[ValvePakIndex]io.ReaderAt
+	missing map[ValvePakIndex]error // blocks which failed to open for partial readers
+	dir     io.ReaderAt             // the entire dir index file
+	dirN    int64                   // the offset of the chunk data in dir
+
+	closeMu sync.Mutex
+	close   map[ValvePakIndex]io.Closer
 }
 
 // NewReader creates a new Reader reading from vpk.
 func NewReader(vpk ValvePakRef) (*Reader, error) {
 	return NewReaderFunc(func(i ValvePakIndex) (io.ReaderAt, error) {
-		return os.Open(vpk.Resolve(i))
+		return vpk.Open(i)
+	})
+}
+
+// OpenReaderPath creates a new Reader reading from the VPK at the provided
+// path. If prefix is empty, it is detected (see [DetectValvePakRef]). The
+// resolved ref is returned along with the Reader.
+func OpenReaderPath(filename, prefix string) (*Reader, ValvePakRef, error) {
+	vpk, err := PathToValvePakRef(filename, prefix)
+	if err != nil {
+		return nil, ValvePakRef{}, err
+	}
+	r, err := NewReader(vpk)
+	if err != nil {
+		return nil, vpk, err
+	}
+	return r, vpk, nil
+}
+
+// NewPartialReader is like NewReader, but tolerates missing block files (e.g.,
+// for listing the files in an incomplete copy of a VPK). See
+// NewPartialReaderFunc.
+func NewPartialReader(vpk ValvePakRef) (*Reader, error) {
+	return NewPartialReaderFunc(func(i ValvePakIndex) (io.ReaderAt, error) {
+		return vpk.Open(i)
 	})
 }
 
 // NewReaderFunc creates a new Reader reading using the provided function. If
 // the returned [io.ReaderAt] implements [io.Closer], it will be called when the
-// Reader is closed.
+// Reader is closed. As required by the [io.ReaderAt] interface, it must be safe
+// to call ReadAt concurrently.
 func NewReaderFunc(open func(ValvePakIndex) (io.ReaderAt, error)) (*Reader, error) {
+	return newReader(open, false)
+}
+
+// NewPartialReaderFunc is like NewReaderFunc, but if open returns an error
+// matching [fs.ErrNotExist] for a block, the error is returned when reading a
+// file from it instead of when creating the Reader. The dir index must still
+// exist. Use MissingBlocks to check which blocks are missing.
+func NewPartialReaderFunc(open func(ValvePakIndex) (io.ReaderAt, error)) (*Reader, error) {
+	return newReader(open, true)
+}
+
+func newReader(open func(ValvePakIndex) (io.ReaderAt, error), partial bool) (*Reader, error) {
 	r := &Reader{
-		block: map[ValvePakIndex]io.ReaderAt{},
-		close: map[ValvePakIndex]io.Closer{},
+		block:   map[ValvePakIndex]io.ReaderAt{},
+		missing: map[ValvePakIndex]error{},
+		close:   map[ValvePakIndex]io.Closer{},
 	}
 
 	// read dir index
@@ -53,13 +115,22 @@ func NewReaderFunc(open func(ValvePakIndex) (io.ReaderAt, error)) (*Reader, erro
 		r.close[ValvePakIndexDir] = dir
 	}
 	r.block[ValvePakIndexDir] = io.NewSectionReader(dir, int64(chunkOffset), 1<<63-1)
+	r.dir, r.dirN = dir, int64(chunkOffset)
 
 	// open blocks
 	var errs []error
 	for _, b := range r.Root.File {
 		if _, ok := r.block[b.Index]; !ok {
+			if _, ok := r.missing[b.Index]; ok {
+				continue
+			}
 			if x, err := open(b.Index); err != nil {
-				errs = append(errs, fmt.Errorf("open vpk block %s: %w", b.Index, err))
+				err = fmt.Errorf("open vpk block %s: %w", b.Index, err)
+				if partial && errors.Is(err, fs.ErrNotExist) {
+					r.missing[b.Index] = err
+				} else {
+					errs = append(errs, err)
+				}
 			} else {
 				if x, ok := x.(io.Closer); ok {
 					r.close[b.Index] = x
@@ -75,8 +146,12 @@ func NewReaderFunc(open func(ValvePakIndex) (io.ReaderAt, error)) (*Reader, erro
 	return r, nil
 }
 
-// Close cleans files opened by the Reader.
+// Close cleans files opened by the Reader. It is safe to call Close more than
+// once.
 func (r *Reader) Close() error {
+	r.closeMu.Lock()
+	defer r.closeMu.Unlock()
+
 	var errs []error
 	if r.close != nil {
 		for i, x := range r.close {
@@ -84,6 +159,7 @@ func (r *Reader) Close() error {
 				errs = append(errs, fmt.Errorf("close data reader for index %d: %w", i, err))
 			}
 		}
+		r.close = nil
 	}
 	if err := errors.Join(errs...); err != nil {
 		return fmt.Errorf("close data readers: %w", err)
@@ -91,36 +167,300 @@ func (r *Reader) Close() error {
 	return nil
 }
 
+// Header returns the header of the dir index as it was read.
+func (r *Reader) Header() ValvePakHeader {
+	h, _ := r.Root.Header() // the tree size was read, so it isn't computed
+	return h
+}
+
 // OpenFile returns a new reader reading the contents of a specific file. The checksum is verified at EOF.
 func (r *Reader) OpenFile(f ValvePakFile) (io.Reader, error) {
-	return f.CreateReader(r.block[f.Index])
+	b, err := r.OpenBlockRaw(f.Index)
+	if err != nil {
+		r.logError(f, err)
+		return nil, err
+	}
+	fr, err := f.createReader(b, 1, r.DecompressMemory)
+	if err != nil {
+		r.logError(f, err)
+		return nil, err
+	}
+	return r.logReader(f, fr), nil
 }
 
 // OpenFileParallel is like OpenFile, but but decompresses chunks in parallel
-// using n goroutines going no more than n compressed chunks ahead.
+// going no more than n compressed chunks ahead (see CreateReaderParallel).
 func (r *Reader) OpenFileParallel(f ValvePakFile, n int) (io.Reader, error) {
-	return f.CreateReaderParallel(r.block[f.Index], n)
+	b, err := r.OpenBlockRaw(f.Index)
+	if err != nil {
+		r.logError(f, err)
+		return nil, err
+	}
+	fr, err := f.createReader(b, n, r.DecompressMemory)
+	if err != nil {
+		r.logError(f, err)
+		return nil, err
+	}
+	return r.logReader(f, fr), nil
+}
+
+// OpenFileRange returns a new reader reading up to n bytes of a specific file
+// starting at off, only decompressing the chunks covering the range (see
+// CreateReaderAt). The checksum is not verified.
+func (r *Reader) OpenFileRange(f ValvePakFile, off, n int64) (io.Reader, error) {
+	b, err := r.OpenBlockRaw(f.Index)
+	if err != nil {
+		r.logError(f, err)
+		return nil, err
+	}
+	fr, err := f.createReaderAt(b, off, n, r.DecompressMemory)
+	if err != nil {
+		r.logError(f, err)
+		return nil, err
+	}
+	return fr, nil
+}
+
+// VerifyFile reads the contents of a specific file, checking the size and
+// CRC32.
+func (r *Reader) VerifyFile(f ValvePakFile) error {
+	return r.VerifyFileParallel(f, 1)
+}
+
+// VerifyFileParallel is like VerifyFile, but decompresses chunks in parallel
+// (see OpenFileParallel).
+func (r *Reader) VerifyFileParallel(f ValvePakFile, n int) error {
+	fr, err := r.OpenFileParallel(f, n)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(io.Discard, fr)
+	return err
+}
+
+// FileHash contains the hashes of the contents of a file.
+type FileHash struct {
+	Size   uint64
+	CRC32  uint32
+	SHA256 [sha256.Size]byte
+}
+
+// HashFile is like VerifyFileParallel, but also returns the hashes of the file
+// contents.
+func (r *Reader) HashFile(f ValvePakFile, n int) (FileHash, error) {
+	var h FileHash
+	fr, err := r.OpenFileParallel(f, n)
+	if err != nil {
+		return h, err
+	}
+	var (
+		crc = NewCRC()
+		sha = sha256.New()
+	)
+	x, err := io.Copy(io.MultiWriter(crc, sha), fr)
+	if err != nil {
+		return h, err
+	}
+	h.Size = uint64(x)
+	h.CRC32 = crc.Sum32()
+	sha.Sum(h.SHA256[:0])
+	return h, nil
+}
+
+// logError logs a failure to read f.
+func (r *Reader) logError(f ValvePakFile, err error) {
+	if r.Logger != nil {
+		r.Logger.Warn("read file failed", "path", f.Path, "block", f.Index.String(), "error", err)
+	}
+}
+
+// logReader wraps fr to log the file once it has been read if Logger is set.
+func (r *Reader) logReader(f ValvePakFile, fr io.Reader) io.Reader {
+	if r.Logger == nil {
+		return fr
+	}
+	return &loggedReader{r: fr, l: r, f: f, start: time.Now()}
+}
+
+type loggedReader struct {
+	r     io.Reader
+	l     *Reader
+	f     ValvePakFile
+	start time.Time
+	n     int64
+	done  bool
+}
+
+func (r *loggedReader) Read(b []byte) (n int, err error) {
+	n, err = r.r.Read(b)
+	r.n += int64(n)
+	if err != nil && !r.done {
+		r.done = true
+		if err == io.EOF {
+			r.l.Logger.Debug("read file",
+				"path", r.f.Path,
+				"block", r.f.Index.String(),
+				"size", r.n,
+				"compressed_size", r.f.CompressedSize(),
+				"chunks", len(r.f.Chunk),
+				"duration", time.Since(r.start),
+			)
+		} else {
+			r.l.logError(r.f, err)
+		}
+	}
+	return
 }
 
 // OpenChunk returns a new reader reading the contents of a specific chunk.
 func (r *Reader) OpenChunk(f ValvePakFile, c ValvePakChunk) (io.Reader, error) {
-	return c.CreateReader(r.block[f.Index])
+	b, err := r.OpenBlockRaw(f.Index)
+	if err != nil {
+		return nil, err
+	}
+	return c.CreateReader(b)
 }
 
 // OpenChunkRaw returns a new reader reading the raw contents of a specific chunk.
 func (r *Reader) OpenChunkRaw(f ValvePakFile, c ValvePakChunk) (io.Reader, error) {
-	return c.CreateReaderRaw(r.block[f.Index])
+	b, err := r.OpenBlockRaw(f.Index)
+	if err != nil {
+		return nil, err
+	}
+	return c.CreateReaderRaw(b)
 }
 
 // OpenBlockRaw opens a new reader reading the contents of a specific block.
 func (r *Reader) OpenBlockRaw(n ValvePakIndex) (io.ReaderAt, error) {
 	x, ok := r.block[n]
 	if !ok {
+		if err, ok := r.missing[n]; ok {
+			return nil, err
+		}
 		return nil, fmt.Errorf("block %#v out of range", n)
 	}
 	return x, nil
 }
 
+// MissingBlocks returns the blocks which are referenced by files, but which
+// could not be opened because they do not exist. It is always empty unless the
+// Reader was created with NewPartialReader or NewPartialReaderFunc.
+func (r *Reader) MissingBlocks() []ValvePakIndex {
+	idx := make([]ValvePakIndex, 0, len(r.missing))
+	for i := range r.missing {
+		idx = append(idx, i)
+	}
+	sort.Slice(idx, func(a, b int) bool {
+		return idx[a] < idx[b]
+	})
+	return idx
+}
+
+// CheckBounds checks that the chunks of all files are within the blocks they
+// are stored in. Blocks are skipped if their size can't be determined (i.e., if
+// they don't have a Size or Stat method).
+func (r *Reader) CheckBounds() error {
+	size := map[ValvePakIndex]int64{}
+	for i, x := range r.block {
+		if i == ValvePakIndexDir {
+			x = r.dir
+		}
+		n, ok := readerAtSize(x)
+		if !ok {
+			continue
+		}
+		if i == ValvePakIndexDir {
+			n -= r.dirN
+		}
+		size[i] = n
+	}
+	var errs []error
+	for _, f := range r.Root.File {
+		n, ok := size[f.Index]
+		if !ok {
+			continue
+		}
+		for ci, c := range f.Chunk {
+			if off, length := c.RawRange(); off < 0 || length < 0 || off > n-length {
+				errs = append(errs, fmt.Errorf("file %q: chunk %d (offset %d, size %d) is past the end of block %s (size %d)", f.Path, ci, off, length, f.Index, n))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// readerAtSize gets the size of r if possible.
+func readerAtSize(r io.ReaderAt) (int64, bool) {
+	switch x := r.(type) {
+	case interface{ Size() int64 }:
+		return x.Size(), true
+	case interface{ Stat() (fs.FileInfo, error) }:
+		if fi, err := x.Stat(); err == nil && fi.Mode().IsRegular() {
+			return fi.Size(), true
+		}
+	}
+	return 0, false
+}
+
+// Walk calls fn for each file in the VPK in directory order (i.e., the same
+// order as fs.WalkDir), without synthesizing directory entries. The file points
+// into Root.
+//
+// If fn returns fs.SkipDir, the remaining files in the directory containing the
+// file (including subdirectories) are skipped. If fn returns fs.SkipAll, Walk
+// stops and returns nil. Any other error stops the walk and is returned.
+func (r *Reader) Walk(fn func(path string, f *ValvePakFile) error) error {
+	idx := make([]int, len(r.Root.File))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(a, b int) bool {
+		return comparePath(r.Root.File[idx[a]].Path, r.Root.File[idx[b]].Path) < 0
+	})
+	var skip string
+	for _, i := range idx {
+		f := &r.Root.File[i]
+		if skip != "" && strings.HasPrefix(f.Path, skip) {
+			continue
+		}
+		if err := fn(f.Path, f); err != nil {
+			switch err {
+			case fs.SkipDir:
+				if dir := path.Dir(f.Path); dir == "." {
+					return nil
+				} else {
+					skip = dir + "/"
+				}
+			case fs.SkipAll:
+				return nil
+			default:
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// comparePath compares slash-separated paths component by component.
+func comparePath(a, b string) int {
+	for {
+		ac, ar, amore := strings.Cut(a, "/")
+		bc, br, bmore := strings.Cut(b, "/")
+		if ac != bc {
+			return strings.Compare(ac, bc)
+		}
+		switch {
+		case !amore && !bmore:
+			return 0
+		case !amore:
+			return -1
+		case !bmore:
+			return 1
+		}
+		a, b = ar, br
+	}
+}
+
 var (
 	_ fs.FS          = (*Reader)(nil)
 	_ fs.File        = (*readerFile)(nil)
@@ -200,9 +540,7 @@ func (i *readerInfo) Name() string {
 func (i *readerInfo) Size() int64 {
 	var sz uint64
 	if !i.IsDir() {
-		for _, c := range i.file.Chunk {
-			sz += c.UncompressedSize
-		}
+		sz = i.file.Size()
 	}
 	return int64(sz)
 }
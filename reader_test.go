@@ -0,0 +1,644 @@
+package tf2vpk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+type memBlocks map[ValvePakIndex]*bytes.Buffer
+
+func (m memBlocks) create(i ValvePakIndex) (io.Writer, error) {
+	b := new(bytes.Buffer)
+	m[i] = b
+	return b, nil
+}
+
+func (m memBlocks) open(i ValvePakIndex) (io.ReaderAt, error) {
+	b, ok := m[i]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return bytes.NewReader(b.Bytes()), nil
+}
+
+func TestReaderConcurrent(t *testing.T) {
+	files := map[string][]byte{}
+	for i := 0; i < 6; i++ {
+		var b bytes.Buffer
+		for j := 0; b.Len() < i*int(ValvePakMaxChunkUncompressedSize)/3+1; j++ {
+			fmt.Fprintf(&b, "file %d line %d\n", i, j)
+		}
+		files[fmt.Sprintf("dir%d/file%d.txt", i%3, i)] = b.Bytes()
+	}
+
+	m := memBlocks{}
+	w := NewWriterFunc(m.create)
+	for name, data := range files {
+		if err := w.Add(name, 1, 0, bytes.NewReader(data)); err != nil {
+			t.Fatalf("add %q: %v", name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+
+	r, err := NewReaderFunc(m.open)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer r.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for _, f := range r.Root.File {
+				var (
+					buf []byte
+					err error
+				)
+				switch g % 3 {
+				case 0:
+					var fr io.Reader
+					if fr, err = r.OpenFile(f); err == nil {
+						buf, err = io.ReadAll(fr)
+					}
+				case 1:
+					var fr io.Reader
+					if fr, err = r.OpenFileParallel(f, 4); err == nil {
+						var b bytes.Buffer
+						_, err = io.Copy(&b, fr)
+						buf = b.Bytes()
+					}
+				case 2:
+					buf, err = fs.ReadFile(r, f.Path)
+				}
+				if err != nil {
+					t.Errorf("goroutine %d: read %q: %v", g, f.Path, err)
+				} else if !bytes.Equal(buf, files[f.Path]) {
+					t.Errorf("goroutine %d: read %q: incorrect contents", g, f.Path)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestChunkRange(t *testing.T) {
+	var data bytes.Buffer
+	for i := 0; data.Len() < int(ValvePakMaxChunkUncompressedSize)*2; i++ {
+		fmt.Fprintf(&data, "line %d\n", i)
+	}
+
+	m := memBlocks{}
+	w := NewWriterFunc(m.create)
+	if err := w.SetBlock(ValvePakIndexDir); err != nil {
+		t.Fatalf("set block: %v", err)
+	}
+	if err := w.Add("test.txt", 1, 0, bytes.NewReader(data.Bytes())); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+
+	r, err := NewReaderFunc(m.open)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer r.Close()
+
+	f := r.Root.File[0]
+	b, err := r.OpenBlockRaw(f.Index)
+	if err != nil {
+		t.Fatalf("open block: %v", err)
+	}
+	var all []byte
+	for i, c := range f.Chunk {
+		off, n, err := r.Root.ChunkRange(f.Index, c)
+		if err != nil {
+			t.Fatalf("chunk %d: range: %v", i, err)
+		}
+		cr, err := r.OpenChunkRaw(f, c)
+		if err != nil {
+			t.Fatalf("chunk %d: open raw: %v", i, err)
+		}
+		raw, err := io.ReadAll(cr)
+		if err != nil {
+			t.Fatalf("chunk %d: read raw: %v", i, err)
+		}
+		if !bytes.Equal(m[f.Index].Bytes()[off:off+n], raw) {
+			t.Errorf("chunk %d: range %d+%d does not match raw data", i, off, n)
+		}
+		buf, err := c.ReadAll(b)
+		if err != nil {
+			t.Fatalf("chunk %d: read: %v", i, err)
+		}
+		all = append(all, buf...)
+	}
+	if !bytes.Equal(all, data.Bytes()) {
+		t.Errorf("chunk contents do not match file")
+	}
+}
+
+func TestFileRange(t *testing.T) {
+	var data bytes.Buffer
+	for i := 0; data.Len() < int(ValvePakMaxChunkUncompressedSize)*3; i++ {
+		fmt.Fprintf(&data, "line %d\n", i)
+	}
+
+	m := memBlocks{}
+	w := NewWriterFunc(m.create)
+	if err := w.SetBlock(ValvePakIndexDir); err != nil {
+		t.Fatalf("set block: %v", err)
+	}
+	if err := w.Add("test.txt", 1, 0, bytes.NewReader(data.Bytes())); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+
+	r, err := NewReaderFunc(m.open)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer r.Close()
+
+	f := r.Root.File[0]
+	if len(f.Chunk) < 3 {
+		t.Fatalf("expected at least 3 chunks, got %d", len(f.Chunk))
+	}
+	cs := int64(ValvePakMaxChunkUncompressedSize)
+	size := int64(data.Len())
+	for _, x := range [][2]int64{
+		{0, 0},
+		{0, 10},
+		{5, cs},
+		{cs, cs},
+		{cs - 1, 2},
+		{cs + 7, cs * 2},
+		{size - 3, 100},
+		{size, 10},
+		{0, size},
+	} {
+		fr, err := r.OpenFileRange(f, x[0], x[1])
+		if err != nil {
+			t.Errorf("range %d+%d: open: %v", x[0], x[1], err)
+			continue
+		}
+		buf, err := io.ReadAll(fr)
+		if err != nil {
+			t.Errorf("range %d+%d: read: %v", x[0], x[1], err)
+			continue
+		}
+		if exp := data.Bytes()[x[0]:min(x[0]+x[1], size)]; !bytes.Equal(buf, exp) {
+			t.Errorf("range %d+%d: got %d bytes, expected %d matching bytes", x[0], x[1], len(buf), len(exp))
+		}
+	}
+	if _, err := r.OpenFileRange(f, size+1, 1); err == nil {
+		t.Errorf("expected error for offset past end")
+	}
+}
+
+func TestVerifyFile(t *testing.T) {
+	data := bytes.Repeat([]byte("tf2vpk\n"), 10000)
+
+	m := memBlocks{}
+	w := NewWriterFunc(m.create)
+	if err := w.SetBlock(ValvePakIndexDir); err != nil {
+		t.Fatalf("set block: %v", err)
+	}
+	if err := w.Add("test.txt", 1, 0, bytes.NewReader(data)); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+
+	r, err := NewReaderFunc(m.open)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer r.Close()
+
+	f := r.Root.File[0]
+	if err := r.VerifyFile(f); err != nil {
+		t.Errorf("verify: %v", err)
+	}
+	h, err := r.HashFile(f, 4)
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	if h.Size != uint64(len(data)) || h.CRC32 != f.CRC32 || h.SHA256 != sha256.Sum256(data) {
+		t.Errorf("incorrect hash %+v", h)
+	}
+
+	f.CRC32 ^= 1
+	if err := r.VerifyFile(f); err == nil {
+		t.Errorf("expected crc mismatch")
+	}
+	if _, err := r.HashFile(f, 1); err == nil {
+		t.Errorf("expected crc mismatch")
+	}
+}
+
+// sparseBlock is a block containing data at off, and zeros elsewhere.
+type sparseBlock struct {
+	off  int64
+	data []byte
+	size int64
+}
+
+func (b sparseBlock) Size() int64 {
+	return b.size
+}
+
+func (b sparseBlock) ReadAt(p []byte, off int64) (int, error) {
+	if off >= b.size {
+		return 0, io.EOF
+	}
+	n := len(p)
+	if rem := b.size - off; int64(n) > rem {
+		n = int(rem)
+	}
+	clear(p[:n])
+	if s, e := max(off, b.off), min(off+int64(n), b.off+int64(len(b.data))); s < e {
+		copy(p[s-off:e-off], b.data[s-b.off:e-b.off])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func TestLargeOffset(t *testing.T) {
+	var data bytes.Buffer
+	for i := 0; data.Len() < int(ValvePakMaxChunkUncompressedSize)+100; i++ {
+		fmt.Fprintf(&data, "line %d\n", i)
+	}
+	a, b := data.Bytes()[:ValvePakMaxChunkUncompressedSize], data.Bytes()[ValvePakMaxChunkUncompressedSize:]
+
+	zb := make([]byte, len(a))
+	zn, err := CurrentCodec().Compress(zb, a)
+	if err != nil {
+		t.Skipf("codec %s can't compress: %v", CurrentCodec().Name(), err)
+	}
+
+	const off = 5 << 30 // past 4 GiB
+	blk := sparseBlock{off: off, data: append(zb[:zn:zn], b...)}
+	blk.size = off + int64(len(blk.data))
+
+	h := NewCRC()
+	h.Write(data.Bytes())
+
+	var root ValvePakDir
+	root.Magic, root.MajorVersion, root.MinorVersion = ValvePakMagic, ValvePakVersionMajor, ValvePakVersionMinor
+	root.File = []ValvePakFile{{
+		Path:  "large.txt",
+		CRC32: h.Sum32(),
+		Index: 0,
+		Chunk: []ValvePakChunk{
+			{LoadFlags: 1, Offset: off, CompressedSize: uint64(zn), UncompressedSize: uint64(len(a))},
+			{LoadFlags: 1, Offset: off + uint64(zn), CompressedSize: uint64(len(b)), UncompressedSize: uint64(len(b))},
+		},
+	}}
+	var dir bytes.Buffer
+	if err := root.Serialize(&dir); err != nil {
+		t.Fatalf("serialize: %v", err)
+	}
+
+	r, err := NewReaderFunc(func(i ValvePakIndex) (io.ReaderAt, error) {
+		switch i {
+		case ValvePakIndexDir:
+			return bytes.NewReader(dir.Bytes()), nil
+		case 0:
+			return blk, nil
+		}
+		return nil, fs.ErrNotExist
+	})
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	if c := r.Root.File[0].Chunk[1]; c.Offset != off+uint64(zn) {
+		t.Errorf("offset not preserved: got %d", c.Offset)
+	}
+	if err := r.CheckBounds(); err != nil {
+		t.Errorf("check bounds: %v", err)
+	}
+	if buf, err := fs.ReadFile(r, "large.txt"); err != nil {
+		t.Errorf("read: %v", err)
+	} else if !bytes.Equal(buf, data.Bytes()) {
+		t.Errorf("read: incorrect contents")
+	}
+
+	r.block[0] = sparseBlock{off: off, data: blk.data, size: blk.size - 1}
+	if err := r.CheckBounds(); err == nil {
+		t.Errorf("check bounds: expected error for truncated block")
+	}
+}
+
+func TestPreload(t *testing.T) {
+	vpk := ValvePakRef{Path: t.TempDir(), Prefix: "english", Name: "test"}
+	files := map[string]string{
+		"a.vmt":   strings.Repeat("material ", 1000),
+		"b.vmt":   "xy",
+		"c.txt":   "not preloaded",
+		"d/e.vmt": "z",
+	}
+
+	w := NewWriter(vpk)
+	w.Preload = func(name string) int {
+		if strings.HasSuffix(name, ".vmt") {
+			return 16
+		}
+		return 0
+	}
+	for _, name := range []string{"a.vmt", "b.vmt", "c.txt", "d/e.vmt"} {
+		if err := w.Add(name, 1, 0, strings.NewReader(files[name])); err != nil {
+			t.Fatalf("add %q: %v", name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+
+	r, err := NewReader(vpk)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer r.Close()
+	for _, f := range r.Root.File {
+		exp := map[string]int{"a.vmt": 16, "b.vmt": 1}[f.Path]
+		if int(f.PreloadBytes) != exp || len(f.Preload) != exp || string(f.Preload) != files[f.Path][:exp] {
+			t.Errorf("%q: expected %d preload bytes, got %d %q", f.Path, exp, f.PreloadBytes, f.Preload)
+		}
+		if len(f.Chunk) == 0 {
+			t.Errorf("%q: expected at least one chunk", f.Path)
+		}
+	}
+	for name, exp := range files {
+		if buf, err := fs.ReadFile(r, name); err != nil {
+			t.Errorf("read %q: %v", name, err)
+		} else if string(buf) != exp {
+			t.Errorf("read %q: incorrect contents", name)
+		}
+		if fi, err := fs.Stat(r, name); err != nil || fi.Size() != int64(len(exp)) {
+			t.Errorf("stat %q: incorrect size", name)
+		}
+	}
+}
+
+// rleCodec compresses chunks consisting of a single repeated byte.
+type rleCodec struct {
+	compressed, decompressed atomic.Int32
+}
+
+func (*rleCodec) Name() string {
+	return "rle"
+}
+
+func (c *rleCodec) Compress(dst, src []byte) (int, error) {
+	if len(dst) < 1 || len(src) == 0 || len(bytes.Trim(src, string(src[:1]))) != 0 {
+		return 0, fmt.Errorf("incompressible")
+	}
+	c.compressed.Add(1)
+	dst[0] = src[0]
+	return 1, nil
+}
+
+func (c *rleCodec) Decompress(dst, src []byte) (int, error) {
+	if len(src) != 1 {
+		return 0, fmt.Errorf("invalid data")
+	}
+	c.decompressed.Add(1)
+	for i := range dst {
+		dst[i] = src[0]
+	}
+	return len(dst), nil
+}
+
+func TestSetCodec(t *testing.T) {
+	c := new(rleCodec)
+	defer SetCodec(SetCodec(c))
+
+	files := map[string][]byte{
+		"a.txt": bytes.Repeat([]byte("a"), int(ValvePakMaxChunkUncompressedSize)+5),
+		"b.txt": []byte("not compressible"),
+	}
+
+	m := memBlocks{}
+	w := NewWriterFunc(m.create)
+	for name, data := range files {
+		if err := w.Add(name, 1, 0, bytes.NewReader(data)); err != nil {
+			t.Fatalf("add %q: %v", name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+	if n := c.compressed.Load(); n != 2 {
+		t.Errorf("expected 2 chunks to be compressed, got %d", n)
+	}
+	if n := m[0].Len(); n != 2+len(files["b.txt"]) {
+		t.Errorf("incorrect block size %d", n)
+	}
+
+	r, err := NewReaderFunc(m.open)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer r.Close()
+	for name, data := range files {
+		if buf, err := fs.ReadFile(r, name); err != nil {
+			t.Errorf("read %q: %v", name, err)
+		} else if !bytes.Equal(buf, data) {
+			t.Errorf("read %q: incorrect contents", name)
+		}
+	}
+	if n := c.decompressed.Load(); n != 2 {
+		t.Errorf("expected 2 chunks to be decompressed, got %d", n)
+	}
+}
+
+func TestPartialReader(t *testing.T) {
+	m := memBlocks{}
+	w := NewWriterFunc(m.create)
+	if err := w.Add("a.txt", 1, 0, strings.NewReader("aaaa")); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := w.SetBlock(1); err != nil {
+		t.Fatalf("set block: %v", err)
+	}
+	if err := w.Add("b.txt", 1, 0, strings.NewReader("bbbb")); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+	delete(m, 1)
+
+	if _, err := NewReaderFunc(m.open); err == nil {
+		t.Fatalf("expected error for missing block")
+	}
+	r, err := NewPartialReaderFunc(m.open)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer r.Close()
+	if x := r.MissingBlocks(); len(x) != 1 || x[0] != 1 {
+		t.Errorf("incorrect missing blocks %v", x)
+	}
+	if buf, err := fs.ReadFile(r, "a.txt"); err != nil || string(buf) != "aaaa" {
+		t.Errorf("read a.txt: %q %v", buf, err)
+	}
+	if _, err := fs.ReadFile(r, "b.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("read b.txt: expected missing block error, got %v", err)
+	}
+}
+
+func TestDecompressMemory(t *testing.T) {
+	var data bytes.Buffer
+	for i := 0; data.Len() < int(ValvePakMaxChunkUncompressedSize)*8; i++ {
+		fmt.Fprintf(&data, "line %d\n", i)
+	}
+
+	m := memBlocks{}
+	w := NewWriterFunc(m.create)
+	if err := w.Add("a.txt", 1, 0, bytes.NewReader(data.Bytes())); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+
+	for _, limit := range []int64{0, 1, int64(ValvePakMaxChunkUncompressedSize) * 2} {
+		mem := NewDecompressMemory(limit)
+
+		// readers sharing the limit
+		var rs []*Reader
+		for i := 0; i < 2; i++ {
+			r, err := NewReaderFunc(m.open)
+			if err != nil {
+				t.Fatalf("read vpk: %v", err)
+			}
+			defer r.Close()
+			r.DecompressMemory = mem
+			rs = append(rs, r)
+		}
+
+		var frs []io.Reader
+		for _, r := range rs {
+			fr, err := r.OpenFileParallel(r.Root.File[0], 8)
+			if err != nil {
+				t.Fatalf("open file: %v", err)
+			}
+			if _, err := io.ReadFull(fr, make([]byte, 1)); err != nil {
+				t.Fatalf("read file: %v", err)
+			}
+			frs = append(frs, fr)
+		}
+		if used := mem.Used(); limit > 0 && used > limit {
+			t.Errorf("limit %d: %d bytes used", limit, used)
+		} else if limit == 0 && used == 0 {
+			t.Errorf("limit %d: memory usage not tracked", limit)
+		}
+		for _, fr := range frs {
+			buf, err := io.ReadAll(fr)
+			if err != nil {
+				t.Fatalf("limit %d: read file: %v", limit, err)
+			}
+			if !bytes.Equal(buf, data.Bytes()[1:]) {
+				t.Errorf("limit %d: incorrect contents", limit)
+			}
+		}
+		if used := mem.Used(); used != 0 {
+			t.Errorf("limit %d: %d bytes not released", limit, used)
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	m := memBlocks{}
+	w := NewWriterFunc(m.create)
+	for _, name := range []string{"zz.txt", "a/z.txt", "a.txt", "a-b/x.txt", "a/c/d.txt", "z/y.txt", "b.txt", "a/b.txt"} {
+		if err := w.Add(name, 1, 0, strings.NewReader(name)); err != nil {
+			t.Fatalf("add %q: %v", name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+
+	r, err := NewReaderFunc(m.open)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer r.Close()
+
+	var exp []string
+	if err := fs.WalkDir(r, ".", func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			exp = append(exp, path)
+		}
+		return err
+	}); err != nil {
+		t.Fatalf("walkdir: %v", err)
+	}
+	if x := []string{"a/b.txt", "a/c/d.txt", "a/z.txt", "a-b/x.txt", "a.txt", "b.txt", "z/y.txt", "zz.txt"}; !slices.Equal(exp, x) {
+		t.Fatalf("expected walkdir order %q, got %q", x, exp)
+	}
+
+	walk := func(stop string, ret error) ([]string, error) {
+		var act []string
+		err := r.Walk(func(path string, f *ValvePakFile) error {
+			if f.Path != path {
+				t.Errorf("%q: incorrect file %q", path, f.Path)
+			}
+			if i := slices.IndexFunc(r.Root.File, func(x ValvePakFile) bool { return x.Path == path }); f != &r.Root.File[i] {
+				t.Errorf("%q: file does not point into root", path)
+			}
+			act = append(act, path)
+			if path == stop {
+				return ret
+			}
+			return nil
+		})
+		return act, err
+	}
+	errTest := errors.New("test")
+	for _, tc := range []struct {
+		Stop string
+		Err  error
+		Exp  []string
+	}{
+		{"", nil, exp},
+		{"a/b.txt", fs.SkipDir, []string{"a/b.txt", "a-b/x.txt", "a.txt", "b.txt", "z/y.txt", "zz.txt"}},
+		{"a/c/d.txt", fs.SkipDir, []string{"a/b.txt", "a/c/d.txt", "a/z.txt", "a-b/x.txt", "a.txt", "b.txt", "z/y.txt", "zz.txt"}},
+		{"a.txt", fs.SkipDir, []string{"a/b.txt", "a/c/d.txt", "a/z.txt", "a-b/x.txt", "a.txt"}},
+		{"a/c/d.txt", fs.SkipAll, []string{"a/b.txt", "a/c/d.txt"}},
+		{"a-b/x.txt", errTest, []string{"a/b.txt", "a/c/d.txt", "a/z.txt", "a-b/x.txt"}},
+	} {
+		act, err := walk(tc.Stop, tc.Err)
+		if tc.Err == errTest {
+			if !errors.Is(err, errTest) {
+				t.Errorf("%v at %q: expected error, got %v", tc.Err, tc.Stop, err)
+			}
+		} else if err != nil {
+			t.Errorf("%v at %q: unexpected error: %v", tc.Err, tc.Stop, err)
+		}
+		if !slices.Equal(act, tc.Exp) {
+			t.Errorf("%v at %q: expected %q, got %q", tc.Err, tc.Stop, tc.Exp, act)
+		}
+	}
+}
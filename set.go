@@ -0,0 +1,147 @@
+package tf2vpk
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// SetReader reads the combined contents of multiple VPKs (e.g., all of the
+// VPKs in a game's vpk directory) as a single filesystem. If a file exists in
+// more than one VPK, the first one takes precedence.
+//
+// Like Reader, it is safe for concurrent use.
+type SetReader struct {
+	Ref    []ValvePakRef
+	Reader []*Reader
+
+	// Fallback is set for the VPKs opened by OpenSet which did not have a dir
+	// index for the requested language, so the default one was used instead.
+	Fallback []bool
+
+	file map[string]int // path to index in Reader
+}
+
+var _ fs.FS = (*SetReader)(nil)
+
+// OpenSet opens all VPKs in dir (see ScanValvePakSets) for the provided
+// language, in name order. If language is empty, or a VPK doesn't have a dir
+// index for the language, the default one (see ValvePakSet.Default) is used,
+// and the latter is recorded in SetReader.Fallback.
+func OpenSet(dir, language string) (*SetReader, error) {
+	sets, err := ScanValvePakSets(dir)
+	if err != nil {
+		return nil, fmt.Errorf("scan vpk sets: %w", err)
+	}
+	if len(sets) == 0 {
+		return nil, fmt.Errorf("scan vpk sets: no vpks found in %q", dir)
+	}
+	refs := make([]ValvePakRef, len(sets))
+	fallback := make([]bool, len(sets))
+	for i, s := range sets {
+		if language == "" {
+			refs[i] = s.Default()
+		} else if ref, err := s.Ref(language); err == nil {
+			refs[i] = ref
+		} else {
+			refs[i] = s.Default()
+			fallback[i] = true
+		}
+	}
+	r, err := NewSetReader(refs...)
+	if err != nil {
+		return nil, err
+	}
+	r.Fallback = fallback
+	return r, nil
+}
+
+// NewSetReader opens the provided VPKs, in order of precedence.
+func NewSetReader(refs ...ValvePakRef) (*SetReader, error) {
+	s := &SetReader{
+		Ref:      refs,
+		Fallback: make([]bool, len(refs)),
+		file:     map[string]int{},
+	}
+	for i, ref := range refs {
+		r, err := NewReader(ref)
+		if err != nil {
+			_ = s.Close()
+			return nil, fmt.Errorf("open vpk %q: %w", ref.Resolve(ValvePakIndexDir), err)
+		}
+		s.Reader = append(s.Reader, r)
+		for _, f := range r.Root.File {
+			if _, ok := s.file[f.Path]; !ok {
+				s.file[f.Path] = i
+			}
+		}
+	}
+	return s, nil
+}
+
+// Close closes all of the VPKs.
+func (s *SetReader) Close() error {
+	var errs []error
+	for i, r := range s.Reader {
+		if err := r.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close vpk %q: %w", s.Ref[i].Resolve(ValvePakIndexDir), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Which returns the VPK which the file at the provided path is read from.
+func (s *SetReader) Which(name string) (ValvePakRef, *Reader, bool) {
+	i, ok := s.file[strings.TrimPrefix(name, "./")]
+	if !ok {
+		return ValvePakRef{}, nil, false
+	}
+	return s.Ref[i], s.Reader[i], true
+}
+
+// Open implements fs.FS. Directories contain the entries from all VPKs, and a
+// file shadows a directory with the same name in any other VPK.
+func (s *SetReader) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	name = strings.TrimPrefix(name, "./")
+	if i, ok := s.file[name]; ok {
+		return s.Reader[i].Open(name)
+	}
+
+	seen := map[string]bool{}
+	var dirents []*readerInfo
+	for i, r := range s.Reader {
+		f, err := r.Open(name)
+		if err != nil {
+			continue
+		}
+		d, ok := f.(*readerDir)
+		if !ok {
+			continue // shadowed file with the same name as a directory
+		}
+		for _, e := range d.entry {
+			p := e.name
+			if name != "." {
+				p = name + "/" + p
+			}
+			if j, ok := s.file[p]; ok && j != i {
+				continue // files take precedence over directories, like in Open
+			}
+			if !seen[e.name] {
+				seen[e.name] = true
+				dirents = append(dirents, e)
+			}
+		}
+	}
+	if len(dirents) == 0 && name != "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(dirents, func(i, j int) bool {
+		return dirents[i].name < dirents[j].name
+	})
+	return &readerDir{readerInfo{name[strings.LastIndex(name, "/")+1:], nil}, dirents, 0}, nil
+}
@@ -0,0 +1,150 @@
+package tf2vpk
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestOpenSet(t *testing.T) {
+	dir := t.TempDir()
+	for _, x := range []struct {
+		Prefixes []string
+		Name     string
+		Files    map[string]string
+	}{
+		{[]string{"english", "french"}, "a", map[string]string{
+			"x.txt":       "a",
+			"dir/one.txt": "one",
+		}},
+		{[]string{"english"}, "b", map[string]string{
+			"x.txt":       "b",
+			"dir/two.txt": "two",
+			"y.txt/z.txt": "z",
+		}},
+		{[]string{""}, "pak01", map[string]string{
+			"dir/three.txt": "three",
+			"y.txt":         "y",
+		}},
+	} {
+		w := NewWriter(ValvePakRef{Path: dir, Prefix: x.Prefixes[0], Name: x.Name})
+		var names []string
+		for name := range x.Files {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			if err := w.Add(name, 1, 0, strings.NewReader(x.Files[name])); err != nil {
+				t.Fatalf("add %q: %v", name, err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("write vpk: %v", err)
+		}
+		for _, prefix := range x.Prefixes[1:] {
+			buf, err := os.ReadFile(filepath.Join(dir, JoinName(x.Prefixes[0], x.Name, ValvePakIndexDir)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, JoinName(prefix, x.Name, ValvePakIndexDir)), buf, 0666); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	s, err := OpenSet(dir, "french")
+	if err != nil {
+		t.Fatalf("open set: %v", err)
+	}
+	defer s.Close()
+
+	var refs []string
+	for _, ref := range s.Ref {
+		refs = append(refs, ref.Prefix+ref.Name)
+	}
+	if exp := []string{"frencha", "englishb", "pak01"}; !slices.Equal(refs, exp) {
+		t.Errorf("expected vpks %q, got %q", exp, refs)
+	}
+	if exp := []bool{false, true, true}; !slices.Equal(s.Fallback, exp) {
+		t.Errorf("expected fallback %v, got %v", exp, s.Fallback)
+	}
+
+	// the first vpk takes precedence
+	for name, exp := range map[string]string{
+		"x.txt":         "a",
+		"dir/one.txt":   "one",
+		"dir/two.txt":   "two",
+		"dir/three.txt": "three",
+		"y.txt/z.txt":   "z",
+		"y.txt":         "y",
+	} {
+		if buf, err := fs.ReadFile(s, name); err != nil {
+			t.Errorf("read %q: %v", name, err)
+		} else if string(buf) != exp {
+			t.Errorf("read %q: expected %q, got %q", name, exp, buf)
+		}
+	}
+	for name, exp := range map[string]string{
+		"x.txt":         "a",
+		"./x.txt":       "a",
+		"dir/two.txt":   "b",
+		"dir/three.txt": "pak01",
+		"y.txt":         "pak01",
+	} {
+		if ref, r, ok := s.Which(name); !ok {
+			t.Errorf("which %q: not found", name)
+		} else if ref.Name != exp || r == nil {
+			t.Errorf("which %q: expected vpk %q, got %q", name, exp, ref.Name)
+		}
+	}
+	if _, _, ok := s.Which("dir"); ok {
+		t.Errorf("which dir: expected not found")
+	}
+	if _, _, ok := s.Which("missing.txt"); ok {
+		t.Errorf("which missing file: expected not found")
+	}
+
+	// directories are merged
+	for name, exp := range map[string]string{
+		".":   "dir x.txt y.txt",
+		"dir": "one.txt three.txt two.txt",
+	} {
+		es, err := fs.ReadDir(s, name)
+		if err != nil {
+			t.Errorf("read dir %q: %v", name, err)
+			continue
+		}
+		var act []string
+		for _, e := range es {
+			act = append(act, e.Name())
+		}
+		if strings.Join(act, " ") != exp {
+			t.Errorf("read dir %q: expected %q, got %q", name, exp, act)
+		}
+	}
+	if _, err := s.Open("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("open missing: expected not exist error, got %v", err)
+	}
+	if err := fstest.TestFS(s, "x.txt", "dir/one.txt", "dir/two.txt", "dir/three.txt", "y.txt"); err != nil {
+		t.Errorf("test fs: %v", err)
+	}
+
+	// without a language, the default is used without falling back
+	s2, err := OpenSet(dir, "")
+	if err != nil {
+		t.Fatalf("open set: %v", err)
+	}
+	defer s2.Close()
+	if s2.Ref[0].Prefix != "english" || slices.Contains(s2.Fallback, true) {
+		t.Errorf("expected default languages without fallback, got %+v %v", s2.Ref, s2.Fallback)
+	}
+
+	if _, err := OpenSet(t.TempDir(), ""); err == nil {
+		t.Errorf("open empty set: expected error")
+	}
+}
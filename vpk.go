@@ -3,15 +3,16 @@ package tf2vpk
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
-
-	"github.com/pg9182/tf2lzham"
 )
 
 // Titanfall 2 VPK constants.
@@ -36,124 +37,230 @@ type ValvePakDir struct {
 
 // Deserialize parses a ValvePakDir from r.
 func (d *ValvePakDir) Deserialize(r io.Reader) error {
-	if err := binary.Read(r, binary.LittleEndian, &d.Magic); err != nil {
-		return fmt.Errorf("read dir magic: %w", err)
-	} else if d.Magic != ValvePakMagic {
-		return fmt.Errorf("read magic: expected %08X, got %08X", ValvePakMagic, d.Magic)
-	}
-	if err := binary.Read(r, binary.LittleEndian, &d.MajorVersion); err != nil {
-		return fmt.Errorf("read major version: %w", err)
-	} else if err := binary.Read(r, binary.LittleEndian, &d.MinorVersion); err != nil {
-		return fmt.Errorf("read minor version: %w", err)
-	} else if d.MajorVersion != ValvePakVersionMajor || d.MinorVersion != ValvePakVersionMinor {
-		return fmt.Errorf("unsupported dir version %d.%d (expected %d.%d)", d.MajorVersion, d.MinorVersion, ValvePakVersionMajor, ValvePakVersionMinor)
-	}
-	if err := binary.Read(r, binary.LittleEndian, &d.treeSize); err != nil {
-		return fmt.Errorf("read tree size: %w", err)
-	}
-	if err := binary.Read(r, binary.LittleEndian, &d.DataSize); err != nil {
-		return fmt.Errorf("read data size: %w", err)
-	} else if d.DataSize != 0 {
-		return fmt.Errorf("preload bytes are not implemented (and they shouldn't be in the TF2 VPKs anyways)")
+	d.File = nil
+	if err := d.DeserializeStream(r, func(f ValvePakFile) error {
+		d.File = append(d.File, f)
+		return nil
+	}); err != nil {
+		return err
+	}
+	// we can't round-trip trees which aren't laid out the way we write them
+	if x, err := d.TreeSize(); err != nil {
+		return fmt.Errorf("read directory tree: unsupported tree: %w", err)
+	} else if x != d.treeSize {
+		return fmt.Errorf("read directory tree: unsupported tree: serialized tree size would be %d, not %d", x, d.treeSize)
+	}
+	return nil
+}
+
+// DeserializeStream is like Deserialize, but calls fn for each file as it is
+// parsed instead of adding it to d.File, so the directory tree never needs to
+// be held in memory, and r does not need to be seekable (e.g., stdin or a
+// network stream). Nothing is read past the end of the tree, so for
+// single-file VPKs, the chunk data follows. If fn returns an error, parsing
+// stops and it is returned as-is.
+//
+// Unlike Deserialize, it does not check whether the tree can be serialized
+// byte-for-byte identically.
+func (d *ValvePakDir) DeserializeStream(r io.Reader, fn func(f ValvePakFile) error) error {
+	dec, err := d.NewDecoder(r)
+	if err != nil {
+		return err
 	}
-	// note: there isn't really any required order to the tree items as long as the ext/path/name is grouped together (the game builds a lookup table itself when reading the vpk)
-	b := bufio.NewReader(io.LimitReader(r, int64(d.treeSize)))
 	for {
-		xx, err := readNullString(b)
+		f, err := dec.Next()
+		if err == io.EOF {
+			return nil
+		}
 		if err != nil {
-			return fmt.Errorf("read directory tree extension: %w", err)
+			return err
 		}
-		if xx == "" {
-			break
+		if err := fn(f); err != nil {
+			return err
 		}
-		for {
-			xp, err := readNullString(b)
+	}
+}
+
+// ValvePakDirDecoder parses the files in a directory tree one at a time. It
+// only holds the current extension and path, so memory usage is independent
+// of the number of files.
+type ValvePakDirDecoder struct {
+	d    *ValvePakDir
+	lr   *io.LimitedReader
+	b    *bufio.Reader
+	ext  string // current extension, or empty if at the start of the next one
+	path string // current path, or empty if at the start of the next one
+	err  error  // sticky error, io.EOF once the tree has been fully read
+}
+
+// NewDecoder reads the header from r into d (leaving d.File as-is), and returns
+// a decoder for the files in the directory tree. Like DeserializeStream,
+// nothing is read past the end of the tree.
+func (d *ValvePakDir) NewDecoder(r io.Reader) (*ValvePakDirDecoder, error) {
+	h, err := ReadValvePakHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	d.Magic, d.MajorVersion, d.MinorVersion = h.Magic, h.MajorVersion, h.MinorVersion
+	if f := h.Format(); f != ValvePakFormatRespawn {
+		if f != ValvePakFormatUnknown {
+			return nil, fmt.Errorf("unsupported dir version %d.%d (%s, expected %d.%d)", d.MajorVersion, d.MinorVersion, f, ValvePakVersionMajor, ValvePakVersionMinor)
+		}
+		return nil, fmt.Errorf("unsupported dir version %d.%d (expected %d.%d)", d.MajorVersion, d.MinorVersion, ValvePakVersionMajor, ValvePakVersionMinor)
+	}
+	d.treeSize, d.DataSize = h.TreeSize, h.DataSize
+	if d.DataSize != 0 {
+		return nil, fmt.Errorf("non-empty dir data section (%d bytes) is not supported", d.DataSize)
+	}
+	// note: there isn't really any required order to the tree items as long as the ext/path/name is grouped together (the game builds a lookup table itself when reading the vpk)
+	if d.treeSize > math.MaxUint32-valvePakHeaderSize {
+		return nil, fmt.Errorf("read tree size: %d is too large", d.treeSize)
+	}
+	lr := &io.LimitedReader{R: r, N: int64(d.treeSize)}
+	return &ValvePakDirDecoder{
+		d:  d,
+		lr: lr,
+		b:  bufio.NewReader(lr),
+	}, nil
+}
+
+// Next parses the next file in the tree. At the end of the tree, it returns
+// io.EOF. Once an error is returned, all subsequent calls return it.
+func (dec *ValvePakDirDecoder) Next() (ValvePakFile, error) {
+	if dec.err == nil {
+		var f ValvePakFile
+		if f, dec.err = dec.next(); dec.err == nil {
+			return f, nil
+		}
+	}
+	return ValvePakFile{}, dec.err
+}
+
+func (dec *ValvePakDirDecoder) next() (ValvePakFile, error) {
+	for {
+		if dec.ext == "" {
+			xx, err := readNullString(dec.b)
 			if err != nil {
-				return fmt.Errorf("read directory tree path: %w", err)
-			}
-			if xp == "" {
-				break
+				return ValvePakFile{}, fmt.Errorf("read directory tree extension: %w", err)
 			}
-			for {
-				xn, err := readNullString(b)
-				if err != nil {
-					return fmt.Errorf("read directory tree name: %w", err)
-				}
-				if xn == "" {
-					break
-				}
-				var fn string
-				if xp == " " {
-					fn = xn + "." + xx
-				} else {
-					fn = xp + "/" + xn + "." + xx
+			if xx == "" {
+				if _, err := dec.b.Peek(1); err != io.EOF {
+					return ValvePakFile{}, fmt.Errorf("read directory tree: expected tree size %d, but tree ended before that", dec.d.treeSize)
 				}
-				var f ValvePakFile
-				if err := f.Deserialize(b, fn); err != nil {
-					return fmt.Errorf("read directory tree file data for %q: %w", f.Path, err)
+				if dec.lr.N != 0 {
+					return ValvePakFile{}, fmt.Errorf("read directory tree: expected tree size %d, but got EOF after %d bytes: %w", dec.d.treeSize, int64(dec.d.treeSize)-dec.lr.N, io.ErrUnexpectedEOF)
 				}
-				//fmt.Println(xx, xp, xn)
-				d.File = append(d.File, f)
+				return ValvePakFile{}, io.EOF
 			}
+			dec.ext = xx
 		}
+		if dec.path == "" {
+			xp, err := readNullString(dec.b)
+			if err != nil {
+				return ValvePakFile{}, fmt.Errorf("read directory tree path: %w", err)
+			}
+			if xp == "" {
+				dec.ext = ""
+				continue
+			}
+			dec.path = xp
+		}
+		xn, err := readNullString(dec.b)
+		if err != nil {
+			return ValvePakFile{}, fmt.Errorf("read directory tree name: %w", err)
+		}
+		if xn == "" {
+			dec.path = ""
+			continue
+		}
+		var name string
+		if dec.path == " " {
+			name = xn + "." + dec.ext
+		} else {
+			name = dec.path + "/" + xn + "." + dec.ext
+		}
+		var f ValvePakFile
+		if err := f.Deserialize(dec.b, name); err != nil {
+			return ValvePakFile{}, fmt.Errorf("read directory tree file data for %q: %w", f.Path, err)
+		}
+		return f, nil
 	}
-	if _, err := b.Peek(1); err != io.EOF {
-		return fmt.Errorf("read directory tree: expected tree size %d, but tree ended before that", d.treeSize)
-	}
-	if x, err := d.TreeSize(); err != nil {
-		panic(fmt.Errorf("serialized tree size mismatch: failed to serialize: %w (this is a bug in the serialization or a mismatch in the validation logic)", err))
-	} else if x != d.treeSize {
-		panic(fmt.Errorf("serialized tree size mismatch: expected %d, got %d (this is a bug in the serialization)", d.treeSize, x))
-	}
-	return nil
 }
 
+// maxNullString is the maximum length of a string in the directory tree, to
+// prevent huge allocations for malformed trees.
+const maxNullString = 4096
+
 func readNullString(r io.ByteReader) (string, error) {
 	var s []byte
 	for {
 		b, err := r.ReadByte()
 		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
 			return string(s), err
 		}
 		if b == 0 {
 			break
 		}
+		if len(s) == maxNullString {
+			return string(s), fmt.Errorf("string longer than %d bytes", maxNullString)
+		}
 		s = append(s, b)
 	}
 	return string(s), nil
 }
 
 // Serialize writes an encoded ValvePakDir to w. The output should be identical
-// byte-for-byte.
+// byte-for-byte. See SerializeTo.
 func (d ValvePakDir) Serialize(w io.Writer) error {
+	_, err := d.SerializeTo(w)
+	return err
+}
+
+// SerializeTo writes an encoded ValvePakDir to w, returning the number of bytes
+// written. The tree size is computed in a separate pass before the tree is
+// written, and the tree is emitted incrementally through a small buffer, so
+// memory usage doesn't depend on the number of files, and w doesn't need to be
+// seekable. If an error occurs, w may contain a partial dir index.
+func (d ValvePakDir) SerializeTo(w io.Writer) (int64, error) {
 	ts, err := d.TreeSize()
 	if err != nil {
-		return fmt.Errorf("calculate tree size: %w", err)
+		return 0, fmt.Errorf("calculate tree size: %w", err)
 	}
 	if d.Magic != ValvePakMagic {
-		return fmt.Errorf("write magic: expected %08X, got %08X", ValvePakMagic, d.Magic)
-	} else if err := binary.Write(w, binary.LittleEndian, &d.Magic); err != nil {
-		return fmt.Errorf("write dir magic: %w", err)
+		return 0, fmt.Errorf("write magic: expected %08X, got %08X", ValvePakMagic, d.Magic)
 	}
 	if d.MajorVersion != ValvePakVersionMajor || d.MinorVersion != ValvePakVersionMinor {
-		return fmt.Errorf("unsupported dir version %d.%d (expected %d.%d)", d.MajorVersion, d.MinorVersion, ValvePakVersionMajor, ValvePakVersionMinor)
-	} else if err := binary.Write(w, binary.LittleEndian, &d.MajorVersion); err != nil {
-		return fmt.Errorf("write major version: %w", err)
-	} else if err := binary.Write(w, binary.LittleEndian, &d.MinorVersion); err != nil {
-		return fmt.Errorf("write minor version: %w", err)
-	}
-	if err := binary.Write(w, binary.LittleEndian, ts); err != nil {
-		return fmt.Errorf("write tree size: %w", err)
+		return 0, fmt.Errorf("unsupported dir version %d.%d (expected %d.%d)", d.MajorVersion, d.MinorVersion, ValvePakVersionMajor, ValvePakVersionMinor)
 	}
 	if d.DataSize != 0 {
-		return fmt.Errorf("preload bytes are not implemented (and they shouldn't be in the TF2 VPKs anyways)")
-	} else if err := binary.Write(w, binary.LittleEndian, &d.DataSize); err != nil {
-		return fmt.Errorf("write data size: %w", err)
+		return 0, fmt.Errorf("non-empty dir data section (%d bytes) is not supported", d.DataSize)
 	}
-	if err := d.writeTree(w); err != nil {
-		return fmt.Errorf("write directory tree: %w", err)
+
+	cw := &countWriter{}
+	bw := bufio.NewWriterSize(io.MultiWriter(w, cw), 64*1024)
+
+	var buf [valvePakHeaderSize]byte
+	binary.LittleEndian.PutUint32(buf[0:], d.Magic)
+	binary.LittleEndian.PutUint16(buf[4:], d.MajorVersion)
+	binary.LittleEndian.PutUint16(buf[6:], d.MinorVersion)
+	binary.LittleEndian.PutUint32(buf[8:], ts)
+	binary.LittleEndian.PutUint32(buf[12:], d.DataSize)
+	if _, err := bw.Write(buf[:]); err != nil {
+		return cw.N, fmt.Errorf("write dir header: %w", err)
 	}
-	return nil
+	if err := d.writeTree(bw); err != nil {
+		return cw.N, fmt.Errorf("write directory tree: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return cw.N, fmt.Errorf("write directory tree: %w", err)
+	}
+	if n := int64(valvePakHeaderSize) + int64(ts); cw.N != n {
+		return cw.N, fmt.Errorf("write directory tree: wrote %d bytes, expected %d", cw.N, n)
+	}
+	return cw.N, nil
 }
 
 // SortFiles sorts the files in an order suitable for the tree.
@@ -195,9 +302,26 @@ func (d ValvePakDir) TreeSize() (uint32, error) {
 	if err := d.writeTree(&b); err != nil {
 		return 0, err
 	}
+	if b.N > math.MaxUint32-valvePakHeaderSize {
+		return 0, fmt.Errorf("directory tree is too large (%d bytes)", b.N)
+	}
 	return uint32(b.N), nil
 }
 
+// valvePakHeaderSize is the size of the header before the directory tree.
+const valvePakHeaderSize = 4 + 2 + 2 + 4 + 4
+
+// SingleFile returns true if there are files, and all of them are stored in
+// the dir index file itself (i.e., the VPK doesn't have any other blocks).
+func (d ValvePakDir) SingleFile() bool {
+	for _, f := range d.File {
+		if f.Index != ValvePakIndexDir {
+			return false
+		}
+	}
+	return len(d.File) != 0
+}
+
 // ChunkOffset returns the starting offset of chunk data stored after the dir
 // index (i.e., add this to the ValvePakChunk.Offset when reading a chunk for a
 // file with ValvePakFile.Index == ValvePakIndexDir).
@@ -215,6 +339,147 @@ func (d ValvePakDir) ChunkOffset() (n uint32, err error) {
 	return n, err
 }
 
+// ChunkRange returns the absolute offset and length of the raw chunk data within
+// the file for index i (i.e., including [ValvePakDir.ChunkOffset] if i is
+// [ValvePakIndexDir]).
+func (d ValvePakDir) ChunkRange(i ValvePakIndex, c ValvePakChunk) (offset, length int64, err error) {
+	offset, length = c.RawRange()
+	if i == ValvePakIndexDir {
+		n, err := d.ChunkOffset()
+		if err != nil {
+			return 0, 0, err
+		}
+		offset += int64(n)
+	}
+	return offset, length, nil
+}
+
+// TotalUncompressedSize returns the total uncompressed size of all files,
+// including preload data.
+func (d ValvePakDir) TotalUncompressedSize() uint64 {
+	var n uint64
+	for _, f := range d.File {
+		n += f.Size()
+	}
+	return n
+}
+
+// TotalCompressedSize returns the total size of the chunk data referenced by
+// the files. Chunks shared by multiple files are only counted once.
+func (d ValvePakDir) TotalCompressedSize() uint64 {
+	var n uint64
+	for _, x := range d.BlockSizes() {
+		n += x
+	}
+	return n
+}
+
+// BlockSizes returns the size of the chunk data referenced by the files in each
+// block. Chunks shared by multiple files (i.e., with the same block and offset)
+// are only counted once. Since blocks can contain unreferenced data (e.g., from
+// replaced files), this may be smaller than the actual size of the block.
+func (d ValvePakDir) BlockSizes() map[ValvePakIndex]uint64 {
+	type chunkKey struct {
+		Index  ValvePakIndex
+		Offset uint64
+	}
+	var (
+		n    = map[ValvePakIndex]uint64{}
+		seen = map[chunkKey]bool{}
+	)
+	for _, f := range d.File {
+		for _, c := range f.Chunk {
+			if k := (chunkKey{f.Index, c.Offset}); !seen[k] {
+				seen[k] = true
+				n[f.Index] += c.CompressedSize
+			}
+		}
+	}
+	return n
+}
+
+// ValvePakExtent describes where a contiguous range of a file's uncompressed
+// contents is stored.
+type ValvePakExtent struct {
+	Offset       uint64        // offset within the uncompressed file
+	Size         uint64        // uncompressed size
+	Chunk        int           // index in ValvePakFile.Chunk, or -1 for the preload data
+	Index        ValvePakIndex // block containing the raw data
+	RawOffset    int64         // absolute offset of the raw data in the block file, or -1 for the preload data (which is in the dir index tree)
+	RawSize      int64         // size of the raw (possibly compressed) data
+	LoadFlags    uint32
+	TextureFlags uint16
+}
+
+// IsCompressed checks if the extent is compressed.
+func (e ValvePakExtent) IsCompressed() bool {
+	return uint64(e.RawSize) != e.Size
+}
+
+// Extents returns the extents of f in order of their uncompressed offset. The
+// preload data, if any, comes first.
+func (d ValvePakDir) Extents(f ValvePakFile) ([]ValvePakExtent, error) {
+	es := f.extents()
+	if f.Index == ValvePakIndexDir && len(f.Chunk) != 0 {
+		n, err := d.ChunkOffset()
+		if err != nil {
+			return nil, fmt.Errorf("chunk 0: %w", err)
+		}
+		for i := range es {
+			if es[i].Chunk != -1 {
+				es[i].RawOffset += int64(n)
+			}
+		}
+	}
+	return es, nil
+}
+
+// extents is like [ValvePakDir.Extents], but the raw offsets of chunks stored
+// in the dir index are relative to the end of the tree.
+func (f *ValvePakFile) extents() []ValvePakExtent {
+	es := make([]ValvePakExtent, 0, len(f.Chunk)+1)
+	var off uint64
+	if len(f.Preload) != 0 {
+		es = append(es, ValvePakExtent{
+			Offset:    0,
+			Size:      uint64(len(f.Preload)),
+			Chunk:     -1,
+			Index:     ValvePakIndexDir,
+			RawOffset: -1,
+			RawSize:   int64(len(f.Preload)),
+		})
+		off += uint64(len(f.Preload))
+	}
+	for i, c := range f.Chunk {
+		ro, rn := c.RawRange()
+		es = append(es, ValvePakExtent{
+			Offset:       off,
+			Size:         c.UncompressedSize,
+			Chunk:        i,
+			Index:        f.Index,
+			RawOffset:    ro,
+			RawSize:      rn,
+			LoadFlags:    c.LoadFlags,
+			TextureFlags: c.TextureFlags,
+		})
+		off += c.UncompressedSize
+	}
+	return es
+}
+
+// FindExtent returns the index of the extent in es (as returned by
+// [ValvePakDir.Extents]) containing the uncompressed offset off, or -1 if off
+// is past the end.
+func FindExtent(es []ValvePakExtent, off uint64) int {
+	i := sort.Search(len(es), func(i int) bool {
+		return es[i].Offset+es[i].Size > off
+	})
+	if i == len(es) {
+		return -1
+	}
+	return i
+}
+
 type countWriter struct {
 	N int64
 }
@@ -312,6 +577,39 @@ const (
 	ValvePakIndexEOF ValvePakIndex = 0xFFFF // not actually one
 )
 
+// ValvePakMaxBlockIndex is the largest index of a block file.
+const ValvePakMaxBlockIndex = ValvePakIndexDir - 1
+
+// IsValid checks if i refers to a block file or the dir index.
+func (i ValvePakIndex) IsValid() bool {
+	return i <= ValvePakIndexDir
+}
+
+// ParseValvePakIndex parses a block index as formatted by String, which is the
+// index padded to at least three digits or "dir". Any amount of zero-padding
+// is accepted.
+func ParseValvePakIndex(s string) (ValvePakIndex, error) {
+	if s == "dir" {
+		return ValvePakIndexDir, nil
+	}
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return ValvePakIndexEOF, fmt.Errorf("invalid block index %q: not a number", s)
+	}
+	var n uint64
+	if d := strings.TrimLeft(s, "0"); d != "" {
+		var err error
+		if n, err = strconv.ParseUint(d, 10, 16); err != nil {
+			n = math.MaxUint16
+		}
+	}
+	if n > uint64(ValvePakMaxBlockIndex) {
+		return ValvePakIndexEOF, fmt.Errorf("invalid block index %q: must be at most %d", s, ValvePakMaxBlockIndex)
+	}
+	return ValvePakIndex(n), nil
+}
+
+// String formats i as used in block file names (i.e., zero-padded to three
+// digits, or "dir").
 func (i ValvePakIndex) String() string {
 	switch i {
 	case ValvePakIndexDir:
@@ -335,12 +633,37 @@ func (i ValvePakIndex) GoString() string {
 }
 
 // ValvePakFile is a file in a Titanfall 2 VPK.
+//
+// If PreloadBytes is non-zero, the first PreloadBytes bytes of the file are
+// stored in Preload (in the dir index, after the chunk entries) instead of in
+// the chunks, and the contents of the file are Preload followed by the chunks.
+// The CRC32 covers the entire contents.
 type ValvePakFile struct {
 	Path         string
 	CRC32        uint32
 	PreloadBytes uint16
 	Index        ValvePakIndex
 	Chunk        []ValvePakChunk
+	Preload      []byte
+}
+
+// Size returns the uncompressed size of the file, including the preload data.
+func (f *ValvePakFile) Size() uint64 {
+	n := uint64(len(f.Preload))
+	for _, c := range f.Chunk {
+		n += c.UncompressedSize
+	}
+	return n
+}
+
+// CompressedSize returns the total size of the chunk data for the file (which
+// does not include the preload data stored in the dir index).
+func (f *ValvePakFile) CompressedSize() uint64 {
+	var n uint64
+	for _, c := range f.Chunk {
+		n += c.CompressedSize
+	}
+	return n
 }
 
 // LoadFlags gets the load flags for the file.
@@ -369,28 +692,212 @@ func (f *ValvePakFile) TextureFlags() (uint16, error) {
 	return f.Chunk[0].TextureFlags, nil
 }
 
+// Flags gets the typed load and texture flags for the file.
+func (f *ValvePakFile) Flags() (ValvePakLoadFlags, ValvePakTextureFlags, error) {
+	load, err := f.LoadFlags()
+	if err != nil {
+		return 0, 0, err
+	}
+	texture, err := f.TextureFlags()
+	if err != nil {
+		return 0, 0, err
+	}
+	return ValvePakLoadFlags(load), ValvePakTextureFlags(texture), nil
+}
+
 // CreateReader creates a new reader for the file, checking the CRC32 at EOF.
 func (f *ValvePakFile) CreateReader(r io.ReaderAt) (io.Reader, error) {
-	return f.CreateReaderParallel(r, 1)
+	return f.createReader(r, 1, nil)
 }
 
 // CreateReaderParallel is like CreateReader, but decompresses chunks in
-// parallel using n-1 goroutines going no more than n compressed chunks ahead
-// (i.e., 1 is not parallel).
+// parallel going no more than n-1 compressed chunks ahead (i.e., 1 is not
+// parallel). The decompression is done by a pool of workers shared between all
+// readers (see SetDecompressWorkers). The memory used for reading ahead is not
+// limited (see [Reader.DecompressMemory]).
 func (f *ValvePakFile) CreateReaderParallel(r io.ReaderAt, n int) (io.Reader, error) {
-	rs := make([]io.Reader, len(f.Chunk))
+	return f.createReader(r, n, nil)
+}
+
+// createReader is like CreateReaderParallel, but reserves the memory used for
+// reading ahead from mem.
+func (f *ValvePakFile) createReader(r io.ReaderAt, n int, mem *DecompressMemory) (io.Reader, error) {
+	rs := make([]io.Reader, 0, len(f.Chunk)+1)
 	var sz uint64
-	var err error
+	if len(f.Preload) != 0 {
+		rs = append(rs, bytes.NewReader(f.Preload))
+		sz += uint64(len(f.Preload))
+	}
+	cr := r
 	for i, c := range f.Chunk {
-		rs[i], err = c.CreateReader(r)
+		cr = coalesceChunks(cr, f.Chunk, i, mem)
+		x, err := c.createReader(cr, mem)
 		if err != nil {
 			return nil, fmt.Errorf("chunk %d: %w", i, err)
 		}
+		rs = append(rs, x)
 		sz += c.UncompressedSize
 	}
 	return newCRCReader(newMultiChunkReader(n-1, rs...), sz, f.CRC32), nil
 }
 
+// CreateReaderAt creates a new reader for up to n bytes of the file starting at
+// off, only reading and decompressing the chunks covering the range. Since the
+// file isn't read fully, the CRC32 is not checked. If the range extends past
+// the end of the file, it is truncated.
+func (f *ValvePakFile) CreateReaderAt(r io.ReaderAt, off, n int64) (io.Reader, error) {
+	return f.createReaderAt(r, off, n, nil)
+}
+
+// createReaderAt is like CreateReaderAt, but reserves the memory used for
+// reading ahead from mem.
+func (f *ValvePakFile) createReaderAt(r io.ReaderAt, off, n int64, mem *DecompressMemory) (io.Reader, error) {
+	if off < 0 || n < 0 {
+		return nil, fmt.Errorf("invalid range %d+%d", off, n)
+	}
+	if size := int64(f.Size()); off > size {
+		return nil, fmt.Errorf("offset %d is past the end of the file (size %d)", off, size)
+	} else {
+		n = min(n, size-off)
+	}
+	var (
+		rs  []io.Reader
+		pos int64 // start of the current part of the file
+		end = off + n
+	)
+	if p := int64(len(f.Preload)); p != 0 {
+		if off < p && end > 0 {
+			rs = append(rs, bytes.NewReader(f.Preload[off:min(end, p)]))
+		}
+		pos = p
+	}
+
+	// don't coalesce reads past the last chunk in the range
+	cs := f.Chunk
+	if es := f.extents(); n != 0 {
+		if x := FindExtent(es, uint64(end-1)); x != -1 {
+			cs = cs[:es[x].Chunk+1] // empty if it's in the preload
+		}
+	}
+	cr := r
+	for i, c := range cs {
+		csz := int64(c.UncompressedSize)
+		if pos >= end {
+			break
+		}
+		if pos+csz > off {
+			cr = coalesceChunks(cr, cs, i, mem)
+			x, err := c.createReader(cr, mem)
+			if err != nil {
+				return nil, fmt.Errorf("chunk %d: %w", i, err)
+			}
+			skip := max(off-pos, 0)
+			rs = append(rs, io.LimitReader(&skipReader{r: x, n: skip}, min(end, pos+csz)-pos-skip))
+		}
+		pos += csz
+	}
+	return io.MultiReader(rs...), nil
+}
+
+// skipReader discards the first n bytes of r when it is first read.
+type skipReader struct {
+	r io.Reader
+	n int64
+}
+
+func (s *skipReader) Read(p []byte) (int, error) {
+	if s.n != 0 {
+		if _, err := io.CopyN(io.Discard, s.r, s.n); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		s.n = 0
+	}
+	return s.r.Read(p)
+}
+
+// coalesceLimit is the maximum size of a run of adjacent chunks to read at once.
+const coalesceLimit = 4 * ValvePakMaxChunkUncompressedSize
+
+// coalesceChunks returns a reader for chunk i of cs. If the chunk is the first
+// in a run of adjacent chunks, a reader which reads the entire run in a single
+// operation is returned, and it is also used for the rest of the run. The
+// buffer is reserved from mem.
+func coalesceChunks(r io.ReaderAt, cs []ValvePakChunk, i int, mem *DecompressMemory) io.ReaderAt {
+	if c, ok := r.(*coalescedReaderAt); ok {
+		if cs[i].Offset >= c.off && cs[i].Offset+cs[i].CompressedSize <= c.off+c.size {
+			return c // already part of the current run
+		}
+		r = c.r
+	}
+	end := cs[i].Offset + cs[i].CompressedSize
+	j := i + 1
+	for ; j < len(cs) && cs[j].Offset == end && end+cs[j].CompressedSize-cs[i].Offset <= coalesceLimit; j++ {
+		end += cs[j].CompressedSize
+	}
+	if j-i < 2 {
+		return r
+	}
+	return &coalescedReaderAt{r: r, off: cs[i].Offset, size: end - cs[i].Offset, lim: mem}
+}
+
+// coalescedReaderAt reads a range of r into memory the first time any part of
+// it is read, serving reads within the range from memory until all of it has
+// been read once.
+//
+// The buffer counts towards the decompression memory limit (see
+// DecompressMemory). If there isn't enough memory, reads go directly to the
+// underlying reader.
+type coalescedReaderAt struct {
+	r    io.ReaderAt
+	off  uint64
+	size uint64
+	lim  *DecompressMemory
+
+	m    sync.Mutex
+	buf  []byte
+	read uint64
+	mem  int64
+}
+
+func (c *coalescedReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || uint64(off) < c.off || uint64(off)+uint64(len(p)) > c.off+c.size {
+		return c.r.ReadAt(p, off)
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.buf == nil {
+		if c.read != 0 || !c.lim.reserve(int64(c.size)) {
+			return c.r.ReadAt(p, off)
+		}
+		c.mem = int64(c.size)
+		runtime.SetFinalizer(c, (*coalescedReaderAt).release)
+		buf := make([]byte, c.size)
+		if n, err := c.r.ReadAt(buf, int64(c.off)); n != len(buf) {
+			c.release()
+			return 0, err
+		}
+		c.buf = buf
+	}
+	n := copy(p, c.buf[uint64(off)-c.off:])
+	if c.read += uint64(n); c.read >= c.size {
+		c.buf = nil
+		c.release()
+	}
+	return n, nil
+}
+
+// release releases the reserved memory, if any.
+func (c *coalescedReaderAt) release() {
+	if c.mem != 0 {
+		c.lim.release(c.mem)
+		c.mem = 0
+		runtime.SetFinalizer(c, nil)
+	}
+}
+
 type multiChunkReader struct {
 	readers  []io.Reader
 	parallel int
@@ -405,22 +912,7 @@ func (mr *multiChunkReader) Read(p []byte) (n int, err error) {
 		n, err = mr.readers[0].Read(p)
 		if err == io.EOF {
 			mr.readers[0], mr.readers = nil, mr.readers[1:]
-
-			if ahead := mr.parallel; ahead > 0 {
-				for _, r := range mr.readers {
-					if r, ok := r.(interface {
-						// EnsureDecompressed synchronously decompresses the
-						// block if needed. It must be safe to be called in
-						// concurrently with Read.
-						EnsureDecompressed() error
-					}); ok {
-						go r.EnsureDecompressed()
-						if ahead--; ahead == 0 {
-							break
-						}
-					}
-				}
-			}
+			mr.prefetch()
 		}
 		if n > 0 || err != io.EOF {
 			if err == io.EOF && len(mr.readers) > 0 {
@@ -432,19 +924,56 @@ func (mr *multiChunkReader) Read(p []byte) (n int, err error) {
 	return 0, io.EOF
 }
 
+// prefetch starts decompressing the next chunks in the background.
+func (mr *multiChunkReader) prefetch() {
+	if ahead := mr.parallel; ahead > 0 {
+		for _, r := range mr.readers {
+			if r, ok := r.(interface {
+				// Prefetch starts decompressing the chunk in the
+				// background if needed, returning false if it
+				// couldn't be started. It must be safe to be called
+				// concurrently with Read.
+				Prefetch() bool
+			}); ok {
+				if !r.Prefetch() {
+					break // all workers are busy, or the memory limit was reached
+				}
+				if ahead--; ahead == 0 {
+					break
+				}
+			}
+		}
+	}
+}
+
+// WriteTo writes the remaining chunks to w, writing decompressed chunks
+// directly from their buffer, and copying stored chunks using a buffer large
+// enough for an entire chunk.
 func (mr *multiChunkReader) WriteTo(w io.Writer) (sum int64, err error) {
-	buf := make([]byte, 1024*32)
-	for i, r := range mr.readers {
+	var buf []byte
+	defer func() {
+		if buf != nil {
+			putChunkBuf(buf)
+		}
+	}()
+	mr.prefetch()
+	for len(mr.readers) > 0 {
 		var n int64
-		n, err = io.CopyBuffer(w, r, buf)
+		if wt, ok := mr.readers[0].(io.WriterTo); ok {
+			n, err = wt.WriteTo(w)
+		} else {
+			if buf == nil {
+				buf = getChunkBuf(int64(ValvePakMaxChunkUncompressedSize))
+			}
+			n, err = io.CopyBuffer(w, mr.readers[0], buf)
+		}
 		sum += n
 		if err != nil {
-			mr.readers = mr.readers[i:]
 			return sum, err
 		}
-		mr.readers[i] = nil
+		mr.readers[0], mr.readers = nil, mr.readers[1:]
+		mr.prefetch()
 	}
-	mr.readers = nil
 	return sum, nil
 }
 
@@ -456,12 +985,13 @@ func (f *ValvePakFile) Deserialize(r io.Reader, path string) error {
 	}
 	if err := binary.Read(r, binary.LittleEndian, &f.PreloadBytes); err != nil {
 		return fmt.Errorf("read file preload bytes: %w", err)
-	} else if f.PreloadBytes != 0 {
-		return fmt.Errorf("non-zero preload bytes are not implemented (and they shouldn't be in the TF2 VPKs anyways)")
 	}
 	if err := binary.Read(r, binary.LittleEndian, &f.Index); err != nil {
 		return fmt.Errorf("read file archive index: %w", err)
 	}
+	if !f.Index.IsValid() {
+		return fmt.Errorf("read file archive index: invalid block index %d", uint16(f.Index))
+	}
 	for {
 		var e ValvePakChunk
 		if err := e.Deserialize(r); err != nil {
@@ -479,6 +1009,12 @@ func (f *ValvePakFile) Deserialize(r io.Reader, path string) error {
 		if e.UncompressedSize > ValvePakMaxChunkUncompressedSize {
 			return fmt.Errorf("read file chunk: uncompressed size %d larger than %d", e.UncompressedSize, ValvePakMaxChunkUncompressedSize) // I'm not 100% sure about this limit
 		}
+		if e.CompressedSize > 2*ValvePakMaxChunkUncompressedSize {
+			return fmt.Errorf("read file chunk: compressed size %d too large", e.CompressedSize) // even incompressible data shouldn't expand this much
+		}
+		if e.Offset > math.MaxInt64-e.CompressedSize {
+			return fmt.Errorf("read file chunk: offset %d out of range", e.Offset)
+		}
 
 		var n ValvePakIndex
 		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
@@ -490,21 +1026,34 @@ func (f *ValvePakFile) Deserialize(r io.Reader, path string) error {
 			return fmt.Errorf("non-eof chunk terminator must equal the block index") // assumption based on observation
 		}
 	}
+	if f.PreloadBytes != 0 {
+		f.Preload = make([]byte, f.PreloadBytes)
+		if _, err := io.ReadFull(r, f.Preload); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("read file preload data: %w", err)
+		}
+	} else {
+		f.Preload = nil
+	}
 	return nil
 }
 
 // Serialize writes an encoded ValvePakFile to w.
 func (f ValvePakFile) Serialize(w io.Writer) error {
-	if err := binary.Write(w, binary.LittleEndian, &f.CRC32); err != nil {
-		return fmt.Errorf("write file crc32: %w", err)
+	if int(f.PreloadBytes) != len(f.Preload) {
+		return fmt.Errorf("write file preload bytes: expected %d bytes of preload data, got %d", f.PreloadBytes, len(f.Preload))
 	}
-	if f.PreloadBytes != 0 {
-		return fmt.Errorf("non-zero preload bytes are not implemented (and they shouldn't be in the TF2 VPKs anyways)")
-	} else if err := binary.Write(w, binary.LittleEndian, &f.PreloadBytes); err != nil {
-		return fmt.Errorf("write file preload bytes: %w", err)
+	if !f.Index.IsValid() {
+		return fmt.Errorf("write file archive index: invalid block index %d", uint16(f.Index))
 	}
-	if err := binary.Write(w, binary.LittleEndian, &f.Index); err != nil {
-		return fmt.Errorf("write file archive index: %w", err)
+	var buf [4 + 2 + 2]byte
+	binary.LittleEndian.PutUint32(buf[0:], f.CRC32)
+	binary.LittleEndian.PutUint16(buf[4:], f.PreloadBytes)
+	binary.LittleEndian.PutUint16(buf[6:], uint16(f.Index))
+	if _, err := w.Write(buf[:]); err != nil {
+		return fmt.Errorf("write file entry: %w", err)
 	}
 	for i, e := range f.Chunk {
 		// assumptions based on observation
@@ -522,7 +1071,8 @@ func (f ValvePakFile) Serialize(w io.Writer) error {
 		}
 
 		if i != 0 {
-			if err := binary.Write(w, binary.LittleEndian, f.Index); err != nil {
+			binary.LittleEndian.PutUint16(buf[:2], uint16(f.Index))
+			if _, err := w.Write(buf[:2]); err != nil {
 				return fmt.Errorf("write file chunk terminator: %w", err)
 			}
 		}
@@ -530,9 +1080,15 @@ func (f ValvePakFile) Serialize(w io.Writer) error {
 			return fmt.Errorf("write file chunk: %w", err)
 		}
 	}
-	if err := binary.Write(w, binary.LittleEndian, uint16(65535)); err != nil {
+	binary.LittleEndian.PutUint16(buf[:2], uint16(ValvePakIndexEOF))
+	if _, err := w.Write(buf[:2]); err != nil {
 		return fmt.Errorf("write file eof chunk terminator: %w", err)
 	}
+	if len(f.Preload) != 0 {
+		if _, err := w.Write(f.Preload); err != nil {
+			return fmt.Errorf("write file preload data: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -580,6 +1136,72 @@ func DescribeTextureFlags(flags uint16) (s []string) {
 	return
 }
 
+// ValvePakLoadFlags are the load flags of a chunk. The meanings of the known
+// flags are guesses based on where they are used.
+type ValvePakLoadFlags uint32
+
+// Known load flags.
+const (
+	ValvePakLoadVisible     ValvePakLoadFlags = 1 << 0
+	ValvePakLoadCache       ValvePakLoadFlags = 1 << 8
+	ValvePakLoadACacheUnk0  ValvePakLoadFlags = 1 << 10
+	ValvePakLoadTextureUnk0 ValvePakLoadFlags = 1 << 18
+	ValvePakLoadTextureUnk1 ValvePakLoadFlags = 1 << 19
+	ValvePakLoadTextureUnk2 ValvePakLoadFlags = 1 << 20
+
+	ValvePakLoadTexture = ValvePakLoadTextureUnk0 | ValvePakLoadTextureUnk1 | ValvePakLoadTextureUnk2
+)
+
+// Has checks if all flags in x are set.
+func (f ValvePakLoadFlags) Has(x ValvePakLoadFlags) bool {
+	return f&x == x
+}
+
+// IsVisible checks if the file is visible to the game filesystem.
+func (f ValvePakLoadFlags) IsVisible() bool {
+	return f.Has(ValvePakLoadVisible)
+}
+
+// IsCached checks if the file is cached in memory.
+func (f ValvePakLoadFlags) IsCached() bool {
+	return f.Has(ValvePakLoadCache)
+}
+
+// IsTexture checks if any of the texture flags are set.
+func (f ValvePakLoadFlags) IsTexture() bool {
+	return f&ValvePakLoadTexture != 0
+}
+
+// String describes the flags (see DescribeLoadFlags).
+func (f ValvePakLoadFlags) String() string {
+	return strings.Join(DescribeLoadFlags(uint32(f)), " ")
+}
+
+// ValvePakTextureFlags are the texture flags of a chunk. They are only set on
+// VTF files.
+type ValvePakTextureFlags uint16
+
+// Known texture flags.
+const (
+	ValvePakTextureDefault        ValvePakTextureFlags = 1 << 3
+	ValvePakTextureEnvironmentMap ValvePakTextureFlags = 1 << 10
+)
+
+// Has checks if all flags in x are set.
+func (f ValvePakTextureFlags) Has(x ValvePakTextureFlags) bool {
+	return f&x == x
+}
+
+// IsEnvironmentMap checks if the texture is an environment map.
+func (f ValvePakTextureFlags) IsEnvironmentMap() bool {
+	return f.Has(ValvePakTextureEnvironmentMap)
+}
+
+// String describes the flags (see DescribeTextureFlags).
+func (f ValvePakTextureFlags) String() string {
+	return strings.Join(DescribeTextureFlags(uint16(f)), " ")
+}
+
 // ValvePakChunk is a file chunk (possibly shared) in a Titanfall 2 VPK.
 type ValvePakChunk struct {
 	LoadFlags        uint32 // note: these flags seem to be the same for all chunks in a ValvePakFile
@@ -589,15 +1211,61 @@ type ValvePakChunk struct {
 	UncompressedSize uint64
 }
 
+// Load returns the typed load flags of the chunk.
+func (c ValvePakChunk) Load() ValvePakLoadFlags {
+	return ValvePakLoadFlags(c.LoadFlags)
+}
+
+// Texture returns the typed texture flags of the chunk.
+func (c ValvePakChunk) Texture() ValvePakTextureFlags {
+	return ValvePakTextureFlags(c.TextureFlags)
+}
+
 // IsCompressed checks if a chunk is compressed.
 func (c ValvePakChunk) IsCompressed() bool {
 	return c.CompressedSize != c.UncompressedSize
 }
 
-// CreateReader creates a new reader for the chunk.
+// RawRange returns the offset and length of the raw (possibly compressed) chunk
+// data within the data read from its block. For files stored in the dir index,
+// the offset is relative to the end of the index (see [ValvePakDir.ChunkRange]
+// for the absolute offset).
+func (c ValvePakChunk) RawRange() (offset, length int64) {
+	return int64(c.Offset), int64(c.CompressedSize)
+}
+
+// ReadAll reads and decompresses the entire chunk from r (the block data,
+// as returned by [Reader.OpenBlockRaw]).
+func (c ValvePakChunk) ReadAll(r io.ReaderAt) ([]byte, error) {
+	if c.UncompressedSize > ValvePakMaxChunkUncompressedSize {
+		return nil, fmt.Errorf("chunk uncompressed size %d larger than %d", c.UncompressedSize, ValvePakMaxChunkUncompressedSize)
+	}
+	cr, err := c.CreateReader(r)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, c.UncompressedSize)
+	if _, err := io.ReadFull(cr, b); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("chunk is truncated: %w", err)
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// CreateReader creates a new reader for the chunk, decompressing it if
+// necessary. The chunk is independent of the other chunks in the file, so it
+// can be read on its own.
 func (c ValvePakChunk) CreateReader(r io.ReaderAt) (io.Reader, error) {
+	return c.createReader(r, nil)
+}
+
+// createReader is like CreateReader, but reserves the memory used for
+// decompressing ahead of time from mem.
+func (c ValvePakChunk) createReader(r io.ReaderAt, mem *DecompressMemory) (io.Reader, error) {
 	if c.IsCompressed() {
-		return newLZHAMLazyReader(r, int64(c.Offset), int64(c.CompressedSize), int64(c.UncompressedSize)), nil
+		return newLZHAMLazyReader(r, int64(c.Offset), int64(c.CompressedSize), int64(c.UncompressedSize), mem), nil
 	} else {
 		return io.NewSectionReader(r, int64(c.Offset), int64(c.CompressedSize)), nil
 	}
@@ -608,15 +1276,17 @@ type lzhamLazyReader struct {
 	off int64
 	csz int64
 	dsz int64
+	lim *DecompressMemory
 
-	m sync.Mutex
-	b []byte
-	e error
-	n uint64
+	m   sync.Mutex
+	b   []byte
+	e   error
+	n   uint64
+	mem int64 // reserved for prefetching
 }
 
-func newLZHAMLazyReader(r io.ReaderAt, off, csz, dsz int64) io.Reader {
-	return &lzhamLazyReader{r: r, off: off, csz: csz, dsz: dsz}
+func newLZHAMLazyReader(r io.ReaderAt, off, csz, dsz int64, lim *DecompressMemory) io.Reader {
+	return &lzhamLazyReader{r: r, off: off, csz: csz, dsz: dsz, lim: lim}
 }
 
 func (r *lzhamLazyReader) Read(b []byte) (n int, err error) {
@@ -630,8 +1300,10 @@ func (r *lzhamLazyReader) Read(b []byte) (n int, err error) {
 		return 0, r.e
 	}
 	if r.n >= uint64(r.dsz) {
+		putChunkBuf(r.b)
 		r.b = nil
 		r.e = io.EOF
+		r.release()
 		return 0, r.e
 	}
 	n = copy(b, r.b[r.n:])
@@ -639,6 +1311,31 @@ func (r *lzhamLazyReader) Read(b []byte) (n int, err error) {
 	return
 }
 
+func (r *lzhamLazyReader) WriteTo(w io.Writer) (int64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.e != nil {
+		if r.e == io.EOF {
+			return 0, nil
+		}
+		return 0, r.e
+	}
+	if r.decompress(); r.e != nil {
+		return 0, r.e
+	}
+	n, err := w.Write(r.b[r.n:])
+	r.n += uint64(n)
+	if err != nil {
+		return int64(n), err
+	}
+	putChunkBuf(r.b)
+	r.b = nil
+	r.e = io.EOF
+	r.release()
+	return int64(n), nil
+}
+
 func (r *lzhamLazyReader) EnsureDecompressed() error {
 	r.m.Lock()
 	defer r.m.Unlock()
@@ -646,6 +1343,40 @@ func (r *lzhamLazyReader) EnsureDecompressed() error {
 	return r.decompress()
 }
 
+func (r *lzhamLazyReader) Prefetch() bool {
+	r.m.Lock()
+	if r.e != nil || r.b != nil || r.mem != 0 {
+		r.m.Unlock()
+		return true // already done or in progress
+	}
+	if !r.lim.reserve(r.csz + r.dsz) {
+		r.m.Unlock()
+		return false
+	}
+	r.mem = r.csz + r.dsz
+	runtime.SetFinalizer(r, (*lzhamLazyReader).release)
+	r.m.Unlock()
+
+	if !submitDecompress(func() { r.EnsureDecompressed() }) {
+		r.m.Lock()
+		if r.b == nil {
+			r.release()
+		}
+		r.m.Unlock()
+		return false
+	}
+	return true
+}
+
+// release releases the memory reserved for prefetching, if any.
+func (r *lzhamLazyReader) release() {
+	if r.mem != 0 {
+		r.lim.release(r.mem)
+		r.mem = 0
+		runtime.SetFinalizer(r, nil)
+	}
+}
+
 func (r *lzhamLazyReader) decompress() error {
 	if r.e != nil {
 		return r.e
@@ -653,23 +1384,59 @@ func (r *lzhamLazyReader) decompress() error {
 	if r.b != nil {
 		return nil
 	}
-	src := make([]byte, int(r.csz))
+	src := getChunkBuf(r.csz)
+	defer putChunkBuf(src)
 	if _, err := r.r.ReadAt(src, r.off); err != nil {
 		r.e = fmt.Errorf("read chunk: %w", err)
+		r.release()
 		return r.e
 	}
-	dst := make([]byte, int(r.dsz))
-	if n, _, _, err := tf2lzham.Decompress(dst, src); err != nil {
+	dst := getChunkBuf(r.dsz)
+	if n, err := CurrentCodec().Decompress(dst, src); err != nil {
+		putChunkBuf(dst)
 		r.e = fmt.Errorf("decompress chunk: %w", err)
+		r.release()
 		return r.e
 	} else if n != len(dst) {
-		r.e = fmt.Errorf("decompress chunk: %w", err)
+		putChunkBuf(dst)
+		r.e = fmt.Errorf("decompress chunk: got %d bytes, expected %d", n, len(dst))
+		r.release()
 		return r.e
 	}
 	r.b = dst
+	if r.mem != 0 {
+		// only the decompressed data is kept
+		r.lim.release(r.csz)
+		r.mem -= r.csz
+	}
 	return nil
 }
 
+// chunkBufPool contains buffers large enough for any valid chunk, to reduce
+// allocations when decompressing many chunks.
+var chunkBufPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, ValvePakMaxChunkUncompressedSize)
+		return &b
+	},
+}
+
+// getChunkBuf gets a buffer of length n, which should be returned with
+// putChunkBuf once it is no longer referenced.
+func getChunkBuf(n int64) []byte {
+	if uint64(n) > ValvePakMaxChunkUncompressedSize {
+		return make([]byte, int(n))
+	}
+	return (*chunkBufPool.Get().(*[]byte))[:n]
+}
+
+func putChunkBuf(b []byte) {
+	if uint64(cap(b)) == ValvePakMaxChunkUncompressedSize {
+		b = b[:cap(b)]
+		chunkBufPool.Put(&b)
+	}
+}
+
 // CreateReader creates a new reader for the raw data of the chunk.
 func (c ValvePakChunk) CreateReaderRaw(r io.ReaderAt) (io.Reader, error) {
 	return io.NewSectionReader(r, int64(c.Offset), int64(c.CompressedSize)), nil
@@ -701,24 +1468,26 @@ func (c *ValvePakChunk) Deserialize(r io.Reader) error {
 
 // Serialize writes an encoded ValvePakChunk to w.
 func (c ValvePakChunk) Serialize(w io.Writer) error {
-	if err := binary.Write(w, binary.LittleEndian, &c.LoadFlags); err != nil {
-		return fmt.Errorf("write chunk entry flags: %w", err)
-	}
-	if err := binary.Write(w, binary.LittleEndian, c.TextureFlags); err != nil {
-		return fmt.Errorf("write chunk texture flags: %w", err)
-	}
-	if err := binary.Write(w, binary.LittleEndian, c.Offset); err != nil {
-		return fmt.Errorf("write chunk archive offset: %w", err)
+	if c.Offset > math.MaxInt64-c.CompressedSize {
+		return fmt.Errorf("write chunk archive offset: %d out of range", c.Offset)
 	}
 	if c.CompressedSize == 0 {
 		return fmt.Errorf("write chunk compressed size: must be non-zero")
-	} else if err := binary.Write(w, binary.LittleEndian, c.CompressedSize); err != nil {
-		return fmt.Errorf("write chunk compressed size: %w", err)
 	}
 	if c.UncompressedSize == 0 {
 		return fmt.Errorf("write chunk uncompressed size: must be non-zero")
-	} else if err := binary.Write(w, binary.LittleEndian, c.UncompressedSize); err != nil {
-		return fmt.Errorf("write chunk uncompressed size: %w", err)
+	}
+	var buf [valvePakChunkSize]byte
+	binary.LittleEndian.PutUint32(buf[0:], c.LoadFlags)
+	binary.LittleEndian.PutUint16(buf[4:], c.TextureFlags)
+	binary.LittleEndian.PutUint64(buf[6:], c.Offset)
+	binary.LittleEndian.PutUint64(buf[14:], c.CompressedSize)
+	binary.LittleEndian.PutUint64(buf[22:], c.UncompressedSize)
+	if _, err := w.Write(buf[:]); err != nil {
+		return fmt.Errorf("write chunk entry: %w", err)
 	}
 	return nil
 }
+
+// valvePakChunkSize is the size of a serialized ValvePakChunk.
+const valvePakChunkSize = 4 + 2 + 8 + 8 + 8
@@ -0,0 +1,426 @@
+package tf2vpk
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+)
+
+func FuzzValvePakDirDeserialize(f *testing.F) {
+	for _, d := range []ValvePakDir{
+		{
+			Magic:        ValvePakMagic,
+			MajorVersion: ValvePakVersionMajor,
+			MinorVersion: ValvePakVersionMinor,
+		},
+		{
+			Magic:        ValvePakMagic,
+			MajorVersion: ValvePakVersionMajor,
+			MinorVersion: ValvePakVersionMinor,
+			File: []ValvePakFile{
+				{Path: "a.txt", CRC32: 1, PreloadBytes: 2, Index: 0, Chunk: []ValvePakChunk{{LoadFlags: 1, Offset: 0, CompressedSize: 3, UncompressedSize: 3}}, Preload: []byte("hi")},
+				{Path: "b/c.nut", CRC32: 2, Index: 0, Chunk: []ValvePakChunk{{LoadFlags: 1, Offset: 3, CompressedSize: 10, UncompressedSize: 20}, {LoadFlags: 1, Offset: 13, CompressedSize: 5, UncompressedSize: 5}}},
+				{Path: "b/d/e.vtf", CRC32: 3, Index: ValvePakIndexDir, Chunk: []ValvePakChunk{{LoadFlags: 1 << 18, TextureFlags: 8, Offset: 0, CompressedSize: 1, UncompressedSize: 1}}},
+			},
+		},
+	} {
+		var b bytes.Buffer
+		if err := d.Serialize(&b); err != nil {
+			f.Fatalf("serialize seed: %v", err)
+		}
+		f.Add(b.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var d ValvePakDir
+		if err := d.Deserialize(bytes.NewReader(data)); err != nil {
+			return
+		}
+		var b bytes.Buffer
+		if err := d.Serialize(&b); err != nil {
+			t.Fatalf("failed to serialize deserialized dir: %v", err)
+		}
+		var d1 ValvePakDir
+		if err := d1.Deserialize(&b); err != nil {
+			t.Fatalf("failed to deserialize serialized dir: %v", err)
+		}
+		if len(d1.File) != len(d.File) {
+			t.Fatalf("file count mismatch after round trip: %d != %d", len(d1.File), len(d.File))
+		}
+	})
+}
+
+func TestValvePakDirDecoder(t *testing.T) {
+	d := ValvePakDir{
+		Magic:        ValvePakMagic,
+		MajorVersion: ValvePakVersionMajor,
+		MinorVersion: ValvePakVersionMinor,
+		File: []ValvePakFile{
+			{Path: "a.txt", CRC32: 1, Index: 0, Chunk: []ValvePakChunk{{LoadFlags: 1, Offset: 0, CompressedSize: 3, UncompressedSize: 3}}},
+			{Path: "b/c.nut", CRC32: 2, Index: 0, Chunk: []ValvePakChunk{{LoadFlags: 1, Offset: 3, CompressedSize: 10, UncompressedSize: 20}}},
+			{Path: "b/d.nut", CRC32: 3, Index: 0, Chunk: []ValvePakChunk{{LoadFlags: 1, Offset: 13, CompressedSize: 5, UncompressedSize: 5}}},
+			{Path: "b/d/e.vtf", CRC32: 4, Index: ValvePakIndexDir, Chunk: []ValvePakChunk{{LoadFlags: 1 << 18, TextureFlags: 8, Offset: 0, CompressedSize: 1, UncompressedSize: 1}}},
+		},
+	}
+	var b bytes.Buffer
+	if err := d.Serialize(&b); err != nil {
+		t.Fatalf("serialize: %v", err)
+	}
+	var exp ValvePakDir
+	if err := exp.Deserialize(bytes.NewReader(b.Bytes())); err != nil {
+		t.Fatalf("deserialize: %v", err)
+	}
+
+	var d1 ValvePakDir
+	dec, err := d1.NewDecoder(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatalf("new decoder: %v", err)
+	}
+	for i := 0; ; i++ {
+		f, err := dec.Next()
+		if err == io.EOF {
+			if i != len(exp.File) {
+				t.Errorf("expected %d files, got %d", len(exp.File), i)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("next: %v", err)
+		}
+		if i >= len(exp.File) || f.Path != exp.File[i].Path || f.CRC32 != exp.File[i].CRC32 {
+			t.Errorf("file %d: unexpected %q", i, f.Path)
+		}
+	}
+	if _, err := dec.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF after the end, got %v", err)
+	}
+
+	dec, err = d1.NewDecoder(bytes.NewReader(b.Bytes()[:b.Len()-4]))
+	if err != nil {
+		t.Fatalf("new decoder: %v", err)
+	}
+	for {
+		if _, err = dec.Next(); err != nil {
+			break
+		}
+	}
+	if err == io.EOF {
+		t.Errorf("expected error for truncated tree")
+	}
+}
+
+func TestValvePakDirDeserializeStream(t *testing.T) {
+	d := ValvePakDir{
+		Magic:        ValvePakMagic,
+		MajorVersion: ValvePakVersionMajor,
+		MinorVersion: ValvePakVersionMinor,
+		File: []ValvePakFile{
+			{Path: "a.txt", CRC32: 1, PreloadBytes: 2, Index: 0, Chunk: []ValvePakChunk{{LoadFlags: 1, Offset: 0, CompressedSize: 3, UncompressedSize: 3}}, Preload: []byte("hi")},
+			{Path: "b/c.nut", CRC32: 2, Index: 0, Chunk: []ValvePakChunk{{LoadFlags: 1, Offset: 3, CompressedSize: 10, UncompressedSize: 20}, {LoadFlags: 1, Offset: 13, CompressedSize: 5, UncompressedSize: 5}}},
+			{Path: "b/d/e.vtf", CRC32: 3, Index: ValvePakIndexDir, Chunk: []ValvePakChunk{{LoadFlags: 1 << 18, TextureFlags: 8, Offset: 0, CompressedSize: 1, UncompressedSize: 1}}},
+		},
+	}
+	var b bytes.Buffer
+	if err := d.Serialize(&b); err != nil {
+		t.Fatalf("serialize: %v", err)
+	}
+	var exp ValvePakDir
+	if err := exp.Deserialize(bytes.NewReader(b.Bytes())); err != nil {
+		t.Fatalf("deserialize: %v", err)
+	}
+	b.WriteString("chunk data")
+
+	// all files, and nothing past the tree
+	var (
+		d1 ValvePakDir
+		fs []ValvePakFile
+		r  = bytes.NewReader(b.Bytes())
+	)
+	if err := d1.DeserializeStream(r, func(f ValvePakFile) error {
+		fs = append(fs, f)
+		return nil
+	}); err != nil {
+		t.Fatalf("deserialize stream: %v", err)
+	}
+	if d1.Magic != exp.Magic || d1.MajorVersion != exp.MajorVersion || d1.MinorVersion != exp.MinorVersion {
+		t.Errorf("incorrect header")
+	}
+	if !slices.EqualFunc(fs, exp.File, func(a, b ValvePakFile) bool {
+		return a.Path == b.Path && a.CRC32 == b.CRC32 && a.Index == b.Index && bytes.Equal(a.Preload, b.Preload) && slices.Equal(a.Chunk, b.Chunk)
+	}) {
+		t.Errorf("incorrect files: %+v", fs)
+	}
+	if rest, _ := io.ReadAll(r); string(rest) != "chunk data" {
+		t.Errorf("read past the end of the tree")
+	}
+
+	// errors from fn stop parsing
+	errStop := errors.New("stop")
+	var n int
+	if err := d1.DeserializeStream(bytes.NewReader(b.Bytes()), func(f ValvePakFile) error {
+		n++
+		return errStop
+	}); err != errStop || n != 1 {
+		t.Errorf("expected parsing to stop after the first file, got %d files and %v", n, err)
+	}
+
+	// truncated trees fail
+	if err := d1.DeserializeStream(bytes.NewReader(b.Bytes()[:20]), func(f ValvePakFile) error {
+		return nil
+	}); err == nil {
+		t.Errorf("expected error for truncated tree")
+	}
+}
+
+func TestValvePakDirSizes(t *testing.T) {
+	d := ValvePakDir{
+		File: []ValvePakFile{
+			{Path: "a.txt", Index: 0, Chunk: []ValvePakChunk{{Offset: 0, CompressedSize: 3, UncompressedSize: 3}}, Preload: []byte("hi")},
+			{Path: "b.txt", Index: 0, Chunk: []ValvePakChunk{{Offset: 3, CompressedSize: 10, UncompressedSize: 20}, {Offset: 13, CompressedSize: 5, UncompressedSize: 5}}},
+			{Path: "c.txt", Index: 0, Chunk: []ValvePakChunk{{Offset: 3, CompressedSize: 10, UncompressedSize: 20}}}, // shared with b.txt
+			{Path: "d.txt", Index: 1, Chunk: []ValvePakChunk{{Offset: 3, CompressedSize: 7, UncompressedSize: 8}}},   // same offset, different block
+		},
+	}
+	if n := d.TotalUncompressedSize(); n != 2+3+20+5+20+8 {
+		t.Errorf("incorrect uncompressed size %d", n)
+	}
+	if n := d.TotalCompressedSize(); n != 3+10+5+7 {
+		t.Errorf("incorrect compressed size %d", n)
+	}
+	if bs := d.BlockSizes(); len(bs) != 2 || bs[0] != 18 || bs[1] != 7 {
+		t.Errorf("incorrect block sizes %v", bs)
+	}
+	if n := d.File[1].CompressedSize(); n != 15 {
+		t.Errorf("incorrect file compressed size %d", n)
+	}
+}
+
+func TestValvePakDirExtents(t *testing.T) {
+	d := ValvePakDir{}
+	f := ValvePakFile{
+		Path:    "a.txt",
+		Index:   1,
+		Preload: []byte("hi"),
+		Chunk: []ValvePakChunk{
+			{LoadFlags: 1, Offset: 100, CompressedSize: 10, UncompressedSize: 20},
+			{LoadFlags: 1, Offset: 110, CompressedSize: 5, UncompressedSize: 5},
+		},
+	}
+	es, err := d.Extents(f)
+	if err != nil {
+		t.Fatalf("extents: %v", err)
+	}
+	exp := []ValvePakExtent{
+		{Offset: 0, Size: 2, Chunk: -1, Index: ValvePakIndexDir, RawOffset: -1, RawSize: 2},
+		{Offset: 2, Size: 20, Chunk: 0, Index: 1, RawOffset: 100, RawSize: 10, LoadFlags: 1},
+		{Offset: 22, Size: 5, Chunk: 1, Index: 1, RawOffset: 110, RawSize: 5, LoadFlags: 1},
+	}
+	if !slices.Equal(es, exp) {
+		t.Fatalf("incorrect extents %+v", es)
+	}
+	if es[0].IsCompressed() || !es[1].IsCompressed() || es[2].IsCompressed() {
+		t.Errorf("incorrect compression")
+	}
+	for off, i := range map[uint64]int{0: 0, 1: 0, 2: 1, 21: 1, 22: 2, 26: 2, 27: -1} {
+		if x := FindExtent(es, off); x != i {
+			t.Errorf("offset %d: got extent %d, expected %d", off, x, i)
+		}
+	}
+}
+
+func TestValvePakIndex(t *testing.T) {
+	for _, tc := range []struct {
+		s   string
+		i   ValvePakIndex
+		err bool
+	}{
+		{"dir", ValvePakIndexDir, false},
+		{"000", 0, false},
+		{"0", 0, false},
+		{"1", 1, false},
+		{"0001", 1, false},
+		{"999", 999, false},
+		{"1000", 1000, false},
+		{"32766", ValvePakMaxBlockIndex, false},
+		{"32767", 0, true},
+		{"65535", 0, true},
+		{"99999999999999999999", 0, true},
+		{"", 0, true},
+		{"-1", 0, true},
+		{"1a", 0, true},
+	} {
+		i, err := ParseValvePakIndex(tc.s)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: expected error, got %s", tc.s, i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.s, err)
+		} else if i != tc.i {
+			t.Errorf("%q: expected %s, got %s", tc.s, tc.i, i)
+		}
+	}
+	for _, i := range []ValvePakIndex{0, 7, 999, 1000, ValvePakMaxBlockIndex, ValvePakIndexDir} {
+		fn := JoinName("english", "test", i)
+		if name, x, err := SplitName(fn, "english"); err != nil || name != "test" || x != i {
+			t.Errorf("%s: round trip failed: got %q %s %v", fn, name, x, err)
+		}
+	}
+	if _, _, err := SplitName("test_32767.vpk", ""); err == nil {
+		t.Errorf("expected error for the dir index as a number")
+	}
+}
+
+func FuzzChunkReader(f *testing.F) {
+	data := bytes.Repeat([]byte("tf2vpk"), 100)
+	f.Add(data, uint64(len(data)))
+	comp := make([]byte, len(data))
+	if n, err := CurrentCodec().Compress(comp, data); err == nil {
+		comp = comp[:n]
+		f.Add(comp, uint64(len(data)))
+		f.Add(comp, uint64(len(data)+1))
+		f.Add(comp, uint64(1))
+	}
+	f.Fuzz(func(t *testing.T, data []byte, dsz uint64) {
+		if len(data) == 0 || dsz == 0 || dsz > ValvePakMaxChunkUncompressedSize {
+			return // rejected by ValvePakChunk.Deserialize
+		}
+		c := ValvePakChunk{Offset: 0, CompressedSize: uint64(len(data)), UncompressedSize: dsz}
+		r, err := c.CreateReader(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		n, err := io.Copy(io.Discard, r)
+		if err == nil && uint64(n) != dsz {
+			t.Fatalf("read %d bytes without error, expected %d", n, dsz)
+		}
+	})
+}
+
+func TestValvePakHeader(t *testing.T) {
+	d := ValvePakDir{
+		Magic:        ValvePakMagic,
+		MajorVersion: ValvePakVersionMajor,
+		MinorVersion: ValvePakVersionMinor,
+		File: []ValvePakFile{
+			{Path: "a.txt", CRC32: 1, Index: 0, Chunk: []ValvePakChunk{{LoadFlags: 1, Offset: 0, CompressedSize: 3, UncompressedSize: 3}}},
+		},
+	}
+	exp, err := d.Header()
+	if err != nil {
+		t.Fatalf("header: %v", err)
+	}
+	var b bytes.Buffer
+	if err := d.Serialize(&b); err != nil {
+		t.Fatalf("serialize: %v", err)
+	}
+	if n := uint32(b.Len() - valvePakHeaderSize); exp.TreeSize != n {
+		t.Errorf("incorrect computed tree size %d, expected %d", exp.TreeSize, n)
+	}
+	h, err := ReadValvePakHeader(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatalf("read header: %v", err)
+	}
+	if h != exp || h.Format() != ValvePakFormatRespawn || h.Size() != valvePakHeaderSize {
+		t.Errorf("incorrect header %+v (%s)", h, h.Format())
+	}
+	var d1 ValvePakDir
+	if err := d1.Deserialize(bytes.NewReader(b.Bytes())); err != nil {
+		t.Fatalf("deserialize: %v", err)
+	}
+	if h, _ := d1.Header(); h != exp {
+		t.Errorf("incorrect deserialized header %+v", h)
+	}
+
+	for _, tc := range []struct {
+		b []byte
+		h ValvePakHeader
+		f ValvePakFormat
+	}{
+		{[]byte{0x34, 0x12, 0xAA, 0x55, 1, 0, 0, 0, 10, 0, 0, 0}, ValvePakHeader{Magic: ValvePakMagic, MajorVersion: 1, TreeSize: 10}, ValvePakFormatValve1},
+		{[]byte{0x34, 0x12, 0xAA, 0x55, 2, 0, 0, 0, 10, 0, 0, 0, 20, 0, 0, 0, 30, 0, 0, 0, 48, 0, 0, 0, 40, 1, 0, 0}, ValvePakHeader{Magic: ValvePakMagic, MajorVersion: 2, TreeSize: 10, DataSize: 20, ArchiveMD5Size: 30, OtherMD5Size: 48, SignatureSize: 296}, ValvePakFormatValve2},
+		{[]byte{0x34, 0x12, 0xAA, 0x55, 9, 0, 9, 0}, ValvePakHeader{Magic: ValvePakMagic, MajorVersion: 9, MinorVersion: 9}, ValvePakFormatUnknown},
+	} {
+		r := bytes.NewReader(append(tc.b, 0xFF))
+		h, err := ReadValvePakHeader(r)
+		if err != nil {
+			t.Errorf("read %s header: %v", tc.f, err)
+			continue
+		}
+		if h != tc.h || h.Format() != tc.f || h.Size() != len(tc.b) || r.Len() != 1 {
+			t.Errorf("incorrect %s header %+v (%s, %d bytes, %d unread)", tc.f, h, h.Format(), h.Size(), r.Len())
+		}
+		if err := new(ValvePakDir).Deserialize(bytes.NewReader(tc.b)); err == nil {
+			t.Errorf("expected error deserializing %s dir", tc.f)
+		}
+	}
+	if _, err := ReadValvePakHeader(bytes.NewReader([]byte{0x34, 0x12, 0xAA, 0x55, 1, 0, 0, 0, 10})); err == nil {
+		t.Errorf("expected error for truncated header")
+	}
+}
+
+// readEndReaderAt records the end of the furthest read.
+type readEndReaderAt struct {
+	r   io.ReaderAt
+	end int64
+}
+
+func (r *readEndReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.end = max(r.end, off+int64(len(p)))
+	return r.r.ReadAt(p, off)
+}
+
+func TestCreateReaderAtCoalesce(t *testing.T) {
+	var data []byte
+	for i := 0; len(data) < 16384; i++ {
+		data = fmt.Appendf(data, "line %d\n", i)
+	}
+	data = data[:16384]
+
+	m := memBlocks{}
+	w := NewWriterFunc(m.create)
+	w.ChunkSize = 4096
+	w.Compression = StoreCompression
+	w.Preload = func(string) int {
+		return 100
+	}
+	if err := w.Add("a.txt", 1, 0, bytes.NewReader(data)); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+	f := w.Root.File[0]
+	b := bytes.NewReader(m[0].Bytes())
+
+	for _, x := range []struct {
+		Off, N, End int64 // end of the last chunk in the range
+	}{
+		{0, 10, 0},
+		{0, 101, 4096},
+		{100, 4096, 4096},
+		{100, 4097, 8192},
+		{5000, 10, 8192},
+		{0, int64(len(data)), int64(len(data)) - 100},
+	} {
+		rr := &readEndReaderAt{r: b}
+		fr, err := f.CreateReaderAt(rr, x.Off, x.N)
+		if err != nil {
+			t.Fatalf("range %d+%d: %v", x.Off, x.N, err)
+		}
+		buf, err := io.ReadAll(fr)
+		if err != nil {
+			t.Fatalf("range %d+%d: read: %v", x.Off, x.N, err)
+		}
+		if !bytes.Equal(buf, data[x.Off:x.Off+x.N]) {
+			t.Errorf("range %d+%d: incorrect data", x.Off, x.N)
+		}
+		if rr.end > x.End {
+			t.Errorf("range %d+%d: expected no reads past %d, got %d", x.Off, x.N, x.End, rr.end)
+		}
+	}
+}
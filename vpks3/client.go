@@ -0,0 +1,294 @@
+// Package vpks3 reads and writes VPKs stored in S3-compatible object storage,
+// without needing local copies of the blocks.
+package vpks3
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultPartSize is the default size of ranged reads and multipart uploads.
+const DefaultPartSize = 8 << 20
+
+// Client makes requests to an S3-compatible bucket.
+type Client struct {
+	// Endpoint is the base URL of the service. If empty, the AWS endpoint for
+	// the region is used.
+	Endpoint string
+
+	// Region is the region used for signing requests. If empty, us-east-1 is
+	// used.
+	Region string
+
+	// Bucket is the bucket name.
+	Bucket string
+
+	// PathStyle puts the bucket name in the path instead of the hostname, as
+	// required by most S3-compatible services other than AWS.
+	PathStyle bool
+
+	// AccessKeyID and SecretAccessKey are the credentials. If they are empty,
+	// requests are not signed (i.e., for public buckets).
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// PartSize is the size of the parts read by ReaderAt and uploaded by
+	// Writer. If zero, DefaultPartSize is used. It must be at least 5 MiB for
+	// uploads to AWS.
+	PartSize int64
+
+	// Retries is the number of times a failed request is retried (on network
+	// errors, throttling, and server errors) with exponential backoff.
+	Retries int
+
+	// HTTPClient, if not nil, is used instead of http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// NewClientFromEnv creates a client for bucket using the standard AWS
+// environment variables (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+// AWS_SESSION_TOKEN, AWS_REGION or AWS_DEFAULT_REGION, and AWS_ENDPOINT_URL_S3
+// or AWS_ENDPOINT_URL). If a custom endpoint is set, path-style requests are
+// used.
+func NewClientFromEnv(bucket string) *Client {
+	c := &Client{
+		Bucket:          bucket,
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		Region:          os.Getenv("AWS_REGION"),
+		Endpoint:        os.Getenv("AWS_ENDPOINT_URL_S3"),
+		Retries:         3,
+	}
+	if c.Region == "" {
+		c.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if c.Endpoint == "" {
+		c.Endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	c.PathStyle = c.Endpoint != ""
+	return c
+}
+
+// ParseURL parses an s3://bucket/prefix URL, returning the bucket and prefix.
+func ParseURL(s string) (bucket, prefix string, err error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return "", "", fmt.Errorf("invalid s3 url %q: expected s3://bucket/prefix", s)
+	}
+	return u.Host, strings.Trim(u.Path, "/"), nil
+}
+
+func (c *Client) partSize() int64 {
+	if c.PartSize > 0 {
+		return c.PartSize
+	}
+	return DefaultPartSize
+}
+
+func (c *Client) region() string {
+	if c.Region != "" {
+		return c.Region
+	}
+	return "us-east-1"
+}
+
+// objectURL returns the URL for key with the provided query.
+func (c *Client) objectURL(key string, query url.Values) (*url.URL, error) {
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + c.region() + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parse endpoint: %w", err)
+	}
+	if c.PathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + c.Bucket + "/" + key
+	} else {
+		u.Host = c.Bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	}
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = canonicalQuery(query)
+	return u, nil
+}
+
+// StatusError is returned for unsuccessful responses.
+type StatusError struct {
+	Method string
+	Key    string
+	Status int
+	Body   string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("s3 %s %q: status %d: %s", e.Method, e.Key, e.Status, e.Body)
+}
+
+// do makes a signed request, retrying it if necessary. The response body must
+// be closed if err is nil. The response status is checked against ok.
+func (c *Client) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte, ok ...int) (*http.Response, error) {
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	u, err := c.objectURL(key, query)
+	if err != nil {
+		return nil, err
+	}
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = int64(len(body))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		c.sign(req, body, time.Now())
+
+		resp, err := hc.Do(req)
+		if err == nil {
+			for _, s := range ok {
+				if resp.StatusCode == s {
+					return resp, nil
+				}
+			}
+			b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+			err = &StatusError{method, key, resp.StatusCode, strings.TrimSpace(string(b))}
+		}
+		if attempt >= c.Retries || !retryable(err) {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Millisecond * 100 << attempt):
+		}
+	}
+}
+
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Status == http.StatusTooManyRequests || se.Status >= 500
+	}
+	return true
+}
+
+// sign signs req using AWS Signature Version 4.
+func (c *Client) sign(req *http.Request, body []byte, t time.Time) {
+	sum := sha256.Sum256(body)
+	payload := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	if c.AccessKeyID == "" {
+		return
+	}
+
+	amzDate := t.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if c.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+	}
+
+	names := []string{"host"}
+	for k := range req.Header {
+		if k := strings.ToLower(k); strings.HasPrefix(k, "x-amz-") || k == "range" || k == "content-type" {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	var ch strings.Builder
+	for _, k := range names {
+		v := req.URL.Host
+		if k != "host" {
+			v = strings.TrimSpace(req.Header.Get(k))
+		}
+		ch.WriteString(k + ":" + v + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	creq := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		ch.String(),
+		signed,
+		payload,
+	}, "\n")
+	creqSum := sha256.Sum256([]byte(creq))
+
+	scope := date + "/" + c.region() + "/s3/aws4_request"
+	sts := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(creqSum[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretAccessKey), date)
+	key = hmacSHA256(key, c.region())
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.AccessKeyID+"/"+scope+", SignedHeaders="+signed+", Signature="+hex.EncodeToString(hmacSHA256(key, sts)))
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// escape percent-encodes s as required for signing.
+func escape(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~', slash && c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func escapePath(p string) string {
+	return escape(p, true)
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), q[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, escape(k, false)+"="+escape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,263 @@
+package vpks3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+// ReaderAt reads an object using ranged requests. Reads are aligned to
+// PartSize, and the most recently read parts are kept in memory, so small
+// sequential reads (e.g., of the dir index) don't each make a request. It is
+// safe for concurrent use.
+type ReaderAt struct {
+	c    *Client
+	ctx  context.Context
+	key  string
+	size int64
+
+	mu    sync.Mutex
+	parts []cachedPart // most recently used last
+}
+
+type cachedPart struct {
+	n    int64
+	data []byte
+}
+
+// maxCachedParts is the number of parts kept in memory by ReaderAt.
+const maxCachedParts = 4
+
+// OpenReaderAt gets the size of the object at key, returning a ReaderAt for it.
+// The context is used for all requests made by the ReaderAt.
+func (c *Client) OpenReaderAt(ctx context.Context, key string) (*ReaderAt, error) {
+	resp, err := c.do(ctx, http.MethodHead, key, nil, nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.ContentLength < 0 {
+		return nil, fmt.Errorf("s3 HEAD %q: unknown size", key)
+	}
+	return &ReaderAt{c: c, ctx: ctx, key: key, size: resp.ContentLength}, nil
+}
+
+// Size returns the size of the object.
+func (r *ReaderAt) Size() int64 {
+	return r.size
+}
+
+// ReadAt implements io.ReaderAt.
+func (r *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+	ps := r.c.partSize()
+	var n int
+	for n < len(p) && off < r.size {
+		part, err := r.part(off / ps)
+		if err != nil {
+			return n, err
+		}
+		x := copy(p[n:], part[off%ps:])
+		n += x
+		off += int64(x)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// part gets the n-th part of the object.
+func (r *ReaderAt) part(n int64) ([]byte, error) {
+	r.mu.Lock()
+	for i, x := range r.parts {
+		if x.n == n {
+			r.parts = append(append(r.parts[:i:i], r.parts[i+1:]...), x)
+			r.mu.Unlock()
+			return x.data, nil
+		}
+	}
+	r.mu.Unlock()
+
+	ps := r.c.partSize()
+	start, end := n*ps, min((n+1)*ps, r.size)
+	resp, err := r.c.do(r.ctx, http.MethodGet, r.key, nil, http.Header{
+		"Range": {"bytes=" + strconv.FormatInt(start, 10) + "-" + strconv.FormatInt(end-1, 10)},
+	}, nil, http.StatusPartialContent, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		// range not supported
+		if _, err := io.CopyN(io.Discard, resp.Body, start); err != nil {
+			return nil, fmt.Errorf("s3 GET %q: %w", r.key, err)
+		}
+	}
+	data := make([]byte, end-start)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, fmt.Errorf("s3 GET %q: %w", r.key, err)
+	}
+
+	r.mu.Lock()
+	r.parts = append(r.parts, cachedPart{n, data})
+	if len(r.parts) > maxCachedParts {
+		r.parts = r.parts[1:]
+	}
+	r.mu.Unlock()
+	return data, nil
+}
+
+// Writer uploads an object. Objects smaller than PartSize are uploaded with a
+// single request, and larger ones with a multipart upload. The object is only
+// created once the Writer is closed successfully.
+type Writer struct {
+	c        *Client
+	ctx      context.Context
+	key      string
+	buf      bytes.Buffer
+	uploadID string
+	parts    []completedPart
+	err      error
+	closed   bool
+}
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+// Create creates a Writer uploading to key.
+func (c *Client) Create(ctx context.Context, key string) *Writer {
+	return &Writer{c: c, ctx: ctx, key: key}
+}
+
+// Write implements io.Writer.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, fmt.Errorf("s3 upload %q: writer is closed", w.key)
+	}
+	w.buf.Write(p)
+	for int64(w.buf.Len()) >= w.c.partSize() {
+		if err := w.flushPart(w.buf.Next(int(w.c.partSize()))); err != nil {
+			w.err = err
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// flushPart uploads a part, starting a multipart upload if necessary.
+func (w *Writer) flushPart(data []byte) error {
+	if w.uploadID == "" {
+		resp, err := w.c.do(w.ctx, http.MethodPost, w.key, url.Values{"uploads": {""}}, nil, nil, http.StatusOK)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		var x struct {
+			UploadID string `xml:"UploadId"`
+		}
+		if err := xml.NewDecoder(resp.Body).Decode(&x); err != nil || x.UploadID == "" {
+			return fmt.Errorf("s3 create multipart upload %q: invalid response (%v)", w.key, err)
+		}
+		w.uploadID = x.UploadID
+	}
+	n := len(w.parts) + 1
+	resp, err := w.c.do(w.ctx, http.MethodPut, w.key, url.Values{
+		"partNumber": {strconv.Itoa(n)},
+		"uploadId":   {w.uploadID},
+	}, nil, data, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	w.parts = append(w.parts, completedPart{n, resp.Header.Get("ETag")})
+	return nil
+}
+
+// Close finishes the upload.
+func (w *Writer) Close() error {
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	if w.err != nil {
+		w.Abort()
+		return w.err
+	}
+	if w.uploadID == "" {
+		resp, err := w.c.do(w.ctx, http.MethodPut, w.key, nil, nil, w.buf.Bytes(), http.StatusOK)
+		if err != nil {
+			w.err = err
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+	if w.buf.Len() != 0 {
+		if err := w.flushPart(w.buf.Bytes()); err != nil {
+			w.err = err
+			w.Abort()
+			return err
+		}
+	}
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Part    []completedPart `xml:"Part"`
+	}{Part: w.parts})
+	if err != nil {
+		panic(err)
+	}
+	resp, err := w.c.do(w.ctx, http.MethodPost, w.key, url.Values{"uploadId": {w.uploadID}}, http.Header{
+		"Content-Type": {"application/xml"},
+	}, body, http.StatusOK)
+	if err != nil {
+		w.err = err
+		w.Abort()
+		return err
+	}
+	defer resp.Body.Close()
+
+	// errors can be returned with a 200 status
+	var x struct {
+		XMLName xml.Name
+		Message string
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&x); err == nil && x.XMLName.Local == "Error" {
+		w.err = fmt.Errorf("s3 complete multipart upload %q: %s", w.key, x.Message)
+		w.Abort()
+		return w.err
+	}
+	return nil
+}
+
+// Commit does nothing, since the object is created by Close. It allows Writer
+// to be used as a pending block by tf2vpk.Writer, which calls Abort if writing
+// the VPK fails.
+func (w *Writer) Commit() error {
+	return nil
+}
+
+// Abort cancels the upload if it hasn't been completed yet.
+func (w *Writer) Abort() {
+	w.closed = true
+	if w.uploadID != "" {
+		if resp, err := w.c.do(context.Background(), http.MethodDelete, w.key, url.Values{"uploadId": {w.uploadID}}, nil, nil, http.StatusNoContent, http.StatusOK); err == nil {
+			resp.Body.Close()
+		}
+		w.uploadID = ""
+	}
+}
@@ -0,0 +1,34 @@
+package vpks3
+
+import (
+	"context"
+	"io"
+	"path"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// Key returns the key for a VPK file under prefix.
+func Key(prefix string, vpk tf2vpk.ValvePakRef, i tf2vpk.ValvePakIndex) string {
+	return path.Join(prefix, tf2vpk.JoinName(vpk.Prefix, vpk.Name, i))
+}
+
+// NewReader creates a new tf2vpk.Reader for a VPK stored under prefix. The
+// Path of vpk is ignored. The context is used for all requests made by the
+// reader, including ones for reading files.
+func (c *Client) NewReader(ctx context.Context, prefix string, vpk tf2vpk.ValvePakRef) (*tf2vpk.Reader, error) {
+	return tf2vpk.NewReaderFunc(func(i tf2vpk.ValvePakIndex) (io.ReaderAt, error) {
+		return c.OpenReaderAt(ctx, Key(prefix, vpk, i))
+	})
+}
+
+// NewWriter creates a new tf2vpk.Writer uploading a VPK under prefix. The Path
+// of vpk is ignored. Blocks are uploaded as they are written, and the dir index
+// is uploaded last, so the VPK isn't visible to readers until it is complete
+// (unless it is replacing an existing one). If writing fails, incomplete
+// uploads are aborted, but blocks which were already uploaded are not deleted.
+func (c *Client) NewWriter(ctx context.Context, prefix string, vpk tf2vpk.ValvePakRef) *tf2vpk.Writer {
+	return tf2vpk.NewWriterFunc(func(i tf2vpk.ValvePakIndex) (io.Writer, error) {
+		return c.Create(ctx, Key(prefix, vpk, i)), nil
+	})
+}
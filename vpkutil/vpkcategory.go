@@ -0,0 +1,106 @@
+package vpkutil
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+)
+
+// AssetCategory is a broad type of asset, as determined by ClassifyAsset.
+type AssetCategory string
+
+// Asset categories.
+const (
+	AssetMaps      AssetCategory = "maps"
+	AssetMaterials AssetCategory = "materials"
+	AssetModels    AssetCategory = "models"
+	AssetSounds    AssetCategory = "sounds"
+	AssetScripts   AssetCategory = "scripts"
+	AssetMedia     AssetCategory = "media"
+	AssetParticles AssetCategory = "particles"
+	AssetShaders   AssetCategory = "shaders"
+	AssetResource  AssetCategory = "resource"
+	AssetConfig    AssetCategory = "cfg"
+	AssetOther     AssetCategory = "other"
+)
+
+// assetCategoryRules are checked by extension first, then by the top-level
+// directory, so files like scripts/foo.txt are still scripts.
+var assetCategoryRules = []struct {
+	Category AssetCategory
+	Dir      string
+	Ext      []string
+}{
+	{AssetMaps, "maps", []string{".bsp", ".bsp_lump", ".ent", ".ain", ".nav", ".lmp"}},
+	{AssetMaterials, "materials", []string{".vmt", ".vtf"}},
+	{AssetModels, "models", []string{".mdl", ".vvd", ".vtx", ".phy", ".ani", ".rrig"}},
+	{AssetSounds, "sound", []string{".wav", ".mp3", ".ogg", ".mbnk", ".mstr", ".opus"}},
+	{AssetScripts, "scripts", []string{".nut", ".gnut", ".rson"}},
+	{AssetMedia, "media", []string{".bik"}},
+	{AssetParticles, "particles", []string{".pcf"}},
+	{AssetShaders, "shaders", []string{".vcs"}},
+	{AssetResource, "resource", []string{".res", ".ttf"}},
+	{AssetConfig, "cfg", []string{".cfg"}},
+}
+
+// AssetCategories returns all asset categories, including AssetOther.
+func AssetCategories() []AssetCategory {
+	cs := make([]AssetCategory, 0, len(assetCategoryRules)+1)
+	for _, r := range assetCategoryRules {
+		cs = append(cs, r.Category)
+	}
+	return append(cs, AssetOther)
+}
+
+// AssetCategoryNames is like AssetCategories, but returns strings.
+func AssetCategoryNames() []string {
+	var s []string
+	for _, c := range AssetCategories() {
+		s = append(s, string(c))
+	}
+	return s
+}
+
+// ParseAssetCategory parses an asset category name.
+func ParseAssetCategory(s string) (AssetCategory, error) {
+	if c := AssetCategory(strings.ToLower(s)); slices.Contains(AssetCategories(), c) {
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown asset category %q", s)
+}
+
+// ClassifyAsset determines the category of a VPK file by its path.
+func ClassifyAsset(p string) AssetCategory {
+	p = strings.ToLower(p)
+	if ext := path.Ext(p); ext != "" {
+		for _, r := range assetCategoryRules {
+			if slices.Contains(r.Ext, ext) {
+				return r.Category
+			}
+		}
+	}
+	if dir, _, ok := strings.Cut(p, "/"); ok {
+		for _, r := range assetCategoryRules {
+			if dir == r.Dir {
+				return r.Category
+			}
+		}
+	}
+	return AssetOther
+}
+
+// MatchAssetCategories checks whether p is in one of the named asset
+// categories. An error is returned if any name is invalid.
+func MatchAssetCategories(names []string, p string) (bool, error) {
+	c := ClassifyAsset(p)
+	var match bool
+	for _, x := range names {
+		t, err := ParseAssetCategory(x)
+		if err != nil {
+			return false, err
+		}
+		match = match || t == c
+	}
+	return match, nil
+}
@@ -0,0 +1,55 @@
+package vpkutil
+
+import "testing"
+
+func TestClassifyAsset(t *testing.T) {
+	for p, exp := range map[string]AssetCategory{
+		"maps/mp_box.bsp":                  AssetMaps,
+		"maps/mp_box.bsp.pak000_000.vpk":   AssetMaps, // by directory
+		"maps/mp_box_lump/0000.bsp_lump":   AssetMaps,
+		"MATERIALS/A.VMT":                  AssetMaterials,
+		"models/a.mdl":                     AssetModels,
+		"sound/a.txt":                      AssetSounds,
+		"audio/a.mstr":                     AssetSounds, // by extension
+		"scripts/vscripts/a.nut":           AssetScripts,
+		"scripts/weapons/a.txt":            AssetScripts,
+		"media/a.bik":                      AssetMedia,
+		"particles/a.pcf":                  AssetParticles,
+		"shaders/fxc/a.vcs":                AssetShaders,
+		"resource/ui/a.res":                AssetResource,
+		"resource/a.ttf":                   AssetResource,
+		"cfg/a.cfg":                        AssetConfig,
+		"a.cfg":                            AssetConfig,
+		"depot/a.txt":                      AssetOther,
+		"a":                                AssetOther,
+		"materials.txt":                    AssetOther,
+		"scripts/vscripts/a.nut.bak/a.vmt": AssetMaterials, // extension takes precedence
+	} {
+		if act := ClassifyAsset(p); act != exp {
+			t.Errorf("%q: expected %s, got %s", p, exp, act)
+		}
+	}
+}
+
+func TestMatchAssetCategories(t *testing.T) {
+	for _, tc := range []struct {
+		Names []string
+		Path  string
+		Match bool
+		Err   bool
+	}{
+		{nil, "a.vmt", false, false},
+		{[]string{"materials"}, "a.vmt", true, false},
+		{[]string{"MODELS", "Materials"}, "a.vmt", true, false},
+		{[]string{"models"}, "a.vmt", false, false},
+		{[]string{"other"}, "a.txt", true, false},
+		{[]string{"materials", "nope"}, "a.vmt", false, true},
+	} {
+		m, err := MatchAssetCategories(tc.Names, tc.Path)
+		if (err != nil) != tc.Err {
+			t.Errorf("%q %q: unexpected error %v", tc.Names, tc.Path, err)
+		} else if m != tc.Match {
+			t.Errorf("%q %q: expected %t, got %t", tc.Names, tc.Path, tc.Match, m)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package vpkutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// CDNRepack copies the files from r into w without recompressing them, laid
+// out for serving the blocks from object storage with HTTP range requests.
+// Files are written grouped by directory and sorted by path (instead of the
+// dir index order, which groups them by extension), so the order only depends
+// on the files themselves and related files are close together. Set
+// w.Alignment to align the chunks. If skip is not nil, files it returns true
+// for are not copied.
+func CDNRepack(w *tf2vpk.Writer, skip func(tf2vpk.ValvePakFile) (bool, error), r *tf2vpk.Reader) error {
+	var files []tf2vpk.ValvePakFile
+	for _, f := range r.Root.File {
+		if skip != nil {
+			if s, err := skip(f); err != nil {
+				return err
+			} else if s {
+				continue
+			}
+		}
+		files = append(files, f)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i].Path, files[j].Path
+		if da, db := path.Dir(a), path.Dir(b); da != db {
+			return da < db
+		}
+		return a < b
+	})
+	for _, f := range files {
+		b, err := r.OpenBlockRaw(f.Index)
+		if err != nil {
+			return fmt.Errorf("repack %q: %w", f.Path, err)
+		}
+		if err := w.AddRaw(f, b); err != nil {
+			return fmt.Errorf("repack %q: %w", f.Path, err)
+		}
+	}
+	return nil
+}
+
+// CDNIndexVersion is the current version of the CDN index schema.
+const CDNIndexVersion = 1
+
+// CDNIndex is a sidecar index describing where each file is stored, so clients
+// can fetch files directly from the blocks with HTTP range requests without
+// parsing the dir index. Files are sorted by block and offset.
+//
+//	{
+//	  "version": 1,                  // CDNIndexVersion
+//	  "files": [
+//	    {
+//	      "path": "scripts/vscripts/foo.nut",
+//	      "block": "pak000_000.vpk", // file name of the block
+//	      "offset": 4096,            // of the first chunk in the block
+//	      "length": 567,             // from the start of the first chunk to the end of the last one
+//	      "size": 1234,              // total uncompressed size (including preload)
+//	      "crc32": 3735928559,
+//	      "preload": "",             // base64, only present if there is preload data
+//	      "extents": [
+//	        {
+//	          "offset": 0,           // in the uncompressed file
+//	          "size": 1234,
+//	          "raw_offset": 4096,    // in the block
+//	          "raw_size": 567,
+//	          "compressed": true
+//	        }
+//	      ]
+//	    }
+//	  ]
+//	}
+type CDNIndex struct {
+	Version int            `json:"version"`
+	Files   []CDNIndexFile `json:"files"`
+}
+
+// CDNIndexFile is a file in a CDNIndex.
+type CDNIndexFile struct {
+	Path    string           `json:"path"`
+	Block   string           `json:"block"`
+	Offset  int64            `json:"offset"`
+	Length  int64            `json:"length"`
+	Size    uint64           `json:"size"`
+	CRC32   uint32           `json:"crc32"`
+	Preload []byte           `json:"preload,omitempty"`
+	Extents []CDNIndexExtent `json:"extents"`
+}
+
+// CDNIndexExtent is a chunk of a file in a CDNIndex.
+type CDNIndexExtent struct {
+	Offset     uint64 `json:"offset"`
+	Size       uint64 `json:"size"`
+	RawOffset  int64  `json:"raw_offset"`
+	RawSize    int64  `json:"raw_size"`
+	Compressed bool   `json:"compressed"`
+}
+
+// NewCDNIndex creates a CDNIndex for the files in vpk.
+func NewCDNIndex(vpk tf2vpk.ValvePakRef, root tf2vpk.ValvePakDir) (CDNIndex, error) {
+	x := CDNIndex{
+		Version: CDNIndexVersion,
+		Files:   make([]CDNIndexFile, 0, len(root.File)),
+	}
+	for _, f := range root.File {
+		es, err := root.Extents(f)
+		if err != nil {
+			return x, fmt.Errorf("file %q: %w", f.Path, err)
+		}
+		j := CDNIndexFile{
+			Path:    f.Path,
+			Block:   filepath.Base(vpk.Resolve(f.Index)),
+			Offset:  -1,
+			Size:    f.Size(),
+			CRC32:   f.CRC32,
+			Preload: f.Preload,
+		}
+		for _, e := range es {
+			if e.Chunk < 0 {
+				continue // preload
+			}
+			if j.Offset == -1 || e.RawOffset < j.Offset {
+				j.Offset = e.RawOffset
+			}
+			j.Length = max(j.Length, e.RawOffset+e.RawSize)
+			j.Extents = append(j.Extents, CDNIndexExtent{
+				Offset:     e.Offset,
+				Size:       e.Size,
+				RawOffset:  e.RawOffset,
+				RawSize:    e.RawSize,
+				Compressed: e.IsCompressed(),
+			})
+		}
+		if j.Offset == -1 {
+			j.Offset = 0
+		}
+		j.Length -= j.Offset
+		x.Files = append(x.Files, j)
+	}
+	sort.SliceStable(x.Files, func(i, j int) bool {
+		a, b := x.Files[i], x.Files[j]
+		if a.Block != b.Block {
+			return a.Block < b.Block
+		}
+		return a.Offset < b.Offset
+	})
+	return x, nil
+}
+
+// Encode writes x as indented JSON.
+func (x CDNIndex) Encode(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(x)
+}
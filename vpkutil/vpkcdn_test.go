@@ -0,0 +1,118 @@
+package vpkutil
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+func TestCDNRepack(t *testing.T) {
+	files := map[string]string{}
+	for i, name := range []string{"b/z.txt", "a/y.nut", "b/a.nut", "a/b/c.txt", "a/x.txt", "skip.txt"} {
+		var b strings.Builder
+		for j := 0; b.Len() < 1000*(i+1); j++ {
+			fmt.Fprintf(&b, "%s %d\n", name, j)
+		}
+		files[name] = b.String()
+	}
+
+	vpk := tf2vpk.ValvePakRef{Path: t.TempDir(), Prefix: "english", Name: "test"}
+	w := tf2vpk.NewWriter(vpk)
+	w.Compression = tf2vpk.StoreCompression
+	w.ChunkSize = 2048
+	w.Preload = func(name string) int {
+		if name == "a/y.nut" {
+			return 10
+		}
+		return 0
+	}
+	for _, name := range sortedKeys(files) {
+		if err := w.Add(name, uint32(tf2vpk.ValvePakLoadVisible|tf2vpk.ValvePakLoadCache), 0, strings.NewReader(files[name])); err != nil {
+			t.Fatalf("add %q: %v", name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+
+	r, err := tf2vpk.NewReader(vpk)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer r.Close()
+
+	out := tf2vpk.ValvePakRef{Path: t.TempDir(), Prefix: "english", Name: "test"}
+	ow := tf2vpk.NewWriter(out)
+	ow.Alignment = 4096
+	if err := CDNRepack(ow, func(f tf2vpk.ValvePakFile) (bool, error) {
+		return f.Path == "skip.txt", nil
+	}, r); err != nil {
+		ow.Abort()
+		t.Fatalf("repack: %v", err)
+	}
+	if err := ow.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+	delete(files, "skip.txt")
+	checkTestVPK(t, out, files)
+
+	x, err := NewCDNIndex(out, ow.Root)
+	if err != nil {
+		t.Fatalf("cdn index: %v", err)
+	}
+	if x.Version != CDNIndexVersion {
+		t.Errorf("incorrect version %d", x.Version)
+	}
+
+	// files are grouped by directory, then sorted by path, and the index is
+	// sorted by offset
+	var order []string
+	for _, j := range x.Files {
+		order = append(order, j.Path)
+	}
+	if exp := []string{"a/x.txt", "a/y.nut", "a/b/c.txt", "b/a.nut", "b/z.txt"}; !slices.Equal(order, exp) {
+		t.Errorf("expected order %q, got %q", exp, order)
+	}
+
+	block, err := os.ReadFile(out.Resolve(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last int64
+	for _, j := range x.Files {
+		if j.Block != filepath.Base(out.Resolve(0)) {
+			t.Errorf("%q: incorrect block %q", j.Path, j.Block)
+		}
+		if j.Offset < last {
+			t.Errorf("%q: offset %d before the previous file", j.Path, j.Offset)
+		}
+		last = j.Offset
+		if j.Size != uint64(len(files[j.Path])) {
+			t.Errorf("%q: incorrect size %d", j.Path, j.Size)
+		}
+
+		if (j.Path == "a/y.nut") != (len(j.Preload) == 10) {
+			t.Errorf("%q: incorrect preload %q", j.Path, j.Preload)
+		}
+		data := slices.Clone(j.Preload)
+		for _, e := range j.Extents {
+			if e.RawOffset%4096 != 0 {
+				t.Errorf("%q: unaligned extent at %d", j.Path, e.RawOffset)
+			}
+			if e.Compressed || e.Offset != uint64(len(data)) || e.RawOffset < j.Offset || e.RawOffset+e.RawSize > j.Offset+j.Length {
+				t.Errorf("%q: incorrect extent %+v", j.Path, e)
+				continue
+			}
+			data = append(data, block[e.RawOffset:e.RawOffset+e.RawSize]...)
+		}
+		if !bytes.Equal(data, []byte(files[j.Path])) {
+			t.Errorf("%q: extents don't match the contents", j.Path)
+		}
+	}
+}
@@ -2,17 +2,23 @@ package vpkutil
 
 import (
 	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
 
 	"github.com/pg9182/tf2vpk"
 	"github.com/pg9182/tf2vpk/internal"
 	"github.com/spf13/pflag"
 )
 
-// CLIIncludeExclude filters VPK files using the provided globs.
+// CLIIncludeExclude filters VPK files using the provided globs and asset
+// categories.
 type CLIIncludeExclude struct {
 	Exclude        *[]string
 	ExcludeBSPLump *[]int
 	Include        *[]string
+	Type           *[]string
 }
 
 // NewCLIIncludeExclude creates a new CLIIncludeExclude and registers it with
@@ -22,11 +28,19 @@ func NewCLIIncludeExclude(set *pflag.FlagSet) CLIIncludeExclude {
 		Exclude:        pflag.StringSlice("exclude", nil, "Excludes files or directories matching the provided glob (anchor to the start with /)"),
 		ExcludeBSPLump: pflag.IntSlice("exclude-bsp-lump", nil, "Shortcut for --exclude to remove %04x.bsp_lump"),
 		Include:        pflag.StringSlice("include", nil, "Negates --exclude for files or directories matching the provided glob"),
+		Type:           pflag.StringSlice("type", nil, "Excludes files not in one of the provided asset categories ("+strings.Join(AssetCategoryNames(), ", ")+")"),
 	}
 }
 
 // Skip determines whether to skip the specified file.
 func (ie CLIIncludeExclude) Skip(f tf2vpk.ValvePakFile) (bool, error) {
+	if len(*ie.Type) != 0 {
+		if m, err := MatchAssetCategories(*ie.Type, f.Path); err != nil {
+			return false, fmt.Errorf("process types: %w", err)
+		} else if !m {
+			return true, nil
+		}
+	}
 	var excluded bool
 	for _, x := range *ie.Exclude {
 		if m, err := internal.MatchGlobParents(x, f.Path); err != nil {
@@ -58,6 +72,52 @@ func (ie CLIIncludeExclude) Skip(f tf2vpk.ValvePakFile) (bool, error) {
 	return excluded, nil
 }
 
+// CLIJobsEnv is the environment variable used as the default for --jobs.
+const CLIJobsEnv = "TF2VPK_JOBS"
+
+// CLIJobs is the number of parallel jobs to use.
+type CLIJobs struct {
+	Jobs *int
+	set  *pflag.FlagSet
+}
+
+// NewCLIJobs creates a new CLIJobs and registers it with the provided
+// [pflag.FlagSet] as --jobs, with --threads as a deprecated alias.
+func NewCLIJobs(set *pflag.FlagSet) CLIJobs {
+	j := CLIJobs{
+		Jobs: set.IntP("jobs", "j", runtime.NumCPU(), "number of parallel jobs for compression, decompression, and verification (0 to do everything on one thread; defaults to $"+CLIJobsEnv+" or the cpu count)"),
+		set:  set,
+	}
+	set.IntVar(j.Jobs, "threads", runtime.NumCPU(), "")
+	set.MarkDeprecated("threads", "use --jobs instead")
+	return j
+}
+
+// Apply gets the number of jobs from CLIJobsEnv if it wasn't set by a flag,
+// then configures GOMAXPROCS and the decompression workers for it. It must be
+// called after the flags are parsed.
+func (j CLIJobs) Apply() error {
+	if x := os.Getenv(CLIJobsEnv); x != "" && !j.set.Changed("jobs") && !j.set.Changed("threads") {
+		n, err := strconv.Atoi(x)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %w", CLIJobsEnv, x, err)
+		}
+		*j.Jobs = n
+	}
+	if *j.Jobs < 0 {
+		*j.Jobs = 0
+	}
+	if *j.Jobs > runtime.NumCPU() {
+		runtime.GOMAXPROCS(*j.Jobs)
+	}
+	if *j.Jobs == 0 {
+		tf2vpk.SetDecompressWorkers(-1)
+	} else {
+		tf2vpk.SetDecompressWorkers(*j.Jobs)
+	}
+	return nil
+}
+
 // CLIResolveOpen takes over the last 1 or 2 arguments, using them to resolve
 // and open a VPK.
 type CLIResolveOpen struct {
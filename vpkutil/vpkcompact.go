@@ -0,0 +1,250 @@
+package vpkutil
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// CompactBlock describes the space reclaimed from a block by Compact.
+type CompactBlock struct {
+	Index   tf2vpk.ValvePakIndex
+	OldSize int64 // excluding the tree for the dir index
+	NewSize int64
+}
+
+// Reclaimed returns the number of bytes removed from the block.
+func (b CompactBlock) Reclaimed() int64 {
+	return b.OldSize - b.NewSize
+}
+
+// compactRange is a range of referenced bytes in a block.
+type compactRange struct {
+	Offset, Size uint64
+	New          uint64 // new offset
+}
+
+// Compact rewrites the blocks of vpk which contain unreferenced data (e.g.,
+// left over from removing or replacing files), leaving only the chunks
+// referenced by the dir index, and updates the chunk offsets. Blocks which
+// aren't referenced at all are removed. Chunks are not moved between blocks or
+// reordered, shared chunks stay shared, and blocks without unreferenced data
+// are not modified. The compacted blocks are written to a temporary directory
+// next to the VPK, then moved into place.
+//
+// The referenced chunks are packed back-to-back, so any padding between them
+// (e.g., from [tf2vpk.Writer.Alignment]) is removed from compacted blocks.
+//
+// Since the dir indexes for other languages reference the same blocks, but may
+// reference different chunks, an error is returned if vpk has any.
+//
+// The returned blocks are the ones which were (or, if dryRun is true, would
+// be) compacted.
+func Compact(vpk tf2vpk.ValvePakRef, dryRun bool) ([]CompactBlock, error) {
+	if err := checkOtherLanguages(vpk); err != nil {
+		return nil, err
+	}
+
+	r, err := tf2vpk.NewReader(vpk)
+	if err != nil {
+		return nil, fmt.Errorf("open vpk: %w", err)
+	}
+	defer r.Close()
+
+	if err := r.CheckBounds(); err != nil {
+		return nil, fmt.Errorf("check vpk: %w", err)
+	}
+
+	// find the referenced ranges in each block
+	ranges := map[tf2vpk.ValvePakIndex][]compactRange{}
+	for _, f := range r.Root.File {
+		for _, c := range f.Chunk {
+			ranges[f.Index] = append(ranges[f.Index], compactRange{Offset: c.Offset, Size: c.CompressedSize})
+		}
+	}
+
+	names, err := vpk.List()
+	if err != nil {
+		return nil, fmt.Errorf("list blocks: %w", err)
+	}
+
+	var (
+		blocks []CompactBlock
+		keep   []tf2vpk.ValvePakIndex
+	)
+	for _, fn := range names {
+		_, i, err := tf2vpk.SplitName(fn, vpk.Prefix)
+		if err != nil {
+			return nil, fmt.Errorf("list blocks: %w", err)
+		}
+		fi, err := os.Stat(vpk.Resolve(i))
+		if err != nil {
+			return nil, fmt.Errorf("stat block %s: %w", i, err)
+		}
+		size := fi.Size()
+		if i == tf2vpk.ValvePakIndexDir {
+			n, err := r.Root.ChunkOffset()
+			if err != nil {
+				return nil, fmt.Errorf("get chunk offset: %w", err)
+			}
+			size -= int64(n)
+		}
+
+		rs := ranges[i]
+		if len(rs) == 0 {
+			if i != tf2vpk.ValvePakIndexDir || size != 0 {
+				blocks = append(blocks, CompactBlock{Index: i, OldSize: size, NewSize: 0})
+			}
+			continue
+		}
+
+		// merge overlapping ranges (i.e., shared chunks)
+		sort.Slice(rs, func(a, b int) bool {
+			return rs[a].Offset < rs[b].Offset
+		})
+		merged := rs[:1]
+		for _, x := range rs[1:] {
+			last := &merged[len(merged)-1]
+			if x.Offset <= last.Offset+last.Size {
+				last.Size = max(last.Size, x.Offset+x.Size-last.Offset)
+			} else {
+				merged = append(merged, x)
+			}
+		}
+		var live uint64
+		for j := range merged {
+			merged[j].New = live
+			live += merged[j].Size
+		}
+		ranges[i] = merged
+
+		if int64(live) < size || merged[0].Offset != 0 {
+			blocks = append(blocks, CompactBlock{Index: i, OldSize: size, NewSize: int64(live)})
+		} else if i != tf2vpk.ValvePakIndexDir {
+			keep = append(keep, i)
+		}
+	}
+	sort.Slice(blocks, func(a, b int) bool {
+		return blocks[a].Index < blocks[b].Index
+	})
+	if dryRun || len(blocks) == 0 {
+		return blocks, nil
+	}
+
+	compact := map[tf2vpk.ValvePakIndex]bool{}
+	for _, b := range blocks {
+		compact[b.Index] = true
+	}
+
+	// update the offsets
+	root := r.Root
+	root.File = make([]tf2vpk.ValvePakFile, len(r.Root.File))
+	for fi, f := range r.Root.File {
+		if compact[f.Index] {
+			rs := ranges[f.Index]
+			f.Chunk = append([]tf2vpk.ValvePakChunk(nil), f.Chunk...)
+			for ci := range f.Chunk {
+				c := &f.Chunk[ci]
+				j := sort.Search(len(rs), func(j int) bool {
+					return rs[j].Offset > c.Offset
+				}) - 1
+				c.Offset = rs[j].New + (c.Offset - rs[j].Offset)
+			}
+		}
+		root.File[fi] = f
+	}
+
+	staged := vpk
+	staged.Path = filepath.Join(vpk.Path, ".vpkcompact")
+	if err := os.RemoveAll(staged.Path); err != nil {
+		return nil, fmt.Errorf("remove old staging directory: %w", err)
+	}
+	if err := os.Mkdir(staged.Path, 0777); err != nil {
+		return nil, fmt.Errorf("create staging directory: %w", err)
+	}
+	defer os.RemoveAll(staged.Path)
+
+	write := func(i tf2vpk.ValvePakIndex, fn func(w io.Writer) error) error {
+		f, err := os.Create(staged.Resolve(i))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := fn(f); err != nil {
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			return err
+		}
+		return f.Close()
+	}
+	copyLive := func(w io.Writer, i tf2vpk.ValvePakIndex) error {
+		b, err := r.OpenBlockRaw(i)
+		if err != nil {
+			return err
+		}
+		for _, x := range ranges[i] {
+			if _, err := io.Copy(w, io.NewSectionReader(b, int64(x.Offset), int64(x.Size))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, b := range blocks {
+		if b.Index == tf2vpk.ValvePakIndexDir || len(ranges[b.Index]) == 0 {
+			continue // unreferenced blocks are removed by replaceVPK
+		}
+		if err := write(b.Index, func(w io.Writer) error {
+			return copyLive(w, b.Index)
+		}); err != nil {
+			return nil, fmt.Errorf("write block %s: %w", b.Index, err)
+		}
+	}
+	if err := write(tf2vpk.ValvePakIndexDir, func(w io.Writer) error {
+		if err := root.Serialize(w); err != nil {
+			return err
+		}
+		if compact[tf2vpk.ValvePakIndexDir] {
+			return copyLive(w, tf2vpk.ValvePakIndexDir)
+		}
+		if _, ok := ranges[tf2vpk.ValvePakIndexDir]; ok {
+			b, err := r.OpenBlockRaw(tf2vpk.ValvePakIndexDir)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(w, io.NewSectionReader(b, 0, 1<<63-1)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("write dir index: %w", err)
+	}
+	for _, i := range keep {
+		// so replaceVPK doesn't remove it (link it if possible since it may be large)
+		if err := os.Link(vpk.Resolve(i), staged.Resolve(i)); err != nil {
+			if err := write(i, func(w io.Writer) error {
+				b, err := r.OpenBlockRaw(i)
+				if err != nil {
+					return err
+				}
+				_, err = io.Copy(w, io.NewSectionReader(b, 0, 1<<63-1))
+				return err
+			}); err != nil {
+				return nil, fmt.Errorf("stage unmodified block %s: %w", i, err)
+			}
+		}
+	}
+
+	if err := r.Close(); err != nil {
+		return nil, fmt.Errorf("close vpk: %w", err)
+	}
+	if err := replaceVPK(vpk, staged, true); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
@@ -0,0 +1,139 @@
+package vpkutil
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+func TestCompact(t *testing.T) {
+	vpk := tf2vpk.ValvePakRef{Path: t.TempDir(), Prefix: "english", Name: "test"}
+	writeTestVPK(t, vpk, map[string]string{
+		"a.txt": strings.Repeat("a", 1000),
+		"b.txt": strings.Repeat("0123456789", 1000),
+		"c.txt": strings.Repeat("c", 1000),
+	})
+	exp := map[string]string{
+		"a.txt": strings.Repeat("a", 1000),
+		"c.txt": strings.Repeat("c", 1000),
+	}
+	if err := UpdateDir(vpk, false, func(root *tf2vpk.ValvePakDir) error {
+		root.File = slices.DeleteFunc(root.File, func(f tf2vpk.ValvePakFile) bool {
+			return f.Path == "b.txt"
+		})
+		return nil
+	}); err != nil {
+		t.Fatalf("remove file: %v", err)
+	}
+	fi, err := os.Stat(vpk.Resolve(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// other languages may reference the removed chunks
+	other := vpk
+	other.Prefix = "french"
+	if err := os.Link(vpk.Resolve(tf2vpk.ValvePakIndexDir), other.Resolve(tf2vpk.ValvePakIndexDir)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Compact(vpk, true); err == nil {
+		t.Errorf("expected error when compacting a vpk with other languages")
+	}
+	if err := os.Remove(other.Resolve(tf2vpk.ValvePakIndexDir)); err != nil {
+		t.Fatal(err)
+	}
+
+	blocks, err := Compact(vpk, true)
+	if err != nil {
+		t.Fatalf("compact (dry run): %v", err)
+	}
+	if len(blocks) != 1 || blocks[0].Index != 0 || blocks[0].OldSize != fi.Size() || blocks[0].Reclaimed() <= 0 {
+		t.Fatalf("incorrect blocks %+v", blocks)
+	}
+	if x, err := os.Stat(vpk.Resolve(0)); err != nil || x.Size() != fi.Size() {
+		t.Errorf("block modified by dry run")
+	}
+
+	if blocks, err = Compact(vpk, false); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if x, err := os.Stat(vpk.Resolve(0)); err != nil || x.Size() != blocks[0].NewSize {
+		t.Errorf("block not compacted")
+	}
+	checkTestVPK(t, vpk, exp)
+
+	if blocks, err = Compact(vpk, false); err != nil || len(blocks) != 0 {
+		t.Errorf("expected nothing to compact, got %+v %v", blocks, err)
+	}
+}
+
+func TestCompactUnreferencedBlock(t *testing.T) {
+	vpk := tf2vpk.ValvePakRef{Path: t.TempDir(), Prefix: "english", Name: "test"}
+	w := tf2vpk.NewWriter(vpk)
+	for i, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if err := w.SetBlock(tf2vpk.ValvePakIndex(i)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Add(name, uint32(tf2vpk.ValvePakLoadVisible|tf2vpk.ValvePakLoadCache), 0, strings.NewReader(strings.Repeat(name, 100))); err != nil {
+			t.Fatalf("add %q: %v", name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+	if err := UpdateDir(vpk, false, func(root *tf2vpk.ValvePakDir) error {
+		root.File = slices.DeleteFunc(root.File, func(f tf2vpk.ValvePakFile) bool {
+			return f.Path == "b.txt"
+		})
+		return nil
+	}); err != nil {
+		t.Fatalf("remove file: %v", err)
+	}
+	fi, err := os.Stat(vpk.Resolve(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unmodified := map[tf2vpk.ValvePakIndex][]byte{}
+	for _, i := range []tf2vpk.ValvePakIndex{0, 2} {
+		if unmodified[i], err = os.ReadFile(vpk.Resolve(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	blocks, err := Compact(vpk, true)
+	if err != nil {
+		t.Fatalf("compact (dry run): %v", err)
+	}
+	if exp := []CompactBlock{{Index: 1, OldSize: fi.Size(), NewSize: 0}}; !slices.Equal(blocks, exp) {
+		t.Fatalf("expected blocks %+v, got %+v", exp, blocks)
+	}
+	if _, err := os.Stat(vpk.Resolve(1)); err != nil {
+		t.Errorf("block removed by dry run")
+	}
+
+	if blocks, err = Compact(vpk, false); err != nil || len(blocks) != 1 {
+		t.Fatalf("compact: %+v %v", blocks, err)
+	}
+	if _, err := os.Stat(vpk.Resolve(1)); !os.IsNotExist(err) {
+		t.Errorf("unreferenced block not removed: %v", err)
+	}
+	for i, exp := range unmodified {
+		if buf, err := os.ReadFile(vpk.Resolve(i)); err != nil || string(buf) != string(exp) {
+			t.Errorf("block %s modified: %v", i, err)
+		}
+	}
+	checkTestVPK(t, vpk, map[string]string{
+		"a.txt": strings.Repeat("a.txt", 100),
+		"c.txt": strings.Repeat("c.txt", 100),
+	})
+	if names, err := vpk.List(); err != nil || len(names) != 3 {
+		t.Errorf("expected the dir index and 2 blocks, got %q %v", names, err)
+	}
+
+	if blocks, err = Compact(vpk, false); err != nil || len(blocks) != 0 {
+		t.Errorf("expected nothing to compact, got %+v %v", blocks, err)
+	}
+}
@@ -0,0 +1,179 @@
+package vpkutil
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// DuplicateReport describes the duplicate content in a VPK.
+type DuplicateReport struct {
+	Groups  []DuplicateGroup   // files with identical contents, most wasted bytes first
+	Partial []PartialDuplicate // files sharing some chunks with other files, most shared bytes first
+
+	FileWasted   uint64 // uncompressed bytes stored more than once by identical files
+	Chunks       int    // number of distinct chunk contents stored more than once
+	ChunkWasted  uint64 // uncompressed bytes stored more than once by identical chunks
+	ChunkDeduped uint64 // uncompressed bytes saved by chunks which are already shared
+}
+
+// DuplicateGroup is a set of files with identical contents.
+type DuplicateGroup struct {
+	SHA256 [sha256.Size]byte
+	Size   uint64   // uncompressed size of each file
+	Paths  []string // sorted
+	Stored int      // number of distinct copies stored in the VPK
+}
+
+// Wasted returns the number of uncompressed bytes which would be saved if the
+// files shared the same chunks.
+func (g DuplicateGroup) Wasted() uint64 {
+	return uint64(g.Stored-1) * g.Size
+}
+
+// PartialDuplicate is a file which is not identical to another file, but has
+// chunks with the same contents as chunks in other files.
+type PartialDuplicate struct {
+	Path   string
+	Size   uint64 // uncompressed size
+	Shared uint64 // uncompressed bytes in chunks also in other files
+}
+
+type dupeLoc struct {
+	Index  tf2vpk.ValvePakIndex
+	Offset uint64
+}
+
+// FindDuplicates reads every file in r (other than ones skip returns true for,
+// if not nil), hashing the uncompressed contents of each file and chunk. Since
+// the Writer deduplicates chunks by their uncompressed contents, ChunkWasted is
+// the amount of data it would save when repacking.
+func FindDuplicates(r *tf2vpk.Reader, skip func(tf2vpk.ValvePakFile) (bool, error)) (DuplicateReport, error) {
+	var rep DuplicateReport
+
+	type chunkInfo struct {
+		Size  uint64
+		Locs  map[dupeLoc]int // number of references to each copy
+		Files int             // number of files containing the chunk
+		last  int
+	}
+	type fileInfo struct {
+		File   tf2vpk.ValvePakFile
+		Size   uint64
+		Chunks [][sha256.Size]byte
+	}
+	var (
+		files  []fileInfo
+		chunks = map[[sha256.Size]byte]*chunkInfo{}
+		groups = map[[sha256.Size]byte][]int{}
+	)
+	for fidx, f := range r.Root.File {
+		if skip != nil {
+			if s, err := skip(f); err != nil {
+				return rep, err
+			} else if s {
+				continue
+			}
+		}
+		fi := fileInfo{File: f}
+		fh := sha256.New()
+		for _, c := range f.Chunk {
+			cr, err := r.OpenChunk(f, c)
+			if err != nil {
+				return rep, fmt.Errorf("read %q: %w", f.Path, err)
+			}
+			ch := sha256.New()
+			n, err := io.Copy(io.MultiWriter(fh, ch), cr)
+			if err != nil {
+				return rep, fmt.Errorf("read %q: %w", f.Path, err)
+			}
+			var cs [sha256.Size]byte
+			ch.Sum(cs[:0])
+
+			ci, ok := chunks[cs]
+			if !ok {
+				ci = &chunkInfo{Size: uint64(n), Locs: map[dupeLoc]int{}, last: -1}
+				chunks[cs] = ci
+			}
+			if ci.last != fidx {
+				ci.last = fidx
+				ci.Files++
+			}
+			ci.Locs[dupeLoc{f.Index, c.Offset}]++
+			fi.Size += uint64(n)
+			fi.Chunks = append(fi.Chunks, cs)
+		}
+		if fi.Size == 0 {
+			continue
+		}
+		var fs [sha256.Size]byte
+		fh.Sum(fs[:0])
+		groups[fs] = append(groups[fs], len(files))
+		files = append(files, fi)
+	}
+
+	for _, ci := range chunks {
+		if len(ci.Locs) > 1 {
+			rep.Chunks++
+			rep.ChunkWasted += uint64(len(ci.Locs)-1) * ci.Size
+		}
+		for _, refs := range ci.Locs {
+			rep.ChunkDeduped += uint64(refs-1) * ci.Size
+		}
+	}
+
+	for fs, is := range groups {
+		if len(is) < 2 {
+			fi := files[is[0]]
+			pd := PartialDuplicate{Path: fi.File.Path, Size: fi.Size}
+			for _, cs := range fi.Chunks {
+				if chunks[cs].Files > 1 {
+					pd.Shared += chunks[cs].Size
+				}
+			}
+			if pd.Shared != 0 {
+				rep.Partial = append(rep.Partial, pd)
+			}
+			continue
+		}
+		g := DuplicateGroup{SHA256: fs, Size: files[is[0]].Size}
+		stored := map[dupeLoc]struct{}{}
+		for _, i := range is {
+			f := files[i].File
+			g.Paths = append(g.Paths, f.Path)
+			if len(f.Chunk) != 0 {
+				stored[dupeLoc{f.Index, f.Chunk[0].Offset}] = struct{}{}
+			}
+		}
+		slices.Sort(g.Paths)
+		g.Stored = len(stored)
+		rep.FileWasted += g.Wasted()
+		rep.Groups = append(rep.Groups, g)
+	}
+
+	slices.SortFunc(rep.Groups, func(a, b DuplicateGroup) int {
+		if a.Wasted() != b.Wasted() {
+			if a.Wasted() > b.Wasted() {
+				return -1
+			}
+			return 1
+		}
+		return slices.Compare(a.Paths, b.Paths)
+	})
+	slices.SortFunc(rep.Partial, func(a, b PartialDuplicate) int {
+		if a.Shared != b.Shared {
+			if a.Shared > b.Shared {
+				return -1
+			}
+			return 1
+		}
+		if a.Path < b.Path {
+			return -1
+		}
+		return 1
+	})
+	return rep, nil
+}
@@ -0,0 +1,83 @@
+package vpkutil
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	const load = uint32(tf2vpk.ValvePakLoadVisible | tf2vpk.ValvePakLoadCache)
+	a := strings.Repeat("a", 4096)
+
+	vpk := tf2vpk.ValvePakRef{Path: t.TempDir(), Prefix: "english", Name: "test"}
+	w := tf2vpk.NewWriter(vpk)
+	for _, x := range []struct {
+		Block  tf2vpk.ValvePakIndex
+		Path   string
+		Data   string
+		Chunks []uint64
+	}{
+		{0, "a.txt", a, nil},
+		{0, "b.txt", a, nil},                        // deduplicated with a.txt
+		{0, "d.txt", a + "dddd", []uint64{4096, 4}}, // shares a chunk with a.txt
+		{0, "e.txt", "eeee", nil},
+		{1, "c.txt", a, nil}, // chunks are only deduplicated within a block
+	} {
+		if err := w.SetBlock(x.Block); err != nil {
+			t.Fatalf("set block: %v", err)
+		}
+		var err error
+		if x.Chunks == nil {
+			err = w.Add(x.Path, load, 0, strings.NewReader(x.Data))
+		} else {
+			var cs []tf2vpk.WriterChunk
+			for _, n := range x.Chunks {
+				cs = append(cs, tf2vpk.WriterChunk{LoadFlags: load, Size: n})
+			}
+			err = w.AddChunks(x.Path, cs, strings.NewReader(x.Data))
+		}
+		if err != nil {
+			t.Fatalf("add %q: %v", x.Path, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+
+	r, err := tf2vpk.NewReader(vpk)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer r.Close()
+
+	rep, err := FindDuplicates(r, nil)
+	if err != nil {
+		t.Fatalf("find duplicates: %v", err)
+	}
+	if len(rep.Groups) != 1 {
+		t.Fatalf("expected 1 group, got %+v", rep.Groups)
+	}
+	if g := rep.Groups[0]; !slices.Equal(g.Paths, []string{"a.txt", "b.txt", "c.txt"}) || g.Size != 4096 || g.Stored != 2 || g.Wasted() != 4096 {
+		t.Errorf("incorrect group %+v", g)
+	}
+	if len(rep.Partial) != 1 || rep.Partial[0] != (PartialDuplicate{"d.txt", 4100, 4096}) {
+		t.Errorf("incorrect partial duplicates %+v", rep.Partial)
+	}
+	if rep.FileWasted != 4096 || rep.Chunks != 1 || rep.ChunkWasted != 4096 || rep.ChunkDeduped != 2*4096 {
+		t.Errorf("incorrect totals %+v", rep)
+	}
+
+	// skipped files aren't included
+	rep, err = FindDuplicates(r, func(f tf2vpk.ValvePakFile) (bool, error) {
+		return f.Path == "c.txt", nil
+	})
+	if err != nil {
+		t.Fatalf("find duplicates: %v", err)
+	}
+	if len(rep.Groups) != 1 || rep.Groups[0].Stored != 1 || rep.FileWasted != 0 || rep.ChunkWasted != 0 {
+		t.Errorf("incorrect report with skipped file %+v", rep)
+	}
+}
@@ -3,7 +3,12 @@ package vpkutil
 import (
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/pg9182/tf2vpk"
 )
@@ -91,3 +96,165 @@ func UpdateDir(vpk tf2vpk.ValvePakRef, dryRun bool, fn func(*tf2vpk.ValvePakDir)
 	}
 	return nil
 }
+
+// ReplaceVPK moves the files of the VPK src over the ones of dst, removing any
+// blocks of dst which do not exist in src. The original files are renamed out
+// of the way first, and restored if anything fails, so dst is either fully
+// replaced or left as it was. Both VPKs must be on the same filesystem, and
+// neither should be open.
+//
+// Since the dir indexes for other languages of dst reference the same blocks,
+// which would no longer contain the chunks at the offsets they expect, an error
+// is returned if dst has any.
+func ReplaceVPK(dst, src tf2vpk.ValvePakRef) error {
+	if err := checkOtherLanguages(dst); err != nil {
+		return err
+	}
+	return replaceVPK(dst, src, true)
+}
+
+// checkOtherLanguages returns an error if there are dir indexes for languages
+// other than the one of vpk sharing its blocks.
+func checkOtherLanguages(vpk tf2vpk.ValvePakRef) error {
+	sets, err := tf2vpk.ScanValvePakSets(vpk.Path)
+	if err != nil {
+		return fmt.Errorf("scan vpks: %w", err)
+	}
+	for _, s := range sets {
+		if s.Name != vpk.Name {
+			continue
+		}
+		var other []string
+		for _, lang := range s.Languages {
+			if lang != vpk.Prefix {
+				other = append(other, lang)
+			}
+		}
+		if len(other) != 0 {
+			return fmt.Errorf("vpk %q shares its blocks with the dir indexes for other languages (%s), which would be corrupted", vpk.Resolve(tf2vpk.ValvePakIndexDir), strings.Join(other, ", "))
+		}
+	}
+	return nil
+}
+
+// replaceVPK is like ReplaceVPK, but only removes the blocks of dst which do
+// not exist in src if prune is true.
+func replaceVPK(dst, src tf2vpk.ValvePakRef, prune bool) error {
+	srcNames, err := src.List()
+	if err != nil {
+		return fmt.Errorf("list new vpk: %w", err)
+	}
+	dstNames, err := dst.List()
+	if err != nil {
+		return fmt.Errorf("list original vpk: %w", err)
+	}
+
+	type move struct {
+		From, To string
+	}
+	var (
+		moves   []move // src -> dst
+		backups []move // dst -> backup
+	)
+	for _, fn := range srcNames {
+		_, idx, err := tf2vpk.SplitName(fn, src.Prefix)
+		if err != nil {
+			return fmt.Errorf("list new vpk: %w", err)
+		}
+		moves = append(moves, move{filepath.Join(src.Path, fn), dst.Resolve(idx)})
+	}
+	for _, fn := range dstNames {
+		if !prune && !slices.Contains(srcNames, fn) {
+			continue
+		}
+		p := filepath.Join(dst.Path, fn)
+		backups = append(backups, move{p, filepath.Join(dst.Path, ".vpkbak-"+fn)})
+	}
+
+	rollback := func(moved, backedUp int) {
+		for _, m := range moves[:moved] {
+			os.Rename(m.To, m.From)
+		}
+		for _, m := range backups[:backedUp] {
+			os.Rename(m.To, m.From)
+		}
+	}
+	for i, m := range backups {
+		if err := os.Rename(m.From, m.To); err != nil {
+			rollback(0, i)
+			return fmt.Errorf("back up original vpk: %w", err)
+		}
+	}
+	for i, m := range moves {
+		if err := os.Rename(m.From, m.To); err != nil {
+			rollback(i, len(backups))
+			return fmt.Errorf("replace vpk: %w", err)
+		}
+	}
+	for _, m := range backups {
+		os.Remove(m.To)
+	}
+	return nil
+}
+
+// Rename renames the file from to to or, if from is a directory, moves the
+// files in it into the directory to. Only the paths are changed, so the files
+// keep referencing the same chunks. If fn is not nil, it is called for each
+// renamed file. Nothing is changed if no files match from, or if a renamed file
+// would conflict with an existing one.
+func Rename(root *tf2vpk.ValvePakDir, from, to string, fn func(oldPath, newPath string)) error {
+	from, to = strings.Trim(from, "/"), strings.Trim(to, "/")
+	if !fs.ValidPath(from) || from == "." {
+		return fmt.Errorf("rename %q: invalid source path", from)
+	}
+	if !fs.ValidPath(to) || to == "." {
+		return fmt.Errorf("rename %q: invalid destination path %q", from, to)
+	}
+
+	renamed := map[int]string{}
+	for i, f := range root.File {
+		if f.Path == from {
+			renamed[i] = to
+		} else if rest, ok := strings.CutPrefix(f.Path, from+"/"); ok {
+			renamed[i] = to + "/" + rest
+		}
+	}
+	if len(renamed) == 0 {
+		return fmt.Errorf("rename %q: %w", from, fs.ErrNotExist)
+	}
+
+	files, dirs := map[string]bool{}, map[string]bool{}
+	add := func(p string) {
+		files[p] = true
+		for d := path.Dir(p); d != "."; d = path.Dir(d) {
+			dirs[d] = true
+		}
+	}
+	for i, f := range root.File {
+		if _, ok := renamed[i]; !ok {
+			add(f.Path)
+		}
+	}
+	for i, p := range renamed {
+		if files[p] || dirs[p] {
+			return fmt.Errorf("rename %q to %q: %w", root.File[i].Path, p, fs.ErrExist)
+		}
+		for d := path.Dir(p); d != "."; d = path.Dir(d) {
+			if files[d] {
+				return fmt.Errorf("rename %q to %q: parent %q is a file", root.File[i].Path, p, d)
+			}
+		}
+		add(p)
+	}
+
+	for i, p := range renamed {
+		if fn != nil {
+			fn(root.File[i].Path, p)
+		}
+		root.File[i].Path = p
+	}
+	if err := root.SortFiles(); err != nil {
+		return fmt.Errorf("rename %q: %w", from, err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package vpkutil
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+func TestReplaceVPK(t *testing.T) {
+	dir := t.TempDir()
+	var (
+		dst = tf2vpk.ValvePakRef{Path: filepath.Join(dir, "dst"), Prefix: "english", Name: "test"}
+		src = tf2vpk.ValvePakRef{Path: filepath.Join(dir, "src"), Prefix: "english", Name: "test"}
+	)
+	for _, p := range []string{dst.Path, src.Path} {
+		if err := os.Mkdir(p, 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// dst has an extra block which should be removed
+	w := tf2vpk.NewWriter(dst)
+	if err := w.Add("a.txt", 1, 0, strings.NewReader("old")); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	w.SetBlock(1)
+	if err := w.Add("b.txt", 1, 0, strings.NewReader("old")); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+	exp := map[string]string{"a.txt": "new"}
+	writeTestVPK(t, src, exp)
+
+	if err := ReplaceVPK(dst, src); err != nil {
+		t.Fatalf("replace vpk: %v", err)
+	}
+	checkTestVPK(t, dst, exp)
+	if _, err := os.Stat(dst.Resolve(1)); err == nil {
+		t.Errorf("extra block not removed")
+	}
+	if ns, _ := src.List(); len(ns) != 0 {
+		t.Errorf("files left in source: %q", ns)
+	}
+	if ds, _ := os.ReadDir(dst.Path); len(ds) != 2 {
+		t.Errorf("expected only the new vpk, got %d files", len(ds))
+	}
+
+	// other languages share the blocks, so they must not be replaced
+	other := dst
+	other.Prefix = "french"
+	writeTestVPK(t, src, map[string]string{"a.txt": "newer"})
+	if err := os.Link(dst.Resolve(tf2vpk.ValvePakIndexDir), other.Resolve(tf2vpk.ValvePakIndexDir)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReplaceVPK(dst, src); err == nil {
+		t.Errorf("expected error when replacing a vpk with other languages")
+	}
+	checkTestVPK(t, dst, exp)
+	checkTestVPK(t, other, exp)
+}
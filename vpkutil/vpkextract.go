@@ -0,0 +1,109 @@
+package vpkutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// totalSize returns the total uncompressed size of the files which aren't
+// skipped.
+func totalSize(r *tf2vpk.Reader, skip func(tf2vpk.ValvePakFile) (bool, error)) (int64, error) {
+	var total int64
+	for _, f := range r.Root.File {
+		if skip != nil {
+			if s, err := skip(f); err != nil {
+				return 0, err
+			} else if s {
+				continue
+			}
+		}
+		total += int64(f.Size())
+	}
+	return total, nil
+}
+
+// Extract extracts the files from r into dir, creating it if it doesn't exist.
+// Files are skipped if skip is not nil and returns true. If progress is not
+// nil, it is called as data is extracted. Paths are checked with SanitizePath
+// (not lax) before extracting each file.
+func Extract(r *tf2vpk.Reader, dir string, skip func(tf2vpk.ValvePakFile) (bool, error), progress tf2vpk.ProgressFunc) error {
+	total, err := totalSize(r, skip)
+	if err != nil {
+		return err
+	}
+	var done int64
+	for _, f := range r.Root.File {
+		if skip != nil {
+			if s, err := skip(f); err != nil {
+				return err
+			} else if s {
+				continue
+			}
+		}
+		if err := func() error {
+			name, err := SanitizeJoin(dir, f.Path, false)
+			if err != nil {
+				return err
+			}
+			fr, err := r.OpenFile(f)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+				return err
+			}
+			x, err := os.Create(name)
+			if err != nil {
+				return err
+			}
+			defer x.Close()
+
+			if _, err := io.Copy(x, tf2vpk.NewProgressReader(fr, progress, &done, total, f.Path)); err != nil {
+				return err
+			}
+			return x.Close()
+		}(); err != nil {
+			return fmt.Errorf("extract %q: %w", f.Path, err)
+		}
+	}
+	return nil
+}
+
+// Verify reads all files in r, checking their checksums. It continues after
+// errors, returning all of them. Files are skipped if skip is not nil and
+// returns true. If progress is not nil, it is called as data is read.
+func Verify(r *tf2vpk.Reader, skip func(tf2vpk.ValvePakFile) (bool, error), progress tf2vpk.ProgressFunc) error {
+	total, err := totalSize(r, skip)
+	if err != nil {
+		return err
+	}
+	var (
+		done int64
+		errs []error
+	)
+	for _, f := range r.Root.File {
+		if skip != nil {
+			if s, err := skip(f); err != nil {
+				return err
+			} else if s {
+				continue
+			}
+		}
+		if err := func() error {
+			fr, err := r.OpenFile(f)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(io.Discard, tf2vpk.NewProgressReader(fr, progress, &done, total, f.Path))
+			return err
+		}(); err != nil {
+			errs = append(errs, fmt.Errorf("verify %q: %w", f.Path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
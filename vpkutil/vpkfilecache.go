@@ -0,0 +1,201 @@
+package vpkutil
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// FileCache stores decompressed files on disk, so tools repeatedly reading the
+// same files from a VPK (e.g., serve or get) don't need to decompress them
+// again.
+//
+// Files are keyed by the absolute path of the VPK's dir index, the file path,
+// the CRC32, and the uncompressed size, so cached files are not used after the
+// file is changed in the VPK. Files are verified against their checksum
+// before being added to the cache.
+//
+// FileCache is safe for concurrent use, including by multiple processes using
+// the same directory.
+type FileCache struct {
+	Dir     string
+	MaxSize int64 // if positive, the least recently used files are removed when the cache gets larger
+
+	mu    sync.Mutex
+	size  int64 // approximate
+	sized bool
+}
+
+// NewFileCache creates a new FileCache storing files in dir.
+func NewFileCache(dir string, maxSize int64) *FileCache {
+	return &FileCache{
+		Dir:     dir,
+		MaxSize: maxSize,
+	}
+}
+
+// DefaultFileCacheDir returns the default directory for the file cache in the
+// user's cache directory.
+func DefaultFileCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "tf2vpk", "files"), nil
+}
+
+// key returns the cache filename for f in vpk.
+func (c *FileCache) key(vpk tf2vpk.ValvePakRef, f tf2vpk.ValvePakFile) (string, error) {
+	p, err := filepath.Abs(vpk.Resolve(tf2vpk.ValvePakIndexDir))
+	if err != nil {
+		return "", err
+	}
+	size := f.Size()
+	h := sha256.New()
+	h.Write([]byte(p))
+	h.Write([]byte{0})
+	h.Write([]byte(f.Path))
+	h.Write([]byte{0})
+	h.Write(binary.LittleEndian.AppendUint32(nil, f.CRC32))
+	h.Write(binary.LittleEndian.AppendUint64(nil, size))
+	s := hex.EncodeToString(h.Sum(nil))
+	return filepath.Join(c.Dir, s[:2], s[2:]), nil
+}
+
+// Open opens f from the cache, extracting it from r (which should have been
+// opened from vpk) if it isn't cached yet.
+func (c *FileCache) Open(r *tf2vpk.Reader, vpk tf2vpk.ValvePakRef, f tf2vpk.ValvePakFile) (*os.File, error) {
+	name, err := c.key(vpk, f)
+	if err != nil {
+		return nil, fmt.Errorf("get cache key for %q: %w", f.Path, err)
+	}
+	if x, err := os.Open(name); err == nil {
+		now := time.Now()
+		_ = os.Chtimes(name, now, now) // for trimming
+		return x, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("open cached file for %q: %w", f.Path, err)
+	}
+	n, err := c.extract(r, f, name)
+	if err != nil {
+		return nil, fmt.Errorf("cache %q: %w", f.Path, err)
+	}
+	x, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("open cached file for %q: %w", f.Path, err)
+	}
+	if c.MaxSize > 0 {
+		c.mu.Lock()
+		c.size += n
+		trim := !c.sized || c.size > c.MaxSize
+		c.mu.Unlock()
+		if trim {
+			if err := c.Trim(); err != nil {
+				x.Close()
+				return nil, fmt.Errorf("trim cache: %w", err)
+			}
+		}
+	}
+	return x, nil
+}
+
+// extract writes f to name, returning the size.
+func (c *FileCache) extract(r *tf2vpk.Reader, f tf2vpk.ValvePakFile, name string) (int64, error) {
+	fr, err := r.OpenFile(f)
+	if err != nil {
+		return 0, err
+	}
+	if c, ok := fr.(io.Closer); ok {
+		defer c.Close()
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+		return 0, err
+	}
+	x, err := os.CreateTemp(filepath.Dir(name), ".tmp*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(x.Name())
+	defer x.Close()
+
+	n, err := io.Copy(x, fr) // the checksum is verified at EOF
+	if err != nil {
+		return 0, err
+	}
+	if err := x.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(x.Name(), name); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Trim removes the least recently used files until the cache is no larger than
+// 90% of MaxSize. Files which can't be removed (e.g., if they're open on
+// Windows) are skipped. It does nothing if MaxSize is not positive.
+func (c *FileCache) Trim() error {
+	if c.MaxSize <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	type cached struct {
+		Name  string
+		Size  int64
+		MTime time.Time
+	}
+	var (
+		files []cached
+		total int64
+	)
+	if err := filepath.WalkDir(c.Dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil // removed concurrently
+			}
+			return err
+		}
+		if !d.Type().IsRegular() || strings.HasPrefix(d.Name(), ".tmp") {
+			return nil // skip files being extracted
+		}
+		fi, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		files = append(files, cached{p, fi.Size(), fi.ModTime()})
+		total += fi.Size()
+		return nil
+	}); err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].MTime.Before(files[j].MTime)
+	})
+	for _, x := range files {
+		if total <= c.MaxSize/10*9 {
+			break
+		}
+		if err := os.Remove(x.Name); err == nil || errors.Is(err, fs.ErrNotExist) {
+			total -= x.Size
+		}
+	}
+	c.size, c.sized = total, true
+	return nil
+}
@@ -0,0 +1,166 @@
+package vpkutil
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// readCached reads name from vpk using c.
+func readCached(t *testing.T, c *FileCache, vpk tf2vpk.ValvePakRef, name string) string {
+	t.Helper()
+	r, err := tf2vpk.NewReader(vpk)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer r.Close()
+	for _, f := range r.Root.File {
+		if f.Path != name {
+			continue
+		}
+		x, err := c.Open(r, vpk, f)
+		if err != nil {
+			t.Fatalf("open %q: %v", name, err)
+		}
+		defer x.Close()
+		buf, err := io.ReadAll(x)
+		if err != nil {
+			t.Fatalf("read %q: %v", name, err)
+		}
+		return string(buf)
+	}
+	t.Fatalf("file %q not found", name)
+	return ""
+}
+
+// countCached returns the number of files in the cache.
+func countCached(t *testing.T, c *FileCache) int {
+	t.Helper()
+	ms, err := filepath.Glob(filepath.Join(c.Dir, "*", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(ms)
+}
+
+func TestFileCache(t *testing.T) {
+	vpk := tf2vpk.ValvePakRef{Path: t.TempDir(), Prefix: "english", Name: "test"}
+	c := NewFileCache(t.TempDir(), 0)
+
+	writeTestVPK(t, vpk, map[string]string{
+		"a.txt": "aaaa",
+		"b.txt": "bbbb",
+	})
+	if act := readCached(t, c, vpk, "a.txt"); act != "aaaa" {
+		t.Errorf("expected %q, got %q", "aaaa", act)
+	}
+	if n := countCached(t, c); n != 1 {
+		t.Errorf("expected 1 cached file, got %d", n)
+	}
+
+	// cached files are used as-is
+	r, err := tf2vpk.NewReader(vpk)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	name, err := c.key(vpk, r.Root.File[slices.IndexFunc(r.Root.File, func(f tf2vpk.ValvePakFile) bool {
+		return f.Path == "a.txt"
+	})])
+	r.Close()
+	if err != nil {
+		t.Fatalf("get key: %v", err)
+	}
+	if err := os.WriteFile(name, []byte("cached"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if act := readCached(t, c, vpk, "a.txt"); act != "cached" {
+		t.Errorf("expected cached contents, got %q", act)
+	}
+	if act := readCached(t, c, vpk, "b.txt"); act != "bbbb" {
+		t.Errorf("expected %q, got %q", "bbbb", act)
+	}
+	if n := countCached(t, c); n != 2 {
+		t.Errorf("expected 2 cached files, got %d", n)
+	}
+
+	// changing the crc32 or size changes the key
+	writeTestVPK(t, vpk, map[string]string{
+		"a.txt": "AAAA",
+		"b.txt": "bbbbbbbb",
+	})
+	if act := readCached(t, c, vpk, "a.txt"); act != "AAAA" {
+		t.Errorf("expected new contents after crc32 change, got %q", act)
+	}
+	if act := readCached(t, c, vpk, "b.txt"); act != "bbbbbbbb" {
+		t.Errorf("expected new contents after size change, got %q", act)
+	}
+	if n := countCached(t, c); n != 4 {
+		t.Errorf("expected 4 cached files, got %d", n)
+	}
+}
+
+func TestFileCacheTrim(t *testing.T) {
+	c := NewFileCache(t.TempDir(), 800)
+	if err := os.MkdirAll(filepath.Join(c.Dir, "00"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, x := range []struct {
+		Name string
+		Age  time.Duration
+	}{
+		{"older", 4 * time.Hour},
+		{"old", 3 * time.Hour},
+		{"new", 2 * time.Hour},
+		{"newer", 1 * time.Hour},
+		{".tmp123", 5 * time.Hour}, // being extracted
+	} {
+		p := filepath.Join(c.Dir, "00", x.Name)
+		if err := os.WriteFile(p, []byte(strings.Repeat("x", 300)), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, now.Add(-x.Age), now.Add(-x.Age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 1200 bytes (excluding the temp file) to at most 720
+	if err := c.Trim(); err != nil {
+		t.Fatalf("trim: %v", err)
+	}
+	for name, exp := range map[string]bool{
+		"older":   false,
+		"old":     false,
+		"new":     true,
+		"newer":   true,
+		".tmp123": true,
+	} {
+		if _, err := os.Stat(filepath.Join(c.Dir, "00", name)); (err == nil) != exp {
+			t.Errorf("%s: expected exists=%t, got %v", name, exp, err)
+		}
+	}
+	if c.size != 600 || !c.sized {
+		t.Errorf("expected size 600, got %d (sized=%t)", c.size, c.sized)
+	}
+
+	// nothing is removed if it's already small enough
+	if err := c.Trim(); err != nil {
+		t.Fatalf("trim: %v", err)
+	}
+	if n := countCached(t, c); n != 3 {
+		t.Errorf("expected 2 files and the temp file, got %d", n)
+	}
+
+	// it does nothing without a max size
+	c.MaxSize = 0
+	c.size = 0
+	if err := c.Trim(); err != nil || c.size != 0 {
+		t.Errorf("trim without max size: %v", err)
+	}
+}
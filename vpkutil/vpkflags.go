@@ -3,6 +3,7 @@ package vpkutil
 import (
 	"bufio"
 	"fmt"
+	"io/fs"
 	"os"
 	"sort"
 	"strconv"
@@ -67,6 +68,15 @@ func (v *VPKFlags) Add(glob string, loadFlags uint32, textureFlags uint16) error
 	return nil
 }
 
+// AddDefault adds a rule setting the default flags (VISIBLE and CACHE) for all
+// files.
+func (v *VPKFlags) AddDefault() {
+	v.rules = append(v.rules, vpkFlagsRule{
+		Glob:      "/",
+		LoadFlags: uint32(tf2vpk.ValvePakLoadVisible | tf2vpk.ValvePakLoadCache),
+	})
+}
+
 // GenerateExplicit generates a new VPKFlags based on the provided VPK with all
 // files explicitly set, replacing any existing rules.
 func (v *VPKFlags) GenerateExplicit(root tf2vpk.ValvePakDir) error {
@@ -365,3 +375,12 @@ func (v *VPKFlags) ParseFile(name string) error {
 	}
 	return v.Parse(string(buf))
 }
+
+// ParseFS is like ParseFile, but reads from fsys.
+func (v *VPKFlags) ParseFS(fsys fs.FS, name string) error {
+	buf, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return err
+	}
+	return v.Parse(string(buf))
+}
@@ -0,0 +1,72 @@
+package vpkutil
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// AddTar adds the regular files in tr to w as they are read, so an archive can
+// be streamed into a VPK (e.g., from git archive) without extracting it first.
+// Entry names are cleaned and made relative to the root of the archive.
+//
+// The files are added like [tf2vpk.Writer.AddFS], using meta for the flags and
+// chunking (or the default flags if nil), and skipping files matching it. If
+// pax is true, the flags and chunks stored in the PAX records by TarPAXRecords
+// take precedence. Chunks are only used if the file hasn't changed in size.
+//
+// Empty files are skipped, since VPKs can't contain them. If fn is not nil, it
+// is called for each file before it is added or skipped.
+// If progress is not nil, it is called as data is read.
+func AddTar(w *tf2vpk.Writer, tr *tar.Reader, meta tf2vpk.WriterFSMeta, pax bool, fn func(name string, size int64, skip bool), progress tf2vpk.ProgressFunc) error {
+	var done int64
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read tar: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		skip := hdr.Size == 0 || (meta != nil && meta.Skip(name))
+		if fn != nil {
+			fn(name, hdr.Size, skip)
+		}
+		if skip {
+			continue
+		}
+		if err := addTarFile(w, name, hdr, tf2vpk.NewProgressReader(tr, progress, &done, 0, name), meta, pax); err != nil {
+			return fmt.Errorf("pack %q: %w", name, err)
+		}
+	}
+}
+
+func addTarFile(w *tf2vpk.Writer, name string, hdr *tar.Header, r io.Reader, meta tf2vpk.WriterFSMeta, pax bool) error {
+	if pax {
+		load, texture, chunks, ok, err := ParseTarPAXRecords(hdr.PAXRecords)
+		if err != nil {
+			return err
+		}
+		if ok {
+			var n uint64
+			for _, c := range chunks {
+				n += c.Size
+			}
+			if chunks != nil && n == uint64(hdr.Size) {
+				return w.AddChunks(name, chunks, r)
+			}
+			return w.Add(name, load, texture, r) // no chunks, or the file was modified
+		}
+	}
+	return w.AddMeta(name, uint64(hdr.Size), r, meta)
+}
@@ -0,0 +1,159 @@
+package vpkutil
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/fs"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+func TestAddTar(t *testing.T) {
+	type entry struct {
+		Name string
+		Type byte
+		Data string
+		PAX  map[string]string
+	}
+	entries := []entry{
+		{"./scripts/", tar.TypeDir, "", nil},
+		{"./scripts/a.nut", tar.TypeReg, "a", nil},
+		{"/x/../b.txt", tar.TypeReg, "b", nil},
+		{"c.lnk", tar.TypeSymlink, "", nil},
+		{"empty.txt", tar.TypeReg, "", nil},
+		{"old.bak", tar.TypeReg, "old", nil},
+		{"pax.txt", tar.TypeReg, strings.Repeat("p", 300), map[string]string{
+			PAXLoadFlags:    "00000001",
+			PAXTextureFlags: "0000",
+			PAXChunks:       "100,200",
+		}},
+		{"paxmod.txt", tar.TypeReg, strings.Repeat("m", 301), map[string]string{
+			PAXLoadFlags:    "00000001",
+			PAXTextureFlags: "0000",
+			PAXChunks:       "100,200", // the file was modified
+		}},
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.Name, Typeflag: e.Type, Size: int64(len(e.Data)), Mode: 0666, PAXRecords: e.PAX}
+		if e.Type == tar.TypeSymlink {
+			hdr.Linkname = "b.txt"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.Data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	meta := &PackMeta{}
+	if err := meta.VPKFlags.Parse("00000000000000000000000000000011 0000000000000000 /\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := meta.VPKIgnore.Parse("*.bak\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, x := range []struct {
+		Name  string
+		Meta  tf2vpk.WriterFSMeta
+		PAX   bool
+		Load  uint32 // for files without pax records, or with pax=false
+		Files []string
+	}{
+		{"NoMeta", nil, false, uint32(tf2vpk.ValvePakLoadVisible | tf2vpk.ValvePakLoadCache), []string{"b.txt", "old.bak", "pax.txt", "paxmod.txt", "scripts/a.nut"}},
+		{"Meta", meta, false, 3, []string{"b.txt", "pax.txt", "paxmod.txt", "scripts/a.nut"}},
+		{"PAX", meta, true, 3, []string{"b.txt", "pax.txt", "paxmod.txt", "scripts/a.nut"}},
+	} {
+		t.Run(x.Name, func(t *testing.T) {
+			var (
+				called []string
+				last   int64
+			)
+			vpk := tf2vpk.ValvePakRef{Path: t.TempDir(), Prefix: "english", Name: "test"}
+			w := tf2vpk.NewWriter(vpk)
+			if err := AddTar(w, tar.NewReader(bytes.NewReader(buf.Bytes())), x.Meta, x.PAX, func(name string, size int64, skip bool) {
+				if skip != !slices.Contains(x.Files, name) {
+					t.Errorf("%q: incorrect skip %t", name, skip)
+				}
+				called = append(called, name)
+			}, func(done, total int64, _ string) {
+				if done < last {
+					t.Errorf("incorrect progress %d", done)
+				}
+				last = done
+			}); err != nil {
+				t.Fatalf("add tar: %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("write vpk: %v", err)
+			}
+			if exp := []string{"scripts/a.nut", "b.txt", "empty.txt", "old.bak", "pax.txt", "paxmod.txt"}; !slices.Equal(called, exp) {
+				t.Errorf("expected callbacks for %q, got %q", exp, called)
+			}
+
+			r, err := tf2vpk.NewReader(vpk)
+			if err != nil {
+				t.Fatalf("read vpk: %v", err)
+			}
+			defer r.Close()
+
+			var (
+				names []string
+				size  int64
+			)
+			for _, f := range r.Root.File {
+				names = append(names, f.Path)
+				size += int64(f.Size())
+				i := slices.IndexFunc(entries, func(e entry) bool {
+					return strings.HasSuffix(e.Name, f.Path)
+				})
+				if b, err := fs.ReadFile(r, f.Path); err != nil {
+					t.Errorf("read %q: %v", f.Path, err)
+				} else if string(b) != entries[i].Data {
+					t.Errorf("read %q: incorrect contents", f.Path)
+				}
+				exp := x.Load
+				if x.PAX && entries[i].PAX != nil {
+					exp = 1
+				}
+				if load, _ := f.LoadFlags(); load != exp {
+					t.Errorf("%q: expected load flags %#x, got %#x", f.Path, exp, load)
+				}
+				if x.PAX && f.Path == "pax.txt" && len(f.Chunk) != 2 {
+					t.Errorf("%q: expected chunks from pax records, got %d", f.Path, len(f.Chunk))
+				}
+				if f.Path == "paxmod.txt" && len(f.Chunk) != 1 {
+					t.Errorf("%q: expected modified file to be re-chunked, got %d", f.Path, len(f.Chunk))
+				}
+			}
+			slices.Sort(names)
+			if !slices.Equal(names, x.Files) {
+				t.Errorf("expected files %q, got %q", x.Files, names)
+			}
+			if last != size {
+				t.Errorf("expected progress for %d bytes, got %d", size, last)
+			}
+		})
+	}
+
+	// invalid pax records are rejected
+	buf.Reset()
+	tw = tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "a.txt", Size: 1, Mode: 0666, PAXRecords: map[string]string{PAXLoadFlags: "x", PAXTextureFlags: "0"}})
+	tw.Write([]byte("a"))
+	tw.Close()
+	w := tf2vpk.NewWriter(tf2vpk.ValvePakRef{Path: t.TempDir(), Prefix: "english", Name: "test"})
+	defer w.Abort()
+	if err := AddTar(w, tar.NewReader(&buf), nil, true, nil, nil); err == nil || !strings.Contains(err.Error(), "a.txt") {
+		t.Errorf("expected error for invalid pax records, got %v", err)
+	}
+}
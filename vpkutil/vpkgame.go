@@ -0,0 +1,195 @@
+package vpkutil
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// Game is a game whose installs can be located automatically.
+type Game struct {
+	Name        string // short name (e.g., for command-line flags)
+	SteamAppID  int
+	Dir         string // default install directory name
+	VPKDir      string // relative to the install directory
+	RegistryKey string // under HKLM\SOFTWARE, with the install directory in "Install Dir" (set by the EA app/Origin)
+}
+
+// Games contains the games supported by FindGame.
+var Games = []Game{
+	{Name: "tf2", SteamAppID: 1237970, Dir: "Titanfall2", VPKDir: "vpk", RegistryKey: `Respawn\Titanfall2`},
+}
+
+// LookupGame returns the game with the provided short name.
+func LookupGame(name string) (Game, bool) {
+	for _, g := range Games {
+		if strings.EqualFold(g.Name, name) {
+			return g, true
+		}
+	}
+	return Game{}, false
+}
+
+// GameInstall is an install of a game found by FindGame.
+type GameInstall struct {
+	Game   Game
+	Path   string // install directory
+	Source string // where it was found (steam, registry, origin, path)
+}
+
+// VPKDir returns the directory containing the VPKs.
+func (i GameInstall) VPKDir() string {
+	return filepath.Join(i.Path, filepath.FromSlash(i.Game.VPKDir))
+}
+
+// FindGame looks for installs of g in Steam libraries (including ones used by
+// Proton), the EA app/Origin registry keys (on Windows) and install manifests,
+// and the default install paths, in that order. Only installs with a VPK directory containing at least one
+// VPK are returned, and each install is only returned once.
+func FindGame(g Game) []GameInstall {
+	var (
+		res  []GameInstall
+		seen = map[string]bool{}
+	)
+	add := func(source, dir string) {
+		if dir == "" {
+			return
+		}
+		if a, err := filepath.Abs(dir); err == nil {
+			dir = a
+		}
+		if e, err := filepath.EvalSymlinks(dir); err == nil {
+			dir = e
+		}
+		k := dir
+		if isWindows {
+			k = strings.ToLower(k)
+		}
+		if seen[k] {
+			return
+		}
+		i := GameInstall{Game: g, Path: dir, Source: source}
+		if sets, err := tf2vpk.ScanValvePakSets(i.VPKDir()); err != nil || len(sets) == 0 {
+			return
+		}
+		seen[k] = true
+		res = append(res, i)
+	}
+	for _, lib := range steamLibraries() {
+		dir := g.Dir
+		if buf, err := os.ReadFile(filepath.Join(lib, "steamapps", "appmanifest_"+strconv.Itoa(g.SteamAppID)+".acf")); err == nil {
+			if x := vdfValues(buf, "installdir"); len(x) != 0 {
+				dir = x[0]
+			}
+		}
+		add("steam", filepath.Join(lib, "steamapps", "common", dir))
+	}
+	for _, dir := range registryInstallDirs(g) {
+		add("registry", dir)
+	}
+	roots := windowsRoots()
+	for _, root := range roots {
+		ms, _ := filepath.Glob(filepath.Join(root, "ProgramData", "Origin", "LocalContent", g.Dir, "*.mfst"))
+		for _, m := range ms {
+			add("origin", originInstallPath(m, root))
+		}
+	}
+	for _, root := range roots {
+		for _, pf := range []string{"Program Files", "Program Files (x86)"} {
+			for _, x := range []string{"EA Games", "Origin Games"} {
+				add("path", filepath.Join(root, pf, x, g.Dir))
+			}
+		}
+	}
+	return res
+}
+
+// steamLibraries returns the Steam library folders from the libraryfolders.vdf
+// of each Steam install found, including the Steam install itself.
+func steamLibraries() []string {
+	var libs []string
+	for _, root := range steamRoots() {
+		if fi, err := os.Stat(filepath.Join(root, "steamapps")); err != nil || !fi.IsDir() {
+			continue
+		}
+		libs = append(libs, root)
+		if buf, err := os.ReadFile(filepath.Join(root, "steamapps", "libraryfolders.vdf")); err == nil {
+			for _, p := range vdfValues(buf, "path") {
+				libs = append(libs, filepath.FromSlash(p))
+			}
+		}
+	}
+	return libs
+}
+
+var vdfValueRe = regexp.MustCompile(`"([^"\\]*)"\s+"((?:[^"\\]|\\.)*)"`)
+
+// vdfValues returns the values of all keys named key in a Valve KeyValues
+// file. Nesting is ignored.
+func vdfValues(buf []byte, key string) []string {
+	var vs []string
+	for _, m := range vdfValueRe.FindAllSubmatch(buf, -1) {
+		if strings.EqualFold(string(m[1]), key) {
+			vs = append(vs, strings.NewReplacer(`\\`, `\`, `\"`, `"`).Replace(string(m[2])))
+		}
+	}
+	return vs
+}
+
+// originInstallPath gets the install path from an Origin/EA app manifest. On
+// other platforms, root is the drive_c of the Wine prefix the manifest is in,
+// and the path is converted to a path in it.
+func originInstallPath(name, root string) string {
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		return ""
+	}
+	q, _ := url.ParseQuery(strings.TrimPrefix(strings.TrimSpace(string(buf)), "?")) // still returns the valid values on error
+	p := q.Get("dipinstallpath")
+	if p == "" {
+		return ""
+	}
+	if !isWindows {
+		if len(p) < 2 || p[1] != ':' || !strings.EqualFold(p[:1], "c") {
+			return ""
+		}
+		p = filepath.Join(root, filepath.FromSlash(strings.ReplaceAll(p[2:], `\`, "/")))
+	}
+	return p
+}
+
+// SteamBuildID returns the Steam build ID of the game installed in dir (or a
+// directory inside it), from the app manifest in the Steam library containing
+// it. If it isn't in a Steam library, it returns an empty string.
+func SteamBuildID(dir string) string {
+	d, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		p := filepath.Dir(d)
+		if p == d {
+			return ""
+		}
+		if strings.EqualFold(filepath.Base(p), "common") {
+			ms, _ := filepath.Glob(filepath.Join(filepath.Dir(p), "appmanifest_*.acf"))
+			for _, m := range ms {
+				buf, err := os.ReadFile(m)
+				if err != nil {
+					continue
+				}
+				if x := vdfValues(buf, "installdir"); len(x) != 0 && strings.EqualFold(x[0], filepath.Base(d)) {
+					if x := vdfValues(buf, "buildid"); len(x) != 0 {
+						return x[0]
+					}
+				}
+			}
+		}
+		d = p
+	}
+}
@@ -0,0 +1,54 @@
+//go:build !windows
+
+package vpkutil
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const isWindows = false
+
+// steamRoots returns the possible Steam install directories.
+func steamRoots() []string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	return []string{
+		filepath.Join(home, ".steam", "steam"),
+		filepath.Join(home, ".steam", "root"),
+		filepath.Join(home, ".local", "share", "Steam"),
+		filepath.Join(home, ".var", "app", "com.valvesoftware.Steam", ".local", "share", "Steam"), // flatpak
+		filepath.Join(home, "snap", "steam", "common", ".local", "share", "Steam"),
+		filepath.Join(home, "Library", "Application Support", "Steam"), // macOS
+	}
+}
+
+// windowsRoots returns the drive_c of the Wine prefixes which the game may be
+// installed in, including Proton prefixes (e.g., for the EA app added as a
+// non-Steam game) and Lutris prefixes.
+func windowsRoots() []string {
+	var rs []string
+	if x := os.Getenv("WINEPREFIX"); x != "" {
+		rs = append(rs, filepath.Join(x, "drive_c"))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		rs = append(rs, filepath.Join(home, ".wine", "drive_c"))
+		if ms, _ := filepath.Glob(filepath.Join(home, "Games", "*", "drive_c")); len(ms) != 0 {
+			rs = append(rs, ms...)
+		}
+	}
+	for _, lib := range steamLibraries() {
+		if ms, _ := filepath.Glob(filepath.Join(lib, "steamapps", "compatdata", "*", "pfx", "drive_c")); len(ms) != 0 {
+			rs = append(rs, ms...)
+		}
+	}
+	return rs
+}
+
+// registryInstallDirs returns nil, since the registry is only available on
+// Windows.
+func registryInstallDirs(g Game) []string {
+	return nil
+}
@@ -0,0 +1,76 @@
+package vpkutil
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestVDFValues(t *testing.T) {
+	const vdf = `"libraryfolders"
+{
+	"0"
+	{
+		"path"		"C:\\Program Files (x86)\\Steam"
+		"label"		""
+		"apps"
+		{
+			"1237970"		"12345"
+		}
+	}
+	"1"
+	{
+		"PATH"		"/mnt/games/steam"
+		"label"		"with \"quotes\""
+	}
+}
+`
+	for _, tc := range []struct {
+		Key string
+		Exp []string
+	}{
+		{"path", []string{`C:\Program Files (x86)\Steam`, "/mnt/games/steam"}},
+		{"label", []string{"", `with "quotes"`}},
+		{"1237970", []string{"12345"}},
+		{"apps", nil},
+		{"missing", nil},
+	} {
+		if act := vdfValues([]byte(vdf), tc.Key); !slices.Equal(act, tc.Exp) {
+			t.Errorf("%q: expected %q, got %q", tc.Key, tc.Exp, act)
+		}
+	}
+}
+
+func TestOriginInstallPath(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		Manifest string
+		Windows  string
+		Other    string // relative to the root
+	}{
+		{"?dipinstallpath=C%3a%5cGames%5cTitanfall2%5c&id=1", `C:\Games\Titanfall2\`, "Games/Titanfall2"},
+		{"?id=1&dipInstallPath=C%3a%5cGames", "", ""},
+		{"?id=1", "", ""},
+		{"dipinstallpath=D%3a%5cGames%5cTitanfall2", `D:\Games\Titanfall2`, ""},
+		{"?dipinstallpath=C%3a%5cGames%5cTitanfall2&bad=%zz", `C:\Games\Titanfall2`, "Games/Titanfall2"},
+		{"", "", ""},
+	} {
+		name := filepath.Join(dir, "test.mfst")
+		if err := os.WriteFile(name, []byte(tc.Manifest), 0666); err != nil {
+			t.Fatal(err)
+		}
+		exp := tc.Windows
+		if !isWindows {
+			if exp = ""; tc.Other != "" {
+				exp = filepath.Join(dir, filepath.FromSlash(tc.Other))
+			}
+		}
+		if act := originInstallPath(name, dir); act != exp {
+			t.Errorf("%q: expected %q, got %q", tc.Manifest, exp, act)
+		}
+	}
+	if act := originInstallPath(filepath.Join(dir, "missing.mfst"), dir); act != "" {
+		t.Errorf("missing manifest: expected no path, got %q", act)
+	}
+}
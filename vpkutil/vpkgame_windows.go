@@ -0,0 +1,81 @@
+//go:build windows
+
+package vpkutil
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+const isWindows = true
+
+// steamRoots returns the possible Steam install directories.
+func steamRoots() []string {
+	var rs []string
+	if x := regString(syscall.HKEY_CURRENT_USER, `Software\Valve\Steam`, "SteamPath"); x != "" {
+		rs = append(rs, filepath.Clean(x)) // uses forward slashes
+	}
+	for _, env := range []string{"ProgramFiles(x86)", "ProgramFiles"} {
+		if x := os.Getenv(env); x != "" {
+			rs = append(rs, filepath.Join(x, "Steam"))
+		}
+	}
+	return rs
+}
+
+// windowsRoots returns the roots of the drives.
+func windowsRoots() []string {
+	var rs []string
+	for c := 'C'; c <= 'Z'; c++ {
+		r := string(c) + `:\`
+		if _, err := os.Stat(r); err == nil {
+			rs = append(rs, r)
+		}
+	}
+	return rs
+}
+
+// registryInstallDirs returns the install directories of g written to the
+// registry by the EA app/Origin.
+func registryInstallDirs(g Game) []string {
+	if g.RegistryKey == "" {
+		return nil
+	}
+	var rs []string
+	for _, k := range []string{`SOFTWARE\`, `SOFTWARE\WOW6432Node\`} {
+		if x := regString(syscall.HKEY_LOCAL_MACHINE, k+g.RegistryKey, "Install Dir"); x != "" {
+			rs = append(rs, x)
+		}
+	}
+	return rs
+}
+
+// regString reads a string value from the registry, returning an empty string
+// if it doesn't exist.
+func regString(root syscall.Handle, path, name string) string {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return ""
+	}
+	n, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return ""
+	}
+	var k syscall.Handle
+	if err := syscall.RegOpenKeyEx(root, p, 0, syscall.KEY_READ, &k); err != nil {
+		return ""
+	}
+	defer syscall.RegCloseKey(k)
+
+	var typ, size uint32
+	if err := syscall.RegQueryValueEx(k, n, nil, &typ, nil, &size); err != nil || (typ != syscall.REG_SZ && typ != syscall.REG_EXPAND_SZ) || size < 2 {
+		return ""
+	}
+	buf := make([]uint16, size/2)
+	if err := syscall.RegQueryValueEx(k, n, nil, &typ, (*byte)(unsafe.Pointer(&buf[0])), &size); err != nil {
+		return ""
+	}
+	return syscall.UTF16ToString(buf)
+}
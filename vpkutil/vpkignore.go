@@ -3,6 +3,7 @@ package vpkutil
 import (
 	"bufio"
 	"fmt"
+	"io/fs"
 	"os"
 	"strings"
 
@@ -196,3 +197,12 @@ func (v *VPKIgnore) ParseFile(name string) error {
 	}
 	return v.Parse(string(buf))
 }
+
+// ParseFS is like ParseFile, but reads from fsys.
+func (v *VPKIgnore) ParseFS(fsys fs.FS, name string) error {
+	buf, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return err
+	}
+	return v.Parse(string(buf))
+}
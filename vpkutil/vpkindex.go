@@ -0,0 +1,341 @@
+package vpkutil
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// SearchIndex is an index of the files in all VPKs in a directory, so they can
+// be searched without parsing every dir index each time. It is stored on disk
+// by UpdateSearchIndex, and each VPK is re-parsed when the size or modification
+// time of its dir index changes.
+type SearchIndex struct {
+	Dir  string // absolute
+	VPKs []SearchIndexVPK
+}
+
+// SearchIndexVPK is a dir index in a SearchIndex.
+type SearchIndexVPK struct {
+	Name  string // file name of the dir index
+	Size  int64
+	MTime int64 // unix nanoseconds
+	Files []SearchIndexFile
+}
+
+// SearchIndexFile is a file in a SearchIndexVPK.
+type SearchIndexFile struct {
+	Path           string
+	Index          tf2vpk.ValvePakIndex
+	CRC32          uint32
+	Size           uint64
+	CompressedSize uint64
+	LoadFlags      uint32
+	TextureFlags   uint16
+}
+
+// searchIndexMagic and searchIndexVersion identify the on-disk format, which is
+// gzipped, and consists of the directory, then each VPK followed by its files,
+// each prefixed by the count. Strings are prefixed by their length, and all
+// integers other than the CRC32 are varints.
+const (
+	searchIndexMagic   = "TF2VPKSI"
+	searchIndexVersion = 1
+)
+
+// DefaultSearchIndexDir returns the default directory for search indexes in
+// the user's cache directory.
+func DefaultSearchIndexDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "tf2vpk", "index"), nil
+}
+
+// searchIndexPath returns the path of the index for vpkDir (which must be
+// absolute) in cacheDir.
+func searchIndexPath(cacheDir, vpkDir string) string {
+	h := sha256.Sum256([]byte(vpkDir))
+	return filepath.Join(cacheDir, hex.EncodeToString(h[:16])+".idx")
+}
+
+// UpdateSearchIndex loads the index for vpkDir from cacheDir, re-parsing the
+// dir indexes which have changed, and saving it if anything changed. An index
+// which can't be read (e.g., if it is corrupt or from another version) is
+// rebuilt. The number of re-parsed dir indexes is returned.
+func UpdateSearchIndex(cacheDir, vpkDir string) (*SearchIndex, int, error) {
+	dir, err := filepath.Abs(vpkDir)
+	if err != nil {
+		return nil, 0, err
+	}
+	name := searchIndexPath(cacheDir, dir)
+
+	old := map[string]SearchIndexVPK{}
+	if f, err := os.Open(name); err == nil {
+		x, err := ReadSearchIndex(f)
+		f.Close()
+		if err == nil && x.Dir == dir {
+			for _, v := range x.VPKs {
+				old[v.Name] = v
+			}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, 0, fmt.Errorf("open index: %w", err)
+	}
+
+	ms, err := filepath.Glob(filepath.Join(dir, "*_dir.vpk"))
+	if err != nil {
+		return nil, 0, err
+	}
+	sort.Strings(ms)
+
+	var (
+		x       = &SearchIndex{Dir: dir}
+		updated int
+	)
+	for _, m := range ms {
+		fi, err := os.Stat(m)
+		if err != nil {
+			return nil, 0, fmt.Errorf("stat vpk: %w", err)
+		}
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		v := SearchIndexVPK{
+			Name:  filepath.Base(m),
+			Size:  fi.Size(),
+			MTime: fi.ModTime().UnixNano(),
+		}
+		if o, ok := old[v.Name]; ok && o.Size == v.Size && o.MTime == v.MTime {
+			x.VPKs = append(x.VPKs, o)
+			delete(old, v.Name)
+			continue
+		}
+		delete(old, v.Name)
+		if v.Files, err = searchIndexFiles(m); err != nil {
+			return nil, 0, fmt.Errorf("index vpk %q: %w", v.Name, err)
+		}
+		x.VPKs = append(x.VPKs, v)
+		updated++
+	}
+
+	if updated != 0 || len(old) != 0 {
+		if err := x.save(name); err != nil {
+			return nil, 0, fmt.Errorf("save index: %w", err)
+		}
+	}
+	return x, updated, nil
+}
+
+// searchIndexFiles parses the dir index at name.
+func searchIndexFiles(name string) ([]SearchIndexFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		root  tf2vpk.ValvePakDir
+		files []SearchIndexFile
+	)
+	if err := root.DeserializeStream(bufio.NewReader(f), func(vf tf2vpk.ValvePakFile) error {
+		load, err := vf.LoadFlags()
+		if err != nil {
+			return fmt.Errorf("file %q: %w", vf.Path, err)
+		}
+		texture, err := vf.TextureFlags()
+		if err != nil {
+			return fmt.Errorf("file %q: %w", vf.Path, err)
+		}
+		files = append(files, SearchIndexFile{
+			Path:           vf.Path,
+			Index:          vf.Index,
+			CRC32:          vf.CRC32,
+			Size:           vf.Size(),
+			CompressedSize: vf.CompressedSize(),
+			LoadFlags:      load,
+			TextureFlags:   texture,
+		})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// save atomically writes x to name.
+func (x *SearchIndex) save(name string) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), ".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if err := x.Write(f); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// Write writes x in the on-disk format.
+func (x *SearchIndex) Write(w io.Writer) error {
+	zw := gzip.NewWriter(w)
+	bw := bufio.NewWriter(zw)
+
+	var b []byte
+	str := func(s string) {
+		b = binary.AppendUvarint(b, uint64(len(s)))
+		b = append(b, s...)
+	}
+	flush := func() error {
+		_, err := bw.Write(b)
+		b = b[:0]
+		return err
+	}
+
+	b = append(b, searchIndexMagic...)
+	b = binary.AppendUvarint(b, searchIndexVersion)
+	str(x.Dir)
+	b = binary.AppendUvarint(b, uint64(len(x.VPKs)))
+	for _, v := range x.VPKs {
+		str(v.Name)
+		b = binary.AppendVarint(b, v.Size)
+		b = binary.AppendVarint(b, v.MTime)
+		b = binary.AppendUvarint(b, uint64(len(v.Files)))
+		for _, f := range v.Files {
+			str(f.Path)
+			b = binary.AppendUvarint(b, uint64(f.Index))
+			b = binary.LittleEndian.AppendUint32(b, f.CRC32)
+			b = binary.AppendUvarint(b, f.Size)
+			b = binary.AppendUvarint(b, f.CompressedSize)
+			b = binary.AppendUvarint(b, uint64(f.LoadFlags))
+			b = binary.AppendUvarint(b, uint64(f.TextureFlags))
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// ReadSearchIndex reads an index written by Write.
+func ReadSearchIndex(r io.Reader) (*SearchIndex, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(zr)
+
+	var rerr error
+	uvarint := func(limit uint64) uint64 {
+		if rerr != nil {
+			return 0
+		}
+		n, err := binary.ReadUvarint(br)
+		if err == nil && n > limit {
+			err = fmt.Errorf("value %d out of range", n)
+		}
+		if rerr = err; err != nil {
+			return 0
+		}
+		return n
+	}
+	varint := func() int64 {
+		if rerr != nil {
+			return 0
+		}
+		n, err := binary.ReadVarint(br)
+		rerr = err
+		return n
+	}
+	uint32le := func() uint32 {
+		var b [4]byte
+		if rerr == nil {
+			_, rerr = io.ReadFull(br, b[:])
+		}
+		return binary.LittleEndian.Uint32(b[:])
+	}
+	str := func() string {
+		n := uvarint(1 << 16)
+		if rerr != nil {
+			return ""
+		}
+		b := make([]byte, n)
+		_, rerr = io.ReadFull(br, b)
+		return string(b)
+	}
+
+	magic := make([]byte, len(searchIndexMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, err
+	}
+	if string(magic) != searchIndexMagic {
+		return nil, fmt.Errorf("invalid magic %q", magic)
+	}
+	if v := uvarint(1<<32 - 1); rerr == nil && v != searchIndexVersion {
+		return nil, fmt.Errorf("unsupported version %d", v)
+	}
+
+	x := &SearchIndex{Dir: str()}
+	x.VPKs = make([]SearchIndexVPK, uvarint(1<<16))
+	for i := range x.VPKs {
+		v := &x.VPKs[i]
+		v.Name = str()
+		v.Size = varint()
+		v.MTime = varint()
+		nf := uvarint(1 << 24)
+		for j := uint64(0); j < nf && rerr == nil; j++ {
+			v.Files = append(v.Files, SearchIndexFile{
+				Path:           str(),
+				Index:          tf2vpk.ValvePakIndex(uvarint(1<<16 - 1)),
+				CRC32:          uint32le(),
+				Size:           uvarint(1<<64 - 1),
+				CompressedSize: uvarint(1<<64 - 1),
+				LoadFlags:      uint32(uvarint(1<<32 - 1)),
+				TextureFlags:   uint16(uvarint(1<<16 - 1)),
+			})
+		}
+		if rerr != nil {
+			break
+		}
+	}
+	if rerr != nil {
+		if rerr == io.EOF {
+			rerr = io.ErrUnexpectedEOF
+		}
+		return nil, rerr
+	}
+
+	// read to the end so the gzip checksum is verified
+	if n, err := io.Copy(io.Discard, br); err != nil {
+		return nil, err
+	} else if n != 0 {
+		return nil, fmt.Errorf("%d bytes of trailing data", n)
+	}
+	return x, nil
+}
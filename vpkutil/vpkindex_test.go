@@ -0,0 +1,100 @@
+package vpkutil
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+func TestSearchIndex(t *testing.T) {
+	exp := &SearchIndex{
+		Dir: "/games/tf2/vpk",
+		VPKs: []SearchIndexVPK{
+			{Name: "englishclient_mp_common.bsp.pak000_dir.vpk", Size: 1 << 40, MTime: -1, Files: []SearchIndexFile{
+				{"a.txt", 0, 0xDEADBEEF, 1, 1, 0x101, 0},
+				{"materials/b.vtf", tf2vpk.ValvePakIndexDir, 0, 1 << 63, 1 << 33, 1<<32 - 1, 1<<16 - 1},
+			}},
+			{Name: "empty_dir.vpk"},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := exp.Write(&buf); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	act, err := ReadSearchIndex(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !reflect.DeepEqual(exp, act) {
+		t.Errorf("expected %+v, got %+v", exp, act)
+	}
+
+	// every truncation must be detected (the last byte is the end of the gzip trailer)
+	for n := 0; n < buf.Len()-1; n++ {
+		if _, err := ReadSearchIndex(bytes.NewReader(buf.Bytes()[:n])); err == nil {
+			t.Errorf("truncated to %d bytes: expected error", n)
+		}
+	}
+
+	var bad bytes.Buffer
+	if err := (&SearchIndex{Dir: strings.Repeat("x", 1<<17)}).Write(&bad); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := ReadSearchIndex(&bad); err == nil {
+		t.Errorf("expected error for oversized string")
+	}
+}
+
+func TestUpdateSearchIndex(t *testing.T) {
+	cache, dir := t.TempDir(), t.TempDir()
+
+	vpk := tf2vpk.ValvePakRef{Path: dir, Prefix: "english", Name: "test"}
+	write := func(files ...string) {
+		w := tf2vpk.NewWriter(vpk)
+		for _, name := range files {
+			if err := w.Add(name, 1, 0, strings.NewReader(name)); err != nil {
+				t.Fatalf("add %q: %v", name, err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("write vpk: %v", err)
+		}
+	}
+	update := func(updated, files int) {
+		t.Helper()
+		x, n, err := UpdateSearchIndex(cache, dir)
+		if err != nil {
+			t.Fatalf("update index: %v", err)
+		}
+		if n != updated {
+			t.Errorf("expected %d updated, got %d", updated, n)
+		}
+		if len(x.VPKs) != 1 || len(x.VPKs[0].Files) != files {
+			t.Errorf("expected 1 vpk with %d files, got %+v", files, x.VPKs)
+		}
+	}
+
+	write("a.txt")
+	update(1, 1)
+	update(0, 1)
+
+	write("a.txt", "b.txt")
+	// make sure the change is noticed even if the size and mtime are coarse
+	if err := os.Chtimes(vpk.Resolve(tf2vpk.ValvePakIndexDir), time.Time{}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	update(1, 2)
+	update(0, 2)
+
+	// corrupt indexes are rebuilt
+	if err := os.WriteFile(searchIndexPath(cache, dir), []byte("garbage"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	update(1, 2)
+}
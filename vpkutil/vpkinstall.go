@@ -0,0 +1,379 @@
+package vpkutil
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// InstallManifestVersion is the current version of the install manifest schema.
+const InstallManifestVersion = 1
+
+// InstallManifest lists the expected contents of every VPK in a game install,
+// so an install can be checked against a known-good one with VerifyInstall.
+// VPKs are sorted by name, and files by path.
+//
+//	{
+//	  "version": 1,               // InstallManifestVersion
+//	  "game": "tf2",              // optional
+//	  "build": "...",             // optional
+//	  "vpks": [{
+//	    "name": "englishclient_frontend.bsp.pak000_dir.vpk",
+//	    "files": [{"path": "...", "size": 123, "crc32": 456, "sha256": "..."}]
+//	  }]
+//	}
+//
+// A manifest can be signed with Ed25519 (see SignInstallManifest) to prove it
+// was published by someone trusted. The signature covers the exact bytes of
+// the manifest file.
+type InstallManifest struct {
+	Version int                  `json:"version"`
+	Game    string               `json:"game,omitempty"`
+	Build   string               `json:"build,omitempty"`
+	VPKs    []InstallManifestVPK `json:"vpks"`
+}
+
+// InstallManifestVPK is a dir index in an InstallManifest.
+type InstallManifestVPK struct {
+	Name  string                `json:"name"` // file name of the dir index
+	Files []InstallManifestFile `json:"files"`
+}
+
+// InstallManifestFile is a file in an InstallManifestVPK.
+type InstallManifestFile struct {
+	Path   string `json:"path"`
+	Size   uint64 `json:"size"`
+	CRC32  uint32 `json:"crc32"`
+	SHA256 string `json:"sha256"` // hex
+}
+
+// ReadInstallManifest reads and validates a manifest written by Encode.
+func ReadInstallManifest(r io.Reader) (*InstallManifest, error) {
+	var m InstallManifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, err
+	}
+	if m.Version != InstallManifestVersion {
+		return nil, fmt.Errorf("unsupported version %d", m.Version)
+	}
+	seen := map[string]bool{}
+	for _, v := range m.VPKs {
+		if v.Name == "" || v.Name != filepath.Base(v.Name) || !strings.HasSuffix(v.Name, "_dir"+tf2vpk.Ext) {
+			return nil, fmt.Errorf("invalid vpk name %q", v.Name)
+		}
+		if seen[v.Name] {
+			return nil, fmt.Errorf("duplicate vpk %q", v.Name)
+		}
+		seen[v.Name] = true
+		for _, f := range v.Files {
+			if b, err := hex.DecodeString(f.SHA256); err != nil || len(b) != 32 {
+				return nil, fmt.Errorf("vpk %q: file %q: invalid sha256 %q", v.Name, f.Path, f.SHA256)
+			}
+		}
+	}
+	return &m, nil
+}
+
+// Encode writes m as indented JSON, sorting the VPKs and files first so the
+// output only depends on the contents.
+func (m *InstallManifest) Encode(w io.Writer) error {
+	sort.Slice(m.VPKs, func(i, j int) bool {
+		return m.VPKs[i].Name < m.VPKs[j].Name
+	})
+	for _, v := range m.VPKs {
+		sort.Slice(v.Files, func(i, j int) bool {
+			return v.Files[i].Path < v.Files[j].Path
+		})
+	}
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(m)
+}
+
+// ParseInstallManifestKey parses a base64 Ed25519 public key.
+func ParseInstallManifestKey(s string) (ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key: expected %d bytes, got %d", ed25519.PublicKeySize, len(b))
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+// VerifyInstallManifestSignature checks the base64 Ed25519 signature sig of the
+// manifest file contents buf.
+func VerifyInstallManifestSignature(buf, sig []byte, key ed25519.PublicKey) error {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	if !ed25519.Verify(key, buf, b) {
+		return fmt.Errorf("signature does not match")
+	}
+	return nil
+}
+
+// InstallDiffKind is the type of difference found by VerifyInstall.
+type InstallDiffKind int
+
+const (
+	InstallModified InstallDiffKind = iota // the contents are different or unreadable
+	InstallMissing                         // in the manifest, but not the install
+	InstallExtra                           // in the install, but not the manifest
+)
+
+func (k InstallDiffKind) String() string {
+	switch k {
+	case InstallModified:
+		return "modified"
+	case InstallMissing:
+		return "missing"
+	case InstallExtra:
+		return "extra"
+	}
+	return fmt.Sprintf("InstallDiffKind(%d)", int(k))
+}
+
+// InstallDiff is a difference between an install and a manifest. If Path is
+// empty, it applies to the entire VPK.
+type InstallDiff struct {
+	Kind   InstallDiffKind
+	VPK    string
+	Path   string
+	Reason string // optional
+}
+
+// VerifyInstall compares the VPKs in dir against m, calling fn for each
+// difference. The size and CRC32 of each file are compared first, and only
+// files which match are read to check the SHA-256, using n goroutines. Dir
+// indexes in dir which are not in m are reported as extra. If progress is not
+// nil, it is called after each file is checked. Only errors which prevent
+// checking the install at all are returned.
+func VerifyInstall(m *InstallManifest, dir string, n int, fn func(InstallDiff), progress tf2vpk.ProgressFunc) error {
+	var total, done int64
+	for _, v := range m.VPKs {
+		for _, f := range v.Files {
+			total += int64(f.Size)
+		}
+	}
+	names := map[string]bool{}
+	for _, v := range m.VPKs {
+		names[v.Name] = true
+		verifyInstallVPK(v, dir, n, fn, func(f InstallManifestFile) {
+			if done += int64(f.Size); progress != nil {
+				progress(done, total, f.Path)
+			}
+		})
+	}
+	ms, err := filepath.Glob(filepath.Join(dir, "*_dir"+tf2vpk.Ext))
+	if err != nil {
+		return err
+	}
+	sort.Strings(ms)
+	for _, x := range ms {
+		if name := filepath.Base(x); !names[name] {
+			fn(InstallDiff{Kind: InstallExtra, VPK: name})
+		}
+	}
+	return nil
+}
+
+func verifyInstallVPK(v InstallManifestVPK, dir string, n int, fn func(InstallDiff), checked func(InstallManifestFile)) {
+	r, err := func() (*tf2vpk.Reader, error) {
+		name := filepath.Join(dir, v.Name)
+		if _, err := os.Stat(name); err != nil {
+			return nil, err
+		}
+		vpk, err := tf2vpk.PathToValvePakRef(name, "")
+		if err != nil {
+			return nil, err
+		}
+		return tf2vpk.NewPartialReader(vpk)
+	}()
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			fn(InstallDiff{Kind: InstallMissing, VPK: v.Name})
+		} else {
+			fn(InstallDiff{Kind: InstallModified, VPK: v.Name, Reason: err.Error()})
+		}
+		for _, f := range v.Files {
+			checked(f)
+		}
+		return
+	}
+	defer r.Close()
+
+	files := make(map[string]tf2vpk.ValvePakFile, len(r.Root.File))
+	for _, f := range r.Root.File {
+		files[f.Path] = f
+	}
+	missing := r.MissingBlocks()
+	for _, mf := range v.Files {
+		f, ok := files[mf.Path]
+		delete(files, mf.Path)
+		switch {
+		case !ok:
+			fn(InstallDiff{Kind: InstallMissing, VPK: v.Name, Path: mf.Path})
+		case slices.Contains(missing, f.Index):
+			fn(InstallDiff{Kind: InstallMissing, VPK: v.Name, Path: mf.Path, Reason: "block " + f.Index.String() + " is missing"})
+		case f.Size() != mf.Size:
+			fn(InstallDiff{Kind: InstallModified, VPK: v.Name, Path: mf.Path, Reason: fmt.Sprintf("size is %d, expected %d", f.Size(), mf.Size)})
+		case f.CRC32 != mf.CRC32:
+			fn(InstallDiff{Kind: InstallModified, VPK: v.Name, Path: mf.Path, Reason: fmt.Sprintf("crc32 is %08X, expected %08X", f.CRC32, mf.CRC32)})
+		default:
+			if h, err := r.HashFile(f, n); err != nil {
+				fn(InstallDiff{Kind: InstallModified, VPK: v.Name, Path: mf.Path, Reason: err.Error()})
+			} else if s := hex.EncodeToString(h.SHA256[:]); !strings.EqualFold(s, mf.SHA256) {
+				fn(InstallDiff{Kind: InstallModified, VPK: v.Name, Path: mf.Path, Reason: fmt.Sprintf("sha256 is %s, expected %s", s, strings.ToLower(mf.SHA256))})
+			}
+		}
+		checked(mf)
+	}
+	extra := make([]string, 0, len(files))
+	for p := range files {
+		extra = append(extra, p)
+	}
+	sort.Strings(extra)
+	for _, p := range extra {
+		fn(InstallDiff{Kind: InstallExtra, VPK: v.Name, Path: p})
+	}
+}
+
+// NewInstallManifest hashes the files in every dir index in dir using n
+// goroutines. Files shared between the dir indexes for different languages
+// (i.e., with the same name, block, and chunk offset) are only read once. If
+// progress is not nil, it is called with an empty path once the total size is
+// known, then as files are hashed.
+func NewInstallManifest(dir string, n int, progress tf2vpk.ProgressFunc) (*InstallManifest, error) {
+	ms, err := filepath.Glob(filepath.Join(dir, "*_dir"+tf2vpk.Ext))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(ms)
+
+	type key struct {
+		name   string
+		index  tf2vpk.ValvePakIndex
+		offset uint64
+		crc32  uint32
+		size   uint64
+	}
+	var (
+		m      = &InstallManifest{Version: InstallManifestVersion}
+		hashes = map[key]string{}
+		refs   = make([]tf2vpk.ValvePakRef, len(ms))
+		total  int64
+		done   int64
+	)
+	for i, x := range ms {
+		if refs[i], err = tf2vpk.PathToValvePakRef(x, ""); err != nil {
+			return nil, err
+		}
+		if progress != nil {
+			var d tf2vpk.ValvePakDir
+			if err := func() error {
+				f, err := os.Open(x)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				return d.DeserializeStream(bufio.NewReader(f), func(f tf2vpk.ValvePakFile) error {
+					total += int64(f.Size())
+					return nil
+				})
+			}(); err != nil {
+				return nil, fmt.Errorf("read vpk %q: %w", filepath.Base(x), err)
+			}
+		}
+	}
+	if progress != nil {
+		progress(0, total, "")
+	}
+	for i, x := range ms {
+		v := InstallManifestVPK{Name: filepath.Base(x)}
+		if err := func() error {
+			r, err := tf2vpk.NewReader(refs[i])
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+
+			for _, f := range r.Root.File {
+				k := key{refs[i].Name, f.Index, 0, f.CRC32, f.Size()}
+				if len(f.Chunk) != 0 {
+					k.offset = f.Chunk[0].Offset
+				}
+				h, ok := hashes[k]
+				if !ok {
+					s, err := r.HashFile(f, n)
+					if err != nil {
+						return fmt.Errorf("hash %q: %w", f.Path, err)
+					}
+					h = hex.EncodeToString(s.SHA256[:])
+					hashes[k] = h
+				}
+				v.Files = append(v.Files, InstallManifestFile{
+					Path:   f.Path,
+					Size:   f.Size(),
+					CRC32:  f.CRC32,
+					SHA256: h,
+				})
+				if done += int64(f.Size()); progress != nil {
+					progress(done, total, f.Path)
+				}
+			}
+			return nil
+		}(); err != nil {
+			return nil, fmt.Errorf("vpk %q: %w", v.Name, err)
+		}
+		m.VPKs = append(m.VPKs, v)
+	}
+	return m, nil
+}
+
+// ParseInstallManifestPrivateKey parses a base64 Ed25519 private key (or seed)
+// as written by GenerateInstallManifestKey.
+func ParseInstallManifestPrivateKey(s string) (ed25519.PrivateKey, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+	switch len(b) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(b), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(b), nil
+	}
+	return nil, fmt.Errorf("invalid private key: expected %d bytes, got %d", ed25519.SeedSize, len(b))
+}
+
+// GenerateInstallManifestKey generates a new Ed25519 key for signing manifests,
+// returning the base64 public key and private key seed.
+func GenerateInstallManifestKey() (public, private string, err error) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(pub), base64.StdEncoding.EncodeToString(priv.Seed()), nil
+}
+
+// SignInstallManifest returns the base64 Ed25519 signature of the manifest file
+// contents buf, as checked by VerifyInstallManifestSignature.
+func SignInstallManifest(buf []byte, key ed25519.PrivateKey) []byte {
+	return []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(key, buf)) + "\n")
+}
@@ -0,0 +1,326 @@
+package vpkutil
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+var testInstall = map[string]map[string]string{
+	"client_a.bsp.pak000": {
+		"a.txt":     strings.Repeat("a", 10000),
+		"dir/b.txt": "bbbb",
+	},
+	"client_b.bsp.pak000": {
+		"c.txt": strings.Repeat("c", 5000),
+	},
+}
+
+// writeTestInstall writes the VPKs in testInstall to a new directory.
+func writeTestInstall(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, files := range testInstall {
+		w := tf2vpk.NewWriter(tf2vpk.ValvePakRef{Path: dir, Prefix: "english", Name: name})
+		w.Compression = tf2vpk.StoreCompression // so we can corrupt it easily
+		for _, p := range sortedKeys(files) {
+			if err := w.Add(p, 1, 0, strings.NewReader(files[p])); err != nil {
+				t.Fatalf("add %q: %v", p, err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("write vpk: %v", err)
+		}
+	}
+	return dir
+}
+
+// copyInstallManifest returns a deep copy of m.
+func copyInstallManifest(m *InstallManifest) *InstallManifest {
+	c := *m
+	c.VPKs = slices.Clone(m.VPKs)
+	for i := range c.VPKs {
+		c.VPKs[i].Files = slices.Clone(c.VPKs[i].Files)
+	}
+	return &c
+}
+
+func TestVerifyInstall(t *testing.T) {
+	dir := writeTestInstall(t)
+	m, err := NewInstallManifest(dir, 2, nil)
+	if err != nil {
+		t.Fatalf("create manifest: %v", err)
+	}
+	const (
+		a = "englishclient_a.bsp.pak000_dir.vpk"
+		b = "englishclient_b.bsp.pak000_dir.vpk"
+	)
+
+	verify := func(m *InstallManifest, dir string) []string {
+		t.Helper()
+		var diffs []string
+		if err := VerifyInstall(m, dir, 2, func(d InstallDiff) {
+			diffs = append(diffs, d.Kind.String()+" "+d.VPK+" "+d.Path)
+		}, nil); err != nil {
+			t.Fatalf("verify install: %v", err)
+		}
+		return diffs
+	}
+	for _, x := range []struct {
+		Name   string
+		Tamper func(m *InstallManifest)
+		Diffs  []string
+	}{
+		{"Unmodified", func(m *InstallManifest) {}, nil},
+		{"SHA256", func(m *InstallManifest) {
+			m.VPKs[0].Files[0].SHA256 = strings.Repeat("0", 64)
+		}, []string{"modified " + a + " a.txt"}},
+		{"CRC32", func(m *InstallManifest) {
+			m.VPKs[0].Files[1].CRC32++
+		}, []string{"modified " + a + " dir/b.txt"}},
+		{"Size", func(m *InstallManifest) {
+			m.VPKs[1].Files[0].Size++
+		}, []string{"modified " + b + " c.txt"}},
+		{"MissingFile", func(m *InstallManifest) {
+			m.VPKs[1].Files = append(m.VPKs[1].Files, InstallManifestFile{Path: "d.txt", SHA256: strings.Repeat("0", 64)})
+		}, []string{"missing " + b + " d.txt"}},
+		{"ExtraFile", func(m *InstallManifest) {
+			m.VPKs[0].Files = m.VPKs[0].Files[1:]
+		}, []string{"extra " + a + " a.txt"}},
+		{"MissingVPK", func(m *InstallManifest) {
+			m.VPKs = append(m.VPKs, InstallManifestVPK{Name: "englishclient_c.bsp.pak000_dir.vpk"})
+		}, []string{"missing englishclient_c.bsp.pak000_dir.vpk "}},
+		{"ExtraVPK", func(m *InstallManifest) {
+			m.VPKs = m.VPKs[:1]
+		}, []string{"extra " + b + " "}},
+	} {
+		t.Run(x.Name, func(t *testing.T) {
+			c := copyInstallManifest(m)
+			x.Tamper(c)
+			if diffs := verify(c, dir); !slices.Equal(diffs, x.Diffs) {
+				t.Errorf("expected %q, got %q", x.Diffs, diffs)
+			}
+		})
+	}
+
+	// tampering with the install itself
+	vpk := tf2vpk.ValvePakRef{Path: dir, Prefix: "english", Name: "client_a.bsp.pak000"}
+	bf, err := os.OpenFile(vpk.Resolve(0), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bf.WriteAt([]byte{'!'}, 100); err != nil {
+		t.Fatal(err)
+	}
+	bf.Close()
+	if diffs := verify(m, dir); !slices.Equal(diffs, []string{"modified " + a + " a.txt"}) {
+		t.Errorf("corrupt block: got %q", diffs)
+	}
+	if err := os.Remove(vpk.Resolve(0)); err != nil {
+		t.Fatal(err)
+	}
+	if diffs := verify(m, dir); !slices.Equal(diffs, []string{"missing " + a + " a.txt", "missing " + a + " dir/b.txt"}) {
+		t.Errorf("missing block: got %q", diffs)
+	}
+}
+
+func TestReadInstallManifest(t *testing.T) {
+	m, err := NewInstallManifest(writeTestInstall(t), 1, nil)
+	if err != nil {
+		t.Fatalf("create manifest: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := m.Encode(&buf); err != nil {
+		t.Fatalf("encode manifest: %v", err)
+	}
+	if act, err := ReadInstallManifest(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Errorf("read manifest: %v", err)
+	} else if fmt.Sprint(act) != fmt.Sprint(m) {
+		t.Errorf("expected %+v, got %+v", m, act)
+	}
+
+	for _, x := range []struct {
+		Name   string
+		Tamper func(m *InstallManifest)
+	}{
+		{"Version", func(m *InstallManifest) { m.Version++ }},
+		{"EmptyName", func(m *InstallManifest) { m.VPKs[0].Name = "" }},
+		{"PathName", func(m *InstallManifest) { m.VPKs[0].Name = "../" + m.VPKs[0].Name }},
+		{"BlockName", func(m *InstallManifest) { m.VPKs[0].Name = "client_a.bsp.pak000_000.vpk" }},
+		{"Duplicate", func(m *InstallManifest) { m.VPKs[1].Name = m.VPKs[0].Name }},
+		{"ShortSHA256", func(m *InstallManifest) { m.VPKs[0].Files[0].SHA256 = m.VPKs[0].Files[0].SHA256[2:] }},
+		{"InvalidSHA256", func(m *InstallManifest) { m.VPKs[0].Files[0].SHA256 = "zz" + m.VPKs[0].Files[0].SHA256[2:] }},
+	} {
+		c := copyInstallManifest(m)
+		x.Tamper(c)
+		b, err := json.Marshal(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadInstallManifest(bytes.NewReader(b)); err == nil {
+			t.Errorf("%s: expected error", x.Name)
+		}
+	}
+	if _, err := ReadInstallManifest(bytes.NewReader(buf.Bytes()[:buf.Len()/2])); err == nil {
+		t.Errorf("truncated: expected error")
+	}
+}
+
+func TestVerifyInstallManifestSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := []byte(`{"version": 1, "vpks": []}` + "\n")
+	sig := SignInstallManifest(buf, priv)
+
+	if err := VerifyInstallManifestSignature(buf, sig, pub); err != nil {
+		t.Errorf("valid signature: %v", err)
+	}
+	if err := VerifyInstallManifestSignature(buf, bytes.TrimSpace(sig), pub); err != nil {
+		t.Errorf("valid signature without newline: %v", err)
+	}
+
+	tampered := bytes.Replace(buf, []byte("[]"), []byte("[ ]"), 1)
+	if err := VerifyInstallManifestSignature(tampered, sig, pub); err == nil {
+		t.Errorf("tampered manifest: expected error")
+	}
+	if err := VerifyInstallManifestSignature(buf, sig, other); err == nil {
+		t.Errorf("wrong key: expected error")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[0] ^= 1
+	for name, s := range map[string][]byte{
+		"flipped":   []byte(base64.StdEncoding.EncodeToString(raw)),
+		"truncated": []byte(base64.StdEncoding.EncodeToString(raw[:32])),
+		"empty":     nil,
+		"invalid":   []byte("not base64!"),
+	} {
+		if err := VerifyInstallManifestSignature(buf, s, pub); err == nil {
+			t.Errorf("%s signature: expected error", name)
+		}
+	}
+}
+
+func TestNewInstallManifest(t *testing.T) {
+	dir := writeTestInstall(t)
+
+	// another language sharing the blocks
+	buf, err := os.ReadFile(filepath.Join(dir, "englishclient_a.bsp.pak000_dir.vpk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "frenchclient_a.bsp.pak000_dir.vpk"), buf, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		calls []string
+		total int64
+		last  int64
+	)
+	m, err := NewInstallManifest(dir, 2, func(done, n int64, path string) {
+		if len(calls) == 0 && (path != "" || done != 0) {
+			t.Errorf("expected the total first, got %d/%d %q", done, n, path)
+		}
+		if done < last || done > n {
+			t.Errorf("incorrect progress %d/%d", done, n)
+		}
+		calls = append(calls, path)
+		total, last = n, done
+	})
+	if err != nil {
+		t.Fatalf("create manifest: %v", err)
+	}
+	if m.Version != InstallManifestVersion {
+		t.Errorf("incorrect version %d", m.Version)
+	}
+
+	var size int64
+	exp := map[string]map[string]string{
+		"englishclient_a.bsp.pak000_dir.vpk": testInstall["client_a.bsp.pak000"],
+		"englishclient_b.bsp.pak000_dir.vpk": testInstall["client_b.bsp.pak000"],
+		"frenchclient_a.bsp.pak000_dir.vpk":  testInstall["client_a.bsp.pak000"],
+	}
+	if len(m.VPKs) != len(exp) {
+		t.Fatalf("expected %d vpks, got %d", len(exp), len(m.VPKs))
+	}
+	for _, v := range m.VPKs {
+		files, ok := exp[v.Name]
+		if !ok {
+			t.Errorf("unexpected vpk %q", v.Name)
+			continue
+		}
+		if len(v.Files) != len(files) {
+			t.Errorf("%q: expected %d files, got %d", v.Name, len(files), len(v.Files))
+		}
+		for _, f := range v.Files {
+			data := files[f.Path]
+			h := sha256.Sum256([]byte(data))
+			if f != (InstallManifestFile{f.Path, uint64(len(data)), crc32.ChecksumIEEE([]byte(data)), hex.EncodeToString(h[:])}) {
+				t.Errorf("%q: incorrect file %+v", v.Name, f)
+			}
+			size += int64(len(data))
+		}
+	}
+	if total != size || last != size || len(calls) != 1+5 {
+		t.Errorf("incorrect progress: %d/%d bytes, %d calls", last, total, len(calls))
+	}
+}
+
+func TestInstallManifestKey(t *testing.T) {
+	pub, priv, err := GenerateInstallManifestKey()
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	pk, err := ParseInstallManifestKey(pub + "\n")
+	if err != nil {
+		t.Fatalf("parse public key: %v", err)
+	}
+	sk, err := ParseInstallManifestPrivateKey(priv + "\n")
+	if err != nil {
+		t.Fatalf("parse private key: %v", err)
+	}
+	if !pk.Equal(sk.Public()) {
+		t.Errorf("private key doesn't match public key")
+	}
+
+	// the full private key is also accepted
+	if k, err := ParseInstallManifestPrivateKey(base64.StdEncoding.EncodeToString(sk)); err != nil || !k.Equal(sk) {
+		t.Errorf("parse full private key: %v", err)
+	}
+
+	buf := []byte("manifest")
+	if err := VerifyInstallManifestSignature(buf, SignInstallManifest(buf, sk), pk); err != nil {
+		t.Errorf("verify signature: %v", err)
+	}
+
+	for _, s := range []string{"", "not base64!", base64.StdEncoding.EncodeToString(make([]byte, 31))} {
+		if _, err := ParseInstallManifestKey(s); err == nil {
+			t.Errorf("public key %q: expected error", s)
+		}
+		if _, err := ParseInstallManifestPrivateKey(s); err == nil {
+			t.Errorf("private key %q: expected error", s)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package vpkutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// JSONDumpVersion is the current version of the JSON dump schema.
+const JSONDumpVersion = 1
+
+// JSONDir is the JSON representation of a VPK dir index, for use by external
+// tools. All fields other than preload are always present, and the files are
+// in dir index order.
+//
+//	{
+//	  "version": 1,                  // JSONDumpVersion
+//	  "magic": 1437209140,           // 0x55AA1234
+//	  "major_version": 2,
+//	  "minor_version": 3,
+//	  "files": [
+//	    {
+//	      "path": "scripts/vscripts/foo.nut",
+//	      "crc32": 3735928559,       // of the uncompressed contents
+//	      "preload_bytes": 0,
+//	      "preload": "",             // base64, only present if preload_bytes is non-zero
+//	      "index": 0,                // block index (32767 for the dir)
+//	      "size": 1234,              // total uncompressed size (including preload)
+//	      "compressed_size": 567,    // total compressed size
+//	      "chunks": [
+//	        {
+//	          "load_flags": 257,
+//	          "texture_flags": 0,
+//	          "offset": 0,           // in the block
+//	          "compressed_size": 567,
+//	          "uncompressed_size": 1234 // equal to compressed_size if stored
+//	        }
+//	      ]
+//	    }
+//	  ]
+//	}
+type JSONDir struct {
+	Version      int        `json:"version"`
+	Magic        uint32     `json:"magic"`
+	MajorVersion uint16     `json:"major_version"`
+	MinorVersion uint16     `json:"minor_version"`
+	Files        []JSONFile `json:"files"`
+}
+
+// JSONFile is the JSON representation of a VPK file entry.
+type JSONFile struct {
+	Path           string      `json:"path"`
+	CRC32          uint32      `json:"crc32"`
+	PreloadBytes   uint16      `json:"preload_bytes"`
+	Preload        []byte      `json:"preload,omitempty"`
+	Index          uint16      `json:"index"`
+	Size           uint64      `json:"size"`
+	CompressedSize uint64      `json:"compressed_size"`
+	Chunks         []JSONChunk `json:"chunks"`
+}
+
+// JSONChunk is the JSON representation of a VPK file chunk.
+type JSONChunk struct {
+	LoadFlags        uint32 `json:"load_flags"`
+	TextureFlags     uint16 `json:"texture_flags"`
+	Offset           uint64 `json:"offset"`
+	CompressedSize   uint64 `json:"compressed_size"`
+	UncompressedSize uint64 `json:"uncompressed_size"`
+}
+
+// NewJSONDir converts a dir index to its JSON representation.
+func NewJSONDir(root tf2vpk.ValvePakDir) JSONDir {
+	j := JSONDir{
+		Version:      JSONDumpVersion,
+		Magic:        root.Magic,
+		MajorVersion: root.MajorVersion,
+		MinorVersion: root.MinorVersion,
+		Files:        make([]JSONFile, len(root.File)),
+	}
+	for i, f := range root.File {
+		jf := JSONFile{
+			Path:         f.Path,
+			CRC32:        f.CRC32,
+			PreloadBytes: f.PreloadBytes,
+			Preload:      f.Preload,
+			Index:        uint16(f.Index),
+			Size:         uint64(len(f.Preload)),
+			Chunks:       make([]JSONChunk, len(f.Chunk)),
+		}
+		for k, c := range f.Chunk {
+			jf.Size += c.UncompressedSize
+			jf.CompressedSize += c.CompressedSize
+			jf.Chunks[k] = JSONChunk{
+				LoadFlags:        c.LoadFlags,
+				TextureFlags:     c.TextureFlags,
+				Offset:           c.Offset,
+				CompressedSize:   c.CompressedSize,
+				UncompressedSize: c.UncompressedSize,
+			}
+		}
+		j.Files[i] = jf
+	}
+	return j
+}
+
+// ValvePakDir converts the JSON representation back to a dir index. The
+// computed sizes are ignored.
+func (j JSONDir) ValvePakDir() (tf2vpk.ValvePakDir, error) {
+	if j.Version != JSONDumpVersion {
+		return tf2vpk.ValvePakDir{}, fmt.Errorf("unsupported json dump version %d", j.Version)
+	}
+	root := tf2vpk.ValvePakDir{
+		Magic:        j.Magic,
+		MajorVersion: j.MajorVersion,
+		MinorVersion: j.MinorVersion,
+		File:         make([]tf2vpk.ValvePakFile, len(j.Files)),
+	}
+	for i, jf := range j.Files {
+		f := tf2vpk.ValvePakFile{
+			Path:         jf.Path,
+			CRC32:        jf.CRC32,
+			PreloadBytes: jf.PreloadBytes,
+			Preload:      jf.Preload,
+			Index:        tf2vpk.ValvePakIndex(jf.Index),
+			Chunk:        make([]tf2vpk.ValvePakChunk, len(jf.Chunks)),
+		}
+		if !f.Index.IsValid() {
+			return tf2vpk.ValvePakDir{}, fmt.Errorf("file %q: invalid block index %d", jf.Path, jf.Index)
+		}
+		for k, c := range jf.Chunks {
+			f.Chunk[k] = tf2vpk.ValvePakChunk{
+				LoadFlags:        c.LoadFlags,
+				TextureFlags:     c.TextureFlags,
+				Offset:           c.Offset,
+				CompressedSize:   c.CompressedSize,
+				UncompressedSize: c.UncompressedSize,
+			}
+		}
+		root.File[i] = f
+	}
+	return root, nil
+}
+
+// DumpJSON writes the JSON representation of root to w. If indent is true, the
+// output is indented with two spaces.
+func DumpJSON(w io.Writer, root tf2vpk.ValvePakDir, indent bool) error {
+	e := json.NewEncoder(w)
+	if indent {
+		e.SetIndent("", "  ")
+	}
+	return e.Encode(NewJSONDir(root))
+}
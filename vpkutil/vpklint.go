@@ -0,0 +1,196 @@
+package vpkutil
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// LintFile is a file to be checked by Lint.
+type LintFile struct {
+	Path         string
+	LoadFlags    uint32
+	TextureFlags uint16
+	Size         uint64 // uncompressed
+}
+
+// LintWarning is a potential problem found by Lint.
+type LintWarning struct {
+	Path    string
+	Check   string // case, chars, flags, size, or companion
+	Message string
+}
+
+// String formats the warning as "path: check: message".
+func (w LintWarning) String() string {
+	return w.Path + ": " + w.Check + ": " + w.Message
+}
+
+// LintDir gets the files to lint from a VPK dir index.
+func LintDir(root tf2vpk.ValvePakDir) ([]LintFile, error) {
+	lfs := make([]LintFile, 0, len(root.File))
+	for _, f := range root.File {
+		load, texture, err := f.Flags()
+		if err != nil {
+			return nil, fmt.Errorf("file %q: %w", f.Path, err)
+		}
+		lfs = append(lfs, LintFile{
+			Path:         f.Path,
+			LoadFlags:    uint32(load),
+			TextureFlags: uint16(texture),
+			Size:         f.Size(),
+		})
+	}
+	return lfs, nil
+}
+
+// LintManifest gets the files to lint from a manifest, resolving sources
+// relative to base like Build.
+func LintManifest(m Manifest, base string) ([]LintFile, error) {
+	var lfs []LintFile
+	for i, mf := range m.Files {
+		name := path.Clean(mf.Path)
+		if !fs.ValidPath(name) || name == "." {
+			return nil, fmt.Errorf("file %d: invalid path %q", i, mf.Path)
+		}
+		src := mf.Source
+		if src == "" {
+			src = mf.Path
+		}
+		src = filepath.Join(base, filepath.FromSlash(src))
+
+		load, texture := m.flags(mf)
+		if err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(src, p)
+			if err != nil {
+				return err
+			}
+			lfs = append(lfs, LintFile{
+				Path:         path.Join(name, filepath.ToSlash(rel)),
+				LoadFlags:    load,
+				TextureFlags: texture,
+				Size:         uint64(fi.Size()),
+			})
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("file %d (%s): %w", i, name, err)
+		}
+	}
+	return lfs, nil
+}
+
+// LintManifestFile is like LintManifest, but reads the manifest from a file and
+// resolves sources relative to it.
+func LintManifestFile(name string) ([]LintFile, error) {
+	m, err := ParseManifestFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return LintManifest(m, filepath.Dir(name))
+}
+
+// Lint checks files against the conventions followed by the VPKs shipped with
+// the game, returning warnings sorted by path. Files with an uncompressed size
+// larger than maxSize (if non-zero) are reported.
+//
+// The checks are:
+//   - case: paths are lowercase, and don't differ only by case
+//   - chars: paths only contain printable ASCII without spaces, backslashes, or
+//     characters invalid on Windows, and can be represented in a vpkflags file
+//   - flags: files are visible, only known load flags are set, and texture
+//     flags (and the texture load flags) are only set on (and are set on) VTFs
+//   - size: files are not empty, and are not larger than maxSize
+//   - companion: each VMT has a VTF in the same directory starting with its
+//     name (e.g., foo.vmt and foo_col.vtf), which catches forgotten textures,
+//     but will also warn about materials using textures from other VPKs
+func Lint(files []LintFile, maxSize uint64) []LintWarning {
+	var ws []LintWarning
+	warn := func(p, check, format string, a ...any) {
+		ws = append(ws, LintWarning{p, check, fmt.Sprintf(format, a...)})
+	}
+
+	const knownLoad = tf2vpk.ValvePakLoadVisible | tf2vpk.ValvePakLoadCache | tf2vpk.ValvePakLoadACacheUnk0 | tf2vpk.ValvePakLoadTexture
+
+	var (
+		folded = map[string]string{}
+		vtfs   = map[string][]string{} // [dir]name
+	)
+	for _, f := range files {
+		if name, ok := strings.CutSuffix(f.Path, ".vtf"); ok {
+			dir, name := path.Split(name)
+			vtfs[dir] = append(vtfs[dir], name)
+		}
+	}
+	for _, f := range files {
+		if f.Path != strings.ToLower(f.Path) {
+			warn(f.Path, "case", "path is not lowercase (the game lowercases paths before looking them up)")
+		}
+		if x, ok := folded[strings.ToLower(f.Path)]; ok {
+			warn(f.Path, "case", "path only differs by case from %q", x)
+		} else {
+			folded[strings.ToLower(f.Path)] = f.Path
+		}
+
+		if i := strings.IndexFunc(f.Path, func(r rune) bool {
+			return r > unicode.MaxASCII || !unicode.IsPrint(r) || strings.ContainsRune(`\:*?"<>|`, r)
+		}); i != -1 {
+			warn(f.Path, "chars", "path contains forbidden character %q", []rune(f.Path[i:])[0])
+		} else if err := isLiteralPathValidForGlobRuleGlob(f.Path); err != nil {
+			warn(f.Path, "chars", "%v (cannot be represented in %s)", err, VPKFlagsFilename)
+		}
+
+		load, texture := tf2vpk.ValvePakLoadFlags(f.LoadFlags), tf2vpk.ValvePakTextureFlags(f.TextureFlags)
+		vtf := strings.HasSuffix(strings.ToLower(f.Path), ".vtf")
+		if !load.IsVisible() {
+			warn(f.Path, "flags", "load flags 0x%08X do not include VISIBLE (the game will not see the file)", f.LoadFlags)
+		}
+		if x := load &^ knownLoad; x != 0 {
+			warn(f.Path, "flags", "unknown load flags set (%s)", x)
+		}
+		if !vtf && texture != 0 {
+			warn(f.Path, "flags", "texture flags 0x%04X set on a non-vtf file", f.TextureFlags)
+		}
+		if !vtf && load.IsTexture() {
+			warn(f.Path, "flags", "texture load flags (%s) set on a non-vtf file", load&tf2vpk.ValvePakLoadTexture)
+		}
+		if vtf && !load.IsTexture() {
+			warn(f.Path, "flags", "vtf file does not have any texture load flags")
+		}
+
+		if f.Size == 0 {
+			warn(f.Path, "size", "file is empty")
+		} else if maxSize != 0 && f.Size > maxSize {
+			warn(f.Path, "size", "uncompressed size %d is larger than %d", f.Size, maxSize)
+		}
+
+		if name, ok := strings.CutSuffix(f.Path, ".vmt"); ok {
+			dir, name := path.Split(name)
+			if !slices.ContainsFunc(vtfs[dir], func(x string) bool {
+				return strings.HasPrefix(x, name)
+			}) {
+				warn(f.Path, "companion", "no vtf named like the material in the same directory")
+			}
+		}
+	}
+	sort.SliceStable(ws, func(i, j int) bool {
+		return ws[i].Path < ws[j].Path
+	})
+	return ws
+}
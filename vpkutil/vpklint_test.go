@@ -0,0 +1,129 @@
+package vpkutil
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+func TestLint(t *testing.T) {
+	const (
+		load = uint32(tf2vpk.ValvePakLoadVisible | tf2vpk.ValvePakLoadCache)
+		tex  = load | uint32(tf2vpk.ValvePakLoadTextureUnk0)
+	)
+	for _, tc := range []struct {
+		Name    string
+		Files   []LintFile
+		MaxSize uint64
+		Checks  []string // path: check
+	}{
+		{
+			Name: "ok",
+			Files: []LintFile{
+				{"scripts/a.txt", load, 0, 1},
+				{"materials/a.vmt", load, 0, 1},
+				{"materials/a_col.vtf", tex, 8, 1},
+			},
+		},
+		{
+			Name:   "uppercase",
+			Files:  []LintFile{{"scripts/A.txt", load, 0, 1}},
+			Checks: []string{"scripts/A.txt: case"},
+		},
+		{
+			Name: "case collision",
+			Files: []LintFile{
+				{"scripts/a.txt", load, 0, 1},
+				{"Scripts/a.txt", load, 0, 1},
+			},
+			Checks: []string{"Scripts/a.txt: case", "Scripts/a.txt: case"},
+		},
+		{
+			Name:   "forbidden char",
+			Files:  []LintFile{{"scripts/a:b.txt", load, 0, 1}},
+			Checks: []string{"scripts/a:b.txt: chars"},
+		},
+		{
+			Name:   "non-ascii",
+			Files:  []LintFile{{"scripts/ä.txt", load, 0, 1}},
+			Checks: []string{"scripts/ä.txt: chars"},
+		},
+		{
+			Name:   "space",
+			Files:  []LintFile{{"scripts/a b.txt", load, 0, 1}},
+			Checks: []string{"scripts/a b.txt: chars"},
+		},
+		{
+			Name:   "glob char",
+			Files:  []LintFile{{"scripts/[a].txt", load, 0, 1}},
+			Checks: []string{"scripts/[a].txt: chars"},
+		},
+		{
+			Name:   "invisible",
+			Files:  []LintFile{{"scripts/a.txt", uint32(tf2vpk.ValvePakLoadCache), 0, 1}},
+			Checks: []string{"scripts/a.txt: flags"},
+		},
+		{
+			Name:   "unknown load flags",
+			Files:  []LintFile{{"scripts/a.txt", load | 1<<30, 0, 1}},
+			Checks: []string{"scripts/a.txt: flags"},
+		},
+		{
+			Name:   "texture flags on non-vtf",
+			Files:  []LintFile{{"scripts/a.txt", load, 8, 1}},
+			Checks: []string{"scripts/a.txt: flags"},
+		},
+		{
+			Name:   "texture load flags on non-vtf",
+			Files:  []LintFile{{"scripts/a.txt", tex, 0, 1}},
+			Checks: []string{"scripts/a.txt: flags"},
+		},
+		{
+			Name:   "vtf without texture load flags",
+			Files:  []LintFile{{"materials/a.vtf", load, 8, 1}},
+			Checks: []string{"materials/a.vtf: flags"},
+		},
+		{
+			Name:   "empty",
+			Files:  []LintFile{{"scripts/a.txt", load, 0, 0}},
+			Checks: []string{"scripts/a.txt: size"},
+		},
+		{
+			Name:    "too large",
+			Files:   []LintFile{{"scripts/a.txt", load, 0, 101}},
+			MaxSize: 100,
+			Checks:  []string{"scripts/a.txt: size"},
+		},
+		{
+			Name:    "not too large",
+			Files:   []LintFile{{"scripts/a.txt", load, 0, 100}},
+			MaxSize: 100,
+		},
+		{
+			Name: "missing companion",
+			Files: []LintFile{
+				{"materials/a.vmt", load, 0, 1},
+				{"materials/b_col.vtf", tex, 8, 1},
+				{"materials/x/a_col.vtf", tex, 8, 1},
+			},
+			Checks: []string{"materials/a.vmt: companion"},
+		},
+		{
+			Name: "sorted",
+			Files: []LintFile{
+				{"b.txt", load, 0, 0},
+				{"A.txt", load, 0, 1},
+			},
+			Checks: []string{"A.txt: case", "b.txt: size"},
+		},
+	} {
+		var act []string
+		for _, w := range Lint(tc.Files, tc.MaxSize) {
+			act = append(act, w.Path+": "+w.Check)
+		}
+		if !slices.Equal(act, tc.Checks) {
+			t.Errorf("%s: expected %q, got %q", tc.Name, tc.Checks, act)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package vpkutil
+
+import (
+	"github.com/pg9182/tf2vpk"
+)
+
+// LoadFlagsFilter selects files by their load flags.
+type LoadFlagsFilter struct {
+	All  uint32 // flags which must all be set
+	None uint32 // flags which must not be set
+}
+
+// ServerLoadFlagsFilter skips textures (i.e., files with any of the texture
+// load flags), which a dedicated server doesn't load. Note that the load flags
+// don't otherwise distinguish between client and server content.
+var ServerLoadFlagsFilter = LoadFlagsFilter{None: uint32(tf2vpk.ValvePakLoadTexture)}
+
+// Skip checks if f should be skipped.
+func (l LoadFlagsFilter) Skip(f tf2vpk.ValvePakFile) (bool, error) {
+	if l.All == 0 && l.None == 0 {
+		return false, nil
+	}
+	load, err := f.LoadFlags()
+	if err != nil {
+		return false, err
+	}
+	return load&l.All != l.All || load&l.None != 0, nil
+}
+
+// Merge combines the requirements of both filters.
+func (l LoadFlagsFilter) Merge(o LoadFlagsFilter) LoadFlagsFilter {
+	return LoadFlagsFilter{l.All | o.All, l.None | o.None}
+}
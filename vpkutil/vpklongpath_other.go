@@ -0,0 +1,10 @@
+//go:build !windows
+
+package vpkutil
+
+// LongPath makes p absolute and adds the \\?\ prefix on Windows, so paths under
+// it can be longer than MAX_PATH and can contain reserved names. On other
+// platforms, it returns p as-is.
+func LongPath(p string) (string, error) {
+	return p, nil
+}
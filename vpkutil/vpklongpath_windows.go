@@ -0,0 +1,24 @@
+//go:build windows
+
+package vpkutil
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// LongPath makes p absolute and adds the \\?\ prefix, so paths under it can be
+// longer than MAX_PATH and can contain reserved names.
+func LongPath(p string) (string, error) {
+	if strings.HasPrefix(p, `\\?\`) {
+		return p, nil
+	}
+	a, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(a, `\\`) {
+		return `\\?\UNC\` + a[2:], nil
+	}
+	return `\\?\` + a, nil
+}
@@ -0,0 +1,201 @@
+package vpkutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// ManifestVersion is the current version of the manifest schema.
+const ManifestVersion = 1
+
+// Manifest declaratively describes the contents of a VPK to build.
+//
+//	{
+//	  "version": 1,               // ManifestVersion
+//	  "load_flags": 257,          // optional, default for all files
+//	  "texture_flags": 0,         // optional, default for all files
+//	  "files": [
+//	    {
+//	      "path": "scripts/vscripts/foo.nut",
+//	      "source": "src/foo.nut", // optional, defaults to path
+//	      "load_flags": 257,       // optional
+//	      "texture_flags": 0,      // optional
+//	      "store": false,          // optional, store without compressing
+//	      "block": 0               // optional, block index to write this entry to
+//	    },
+//	    {
+//	      "path": "materials",     // directories are added recursively
+//	      "source": "build/materials"
+//	    }
+//	  ]
+//	}
+//
+// Sources are relative to the directory containing the manifest. Files are
+// added in the order they are listed.
+type Manifest struct {
+	Version      int            `json:"version"`
+	LoadFlags    *uint32        `json:"load_flags,omitempty"`
+	TextureFlags *uint16        `json:"texture_flags,omitempty"`
+	Files        []ManifestFile `json:"files"`
+}
+
+// ManifestFile is a file or directory in a Manifest.
+type ManifestFile struct {
+	Path         string  `json:"path"`
+	Source       string  `json:"source,omitempty"`
+	LoadFlags    *uint32 `json:"load_flags,omitempty"`
+	TextureFlags *uint16 `json:"texture_flags,omitempty"`
+	Store        bool    `json:"store,omitempty"`
+	Block        *uint16 `json:"block,omitempty"`
+}
+
+// ParseManifest reads a manifest, rejecting unknown fields.
+func ParseManifest(r io.Reader) (Manifest, error) {
+	var m Manifest
+	d := json.NewDecoder(r)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&m); err != nil {
+		return m, err
+	}
+	if m.Version != ManifestVersion {
+		return m, fmt.Errorf("unsupported manifest version %d", m.Version)
+	}
+	return m, nil
+}
+
+// ParseManifestFile is like ParseManifest, but reads from a file.
+func ParseManifestFile(name string) (Manifest, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer f.Close()
+	return ParseManifest(f)
+}
+
+// flags gets the flags for mf, falling back to the manifest defaults, then the
+// default vpkflags rule.
+func (m Manifest) flags(mf ManifestFile) (load uint32, texture uint16) {
+	var defaults VPKFlags
+	defaults.AddDefault()
+	load, texture = defaults.Match("/")
+	if m.LoadFlags != nil {
+		load = *m.LoadFlags
+	}
+	if m.TextureFlags != nil {
+		texture = *m.TextureFlags
+	}
+	if mf.LoadFlags != nil {
+		load = *mf.LoadFlags
+	}
+	if mf.TextureFlags != nil {
+		texture = *mf.TextureFlags
+	}
+	return
+}
+
+// Build adds the files described by m to w, resolving sources relative to
+// base. If fn is not nil, it is called before adding each file. The
+// compression policy and current block of w are restored afterwards, and the
+// store and block settings of each entry only apply to its own files.
+func (m Manifest) Build(w *tf2vpk.Writer, base string, fn func(name, source string)) error {
+	policy := w.Compression
+	defer func() {
+		w.Compression = policy
+	}()
+
+	// the block only applies to the files it is specified for, so return to
+	// the one the writer was on before afterwards
+	var (
+		pinned bool
+		block  tf2vpk.ValvePakIndex
+	)
+
+	for i, mf := range m.Files {
+		name := path.Clean(mf.Path)
+		if !fs.ValidPath(name) || name == "." {
+			return fmt.Errorf("file %d: invalid path %q", i, mf.Path)
+		}
+		src := mf.Source
+		if src == "" {
+			src = mf.Path
+		}
+		src = filepath.Join(base, filepath.FromSlash(src))
+
+		load, texture := m.flags(mf)
+		if mf.Store {
+			w.Compression = func(string) tf2vpk.CompressionMode {
+				return tf2vpk.CompressionStore
+			}
+		} else {
+			w.Compression = policy
+		}
+		if mf.Block != nil {
+			if !pinned {
+				if err := w.Flush(); err != nil {
+					return fmt.Errorf("file %d (%s): %w", i, name, err)
+				}
+				pinned, block = true, w.Block()
+			}
+			if err := w.SetBlock(tf2vpk.ValvePakIndex(*mf.Block)); err != nil {
+				return fmt.Errorf("file %d (%s): %w", i, name, err)
+			}
+		} else if pinned {
+			if err := w.SetBlock(block); err != nil {
+				return fmt.Errorf("file %d (%s): %w", i, name, err)
+			}
+			pinned = false
+		}
+
+		add := func(name, src string) error {
+			if fn != nil {
+				fn(name, src)
+			}
+			f, err := os.Open(src)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return w.Add(name, load, texture, f)
+		}
+
+		fi, err := os.Stat(src)
+		if err != nil {
+			return fmt.Errorf("file %d (%s): %w", i, name, err)
+		}
+		if !fi.IsDir() {
+			if err := add(name, src); err != nil {
+				return fmt.Errorf("file %d (%s): %w", i, name, err)
+			}
+			continue
+		}
+		if err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(src, p)
+			if err != nil {
+				return err
+			}
+			return add(path.Join(name, filepath.ToSlash(rel)), p)
+		}); err != nil {
+			return fmt.Errorf("file %d (%s): %w", i, name, err)
+		}
+	}
+	if pinned {
+		if err := w.SetBlock(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
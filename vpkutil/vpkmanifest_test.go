@@ -0,0 +1,87 @@
+package vpkutil
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+func TestManifestBuild(t *testing.T) {
+	base := t.TempDir()
+	for name, data := range map[string]string{
+		"a.txt": strings.Repeat("a", 4096),
+		"b.txt": strings.Repeat("b", 4096),
+		"c.txt": strings.Repeat("c", 4096),
+	} {
+		if err := os.WriteFile(filepath.Join(base, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := ParseManifest(strings.NewReader(`{
+		"version": 1,
+		"files": [
+			{"path": "a.txt"},
+			{"path": "b.txt", "store": true, "block": 2},
+			{"path": "c.txt"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("parse manifest: %v", err)
+	}
+
+	vpk := tf2vpk.ValvePakRef{Path: t.TempDir(), Prefix: "english", Name: "test"}
+	w := tf2vpk.NewWriter(vpk)
+	if err := m.Build(w, base, nil); err != nil {
+		w.Abort()
+		t.Fatalf("build: %v", err)
+	}
+	if w.Compression != nil {
+		t.Errorf("compression policy not restored")
+	}
+	if w.Block() != 0 {
+		t.Errorf("block not restored")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+
+	r, err := tf2vpk.NewReader(vpk)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer r.Close()
+
+	// chunks are stored if the codec can't compress them (e.g., with the
+	// tf2vpk_nolzham build tag)
+	_, err = tf2vpk.CurrentCodec().Compress(make([]byte, 4096), []byte(strings.Repeat("a", 4096)))
+	compress := err == nil
+
+	for _, x := range []struct {
+		Path   string
+		Index  tf2vpk.ValvePakIndex
+		Stored bool
+	}{
+		{"a.txt", 0, false},
+		{"b.txt", 2, true},
+		{"c.txt", 0, false},
+	} {
+		i := slices.IndexFunc(r.Root.File, func(f tf2vpk.ValvePakFile) bool {
+			return f.Path == x.Path
+		})
+		if i == -1 {
+			t.Fatalf("%q: not found", x.Path)
+		}
+		f := r.Root.File[i]
+		if f.Index != x.Index {
+			t.Errorf("%q: expected block %s, got %s", x.Path, x.Index, f.Index)
+		}
+		if stored := f.Chunk[0].CompressedSize == f.Chunk[0].UncompressedSize; stored != (x.Stored || !compress) {
+			t.Errorf("%q: expected stored=%t, got %t", x.Path, x.Stored || !compress, stored)
+		}
+	}
+}
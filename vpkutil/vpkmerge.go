@@ -0,0 +1,93 @@
+package vpkutil
+
+import (
+	"fmt"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// MergeConflict determines how files present in more than one VPK are handled
+// when merging.
+type MergeConflict int
+
+const (
+	MergeFirstWins MergeConflict = iota // use the file from the first VPK containing it
+	MergeLastWins                       // use the file from the last VPK containing it
+	MergeError                          // fail if a file is in more than one VPK
+)
+
+// ParseMergeConflict parses a MergeConflict from its string form.
+func ParseMergeConflict(s string) (MergeConflict, error) {
+	switch s {
+	case "first", "first-wins":
+		return MergeFirstWins, nil
+	case "last", "last-wins":
+		return MergeLastWins, nil
+	case "error":
+		return MergeError, nil
+	}
+	return 0, fmt.Errorf("unknown merge conflict policy %q (expected first, last, or error)", s)
+}
+
+func (c MergeConflict) String() string {
+	switch c {
+	case MergeFirstWins:
+		return "first"
+	case MergeLastWins:
+		return "last"
+	case MergeError:
+		return "error"
+	}
+	return fmt.Sprintf("MergeConflict(%d)", int(c))
+}
+
+// Merge copies the files from each of rs into w without recompressing them,
+// resolving files present in more than one VPK according to policy. If skip is
+// not nil, files it returns true for are not copied.
+func Merge(w *tf2vpk.Writer, policy MergeConflict, skip func(tf2vpk.ValvePakFile) (bool, error), rs ...*tf2vpk.Reader) error {
+	winner := map[string]int{}
+	for i, r := range rs {
+		for _, f := range r.Root.File {
+			if skip != nil {
+				if s, err := skip(f); err != nil {
+					return err
+				} else if s {
+					continue
+				}
+			}
+			if j, ok := winner[f.Path]; ok {
+				switch policy {
+				case MergeFirstWins:
+					continue
+				case MergeLastWins:
+				case MergeError:
+					return fmt.Errorf("merge %q: file exists in vpk %d and %d", f.Path, j, i)
+				default:
+					return fmt.Errorf("merge %q: invalid conflict policy %s", f.Path, policy)
+				}
+			}
+			winner[f.Path] = i
+		}
+	}
+	for i, r := range rs {
+		for _, f := range r.Root.File {
+			if j, ok := winner[f.Path]; !ok || j != i {
+				continue
+			}
+			b, err := r.OpenBlockRaw(f.Index)
+			if err != nil {
+				return fmt.Errorf("merge %q from vpk %d: %w", f.Path, i, err)
+			}
+			if err := w.AddRaw(f, b); err != nil {
+				return fmt.Errorf("merge %q from vpk %d: %w", f.Path, i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Copy copies the files from r into w without recompressing them. If skip is
+// not nil, files it returns true for are not copied.
+func Copy(w *tf2vpk.Writer, skip func(tf2vpk.ValvePakFile) (bool, error), r *tf2vpk.Reader) error {
+	return Merge(w, MergeError, skip, r)
+}
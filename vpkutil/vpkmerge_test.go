@@ -0,0 +1,211 @@
+package vpkutil
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// openTestVPKs writes a VPK (named srca, srcb, etc) for each of files in dir,
+// returning readers for them.
+func openTestVPKs(t *testing.T, dir string, files ...map[string]string) []*tf2vpk.Reader {
+	t.Helper()
+	var rs []*tf2vpk.Reader
+	for i, fs := range files {
+		vpk := tf2vpk.ValvePakRef{Path: dir, Prefix: "english", Name: "src" + string(rune('a'+i))}
+		writeTestVPK(t, vpk, fs)
+		r, err := tf2vpk.NewReader(vpk)
+		if err != nil {
+			t.Fatalf("read vpk: %v", err)
+		}
+		t.Cleanup(func() { r.Close() })
+		rs = append(rs, r)
+	}
+	return rs
+}
+
+// readRawChunks reads the raw data of all chunks of the file at name in r.
+func readRawChunks(t *testing.T, r *tf2vpk.Reader, name string) []byte {
+	t.Helper()
+	for _, f := range r.Root.File {
+		if f.Path != name {
+			continue
+		}
+		var buf bytes.Buffer
+		for _, c := range f.Chunk {
+			cr, err := r.OpenChunkRaw(f, c)
+			if err != nil {
+				t.Fatalf("read chunk of %q: %v", name, err)
+			}
+			if _, err := io.Copy(&buf, cr); err != nil {
+				t.Fatalf("read chunk of %q: %v", name, err)
+			}
+		}
+		return buf.Bytes()
+	}
+	t.Fatalf("file %q not found", name)
+	return nil
+}
+
+func TestMerge(t *testing.T) {
+	dir := t.TempDir()
+	rs := openTestVPKs(t, dir, map[string]string{
+		"a.txt":      strings.Repeat("a", 1000),
+		"shared.txt": "first",
+	}, map[string]string{
+		"b.txt":      strings.Repeat("b", 1000),
+		"shared.txt": "last",
+	})
+
+	for _, tc := range []struct {
+		Policy MergeConflict
+		Shared string
+	}{
+		{MergeFirstWins, "first"},
+		{MergeLastWins, "last"},
+		{MergeError, ""},
+	} {
+		t.Run(tc.Policy.String(), func(t *testing.T) {
+			out := tf2vpk.ValvePakRef{Path: filepath.Join(dir, tc.Policy.String()), Prefix: "english", Name: "out"}
+			if err := os.Mkdir(out.Path, 0777); err != nil {
+				t.Fatal(err)
+			}
+			w := tf2vpk.NewWriter(out)
+			err := Merge(w, tc.Policy, nil, rs...)
+			if tc.Shared == "" {
+				if err == nil || !strings.Contains(err.Error(), "shared.txt") {
+					t.Errorf("expected conflict error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("merge: %v", err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("write vpk: %v", err)
+			}
+			checkTestVPK(t, out, map[string]string{
+				"a.txt":      strings.Repeat("a", 1000),
+				"b.txt":      strings.Repeat("b", 1000),
+				"shared.txt": tc.Shared,
+			})
+		})
+	}
+}
+
+func TestMergeSkip(t *testing.T) {
+	dir := t.TempDir()
+	rs := openTestVPKs(t, dir, map[string]string{
+		"a.txt":      "a",
+		"shared.txt": "first",
+	}, map[string]string{
+		"b.txt":      "b",
+		"shared.txt": "last",
+	})
+
+	// skipped files don't conflict
+	out := tf2vpk.ValvePakRef{Path: dir, Prefix: "english", Name: "out"}
+	w := tf2vpk.NewWriter(out)
+	var skipped bool
+	if err := Merge(w, MergeError, func(f tf2vpk.ValvePakFile) (bool, error) {
+		if f.Path == "shared.txt" && !skipped {
+			skipped = true
+			return true, nil
+		}
+		return false, nil
+	}, rs...); err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+	checkTestVPK(t, out, map[string]string{
+		"a.txt":      "a",
+		"b.txt":      "b",
+		"shared.txt": "last",
+	})
+
+	errSkip := errors.New("skip error")
+	w = tf2vpk.NewWriter(tf2vpk.ValvePakRef{Path: dir, Prefix: "english", Name: "err"})
+	if err := Copy(w, func(f tf2vpk.ValvePakFile) (bool, error) {
+		return false, errSkip
+	}, rs[0]); !errors.Is(err, errSkip) {
+		t.Errorf("expected skip error, got %v", err)
+	}
+}
+
+func TestCopyRaw(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.txt":   strings.Repeat("0123456789", 1000),
+		"b/c.txt": strings.Repeat("0123456789", 1000),
+		"d.txt":   strings.Repeat("d", 1000),
+	}
+	rs := openTestVPKs(t, dir, files, map[string]string{
+		"e.txt": strings.Repeat("e", 1000),
+	})
+
+	out := tf2vpk.ValvePakRef{Path: dir, Prefix: "english", Name: "out"}
+	w := tf2vpk.NewWriter(out)
+	if err := Copy(w, nil, rs[0]); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	// the chunks of the second vpk start at the same offsets as the first, but
+	// must not be reused for it
+	if err := Copy(w, nil, rs[1]); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+	files["e.txt"] = strings.Repeat("e", 1000)
+	checkTestVPK(t, out, files)
+
+	r, err := tf2vpk.NewReader(out)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer r.Close()
+
+	// the chunks are copied as-is
+	for i, src := range rs {
+		for _, f := range src.Root.File {
+			if exp, act := readRawChunks(t, src, f.Path), readRawChunks(t, r, f.Path); !bytes.Equal(exp, act) {
+				t.Errorf("vpk %d: %q: raw chunks differ", i, f.Path)
+			}
+		}
+	}
+
+	// chunks shared by files in the same vpk are only written once
+	var ac, cc []tf2vpk.ValvePakChunk
+	for _, f := range r.Root.File {
+		switch f.Path {
+		case "a.txt":
+			ac = f.Chunk
+		case "b/c.txt":
+			cc = f.Chunk
+		}
+	}
+	if len(ac) == 0 || len(ac) != len(cc) || ac[0].Offset != cc[0].Offset {
+		t.Errorf("expected a.txt and b/c.txt to share chunks, got %+v and %+v", ac, cc)
+	}
+	var size int64
+	for _, name := range []string{"srca", "srcb"} {
+		fi, err := os.Stat(tf2vpk.ValvePakRef{Path: dir, Prefix: "english", Name: name}.Resolve(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += fi.Size()
+	}
+	if fi, err := os.Stat(out.Resolve(0)); err != nil {
+		t.Fatal(err)
+	} else if fi.Size() != size {
+		t.Errorf("expected block size %d, got %d", size, fi.Size())
+	}
+}
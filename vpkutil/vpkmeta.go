@@ -0,0 +1,214 @@
+package vpkutil
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// VPKMetaFilename is the name of the vpkmeta file. It should be at the root of
+// the folder to be packed.
+const VPKMetaFilename = ".vpkmeta"
+
+// VPKMeta records the exact flags and chunking of each file in a VPK, so an
+// unpacked VPK can be repacked without losing any metadata.
+//
+// Each line contains the load flags and texture flags (in binary, like
+// VPKFlags), a comma-separated list of the uncompressed chunk sizes (suffixed
+// with s if the chunk is stored uncompressed), and the file path, which extends
+// to the end of the line.
+type VPKMeta struct {
+	files map[string][]tf2vpk.WriterChunk
+	order []string
+}
+
+// Generate replaces the contents of v with the metadata for the files in root.
+func (v *VPKMeta) Generate(root tf2vpk.ValvePakDir) error {
+	v.files = make(map[string][]tf2vpk.WriterChunk, len(root.File))
+	v.order = v.order[:0]
+	for _, file := range root.File {
+		if strings.ContainsAny(file.Path, "\n\r") {
+			return fmt.Errorf("entry %q: path contains newlines or carriage returns", file.Path)
+		}
+		if _, err := file.LoadFlags(); err != nil {
+			return fmt.Errorf("entry %q: %w", file.Path, err)
+		}
+		if _, err := file.TextureFlags(); err != nil {
+			return fmt.Errorf("entry %q: %w", file.Path, err)
+		}
+		v.files[file.Path] = fileChunks(file)
+		v.order = append(v.order, file.Path)
+	}
+	return nil
+}
+
+// Chunks returns the chunks for the provided path, if it exists.
+func (v VPKMeta) Chunks(path string) ([]tf2vpk.WriterChunk, bool) {
+	cs, ok := v.files[path]
+	return cs, ok
+}
+
+// Size returns the total uncompressed size of the chunks.
+func (v VPKMeta) Size(path string) (uint64, bool) {
+	cs, ok := v.files[path]
+	if !ok {
+		return 0, false
+	}
+	var n uint64
+	for _, c := range cs {
+		n += c.Size
+	}
+	return n, true
+}
+
+// String returns a string which can later be parsed by Parse.
+func (v VPKMeta) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %-32s %-16s %s %s\n", "load flags", "texture flags", "chunk sizes (s = stored)", "path")
+	fmt.Fprintf(&b, "# generated for lossless repacking; files which are missing or changed in size use the vpkflags instead\n")
+	for _, path := range v.order {
+		cs := v.files[path]
+		fmt.Fprintf(&b, "%032b %016b ", cs[0].LoadFlags, cs[0].TextureFlags)
+		b.WriteString(formatChunkSizes(cs))
+		b.WriteByte(' ')
+		b.WriteString(path)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// Parse parses a vpkmeta string, replacing any existing contents.
+func (v *VPKMeta) Parse(s string) error {
+	var (
+		files  = map[string][]tf2vpk.WriterChunk{}
+		order  []string
+		lineNo int
+	)
+	sc := bufio.NewScanner(strings.NewReader(s))
+	for sc.Scan() {
+		line := sc.Text()
+		lineNo++
+
+		// skip comments and empty lines (paths may contain #, so we can't
+		// strip trailing comments)
+		if t := strings.TrimSpace(line); t == "" || strings.HasPrefix(t, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(strings.TrimLeft(line, " \t"), " ", 4)
+		if len(fields) != 4 || fields[3] == "" {
+			return fmt.Errorf("line %d: expected 4 fields (load_flags texture_flags chunks path)", lineNo)
+		}
+
+		load, err := strconv.ParseUint(fields[0], 2, 32)
+		if err != nil {
+			return fmt.Errorf("line %d: parse load flags binary %q: %w", lineNo, fields[0], err)
+		}
+		texture, err := strconv.ParseUint(fields[1], 2, 16)
+		if err != nil {
+			return fmt.Errorf("line %d: parse texture flags binary %q: %w", lineNo, fields[1], err)
+		}
+
+		cs, err := parseChunkSizes(fields[2], uint32(load), uint16(texture))
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNo, err)
+		}
+
+		path := fields[3]
+		if _, ok := files[path]; ok {
+			return fmt.Errorf("line %d: duplicate path %q", lineNo, path)
+		}
+		files[path] = cs
+		order = append(order, path)
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	v.files, v.order = files, order
+	return nil
+}
+
+// fileChunks returns the chunks for f. Since AddChunks can't create preload
+// data, the preload is returned as a separate stored chunk at the start so the
+// chunk sizes still add up to the size of the file.
+func fileChunks(f tf2vpk.ValvePakFile) []tf2vpk.WriterChunk {
+	cs := make([]tf2vpk.WriterChunk, 0, len(f.Chunk)+1)
+	if len(f.Preload) != 0 && len(f.Chunk) != 0 {
+		cs = append(cs, tf2vpk.WriterChunk{
+			LoadFlags:    f.Chunk[0].LoadFlags,
+			TextureFlags: f.Chunk[0].TextureFlags,
+			Size:         uint64(len(f.Preload)),
+			Store:        true,
+		})
+	}
+	for _, c := range f.Chunk {
+		cs = append(cs, tf2vpk.WriterChunk{
+			LoadFlags:    c.LoadFlags,
+			TextureFlags: c.TextureFlags,
+			Size:         c.UncompressedSize,
+			Store:        c.CompressedSize == c.UncompressedSize,
+		})
+	}
+	return cs
+}
+
+// formatChunkSizes formats a comma-separated list of the uncompressed chunk
+// sizes, suffixed with s if the chunk is stored uncompressed.
+func formatChunkSizes(cs []tf2vpk.WriterChunk) string {
+	var b strings.Builder
+	for i, c := range cs {
+		if i != 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatUint(c.Size, 10))
+		if c.Store {
+			b.WriteByte('s')
+		}
+	}
+	return b.String()
+}
+
+// parseChunkSizes parses a string created by formatChunkSizes.
+func parseChunkSizes(s string, load uint32, texture uint16) ([]tf2vpk.WriterChunk, error) {
+	var cs []tf2vpk.WriterChunk
+	for _, x := range strings.Split(s, ",") {
+		c := tf2vpk.WriterChunk{
+			LoadFlags:    load,
+			TextureFlags: texture,
+		}
+		var err error
+		x, c.Store = strings.CutSuffix(x, "s")
+		if c.Size, err = strconv.ParseUint(x, 10, 64); err != nil {
+			return nil, fmt.Errorf("parse chunk size %q: %w", x, err)
+		}
+		if c.Size == 0 || c.Size > tf2vpk.ValvePakMaxChunkUncompressedSize {
+			return nil, fmt.Errorf("invalid chunk size %d", c.Size)
+		}
+		cs = append(cs, c)
+	}
+	return cs, nil
+}
+
+// ParseFile is like Parse, but reads from a file.
+func (v *VPKMeta) ParseFile(name string) error {
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	return v.Parse(string(buf))
+}
+
+// ParseFS is like ParseFile, but reads from fsys.
+func (v *VPKMeta) ParseFS(fsys fs.FS, name string) error {
+	buf, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return err
+	}
+	return v.Parse(string(buf))
+}
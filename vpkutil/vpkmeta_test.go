@@ -0,0 +1,65 @@
+package vpkutil
+
+import (
+	"bytes"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+func TestVPKMetaPreload(t *testing.T) {
+	data := strings.Repeat("0123456789", 1000)
+
+	vpk := tf2vpk.ValvePakRef{Path: t.TempDir(), Prefix: "english", Name: "test"}
+	w := tf2vpk.NewWriter(vpk)
+	w.Preload = func(string) int {
+		return 100
+	}
+	if err := w.Add("a.txt", uint32(tf2vpk.ValvePakLoadVisible|tf2vpk.ValvePakLoadCache), 0, strings.NewReader(data)); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+
+	r, err := tf2vpk.NewReader(vpk)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer r.Close()
+
+	var m1, m2 VPKMeta
+	if err := m1.Generate(r.Root); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if err := m2.Parse(m1.String()); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if n, ok := m2.Size("a.txt"); !ok || n != uint64(len(data)) {
+		t.Fatalf("expected size %d, got %d", len(data), n)
+	}
+	cs, _ := m2.Chunks("a.txt")
+
+	out := tf2vpk.ValvePakRef{Path: t.TempDir(), Prefix: "english", Name: "test"}
+	ow := tf2vpk.NewWriter(out)
+	if err := ow.AddChunks("a.txt", cs, strings.NewReader(data)); err != nil {
+		t.Fatalf("add chunks: %v", err)
+	}
+	if err := ow.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+
+	or, err := tf2vpk.NewReader(out)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer or.Close()
+	if b, err := fs.ReadFile(or, "a.txt"); err != nil || !bytes.Equal(b, []byte(data)) {
+		t.Errorf("incorrect contents after repacking (err: %v)", err)
+	}
+	if lf, _ := or.Root.File[0].LoadFlags(); lf != uint32(tf2vpk.ValvePakLoadVisible|tf2vpk.ValvePakLoadCache) {
+		t.Errorf("load flags not preserved")
+	}
+}
@@ -0,0 +1,318 @@
+package vpkutil
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// OptimizeAnalysis contains the chunk hashes of a VPK, as computed by
+// AnalyzeOptimize. It is used to create an OptimizePlan.
+type OptimizeAnalysis struct {
+	r       *tf2vpk.Reader
+	file    []tf2vpk.ValvePakFile // included files
+	exclude []string
+	hash    map[optimChunk][sha256.Size]byte
+}
+
+// optimChunk identifies a range of raw chunk data in a block.
+type optimChunk struct {
+	Index  tf2vpk.ValvePakIndex
+	Offset uint64
+	Size   uint64
+}
+
+// AnalyzeOptimize reads the raw (i.e., still compressed) data of every chunk
+// referenced by the files in r which skip (if not nil) doesn't return true for,
+// hashing it so identical chunks can be found. Chunks shared by multiple files
+// are only read once. Nothing is decompressed.
+func AnalyzeOptimize(r *tf2vpk.Reader, skip func(tf2vpk.ValvePakFile) (bool, error)) (*OptimizeAnalysis, error) {
+	a := &OptimizeAnalysis{
+		r:    r,
+		hash: map[optimChunk][sha256.Size]byte{},
+	}
+	for _, f := range r.Root.File {
+		if skip != nil {
+			if s, err := skip(f); err != nil {
+				return nil, err
+			} else if s {
+				a.exclude = append(a.exclude, f.Path)
+				continue
+			}
+		}
+		if len(f.Chunk) == 0 {
+			return nil, fmt.Errorf("analyze %q: invalid file: no chunks", f.Path)
+		}
+		b, err := r.OpenBlockRaw(f.Index)
+		if err != nil {
+			return nil, fmt.Errorf("analyze %q: %w", f.Path, err)
+		}
+		for i, c := range f.Chunk {
+			k := optimChunk{f.Index, c.Offset, c.CompressedSize}
+			if _, ok := a.hash[k]; ok {
+				continue
+			}
+			h := sha256.New()
+			if _, err := io.Copy(h, io.NewSectionReader(b, int64(c.Offset), int64(c.CompressedSize))); err != nil {
+				return nil, fmt.Errorf("analyze %q: chunk %d: %w", f.Path, i, err)
+			}
+			var s [sha256.Size]byte
+			h.Sum(s[:0])
+			a.hash[k] = s
+		}
+		a.file = append(a.file, f)
+	}
+	return a, nil
+}
+
+// OptimizePlan describes how a VPK will be rewritten by Execute. It can be
+// inspected (e.g., for a dry run) before executing it.
+//
+// Files are copied in dir index order without recompressing their chunks.
+// Unreferenced data is dropped, chunks shared between files stay shared, and
+// chunks with identical raw contents are only stored once. Which blocks the
+// chunks are written to is decided by the Writer (e.g., MaxBlockSize).
+type OptimizePlan struct {
+	Files    []string // in the order they will be copied
+	Excluded []string
+
+	OldSize uint64 // bytes of block data referenced by the original files (including excluded ones, and unreferenced data between chunks)
+	NewSize uint64 // bytes of chunk data which will be written
+
+	Chunks         int    // distinct chunks which will be written
+	Duplicates     int    // chunks which will be replaced by a reference to an identical chunk
+	DuplicateBytes uint64 // compressed bytes saved by replacing duplicate chunks
+
+	Blocks []OptimizeBlock // the new blocks, in order, if Rebalance was called
+
+	file   []tf2vpk.ValvePakFile // with chunks remapped into virt
+	source []tf2vpk.ValvePakIndex
+	virt   *optimReaderAt
+}
+
+// OptimizeBlock is a block which will be written by a rebalanced OptimizePlan.
+type OptimizeBlock struct {
+	Source []tf2vpk.ValvePakIndex // the original blocks the files were in, sorted
+	Kept   bool                   // whether the files of an original block were kept together as-is
+	Files  int
+	Size   uint64 // bytes of chunk data
+}
+
+// Plan creates a plan for rewriting the VPK.
+func (a *OptimizeAnalysis) Plan() *OptimizePlan {
+	p := &OptimizePlan{
+		Excluded: a.exclude,
+	}
+
+	// lay out the original blocks end-to-end in a single virtual block, so
+	// files can reference chunks in any of them
+	end := map[tf2vpk.ValvePakIndex]uint64{}
+	for _, f := range a.r.Root.File {
+		for _, c := range f.Chunk {
+			end[f.Index] = max(end[f.Index], c.Offset+c.CompressedSize)
+		}
+	}
+	idx := make([]tf2vpk.ValvePakIndex, 0, len(end))
+	for i, n := range end {
+		idx = append(idx, i)
+		p.OldSize += n
+	}
+	sort.Slice(idx, func(i, j int) bool {
+		return idx[i] < idx[j]
+	})
+	p.virt = &optimReaderAt{r: a.r, base: map[tf2vpk.ValvePakIndex]uint64{}}
+	var base uint64
+	for _, i := range idx {
+		p.virt.base[i] = base
+		p.virt.block = append(p.virt.block, optimBlock{i, base, end[i]})
+		base += end[i]
+	}
+
+	// point each chunk at the first chunk with the same contents
+	canonical := map[[sha256.Size]byte]optimChunk{}
+	seen := map[optimChunk]bool{}
+	for _, f := range a.file {
+		nf := f
+		nf.Chunk = make([]tf2vpk.ValvePakChunk, len(f.Chunk))
+		for ci, c := range f.Chunk {
+			k := optimChunk{f.Index, c.Offset, c.CompressedSize}
+			h := a.hash[k]
+			if x, ok := canonical[h]; !ok || x.Size != k.Size {
+				canonical[h] = k
+			} else if x != k {
+				if !seen[k] {
+					p.Duplicates++
+					p.DuplicateBytes += k.Size
+				}
+				k = x
+			}
+			if !seen[k] {
+				p.Chunks++
+				p.NewSize += k.Size
+			}
+			seen[k] = true
+			c.Offset = p.virt.base[k.Index] + k.Offset
+			nf.Chunk[ci] = c
+		}
+		p.file = append(p.file, nf)
+		p.source = append(p.source, f.Index)
+		p.Files = append(p.Files, f.Path)
+	}
+	return p
+}
+
+// Rebalance changes the plan to rearrange the files into blocks of about size
+// bytes (e.g., after many small blocks were appended by incremental updates)
+// instead of letting the Writer decide. The files of original blocks between
+// half and all of size are kept together in their own block. The files of the
+// other blocks are packed into new blocks of at most size bytes, in order, after
+// the kept blocks. Since a file's chunks must be in a single block, a block
+// with a single file may still be larger than size. Chunks shared between
+// files in different new blocks are stored in each of them.
+func (p *OptimizePlan) Rebalance(size uint64) {
+	type group struct {
+		OptimizeBlock
+		file  []int
+		chunk map[uint64]struct{} // offsets in virt
+	}
+	add := func(g *group, fi int) {
+		g.file = append(g.file, fi)
+		g.Files++
+		for _, c := range p.file[fi].Chunk {
+			if _, ok := g.chunk[c.Offset]; !ok {
+				g.chunk[c.Offset] = struct{}{}
+				g.Size += c.CompressedSize
+			}
+		}
+		if i := p.source[fi]; !slices.Contains(g.Source, i) {
+			g.Source = append(g.Source, i)
+			slices.Sort(g.Source)
+		}
+	}
+	fileSize := func(fi int) uint64 {
+		var n uint64
+		for _, c := range p.file[fi].Chunk {
+			n += c.CompressedSize
+		}
+		return n
+	}
+
+	// group the files by their original block
+	orig := map[tf2vpk.ValvePakIndex]*group{}
+	var idx []tf2vpk.ValvePakIndex
+	for fi, i := range p.source {
+		g, ok := orig[i]
+		if !ok {
+			g = &group{chunk: map[uint64]struct{}{}}
+			orig[i] = g
+			idx = append(idx, i)
+		}
+		add(g, fi)
+	}
+	slices.Sort(idx)
+
+	// keep the balanced blocks, and pack the files of the others
+	var (
+		groups []*group
+		pool   []int
+	)
+	for _, i := range idx {
+		if g := orig[i]; g.Size >= size/2 && (g.Size <= size || g.Files == 1) {
+			g.Kept = true
+			groups = append(groups, g)
+		} else {
+			pool = append(pool, g.file...)
+		}
+	}
+	slices.Sort(pool)
+	var cur *group
+	for _, fi := range pool {
+		if cur == nil || (cur.Size != 0 && cur.Size+fileSize(fi) > size) {
+			cur = &group{chunk: map[uint64]struct{}{}}
+			groups = append(groups, cur)
+		}
+		add(cur, fi)
+	}
+
+	// reorder the files
+	file := make([]tf2vpk.ValvePakFile, 0, len(p.file))
+	source := make([]tf2vpk.ValvePakIndex, 0, len(p.file))
+	p.Files = p.Files[:0]
+	p.Blocks = p.Blocks[:0]
+	p.NewSize = 0
+	for _, g := range groups {
+		for _, fi := range g.file {
+			file = append(file, p.file[fi])
+			source = append(source, p.source[fi])
+			p.Files = append(p.Files, p.file[fi].Path)
+		}
+		p.Blocks = append(p.Blocks, g.OptimizeBlock)
+		p.NewSize += g.Size
+	}
+	p.file, p.source = file, source
+}
+
+// Execute copies the files to w as planned. It does not close w. If fn is not
+// nil, it is called after each file is copied. If the plan was rebalanced, each
+// block is started with SetBlock, and w.MaxBlockSize is ignored.
+func (p *OptimizePlan) Execute(w *tf2vpk.Writer, fn func(path string)) error {
+	if p.Blocks != nil {
+		defer func(n uint64) { w.MaxBlockSize = n }(w.MaxBlockSize)
+		w.MaxBlockSize = 0
+	}
+	var block, next int
+	for i, f := range p.file {
+		if p.Blocks != nil && i == next {
+			if err := w.SetBlock(tf2vpk.ValvePakIndex(block)); err != nil {
+				return err
+			}
+			next += p.Blocks[block].Files
+			block++
+		}
+		if err := w.AddRaw(f, p.virt); err != nil {
+			return err
+		}
+		if fn != nil {
+			fn(f.Path)
+		}
+	}
+	return nil
+}
+
+type optimBlock struct {
+	Index tf2vpk.ValvePakIndex
+	Base  uint64
+	Size  uint64
+}
+
+// optimReaderAt reads the blocks of a VPK laid out end-to-end.
+type optimReaderAt struct {
+	r     *tf2vpk.Reader
+	base  map[tf2vpk.ValvePakIndex]uint64
+	block []optimBlock // sorted by Base
+}
+
+func (v *optimReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+	i := sort.Search(len(v.block), func(i int) bool {
+		return v.block[i].Base+v.block[i].Size > uint64(off)
+	})
+	if i == len(v.block) {
+		return 0, io.EOF
+	}
+	b := v.block[i]
+	if uint64(off)+uint64(len(p)) > b.Base+b.Size {
+		return 0, fmt.Errorf("read crosses the end of block %s", b.Index)
+	}
+	x, err := v.r.OpenBlockRaw(b.Index)
+	if err != nil {
+		return 0, err
+	}
+	return x.ReadAt(p, off-int64(b.Base))
+}
@@ -0,0 +1,208 @@
+package vpkutil
+
+import (
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+func TestOptimize(t *testing.T) {
+	files := map[string]string{
+		"a.txt": strings.Repeat("a", 5000),
+		"b.txt": strings.Repeat("b", 5000),
+		"c.txt": strings.Repeat("a", 5000), // duplicate of a.txt in another block
+		"x.txt": strings.Repeat("x", 5000), // excluded
+	}
+
+	vpk := tf2vpk.ValvePakRef{Path: t.TempDir(), Prefix: "english", Name: "test"}
+	w := tf2vpk.NewWriter(vpk)
+	for _, name := range sortedKeys(files) {
+		if name == "c.txt" {
+			if err := w.SetBlock(1); err != nil {
+				t.Fatalf("set block: %v", err)
+			}
+		}
+		if err := w.Add(name, uint32(tf2vpk.ValvePakLoadVisible|tf2vpk.ValvePakLoadCache), 0, strings.NewReader(files[name])); err != nil {
+			t.Fatalf("add %q: %v", name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+
+	r, err := tf2vpk.NewReader(vpk)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer r.Close()
+
+	a, err := AnalyzeOptimize(r, func(f tf2vpk.ValvePakFile) (bool, error) {
+		return f.Path == "x.txt", nil
+	})
+	if err != nil {
+		t.Fatalf("analyze: %v", err)
+	}
+	p := a.Plan()
+	if !slices.Equal(p.Files, []string{"a.txt", "b.txt", "c.txt"}) || !slices.Equal(p.Excluded, []string{"x.txt"}) {
+		t.Errorf("incorrect files %q, excluded %q", p.Files, p.Excluded)
+	}
+	if p.Chunks != 2 || p.Duplicates != 1 || p.DuplicateBytes == 0 || p.NewSize+p.DuplicateBytes >= p.OldSize {
+		t.Errorf("incorrect plan %+v", p)
+	}
+
+	out := tf2vpk.ValvePakRef{Path: t.TempDir(), Prefix: "english", Name: "test"}
+	ow := tf2vpk.NewWriter(out)
+	var copied []string
+	if err := p.Execute(ow, func(path string) {
+		copied = append(copied, path)
+	}); err != nil {
+		ow.Abort()
+		t.Fatalf("execute: %v", err)
+	}
+	if err := ow.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+	if !slices.Equal(copied, p.Files) {
+		t.Errorf("expected %q to be copied, got %q", p.Files, copied)
+	}
+
+	delete(files, "x.txt")
+	checkTestVPK(t, out, files)
+
+	or, err := tf2vpk.NewReader(out)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer or.Close()
+
+	var size uint64
+	chunk := map[string]tf2vpk.ValvePakChunk{}
+	for _, f := range or.Root.File {
+		chunk[f.Path] = f.Chunk[0]
+		size = max(size, f.Chunk[0].Offset+f.Chunk[0].CompressedSize)
+	}
+	if chunk["a.txt"] != chunk["c.txt"] {
+		t.Errorf("duplicate chunk not shared")
+	}
+	if size != p.NewSize {
+		t.Errorf("expected %d bytes of chunk data, got %d", p.NewSize, size)
+	}
+}
+
+func TestOptimizeRebalance(t *testing.T) {
+	const size = 10000
+
+	// random data so the chunk sizes are about the file sizes
+	rng := rand.New(rand.NewSource(1))
+	files := map[string]string{}
+	block := map[string]tf2vpk.ValvePakIndex{}
+	for _, x := range []struct {
+		name  string
+		block tf2vpk.ValvePakIndex
+		size  int
+	}{
+		{"a1.txt", 0, 3000}, // balanced, kept
+		{"a2.txt", 0, 3000},
+		{"b1.txt", 1, 2000}, // small, packed
+		{"c1.txt", 2, 2000}, // small, packed with b1
+		{"c2.txt", 2, 2000},
+		{"d1.txt", 3, 15000}, // oversized single file, kept
+		{"e1.txt", 4, 4500},  // small, doesn't fit with b1/c1/c2
+	} {
+		buf := make([]byte, x.size)
+		rng.Read(buf)
+		files[x.name] = string(buf)
+		block[x.name] = x.block
+	}
+
+	vpk := tf2vpk.ValvePakRef{Path: t.TempDir(), Prefix: "english", Name: "test"}
+	w := tf2vpk.NewWriter(vpk)
+	for _, name := range sortedKeys(files) {
+		if err := w.SetBlock(block[name]); err != nil {
+			t.Fatalf("set block: %v", err)
+		}
+		if err := w.Add(name, uint32(tf2vpk.ValvePakLoadVisible|tf2vpk.ValvePakLoadCache), 0, strings.NewReader(files[name])); err != nil {
+			t.Fatalf("add %q: %v", name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+
+	r, err := tf2vpk.NewReader(vpk)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer r.Close()
+
+	fileSize := map[string]uint64{}
+	for _, f := range r.Root.File {
+		for _, c := range f.Chunk {
+			fileSize[f.Path] += c.CompressedSize
+		}
+	}
+	sum := func(names ...string) (n uint64) {
+		for _, name := range names {
+			n += fileSize[name]
+		}
+		return
+	}
+
+	a, err := AnalyzeOptimize(r, nil)
+	if err != nil {
+		t.Fatalf("analyze: %v", err)
+	}
+	p := a.Plan()
+	p.Rebalance(size)
+
+	if exp := []string{"a1.txt", "a2.txt", "d1.txt", "b1.txt", "c1.txt", "c2.txt", "e1.txt"}; !slices.Equal(p.Files, exp) {
+		t.Errorf("expected files %q, got %q", exp, p.Files)
+	}
+	exp := []OptimizeBlock{
+		{Source: []tf2vpk.ValvePakIndex{0}, Kept: true, Files: 2, Size: sum("a1.txt", "a2.txt")},
+		{Source: []tf2vpk.ValvePakIndex{3}, Kept: true, Files: 1, Size: sum("d1.txt")},
+		{Source: []tf2vpk.ValvePakIndex{1, 2}, Files: 3, Size: sum("b1.txt", "c1.txt", "c2.txt")},
+		{Source: []tf2vpk.ValvePakIndex{4}, Files: 1, Size: sum("e1.txt")},
+	}
+	if !slices.EqualFunc(p.Blocks, exp, func(a, b OptimizeBlock) bool {
+		return slices.Equal(a.Source, b.Source) && a.Kept == b.Kept && a.Files == b.Files && a.Size == b.Size
+	}) {
+		t.Errorf("expected blocks %+v, got %+v", exp, p.Blocks)
+	}
+	if n := sum(p.Files...); p.NewSize != n {
+		t.Errorf("expected new size %d, got %d", n, p.NewSize)
+	}
+
+	out := tf2vpk.ValvePakRef{Path: t.TempDir(), Prefix: "english", Name: "test"}
+	ow := tf2vpk.NewWriter(out)
+	ow.MaxBlockSize = 1000 // ignored
+	if err := p.Execute(ow, nil); err != nil {
+		ow.Abort()
+		t.Fatalf("execute: %v", err)
+	}
+	if err := ow.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+	checkTestVPK(t, out, files)
+
+	or, err := tf2vpk.NewReader(out)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer or.Close()
+
+	expBlock := map[string]tf2vpk.ValvePakIndex{
+		"a1.txt": 0, "a2.txt": 0,
+		"d1.txt": 1,
+		"b1.txt": 2, "c1.txt": 2, "c2.txt": 2,
+		"e1.txt": 3,
+	}
+	for _, f := range or.Root.File {
+		if f.Index != expBlock[f.Path] {
+			t.Errorf("%s: expected block %d, got %d", f.Path, expBlock[f.Path], f.Index)
+		}
+	}
+}
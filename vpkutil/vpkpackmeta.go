@@ -0,0 +1,68 @@
+package vpkutil
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// PackMeta implements [tf2vpk.WriterFSMeta] using the vpkflags, vpkignore, and
+// vpkmeta files at the root of the directory being packed.
+type PackMeta struct {
+	VPKFlags  VPKFlags
+	VPKIgnore VPKIgnore
+	VPKMeta   VPKMeta
+}
+
+var _ tf2vpk.WriterFSMeta = (*PackMeta)(nil)
+
+// ReadPackMeta reads the metadata files from the root of fsys. The vpkflags
+// file is required. If there isn't a vpkignore file, the default ignore rules
+// are used.
+func ReadPackMeta(fsys fs.FS) (*PackMeta, error) {
+	var m PackMeta
+	if err := m.VPKFlags.ParseFS(fsys, VPKFlagsFilename); err != nil {
+		return nil, fmt.Errorf("read vpkflags: %w", err)
+	}
+	if err := m.VPKIgnore.ParseFS(fsys, VPKIgnoreFilename); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("read vpkignore: %w", err)
+		}
+		m.VPKIgnore.AddDefault()
+	}
+	if err := m.VPKMeta.ParseFS(fsys, VPKMetaFilename); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read vpkmeta: %w", err)
+	}
+	return &m, nil
+}
+
+// IsPackMetaFile returns true if name is one of the metadata files at the root
+// of the directory being packed.
+func IsPackMetaFile(name string) bool {
+	switch name {
+	case VPKFlagsFilename, VPKIgnoreFilename, VPKMetaFilename, VPKTimesFilename:
+		return true
+	}
+	return false
+}
+
+// Skip returns true for the metadata files and files matching the vpkignore.
+func (m *PackMeta) Skip(name string) bool {
+	return IsPackMetaFile(name) || m.VPKIgnore.Match(name)
+}
+
+// Flags returns the flags matching the vpkflags.
+func (m *PackMeta) Flags(name string) (loadFlags uint32, textureFlags uint16) {
+	return m.VPKFlags.Match(name)
+}
+
+// Chunks returns the chunks from the vpkmeta if the file hasn't changed in
+// size.
+func (m *PackMeta) Chunks(name string, size uint64) ([]tf2vpk.WriterChunk, bool) {
+	if sz, ok := m.VPKMeta.Size(name); ok && sz == size {
+		return m.VPKMeta.Chunks(name)
+	}
+	return nil, false
+}
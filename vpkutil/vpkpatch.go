@@ -0,0 +1,299 @@
+package vpkutil
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// PatchMagic identifies a VPK patch.
+const PatchMagic = "TF2VPKPT"
+
+// PatchVersion is the current patch format version.
+const PatchVersion uint32 = 1
+
+// Patch format (all integers are little-endian):
+//
+//	magic    [8]byte  PatchMagic
+//	version  uint32   PatchVersion
+//	base     [20]byte SHA-1 of the serialized dir index of the original VPK
+//	dirSize  uint64
+//	dir      [dirSize]byte serialized dir index of the new VPK, with all files
+//	                       in block 0 and the chunk offsets replaced with the
+//	                       index of the op providing the chunk data
+//	opCount  uint64
+//	ops      [opCount]op
+//
+// Each op is one of:
+//
+//	kind     uint8    patchOpCopy
+//	index    uint16   block index in the original VPK
+//	offset   uint64
+//	size     uint64
+//
+//	kind     uint8    patchOpData
+//	size     uint64
+//	data     [size]byte
+//
+// When applying the patch, the chunks from the ops are written sequentially to
+// block 0 of the new VPK.
+const (
+	patchOpCopy uint8 = 1
+	patchOpData uint8 = 2
+)
+
+// PatchStats contains information about a created patch.
+type PatchStats struct {
+	Files      int    // number of files in the new VPK
+	CopyChunks int    // chunks copied from the original VPK
+	CopyBytes  uint64 // bytes copied from the original VPK
+	DataChunks int    // chunks included in the patch
+	DataBytes  uint64 // bytes included in the patch
+}
+
+type patchChunkID struct {
+	Index  tf2vpk.ValvePakIndex
+	Offset uint64
+	Size   uint64
+}
+
+type patchOp struct {
+	Kind  uint8
+	Chunk patchChunkID // in the original VPK for copy ops, in the new one for data ops
+}
+
+// CreatePatch writes a patch transforming the VPK read by from into the one
+// read by to. Chunks are compared by their raw (compressed) contents, so
+// unchanged chunks are referenced instead of being included in the patch.
+func CreatePatch(w io.Writer, from, to *tf2vpk.Reader) (PatchStats, error) {
+	var stats PatchStats
+
+	base, err := DirHash(from.Root)
+	if err != nil {
+		return stats, fmt.Errorf("hash original dir: %w", err)
+	}
+
+	have := map[[sha1.Size]byte]patchChunkID{}
+	for _, f := range from.Root.File {
+		for _, c := range f.Chunk {
+			h, err := hashChunkRaw(from, f, c)
+			if err != nil {
+				return stats, fmt.Errorf("hash original chunk of %q: %w", f.Path, err)
+			}
+			if _, ok := have[h]; !ok {
+				have[h] = patchChunkID{f.Index, c.Offset, c.CompressedSize}
+			}
+		}
+	}
+
+	var (
+		ops   []patchOp
+		opIdx = map[[sha1.Size]byte]uint64{}
+		cidOp = map[patchChunkID]uint64{}
+		dir   = to.Root
+	)
+	dir.File = make([]tf2vpk.ValvePakFile, len(to.Root.File))
+	for i, f := range to.Root.File {
+		nf := f
+		nf.Index = 0
+		nf.Chunk = make([]tf2vpk.ValvePakChunk, len(f.Chunk))
+		for j, c := range f.Chunk {
+			cid := patchChunkID{f.Index, c.Offset, c.CompressedSize}
+			op, ok := cidOp[cid]
+			if !ok {
+				h, err := hashChunkRaw(to, f, c)
+				if err != nil {
+					return stats, fmt.Errorf("hash new chunk of %q: %w", f.Path, err)
+				}
+				if op, ok = opIdx[h]; !ok {
+					op = uint64(len(ops))
+					if src, ok := have[h]; ok {
+						ops = append(ops, patchOp{patchOpCopy, src})
+						stats.CopyChunks++
+						stats.CopyBytes += src.Size
+					} else {
+						ops = append(ops, patchOp{patchOpData, cid})
+						stats.DataChunks++
+						stats.DataBytes += cid.Size
+					}
+					opIdx[h] = op
+				}
+				cidOp[cid] = op
+			}
+			c.Offset = op
+			nf.Chunk[j] = c
+		}
+		dir.File[i] = nf
+	}
+	stats.Files = len(dir.File)
+
+	var db bytes.Buffer
+	if err := dir.Serialize(&db); err != nil {
+		return stats, fmt.Errorf("serialize new dir: %w", err)
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(PatchMagic)
+	binary.Write(bw, binary.LittleEndian, PatchVersion)
+	bw.Write(base[:])
+	binary.Write(bw, binary.LittleEndian, uint64(db.Len()))
+	bw.Write(db.Bytes())
+	binary.Write(bw, binary.LittleEndian, uint64(len(ops)))
+	for i, op := range ops {
+		switch op.Kind {
+		case patchOpCopy:
+			bw.WriteByte(op.Kind)
+			binary.Write(bw, binary.LittleEndian, op.Chunk.Index)
+			binary.Write(bw, binary.LittleEndian, op.Chunk.Offset)
+			binary.Write(bw, binary.LittleEndian, op.Chunk.Size)
+		case patchOpData:
+			bw.WriteByte(op.Kind)
+			binary.Write(bw, binary.LittleEndian, op.Chunk.Size)
+			b, err := to.OpenBlockRaw(op.Chunk.Index)
+			if err != nil {
+				return stats, fmt.Errorf("write op %d: %w", i, err)
+			}
+			if n, err := io.Copy(bw, io.NewSectionReader(b, int64(op.Chunk.Offset), int64(op.Chunk.Size))); err != nil {
+				return stats, fmt.Errorf("write op %d: %w", i, err)
+			} else if uint64(n) != op.Chunk.Size {
+				return stats, fmt.Errorf("write op %d: %w", i, io.ErrUnexpectedEOF)
+			}
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return stats, fmt.Errorf("write patch: %w", err)
+	}
+	return stats, nil
+}
+
+// ApplyPatch writes the VPK resulting from applying patch to the VPK read by
+// from into w. The patch must have been created against the same original dir
+// index. The caller is responsible for verifying the output (e.g., by reading
+// every file to check the CRC32) before replacing anything with it.
+func ApplyPatch(w *tf2vpk.Writer, patch io.Reader, from *tf2vpk.Reader) error {
+	br := bufio.NewReader(patch)
+
+	var hdr struct {
+		Magic   [len(PatchMagic)]byte
+		Version uint32
+		Base    [sha1.Size]byte
+		DirSize uint64
+	}
+	if err := binary.Read(br, binary.LittleEndian, &hdr); err != nil {
+		return fmt.Errorf("read patch header: %w", err)
+	}
+	if string(hdr.Magic[:]) != PatchMagic {
+		return fmt.Errorf("read patch header: not a vpk patch")
+	}
+	if hdr.Version != PatchVersion {
+		return fmt.Errorf("read patch header: unsupported patch version %d", hdr.Version)
+	}
+
+	base, err := DirHash(from.Root)
+	if err != nil {
+		return fmt.Errorf("hash original dir: %w", err)
+	}
+	if base != hdr.Base {
+		return fmt.Errorf("patch was not created for this vpk (dir hash %x, expected %x)", base, hdr.Base)
+	}
+
+	var dir tf2vpk.ValvePakDir
+	if err := dir.Deserialize(io.LimitReader(br, int64(hdr.DirSize))); err != nil {
+		return fmt.Errorf("read patch dir: %w", err)
+	}
+
+	var opCount uint64
+	if err := binary.Read(br, binary.LittleEndian, &opCount); err != nil {
+		return fmt.Errorf("read patch ops: %w", err)
+	}
+	type opOut struct {
+		Index  tf2vpk.ValvePakIndex
+		Offset uint64
+		Size   uint64
+	}
+	var out []opOut
+	for i := uint64(0); i < opCount; i++ {
+		kind, err := br.ReadByte()
+		if err != nil {
+			return fmt.Errorf("read patch op %d: %w", i, err)
+		}
+		var src io.Reader
+		var size uint64
+		switch kind {
+		case patchOpCopy:
+			var c patchChunkID
+			if err := binary.Read(br, binary.LittleEndian, &c); err != nil {
+				return fmt.Errorf("read patch op %d: %w", i, err)
+			}
+			b, err := from.OpenBlockRaw(c.Index)
+			if err != nil {
+				return fmt.Errorf("apply patch op %d: %w", i, err)
+			}
+			src, size = io.NewSectionReader(b, int64(c.Offset), int64(c.Size)), c.Size
+		case patchOpData:
+			if err := binary.Read(br, binary.LittleEndian, &size); err != nil {
+				return fmt.Errorf("read patch op %d: %w", i, err)
+			}
+			src = br
+		default:
+			return fmt.Errorf("read patch op %d: unknown op kind %d", i, kind)
+		}
+		idx, off, err := w.WriteRaw(src, size)
+		if err != nil {
+			return fmt.Errorf("apply patch op %d: %w", i, err)
+		}
+		out = append(out, opOut{idx, off, size})
+	}
+
+	for _, f := range dir.File {
+		nf := f
+		nf.Chunk = make([]tf2vpk.ValvePakChunk, len(f.Chunk))
+		for j, c := range f.Chunk {
+			if c.Offset >= uint64(len(out)) {
+				return fmt.Errorf("apply patch to %q: chunk %d references missing op %d", f.Path, j, c.Offset)
+			}
+			op := out[c.Offset]
+			if op.Size != c.CompressedSize {
+				return fmt.Errorf("apply patch to %q: chunk %d size mismatch (op %d has %d bytes, expected %d)", f.Path, j, c.Offset, op.Size, c.CompressedSize)
+			}
+			nf.Index, c.Offset = op.Index, op.Offset
+			nf.Chunk[j] = c
+		}
+		if err := w.AddFile(nf); err != nil {
+			return fmt.Errorf("apply patch: %w", err)
+		}
+	}
+	return nil
+}
+
+// DirHash returns the SHA-1 of the serialized dir index.
+func DirHash(root tf2vpk.ValvePakDir) ([sha1.Size]byte, error) {
+	h := sha1.New()
+	if err := root.Serialize(h); err != nil {
+		return [sha1.Size]byte{}, err
+	}
+	var s [sha1.Size]byte
+	h.Sum(s[:0])
+	return s, nil
+}
+
+func hashChunkRaw(r *tf2vpk.Reader, f tf2vpk.ValvePakFile, c tf2vpk.ValvePakChunk) ([sha1.Size]byte, error) {
+	var s [sha1.Size]byte
+	cr, err := r.OpenChunkRaw(f, c)
+	if err != nil {
+		return s, err
+	}
+	h := sha1.New()
+	if n, err := io.Copy(h, cr); err != nil {
+		return s, err
+	} else if uint64(n) != c.CompressedSize {
+		return s, io.ErrUnexpectedEOF
+	}
+	h.Sum(s[:0])
+	return s, nil
+}
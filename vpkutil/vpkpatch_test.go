@@ -0,0 +1,136 @@
+package vpkutil
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// writeTestVPK writes a VPK containing files (path to contents).
+func writeTestVPK(t *testing.T, vpk tf2vpk.ValvePakRef, files map[string]string) {
+	t.Helper()
+	w := tf2vpk.NewWriter(vpk)
+	for _, name := range sortedKeys(files) {
+		if err := w.Add(name, uint32(tf2vpk.ValvePakLoadVisible|tf2vpk.ValvePakLoadCache), 0, strings.NewReader(files[name])); err != nil {
+			t.Fatalf("add %q: %v", name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+}
+
+// checkTestVPK checks that the VPK contains exactly files.
+func checkTestVPK(t *testing.T, vpk tf2vpk.ValvePakRef, files map[string]string) {
+	t.Helper()
+	r, err := tf2vpk.NewReader(vpk)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer r.Close()
+
+	if len(r.Root.File) != len(files) {
+		t.Errorf("expected %d files, got %d", len(files), len(r.Root.File))
+	}
+	for name, exp := range files {
+		if buf, err := fs.ReadFile(r, name); err != nil {
+			t.Errorf("read %q: %v", name, err)
+		} else if string(buf) != exp {
+			t.Errorf("read %q: incorrect contents", name)
+		}
+	}
+	for _, f := range r.Root.File {
+		if err := r.VerifyFile(f); err != nil {
+			t.Errorf("verify %q: %v", f.Path, err)
+		}
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	var ks []string
+	for k := range m {
+		ks = append(ks, k)
+	}
+	slices.Sort(ks)
+	return ks
+}
+
+func TestApplyPatch(t *testing.T) {
+	dir := t.TempDir()
+	var (
+		from = tf2vpk.ValvePakRef{Path: filepath.Join(dir, "from"), Prefix: "english", Name: "test"}
+		to   = tf2vpk.ValvePakRef{Path: filepath.Join(dir, "to"), Prefix: "english", Name: "test"}
+		out  = tf2vpk.ValvePakRef{Path: filepath.Join(dir, "out"), Prefix: "english", Name: "test"}
+	)
+	for _, p := range []string{from.Path, to.Path, out.Path} {
+		if err := os.Mkdir(p, 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestVPK(t, from, map[string]string{
+		"a.txt":     strings.Repeat("a", 1000),
+		"b/c.txt":   strings.Repeat("b", 1000),
+		"removed.x": "removed",
+	})
+	exp := map[string]string{
+		"a.txt":   strings.Repeat("a", 1000),
+		"b/c.txt": strings.Repeat("c", 1000),
+		"b/d.txt": strings.Repeat("b", 1000),
+		"new.txt": "new",
+	}
+	writeTestVPK(t, to, exp)
+
+	fr, err := tf2vpk.NewReader(from)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer fr.Close()
+
+	tr, err := tf2vpk.NewReader(to)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer tr.Close()
+
+	var patch bytes.Buffer
+	stats, err := CreatePatch(&patch, fr, tr)
+	if err != nil {
+		t.Fatalf("create patch: %v", err)
+	}
+	if stats.Files != len(exp) {
+		t.Errorf("expected %d files, got %d", len(exp), stats.Files)
+	}
+	if stats.CopyChunks != 2 || stats.DataChunks != 2 {
+		t.Errorf("expected 2 copied and 2 included chunks, got %d and %d", stats.CopyChunks, stats.DataChunks)
+	}
+
+	w := tf2vpk.NewWriter(out)
+	if err := ApplyPatch(w, bytes.NewReader(patch.Bytes()), fr); err != nil {
+		w.Abort()
+		t.Fatalf("apply patch: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+	checkTestVPK(t, out, exp)
+
+	// the patch must only apply to the original vpk
+	w = tf2vpk.NewWriter(tf2vpk.ValvePakRef{Path: t.TempDir(), Prefix: "english", Name: "test"})
+	if err := ApplyPatch(w, bytes.NewReader(patch.Bytes()), tr); err == nil {
+		t.Errorf("expected error when applying the patch to a different vpk")
+	}
+	w.Abort()
+
+	// truncated patches must fail
+	w = tf2vpk.NewWriter(tf2vpk.ValvePakRef{Path: t.TempDir(), Prefix: "english", Name: "test"})
+	if err := ApplyPatch(w, bytes.NewReader(patch.Bytes()[:patch.Len()-1]), fr); err == nil {
+		t.Errorf("expected error when applying a truncated patch")
+	}
+	w.Abort()
+}
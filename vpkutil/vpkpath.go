@@ -0,0 +1,130 @@
+package vpkutil
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SanitizePath converts a VPK file path (which comes from untrusted data) into
+// a relative native path which is safe to join with an output directory.
+//
+// Empty, absolute, and drive-relative paths, paths with empty, ".", or ".."
+// components, and paths containing NUL or backslashes are always rejected. If
+// lax is false, paths which are invalid or special on Windows (reserved device
+// names like CON or COM1, control characters, any of <>:"|?*, and components
+// ending with a dot or space) are also rejected, since they would either fail
+// to extract or be silently mangled there.
+func SanitizePath(name string, lax bool) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("invalid path %q: empty", name)
+	}
+	if strings.ContainsAny(name, "\x00\\") {
+		return "", fmt.Errorf("invalid path %q: contains NUL or backslash", name)
+	}
+	if strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("invalid path %q: absolute", name)
+	}
+	if len(name) >= 2 && name[1] == ':' {
+		return "", fmt.Errorf("invalid path %q: has a drive letter", name)
+	}
+	for _, c := range strings.Split(name, "/") {
+		switch c {
+		case "":
+			return "", fmt.Errorf("invalid path %q: empty path component", name)
+		case ".", "..":
+			return "", fmt.Errorf("invalid path %q: relative path component %q", name, c)
+		}
+		if lax {
+			continue
+		}
+		if i := strings.IndexFunc(c, func(r rune) bool {
+			return r < 0x20 || r == 0x7F || strings.ContainsRune(`<>:"|?*`, r)
+		}); i != -1 {
+			return "", fmt.Errorf("invalid path %q: character %q is not allowed on windows", name, c[i])
+		}
+		if strings.HasSuffix(c, ".") || strings.HasSuffix(c, " ") {
+			return "", fmt.Errorf("invalid path %q: component %q ends with a dot or space", name, c)
+		}
+		if isReservedWindowsName(c) {
+			return "", fmt.Errorf("invalid path %q: component %q is a reserved name on windows", name, c)
+		}
+	}
+	p := filepath.FromSlash(name)
+	if !filepath.IsLocal(p) {
+		return "", fmt.Errorf("invalid path %q: not a local path", name)
+	}
+	return p, nil
+}
+
+// SanitizeJoin is like SanitizePath, but joins the path to dir.
+func SanitizeJoin(dir, name string, lax bool) (string, error) {
+	p, err := SanitizePath(name, lax)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, p), nil
+}
+
+// isReservedWindowsName checks if c is a DOS device name, which Windows treats
+// specially regardless of the extension.
+func isReservedWindowsName(c string) bool {
+	base, _, _ := strings.Cut(c, ".")
+	base = strings.ToUpper(strings.TrimRight(base, " "))
+	switch base {
+	case "CON", "PRN", "AUX", "NUL", "CONIN$", "CONOUT$":
+		return true
+	}
+	if len(base) == 4 && (strings.HasPrefix(base, "COM") || strings.HasPrefix(base, "LPT")) {
+		return base[3] >= '0' && base[3] <= '9'
+	}
+	return false
+}
+
+// EscapePath escapes the parts of the components of a VPK file path which would
+// be rejected by SanitizePath if not lax, using percent-encoding (e.g., CON.txt
+// becomes %43ON.txt, and a?.txt becomes a%3F.txt), so the file can still be
+// extracted on Windows. Percent signs are always escaped so the result can be
+// reversed with UnescapePath. Empty, ".", and ".." components are left as-is.
+func EscapePath(name string) string {
+	cs := strings.Split(name, "/")
+	for i, c := range cs {
+		if c == "" || c == "." || c == ".." {
+			continue
+		}
+		var b strings.Builder
+		for j := 0; j < len(c); j++ {
+			x := c[j]
+			if x < 0x20 || x == 0x7F || strings.IndexByte(`%<>:"|?*\`, x) != -1 ||
+				(j == len(c)-1 && (x == '.' || x == ' ')) ||
+				(j == 0 && isReservedWindowsName(c)) {
+				fmt.Fprintf(&b, "%%%02X", x)
+			} else {
+				b.WriteByte(x)
+			}
+		}
+		cs[i] = b.String()
+	}
+	return strings.Join(cs, "/")
+}
+
+// UnescapePath reverses EscapePath. Percent signs not followed by two hex
+// digits are left as-is.
+func UnescapePath(name string) string {
+	if !strings.Contains(name, "%") {
+		return name
+	}
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '%' && i+2 < len(name) {
+			if x, err := strconv.ParseUint(name[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(x))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(name[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,92 @@
+package vpkutil
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSanitizePath(t *testing.T) {
+	for _, x := range []struct {
+		Path   string
+		Strict bool // valid if not lax
+		Lax    bool // valid if lax
+	}{
+		{"a", true, true},
+		{"a/b/c.txt", true, true},
+		{"scripts/vscripts/foo.nut", true, true},
+		{"a b/c", true, true},
+		{"", false, false},
+		{"/", false, false},
+		{"/etc/passwd", false, false},
+		{"../a", false, false},
+		{"a/../../b", false, false},
+		{"a/..", false, false},
+		{"./a", false, false},
+		{"a//b", false, false},
+		{"a/", false, false},
+		{`a\..\..\b`, false, false},
+		{`\\server\share`, false, false},
+		{"C:/Windows", false, false},
+		{"c:a", false, false},
+		{"a\x00b", false, false},
+		{"con", false, true},
+		{"a/CON.txt", false, true},
+		{"a/nul", false, true},
+		{"a/com1.vtf", false, true},
+		{"a/lpt9", false, true},
+		{"a/com", true, true},
+		{"a/console", true, true},
+		{"a/b:c", false, true},
+		{"a/b?", false, true},
+		{"a/b\x01", false, true},
+		{"a./b", false, true},
+		{"a/b ", false, true},
+	} {
+		for _, lax := range []bool{false, true} {
+			valid := x.Strict
+			if lax {
+				valid = x.Lax
+			}
+			p, err := SanitizePath(x.Path, lax)
+			if valid && err != nil {
+				t.Errorf("%q (lax=%t): unexpected error: %v", x.Path, lax, err)
+			}
+			if !valid && err == nil {
+				t.Errorf("%q (lax=%t): expected error, got %q", x.Path, lax, p)
+			}
+			if err == nil && p != filepath.FromSlash(x.Path) {
+				t.Errorf("%q (lax=%t): expected native path, got %q", x.Path, lax, p)
+			}
+		}
+	}
+}
+
+func TestEscapePath(t *testing.T) {
+	for _, x := range []struct {
+		Path    string
+		Escaped string
+	}{
+		{"a/b/c.txt", "a/b/c.txt"},
+		{"con", "%63on"},
+		{"a/CON.txt/b", "a/%43ON.txt/b"},
+		{"a/console", "a/console"},
+		{"a/b?.txt", "a/b%3F.txt"},
+		{"a/100%", "a/100%25"},
+		{"a./b ", "a%2E/b%20"},
+		{"a/../b", "a/../b"},
+		{`a\b`, "a%5Cb"},
+	} {
+		if e := EscapePath(x.Path); e != x.Escaped {
+			t.Errorf("escape %q: expected %q, got %q", x.Path, x.Escaped, e)
+			continue
+		}
+		if u := UnescapePath(x.Escaped); u != x.Path {
+			t.Errorf("unescape %q: expected %q, got %q", x.Escaped, x.Path, u)
+		}
+		if x.Path != "a/../b" {
+			if _, err := SanitizePath(x.Escaped, false); err != nil {
+				t.Errorf("escape %q: escaped path %q is not valid: %v", x.Path, x.Escaped, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package vpkutil
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// PAX record keys for VPK metadata in tar archives. They use a vendor prefix as
+// recommended by POSIX so other tools will ignore them.
+const (
+	PAXLoadFlags    = "TF2VPK.load_flags"    // hex
+	PAXTextureFlags = "TF2VPK.texture_flags" // hex
+	PAXCRC32        = "TF2VPK.crc32"         // hex
+	PAXIndex        = "TF2VPK.index"         // decimal, 32767 for the dir vpk
+	PAXChunks       = "TF2VPK.chunks"        // chunk sizes, in the same format as vpkmeta
+)
+
+// TarPAXRecords returns the PAX records describing how f was packed. Preload
+// data is recorded as a stored chunk.
+func TarPAXRecords(f tf2vpk.ValvePakFile) (map[string]string, error) {
+	load, err := f.LoadFlags()
+	if err != nil {
+		return nil, fmt.Errorf("entry %q: %w", f.Path, err)
+	}
+	texture, err := f.TextureFlags()
+	if err != nil {
+		return nil, fmt.Errorf("entry %q: %w", f.Path, err)
+	}
+	return map[string]string{
+		PAXLoadFlags:    fmt.Sprintf("%08X", load),
+		PAXTextureFlags: fmt.Sprintf("%04X", texture),
+		PAXCRC32:        fmt.Sprintf("%08X", f.CRC32),
+		PAXIndex:        strconv.FormatUint(uint64(f.Index), 10),
+		PAXChunks:       formatChunkSizes(fileChunks(f)),
+	}, nil
+}
+
+// ParseTarPAXRecords parses the flags and chunks from PAX records created by
+// TarPAXRecords. If the flags are not present, ok is false. Chunks are only
+// returned if present. The CRC32 and index are informational only, so they are
+// not parsed.
+func ParseTarPAXRecords(pax map[string]string) (load uint32, texture uint16, chunks []tf2vpk.WriterChunk, ok bool, err error) {
+	ls, lok := pax[PAXLoadFlags]
+	ts, tok := pax[PAXTextureFlags]
+	if !lok || !tok {
+		return 0, 0, nil, false, nil
+	}
+	l, err := strconv.ParseUint(ls, 16, 32)
+	if err != nil {
+		return 0, 0, nil, false, fmt.Errorf("parse %s %q: %w", PAXLoadFlags, ls, err)
+	}
+	t, err := strconv.ParseUint(ts, 16, 16)
+	if err != nil {
+		return 0, 0, nil, false, fmt.Errorf("parse %s %q: %w", PAXTextureFlags, ts, err)
+	}
+	if cs, ok := pax[PAXChunks]; ok {
+		if chunks, err = parseChunkSizes(cs, uint32(l), uint16(t)); err != nil {
+			return 0, 0, nil, false, fmt.Errorf("parse %s: %w", PAXChunks, err)
+		}
+	}
+	return uint32(l), uint16(t), chunks, true, nil
+}
@@ -0,0 +1,158 @@
+package vpkutil
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/fs"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+func TestTarPAXRecordsPreload(t *testing.T) {
+	files := map[string]string{
+		"a.txt": strings.Repeat("a", 5000),
+		"b.txt": strings.Repeat("b", 5000),
+	}
+
+	vpk := tf2vpk.ValvePakRef{Path: t.TempDir(), Prefix: "english", Name: "test"}
+	w := tf2vpk.NewWriter(vpk)
+	w.ChunkSize = 4096
+	w.Preload = func(name string) int {
+		if name == "a.txt" {
+			return 100
+		}
+		return 0
+	}
+	for _, name := range sortedKeys(files) {
+		if err := w.Add(name, uint32(tf2vpk.ValvePakLoadVisible), 0, strings.NewReader(files[name])); err != nil {
+			t.Fatalf("add %q: %v", name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+
+	r, err := tf2vpk.NewReader(vpk)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer r.Close()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range r.Root.File {
+		pax, err := TarPAXRecords(f)
+		if err != nil {
+			t.Fatalf("%q: pax records: %v", f.Path, err)
+		}
+		b, err := fs.ReadFile(r, f.Path)
+		if err != nil {
+			t.Fatalf("%q: read: %v", f.Path, err)
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: f.Path, Size: int64(len(b)), Mode: 0666, PAXRecords: pax}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := tf2vpk.ValvePakRef{Path: t.TempDir(), Prefix: "english", Name: "test"}
+	ow := tf2vpk.NewWriter(out)
+	if err := AddTar(ow, tar.NewReader(&buf), nil, true, nil, nil); err != nil {
+		ow.Abort()
+		t.Fatalf("add tar: %v", err)
+	}
+	if err := ow.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+	checkTestVPK(t, out, files)
+
+	or, err := tf2vpk.NewReader(out)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer or.Close()
+	for _, f := range or.Root.File {
+		exp := 2
+		if f.Path == "a.txt" {
+			exp = 3 // the preload becomes a separate chunk
+		}
+		if len(f.Chunk) != exp {
+			t.Errorf("%q: expected %d chunks, got %d", f.Path, exp, len(f.Chunk))
+		}
+	}
+}
+
+func TestTarPAXRecords(t *testing.T) {
+	const (
+		load    = uint32(tf2vpk.ValvePakLoadVisible | tf2vpk.ValvePakLoadCache | tf2vpk.ValvePakLoadTextureUnk0)
+		texture = uint16(tf2vpk.ValvePakTextureDefault)
+	)
+	f := tf2vpk.ValvePakFile{
+		Path:  "materials/a.vtf",
+		CRC32: 0x12345678,
+		Index: 5,
+		Chunk: []tf2vpk.ValvePakChunk{
+			{LoadFlags: load, TextureFlags: texture, CompressedSize: 100, UncompressedSize: 4096},
+			{LoadFlags: load, TextureFlags: texture, CompressedSize: 50, UncompressedSize: 50},
+		},
+	}
+	pax, err := TarPAXRecords(f)
+	if err != nil {
+		t.Fatalf("pax records: %v", err)
+	}
+	if pax[PAXCRC32] != "12345678" || pax[PAXIndex] != "5" {
+		t.Errorf("incorrect informational records %q", pax)
+	}
+
+	l, tx, cs, ok, err := ParseTarPAXRecords(pax)
+	if err != nil || !ok {
+		t.Fatalf("parse pax records: %t %v", ok, err)
+	}
+	if l != load || tx != texture {
+		t.Errorf("expected flags %08X %04X, got %08X %04X", load, texture, l, tx)
+	}
+	exp := []tf2vpk.WriterChunk{
+		{LoadFlags: load, TextureFlags: texture, Size: 4096},
+		{LoadFlags: load, TextureFlags: texture, Size: 50, Store: true},
+	}
+	if !slices.Equal(cs, exp) {
+		t.Errorf("expected chunks %+v, got %+v", exp, cs)
+	}
+
+	// chunks are optional
+	delete(pax, PAXChunks)
+	if _, _, cs, ok, err := ParseTarPAXRecords(pax); err != nil || !ok || cs != nil {
+		t.Errorf("without chunks: unexpected result %+v %t %v", cs, ok, err)
+	}
+
+	// other tar files don't have any
+	if _, _, _, ok, err := ParseTarPAXRecords(map[string]string{"path": "a"}); err != nil || ok {
+		t.Errorf("without records: unexpected result %t %v", ok, err)
+	}
+
+	for k, v := range map[string]string{
+		PAXLoadFlags:    "x",
+		PAXTextureFlags: "10000",
+		PAXChunks:       "0",
+	} {
+		bad := map[string]string{PAXLoadFlags: "1", PAXTextureFlags: "0", PAXChunks: "1"}
+		bad[k] = v
+		if _, _, _, _, err := ParseTarPAXRecords(bad); err == nil {
+			t.Errorf("%s=%q: expected error", k, v)
+		}
+	}
+
+	// inconsistent flags can't be represented
+	f.Chunk[1].LoadFlags = 0
+	if _, err := TarPAXRecords(f); err == nil {
+		t.Errorf("expected error for inconsistent flags")
+	}
+}
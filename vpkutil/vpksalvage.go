@@ -0,0 +1,95 @@
+package vpkutil
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// SalvageDamage describes a chunk which could not be fully read.
+type SalvageDamage struct {
+	Chunk  int    // chunk index
+	Offset uint64 // uncompressed offset of the chunk in the file
+	Size   uint64 // uncompressed size of the chunk
+	Read   uint64 // number of bytes recovered from the start of the chunk
+	Err    error
+}
+
+func (d SalvageDamage) String() string {
+	return fmt.Sprintf("chunk %d (offset %d, %d bytes, %d recovered): %v", d.Chunk, d.Offset, d.Size, d.Read, d.Err)
+}
+
+// SalvageResult describes the outcome of salvaging a file.
+type SalvageResult struct {
+	Damage []SalvageDamage
+	CRC32  uint32 // of the data written
+}
+
+// OK returns true if the file was read without errors and the checksum
+// matches.
+func (s SalvageResult) OK(f tf2vpk.ValvePakFile) bool {
+	return len(s.Damage) == 0 && s.CRC32 == f.CRC32
+}
+
+// Salvage copies as much of f as possible from r to w, starting with the
+// preload data from the dir index. Chunks which cannot be read are replaced
+// with whatever data could be read from them (for uncompressed chunks in
+// truncated blocks) followed by zeros, so the output is always the expected
+// size and undamaged data stays at the correct offset. An error is only
+// returned if writing to w fails, or if a chunk is larger than
+// [tf2vpk.ValvePakMaxChunkUncompressedSize] (so a corrupted dir index can't
+// make it allocate or write an arbitrary amount of data).
+func Salvage(w io.Writer, r *tf2vpk.Reader, f tf2vpk.ValvePakFile) (SalvageResult, error) {
+	var (
+		res SalvageResult
+		off uint64
+		buf []byte
+		crc = tf2vpk.NewCRC()
+		mw  = io.MultiWriter(w, crc)
+	)
+	for i, c := range f.Chunk {
+		if c.UncompressedSize > tf2vpk.ValvePakMaxChunkUncompressedSize {
+			return res, fmt.Errorf("chunk %d: uncompressed size %d exceeds the maximum of %d", i, c.UncompressedSize, tf2vpk.ValvePakMaxChunkUncompressedSize)
+		}
+	}
+	if _, err := mw.Write(f.Preload); err != nil {
+		return res, err
+	}
+	off += uint64(len(f.Preload))
+	_, berr := r.OpenBlockRaw(f.Index)
+	for i, c := range f.Chunk {
+		if uint64(cap(buf)) < c.UncompressedSize {
+			buf = make([]byte, c.UncompressedSize)
+		}
+		b := buf[:c.UncompressedSize]
+
+		var n int
+		err := berr
+		if err == nil {
+			var cr io.Reader
+			if cr, err = r.OpenChunk(f, c); err == nil {
+				n, err = io.ReadFull(cr, b)
+				if c.IsCompressed() && err != nil {
+					n = 0 // partial lzham output isn't meaningful
+				}
+			}
+		}
+		if err != nil {
+			clear(b[n:])
+			res.Damage = append(res.Damage, SalvageDamage{
+				Chunk:  i,
+				Offset: off,
+				Size:   c.UncompressedSize,
+				Read:   uint64(n),
+				Err:    err,
+			})
+		}
+		if _, err := mw.Write(b); err != nil {
+			return res, err
+		}
+		off += c.UncompressedSize
+	}
+	res.CRC32 = crc.Sum32()
+	return res, nil
+}
@@ -0,0 +1,80 @@
+package vpkutil
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+func TestSalvage(t *testing.T) {
+	data := strings.Repeat("0123456789", 1000)
+
+	vpk := tf2vpk.ValvePakRef{Path: t.TempDir(), Prefix: "english", Name: "test"}
+	w := tf2vpk.NewWriter(vpk)
+	w.Compression = func(string) tf2vpk.CompressionMode {
+		return tf2vpk.CompressionStore
+	}
+	w.Preload = func(string) int {
+		return 100
+	}
+	if err := w.Add("a.txt", 1, 0, strings.NewReader(data)); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+
+	r, err := tf2vpk.NewReader(vpk)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	f := r.Root.File[0]
+	if len(f.Preload) != 100 {
+		t.Fatalf("expected preload data")
+	}
+
+	var buf bytes.Buffer
+	res, err := Salvage(&buf, r, f)
+	if err != nil {
+		t.Fatalf("salvage: %v", err)
+	}
+	if !res.OK(f) || buf.String() != data {
+		t.Errorf("undamaged file not salvaged correctly")
+	}
+
+	// chunks with impossible sizes must be rejected without allocating them
+	bad := f
+	bad.Chunk = []tf2vpk.ValvePakChunk{f.Chunk[0]}
+	bad.Chunk[0].UncompressedSize = 1 << 62
+	if _, err := Salvage(&buf, r, bad); err == nil {
+		t.Errorf("expected error for oversized chunk")
+	}
+	r.Close()
+
+	// truncated blocks should keep the readable part of stored chunks
+	if err := os.Truncate(vpk.Resolve(0), 100); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = tf2vpk.NewReader(vpk); err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer r.Close()
+
+	buf.Reset()
+	res, err = Salvage(&buf, r, f)
+	if err != nil {
+		t.Fatalf("salvage: %v", err)
+	}
+	if res.OK(f) || len(res.Damage) == 0 {
+		t.Errorf("expected damage")
+	}
+	if buf.Len() != len(data) {
+		t.Errorf("expected %d bytes, got %d", len(data), buf.Len())
+	}
+	if !strings.HasPrefix(data, strings.TrimRight(buf.String(), "\x00")) {
+		t.Errorf("salvaged data doesn't match")
+	}
+}
@@ -0,0 +1,250 @@
+package vpkutil
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// SetDuplicateReport describes the content duplicated between different VPKs
+// (e.g., all VPKs in an install). The dir indexes for different languages of
+// the same VPK share its blocks, so content shared between them is counted as
+// already deduplicated rather than wasted.
+//
+// Since a dir index can only reference the blocks with its own name, the
+// duplicate content can't be shared between VPKs. The report can be used to
+// decide how to split content between VPKs instead.
+type SetDuplicateReport struct {
+	VPKs  []SetDuplicateVPK   // sorted by name
+	Files []SetDuplicateGroup // identical files stored by more than one VPK, most wasted bytes first
+
+	FileWasted  uint64 // uncompressed bytes stored by more than one VPK as identical files
+	Chunks      int    // number of distinct chunk contents stored by more than one VPK
+	ChunkWasted uint64 // uncompressed bytes stored by more than one VPK as identical chunks
+	Shared      uint64 // uncompressed bytes referenced by more than one language, but only stored once
+}
+
+// SetDuplicateVPK describes the content a VPK shares with other VPKs.
+type SetDuplicateVPK struct {
+	Name      string   // without the language prefix
+	Languages []string // which were read
+	Size      uint64   // uncompressed bytes of distinct chunks stored in the VPK
+	Shared    uint64   // uncompressed bytes of chunks whose contents are also stored by other VPKs
+}
+
+// SetDuplicateGroup is a set of files with identical contents in more than one
+// VPK.
+type SetDuplicateGroup struct {
+	SHA256 [sha256.Size]byte
+	Size   uint64             // uncompressed size of each file
+	Files  []SetDuplicateFile // sorted
+	Stored int                // number of VPKs storing a copy
+}
+
+// SetDuplicateFile is a file in a SetDuplicateGroup.
+type SetDuplicateFile struct {
+	VPK  string
+	Path string
+}
+
+// Wasted returns the number of uncompressed bytes which would be saved if only
+// one VPK stored the file.
+func (g SetDuplicateGroup) Wasted() uint64 {
+	return uint64(g.Stored-1) * g.Size
+}
+
+// setDupeChunk identifies a chunk in the blocks of a VPK.
+type setDupeChunk struct {
+	Set    int
+	Index  tf2vpk.ValvePakIndex
+	Offset uint64
+}
+
+// setDupeFile identifies the contents of a file in the blocks of a VPK.
+type setDupeFile struct {
+	Set    int
+	Index  tf2vpk.ValvePakIndex
+	Offset uint64
+	Size   uint64
+	CRC32  uint32
+}
+
+// FindSetDuplicates reads every file in refs (other than ones skip returns true
+// for, if not nil), hashing the uncompressed contents of each file (including
+// the preload data) and chunk, and finds the content stored by more than one VPK. Dir indexes for the same
+// VPK in different languages should all be included, and files shared between
+// them are only read once. If progress is not nil, it is called as data is
+// read.
+func FindSetDuplicates(refs []tf2vpk.ValvePakRef, skip func(tf2vpk.ValvePakFile) (bool, error), progress tf2vpk.ProgressFunc) (SetDuplicateReport, error) {
+	var rep SetDuplicateReport
+
+	type chunkInfo struct {
+		SHA256 [sha256.Size]byte
+		Size   uint64
+	}
+	type fileInfo struct {
+		SHA256 [sha256.Size]byte
+		Size   uint64
+	}
+	type groupInfo struct {
+		Size  uint64
+		Files map[SetDuplicateFile]int // to set
+	}
+	var (
+		sets      = map[string]int{} // blocks (path and name) to index in rep.VPKs
+		chunks    = map[setDupeChunk]chunkInfo{}
+		files     = map[setDupeFile]fileInfo{}
+		stored    = map[[sha256.Size]byte]map[int]struct{}{} // chunk contents to sets
+		chunkSize = map[[sha256.Size]byte]uint64{}
+		groups    = map[[sha256.Size]byte]*groupInfo{}
+		done      int64
+	)
+	for _, ref := range refs {
+		key := filepath.Join(ref.Path, ref.Name)
+		set, ok := sets[key]
+		if !ok {
+			set = len(rep.VPKs)
+			sets[key] = set
+			rep.VPKs = append(rep.VPKs, SetDuplicateVPK{Name: ref.Name})
+		}
+		if !slices.Contains(rep.VPKs[set].Languages, ref.Prefix) {
+			rep.VPKs[set].Languages = append(rep.VPKs[set].Languages, ref.Prefix)
+		}
+		if err := func() error {
+			r, err := tf2vpk.NewReader(ref)
+			if err != nil {
+				return err
+			}
+			defer r.Close()
+
+			for _, f := range r.Root.File {
+				if skip != nil {
+					if s, err := skip(f); err != nil {
+						return err
+					} else if s {
+						continue
+					}
+				}
+				if f.Size() == 0 {
+					continue
+				}
+				// files entirely in the preload data aren't in the blocks
+				var (
+					fk setDupeFile
+					fi fileInfo
+					ok bool
+				)
+				if len(f.Chunk) != 0 {
+					fk = setDupeFile{set, f.Index, f.Chunk[0].Offset, f.Size(), f.CRC32}
+					fi, ok = files[fk]
+				}
+				if ok {
+					rep.Shared += fi.Size
+				} else {
+					fi = fileInfo{Size: uint64(len(f.Preload))}
+					fh := sha256.New()
+					fh.Write(f.Preload)
+					for _, c := range f.Chunk {
+						cr, err := r.OpenChunk(f, c)
+						if err != nil {
+							return fmt.Errorf("read %q: %w", f.Path, err)
+						}
+						ch := sha256.New()
+						n, err := io.Copy(io.MultiWriter(fh, ch), tf2vpk.NewProgressReader(cr, progress, &done, 0, f.Path))
+						if err != nil {
+							return fmt.Errorf("read %q: %w", f.Path, err)
+						}
+						ck := setDupeChunk{set, f.Index, c.Offset}
+						if _, ok := chunks[ck]; !ok {
+							var ci chunkInfo
+							ch.Sum(ci.SHA256[:0])
+							ci.Size = uint64(n)
+							chunks[ck] = ci
+							if stored[ci.SHA256] == nil {
+								stored[ci.SHA256] = map[int]struct{}{}
+							}
+							stored[ci.SHA256][set] = struct{}{}
+							chunkSize[ci.SHA256] = ci.Size
+						}
+						fi.Size += uint64(n)
+					}
+					fh.Sum(fi.SHA256[:0])
+					if len(f.Chunk) != 0 {
+						files[fk] = fi
+					}
+				}
+				if fi.Size == 0 {
+					continue
+				}
+				g, ok := groups[fi.SHA256]
+				if !ok {
+					g = &groupInfo{Size: fi.Size, Files: map[SetDuplicateFile]int{}}
+					groups[fi.SHA256] = g
+				}
+				g.Files[SetDuplicateFile{ref.Name, f.Path}] = set
+			}
+			return nil
+		}(); err != nil {
+			return rep, fmt.Errorf("vpk %q: %w", ref.Resolve(tf2vpk.ValvePakIndexDir), err)
+		}
+	}
+
+	for ck, ci := range chunks {
+		rep.VPKs[ck.Set].Size += ci.Size
+		if len(stored[ci.SHA256]) > 1 {
+			rep.VPKs[ck.Set].Shared += ci.Size
+		}
+	}
+	for h, s := range stored {
+		if len(s) > 1 {
+			rep.Chunks++
+			rep.ChunkWasted += uint64(len(s)-1) * chunkSize[h]
+		}
+	}
+
+	for h, gi := range groups {
+		ss := map[int]struct{}{}
+		for _, set := range gi.Files {
+			ss[set] = struct{}{}
+		}
+		if len(ss) < 2 {
+			continue
+		}
+		g := SetDuplicateGroup{SHA256: h, Size: gi.Size, Stored: len(ss)}
+		for f := range gi.Files {
+			g.Files = append(g.Files, f)
+		}
+		slices.SortFunc(g.Files, compareSetDuplicateFile)
+		rep.FileWasted += g.Wasted()
+		rep.Files = append(rep.Files, g)
+	}
+
+	for i := range rep.VPKs {
+		slices.Sort(rep.VPKs[i].Languages)
+	}
+	slices.SortFunc(rep.VPKs, func(a, b SetDuplicateVPK) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	slices.SortFunc(rep.Files, func(a, b SetDuplicateGroup) int {
+		if a.Wasted() != b.Wasted() {
+			if a.Wasted() > b.Wasted() {
+				return -1
+			}
+			return 1
+		}
+		return compareSetDuplicateFile(a.Files[0], b.Files[0])
+	})
+	return rep, nil
+}
+
+func compareSetDuplicateFile(a, b SetDuplicateFile) int {
+	if c := strings.Compare(a.VPK, b.VPK); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Path, b.Path)
+}
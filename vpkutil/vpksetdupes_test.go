@@ -0,0 +1,121 @@
+package vpkutil
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+func TestFindSetDuplicates(t *testing.T) {
+	var (
+		same = strings.Repeat("s", 4096)
+		tail = strings.Repeat("t", 4096)
+		preA = strings.Repeat("A", 100)
+		preB = strings.Repeat("B", 100)
+	)
+	dir := t.TempDir()
+	for _, x := range []struct {
+		Name    string
+		Files   map[string]string
+		Preload []string
+	}{
+		{"client_a.bsp.pak000", map[string]string{
+			"same.txt": same,
+			"p1.txt":   preA + tail,
+			"a.txt":    "aaaa",
+		}, []string{"p1.txt"}},
+		{"client_b.bsp.pak000", map[string]string{
+			"dir/same.txt": same,
+			"p2.txt":       preB + tail, // same chunk data, but different preload data
+			"q.txt":        preA + tail, // identical to p1.txt, but without preload data
+		}, []string{"p2.txt"}},
+	} {
+		w := tf2vpk.NewWriter(tf2vpk.ValvePakRef{Path: dir, Prefix: "english", Name: x.Name})
+		w.Preload = func(name string) int {
+			if slices.Contains(x.Preload, name) {
+				return 100
+			}
+			return 0
+		}
+		for _, name := range sortedKeys(x.Files) {
+			if err := w.Add(name, 1, 0, strings.NewReader(x.Files[name])); err != nil {
+				t.Fatalf("add %q: %v", name, err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("write vpk: %v", err)
+		}
+	}
+
+	// another language sharing the blocks
+	buf, err := os.ReadFile(filepath.Join(dir, "englishclient_a.bsp.pak000_dir.vpk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "frenchclient_a.bsp.pak000_dir.vpk"), buf, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	sets, err := tf2vpk.ScanValvePakSets(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var refs []tf2vpk.ValvePakRef
+	for _, s := range sets {
+		for _, lang := range s.Languages {
+			ref, _ := s.Ref(lang)
+			refs = append(refs, ref)
+		}
+	}
+	if len(refs) != 3 {
+		t.Fatalf("expected 3 dir indexes, got %d", len(refs))
+	}
+
+	rep, err := FindSetDuplicates(refs, nil, nil)
+	if err != nil {
+		t.Fatalf("find duplicates: %v", err)
+	}
+
+	var groups []string
+	for _, g := range rep.Files {
+		var fs []string
+		for _, f := range g.Files {
+			fs = append(fs, f.VPK+":"+f.Path)
+		}
+		groups = append(groups, strings.Join(fs, " "))
+		if g.Stored != 2 {
+			t.Errorf("group %q: expected 2 copies, got %d", fs, g.Stored)
+		}
+	}
+	slices.Sort(groups)
+	if exp := []string{
+		"client_a.bsp.pak000:p1.txt client_b.bsp.pak000:q.txt",
+		"client_a.bsp.pak000:same.txt client_b.bsp.pak000:dir/same.txt",
+	}; !slices.Equal(groups, exp) {
+		t.Errorf("expected groups %q, got %q", exp, groups)
+	}
+	if exp := uint64(len(same) + len(preA) + len(tail)); rep.FileWasted != exp {
+		t.Errorf("expected %d bytes wasted by files, got %d", exp, rep.FileWasted)
+	}
+	if exp := uint64(len(same) + len(preA) + len(tail) + 4); rep.Shared != exp {
+		t.Errorf("expected %d bytes shared between languages, got %d", exp, rep.Shared)
+	}
+	if len(rep.VPKs) != 2 || !slices.Equal(rep.VPKs[0].Languages, []string{"english", "french"}) || !slices.Equal(rep.VPKs[1].Languages, []string{"english"}) {
+		t.Errorf("incorrect vpks %+v", rep.VPKs)
+	}
+
+	// skipped files aren't included
+	rep, err = FindSetDuplicates(refs, func(f tf2vpk.ValvePakFile) (bool, error) {
+		return f.Path == "q.txt", nil
+	}, nil)
+	if err != nil {
+		t.Fatalf("find duplicates: %v", err)
+	}
+	if len(rep.Files) != 1 {
+		t.Errorf("expected 1 group with skipped file, got %+v", rep.Files)
+	}
+}
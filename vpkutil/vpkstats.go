@@ -0,0 +1,94 @@
+package vpkutil
+
+import (
+	"path"
+	"strings"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// SizeStats contains the sizes of a set of files in a VPK.
+type SizeStats struct {
+	Files            int    `json:"files"`
+	Chunks           int    `json:"chunks"`
+	CompressedChunks int    `json:"compressed_chunks"`
+	UncompressedSize uint64 `json:"uncompressed_size"`
+	CompressedSize   uint64 `json:"compressed_size"` // including chunks shared with other files
+	StoredSize       uint64 `json:"stored_size"`     // excluding chunks shared with previous files in the dir index
+}
+
+// Ratio returns the compressed size as a fraction of the uncompressed size.
+func (s SizeStats) Ratio() float64 {
+	if s.UncompressedSize == 0 {
+		return 1
+	}
+	return float64(s.CompressedSize) / float64(s.UncompressedSize)
+}
+
+func (s *SizeStats) add(f tf2vpk.ValvePakFile, stored uint64) {
+	s.Files++
+	for _, c := range f.Chunk {
+		s.Chunks++
+		if c.IsCompressed() {
+			s.CompressedChunks++
+		}
+		s.UncompressedSize += c.UncompressedSize
+		s.CompressedSize += c.CompressedSize
+	}
+	s.StoredSize += stored
+}
+
+type statsChunkKey struct {
+	Index  tf2vpk.ValvePakIndex
+	Offset uint64
+}
+
+// Stats contains size statistics for a VPK.
+type Stats struct {
+	Total     SizeStats                          `json:"total"`
+	Extension map[string]SizeStats               `json:"extension"` // by lowercase extension (empty if none)
+	Directory map[string]SizeStats               `json:"directory"` // by top-level directory (empty for files in the root)
+	Block     map[tf2vpk.ValvePakIndex]SizeStats `json:"block"`
+}
+
+// ComputeStats computes size statistics from the dir index without reading any
+// chunks. Chunks shared by multiple files are only included in the StoredSize
+// of the first one.
+func ComputeStats(root tf2vpk.ValvePakDir) Stats {
+	s := Stats{
+		Extension: map[string]SizeStats{},
+		Directory: map[string]SizeStats{},
+		Block:     map[tf2vpk.ValvePakIndex]SizeStats{},
+	}
+	seen := map[statsChunkKey]bool{}
+	for _, f := range root.File {
+		ext := strings.ToLower(strings.TrimPrefix(path.Ext(f.Path), "."))
+		dir, _, ok := strings.Cut(f.Path, "/")
+		if !ok {
+			dir = ""
+		}
+
+		var stored uint64
+		for _, c := range f.Chunk {
+			if k := (statsChunkKey{f.Index, c.Offset}); !seen[k] {
+				seen[k] = true
+				stored += c.CompressedSize
+			}
+		}
+
+		s.Total.add(f, stored)
+
+		x := s.Extension[ext]
+		x.add(f, stored)
+		s.Extension[ext] = x
+
+		x = s.Directory[dir]
+		x.add(f, stored)
+		s.Directory[dir] = x
+
+		x = s.Block[f.Index]
+		x.add(f, stored)
+		s.Block[f.Index] = x
+	}
+	return s
+}
@@ -0,0 +1,73 @@
+package vpkutil
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// SHA256Sum is an entry in a SHA-256 manifest.
+type SHA256Sum struct {
+	Path   string
+	Size   uint64
+	SHA256 [sha256.Size]byte
+}
+
+// SumFile reads f from r using up to threads decompression threads (see
+// [tf2vpk.Reader.OpenFileParallel]), returning its uncompressed size and
+// SHA-256. The CRC32 is also checked while reading.
+func SumFile(r *tf2vpk.Reader, f tf2vpk.ValvePakFile, threads int) (SHA256Sum, error) {
+	h, err := r.HashFile(f, threads)
+	if err != nil {
+		return SHA256Sum{Path: f.Path}, err
+	}
+	return SHA256Sum{Path: f.Path, Size: h.Size, SHA256: h.SHA256}, nil
+}
+
+// String formats s as a manifest line (without a trailing newline). The line
+// consists of the hex SHA-256, the size in bytes, and the path, separated by
+// single spaces.
+func (s SHA256Sum) String() string {
+	return hex.EncodeToString(s.SHA256[:]) + " " + strconv.FormatUint(s.Size, 10) + " " + s.Path
+}
+
+// ParseSHA256Sums parses a manifest consisting of lines formatted by
+// [SHA256Sum.String]. Blank lines and lines starting with # are ignored.
+func ParseSHA256Sums(r io.Reader) ([]SHA256Sum, error) {
+	var ss []SHA256Sum
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		var s SHA256Sum
+		hs, rest, ok1 := strings.Cut(line, " ")
+		size, path, ok2 := strings.Cut(rest, " ")
+		if !ok1 || !ok2 || path == "" {
+			return nil, fmt.Errorf("line %d: expected sha256, size, and path", n)
+		}
+		if b, err := hex.DecodeString(hs); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("line %d: invalid sha256 %q", n, hs)
+		} else {
+			copy(s.SHA256[:], b)
+		}
+		if v, err := strconv.ParseUint(size, 10, 64); err != nil {
+			return nil, fmt.Errorf("line %d: invalid size %q: %w", n, size, err)
+		} else {
+			s.Size = v
+		}
+		s.Path = path
+		ss = append(ss, s)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
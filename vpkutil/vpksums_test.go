@@ -0,0 +1,47 @@
+package vpkutil
+
+import (
+	"crypto/sha256"
+	"strings"
+	"testing"
+)
+
+func TestParseSHA256Sums(t *testing.T) {
+	exp := []SHA256Sum{
+		{"a.txt", 1, sha256.Sum256([]byte("a"))},
+		{"dir/with space.txt", 1 << 40, sha256.Sum256([]byte("b"))},
+	}
+	var b strings.Builder
+	b.WriteString("# comment\n\n")
+	for _, s := range exp {
+		b.WriteString(s.String() + "\r\n")
+	}
+	ss, err := ParseSHA256Sums(strings.NewReader(b.String()))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(ss) != len(exp) {
+		t.Fatalf("expected %d sums, got %d", len(exp), len(ss))
+	}
+	for i := range exp {
+		if ss[i] != exp[i] {
+			t.Errorf("sum %d: expected %+v, got %+v", i, exp[i], ss[i])
+		}
+	}
+
+	h := strings.Repeat("00", sha256.Size)
+	for _, line := range []string{
+		h,
+		h + " 1",
+		h + " 1 ",
+		h[2:] + " 1 a",
+		"zz" + h[2:] + " 1 a",
+		h + " -1 a",
+		h + " x a",
+		h + "  1 a",
+	} {
+		if _, err := ParseSHA256Sums(strings.NewReader(line + "\n")); err == nil {
+			t.Errorf("%q: expected error", line)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package vpkutil
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VPKTimesFilename is the name of the vpktimes file. It should be at the root
+// of the folder to be packed.
+const VPKTimesFilename = ".vpktimes"
+
+// VPKTimes records the modification times of the files in an unpacked VPK
+// (which the format doesn't store), so they can be restored when unpacking a
+// new version of the VPK. A time only applies if the file's checksum and size
+// still match.
+//
+// Each line contains the CRC32 (in hex), the uncompressed size, the time (in
+// RFC 3339 format with nanoseconds), and the file path, which extends to the
+// end of the line.
+type VPKTimes struct {
+	files map[string]vpkTimesEntry
+}
+
+type vpkTimesEntry struct {
+	CRC32 uint32
+	Size  uint64
+	Time  time.Time
+}
+
+// Set records the time for a file, replacing any existing one.
+func (v *VPKTimes) Set(path string, crc32 uint32, size uint64, t time.Time) error {
+	if strings.ContainsAny(path, "\n\r") {
+		return fmt.Errorf("entry %q: path contains newlines or carriage returns", path)
+	}
+	if v.files == nil {
+		v.files = map[string]vpkTimesEntry{}
+	}
+	v.files[path] = vpkTimesEntry{crc32, size, t}
+	return nil
+}
+
+// Get gets the time for a file if it was recorded with the same checksum and
+// size.
+func (v VPKTimes) Get(path string, crc32 uint32, size uint64) (time.Time, bool) {
+	e, ok := v.files[path]
+	if !ok || e.CRC32 != crc32 || e.Size != size {
+		return time.Time{}, false
+	}
+	return e.Time, true
+}
+
+// String returns a string which can later be parsed by Parse. Files are sorted
+// by path.
+func (v VPKTimes) String() string {
+	paths := make([]string, 0, len(v.files))
+	for path := range v.files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %-8s %s %s %s\n", "crc32", "size", "mtime", "path")
+	fmt.Fprintf(&b, "# generated for stable timestamps when unpacking; files which have changed are given the current time\n")
+	for _, path := range paths {
+		e := v.files[path]
+		fmt.Fprintf(&b, "%08X %d %s %s\n", e.CRC32, e.Size, e.Time.UTC().Format(time.RFC3339Nano), path)
+	}
+	return b.String()
+}
+
+// Parse parses a vpktimes string, replacing any existing contents.
+func (v *VPKTimes) Parse(s string) error {
+	var (
+		files  = map[string]vpkTimesEntry{}
+		lineNo int
+	)
+	sc := bufio.NewScanner(strings.NewReader(s))
+	for sc.Scan() {
+		line := sc.Text()
+		lineNo++
+
+		if t := strings.TrimSpace(line); t == "" || strings.HasPrefix(t, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(strings.TrimLeft(line, " \t"), " ", 4)
+		if len(fields) != 4 || fields[3] == "" {
+			return fmt.Errorf("line %d: expected 4 fields (crc32 size mtime path)", lineNo)
+		}
+
+		crc, err := strconv.ParseUint(fields[0], 16, 32)
+		if err != nil {
+			return fmt.Errorf("line %d: parse crc32 %q: %w", lineNo, fields[0], err)
+		}
+		size, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("line %d: parse size %q: %w", lineNo, fields[1], err)
+		}
+		t, err := time.Parse(time.RFC3339Nano, fields[2])
+		if err != nil {
+			return fmt.Errorf("line %d: parse mtime %q: %w", lineNo, fields[2], err)
+		}
+
+		path := fields[3]
+		if _, ok := files[path]; ok {
+			return fmt.Errorf("line %d: duplicate path %q", lineNo, path)
+		}
+		files[path] = vpkTimesEntry{uint32(crc), size, t}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	v.files = files
+	return nil
+}
+
+// ParseFile is like Parse, but reads from a file.
+func (v *VPKTimes) ParseFile(name string) error {
+	buf, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	return v.Parse(string(buf))
+}
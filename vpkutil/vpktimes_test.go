@@ -0,0 +1,61 @@
+package vpkutil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVPKTimes(t *testing.T) {
+	t1 := time.Date(2020, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	t2 := time.Date(2021, 6, 7, 8, 9, 10, 0, time.FixedZone("", -5*60*60))
+
+	var v1 VPKTimes
+	if err := v1.Set("b/with space.txt", 0xDEADBEEF, 1<<40, t2); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := v1.Set("a.txt", 1, 2, t1); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := v1.Set("bad\n.txt", 1, 2, t1); err == nil {
+		t.Errorf("expected error for path with newline")
+	}
+
+	var v2 VPKTimes
+	if err := v2.Parse(v1.String()); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if s1, s2 := v1.String(), v2.String(); s1 != s2 {
+		t.Errorf("round trip changed the output:\n%s\n%s", s1, s2)
+	}
+	for _, x := range []struct {
+		Path  string
+		CRC32 uint32
+		Size  uint64
+		Time  time.Time
+		OK    bool
+	}{
+		{"a.txt", 1, 2, t1, true},
+		{"b/with space.txt", 0xDEADBEEF, 1 << 40, t2, true},
+		{"a.txt", 2, 2, time.Time{}, false},
+		{"a.txt", 1, 3, time.Time{}, false},
+		{"c.txt", 1, 2, time.Time{}, false},
+	} {
+		if tm, ok := v2.Get(x.Path, x.CRC32, x.Size); ok != x.OK || !tm.Equal(x.Time) {
+			t.Errorf("get %q %08X %d: expected %v %t, got %v %t", x.Path, x.CRC32, x.Size, x.Time, x.OK, tm, ok)
+		}
+	}
+
+	for _, s := range []string{
+		"00000001 2 2020-01-02T03:04:05Z",
+		"00000001 2 2020-01-02T03:04:05Z ",
+		"zzzzzzzz 2 2020-01-02T03:04:05Z a.txt",
+		"00000001 -2 2020-01-02T03:04:05Z a.txt",
+		"00000001 2 2020-01-02 a.txt",
+		"00000001 2 2020-01-02T03:04:05Z a.txt\n00000001 2 2020-01-02T03:04:05Z a.txt",
+	} {
+		var v VPKTimes
+		if err := v.Parse(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package vpkutil
+
+import (
+	"fmt"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// Transcode decompresses the files from r and adds them to w, so they are
+// re-chunked and recompressed using the settings of w (e.g., ChunkSize,
+// Compression, and MaxBlockSize). Files are streamed one at a time, and
+// nothing is written to disk other than the output. The load and texture flags
+// of each file are kept, and if w.Preload is nil, so is the amount of preload
+// data. Files are decompressed using n goroutines, and skipped if skip is not
+// nil and returns true. If keep is not nil and returns true, the file is copied
+// as-is without recompressing it, so its chunks are byte-identical. If progress
+// is not nil, it is called as data is read.
+func Transcode(w *tf2vpk.Writer, r *tf2vpk.Reader, skip, keep func(tf2vpk.ValvePakFile) (bool, error), n int, progress tf2vpk.ProgressFunc) error {
+	total, err := totalSize(r, skip)
+	if err != nil {
+		return err
+	}
+	if w.Preload == nil {
+		preload := map[string]int{}
+		for _, f := range r.Root.File {
+			if len(f.Preload) != 0 {
+				preload[f.Path] = len(f.Preload)
+			}
+		}
+		w.Preload = func(name string) int {
+			return preload[name]
+		}
+		defer func() { w.Preload = nil }()
+	}
+	var done int64
+	for _, f := range r.Root.File {
+		if skip != nil {
+			if s, err := skip(f); err != nil {
+				return err
+			} else if s {
+				continue
+			}
+		}
+		if keep != nil {
+			if k, err := keep(f); err != nil {
+				return err
+			} else if k {
+				b, err := r.OpenBlockRaw(f.Index)
+				if err != nil {
+					return fmt.Errorf("transcode %q: %w", f.Path, err)
+				}
+				if err := w.AddRaw(f, b); err != nil {
+					return fmt.Errorf("transcode %q: %w", f.Path, err)
+				}
+				if done += int64(f.Size()); progress != nil {
+					progress(done, total, f.Path)
+				}
+				continue
+			}
+		}
+		load, err := f.LoadFlags()
+		if err != nil {
+			return fmt.Errorf("transcode %q: %w", f.Path, err)
+		}
+		texture, err := f.TextureFlags()
+		if err != nil {
+			return fmt.Errorf("transcode %q: %w", f.Path, err)
+		}
+		fr, err := r.OpenFileParallel(f, n)
+		if err != nil {
+			return fmt.Errorf("transcode %q: %w", f.Path, err)
+		}
+		if err := w.Add(f.Path, load, texture, tf2vpk.NewProgressReader(fr, progress, &done, total, f.Path)); err != nil {
+			return fmt.Errorf("transcode %q: %w", f.Path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package vpkutil
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+func TestTranscode(t *testing.T) {
+	files := map[string]string{}
+	for i, name := range []string{"a.txt", "b.vtf", "c.txt", "d.txt", "e.txt"} {
+		var b strings.Builder
+		for j := 0; b.Len() < 20000*(i+1); j++ {
+			fmt.Fprintf(&b, "%s line %d\n", name, j)
+		}
+		files[name] = b.String()
+	}
+	flags := map[string][2]uint32{
+		"a.txt": {uint32(tf2vpk.ValvePakLoadVisible | tf2vpk.ValvePakLoadCache), 0},
+		"b.vtf": {uint32(tf2vpk.ValvePakLoadVisible), 8},
+		"c.txt": {uint32(tf2vpk.ValvePakLoadCache), 0},
+		"d.txt": {uint32(tf2vpk.ValvePakLoadVisible), 0},
+		"e.txt": {uint32(tf2vpk.ValvePakLoadVisible), 0},
+	}
+
+	src := tf2vpk.ValvePakRef{Path: t.TempDir(), Prefix: "english", Name: "test"}
+	w := tf2vpk.NewWriter(src)
+	w.Preload = func(name string) int {
+		if name == "c.txt" {
+			return 100
+		}
+		return 0
+	}
+	for _, name := range sortedKeys(files) {
+		if err := w.Add(name, flags[name][0], uint16(flags[name][1]), strings.NewReader(files[name])); err != nil {
+			t.Fatalf("add %q: %v", name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+
+	r, err := tf2vpk.NewReader(src)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer r.Close()
+
+	dst := tf2vpk.ValvePakRef{Path: t.TempDir(), Prefix: "english", Name: "test"}
+	w = tf2vpk.NewWriter(dst)
+	w.ChunkSize = 4096
+	w.Compression = tf2vpk.StoreCompression
+	var last int64
+	if err := Transcode(w, r, func(f tf2vpk.ValvePakFile) (bool, error) {
+		return f.Path == "e.txt", nil
+	}, func(f tf2vpk.ValvePakFile) (bool, error) {
+		return f.Path == "d.txt", nil
+	}, 2, func(done, total int64, _ string) {
+		if done < last || done > total {
+			t.Errorf("incorrect progress %d/%d", done, total)
+		}
+		last = done
+	}); err != nil {
+		w.Abort()
+		t.Fatalf("transcode: %v", err)
+	}
+	if w.Preload != nil {
+		t.Errorf("preload policy not restored")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+
+	exp := map[string]string{}
+	for name, data := range files {
+		if name != "e.txt" {
+			exp[name] = data
+		}
+	}
+	checkTestVPK(t, dst, exp)
+
+	tr, err := tf2vpk.NewReader(dst)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer tr.Close()
+
+	for _, f := range tr.Root.File {
+		i := slices.IndexFunc(r.Root.File, func(o tf2vpk.ValvePakFile) bool {
+			return o.Path == f.Path
+		})
+		o := r.Root.File[i]
+		load, _ := f.LoadFlags()
+		texture, _ := f.TextureFlags()
+		if load != flags[f.Path][0] || uint32(texture) != flags[f.Path][1] {
+			t.Errorf("%q: incorrect flags %#x %#x", f.Path, load, texture)
+		}
+		if n := len(f.Preload); string(f.Preload) != string(o.Preload) || (f.Path == "c.txt") != (n == 100) {
+			t.Errorf("%q: expected %d bytes of preload data, got %d", f.Path, len(o.Preload), n)
+		}
+		if f.Path == "d.txt" {
+			if len(f.Chunk) != len(o.Chunk) || f.Chunk[0].CompressedSize != o.Chunk[0].CompressedSize {
+				t.Errorf("%q: kept file was recompressed", f.Path)
+			}
+		} else {
+			for _, c := range f.Chunk {
+				if c.UncompressedSize > 4096 || c.CompressedSize != c.UncompressedSize {
+					t.Errorf("%q: file was not re-chunked and stored", f.Path)
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package vpkutil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/pg9182/tf2vpk/internal"
+)
+
+// TransformFunc rewrites the contents of the file with the provided path as it
+// is packed or extracted, returning a reader for the new contents.
+type TransformFunc func(name string, r io.Reader) (io.Reader, error)
+
+var (
+	transformsMu sync.RWMutex
+	transforms   = map[string]TransformFunc{}
+)
+
+// RegisterTransform makes a transform available by name (e.g., for the
+// --transform flag of the pack and unpack commands). It panics if a transform
+// with the same name is already registered.
+func RegisterTransform(name string, fn TransformFunc) {
+	transformsMu.Lock()
+	defer transformsMu.Unlock()
+
+	if name == "" || strings.ContainsAny(name, "=,") {
+		panic(fmt.Errorf("register transform %q: invalid name", name))
+	}
+	if _, ok := transforms[name]; ok {
+		panic(fmt.Errorf("register transform %q: already registered", name))
+	}
+	transforms[name] = fn
+}
+
+// LookupTransform gets a transform registered by RegisterTransform.
+func LookupTransform(name string) (TransformFunc, bool) {
+	transformsMu.RLock()
+	defer transformsMu.RUnlock()
+
+	fn, ok := transforms[name]
+	return fn, ok
+}
+
+// TransformNames returns the names of the registered transforms in sorted
+// order.
+func TransformNames() []string {
+	transformsMu.RLock()
+	defer transformsMu.RUnlock()
+
+	names := make([]string, 0, len(transforms))
+	for name := range transforms {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func init() {
+	RegisterTransform("lf", func(name string, r io.Reader) (io.Reader, error) {
+		buf, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(bytes.ReplaceAll(buf, []byte("\r\n"), []byte("\n"))), nil
+	})
+	RegisterTransform("crlf", func(name string, r io.Reader) (io.Reader, error) {
+		buf, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		buf = bytes.ReplaceAll(buf, []byte("\r\n"), []byte("\n"))
+		return bytes.NewReader(bytes.ReplaceAll(buf, []byte("\n"), []byte("\r\n"))), nil
+	})
+	RegisterTransform("strip-bom", func(name string, r io.Reader) (io.Reader, error) {
+		buf, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(bytes.TrimPrefix(buf, []byte("\xEF\xBB\xBF"))), nil
+	})
+}
+
+// Transforms is a list of transforms applied to files matching globs (using
+// the same syntax as VPKFlags). All matching transforms are applied in the
+// order they were added.
+type Transforms struct {
+	rules []transformRule
+}
+
+type transformRule struct {
+	Glob string
+	Name string
+	Func TransformFunc
+}
+
+// Add adds a rule applying fn to files matching glob. The name is only used
+// for error messages.
+func (t *Transforms) Add(glob, name string, fn TransformFunc) error {
+	if _, err := path.Match(strings.TrimPrefix(glob, "/"), ""); err != nil {
+		return fmt.Errorf("invalid glob %q: %w", glob, err)
+	}
+	t.rules = append(t.rules, transformRule{glob, name, fn})
+	return nil
+}
+
+// Parse adds a rule in the form glob=name, where name is a registered
+// transform.
+func (t *Transforms) Parse(s string) error {
+	glob, name, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("parse transform %q: expected glob=name", s)
+	}
+	fn, ok := LookupTransform(name)
+	if !ok {
+		return fmt.Errorf("parse transform %q: unknown transform %q (available: %s)", s, name, strings.Join(TransformNames(), ", "))
+	}
+	return t.Add(glob, name, fn)
+}
+
+// Match checks whether any transforms apply to the provided path.
+func (t Transforms) Match(name string) bool {
+	for _, rule := range t.rules {
+		if m, _ := internal.MatchGlobParents(rule.Glob, name); m {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply applies the matching transforms to r. If none match, r is returned
+// as-is.
+func (t Transforms) Apply(name string, r io.Reader) (io.Reader, error) {
+	for _, rule := range t.rules {
+		if m, _ := internal.MatchGlobParents(rule.Glob, name); m {
+			x, err := rule.Func(name, r)
+			if err != nil {
+				return nil, fmt.Errorf("transform %q (%s): %w", name, rule.Name, err)
+			}
+			r = x
+		}
+	}
+	return r, nil
+}
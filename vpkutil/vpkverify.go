@@ -0,0 +1,217 @@
+package vpkutil
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+// VerifyParallel is like Verify, but reads and decompresses up to n chunks at a
+// time (or GOMAXPROCS if n is not positive), independently of which file they
+// belong to. Each chunk is checksummed separately, and the checksums are then
+// combined to check the file, so chunks never need to be buffered in order, and
+// large files are verified as fast as many small ones.
+//
+// If fn is not nil, it is called as each file is verified (in no particular
+// order). The callbacks are not called concurrently.
+func VerifyParallel(r *tf2vpk.Reader, skip func(tf2vpk.ValvePakFile) (bool, error), n int, fn func(f tf2vpk.ValvePakFile, err error), progress tf2vpk.ProgressFunc) error {
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	total, err := totalSize(r, skip)
+	if err != nil {
+		return err
+	}
+
+	type job struct {
+		File, Chunk int
+	}
+	type result struct {
+		job
+		CRC uint32
+		Err error
+	}
+	type state struct {
+		Remaining int
+		CRC       []uint32
+		Err       error
+	}
+
+	var (
+		files = make([]*state, len(r.Root.File))
+		order []int
+	)
+	for fi, f := range r.Root.File {
+		if skip != nil {
+			if s, err := skip(f); err != nil {
+				return err
+			} else if s {
+				continue
+			}
+		}
+		files[fi] = &state{Remaining: len(f.Chunk), CRC: make([]uint32, len(f.Chunk))}
+		order = append(order, fi)
+	}
+
+	var (
+		jobs    = make(chan job, n*4)
+		results = make(chan result, n*4)
+		wg      sync.WaitGroup
+	)
+	go func() {
+		defer close(jobs)
+		for _, fi := range order {
+			for ci := range r.Root.File[fi].Chunk {
+				jobs <- job{fi, ci}
+			}
+		}
+	}()
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, tf2vpk.ValvePakMaxChunkUncompressedSize)
+			for j := range jobs {
+				f := r.Root.File[j.File]
+				c := f.Chunk[j.Chunk]
+				res := result{job: j}
+				if c.UncompressedSize > uint64(len(buf)) {
+					res.Err = fmt.Errorf("chunk %d: uncompressed size %d larger than %d", j.Chunk, c.UncompressedSize, len(buf))
+				} else if b, err := r.OpenBlockRaw(f.Index); err != nil {
+					res.Err = err
+				} else if cr, err := c.CreateReader(b); err != nil {
+					res.Err = fmt.Errorf("chunk %d: %w", j.Chunk, err)
+				} else if _, err := io.ReadFull(cr, buf[:c.UncompressedSize]); err != nil {
+					if err == io.ErrUnexpectedEOF {
+						err = fmt.Errorf("chunk is truncated: %w", err)
+					}
+					res.Err = fmt.Errorf("chunk %d: %w", j.Chunk, err)
+				} else {
+					res.CRC = crc32.ChecksumIEEE(buf[:c.UncompressedSize])
+				}
+				results <- res
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	finish := func(fi int, err error) {
+		if err == nil {
+			f := r.Root.File[fi]
+			sum := crc32.ChecksumIEEE(f.Preload)
+			for ci, c := range f.Chunk {
+				sum = crc32Combine(sum, files[fi].CRC[ci], int64(c.UncompressedSize))
+			}
+			if sum != f.CRC32 {
+				err = fmt.Errorf("crc mismatch: expected %08X, got %08X", f.CRC32, sum)
+			}
+		}
+		files[fi].Err = err
+		files[fi].CRC = nil
+		if fn != nil {
+			fn(r.Root.File[fi], err)
+		}
+	}
+	for _, fi := range order {
+		if files[fi].Remaining == 0 {
+			finish(fi, nil) // no chunks
+		}
+	}
+
+	var done int64
+	for res := range results {
+		st := files[res.File]
+		st.Remaining--
+		if res.Err != nil {
+			if st.Err == nil {
+				st.Err = res.Err
+			}
+		} else {
+			st.CRC[res.Chunk] = res.CRC
+		}
+		if st.Remaining == 0 {
+			finish(res.File, st.Err)
+		}
+		if progress != nil {
+			done += int64(r.Root.File[res.File].Chunk[res.Chunk].UncompressedSize)
+			progress(done, total, r.Root.File[res.File].Path)
+		}
+	}
+
+	var errs []error
+	for _, fi := range order {
+		if err := files[fi].Err; err != nil {
+			errs = append(errs, fmt.Errorf("verify %q: %w", r.Root.File[fi].Path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// crc32Combine returns the IEEE CRC32 of the concatenation of two buffers given
+// their checksums and the length of the second one. It is based on
+// crc32_combine from zlib.
+func crc32Combine(crc1, crc2 uint32, len2 int64) uint32 {
+	if len2 <= 0 {
+		return crc1
+	}
+
+	var even, odd [32]uint32
+
+	// operator for one zero bit in odd
+	odd[0] = crc32.IEEE
+	row := uint32(1)
+	for n := 1; n < 32; n++ {
+		odd[n] = row
+		row <<= 1
+	}
+
+	// operator for two zero bits in even, then four zero bits in odd
+	gf2MatrixSquare(&even, &odd)
+	gf2MatrixSquare(&odd, &even)
+
+	// apply len2 zeros to crc1 (the first square puts the operator for one
+	// zero byte, eight zero bits, in even)
+	for {
+		gf2MatrixSquare(&even, &odd)
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(&even, crc1)
+		}
+		len2 >>= 1
+		if len2 == 0 {
+			break
+		}
+		gf2MatrixSquare(&odd, &even)
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(&odd, crc1)
+		}
+		len2 >>= 1
+		if len2 == 0 {
+			break
+		}
+	}
+	return crc1 ^ crc2
+}
+
+func gf2MatrixTimes(mat *[32]uint32, vec uint32) uint32 {
+	var sum uint32
+	for i := 0; vec != 0; i, vec = i+1, vec>>1 {
+		if vec&1 != 0 {
+			sum ^= mat[i]
+		}
+	}
+	return sum
+}
+
+func gf2MatrixSquare(square, mat *[32]uint32) {
+	for n := 0; n < 32; n++ {
+		square[n] = gf2MatrixTimes(mat, mat[n])
+	}
+}
@@ -0,0 +1,126 @@
+package vpkutil
+
+import (
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pg9182/tf2vpk"
+)
+
+func TestCRC32Combine(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, n := range [][2]int{{0, 0}, {0, 1}, {1, 0}, {1, 1}, {3, 7}, {100, 1}, {1, 4096}, {65536, 12345}, {1 << 20, 1 << 20}} {
+		a, b := make([]byte, n[0]), make([]byte, n[1])
+		rng.Read(a)
+		rng.Read(b)
+		exp := crc32.ChecksumIEEE(append(append([]byte(nil), a...), b...))
+		if act := crc32Combine(crc32.ChecksumIEEE(a), crc32.ChecksumIEEE(b), int64(len(b))); act != exp {
+			t.Errorf("%d+%d bytes: expected %08X, got %08X", n[0], n[1], exp, act)
+		}
+	}
+}
+
+func TestVerifyParallel(t *testing.T) {
+	files := map[string]string{}
+	for i := 0; i < 8; i++ {
+		var b strings.Builder
+		for j := 0; b.Len() < i*10000+1; j++ {
+			fmt.Fprintf(&b, "file %d line %d\n", i, j)
+		}
+		files[fmt.Sprintf("file%d.txt", i)] = b.String()
+	}
+
+	vpk := tf2vpk.ValvePakRef{Path: t.TempDir(), Prefix: "english", Name: "test"}
+	w := tf2vpk.NewWriter(vpk)
+	w.ChunkSize = 4096
+	w.Compression = func(string) tf2vpk.CompressionMode {
+		return tf2vpk.CompressionStore // so we can corrupt it easily
+	}
+	w.Preload = func(name string) int {
+		if name == "file3.txt" {
+			return 100
+		}
+		return 0
+	}
+	for _, name := range sortedKeys(files) {
+		if err := w.Add(name, 1, 0, strings.NewReader(files[name])); err != nil {
+			t.Fatalf("add %q: %v", name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+
+	verify := func(n int) (map[string]error, error) {
+		r, err := tf2vpk.NewReader(vpk)
+		if err != nil {
+			t.Fatalf("read vpk: %v", err)
+		}
+		defer r.Close()
+
+		res := map[string]error{}
+		var last int64
+		err = VerifyParallel(r, func(f tf2vpk.ValvePakFile) (bool, error) {
+			return f.Path == "file7.txt", nil
+		}, n, func(f tf2vpk.ValvePakFile, err error) {
+			if _, ok := res[f.Path]; ok {
+				t.Errorf("%q verified twice", f.Path)
+			}
+			res[f.Path] = err
+		}, func(done, total int64, _ string) {
+			if done < last || done > total {
+				t.Errorf("incorrect progress %d/%d", done, total)
+			}
+			last = done
+		})
+		return res, err
+	}
+
+	for _, n := range []int{0, 1, 3} {
+		res, err := verify(n)
+		if err != nil {
+			t.Errorf("n=%d: unexpected error: %v", n, err)
+		}
+		if len(res) != len(files)-1 {
+			t.Errorf("n=%d: expected %d files, got %d", n, len(files)-1, len(res))
+		}
+		if _, ok := res["file7.txt"]; ok {
+			t.Errorf("n=%d: skipped file verified", n)
+		}
+	}
+
+	// corrupt the second chunk of file5.txt
+	r, err := tf2vpk.NewReader(vpk)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	i := slices.IndexFunc(r.Root.File, func(f tf2vpk.ValvePakFile) bool {
+		return f.Path == "file5.txt"
+	})
+	off := int64(r.Root.File[i].Chunk[1].Offset)
+	r.Close()
+
+	bf, err := os.OpenFile(vpk.Resolve(0), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bf.WriteAt([]byte{'!'}, off+10); err != nil {
+		t.Fatal(err)
+	}
+	bf.Close()
+
+	res, err := verify(4)
+	if err == nil || !strings.Contains(err.Error(), "file5.txt") {
+		t.Errorf("expected error for file5.txt, got %v", err)
+	}
+	for name, err := range res {
+		if (name == "file5.txt") != (err != nil) {
+			t.Errorf("%q: unexpected result %v", name, err)
+		}
+	}
+}
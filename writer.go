@@ -0,0 +1,1254 @@
+package tf2vpk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Writer writes Titanfall 2 VPKs.
+//
+// Files are compressed and appended to the data block as they are added, and
+// the dir index is written when the Writer is closed. Chunks with identical
+// contents are only stored once. The Writer never seeks, so blocks can be
+// written to non-seekable destinations (e.g., pipes or uploads) using
+// NewWriterFunc.
+type Writer struct {
+	Root ValvePakDir
+
+	// Deterministic makes the output depend only on the files added and their
+	// contents, not on the order they were added in. Chunk data is spooled to
+	// temporary files and laid out in dir index order when the Writer is
+	// closed. It must be set before adding files.
+	Deterministic bool
+
+	// Spool, if not nil, creates the buffers used to spool chunk data when
+	// Deterministic is set, instead of temporary files (e.g., MemorySpool). If
+	// the returned value implements [io.Closer], it will be called when it is
+	// no longer needed.
+	Spool func() (WriterSpool, error)
+
+	// Sequential makes the Writer finish (and close) each block before
+	// creating the next one, so the blocks are created and written in order
+	// one at a time, followed by the dir index. Once a new block is started,
+	// files can't be added to the previous ones.
+	Sequential bool
+
+	// MaxBlockSize, if non-zero, is the size after which a new block is
+	// started. Since a file's chunks must be in a single block, a block may
+	// exceed it by up to the compressed size of the file which crosses the
+	// threshold.
+	MaxBlockSize uint64
+
+	// Compression, if not nil, decides how the chunks of each file added with
+	// Add are stored. If nil, DefaultCompression is used.
+	Compression func(name string) CompressionMode
+
+	// ChunkSize, if non-zero, is the uncompressed size of the chunks files
+	// added with Add are split into. Smaller chunks make reading parts of files
+	// cheaper, but compress worse. It must be at most
+	// ValvePakMaxChunkUncompressedSize, which is the default (and what the
+	// game's own VPKs use).
+	ChunkSize uint64
+
+	// Preload, if not nil, returns the number of bytes from the start of each
+	// file added with Add to store in the dir index as preload data (see
+	// ValvePakFile) instead of in the chunks. It is limited to 65535 bytes,
+	// and at least one byte of the file is always stored in a chunk.
+	Preload func(name string) int
+
+	// Progress, if not nil, is called after each chunk is added with the
+	// total uncompressed size of the files added so far. The total is always
+	// zero since it isn't known in advance.
+	Progress ProgressFunc
+
+	// Logger, if not nil, is used to log debug information about each file
+	// added (including the time taken and the number of reused chunks) and
+	// each block started.
+	Logger *slog.Logger
+
+	// Workers, if greater than one, is the number of goroutines used to
+	// compress the chunks of files added with Add and AddChunks. The chunks
+	// are still written in the order they were added, so the output is
+	// identical. Files may not appear in Root until more files are added,
+	// Flush is called, or the Writer is closed, and errors writing a file's
+	// chunks may be returned when adding a later one (once writing fails, the
+	// same error is returned by everything else). It must be set before adding
+	// files.
+	Workers int
+
+	// Alignment, if greater than one, pads the block data with zeros so each
+	// chunk written starts at a multiple of it (e.g., 4096 for mmap or direct
+	// I/O). For chunks stored in the dir index, the offset is relative to the
+	// end of the tree. Padding is not counted in the block sizes reported by
+	// ValvePakDir. It must be set before adding files.
+	Alignment uint64
+
+	create    func(ValvePakIndex) (io.Writer, error)
+	block     map[ValvePakIndex]io.Writer
+	spool     map[ValvePakIndex]WriterSpool
+	closed    map[ValvePakIndex]bool
+	offset    map[ValvePakIndex]uint64
+	index     ValvePakIndex
+	names     map[string]struct{}
+	raw       map[rawChunkKey]chunkLocation
+	chunks    map[chunkKey]chunkLocation
+	buf       []byte
+	zbuf      []byte
+	free      [][]byte
+	queue     []*queuedChunk
+	jobs      chan *queuedChunk
+	added     int64
+	err       error // from writing queued chunks, sticky since they're gone
+	done      bool
+	appending bool
+}
+
+// CompressionMode determines how the chunks of a file are stored.
+type CompressionMode int
+
+const (
+	CompressionAuto  CompressionMode = iota // compress chunks if it makes them smaller
+	CompressionStore                        // store chunks uncompressed
+)
+
+// storeExt contains extensions of file types which are already compressed.
+var storeExt = map[string]bool{
+	"bik":  true, // bink video
+	"mp3":  true,
+	"ogg":  true,
+	"opus": true,
+	"png":  true,
+	"jpg":  true,
+	"jpeg": true,
+	"webp": true,
+	"webm": true,
+	"mp4":  true,
+	"zip":  true,
+	"gz":   true,
+	"xz":   true,
+	"zst":  true,
+	"7z":   true,
+}
+
+// DefaultCompression stores files of types which are already compressed (e.g.,
+// video, audio, and images other than textures) as-is, and compresses all
+// other files.
+func DefaultCompression(name string) CompressionMode {
+	if ext, _, _, err := splitPath(name); err == nil && storeExt[strings.ToLower(ext)] {
+		return CompressionStore
+	}
+	return CompressionAuto
+}
+
+// StoreCompression stores all files uncompressed, which makes packing much
+// faster (and loading slightly faster) at the cost of size, for development
+// builds. Stored chunks have the same compressed and uncompressed size, which
+// the game reads as-is.
+func StoreCompression(name string) CompressionMode {
+	return CompressionStore
+}
+
+// NewWriter creates a new Writer writing to vpk. The files are written to
+// temporary files in the same directory and renamed into place when the Writer
+// is closed successfully.
+func NewWriter(vpk ValvePakRef) *Writer {
+	return NewWriterFunc(func(i ValvePakIndex) (io.Writer, error) {
+		return createPendingFile(vpk.Resolve(i))
+	})
+}
+
+// NewAppendWriter creates a new Writer adding files to the existing VPK at vpk
+// without reading or rewriting the existing chunks. The chunks of new files
+// are appended to the end of the last block (or new blocks once it reaches
+// MaxBlockSize), and the dir index is replaced when the Writer is closed. New
+// chunks are not deduplicated against existing ones.
+//
+// If the Writer is aborted or fails to close, the blocks are truncated to
+// their original sizes. If the process is interrupted, the existing dir index
+// remains valid, but the blocks may be left with unreferenced data at the end.
+//
+// Deterministic is not supported, and the existing files must not have chunks
+// stored in the dir index.
+func NewAppendWriter(vpk ValvePakRef) (*Writer, error) {
+	df, err := os.Open(vpk.Resolve(ValvePakIndexDir))
+	if err != nil {
+		return nil, fmt.Errorf("open vpk dir index: %w", err)
+	}
+	var root ValvePakDir
+	err = root.Deserialize(df)
+	df.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read root directory: %w", err)
+	}
+
+	size := map[ValvePakIndex]int64{}
+	w := NewWriterFunc(func(i ValvePakIndex) (io.Writer, error) {
+		if n, ok := size[i]; ok {
+			return openAppendFile(vpk.Resolve(i), n)
+		}
+		return createPendingFile(vpk.Resolve(i))
+	})
+	w.Root = root
+	w.Root.File = append([]ValvePakFile(nil), root.File...)
+	w.appending = true
+	for _, f := range root.File {
+		if f.Index == ValvePakIndexDir {
+			return nil, fmt.Errorf("file %q has chunks stored in the dir index", f.Path)
+		}
+		w.names[f.Path] = struct{}{}
+		if _, ok := size[f.Index]; !ok {
+			fi, err := os.Stat(vpk.Resolve(f.Index))
+			if err != nil {
+				return nil, fmt.Errorf("stat vpk block %s: %w", f.Index, err)
+			}
+			size[f.Index] = fi.Size()
+			w.offset[f.Index] = uint64(fi.Size())
+			w.index = max(w.index, f.Index)
+		}
+	}
+	return w, nil
+}
+
+// NewWriterFunc creates a new Writer writing using the provided function, which
+// will be called at most once for each block. The dir index is created last. If
+// the returned [io.Writer] implements [io.Closer], it will be called when the
+// Writer is closed.
+func NewWriterFunc(create func(ValvePakIndex) (io.Writer, error)) *Writer {
+	return &Writer{
+		Root: ValvePakDir{
+			Magic:        ValvePakMagic,
+			MajorVersion: ValvePakVersionMajor,
+			MinorVersion: ValvePakVersionMinor,
+		},
+		create: create,
+		block:  map[ValvePakIndex]io.Writer{},
+		spool:  map[ValvePakIndex]WriterSpool{},
+		closed: map[ValvePakIndex]bool{},
+		offset: map[ValvePakIndex]uint64{},
+		names:  map[string]struct{}{},
+		raw:    map[rawChunkKey]chunkLocation{},
+		chunks: map[chunkKey]chunkLocation{},
+	}
+}
+
+// Add compresses the contents of r, writing it as a new file with the provided
+// path and flags.
+func (w *Writer) Add(name string, loadFlags uint32, textureFlags uint16, r io.Reader) error {
+	compression := w.Compression
+	if compression == nil {
+		compression = DefaultCompression
+	}
+	size := w.ChunkSize
+	if size == 0 {
+		size = ValvePakMaxChunkUncompressedSize
+	} else if size > ValvePakMaxChunkUncompressedSize {
+		return fmt.Errorf("add %q: chunk size %d larger than %d", name, size, ValvePakMaxChunkUncompressedSize)
+	}
+	c := WriterChunk{
+		LoadFlags:    loadFlags,
+		TextureFlags: textureFlags,
+		Size:         size,
+		Store:        compression(name) == CompressionStore,
+	}
+	var preload int
+	if w.Preload != nil {
+		preload = min(max(w.Preload(name), 0), math.MaxUint16)
+	}
+	return w.add(name, r, false, preload, func(int) (WriterChunk, bool) {
+		return c, true
+	})
+}
+
+// WriterChunk describes a chunk of a file added with AddChunks.
+type WriterChunk struct {
+	LoadFlags    uint32
+	TextureFlags uint16
+	Size         uint64 // uncompressed, at most ValvePakMaxChunkUncompressedSize
+	Store        bool   // if false, the chunk is compressed if it makes it smaller
+}
+
+// AddChunks is like Add, but splits the contents of r into chunks with the
+// provided sizes and flags, ignoring the Compression policy. The chunk sizes
+// must add up to the length of r.
+func (w *Writer) AddChunks(name string, chunks []WriterChunk, r io.Reader) error {
+	for i, c := range chunks {
+		if c.Size == 0 || c.Size > ValvePakMaxChunkUncompressedSize {
+			return fmt.Errorf("add %q: chunk %d: invalid size %d", name, i, c.Size)
+		}
+	}
+	return w.add(name, r, true, 0, func(i int) (WriterChunk, bool) {
+		if i < len(chunks) {
+			return chunks[i], true
+		}
+		return WriterChunk{}, false
+	})
+}
+
+// add adds a file, splitting it into the chunks returned by next after the
+// first preload bytes. If exact is true, the file must be exactly the size of
+// the chunks.
+func (w *Writer) add(name string, r io.Reader, exact bool, preload int, next func(i int) (WriterChunk, bool)) error {
+	if w.done {
+		return fmt.Errorf("add %q: writer is closed", name)
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if _, ok := w.names[name]; ok {
+		return fmt.Errorf("add %q: file already exists", name)
+	}
+	if _, _, _, err := splitPath(name); err != nil {
+		return fmt.Errorf("add %q: %w", name, err)
+	}
+
+	if w.buf == nil {
+		w.buf = make([]byte, ValvePakMaxChunkUncompressedSize)
+		w.zbuf = make([]byte, ValvePakMaxChunkUncompressedSize)
+	}
+
+	qf := &queuedFile{
+		f:     ValvePakFile{Path: name},
+		start: time.Now(),
+	}
+	fail := func(format string, a ...any) error {
+		w.unqueue(qf)
+		return fmt.Errorf("add %q: "+format, append([]any{name}, a...)...)
+	}
+	h := NewCRC()
+	if preload > 0 {
+		// read one more byte so we know if there's anything left for the chunks
+		b := make([]byte, preload+1)
+		n, err := io.ReadFull(r, b)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("add %q: read: %w", name, err)
+		}
+		b = b[:n]
+		p := max(min(n-1, preload), 0) // keep at least one byte for the chunks
+		if p > 0 {
+			qf.f.Preload = b[:p:p]
+			qf.f.PreloadBytes = uint16(p)
+			_, _ = h.Write(qf.f.Preload)
+			w.progress(int64(p), name)
+		}
+		r = io.MultiReader(bytes.NewReader(b[p:]), r)
+	}
+	var chunks int
+	for i := 0; ; i++ {
+		wc, ok := next(i)
+		if !ok {
+			if exact {
+				if n, _ := io.ReadFull(r, w.buf[:1]); n != 0 {
+					return fail("file is larger than the specified chunks")
+				}
+			}
+			break
+		}
+		buf := w.buf
+		if w.Workers > 1 {
+			buf = w.getBuf()
+		}
+		n, err := io.ReadFull(r, buf[:wc.Size])
+		if err == io.EOF && !exact {
+			break
+		}
+		if (err == io.EOF || err == io.ErrUnexpectedEOF) && exact {
+			return fail("file is smaller than the specified chunks")
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return fail("read: %w", err)
+		}
+		src := buf[:n]
+		_, _ = h.Write(src)
+
+		q := &queuedChunk{
+			file: qf,
+			wc:   wc,
+			src:  src,
+		}
+		if w.Workers > 1 {
+			w.startWorkers()
+			q.zbuf = w.getBuf()
+			q.done = make(chan struct{})
+			w.queue = append(w.queue, q)
+			w.jobs <- q
+		} else {
+			q.process(w.zbuf)
+			w.queue = append(w.queue, q)
+		}
+		chunks++
+
+		if err := w.drain(w.maxQueued()); err != nil {
+			w.unqueue(qf)
+			return err
+		}
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+	}
+	if chunks == 0 {
+		return fail("empty files are not supported")
+	}
+	qf.f.CRC32 = h.Sum32()
+
+	w.names[name] = struct{}{}
+	w.queue = append(w.queue, &queuedChunk{file: qf, end: true})
+	return w.drain(w.maxQueued())
+}
+
+// queuedFile is a file added with add which hasn't been fully written yet.
+type queuedFile struct {
+	f      ValvePakFile
+	start  time.Time
+	reused int
+	began  bool // whether the block has been chosen and chunks written
+}
+
+// queuedChunk is a chunk of a queuedFile waiting to be written, or the end of
+// the file.
+type queuedChunk struct {
+	file *queuedFile
+	end  bool
+
+	wc   WriterChunk
+	src  []byte
+	zbuf []byte        // if owned by the chunk
+	done chan struct{} // nil if processed synchronously
+
+	key  chunkKey // set by process
+	data []byte   // set by process
+}
+
+// process hashes and compresses the chunk into zbuf. If it doesn't compress to
+// something smaller, it is stored as-is.
+func (q *queuedChunk) process(zbuf []byte) {
+	q.key = chunkKey{sha256.Sum256(q.src), q.wc.Store}
+	q.data = q.src
+	if n := len(q.src); n > 1 && !q.wc.Store {
+		if zn, err := CurrentCodec().Compress(zbuf[:n-1], q.src); err == nil {
+			q.data = zbuf[:zn]
+		}
+	}
+}
+
+// startWorkers starts the compression goroutines if they aren't running.
+func (w *Writer) startWorkers() {
+	if w.jobs != nil {
+		return
+	}
+	w.jobs = make(chan *queuedChunk)
+	for i := 0; i < w.Workers; i++ {
+		go func(jobs <-chan *queuedChunk) {
+			for q := range jobs {
+				q.process(q.zbuf)
+				close(q.done)
+			}
+		}(w.jobs)
+	}
+}
+
+// stopWorkers stops the compression goroutines once they finish the current
+// chunks.
+func (w *Writer) stopWorkers() {
+	if w.jobs != nil {
+		close(w.jobs)
+		w.jobs = nil
+	}
+}
+
+// maxQueued is the number of chunks which can be queued before waiting for
+// them to be written.
+func (w *Writer) maxQueued() int {
+	if w.Workers > 1 {
+		return w.Workers * 2
+	}
+	return 0
+}
+
+// getBuf gets a chunk buffer for a queued chunk.
+func (w *Writer) getBuf() []byte {
+	if n := len(w.free); n != 0 {
+		b := w.free[n-1]
+		w.free = w.free[:n-1]
+		return b
+	}
+	return make([]byte, ValvePakMaxChunkUncompressedSize)
+}
+
+// putBuf returns the buffers of a written chunk.
+func (w *Writer) putBuf(q *queuedChunk) {
+	if q.done != nil {
+		w.free = append(w.free, q.src[:cap(q.src)], q.zbuf[:cap(q.zbuf)])
+	}
+}
+
+// unqueue removes the unwritten chunks of a file which failed to be added.
+// Any chunks which were already written are left as unreferenced data. The
+// buffers aren't reused since they may still be in use by a worker.
+func (w *Writer) unqueue(qf *queuedFile) {
+	n := len(w.queue)
+	for n > 0 && w.queue[n-1].file == qf {
+		w.queue[n-1] = nil
+		n--
+	}
+	w.queue = w.queue[:n]
+}
+
+// Flush waits for all queued chunks to be compressed and written, and adds the
+// files to Root. It is only necessary if Workers is set, and is called
+// automatically by Close and the functions which write raw chunk data.
+func (w *Writer) Flush() error {
+	return w.drain(0)
+}
+
+// drain writes queued chunks in order, waiting for them to be processed until
+// at most n chunks are queued.
+func (w *Writer) drain(n int) error {
+	if w.err != nil {
+		return w.err
+	}
+	for len(w.queue) != 0 {
+		q := w.queue[0]
+		if q.done != nil {
+			select {
+			case <-q.done:
+			default:
+				if len(w.queue) <= n {
+					return nil
+				}
+				<-q.done
+			}
+		}
+		w.queue[0] = nil
+		w.queue = w.queue[1:]
+		if err := w.writeQueued(q); err != nil {
+			w.queue = nil
+			w.err = err
+			return err
+		}
+	}
+	return nil
+}
+
+// writeQueued writes a processed chunk to the current block, or finishes the
+// file if it is the end.
+func (w *Writer) writeQueued(q *queuedChunk) error {
+	qf := q.file
+	if !qf.began {
+		if err := w.reserve(0); err != nil {
+			return fmt.Errorf("add %q: %w", qf.f.Path, err)
+		}
+		qf.f.Index = w.index
+		qf.began = true
+	}
+	if q.end {
+		w.Root.File = append(w.Root.File, qf.f)
+		w.logFile("add file", qf.f, qf.reused, qf.start)
+		return nil
+	}
+	defer w.putBuf(q)
+
+	bw, err := w.openBlock(w.index)
+	if err != nil {
+		return fmt.Errorf("add %q: %w", qf.f.Path, err)
+	}
+
+	c := ValvePakChunk{
+		LoadFlags:        q.wc.LoadFlags,
+		TextureFlags:     q.wc.TextureFlags,
+		Offset:           w.offset[w.index],
+		UncompressedSize: uint64(len(q.src)),
+	}
+
+	// reuse identical chunks already written to the block
+	if loc, ok := w.chunks[q.key]; ok && loc.Index == w.index {
+		c.Offset = loc.Offset
+		c.CompressedSize = loc.Size
+		qf.reused++
+	} else {
+		if !w.Deterministic {
+			if err := w.pad(bw, w.index); err != nil {
+				return fmt.Errorf("add %q: %w", qf.f.Path, err)
+			}
+			c.Offset = w.offset[w.index]
+		}
+		c.CompressedSize = uint64(len(q.data))
+		if _, err := bw.Write(q.data); err != nil {
+			return fmt.Errorf("add %q: write chunk to block %s: %w", qf.f.Path, w.index, err)
+		}
+		w.offset[w.index] += c.CompressedSize
+		w.chunks[q.key] = chunkLocation{w.index, c.Offset, c.CompressedSize}
+	}
+	qf.f.Chunk = append(qf.f.Chunk, c)
+
+	w.progress(int64(len(q.src)), qf.f.Path)
+	return nil
+}
+
+// logFile logs a file which was added.
+func (w *Writer) logFile(msg string, f ValvePakFile, reused int, start time.Time) {
+	if w.Logger == nil {
+		return
+	}
+	size, csize := uint64(len(f.Preload)), uint64(0)
+	for _, c := range f.Chunk {
+		size += c.UncompressedSize
+		csize += c.CompressedSize
+	}
+	w.Logger.Debug(msg,
+		"path", f.Path,
+		"block", f.Index.String(),
+		"size", size,
+		"compressed_size", csize,
+		"chunks", len(f.Chunk),
+		"reused_chunks", reused,
+		"duration", time.Since(start),
+	)
+}
+
+// chunkKey identifies the contents of a chunk added with Add.
+type chunkKey struct {
+	sum   [sha256.Size]byte
+	store bool
+}
+
+// chunkLocation is where the data for a chunk was written.
+type chunkLocation struct {
+	Index  ValvePakIndex
+	Offset uint64
+	Size   uint64
+}
+
+// rawChunkKey identifies a chunk copied by AddRaw.
+type rawChunkKey struct {
+	r    io.ReaderAt
+	off  uint64
+	size uint64
+}
+
+// AddRaw copies f from r (the block it is stored in) without recompressing it,
+// preserving the flags and checksum. Chunks shared between files copied from
+// the same r are only written once.
+func (w *Writer) AddRaw(f ValvePakFile, r io.ReaderAt) error {
+	if w.done {
+		return fmt.Errorf("add %q: writer is closed", f.Path)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if _, ok := w.names[f.Path]; ok {
+		return fmt.Errorf("add %q: file already exists", f.Path)
+	}
+	if len(f.Chunk) == 0 {
+		return fmt.Errorf("add %q: invalid file: no chunks", f.Path)
+	}
+
+	var size uint64
+	for _, c := range f.Chunk {
+		size += w.padding(size) + c.CompressedSize
+	}
+	if err := w.reserve(size); err != nil {
+		return fmt.Errorf("add %q: %w", f.Path, err)
+	}
+
+	nf := f
+	nf.Index = w.index
+	nf.Chunk = make([]ValvePakChunk, len(f.Chunk))
+	start := time.Now()
+	var reused int
+	for i, c := range f.Chunk {
+		k := rawChunkKey{r, c.Offset, c.CompressedSize}
+		if loc, ok := w.raw[k]; ok && loc.Index == w.index {
+			c.Offset = loc.Offset
+			reused++
+		} else {
+			cr, err := c.CreateReaderRaw(r)
+			if err != nil {
+				return fmt.Errorf("add %q: chunk %d: %w", f.Path, i, err)
+			}
+			if _, off, err := w.WriteRaw(cr, c.CompressedSize); err != nil {
+				return fmt.Errorf("add %q: chunk %d: %w", f.Path, i, err)
+			} else {
+				c.Offset = off
+			}
+			w.raw[k] = chunkLocation{w.index, c.Offset, c.CompressedSize}
+		}
+		nf.Chunk[i] = c
+		w.progress(int64(c.UncompressedSize), f.Path)
+	}
+	if err := w.AddFile(nf); err != nil {
+		return err
+	}
+	w.logFile("add raw file", nf, reused, start)
+	return nil
+}
+
+// progress reports n more uncompressed bytes added for name.
+func (w *Writer) progress(n int64, name string) {
+	w.added += n
+	if w.Progress != nil {
+		w.Progress(w.added, 0, name)
+	}
+}
+
+// WriteRaw writes n bytes of raw (i.e., already compressed if applicable)
+// chunk data from r to the current block, returning the location it was
+// written to. The chunk can then be referenced by files added with AddFile.
+// WriteRaw never starts a new block, since a file's chunks must all be in the
+// same one.
+func (w *Writer) WriteRaw(r io.Reader, n uint64) (index ValvePakIndex, offset uint64, err error) {
+	if w.done {
+		return 0, 0, fmt.Errorf("writer is closed")
+	}
+	if err := w.Flush(); err != nil {
+		return 0, 0, err
+	}
+	if n > math.MaxInt64-w.offset[w.index] {
+		return 0, 0, fmt.Errorf("block %s would exceed the maximum offset", w.index)
+	}
+	bw, err := w.openBlock(w.index)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !w.Deterministic {
+		if err := w.pad(bw, w.index); err != nil {
+			return 0, 0, err
+		}
+	}
+	if c, err := io.Copy(bw, io.LimitReader(r, int64(n))); err != nil {
+		return 0, 0, fmt.Errorf("copy to block %s: %w", w.index, err)
+	} else if uint64(c) != n {
+		return 0, 0, fmt.Errorf("copy to block %s: %w", w.index, io.ErrUnexpectedEOF)
+	}
+	index, offset = w.index, w.offset[w.index]
+	w.offset[w.index] += n
+	return index, offset, nil
+}
+
+// AddFile adds a file referencing chunks previously written by WriteRaw.
+func (w *Writer) AddFile(f ValvePakFile) error {
+	if w.done {
+		return fmt.Errorf("add %q: writer is closed", f.Path)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if _, ok := w.names[f.Path]; ok {
+		return fmt.Errorf("add %q: file already exists", f.Path)
+	}
+	if _, _, _, err := splitPath(f.Path); err != nil {
+		return fmt.Errorf("add %q: %w", f.Path, err)
+	}
+	if len(f.Chunk) == 0 {
+		return fmt.Errorf("add %q: invalid file: no chunks", f.Path)
+	}
+	for i, c := range f.Chunk {
+		if c.CompressedSize > w.offset[f.Index] || c.Offset > w.offset[f.Index]-c.CompressedSize {
+			return fmt.Errorf("add %q: chunk %d: not written to block %s", f.Path, i, f.Index)
+		}
+	}
+	w.Root.File = append(w.Root.File, f)
+	w.names[f.Path] = struct{}{}
+	return nil
+}
+
+// Block returns the index of the block files are currently being added to.
+// If MaxBlockSize is set, this may change when queued files are written, so
+// Flush should be called first for an exact value.
+func (w *Writer) Block() ValvePakIndex {
+	return w.index
+}
+
+// SetBlock sets the block which subsequently added files are written to. If
+// MaxBlockSize is set, a new block may still be started after it fills up.
+//
+// If i is ValvePakIndexDir, the chunks are stored in the dir index file after
+// the tree (i.e., a single-file VPK if all files are added this way). Since the
+// tree must be written first, the chunks are spooled until the Writer is
+// closed, and MaxBlockSize does not apply.
+func (w *Writer) SetBlock(i ValvePakIndex) error {
+	if !i.IsValid() {
+		return fmt.Errorf("invalid block index %s", i)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if w.Sequential && i < w.index {
+		return fmt.Errorf("cannot return to block %s after starting block %s when writing sequentially", i, w.index)
+	}
+	if i != w.index {
+		if err := w.finishBlock(w.index); err != nil {
+			return err
+		}
+	}
+	w.index = i
+	return nil
+}
+
+// reserve starts a new block if MaxBlockSize is set and adding size bytes
+// (after padding the block to Alignment) to the current block would exceed it.
+func (w *Writer) reserve(size uint64) error {
+	if w.MaxBlockSize == 0 || w.index == ValvePakIndexDir {
+		return nil
+	}
+	if off := w.offset[w.index]; off == 0 {
+		return nil
+	} else if off += w.padding(off); off+size <= w.MaxBlockSize && off < w.MaxBlockSize {
+		return nil
+	}
+	if w.index+1 >= ValvePakIndexDir {
+		return fmt.Errorf("too many blocks (the maximum block index is %d)", ValvePakMaxBlockIndex)
+	}
+	if err := w.finishBlock(w.index); err != nil {
+		return err
+	}
+	w.index++
+	if w.Logger != nil {
+		w.Logger.Debug("start block", "block", w.index.String(), "previous_size", w.offset[w.index-1])
+	}
+	return nil
+}
+
+// padding returns the number of bytes needed to align off to Alignment.
+func (w *Writer) padding(off uint64) uint64 {
+	if w.Alignment <= 1 {
+		return 0
+	}
+	return (w.Alignment - off%w.Alignment) % w.Alignment
+}
+
+// pad writes zeros to bw (block i) until the end of the block is aligned to
+// Alignment.
+func (w *Writer) pad(bw io.Writer, i ValvePakIndex) error {
+	n := w.padding(w.offset[i])
+	if n == 0 {
+		return nil
+	}
+	if n > math.MaxInt64-w.offset[i] {
+		return fmt.Errorf("block %s would exceed the maximum offset", i)
+	}
+	if err := writeZeros(bw, n); err != nil {
+		return fmt.Errorf("pad block %s: %w", i, err)
+	}
+	w.offset[i] += n
+	return nil
+}
+
+var zeros [4096]byte
+
+// writeZeros writes n zero bytes to w.
+func writeZeros(w io.Writer, n uint64) error {
+	for n != 0 {
+		x := min(n, uint64(len(zeros)))
+		if _, err := w.Write(zeros[:x]); err != nil {
+			return err
+		}
+		n -= x
+	}
+	return nil
+}
+
+// finishBlock closes block i if it has been created and Sequential is set.
+func (w *Writer) finishBlock(i ValvePakIndex) error {
+	if !w.Sequential || w.closed[i] {
+		return nil
+	}
+	if bw, ok := w.block[i]; ok {
+		w.closed[i] = true
+		if c, ok := bw.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				return fmt.Errorf("close vpk block %s: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (w *Writer) openBlock(i ValvePakIndex) (io.Writer, error) {
+	if w.Deterministic && w.appending {
+		return nil, fmt.Errorf("deterministic output is not supported when appending")
+	}
+	if w.Deterministic || i == ValvePakIndexDir {
+		if sf, ok := w.spool[i]; ok {
+			return sf, nil
+		}
+		sf, err := w.newSpool()
+		if err != nil {
+			return nil, fmt.Errorf("create spool for vpk block %s: %w", i, err)
+		}
+		w.spool[i] = sf
+		return sf, nil
+	}
+	if bw, ok := w.block[i]; ok {
+		return bw, nil
+	}
+	bw, err := w.create(i)
+	if err != nil {
+		return nil, fmt.Errorf("create vpk block %s: %w", i, err)
+	}
+	w.block[i] = bw
+	return bw, nil
+}
+
+// Close sorts the files, writes the dir index, and closes the blocks. If an
+// error occurs, any pending files created by NewWriter are removed.
+func (w *Writer) Close() error {
+	if w.done {
+		return nil
+	}
+
+	err := func() error {
+		err := w.Flush()
+		w.done = true
+		w.stopWorkers()
+		if err != nil {
+			return err
+		}
+		if err := w.Root.SortFiles(); err != nil {
+			return fmt.Errorf("sort files: %w", err)
+		}
+		if w.Deterministic {
+			if err := w.layout(); err != nil {
+				return err
+			}
+		}
+		if err := w.finishBlock(w.index); err != nil {
+			return err
+		}
+		dw, err := w.create(ValvePakIndexDir)
+		if err != nil {
+			return fmt.Errorf("create vpk dir index: %w", err)
+		}
+		w.block[ValvePakIndexDir] = dw
+		if err := w.Root.Serialize(dw); err != nil {
+			return fmt.Errorf("write vpk dir index: %w", err)
+		}
+		if sf, ok := w.spool[ValvePakIndexDir]; ok {
+			if _, err := io.Copy(dw, io.NewSectionReader(sf, 0, int64(w.offset[ValvePakIndexDir]))); err != nil {
+				return fmt.Errorf("write vpk dir index chunk data: %w", err)
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		w.abort()
+		return err
+	}
+
+	// the dir index is closed and committed last (it has the largest index),
+	// so it is only finished once all blocks are
+	idx := make([]ValvePakIndex, 0, len(w.block))
+	for i := range w.block {
+		idx = append(idx, i)
+	}
+	sort.Slice(idx, func(a, b int) bool {
+		return idx[a] < idx[b]
+	})
+
+	var errs []error
+	for _, i := range idx {
+		if w.closed[i] {
+			continue
+		}
+		if i == ValvePakIndexDir && len(errs) != 0 {
+			break
+		}
+		if c, ok := w.block[i].(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("close vpk block %s: %w", i, err))
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		w.abort()
+		return fmt.Errorf("close blocks: %w", err)
+	}
+	for _, i := range idx {
+		if p, ok := w.block[i].(pendingWriter); ok {
+			if err := p.Commit(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("commit blocks: %w", err)
+	}
+	w.removeSpool()
+	return nil
+}
+
+// layout copies the spooled chunks to the blocks in the order they are
+// referenced by the (sorted) files, updating the chunk offsets.
+func (w *Writer) layout() error {
+	idx := make([]ValvePakIndex, 0, len(w.spool))
+	for i := range w.spool {
+		idx = append(idx, i)
+	}
+	sort.Slice(idx, func(a, b int) bool {
+		return idx[a] < idx[b]
+	})
+	for _, i := range idx {
+		sf := w.spool[i]
+
+		// chunks for the dir index are laid out into a new spool since
+		// they can only be written after the tree
+		var (
+			bw  io.Writer
+			nsf WriterSpool
+		)
+		if i == ValvePakIndexDir {
+			var err error
+			if nsf, err = w.newSpool(); err != nil {
+				return fmt.Errorf("create spool for vpk block %s: %w", i, err)
+			}
+			bw = nsf
+		} else {
+			x, err := w.create(i)
+			if err != nil {
+				return fmt.Errorf("create vpk block %s: %w", i, err)
+			}
+			w.block[i] = x
+			bw = x
+		}
+
+		var off uint64
+		moved := map[chunkLocation]uint64{}
+		for fi := range w.Root.File {
+			f := &w.Root.File[fi]
+			if f.Index != i {
+				continue
+			}
+			f.Chunk = append([]ValvePakChunk(nil), f.Chunk...)
+			for ci := range f.Chunk {
+				c := &f.Chunk[ci]
+				k := chunkLocation{i, c.Offset, c.CompressedSize}
+				if n, ok := moved[k]; ok {
+					c.Offset = n
+					continue
+				}
+				if n := w.padding(off); n != 0 {
+					if err := writeZeros(bw, n); err != nil {
+						if nsf != nil {
+							discardSpool(nsf)
+						}
+						return fmt.Errorf("write vpk block %s: %w", i, err)
+					}
+					off += n
+				}
+				if _, err := io.Copy(bw, io.NewSectionReader(sf, int64(c.Offset), int64(c.CompressedSize))); err != nil {
+					if nsf != nil {
+						discardSpool(nsf)
+					}
+					return fmt.Errorf("write vpk block %s: %w", i, err)
+				}
+				moved[k] = off
+				c.Offset = off
+				off += c.CompressedSize
+			}
+		}
+		w.offset[i] = off
+
+		if nsf != nil {
+			w.removeSpoolBlock(i)
+			w.spool[i] = nsf
+		} else {
+			if err := w.finishBlock(i); err != nil {
+				return err
+			}
+			w.removeSpoolBlock(i)
+		}
+	}
+	return nil
+}
+
+func (w *Writer) newSpool() (WriterSpool, error) {
+	if w.Spool != nil {
+		return w.Spool()
+	}
+	return os.CreateTemp("", "tf2vpk-spool*")
+}
+
+func (w *Writer) removeSpool() {
+	for i := range w.spool {
+		w.removeSpoolBlock(i)
+	}
+}
+
+func (w *Writer) removeSpoolBlock(i ValvePakIndex) {
+	if sf, ok := w.spool[i]; ok {
+		discardSpool(sf)
+		delete(w.spool, i)
+	}
+}
+
+func discardSpool(sf WriterSpool) {
+	if c, ok := sf.(io.Closer); ok {
+		c.Close()
+	}
+	if f, ok := sf.(*os.File); ok {
+		os.Remove(f.Name())
+	}
+}
+
+// WriterSpool buffers chunk data for a block when the Writer is deterministic.
+type WriterSpool interface {
+	io.Writer
+	io.ReaderAt
+}
+
+// MemorySpool returns a WriterSpool buffering data in memory, for use as
+// Writer.Spool when temporary files are not wanted.
+func MemorySpool() (WriterSpool, error) {
+	return new(memorySpool), nil
+}
+
+type memorySpool struct {
+	b []byte
+}
+
+func (m *memorySpool) Write(p []byte) (int, error) {
+	m.b = append(m.b, p...)
+	return len(p), nil
+}
+
+func (m *memorySpool) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset")
+	}
+	if off >= int64(len(m.b)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.b[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Abort discards everything written so far, removing any pending files created
+// by NewWriter.
+func (w *Writer) Abort() {
+	w.done = true
+	w.abort()
+}
+
+func (w *Writer) abort() {
+	w.stopWorkers()
+	w.queue = nil
+	w.removeSpool()
+	for i, bw := range w.block {
+		if p, ok := bw.(pendingWriter); ok {
+			p.Abort()
+		} else if c, ok := bw.(io.Closer); ok && !w.closed[i] {
+			_ = c.Close()
+		}
+	}
+}
+
+// pendingWriter is a block which is only finalized if the Writer is closed
+// successfully.
+type pendingWriter interface {
+	io.WriteCloser
+	Commit() error
+	Abort()
+}
+
+// pendingFile is a temporary file which is renamed to the final name when
+// committed.
+type pendingFile struct {
+	*os.File
+	name   string
+	closed bool
+}
+
+func createPendingFile(name string) (*pendingFile, error) {
+	tf, err := os.CreateTemp(filepath.Dir(name), ".vpk*")
+	if err != nil {
+		return nil, err
+	}
+	return &pendingFile{File: tf, name: name}, nil
+}
+
+func (p *pendingFile) Close() error {
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	if err := p.File.Sync(); err != nil {
+		p.File.Close()
+		return err
+	}
+	return p.File.Close()
+}
+
+func (p *pendingFile) Commit() error {
+	if err := os.Rename(p.File.Name(), p.name); err != nil {
+		os.Remove(p.File.Name())
+		return fmt.Errorf("rename %q: %w", p.name, err)
+	}
+	return nil
+}
+
+func (p *pendingFile) Abort() {
+	if !p.closed {
+		p.closed = true
+		p.File.Close()
+	}
+	os.Remove(p.File.Name())
+}
+
+// appendFile is an existing block which data is appended to. It is truncated
+// to its original size if aborted.
+type appendFile struct {
+	*os.File
+	size   int64
+	closed bool
+}
+
+func openAppendFile(name string, size int64) (*appendFile, error) {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := f.Stat(); err != nil {
+		f.Close()
+		return nil, err
+	} else if fi.Size() != size {
+		f.Close()
+		return nil, fmt.Errorf("size of %q changed from %d to %d", name, size, fi.Size())
+	}
+	return &appendFile{File: f, size: size}, nil
+}
+
+func (a *appendFile) Close() error {
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+	if err := a.File.Sync(); err != nil {
+		a.File.Close()
+		return err
+	}
+	return a.File.Close()
+}
+
+func (a *appendFile) Commit() error {
+	return nil
+}
+
+func (a *appendFile) Abort() {
+	if !a.closed {
+		a.closed = true
+		a.File.Close()
+	}
+	os.Truncate(a.File.Name(), a.size)
+}
@@ -0,0 +1,270 @@
+package tf2vpk
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAppendWriter(t *testing.T) {
+	vpk := ValvePakRef{Path: t.TempDir(), Prefix: "english", Name: "test"}
+
+	w := NewWriter(vpk)
+	if err := w.Add("a.txt", 1, 0, strings.NewReader("aaaa")); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+	fi, err := os.Stat(vpk.Resolve(0))
+	if err != nil {
+		t.Fatalf("stat block: %v", err)
+	}
+	size := fi.Size()
+
+	w, err = NewAppendWriter(vpk)
+	if err != nil {
+		t.Fatalf("open vpk: %v", err)
+	}
+	if err := w.Add("a.txt", 1, 0, strings.NewReader("x")); err == nil {
+		t.Errorf("expected error when adding an existing file")
+	}
+	if err := w.Add("b.txt", 1, 0, strings.NewReader("bbbb")); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	w.Abort()
+	if fi, err := os.Stat(vpk.Resolve(0)); err != nil || fi.Size() != size {
+		t.Fatalf("block not truncated after abort")
+	}
+
+	w, err = NewAppendWriter(vpk)
+	if err != nil {
+		t.Fatalf("open vpk: %v", err)
+	}
+	if err := w.Add("b.txt", 1, 0, strings.NewReader("bbbb")); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("write vpk: %v", err)
+	}
+
+	r, err := NewReader(vpk)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer r.Close()
+	for name, exp := range map[string]string{"a.txt": "aaaa", "b.txt": "bbbb"} {
+		if buf, err := fs.ReadFile(r, name); err != nil {
+			t.Errorf("read %q: %v", name, err)
+		} else if string(buf) != exp {
+			t.Errorf("read %q: incorrect contents", name)
+		}
+	}
+	if len(r.Root.File) != 2 || r.Root.File[0].Index != 0 || r.Root.File[1].Index != 0 {
+		t.Errorf("incorrect files: %+v", r.Root.File)
+	}
+}
+
+func TestWriterWorkers(t *testing.T) {
+	type file struct {
+		Name string
+		Data []byte
+	}
+	var files []file
+	x := uint32(1)
+	for i := 0; i < 16; i++ {
+		b := make([]byte, (i%5)*100000+i+1)
+		for j := range b {
+			if j%7 == 0 {
+				x = x*1664525 + 1013904223
+			}
+			b[j] = byte(x >> 29)
+		}
+		files = append(files, file{fmt.Sprintf("f%02d.bin", i), b})
+		if i%6 == 0 {
+			files = append(files, file{fmt.Sprintf("f%02d_copy.bin", i), b})
+		}
+	}
+
+	write := func(workers int) memBlocks {
+		m := memBlocks{}
+		w := NewWriterFunc(m.create)
+		w.Workers = workers
+		w.MaxBlockSize = 256 << 10
+		w.ChunkSize = 64 << 10
+		for _, f := range files {
+			if err := w.Add(f.Name, 1, 0, bytes.NewReader(f.Data)); err != nil {
+				t.Fatalf("add %q: %v", f.Name, err)
+			}
+		}
+		if err := w.Add(files[0].Name, 1, 0, bytes.NewReader(files[0].Data)); err == nil {
+			t.Errorf("expected error when adding an existing file")
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("write vpk: %v", err)
+		}
+		return m
+	}
+	exp, act := write(0), write(4)
+	if len(exp) != len(act) {
+		t.Fatalf("expected %d blocks, got %d", len(exp), len(act))
+	}
+	for i, b := range exp {
+		if !bytes.Equal(b.Bytes(), act[i].Bytes()) {
+			t.Errorf("block %s differs", i)
+		}
+	}
+
+	r, err := NewReaderFunc(act.open)
+	if err != nil {
+		t.Fatalf("read vpk: %v", err)
+	}
+	defer r.Close()
+	for _, f := range files {
+		if buf, err := fs.ReadFile(r, f.Name); err != nil {
+			t.Errorf("read %q: %v", f.Name, err)
+		} else if !bytes.Equal(buf, f.Data) {
+			t.Errorf("read %q: incorrect contents", f.Name)
+		}
+	}
+}
+
+type failWriter struct {
+	err error
+}
+
+func (w failWriter) Write(p []byte) (int, error) {
+	return 0, w.err
+}
+
+func TestWriterWorkersError(t *testing.T) {
+	errWrite := errors.New("write failed")
+	for _, workers := range []int{0, 4} {
+		w := NewWriterFunc(func(i ValvePakIndex) (io.Writer, error) {
+			return failWriter{errWrite}, nil
+		})
+		w.Workers = workers
+		w.Spool = MemorySpool
+		w.ChunkSize = 4096
+
+		var failed bool
+		for i := 0; i < 16; i++ {
+			err := w.Add(fmt.Sprintf("f%02d.txt", i), 1, 0, bytes.NewReader(bytes.Repeat([]byte{byte(i)}, 10000)))
+			if err != nil {
+				if !errors.Is(err, errWrite) {
+					t.Errorf("workers=%d: unexpected error %v", workers, err)
+				}
+				failed = true
+			} else if failed {
+				t.Errorf("workers=%d: add succeeded after an earlier failure", workers)
+			}
+		}
+		if err := w.Flush(); !errors.Is(err, errWrite) {
+			t.Errorf("workers=%d: expected flush to fail, got %v", workers, err)
+		}
+		if err := w.Close(); !errors.Is(err, errWrite) {
+			t.Errorf("workers=%d: expected close to fail, got %v", workers, err)
+		}
+	}
+}
+
+func TestWriterAlignment(t *testing.T) {
+	files := map[string][]byte{
+		"a.txt": bytes.Repeat([]byte("a"), 1000),
+		"b.bin": bytes.Repeat([]byte("tf2vpk"), 300000),
+		"c.png": []byte("not really a png"),
+		"d.txt": bytes.Repeat([]byte("a"), 1000), // same chunk as a.txt
+	}
+	for _, deterministic := range []bool{false, true} {
+		for _, index := range []ValvePakIndex{0, ValvePakIndexDir} {
+			m := memBlocks{}
+			w := NewWriterFunc(m.create)
+			w.Alignment = 4096
+			w.Deterministic = deterministic
+			w.Spool = MemorySpool
+			if err := w.SetBlock(index); err != nil {
+				t.Fatalf("set block: %v", err)
+			}
+			for _, name := range []string{"c.png", "b.bin", "a.txt", "d.txt"} {
+				if err := w.Add(name, 1, 0, bytes.NewReader(files[name])); err != nil {
+					t.Fatalf("add %q: %v", name, err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("write vpk: %v", err)
+			}
+
+			r, err := NewReaderFunc(m.open)
+			if err != nil {
+				t.Fatalf("read vpk: %v", err)
+			}
+			for _, f := range r.Root.File {
+				for i, c := range f.Chunk {
+					if c.Offset%4096 != 0 {
+						t.Errorf("deterministic=%t index=%s: %q: chunk %d: offset %d is not aligned", deterministic, index, f.Path, i, c.Offset)
+					}
+				}
+				if buf, err := fs.ReadFile(r, f.Path); err != nil {
+					t.Errorf("deterministic=%t index=%s: read %q: %v", deterministic, index, f.Path, err)
+				} else if !bytes.Equal(buf, files[f.Path]) {
+					t.Errorf("deterministic=%t index=%s: read %q: incorrect contents", deterministic, index, f.Path)
+				}
+			}
+			r.Close()
+		}
+	}
+}
+
+func TestWriterAlignmentMaxBlockSize(t *testing.T) {
+	var data []byte
+	for i := 0; len(data) < 20000; i++ {
+		data = fmt.Appendf(data, "line %d\n", i)
+	}
+	for _, raw := range []bool{false, true} {
+		m := memBlocks{}
+		w := NewWriterFunc(m.create)
+		w.Alignment = 4096
+		w.MaxBlockSize = 8192
+		w.Compression = StoreCompression
+		for i, n := range []int{5000, 100, 3000, 100, 100} {
+			name := fmt.Sprintf("f%d.txt", i)
+			var err error
+			if raw {
+				err = w.AddRaw(ValvePakFile{
+					Path:  name,
+					CRC32: crc32.ChecksumIEEE(data[:n]),
+					Chunk: []ValvePakChunk{{LoadFlags: 1, CompressedSize: uint64(n), UncompressedSize: uint64(n)}},
+				}, bytes.NewReader(data))
+			} else {
+				err = w.Add(name, 1, 0, bytes.NewReader(data[:n]))
+			}
+			if err != nil {
+				t.Fatalf("raw=%t: add %q: %v", raw, name, err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("raw=%t: write vpk: %v", raw, err)
+		}
+
+		// the first file fits, so the others shouldn't make the block exceed
+		// the limit after padding
+		files := map[ValvePakIndex]int{}
+		for _, f := range w.Root.File {
+			files[f.Index]++
+			if f.Chunk[0].Offset%4096 != 0 {
+				t.Errorf("raw=%t: %q: unaligned offset %d", raw, f.Path, f.Chunk[0].Offset)
+			}
+		}
+		for i, b := range m {
+			if i != ValvePakIndexDir && b.Len() > int(w.MaxBlockSize) && files[i] > 1 {
+				t.Errorf("raw=%t: block %s with %d files is larger than the maximum (%d bytes)", raw, i, files[i], b.Len())
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package tf2vpk
+
+import (
+	"io"
+	"io/fs"
+)
+
+// WriterFSMeta provides the metadata for files added using [Writer.AddFS].
+type WriterFSMeta interface {
+	// Skip returns true if the file at name should not be added. If it returns
+	// true for a directory, nothing inside it is added.
+	Skip(name string) bool
+
+	// Flags returns the load and texture flags for the file at name.
+	Flags(name string) (loadFlags uint32, textureFlags uint16)
+
+	// Chunks returns the chunks to split the file at name into, or false to
+	// split it normally using the flags. The size is the size of the file,
+	// which should be checked against the chunks.
+	Chunks(name string, size uint64) ([]WriterChunk, bool)
+}
+
+// AddFS adds all regular files in fsys, in name order, with paths relative to
+// the root of fsys. If meta is nil, all files are added with the default flags
+// (VISIBLE and CACHE). This can be used to pack embedded files, zip files, or
+// synthetic filesystems in addition to directories (see [os.DirFS]).
+func (w *Writer) AddFS(fsys fs.FS, meta WriterFSMeta) error {
+	return fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name != "." && meta != nil && meta.Skip(name) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		fi, err := fs.Stat(fsys, name) // follow symlinks if supported
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		return w.addFS(fsys, name, uint64(fi.Size()), meta)
+	})
+}
+
+func (w *Writer) addFS(fsys fs.FS, name string, size uint64, meta WriterFSMeta) error {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return w.AddMeta(name, size, f, meta)
+}
+
+// AddMeta adds a file of the specified size from r like [Writer.AddFS], using
+// the flags and chunks from meta, or the default flags (VISIBLE and CACHE) if
+// meta is nil. Skip is not checked.
+func (w *Writer) AddMeta(name string, size uint64, r io.Reader, meta WriterFSMeta) error {
+	if meta == nil {
+		return w.Add(name, uint32(ValvePakLoadVisible|ValvePakLoadCache), 0, r)
+	}
+	if chunks, ok := meta.Chunks(name, size); ok {
+		return w.AddChunks(name, chunks, r)
+	}
+	load, texture := meta.Flags(name)
+	return w.Add(name, load, texture, r)
+}
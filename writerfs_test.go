@@ -0,0 +1,69 @@
+package tf2vpk
+
+import (
+	"bytes"
+	"io/fs"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+type skipMeta struct{}
+
+func (skipMeta) Skip(name string) bool {
+	return strings.HasSuffix(name, ".bak")
+}
+
+func (skipMeta) Flags(name string) (uint32, uint16) {
+	return 1, 0
+}
+
+func (skipMeta) Chunks(name string, size uint64) ([]WriterChunk, bool) {
+	return nil, false
+}
+
+func TestWriterAddFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"scripts/a.nut":     {Data: []byte("a")},
+		"scripts/b.nut":     {Data: bytes.Repeat([]byte("b"), int(ValvePakMaxChunkUncompressedSize)+1)},
+		"scripts/b.nut.bak": {Data: []byte("old")},
+		"dir/x.txt":         {Data: []byte("x")},
+		"dir":               {Mode: fs.ModeDir},
+	}
+	for _, meta := range []WriterFSMeta{nil, skipMeta{}} {
+		m := memBlocks{}
+		w := NewWriterFunc(m.create)
+		if err := w.AddFS(fsys, meta); err != nil {
+			t.Fatalf("add fs: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("write vpk: %v", err)
+		}
+		r, err := NewReaderFunc(m.open)
+		if err != nil {
+			t.Fatalf("read vpk: %v", err)
+		}
+		var names []string
+		for _, f := range r.Root.File {
+			names = append(names, f.Path)
+			if buf, err := fs.ReadFile(r, f.Path); err != nil {
+				t.Errorf("read %q: %v", f.Path, err)
+			} else if !bytes.Equal(buf, fsys[f.Path].Data) {
+				t.Errorf("read %q: incorrect contents", f.Path)
+			}
+			if load, _ := f.LoadFlags(); meta != nil && load != 1 {
+				t.Errorf("file %q: expected load flags from meta", f.Path)
+			}
+		}
+		exp := "dir/x.txt scripts/a.nut scripts/b.nut scripts/b.nut.bak"
+		if meta != nil {
+			exp = "dir/x.txt scripts/a.nut scripts/b.nut"
+		}
+		sort.Strings(names)
+		if act := strings.Join(names, " "); act != exp {
+			t.Errorf("expected files %q, got %q", exp, act)
+		}
+		r.Close()
+	}
+}